@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.step.sm/crypto/tpm/storage"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "%s [options]\n", os.Args[0])
+	fmt.Fprintln(flag.CommandLine.Output(), "Copies the AKs, keys and sealed data from a TPM storage to another one.")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var fromFile, fromDir, toFile, toDir string
+	var help bool
+	flag.StringVar(&fromFile, "from-file", "", "The path of the source Filestore")
+	flag.StringVar(&fromDir, "from-dir", "", "The directory of the source Dirstore")
+	flag.StringVar(&toFile, "to-file", "", "The path of the destination Filestore")
+	flag.StringVar(&toDir, "to-dir", "", "The directory of the destination Dirstore")
+	flag.BoolVar(&help, "help", false, "Print the program usage")
+	flag.Parse()
+
+	switch {
+	case help:
+		usage()
+		os.Exit(0)
+	case len(flag.Args()) != 0:
+		usage()
+		os.Exit(1)
+	case (fromFile == "") == (fromDir == ""):
+		fmt.Fprintln(flag.CommandLine.Output(), "one of flag --from-file or --from-dir is required")
+		os.Exit(1)
+	case (toFile == "") == (toDir == ""):
+		fmt.Fprintln(flag.CommandLine.Output(), "one of flag --to-file or --to-dir is required")
+		os.Exit(1)
+	}
+
+	var src, dst storage.TPMStore
+	if fromFile != "" {
+		if _, err := os.Stat(fromFile); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+		src = storage.NewFilestore(fromFile)
+	} else {
+		if _, err := os.Stat(fromDir); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+		src = storage.NewDirstore(fromDir)
+	}
+	if toFile != "" {
+		dst = storage.NewFilestore(toFile)
+	} else {
+		dst = storage.NewDirstore(toDir)
+	}
+
+	if err := storage.Migrate(dst, src); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(3)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 
 	"go.step.sm/crypto/fingerprint"
+	"go.step.sm/crypto/rsapss"
 )
 
 // FingerprintEncoding defines the supported encodings in certificate
@@ -53,6 +54,10 @@ func Fingerprint(pub crypto.PublicKey) (string, error) {
 // The fingerprint is calculated from the encoding of the key according to RFC
 // 5280 section 4.2.1.2, but using SHA-256 instead of SHA-1.
 func EncodedFingerprint(pub crypto.PublicKey, encoding FingerprintEncoding) (string, error) {
+	// RSASSA-PSS keys share the subjectPublicKey encoding with RSA keys.
+	if k, ok := pub.(*rsapss.PublicKey); ok {
+		pub = k.PublicKey
+	}
 	b, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
 		return "", fmt.Errorf("error marshaling public key: %w", err)
@@ -10,10 +10,14 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"io"
 	"math/big"
+	"sync"
 	"sync/atomic"
 
 	"github.com/pkg/errors"
+	"go.step.sm/crypto/randutil"
+	"go.step.sm/crypto/rsapss"
 	"go.step.sm/crypto/x25519"
 	"golang.org/x/crypto/ssh"
 )
@@ -62,7 +66,9 @@ func PublicKey(priv interface{}) (crypto.PublicKey, error) {
 		return k.Public(), nil
 	case x25519.PrivateKey:
 		return k.Public(), nil
-	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, x25519.PublicKey:
+	case *rsapss.PrivateKey:
+		return k.Public(), nil
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, x25519.PublicKey, *rsapss.PublicKey:
 		return k, nil
 	case crypto.Signer:
 		return k.Public(), nil
@@ -84,6 +90,13 @@ func GenerateDefaultKeyPair() (crypto.PublicKey, crypto.PrivateKey, error) {
 }
 
 // GenerateKey generates a key of the given type (kty).
+//
+// Keys are generated using the reader set with randutil.SetReader. Since Go
+// 1.26, the standard library ignores custom readers when generating EC and
+// RSA keys, unless GODEBUG cryptocustomrand=1 is set, which is the
+// default for modules declaring a go version older than 1.26. In that case,
+// if a custom reader is set, GenerateKey returns an error instead of
+// generating the key with crypto/rand.
 func GenerateKey(kty, crv string, size int) (crypto.PrivateKey, error) {
 	switch kty {
 	case "EC", "RSA", "OKP":
@@ -133,7 +146,8 @@ func ExtractKey(in interface{}) (interface{}, error) {
 	case *rsa.PublicKey, *rsa.PrivateKey,
 		*ecdsa.PublicKey, *ecdsa.PrivateKey,
 		ed25519.PublicKey, ed25519.PrivateKey,
-		x25519.PublicKey, x25519.PrivateKey:
+		x25519.PublicKey, x25519.PrivateKey,
+		*rsapss.PublicKey, *rsapss.PrivateKey:
 		return in, nil
 	case []byte:
 		return in, nil
@@ -189,6 +203,12 @@ func Equal(x, y any) bool {
 	case x25519.PrivateKey:
 		yy, ok := y.(x25519.PrivateKey)
 		return ok && xx.Equal(yy)
+	case *rsapss.PublicKey:
+		yy, ok := y.(*rsapss.PublicKey)
+		return ok && xx.Equal(yy)
+	case *rsapss.PrivateKey:
+		yy, ok := y.(*rsapss.PrivateKey)
+		return ok && xx.Equal(yy)
 	case []byte: // special case for symmetric keys
 		yy, ok := y.([]byte)
 		return ok && bytes.Equal(xx, yy)
@@ -197,6 +217,32 @@ func Equal(x, y any) bool {
 	}
 }
 
+// ignoresCustomReader reports whether the standard library ignores custom
+// readers when generating keys. Since Go 1.26, they are only used if GODEBUG
+// cryptocustomrand=1 is set.
+var ignoresCustomReader = sync.OnceValue(func() bool {
+	_, err := ecdsa.GenerateKey(elliptic.P256(), errReader{})
+	return err == nil
+})
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// keyReader returns the reader used to generate EC and RSA keys. It
+// fails if a custom reader is set with randutil.SetReader, but the standard
+// library would ignore it.
+func keyReader() (io.Reader, error) {
+	r := randutil.Reader()
+	if r != rand.Reader && ignoresCustomReader() {
+		return nil, errors.New("error generating key: the reader set with randutil.SetReader is not supported; " +
+			"set GODEBUG cryptocustomrand=1 to use it")
+	}
+	return r, nil
+}
+
 func generateECKey(crv string) (crypto.Signer, error) {
 	var c elliptic.Curve
 	switch crv {
@@ -210,7 +256,11 @@ func generateECKey(crv string) (crypto.Signer, error) {
 		return nil, errors.Errorf("invalid value for argument crv (crv: '%s')", crv)
 	}
 
-	key, err := ecdsa.GenerateKey(c, rand.Reader)
+	r, err := keyReader()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(c, r)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating EC key")
 	}
@@ -223,7 +273,11 @@ func generateRSAKey(bits int) (crypto.Signer, error) {
 		return nil, errors.Errorf("the size of the RSA key should be at least %d bits", minBits)
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, bits)
+	r, err := keyReader()
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(r, bits)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating RSA key")
 	}
@@ -234,13 +288,13 @@ func generateRSAKey(bits int) (crypto.Signer, error) {
 func generateOKPKey(crv string) (crypto.Signer, error) {
 	switch crv {
 	case "Ed25519":
-		_, key, err := ed25519.GenerateKey(rand.Reader)
+		_, key, err := ed25519.GenerateKey(randutil.Reader())
 		if err != nil {
 			return nil, errors.Wrap(err, "error generating Ed25519 key")
 		}
 		return key, nil
 	case "X25519":
-		_, key, err := x25519.GenerateKey(rand.Reader)
+		_, key, err := x25519.GenerateKey(randutil.Reader())
 		if err != nil {
 			return nil, errors.Wrap(err, "error generating X25519 key")
 		}
@@ -255,7 +309,7 @@ func generateOctKey(size int) (interface{}, error) {
 	const chars = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	result := make([]byte, size)
 	for i := range result {
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		num, err := rand.Int(randutil.Reader(), big.NewInt(int64(len(chars))))
 		if err != nil {
 			return nil, err
 		}
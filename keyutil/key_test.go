@@ -10,12 +10,14 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io"
+	mathrand "math/rand/v2"
 	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
+	"go.step.sm/crypto/randutil"
 	"go.step.sm/crypto/x25519"
 )
 
@@ -370,6 +372,104 @@ func TestGenerateKey_rsa(t *testing.T) {
 	}
 }
 
+type failReader struct{}
+
+func (failReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestGenerateKey_randutilReader(t *testing.T) {
+	randutil.SetReader(failReader{})
+	t.Cleanup(func() {
+		randutil.SetReader(nil)
+	})
+
+	tests := []struct {
+		kty, crv string
+		size     int
+	}{
+		{"EC", "P-256", 0},
+		{"EC", "P-384", 0},
+		{"EC", "P-521", 0},
+		{"RSA", "", 2048},
+		{"OKP", "Ed25519", 0},
+		{"OKP", "X25519", 0},
+		{"oct", "", 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kty+tt.crv, func(t *testing.T) {
+			_, err := GenerateKey(tt.kty, tt.crv, tt.size)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestGenerateKey_ignoresCustomReader(t *testing.T) {
+	fn := ignoresCustomReader
+	ignoresCustomReader = func() bool { return true }
+	t.Cleanup(func() {
+		ignoresCustomReader = fn
+		randutil.SetReader(nil)
+	})
+
+	// The default reader is always supported.
+	for _, kty := range []string{"EC", "RSA", "OKP"} {
+		_, err := GenerateKey(kty, map[string]string{"EC": "P-256", "OKP": "Ed25519"}[kty], 2048)
+		require.NoError(t, err)
+	}
+
+	randutil.SetReader(mathrand.NewChaCha8([32]byte{}))
+	tests := []struct {
+		kty, crv string
+		size     int
+	}{
+		{"EC", "P-256", 0},
+		{"RSA", "", 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kty+tt.crv, func(t *testing.T) {
+			_, err := GenerateKey(tt.kty, tt.crv, tt.size)
+			require.ErrorContains(t, err, "cryptocustomrand")
+		})
+	}
+
+	// Ed25519, X25519 and oct keys always use the custom reader.
+	_, err := GenerateKey("OKP", "Ed25519", 0)
+	require.NoError(t, err)
+	_, err = GenerateKey("OKP", "X25519", 0)
+	require.NoError(t, err)
+	_, err = GenerateKey("oct", "", 32)
+	require.NoError(t, err)
+}
+
+func TestGenerateKey_deterministicReader(t *testing.T) {
+	t.Cleanup(func() {
+		randutil.SetReader(nil)
+	})
+	generate := func(t *testing.T, seed byte, kty, crv string) crypto.PrivateKey {
+		t.Helper()
+		randutil.SetReader(mathrand.NewChaCha8([32]byte{seed}))
+		key, err := GenerateKey(kty, crv, 0)
+		require.NoError(t, err)
+		return key
+	}
+
+	// EC keys are not deterministic, ecdsa.GenerateKey may read an extra byte.
+	tests := []struct {
+		kty, crv string
+	}{
+		{"OKP", "Ed25519"},
+		{"OKP", "X25519"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kty+tt.crv, func(t *testing.T) {
+			key := generate(t, 1, tt.kty, tt.crv)
+			require.Equal(t, key, generate(t, 1, tt.kty, tt.crv))
+			require.NotEqual(t, key, generate(t, 2, tt.kty, tt.crv))
+		})
+	}
+}
+
 func TestGenerateKeyPair(t *testing.T) {
 	cleanupRandReader(t)
 
@@ -0,0 +1,154 @@
+// Package attestation implements the verification of the key attestations
+// created by the KMS implementations in this module. It supports YubiKey PIV
+// attestation certificates, TPM 2.0 key certification parameters signed by an
+// attestation key (AK), and Cloud HSM attestation bundles from Google Cloud KMS.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+package attestation
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// Type is the type of device or service that created an attestation.
+type Type string
+
+const (
+	// YubiKey is the type used for YubiKey PIV attestations.
+	YubiKey Type = "yubikey"
+	// TPM is the type used for TPM 2.0 key attestations.
+	TPM Type = "tpm"
+	// CloudKMS is the type used for Google Cloud HSM attestations.
+	CloudKMS Type = "cloudkms"
+)
+
+// Result is the result of a successful verification.
+type Result struct {
+	// Type is the type of attestation verified.
+	Type Type
+	// PublicKey is the attested public key. It might be nil for symmetric
+	// keys or if the public key cannot be extracted from the attestation.
+	PublicKey crypto.PublicKey
+	// PermanentIdentifier is the identifier of the device that holds the key.
+	// On a YubiKey it is the serial number, on a TPM the EK URI in the AK
+	// certificate.
+	PermanentIdentifier string
+	// Chain is the verified certificate chain, from the leaf to the root. It
+	// is not set on Cloud KMS attestations, the certificates are available in
+	// the CloudKMS properties.
+	Chain []*x509.Certificate
+	// YubiKey contains the properties of a YubiKey attestation.
+	YubiKey *YubiKeyProperties
+	// TPM contains the properties of a TPM attestation.
+	TPM *TPMProperties
+	// CloudKMS contains the properties of a Cloud KMS attestation.
+	CloudKMS *CloudKMSProperties
+}
+
+type options struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	currentTime   time.Time
+}
+
+func (o *options) apply(opts []Option) *options {
+	for _, fn := range opts {
+		fn(o)
+	}
+	return o
+}
+
+// verifyOptions returns the x509.VerifyOptions used to verify the certificate
+// chains.
+func (o *options) verifyOptions(chain []*x509.Certificate) x509.VerifyOptions {
+	intermediates := x509.NewCertPool()
+	if o.intermediates != nil {
+		intermediates = o.intermediates.Clone()
+	}
+	for _, crt := range chain {
+		intermediates.AddCert(crt)
+	}
+	return x509.VerifyOptions{
+		Roots:         o.roots,
+		Intermediates: intermediates,
+		CurrentTime:   o.currentTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+}
+
+// Option is the type of the functional options used in the verification
+// methods.
+type Option func(o *options)
+
+// WithRoots sets the root certificates used to verify the certificate chains.
+// It replaces the roots bundled in this package.
+func WithRoots(roots *x509.CertPool) Option {
+	return func(o *options) {
+		o.roots = roots
+	}
+}
+
+// WithIntermediates sets a pool of intermediate certificates used to verify
+// the certificate chains in addition to the ones in the attestation.
+func WithIntermediates(intermediates *x509.CertPool) Option {
+	return func(o *options) {
+		o.intermediates = intermediates
+	}
+}
+
+// WithCurrentTime sets the time used to verify the validity of the
+// certificates. If it is not set, the current time will be used.
+func WithCurrentTime(t time.Time) Option {
+	return func(o *options) {
+		o.currentTime = t
+	}
+}
+
+// Verify verifies the attestation in a response of the CreateAttestation
+// method of a KMS. If the response contains certification parameters, it is
+// verified as a TPM key attestation, otherwise the certificate chain is
+// verified as a YubiKey attestation. The public key in the response must match
+// the attested key.
+func Verify(resp *apiv1.CreateAttestationResponse, opts ...Option) (*Result, error) {
+	if resp == nil {
+		return nil, errors.New("attestation response cannot be nil")
+	}
+
+	chain := resp.CertificateChain
+	if len(chain) == 0 && resp.Certificate != nil {
+		chain = []*x509.Certificate{resp.Certificate}
+	}
+
+	var (
+		res *Result
+		err error
+	)
+	if resp.CertificationParameters != nil {
+		res, err = VerifyTPM(resp.CertificationParameters, chain, opts...)
+	} else {
+		res, err = VerifyYubiKey(chain, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.PublicKey != nil {
+		if pub, ok := resp.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(res.PublicKey) {
+			return nil, errors.New("attestation public key does not match the attested key")
+		}
+	}
+	if resp.PermanentIdentifier != "" && resp.PermanentIdentifier != res.PermanentIdentifier {
+		return nil, fmt.Errorf("attestation permanent identifier %q does not match %q", resp.PermanentIdentifier, res.PermanentIdentifier)
+	}
+
+	return res, nil
+}
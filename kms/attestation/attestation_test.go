@@ -0,0 +1,88 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func TestVerify(t *testing.T) {
+	chain := mustReadChain(t, "testdata/yubikey.pem")
+
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	akRoots, akChain := mustAKChain(t, ak)
+	pub, key := mustTPMPublic(t, defaultKeyAttributes)
+	params := mustCertificationParameters(t, ak, pub, nil)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	type args struct {
+		resp *apiv1.CreateAttestationResponse
+		opts []Option
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantType  Type
+		wantKey   any
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok yubikey", args{&apiv1.CreateAttestationResponse{
+			Certificate:         chain[0],
+			CertificateChain:    chain,
+			PublicKey:           chain[0].PublicKey,
+			PermanentIdentifier: "15732500",
+		}, nil}, YubiKey, chain[0].PublicKey, assert.NoError},
+		{"ok yubikey no public key", args{&apiv1.CreateAttestationResponse{
+			CertificateChain: chain,
+		}, nil}, YubiKey, chain[0].PublicKey, assert.NoError},
+		{"ok tpm", args{&apiv1.CreateAttestationResponse{
+			Certificate:             akChain[0],
+			CertificateChain:        akChain,
+			PublicKey:               key,
+			CertificationParameters: params,
+			PermanentIdentifier:     "urn:ek:sha256:bm90LWEtcmVhbC1layBwdWJsaWMga2V5IGlk",
+		}, []Option{WithRoots(akRoots)}}, TPM, key, assert.NoError},
+		{"fail nil", args{nil, nil}, "", nil, assert.Error},
+		{"fail yubikey certificate", args{&apiv1.CreateAttestationResponse{
+			Certificate: chain[0],
+		}, nil}, "", nil, assert.Error},
+		{"fail tpm roots", args{&apiv1.CreateAttestationResponse{
+			CertificateChain:        akChain,
+			CertificationParameters: params,
+		}, nil}, "", nil, assert.Error},
+		{"fail public key", args{&apiv1.CreateAttestationResponse{
+			CertificateChain: chain,
+			PublicKey:        otherKey.Public(),
+		}, nil}, "", nil, assert.Error},
+		{"fail public key type", args{&apiv1.CreateAttestationResponse{
+			CertificateChain: chain,
+			PublicKey:        []byte("key"),
+		}, nil}, "", nil, assert.Error},
+		{"fail permanent identifier", args{&apiv1.CreateAttestationResponse{
+			CertificateChain:    chain,
+			PermanentIdentifier: "12345678",
+		}, nil}, "", nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.args.resp, tt.args.opts...)
+			tt.assertion(t, err)
+			if tt.wantType == "" {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, tt.wantType, got.Type)
+			assert.Equal(t, tt.wantKey, got.PublicKey)
+		})
+	}
+}
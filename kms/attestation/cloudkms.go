@@ -0,0 +1,720 @@
+package attestation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+
+	"go.step.sm/crypto/pemutil"
+)
+
+// Formats of the Cloud HSM attestations.
+const (
+	CloudKMSFormatCaviumV1 = "CAVIUM_V1_COMPRESSED"
+	CloudKMSFormatCaviumV2 = "CAVIUM_V2_COMPRESSED"
+)
+
+// Cloud HSM attestations uses only SHA256
+const attestationSignatureSize = 256
+
+// PKCS #11 attributes used in the Cloud HSM attestations.
+const (
+	ckaKeyType         = 0x0100
+	ckaModulus         = 0x0120
+	ckaModulusBits     = 0x0121
+	ckaPublicExponent  = 0x0122
+	ckaExtractable     = 0x0162
+	ckaLocal           = 0x0163
+	ckaECParams        = 0x0180
+	ckaECPoint         = 0x0181
+	ckkRSA             = 0x0000
+	ckkEC              = 0x0003
+	unknownKeyTypeCode = math.MaxUint32
+)
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// CloudKMSAttestation is the attestation of a key in Cloud HSM. It contains
+// the same data as the KeyOperationAttestation in the Cloud KMS API.
+type CloudKMSAttestation struct {
+	// Format is the format of the attestation, CloudKMSFormatCaviumV1 or
+	// CloudKMSFormatCaviumV2.
+	Format string
+	// Content is the gzip compressed attestation.
+	Content []byte
+	// Symmetric must be true if the attested key is a symmetric key. It is
+	// only required to parse the attestations using the version 1 format.
+	Symmetric bool
+	// CaviumCerts are the PEM encoded certificates issued by the
+	// manufacturer.
+	CaviumCerts []string
+	// GoogleCardCerts are the PEM encoded card certificates issued by Google.
+	GoogleCardCerts []string
+	// GooglePartitionCerts are the PEM encoded partition certificates issued
+	// by Google.
+	GooglePartitionCerts []string
+}
+
+// Attribute is a PKCS #11 attribute in a Cloud HSM attestation.
+type Attribute struct {
+	Type uint32
+	Data []byte
+}
+
+// String returns the attribute type and data in hexadecimal.
+func (v Attribute) String() string {
+	return fmt.Sprintf("0x%04x: b'%x'", v.Type, v.Data)
+}
+
+// CloudKMSProperties are the properties of a key attested by Cloud HSM.
+type CloudKMSProperties struct {
+	// Generated is true if the key was generated in the HSM.
+	Generated bool
+	// Extractable is true if the key can be extracted from the HSM.
+	Extractable bool
+	// KeyType is the PKCS #11 key type, e.g. "EC" or "RSA 2048".
+	KeyType string
+	// PublicKeyAttributes are the attributes of a public key.
+	PublicKeyAttributes []Attribute
+	// PrivateKeyAttributes are the attributes of a private key.
+	PrivateKeyAttributes []Attribute
+	// SymmetricKeyAttributes are the attributes of a symmetric key.
+	SymmetricKeyAttributes []Attribute
+	// ManufacturerCardCertificate is the card certificate issued by the
+	// manufacturer root.
+	ManufacturerCardCertificate *x509.Certificate
+	// ManufacturerPartitionCertificate is the partition certificate issued by
+	// the manufacturer card certificate.
+	ManufacturerPartitionCertificate *x509.Certificate
+	// OwnerCardCertificate is the card certificate issued by the owner root.
+	OwnerCardCertificate *x509.Certificate
+	// OwnerPartitionCertificate is the partition certificate issued by the
+	// owner root.
+	OwnerPartitionCertificate *x509.Certificate
+}
+
+// VerifyCloudKMSWithRoots verifies a Cloud HSM attestation using the given
+// manufacturer and owner roots. The signature of the attestation is verified
+// with the manufacturer and the owner partition certificates.
+//
+// The manufacturer root is required. No Marvell (Cavium) root is bundled in
+// this package, the root that issued the certificates of the existing
+// attestations expired on November 16, 2025. A nil owner root uses the bundled
+// Google Hawksbill root.
+func VerifyCloudKMSWithRoots(att *CloudKMSAttestation, mfrRoot, ownerRoot *x509.Certificate, opts ...Option) (*Result, error) {
+	if att == nil {
+		return nil, errors.New("cloudkms attestation cannot be nil")
+	}
+	switch att.Format {
+	case CloudKMSFormatCaviumV1, CloudKMSFormatCaviumV2:
+	default:
+		return nil, fmt.Errorf("attestation format %q is not supported", att.Format)
+	}
+
+	if mfrRoot == nil {
+		return nil, errors.New("cloudkms attestation manufacturer root is required")
+	}
+	if ownerRoot == nil {
+		var err error
+		if ownerRoot, err = readRoot("roots/google-hawksbill-root.pem"); err != nil {
+			return nil, err
+		}
+	}
+
+	o := new(options).apply(opts)
+
+	r, err := gzip.NewReader(bytes.NewReader(att.Content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	attestation, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation contents: %w", err)
+	}
+	// The attestation should be larger than 256, but this guarantees a not nil
+	// data and a signature of the proper size.
+	if len(attestation) < attestationSignatureSize {
+		return nil, errors.New("attestation content is too short")
+	}
+
+	// Validate and obtain manufacturer certificates
+	mfrCerts, err := parseCertificates(att.CaviumCerts)
+	if err != nil {
+		return nil, err
+	}
+	mfrCardCert, err := getIssuedCertificate(mfrRoot, mfrCerts, o)
+	if err != nil {
+		return nil, err
+	}
+	mfrPartitionCert, err := getIssuedCertificate(mfrCardCert, mfrCerts, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate and obtain owner card cert. The owner and manufacturer card
+	// certificate use the same key.
+	ownerCardCerts, err := parseCertificates(att.GoogleCardCerts)
+	if err != nil {
+		return nil, err
+	}
+	ownerCardCert, err := getIssuedCertificate(ownerRoot, ownerCardCerts, o, withSameKey(mfrCardCert))
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate and obtain owner partition certificate. The owner and
+	// manufacturer partition certificate use the same key.
+	ownerPartitionCerts, err := parseCertificates(att.GooglePartitionCerts)
+	if err != nil {
+		return nil, err
+	}
+	ownerPartitionCert, err := getIssuedCertificate(ownerRoot, ownerPartitionCerts, o, withSameKey(mfrPartitionCert))
+	if err != nil {
+		return nil, err
+	}
+
+	// Get attestation data and signature
+	offset := len(attestation) - attestationSignatureSize
+	data := attestation[:offset]
+	signature := attestation[offset:]
+
+	// Validate with manufacturer certificate
+	if err := verifySignature(mfrPartitionCert, data, signature); err != nil {
+		return nil, fmt.Errorf("error verifying certificate: %w", err)
+	}
+
+	// Validate with google certificate. This certificate and the manufacturer
+	// certificate use the same key.
+	if err := verifySignature(ownerPartitionCert, data, signature); err != nil {
+		return nil, fmt.Errorf("error verifying certificate: %w", err)
+	}
+
+	// Parse attestation attributes
+	pub, priv, err := ParseCloudKMSAttestation(att.Format, data, att.Symmetric)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing attestation data: %w", err)
+	}
+
+	var sym []Attribute
+	attributes := priv
+	if len(attributes) == 0 {
+		attributes = pub
+		if att.Symmetric {
+			sym = attributes
+			pub = nil
+		}
+	}
+
+	var keyType string
+	var keySize uint32
+	var extractable, generated bool
+	for _, v := range attributes {
+		switch v.Type {
+		case ckaKeyType:
+			keyType = getKeyType(decodeUint32(v.Data, unknownKeyTypeCode))
+		case ckaModulusBits:
+			keySize = decodeUint32(v.Data, 0)
+		case ckaExtractable:
+			extractable = bytes.Equal([]byte{0x01}, v.Data)
+		case ckaLocal:
+			generated = bytes.Equal([]byte{0x01}, v.Data)
+		}
+	}
+
+	if keyType == "RSA" && keySize > 0 {
+		keyType = "RSA " + strconv.FormatUint(uint64(keySize), 10)
+	}
+
+	return &Result{
+		Type:      CloudKMS,
+		PublicKey: publicKeyFromAttributes(pub),
+		CloudKMS: &CloudKMSProperties{
+			Generated:                        generated,
+			Extractable:                      extractable,
+			KeyType:                          keyType,
+			PublicKeyAttributes:              pub,
+			PrivateKeyAttributes:             priv,
+			SymmetricKeyAttributes:           sym,
+			ManufacturerCardCertificate:      mfrCardCert,
+			ManufacturerPartitionCertificate: mfrPartitionCert,
+			OwnerCardCertificate:             ownerCardCert,
+			OwnerPartitionCertificate:        ownerPartitionCert,
+		},
+	}, nil
+}
+
+// ParseCloudKMSAttestation parses the uncompressed data of a Cloud HSM
+// attestation without the signature, and returns the attributes of the public
+// and private keys. Symmetric key attestations only return the first list of
+// attributes. The symmetric parameter is only required to parse the
+// CloudKMSFormatCaviumV1 format.
+func ParseCloudKMSAttestation(format string, data []byte, symmetric bool) ([]Attribute, []Attribute, error) {
+	switch format {
+	case CloudKMSFormatCaviumV1:
+		return parseAttestationV1(data, symmetric)
+	case CloudKMSFormatCaviumV2:
+		return parseAttestation(data)
+	default:
+		return nil, nil, fmt.Errorf("attestation format %q is not supported", format)
+	}
+}
+
+func parseCertificates(pemCerts []string) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, len(pemCerts))
+	for i, s := range pemCerts {
+		crt, err := pemutil.ParseCertificate([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		certs[i] = crt
+	}
+	return certs, nil
+}
+
+// withSameKey returns a validator that checks that a certificate has the same
+// key as the given one.
+func withSameKey(crt *x509.Certificate) func(*x509.Certificate) bool {
+	return func(c *x509.Certificate) bool {
+		eq, ok := c.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		return ok && eq.Equal(crt.PublicKey)
+	}
+}
+
+func getIssuedCertificate(issuer *x509.Certificate, certs []*x509.Certificate, o *options, validators ...func(*x509.Certificate) bool) (*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	for _, crt := range certs {
+		if issuer.Equal(crt) {
+			continue
+		}
+		if _, err := crt.Verify(x509.VerifyOptions{
+			Roots:       roots,
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			CurrentTime: o.currentTime,
+		}); err != nil {
+			continue
+		}
+		if slices.ContainsFunc(validators, func(fn func(*x509.Certificate) bool) bool {
+			return !fn(crt)
+		}) {
+			continue
+		}
+		return crt, nil
+	}
+
+	return nil, errors.New("cannot find issued certificate")
+}
+
+// verifySignature verifies the signature of the given data. Cloud HSM always
+// uses RSA PKCS #1 v1.5 with SHA-256.
+func verifySignature(crt *x509.Certificate, data, signature []byte) error {
+	switch key := crt.PublicKey.(type) {
+	case *rsa.PublicKey:
+		hashed := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature)
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// publicKeyFromAttributes returns the RSA or EC public key in the given
+// attributes, or nil if they do not contain one.
+func publicKeyFromAttributes(attrs []Attribute) crypto.PublicKey {
+	var keyType uint32 = unknownKeyTypeCode
+	var modulus, exponent, ecParams, ecPoint []byte
+	for _, v := range attrs {
+		switch v.Type {
+		case ckaKeyType:
+			keyType = decodeUint32(v.Data, unknownKeyTypeCode)
+		case ckaModulus:
+			modulus = v.Data
+		case ckaPublicExponent:
+			exponent = v.Data
+		case ckaECParams:
+			ecParams = v.Data
+		case ckaECPoint:
+			ecPoint = v.Data
+		}
+	}
+
+	switch keyType {
+	case ckkRSA:
+		e := new(big.Int).SetBytes(exponent)
+		if len(modulus) == 0 || !e.IsInt64() || e.Int64() > math.MaxInt32 {
+			return nil
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(e.Int64()),
+		}
+	case ckkEC:
+		// Cloud HSM does not set CKA_EC_PARAMS and CKA_EC_POINT, it encodes
+		// the uncompressed point in CKA_MODULUS.
+		point := modulus
+		if len(ecPoint) > 0 {
+			// CKA_EC_POINT is a DER encoded OCTET STRING with the uncompressed
+			// point. A raw point can also parse as an OCTET STRING, so the
+			// whole attribute must be consumed.
+			var der []byte
+			if rest, err := asn1.Unmarshal(ecPoint, &der); err == nil && len(rest) == 0 && len(der) > 0 && der[0] == 0x04 {
+				point = der
+			} else {
+				point = ecPoint
+			}
+		}
+		curve := curveFromParams(ecParams)
+		if curve == nil {
+			curve = curveFromPoint(point)
+		}
+		if curve == nil {
+			return nil
+		}
+		return newECDSAPublicKey(curve, point)
+	default:
+		return nil
+	}
+}
+
+func curveFromParams(params []byte) elliptic.Curve {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil
+	}
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		return elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		return elliptic.P384()
+	case oid.Equal(oidNamedCurveP521):
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// newECDSAPublicKey returns the ECDSA public key for the given uncompressed
+// point, or nil if the point is not valid.
+func newECDSAPublicKey(curve elliptic.Curve, point []byte) *ecdsa.PublicKey {
+	var c ecdh.Curve
+	switch curve {
+	case elliptic.P256():
+		c = ecdh.P256()
+	case elliptic.P384():
+		c = ecdh.P384()
+	case elliptic.P521():
+		c = ecdh.P521()
+	default:
+		return nil
+	}
+	if _, err := c.NewPublicKey(point); err != nil {
+		return nil
+	}
+	size := (len(point) - 1) / 2
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(point[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(point[1+size:]),
+	}
+}
+
+// curveFromPoint returns the curve of an uncompressed point using its size.
+func curveFromPoint(point []byte) elliptic.Curve {
+	switch len(point) {
+	case 65:
+		return elliptic.P256()
+	case 97:
+		return elliptic.P384()
+	case 133:
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// parseAttestation parses attestation data using the Version 2 format. This
+// code is based on the code of parse_v2.py from
+// https://www.marvell.com/products/security-solutions/nitrox-hs-adapters/software-key-attestation.html
+func parseAttestation(data []byte) ([]Attribute, []Attribute, error) {
+	// Parse response header
+	responseHeader := [4]uint32{}
+	if err := decode(data, &responseHeader, 4*4); err != nil {
+		return nil, nil, err
+	}
+	attributeOffset := responseHeader[2] - (responseHeader[3] + 256)
+	if int(attributeOffset) > len(data) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	attestData := data[attributeOffset:]
+
+	// Parse info header
+	infoHeader := [4]uint16{}
+	if err := decode(attestData, &infoHeader, 4*2); err != nil {
+		return nil, nil, err
+	}
+
+	offset1 := infoHeader[2] // public key offset
+	offset2 := infoHeader[3] // private key offset
+	if int(offset1) > len(attestData) || int(offset2) > len(attestData) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	// Parse public key
+	pubAttributes, err := parse(attestData[offset1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	// Symmetric key attestation
+	if offset2 == 0 {
+		return pubAttributes, nil, nil
+	}
+
+	// Parse private key
+	privAttributes, err := parse(attestData[offset2:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pubAttributes, privAttributes, nil
+}
+
+func parse(data []byte) ([]Attribute, error) {
+	objectHeader := [3]uint32{}
+	if err := decode(data, &objectHeader, 3*4); err != nil {
+		return nil, err
+	}
+
+	attributes, err := parseAttributes(data[12:], int(objectHeader[1]))
+	if err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// parseAttestationV1 parses attestation data using the Version 1 format. This
+// code is based on the code of parse_v1.py and verify_attest.py from
+// https://www.marvell.com/products/security-solutions/nitrox-hs-adapters/software-key-attestation.html
+//
+// Note that this format has not been tested with real attestation, only
+// generated ones using the tests and then verified using Marvell's parse_v1.py
+// and verify_attest.py. Before using verify_attest.py we need to uncompress the
+// attestation file.
+func parseAttestationV1(data []byte, isSymmetricKey bool) ([]Attribute, []Attribute, error) {
+	// Asymmetric key attestation objects start after 984 bytes.
+	const caviumAttestationAsymOffset = 984
+	// Symmetric key attestation objects start after 24 bytes.
+	const caviumAttestationSymOffset = 24
+
+	if isSymmetricKey {
+		if len(data) < caviumAttestationSymOffset {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		attributes, _, err := parseV1(data[caviumAttestationSymOffset:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return attributes, nil, nil
+	}
+
+	if len(data) < caviumAttestationAsymOffset {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	pubAttributes, offset, err := parseV1(data[caviumAttestationAsymOffset:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if caviumAttestationAsymOffset+offset > len(data) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	privAttributes, _, err := parseV1(data[caviumAttestationAsymOffset+offset:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pubAttributes, privAttributes, nil
+}
+
+func parseV1(data []byte) ([]Attribute, int, error) {
+	header := [3]uint32{}
+	if err := decode(data, &header, 3*4); err != nil {
+		return nil, 0, err
+	}
+	const objectHeaderSize = 12
+	objectSize := int(header[2])
+
+	attributes, err := parseAttributes(data[objectHeaderSize:], int(header[1]))
+	if err != nil {
+		return nil, 0, err
+	}
+	return attributes, objectHeaderSize + objectSize, nil
+}
+
+// parseAttributes parses count type-length-value attributes and returns them
+// sorted by type.
+func parseAttributes(attestData []byte, count int) ([]Attribute, error) {
+	attributes := make([]Attribute, 0, min(count, len(attestData)/8))
+	tlv := [2]uint32{}
+	for range count {
+		if err := decode(attestData, &tlv, 2*4); err != nil {
+			return nil, err
+		}
+		attestData = attestData[8:]
+		if uint64(tlv[1]) > uint64(len(attestData)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		attributes = append(attributes, Attribute{
+			Type: tlv[0],
+			Data: attestData[:tlv[1]],
+		})
+		attestData = attestData[tlv[1]:]
+	}
+
+	slices.SortFunc(attributes, func(a, b Attribute) int {
+		switch {
+		case a.Type < b.Type:
+			return -1
+		case a.Type > b.Type:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return attributes, nil
+}
+
+func decode(data []byte, dest any, wantSize int) error {
+	size, err := binary.Decode(data, binary.BigEndian, dest)
+	if err != nil {
+		return err
+	}
+	if size != wantSize {
+		return io.EOF
+	}
+	return nil
+}
+
+func decodeUint32(data []byte, defValue uint32) uint32 {
+	switch len(data) {
+	case 1:
+		return uint32(data[0])
+	case 2:
+		return uint32(binary.BigEndian.Uint16(data))
+	case 4:
+		return binary.BigEndian.Uint32(data)
+	default:
+		return defValue
+	}
+}
+
+// getKeyType returns string version for a given CKK_* key type documented in
+// the PCKS #11 standard. Not all of them are supported by Cloud HSM,
+func getKeyType(v uint32) string {
+	switch v {
+	case 0x0000:
+		return "RSA"
+	case 0x0001:
+		return "DSA"
+	case 0x0002:
+		return "DH"
+	case 0x0003:
+		return "EC"
+	case 0x0004:
+		return "X9_42_DH"
+	case 0x0005:
+		return "KEA"
+	case 0x0010:
+		return "GENERIC_SECRET"
+	case 0x0011:
+		return "RC2"
+	case 0x0012:
+		return "RC4"
+	case 0x0013:
+		return "DES"
+	case 0x0014:
+		return "DES2"
+	case 0x0015:
+		return "DES3"
+	case 0x0016:
+		return "CAST"
+	case 0x0017:
+		return "CAST3"
+	case 0x0018:
+		return "CAST128"
+	case 0x0019:
+		return "RC5"
+	case 0x001A:
+		return "IDEA"
+	case 0x001B:
+		return "SKIPJACK"
+	case 0x001C:
+		return "BATON"
+	case 0x001D:
+		return "JUNIPER"
+	case 0x001E:
+		return "CDMF"
+	case 0x001F:
+		return "AES"
+	case 0x0020:
+		return "BLOWFISH"
+	case 0x0021:
+		return "TWOFISH"
+	case 0x0022:
+		return "SECURID"
+	case 0x0023:
+		return "HOTP"
+	case 0x0024:
+		return "ACTI"
+	case 0x0025:
+		return "CAMELLIA"
+	case 0x0026:
+		return "ARIA"
+	case 0x0027:
+		return "MD5_HMAC"
+	case 0x0028:
+		return "SHA_1_HMAC"
+	case 0x0029:
+		return "RIPEMD128_HMAC"
+	case 0x002A:
+		return "RIPEMD160_HMAC"
+	case 0x002B:
+		return "SHA256_HMAC"
+	case 0x002C:
+		return "SHA384_HMAC"
+	case 0x002D:
+		return "SHA512_HMAC"
+	case 0x002E:
+		return "SHA224_HMAC"
+	case 0x002F:
+		return "SEED"
+	case 0x0030:
+		return "GOSTR3410"
+	case 0x0031:
+		return "GOSTR3411"
+	case 0x0032:
+		return "GOST28147"
+	case 0x80000000:
+		return "VENDOR_DEFINED"
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,491 @@
+package attestation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/pemutil"
+)
+
+func mustCloudKMSAttestation(t *testing.T, typ string) *CloudKMSAttestation {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("..", "cloudkms", "testdata", typ+".dat"))
+	require.NoError(t, err)
+	certs, err := pemutil.ReadCertificateBundle(filepath.Join("..", "cloudkms", "testdata", typ+".certs"))
+	require.NoError(t, err)
+
+	pemData := make([]string, len(certs))
+	for i, crt := range certs {
+		pemData[i] = string(pem.EncodeToMemory(&pem.Block{
+			Type: "CERTIFICATE", Bytes: crt.Raw,
+		}))
+	}
+
+	return &CloudKMSAttestation{
+		Format:               CloudKMSFormatCaviumV2,
+		Content:              content,
+		Symmetric:            typ == "aes",
+		CaviumCerts:          []string{pemData[1], pemData[2]},
+		GoogleCardCerts:      []string{pemData[4]},
+		GooglePartitionCerts: []string{pemData[5]},
+	}
+}
+
+func mustCloudKMSAttributes(t *testing.T, att *CloudKMSAttestation) ([]Attribute, []Attribute) {
+	t.Helper()
+
+	r, err := gzip.NewReader(bytes.NewReader(att.Content))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	pub, priv, err := ParseCloudKMSAttestation(att.Format, b[:len(b)-256], false)
+	require.NoError(t, err)
+	return pub, priv
+}
+
+func mustGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestVerifyCloudKMSWithRoots(t *testing.T) {
+	currentTime := WithCurrentTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	ecAtt := mustCloudKMSAttestation(t, "ec")
+	ecPub, ecPriv := mustCloudKMSAttributes(t, ecAtt)
+	rsaAtt := mustCloudKMSAttestation(t, "rsa")
+	rsaPub, rsaPriv := mustCloudKMSAttributes(t, rsaAtt)
+	aesAtt := mustCloudKMSAttestation(t, "aes")
+	aesSym, _ := mustCloudKMSAttributes(t, aesAtt)
+
+	mfrRoot, err := pemutil.ReadCertificate("testdata/cavium-root.pem")
+	require.NoError(t, err)
+	ownerRoot, err := readRoot("roots/google-hawksbill-root.pem")
+	require.NoError(t, err)
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	withAttestation := func(fn func(att *CloudKMSAttestation)) *CloudKMSAttestation {
+		att := mustCloudKMSAttestation(t, "ec")
+		fn(att)
+		return att
+	}
+	garbage := make([]byte, 1000)
+	_, err = rand.Read(garbage)
+	require.NoError(t, err)
+
+	type args struct {
+		att                *CloudKMSAttestation
+		mfrRoot, ownerRoot *x509.Certificate
+		opts               []Option
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *CloudKMSProperties
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok ec", args{ecAtt, mfrRoot, nil, []Option{currentTime}}, &CloudKMSProperties{
+			Generated:            true,
+			KeyType:              "EC",
+			PublicKeyAttributes:  ecPub,
+			PrivateKeyAttributes: ecPriv,
+		}, assert.NoError},
+		{"ok rsa", args{rsaAtt, mfrRoot, nil, []Option{currentTime}}, &CloudKMSProperties{
+			Generated:            true,
+			KeyType:              "RSA 2048",
+			PublicKeyAttributes:  rsaPub,
+			PrivateKeyAttributes: rsaPriv,
+		}, assert.NoError},
+		{"ok aes", args{aesAtt, mfrRoot, nil, []Option{currentTime}}, &CloudKMSProperties{
+			Generated:              true,
+			KeyType:                "AES",
+			SymmetricKeyAttributes: aesSym,
+		}, assert.NoError},
+		{"ok with roots", args{ecAtt, mfrRoot, ownerRoot, []Option{currentTime}}, &CloudKMSProperties{
+			Generated:            true,
+			KeyType:              "EC",
+			PublicKeyAttributes:  ecPub,
+			PrivateKeyAttributes: ecPriv,
+		}, assert.NoError},
+		{"fail nil", args{nil, mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail format", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.Format = "ATTESTATION_FORMAT_UNSPECIFIED"
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail without manufacturer root", args{ecAtt, nil, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail without manufacturer root with owner root", args{ecAtt, nil, ownerRoot, []Option{currentTime}}, nil, assert.Error},
+		{"fail expired manufacturer root", args{ecAtt, mfrRoot, nil, nil}, nil, assert.Error},
+		{"fail current time", args{ecAtt, mfrRoot, nil, []Option{WithCurrentTime(time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))}}, nil, assert.Error},
+		{"fail manufacturer root", args{ecAtt, ca.Root, ownerRoot, []Option{currentTime}}, nil, assert.Error},
+		{"fail owner root", args{ecAtt, mfrRoot, ca.Root, []Option{currentTime}}, nil, assert.Error},
+		{"fail manufacturer root only", args{ecAtt, ca.Root, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail owner card certificate", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.GoogleCardCerts = att.GooglePartitionCerts
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail owner partition certificate", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.GooglePartitionCerts = rsaAtt.GooglePartitionCerts
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail certificate", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.CaviumCerts = []string{"not a certificate"}
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail signature", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.Content = rsaAtt.Content
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail gzip", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.Content = []byte("garbage")
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail too short", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.Content = mustGzip(t, make([]byte, 255))
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+		{"fail garbage", args{withAttestation(func(att *CloudKMSAttestation) {
+			att.Content = mustGzip(t, garbage)
+		}), mfrRoot, nil, []Option{currentTime}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyCloudKMSWithRoots(tt.args.att, tt.args.mfrRoot, tt.args.ownerRoot, tt.args.opts...)
+			tt.assertion(t, err)
+			if tt.want == nil {
+				assert.Nil(t, got)
+				return
+			}
+
+			require.NotNil(t, got)
+			assert.Equal(t, CloudKMS, got.Type)
+			switch {
+			case tt.want.KeyType == "EC":
+				assert.IsType(t, &ecdsa.PublicKey{}, got.PublicKey)
+			case tt.want.KeyType == "RSA 2048":
+				assert.IsType(t, &rsa.PublicKey{}, got.PublicKey)
+			default:
+				assert.Nil(t, got.PublicKey)
+			}
+			if assert.NotNil(t, got.CloudKMS) {
+				props := got.CloudKMS
+				assert.Equal(t, mfrRoot.Subject.String(), props.ManufacturerCardCertificate.Issuer.String())
+				assert.NotNil(t, props.ManufacturerPartitionCertificate)
+				assert.True(t, props.OwnerCardCertificate.PublicKey.(*rsa.PublicKey).Equal(props.ManufacturerCardCertificate.PublicKey))
+				assert.True(t, props.OwnerPartitionCertificate.PublicKey.(*rsa.PublicKey).Equal(props.ManufacturerPartitionCertificate.PublicKey))
+				props.ManufacturerCardCertificate = nil
+				props.ManufacturerPartitionCertificate = nil
+				props.OwnerCardCertificate = nil
+				props.OwnerPartitionCertificate = nil
+				assert.Equal(t, tt.want, props)
+			}
+		})
+	}
+}
+
+func TestParseCloudKMSAttestation(t *testing.T) {
+	att := mustCloudKMSAttestation(t, "ec")
+	r, err := gzip.NewReader(bytes.NewReader(att.Content))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	data := b[:len(b)-256]
+
+	type args struct {
+		format    string
+		data      []byte
+		symmetric bool
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantPub   bool
+		wantPriv  bool
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{CloudKMSFormatCaviumV2, data, false}, true, true, assert.NoError},
+		{"fail format", args{"CAVIUM_V3_COMPRESSED", data, false}, false, false, assert.Error},
+		{"fail short", args{CloudKMSFormatCaviumV2, data[:8], false}, false, false, assert.Error},
+		{"fail truncated", args{CloudKMSFormatCaviumV2, data[:len(data)/2], false}, false, false, assert.Error},
+		{"fail v1 symmetric", args{CloudKMSFormatCaviumV1, data[:16], true}, false, false, assert.Error},
+		{"fail v1 asymmetric", args{CloudKMSFormatCaviumV1, data[:512], false}, false, false, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, priv, err := ParseCloudKMSAttestation(tt.args.format, tt.args.data, tt.args.symmetric)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.wantPub, len(pub) > 0)
+			assert.Equal(t, tt.wantPriv, len(priv) > 0)
+		})
+	}
+}
+
+func TestAttribute_String(t *testing.T) {
+	assert.Equal(t, "0x0100: b'03'", Attribute{Type: 0x0100, Data: []byte{0x03}}.String())
+	assert.Equal(t, "0x80000002: b''", Attribute{Type: 0x80000002}.String())
+}
+
+func Test_publicKeyFromAttributes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ecPoint := func(k *ecdsa.PublicKey) []byte {
+		size := (k.Curve.Params().BitSize + 7) / 8
+		b := []byte{0x04}
+		b = append(b, k.X.FillBytes(make([]byte, size))...)
+		return append(b, k.Y.FillBytes(make([]byte, size))...)
+	}
+
+	// use a P-384 key whose raw point starts like a DER encoded OCTET STRING
+	var p384 *ecdsa.PrivateKey
+	for p384 == nil || ecPoint(&p384.PublicKey)[1] >= 0x60 {
+		p384, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+	}
+	ecParams, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	require.NoError(t, err)
+	unknownParams, err := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 3, 4})
+	require.NoError(t, err)
+	encodedPoint, err := asn1.Marshal(ecPoint(&p384.PublicKey))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		attrs []Attribute
+		want  any
+	}{
+		{"rsa", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x00}},
+			{Type: ckaModulus, Data: rsaKey.N.Bytes()},
+			{Type: ckaPublicExponent, Data: []byte{0x01, 0x00, 0x01}},
+		}, &rsaKey.PublicKey},
+		{"ec modulus", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaModulus, Data: ecPoint(&p256.PublicKey)},
+		}, &p256.PublicKey},
+		{"ec point", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaECParams, Data: ecParams},
+			{Type: ckaECPoint, Data: encodedPoint},
+		}, &p384.PublicKey},
+		{"ec raw point", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaECPoint, Data: ecPoint(&p384.PublicKey)},
+		}, &p384.PublicKey},
+		{"rsa no modulus", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x00}},
+			{Type: ckaPublicExponent, Data: []byte{0x01, 0x00, 0x01}},
+		}, nil},
+		{"rsa large exponent", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x00}},
+			{Type: ckaModulus, Data: rsaKey.N.Bytes()},
+			{Type: ckaPublicExponent, Data: []byte{0x01, 0x00, 0x00, 0x00, 0x01}},
+		}, nil},
+		{"ec unknown curve", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaModulus, Data: []byte{0x04, 0x01, 0x02}},
+		}, nil},
+		{"ec unknown params", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaECParams, Data: unknownParams},
+			{Type: ckaECPoint, Data: []byte{0x04, 0x01, 0x02}},
+		}, nil},
+		{"ec wrong curve", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaECParams, Data: ecParams},
+			{Type: ckaModulus, Data: ecPoint(&p256.PublicKey)},
+		}, nil},
+		{"ec invalid point", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x03}},
+			{Type: ckaModulus, Data: append([]byte{0x04}, make([]byte, 64)...)},
+		}, nil},
+		{"aes", []Attribute{
+			{Type: ckaKeyType, Data: []byte{0x1f}},
+		}, nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := publicKeyFromAttributes(tt.attrs)
+			if tt.want == nil {
+				assert.Nil(t, got)
+			} else {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+func Test_decode(t *testing.T) {
+	type args struct {
+		data     []byte
+		dest     any
+		wantSize int
+	}
+	tests := []struct {
+		name      string
+		args      args
+		assert    func(*testing.T, any) bool
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{[]byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x03, 0x02, 0x01}, &[2]uint32{}, 8}, func(t *testing.T, v any) bool {
+			vv, ok := v.(*[2]uint32)
+			return assert.True(t, ok) && assert.Equal(t, [2]uint32{0x1020304, 0x4030201}, *vv)
+		}, assert.NoError},
+		{"fail buff", args{[]byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x03, 0x02, 0x01}, &[1]uint32{}, 8}, func(t *testing.T, v any) bool {
+			vv, ok := v.(*[1]uint32)
+			return assert.True(t, ok) && assert.Equal(t, [1]uint32{0x1020304}, *vv)
+		}, assert.Error},
+		{"fail size", args{[]byte{0x01, 0x02, 0x03, 0x04}, &[2]uint32{}, 8}, func(t *testing.T, v any) bool {
+			vv, ok := v.(*[2]uint32)
+			return assert.True(t, ok) && assert.Equal(t, [2]uint32{0x00, 0x00}, *vv)
+		}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.assertion(t, decode(tt.args.data, tt.args.dest, tt.args.wantSize))
+			tt.assert(t, tt.args.dest)
+		})
+	}
+}
+
+func Test_getKeyType(t *testing.T) {
+	tests := []struct {
+		name string
+		v    uint32
+		want string
+	}{
+		{"RSA", 0x0000, "RSA"},
+		{"DSA", 0x0001, "DSA"},
+		{"DH", 0x0002, "DH"},
+		{"EC", 0x0003, "EC"},
+		{"X9_42_DH", 0x0004, "X9_42_DH"},
+		{"KEA", 0x0005, "KEA"},
+		{"GENERIC_SECRET", 0x0010, "GENERIC_SECRET"},
+		{"RC2", 0x0011, "RC2"},
+		{"RC4", 0x0012, "RC4"},
+		{"DES", 0x0013, "DES"},
+		{"DES2", 0x0014, "DES2"},
+		{"DES3", 0x0015, "DES3"},
+		{"CAST", 0x0016, "CAST"},
+		{"CAST3", 0x0017, "CAST3"},
+		{"CAST128", 0x0018, "CAST128"},
+		{"RC5", 0x0019, "RC5"},
+		{"IDEA", 0x001A, "IDEA"},
+		{"SKIPJACK", 0x001B, "SKIPJACK"},
+		{"BATON", 0x001C, "BATON"},
+		{"JUNIPER", 0x001D, "JUNIPER"},
+		{"CDMF", 0x001E, "CDMF"},
+		{"AES", 0x001F, "AES"},
+		{"BLOWFISH", 0x0020, "BLOWFISH"},
+		{"TWOFISH", 0x0021, "TWOFISH"},
+		{"SECURID", 0x0022, "SECURID"},
+		{"HOTP", 0x0023, "HOTP"},
+		{"ACTI", 0x0024, "ACTI"},
+		{"CAMELLIA", 0x0025, "CAMELLIA"},
+		{"ARIA", 0x0026, "ARIA"},
+		{"MD5_HMAC", 0x0027, "MD5_HMAC"},
+		{"SHA_1_HMAC", 0x0028, "SHA_1_HMAC"},
+		{"RIPEMD128_HMAC", 0x0029, "RIPEMD128_HMAC"},
+		{"RIPEMD160_HMAC", 0x002A, "RIPEMD160_HMAC"},
+		{"SHA256_HMAC", 0x002B, "SHA256_HMAC"},
+		{"SHA384_HMAC", 0x002C, "SHA384_HMAC"},
+		{"SHA512_HMAC", 0x002D, "SHA512_HMAC"},
+		{"SHA224_HMAC", 0x002E, "SHA224_HMAC"},
+		{"SEED", 0x002F, "SEED"},
+		{"GOSTR3410", 0x0030, "GOSTR3410"},
+		{"GOSTR3411", 0x0031, "GOSTR3411"},
+		{"GOST28147", 0x0032, "GOST28147"},
+		{"VENDOR_DEFINED", 0x80000000, "VENDOR_DEFINED"},
+		{"UNKNOWN", 0x33, "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getKeyType(tt.v))
+		})
+	}
+}
+
+func TestVerifyCloudKMSWithRoots_currentTime(t *testing.T) {
+	// Sign the attestation data of a real attestation with a partition key
+	// certified by current manufacturer and owner roots.
+	att := mustCloudKMSAttestation(t, "ec")
+	pub, priv := mustCloudKMSAttributes(t, att)
+	r, err := gzip.NewReader(bytes.NewReader(att.Content))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	data := b[:len(b)-attestationSignatureSize]
+
+	partitionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	sum := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, partitionKey, crypto.SHA256, sum[:])
+	require.NoError(t, err)
+
+	// The manufacturer intermediate is the card certificate, and the owner
+	// intermediate is used as the owner root.
+	mfr, err := minica.New(minica.WithName("Manufacturer"))
+	require.NoError(t, err)
+	owner, err := minica.New(minica.WithName("Owner"))
+	require.NoError(t, err)
+	mfrPartition, err := mfr.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Manufacturer Partition"},
+		PublicKey: partitionKey.Public(),
+	})
+	require.NoError(t, err)
+	ownerCard, err := owner.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Owner Card"},
+		PublicKey: mfr.Intermediate.PublicKey,
+	})
+	require.NoError(t, err)
+	ownerPartition, err := owner.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Owner Partition"},
+		PublicKey: partitionKey.Public(),
+	})
+	require.NoError(t, err)
+
+	encode := func(crt *x509.Certificate) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}))
+	}
+	att = &CloudKMSAttestation{
+		Format:               CloudKMSFormatCaviumV2,
+		Content:              mustGzip(t, append(data, sig...)),
+		CaviumCerts:          []string{encode(mfr.Intermediate), encode(mfrPartition)},
+		GoogleCardCerts:      []string{encode(ownerCard)},
+		GooglePartitionCerts: []string{encode(ownerPartition)},
+	}
+
+	got, err := VerifyCloudKMSWithRoots(att, mfr.Root, owner.Intermediate)
+	require.NoError(t, err)
+	assert.Equal(t, CloudKMS, got.Type)
+	assert.Equal(t, pub, got.CloudKMS.PublicKeyAttributes)
+	assert.Equal(t, priv, got.CloudKMS.PrivateKeyAttributes)
+	assert.Equal(t, mfrPartition, got.CloudKMS.ManufacturerPartitionCertificate)
+	assert.Equal(t, ownerPartition, got.CloudKMS.OwnerPartitionCertificate)
+
+	// The manufacturer root is required.
+	_, err = VerifyCloudKMSWithRoots(att, nil, owner.Intermediate)
+	assert.ErrorContains(t, err, "manufacturer root is required")
+}
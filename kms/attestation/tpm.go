@@ -0,0 +1,227 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// TPMProperties are the properties of a key attested by a TPM.
+type TPMProperties struct {
+	// Public is the TPMT_PUBLIC structure of the attested key.
+	Public []byte
+	// Name is the TPM name of the attested key.
+	Name []byte
+	// Attributes are the object attributes of the attested key.
+	Attributes uint32
+	// QualifyingData is the data provided by the caller when the key was
+	// certified.
+	QualifyingData []byte
+	// FirmwareVersion is the firmware version of the TPM.
+	FirmwareVersion uint64
+	// Creation is true if the evidence was created with TPM2_CertifyCreation
+	// and the creation data was verified. If false, the evidence was created
+	// with TPM2_Certify.
+	Creation bool
+}
+
+// VerifyTPM verifies the certification parameters of a TPM key using the
+// attestation key (AK) certificate chain. The first certificate in the chain
+// must be the AK certificate. There are no bundled roots for AK certificates,
+// so the roots must be set using WithRoots.
+//
+// The attested key must have been created by the TPM, it must not be
+// duplicable, and it must not be a restricted key.
+func VerifyTPM(params *apiv1.CertificationParameters, akChain []*x509.Certificate, opts ...Option) (*Result, error) {
+	switch {
+	case params == nil:
+		return nil, errors.New("tpm attestation requires the certification parameters")
+	case len(akChain) == 0:
+		return nil, errors.New("tpm attestation requires the AK certificate")
+	}
+
+	o := new(options).apply(opts)
+	if o.roots == nil {
+		return nil, errors.New("tpm attestation requires the roots used to verify the AK certificate")
+	}
+
+	akCert := akChain[0]
+	chains, err := akCert.Verify(o.verifyOptions(akChain[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying AK certificate: %w", err)
+	}
+
+	pub, props, err := verifyCertification(params, akCert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Type:                TPM,
+		PublicKey:           pub,
+		PermanentIdentifier: ekURI(akCert),
+		Chain:               chains[0],
+		TPM:                 props,
+	}, nil
+}
+
+// verifyCertification verifies the certification parameters of a key using the
+// given AK public key.
+func verifyCertification(params *apiv1.CertificationParameters, akPub crypto.PublicKey) (crypto.PublicKey, *TPMProperties, error) {
+	pub, err := tpm2.DecodePublic(params.Public)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding public key: %w", err)
+	}
+	att, err := tpm2.DecodeAttestationData(params.CreateAttestation)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding attestation data: %w", err)
+	}
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(params.CreateSignature))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding signature: %w", err)
+	}
+
+	// Verify the signature first, the rest of the attestation data cannot be
+	// trusted without it.
+	if err := verifyTPMSignature(akPub, params.CreateAttestation, sig); err != nil {
+		return nil, nil, err
+	}
+
+	var name tpm2.Name
+	var creation bool
+	switch att.Type {
+	case tpm2.TagAttestCertify:
+		name = att.AttestedCertifyInfo.Name
+	case tpm2.TagAttestCreation:
+		name = att.AttestedCreationInfo.Name
+		creation = true
+		if err := verifyCreationHash(pub, params.CreateData, att.AttestedCreationInfo.OpaqueDigest); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported attestation type 0x%x", att.Type)
+	}
+
+	match, err := name.MatchesPublic(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error verifying key name: %w", err)
+	}
+	if !match {
+		return nil, nil, errors.New("attestation refers to a different key")
+	}
+
+	switch {
+	case pub.Attributes&tpm2.FlagFixedTPM == 0:
+		return nil, nil, errors.New("attested key is not fixed to the TPM")
+	case pub.Attributes&tpm2.FlagFixedParent == 0:
+		return nil, nil, errors.New("attested key can be duplicated to a different parent")
+	case pub.Attributes&tpm2.FlagSensitiveDataOrigin == 0:
+		return nil, nil, errors.New("attested key was not generated by the TPM")
+	case pub.Attributes&tpm2.FlagRestricted != 0:
+		return nil, nil, errors.New("attested key is restricted")
+	}
+
+	key, err := pub.Key()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding public key: %w", err)
+	}
+	n, err := name.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding key name: %w", err)
+	}
+
+	return key, &TPMProperties{
+		Public:          params.Public,
+		Name:            n,
+		Attributes:      uint32(pub.Attributes),
+		QualifyingData:  att.ExtraData,
+		FirmwareVersion: att.FirmwareVersion,
+		Creation:        creation,
+	}, nil
+}
+
+// verifyTPMSignature verifies a TPMT_SIGNATURE over the given data.
+func verifyTPMSignature(pub crypto.PublicKey, data []byte, sig *tpm2.Signature) error {
+	var hashAlg tpm2.Algorithm
+	switch {
+	case sig.RSA != nil:
+		hashAlg = sig.RSA.HashAlg
+	case sig.ECC != nil:
+		hashAlg = sig.ECC.HashAlg
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", sig.Alg)
+	}
+	h, err := hashAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("unsupported signature hash: %w", err)
+	}
+	hh := h.New()
+	hh.Write(data)
+	digest := hh.Sum(nil)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch sig.Alg {
+		case tpm2.AlgRSASSA:
+			err = rsa.VerifyPKCS1v15(k, h, digest, sig.RSA.Signature)
+		case tpm2.AlgRSAPSS:
+			err = rsa.VerifyPSS(k, h, digest, sig.RSA.Signature, &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthAuto,
+			})
+		default:
+			return fmt.Errorf("unsupported signature algorithm %s for an RSA key", sig.Alg)
+		}
+	case *ecdsa.PublicKey:
+		if sig.Alg != tpm2.AlgECDSA {
+			return fmt.Errorf("unsupported signature algorithm %s for an ECDSA key", sig.Alg)
+		}
+		if !ecdsa.Verify(k, digest, sig.ECC.R, sig.ECC.S) {
+			err = errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported AK public key type %T", pub)
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying attestation signature: %w", err)
+	}
+	return nil
+}
+
+// verifyCreationHash verifies that the hash of the creation data matches the
+// one in a TPMS_CREATION_INFO.
+func verifyCreationHash(pub tpm2.Public, createData, creationHash []byte) error {
+	if len(createData) == 0 {
+		return errors.New("attestation requires the creation data")
+	}
+	h, err := pub.NameAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("unsupported name algorithm: %w", err)
+	}
+	hh := h.New()
+	hh.Write(createData)
+	if subtle.ConstantTimeCompare(hh.Sum(nil), creationHash) != 1 {
+		return errors.New("creation data does not match the attestation")
+	}
+	return nil
+}
+
+// ekURI returns the EK URI in the AK certificate. The Smallstep Attestation CA
+// encodes the EK public key ID as a URN in the AK certificate.
+func ekURI(akCert *x509.Certificate) string {
+	for _, u := range akCert.URIs {
+		if u.Scheme == "urn" && strings.HasPrefix(u.Opaque, "ek:") {
+			return u.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/minica"
+)
+
+const defaultKeyAttributes = tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+	tpm2.FlagUserWithAuth | tpm2.FlagSign
+
+func mustTPMPublic(t *testing.T, attrs tpm2.KeyProp) (tpm2.Public, *ecdsa.PublicKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: attrs,
+		ECCParameters: &tpm2.ECCParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+			CurveID: tpm2.CurveNISTP256,
+			Point: tpm2.ECPoint{
+				XRaw: key.X.FillBytes(make([]byte, 32)),
+				YRaw: key.Y.FillBytes(make([]byte, 32)),
+			},
+		},
+	}, &key.PublicKey
+}
+
+// mustCertificationParameters creates the certification parameters of a key
+// signed by the given attestation key. If createData is not nil, the
+// parameters will look like the ones generated by TPM2_CertifyCreation.
+func mustCertificationParameters(t *testing.T, ak crypto.Signer, pub tpm2.Public, createData []byte) *apiv1.CertificationParameters {
+	t.Helper()
+
+	public, err := pub.Encode()
+	require.NoError(t, err)
+	name, err := pub.Name()
+	require.NoError(t, err)
+
+	att := tpm2.AttestationData{
+		Magic:           0xff544347,
+		ExtraData:       []byte("qualifying-data"),
+		FirmwareVersion: 0x1234,
+	}
+	if createData != nil {
+		sum := sha256.Sum256(createData)
+		att.Type = tpm2.TagAttestCreation
+		att.AttestedCreationInfo = &tpm2.CreationInfo{
+			Name:         name,
+			OpaqueDigest: sum[:],
+		}
+	} else {
+		att.Type = tpm2.TagAttestCertify
+		att.AttestedCertifyInfo = &tpm2.CertifyInfo{
+			Name:          name,
+			QualifiedName: name,
+		}
+	}
+	data, err := att.Encode()
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(data)
+	var sig tpm2.Signature
+	switch k := ak.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		sig = tpm2.Signature{
+			Alg: tpm2.AlgRSASSA,
+			RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: s},
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = tpm2.Signature{
+			Alg: tpm2.AlgECDSA,
+			ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s},
+		}
+	default:
+		t.Fatalf("unsupported key %T", ak)
+	}
+	signature, err := sig.Encode()
+	require.NoError(t, err)
+
+	return &apiv1.CertificationParameters{
+		Public:            public,
+		CreateData:        createData,
+		CreateAttestation: data,
+		CreateSignature:   signature,
+	}
+}
+
+func mustAKChain(t *testing.T, ak crypto.Signer) (*x509.CertPool, []*x509.Certificate) {
+	t.Helper()
+
+	ca, err := minica.New()
+	require.NoError(t, err)
+	crt, err := ca.Sign(&x509.Certificate{
+		Subject:      pkix.Name{CommonName: "AK"},
+		PublicKey:    ak.Public(),
+		SerialNumber: big.NewInt(1),
+		URIs: []*url.URL{
+			{Scheme: "https", Host: "example.com"},
+			{Scheme: "urn", Opaque: "ek:sha256:bm90LWEtcmVhbC1layBwdWJsaWMga2V5IGlk"},
+		},
+	})
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Root)
+	return roots, []*x509.Certificate{crt, ca.Intermediate}
+}
+
+func TestVerifyTPM(t *testing.T) {
+	rsaAK, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecAK, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaRoots, rsaChain := mustAKChain(t, rsaAK)
+	ecRoots, ecChain := mustAKChain(t, ecAK)
+
+	pub, key := mustTPMPublic(t, defaultKeyAttributes)
+	public, err := pub.Encode()
+	require.NoError(t, err)
+	name, err := pub.Name()
+	require.NoError(t, err)
+	encodedName, err := name.Encode()
+	require.NoError(t, err)
+
+	certifyParams := mustCertificationParameters(t, rsaAK, pub, nil)
+	creationParams := mustCertificationParameters(t, ecAK, pub, []byte("creation-data"))
+
+	// Invalid parameters
+	badCreationParams := *creationParams
+	badCreationParams.CreateData = []byte("other-data")
+	noCreationParams := *creationParams
+	noCreationParams.CreateData = nil
+	otherPub, _ := mustTPMPublic(t, defaultKeyAttributes)
+	otherPublic, err := otherPub.Encode()
+	require.NoError(t, err)
+	otherKeyParams := *certifyParams
+	otherKeyParams.Public = otherPublic
+	badSignatureParams := *certifyParams
+	badSignatureParams.CreateAttestation = append([]byte{}, certifyParams.CreateAttestation...)
+	badSignatureParams.CreateAttestation[len(badSignatureParams.CreateAttestation)-1]++
+
+	mustParams := func(attrs tpm2.KeyProp) *apiv1.CertificationParameters {
+		pub, _ := mustTPMPublic(t, attrs)
+		return mustCertificationParameters(t, rsaAK, pub, nil)
+	}
+
+	type args struct {
+		params  *apiv1.CertificationParameters
+		akChain []*x509.Certificate
+		opts    []Option
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *Result
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok certify", args{certifyParams, rsaChain, []Option{WithRoots(rsaRoots)}}, &Result{
+			Type:                TPM,
+			PublicKey:           key,
+			PermanentIdentifier: "urn:ek:sha256:bm90LWEtcmVhbC1layBwdWJsaWMga2V5IGlk",
+			TPM: &TPMProperties{
+				Public:          public,
+				Name:            encodedName,
+				Attributes:      uint32(defaultKeyAttributes),
+				QualifyingData:  []byte("qualifying-data"),
+				FirmwareVersion: 0x1234,
+			},
+		}, assert.NoError},
+		{"ok creation", args{creationParams, ecChain, []Option{WithRoots(ecRoots)}}, &Result{
+			Type:                TPM,
+			PublicKey:           key,
+			PermanentIdentifier: "urn:ek:sha256:bm90LWEtcmVhbC1layBwdWJsaWMga2V5IGlk",
+			TPM: &TPMProperties{
+				Public:          public,
+				Name:            encodedName,
+				Attributes:      uint32(defaultKeyAttributes),
+				QualifyingData:  []byte("qualifying-data"),
+				FirmwareVersion: 0x1234,
+				Creation:        true,
+			},
+		}, assert.NoError},
+		{"fail params", args{nil, rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail chain", args{certifyParams, nil, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail no roots", args{certifyParams, rsaChain, nil}, nil, assert.Error},
+		{"fail roots", args{certifyParams, rsaChain, []Option{WithRoots(ecRoots)}}, nil, assert.Error},
+		{"fail ak", args{certifyParams, ecChain, []Option{WithRoots(ecRoots)}}, nil, assert.Error},
+		{"fail signature", args{&badSignatureParams, rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail other key", args{&otherKeyParams, rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail creation data", args{&badCreationParams, ecChain, []Option{WithRoots(ecRoots)}}, nil, assert.Error},
+		{"fail no creation data", args{&noCreationParams, ecChain, []Option{WithRoots(ecRoots)}}, nil, assert.Error},
+		{"fail fixedTPM", args{mustParams(defaultKeyAttributes &^ tpm2.FlagFixedTPM), rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail fixedParent", args{mustParams(defaultKeyAttributes &^ tpm2.FlagFixedParent), rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail sensitiveDataOrigin", args{mustParams(defaultKeyAttributes &^ tpm2.FlagSensitiveDataOrigin), rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail restricted", args{mustParams(defaultKeyAttributes | tpm2.FlagRestricted), rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail public", args{&apiv1.CertificationParameters{Public: []byte("foo")}, rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+		{"fail attestation", args{&apiv1.CertificationParameters{Public: public, CreateAttestation: []byte("foo")}, rsaChain, []Option{WithRoots(rsaRoots)}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyTPM(tt.args.params, tt.args.akChain, tt.args.opts...)
+			tt.assertion(t, err)
+			if tt.want != nil && assert.NotNil(t, got) {
+				assert.Len(t, got.Chain, 3)
+				assert.Equal(t, tt.args.akChain[0], got.Chain[0])
+				got.Chain = nil
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_verifyTPMSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	data := []byte("data")
+	digest := sha256.Sum256(data)
+	pss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthEqualsHash,
+	})
+	require.NoError(t, err)
+	pkcs1, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+
+	type args struct {
+		pub  crypto.PublicKey
+		data []byte
+		sig  *tpm2.Signature
+	}
+	tests := []struct {
+		name      string
+		args      args
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok rsassa", args{rsaKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pkcs1}}}, assert.NoError},
+		{"ok rsapss", args{rsaKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgRSAPSS, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pss}}}, assert.NoError},
+		{"ok ecdsa", args{ecKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s}}}, assert.NoError},
+		{"fail rsassa", args{rsaKey.Public(), []byte("other"), &tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pkcs1}}}, assert.Error},
+		{"fail rsapss", args{rsaKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgRSAPSS, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pkcs1}}}, assert.Error},
+		{"fail ecdsa", args{ecKey.Public(), []byte("other"), &tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s}}}, assert.Error},
+		{"fail rsa algorithm", args{rsaKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgECDSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pkcs1}}}, assert.Error},
+		{"fail ecdsa algorithm", args{ecKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgECDAA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s}}}, assert.Error},
+		{"fail hash", args{ecKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgNull, R: r, S: s}}}, assert.Error},
+		{"fail signature", args{ecKey.Public(), data, &tpm2.Signature{Alg: tpm2.AlgHMAC}}, assert.Error},
+		{"fail key", args{[]byte("key"), data, &tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: pkcs1}}}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.assertion(t, verifyTPMSignature(tt.args.pub, tt.args.data, tt.args.sig))
+		})
+	}
+}
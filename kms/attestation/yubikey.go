@@ -0,0 +1,290 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"embed"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.step.sm/crypto/pemutil"
+)
+
+//go:embed roots/*.pem
+var rootsFS embed.FS
+
+// Yubico extensions in the attestation certificates.
+//
+// https://developers.yubico.com/PIV/Introduction/PIV_attestation.html
+var (
+	oidYubicoFirmwareVersion = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 3}
+	oidYubicoSerialNumber    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 7}
+	oidYubicoPolicy          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 8}
+	oidYubicoFormFactor      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 9}
+	oidYubicoFIPS            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 41482, 3, 10}
+)
+
+// yubikeySubjectPrefix is the prefix in the common name of the attestation
+// certificates, the slot follows it.
+const yubikeySubjectPrefix = "YubiKey PIV Attestation "
+
+// Version is the firmware version of a YubiKey.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// String returns the version in the format major.minor.patch.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// PINPolicy is the PIN policy of a key in a YubiKey.
+type PINPolicy int
+
+// PIN policies supported by YubiKeys.
+const (
+	PINPolicyNever PINPolicy = iota + 1
+	PINPolicyOnce
+	PINPolicyAlways
+)
+
+// String returns a string representation of the PIN policy.
+func (p PINPolicy) String() string {
+	switch p {
+	case PINPolicyNever:
+		return "never"
+	case PINPolicyOnce:
+		return "once"
+	case PINPolicyAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// TouchPolicy is the touch policy of a key in a YubiKey.
+type TouchPolicy int
+
+// Touch policies supported by YubiKeys.
+const (
+	TouchPolicyNever TouchPolicy = iota + 1
+	TouchPolicyAlways
+	TouchPolicyCached
+)
+
+// String returns a string representation of the touch policy.
+func (p TouchPolicy) String() string {
+	switch p {
+	case TouchPolicyNever:
+		return "never"
+	case TouchPolicyAlways:
+		return "always"
+	case TouchPolicyCached:
+		return "cached"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+// YubiKeyProperties are the properties of a key attested by a YubiKey.
+type YubiKeyProperties struct {
+	// Version is the firmware version of the YubiKey.
+	Version Version
+	// Serial is the serial number of the YubiKey, 0 if it is not present.
+	Serial uint32
+	// FormFactor is the form factor of the YubiKey, 0 if it is not present.
+	// See https://developers.yubico.com/yubikey-manager/Config_Reference.html#_form_factor
+	FormFactor int
+	// FIPS is true if the YubiKey is a FIPS device.
+	FIPS bool
+	// PINPolicy is the PIN policy of the key.
+	PINPolicy PINPolicy
+	// TouchPolicy is the touch policy of the key.
+	TouchPolicy TouchPolicy
+	// Slot is the slot of the key in hexadecimal, e.g. "9a". It is empty if
+	// the slot cannot be determined.
+	Slot string
+}
+
+var (
+	yubicoOnce          sync.Once
+	yubicoRoots         *x509.CertPool
+	yubicoIntermediates *x509.CertPool
+	yubicoErr           error
+)
+
+// loadYubicoCAs returns the bundled Yubico roots and intermediates.
+func loadYubicoCAs() (*x509.CertPool, *x509.CertPool, error) {
+	yubicoOnce.Do(func() {
+		roots := x509.NewCertPool()
+		for _, name := range []string{"roots/yubico-piv-ca.pem", "roots/yubico-ca-1.pem"} {
+			crt, err := readRoot(name)
+			if err != nil {
+				yubicoErr = err
+				return
+			}
+			roots.AddCert(crt)
+		}
+
+		// Some YubiKeys were certified using the U2F root. This root has the
+		// path length set to 0, so the device attestation certificate is
+		// verified as the leaf of its own chain.
+		u2f, err := readRoot("roots/yubico-u2f-ca.pem")
+		if err != nil {
+			yubicoErr = err
+			return
+		}
+		roots.AddCert(u2f)
+
+		b, err := rootsFS.ReadFile("roots/yubico-intermediate.pem")
+		if err != nil {
+			yubicoErr = err
+			return
+		}
+		intermediates := x509.NewCertPool()
+		if !intermediates.AppendCertsFromPEM(b) {
+			yubicoErr = errors.New("error parsing yubico intermediates")
+			return
+		}
+
+		yubicoRoots, yubicoIntermediates = roots, intermediates
+	})
+	return yubicoRoots, yubicoIntermediates, yubicoErr
+}
+
+// VerifyYubiKey verifies a YubiKey PIV attestation. The first certificate in
+// the chain must be the attestation certificate of the slot, and the second
+// one the device attestation certificate stored in the slot f9. By default,
+// the chain is verified using the Yubico roots bundled in this package.
+func VerifyYubiKey(chain []*x509.Certificate, opts ...Option) (*Result, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("yubikey attestation requires the slot and the device attestation certificates")
+	}
+
+	o := new(options).apply(opts)
+	if o.roots == nil {
+		roots, intermediates, err := loadYubicoCAs()
+		if err != nil {
+			return nil, fmt.Errorf("error loading yubico roots: %w", err)
+		}
+		o.roots = roots
+		if o.intermediates == nil {
+			o.intermediates = intermediates
+		}
+	}
+
+	// The device attestation certificate in some YubiKey 4 does not have the
+	// basic constraints extension. A copy is used to not modify the given one.
+	leaf := chain[0]
+	deviceCert := *chain[1]
+	if !deviceCert.BasicConstraintsValid {
+		deviceCert.BasicConstraintsValid = true
+		deviceCert.IsCA = true
+	}
+
+	// The device attestation certificate is verified against the roots, and
+	// then the slot attestation certificate against the device one, so the
+	// chain always goes through it. Verifying them in one chain would fail
+	// with the U2F root, that has the path length set to 0.
+	deviceChains, err := deviceCert.Verify(o.verifyOptions(chain[2:]))
+	if err != nil {
+		return nil, fmt.Errorf("error verifying yubikey device attestation: %w", err)
+	}
+	device := x509.NewCertPool()
+	device.AddCert(&deviceCert)
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:       device,
+		CurrentTime: o.currentTime,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("error verifying yubikey attestation: %w", err)
+	}
+
+	props, err := parseYubiKeyProperties(leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Old firmware versions do not include the serial number.
+	var permanentIdentifier string
+	if props.Serial != 0 {
+		permanentIdentifier = strconv.FormatUint(uint64(props.Serial), 10)
+	}
+
+	return &Result{
+		Type:                YubiKey,
+		PublicKey:           leaf.PublicKey,
+		PermanentIdentifier: permanentIdentifier,
+		Chain:               append([]*x509.Certificate{leaf}, deviceChains[0]...),
+		YubiKey:             props,
+	}, nil
+}
+
+// parseYubiKeyProperties parses the Yubico extensions in the attestation
+// certificate of a slot.
+func parseYubiKeyProperties(crt *x509.Certificate) (*YubiKeyProperties, error) {
+	var props YubiKeyProperties
+	for _, ext := range crt.Extensions {
+		switch {
+		case ext.Id.Equal(oidYubicoFirmwareVersion):
+			if len(ext.Value) != 3 {
+				return nil, fmt.Errorf("error parsing yubikey firmware version: expected 3 bytes, got %d", len(ext.Value))
+			}
+			props.Version = Version{
+				Major: int(ext.Value[0]),
+				Minor: int(ext.Value[1]),
+				Patch: int(ext.Value[2]),
+			}
+		case ext.Id.Equal(oidYubicoSerialNumber):
+			var serial int64
+			if rest, err := asn1.Unmarshal(ext.Value, &serial); err != nil {
+				return nil, fmt.Errorf("error parsing yubikey serial number: %w", err)
+			} else if len(rest) > 0 {
+				return nil, errors.New("error parsing yubikey serial number: trailing data")
+			}
+			if serial < 0 || serial > 0xffffffff {
+				return nil, fmt.Errorf("error parsing yubikey serial number: invalid value %d", serial)
+			}
+			props.Serial = uint32(serial)
+		case ext.Id.Equal(oidYubicoPolicy):
+			if len(ext.Value) != 2 {
+				return nil, fmt.Errorf("error parsing yubikey policy: expected 2 bytes, got %d", len(ext.Value))
+			}
+			props.PINPolicy = PINPolicy(ext.Value[0])
+			props.TouchPolicy = TouchPolicy(ext.Value[1])
+		case ext.Id.Equal(oidYubicoFormFactor):
+			if len(ext.Value) != 1 {
+				return nil, fmt.Errorf("error parsing yubikey form factor: expected 1 byte, got %d", len(ext.Value))
+			}
+			props.FormFactor = int(ext.Value[0])
+		case ext.Id.Equal(oidYubicoFIPS):
+			props.FIPS = true
+		}
+	}
+
+	if slot, ok := strings.CutPrefix(crt.Subject.CommonName, yubikeySubjectPrefix); ok {
+		if _, err := strconv.ParseUint(slot, 16, 8); err == nil {
+			props.Slot = strings.ToLower(slot)
+		}
+	}
+
+	return &props, nil
+}
+
+// readRoot reads a bundled root certificate.
+func readRoot(name string) (*x509.Certificate, error) {
+	b, err := rootsFS.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	crt, err := pemutil.ParseCertificate(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", name, err)
+	}
+	return crt, nil
+}
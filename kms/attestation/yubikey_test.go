@@ -0,0 +1,218 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/pemutil"
+)
+
+func mustReadChain(t *testing.T, filename string) []*x509.Certificate {
+	t.Helper()
+	certs, err := pemutil.ReadCertificateBundle(filename)
+	require.NoError(t, err)
+	return certs
+}
+
+func mustSerialExtension(t *testing.T, serial int64) pkix.Extension {
+	t.Helper()
+	b, err := asn1.Marshal(serial)
+	require.NoError(t, err)
+	return pkix.Extension{Id: oidYubicoSerialNumber, Value: b}
+}
+
+// mustYubiKeyChain creates a chain that looks like a YubiKey attestation with
+// the given extensions. It returns the root pool, the chain and the attested
+// key.
+func mustYubiKeyChain(t *testing.T, cn string, extensions ...pkix.Extension) (*x509.CertPool, []*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	ca, err := minica.New()
+	require.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	crt, err := ca.Sign(&x509.Certificate{
+		Subject:         pkix.Name{CommonName: cn},
+		PublicKey:       key.Public(),
+		SerialNumber:    big.NewInt(1),
+		ExtraExtensions: extensions,
+	})
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Root)
+	return roots, []*x509.Certificate{crt, ca.Intermediate}, key
+}
+
+func TestVerifyYubiKey(t *testing.T) {
+	chain := mustReadChain(t, "testdata/yubikey.pem")
+	chain2018 := mustReadChain(t, "testdata/yubikey-2018.pem")
+
+	roots, fakeChain, fakeKey := mustYubiKeyChain(t, "YubiKey PIV Attestation 9C",
+		pkix.Extension{Id: oidYubicoFirmwareVersion, Value: []byte{5, 7, 1}},
+		mustSerialExtension(t, 12345678),
+		pkix.Extension{Id: oidYubicoPolicy, Value: []byte{3, 3}},
+		pkix.Extension{Id: oidYubicoFormFactor, Value: []byte{0x81}},
+		pkix.Extension{Id: oidYubicoFIPS, Value: []byte{}},
+	)
+	otherRoots, otherChain, _ := mustYubiKeyChain(t, "YubiKey PIV Attestation 9a")
+
+	type args struct {
+		chain []*x509.Certificate
+		opts  []Option
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *Result
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{chain, nil}, &Result{
+			Type:                YubiKey,
+			PublicKey:           chain[0].PublicKey,
+			PermanentIdentifier: "15732500",
+			YubiKey: &YubiKeyProperties{
+				Version:     Version{5, 4, 3},
+				Serial:      15732500,
+				FormFactor:  4,
+				PINPolicy:   PINPolicyOnce,
+				TouchPolicy: TouchPolicyNever,
+				Slot:        "9a",
+			},
+		}, assert.NoError},
+		{"ok 2018", args{chain2018, nil}, &Result{
+			Type:      YubiKey,
+			PublicKey: chain2018[0].PublicKey,
+			YubiKey: &YubiKeyProperties{
+				Version:     Version{4, 3, 7},
+				PINPolicy:   PINPolicyOnce,
+				TouchPolicy: TouchPolicyNever,
+				Slot:        "9a",
+			},
+		}, assert.NoError},
+		{"ok with roots", args{fakeChain, []Option{WithRoots(roots)}}, &Result{
+			Type:                YubiKey,
+			PublicKey:           fakeKey.Public(),
+			PermanentIdentifier: "12345678",
+			YubiKey: &YubiKeyProperties{
+				Version:     Version{5, 7, 1},
+				Serial:      12345678,
+				FormFactor:  0x81,
+				FIPS:        true,
+				PINPolicy:   PINPolicyAlways,
+				TouchPolicy: TouchPolicyCached,
+				Slot:        "9c",
+			},
+		}, assert.NoError},
+		{"ok with current time", args{chain, []Option{WithCurrentTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))}}, &Result{
+			Type:                YubiKey,
+			PublicKey:           chain[0].PublicKey,
+			PermanentIdentifier: "15732500",
+			YubiKey: &YubiKeyProperties{
+				Version:     Version{5, 4, 3},
+				Serial:      15732500,
+				FormFactor:  4,
+				PINPolicy:   PINPolicyOnce,
+				TouchPolicy: TouchPolicyNever,
+				Slot:        "9a",
+			},
+		}, assert.NoError},
+		{"ok no extensions", args{otherChain, []Option{WithRoots(otherRoots)}}, &Result{
+			Type:      YubiKey,
+			PublicKey: otherChain[0].PublicKey,
+			YubiKey: &YubiKeyProperties{
+				Slot: "9a",
+			},
+		}, assert.NoError},
+		{"fail chain", args{chain[:1], nil}, nil, assert.Error},
+		{"fail mixed chain", args{[]*x509.Certificate{chain[0], chain2018[1]}, nil}, nil, assert.Error},
+		{"fail device certificate", args{[]*x509.Certificate{chain[0], chain2018[1], chain[1]}, nil}, nil, assert.Error},
+		{"fail bundled roots", args{fakeChain, nil}, nil, assert.Error},
+		{"fail roots", args{fakeChain, []Option{WithRoots(otherRoots)}}, nil, assert.Error},
+		{"fail current time", args{chain, []Option{WithCurrentTime(time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC))}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyYubiKey(tt.args.chain, tt.args.opts...)
+			tt.assertion(t, err)
+			if tt.want != nil && assert.NotNil(t, got) {
+				assert.NotEmpty(t, got.Chain)
+				assert.Equal(t, tt.args.chain[0], got.Chain[0])
+				assert.True(t, tt.args.chain[1].Equal(got.Chain[1]))
+				root := got.Chain[len(got.Chain)-1]
+				assert.NoError(t, root.CheckSignatureFrom(root))
+				got.Chain = nil
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseYubiKeyProperties(t *testing.T) {
+	mustCertificate := func(cn string, extensions ...pkix.Extension) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:    pkix.Name{CommonName: cn},
+			Extensions: extensions,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		crt       *x509.Certificate
+		want      *YubiKeyProperties
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", mustCertificate("YubiKey PIV Attestation 82",
+			pkix.Extension{Id: oidYubicoFirmwareVersion, Value: []byte{5, 2, 7}},
+			mustSerialExtension(t, 0xffffffff),
+			pkix.Extension{Id: oidYubicoPolicy, Value: []byte{1, 2}},
+		), &YubiKeyProperties{
+			Version:     Version{5, 2, 7},
+			Serial:      0xffffffff,
+			PINPolicy:   PINPolicyNever,
+			TouchPolicy: TouchPolicyAlways,
+			Slot:        "82",
+		}, assert.NoError},
+		{"ok unknown slot", mustCertificate("YubiKey PIV Attestation"), &YubiKeyProperties{}, assert.NoError},
+		{"ok invalid slot", mustCertificate("YubiKey PIV Attestation 9z"), &YubiKeyProperties{}, assert.NoError},
+		{"fail version", mustCertificate("", pkix.Extension{Id: oidYubicoFirmwareVersion, Value: []byte{5, 2}}), nil, assert.Error},
+		{"fail serial", mustCertificate("", pkix.Extension{Id: oidYubicoSerialNumber, Value: []byte{0x01}}), nil, assert.Error},
+		{"fail serial trailing data", mustCertificate("", pkix.Extension{Id: oidYubicoSerialNumber, Value: []byte{0x02, 0x01, 0x01, 0x00}}), nil, assert.Error},
+		{"fail serial negative", mustCertificate("", mustSerialExtension(t, -1)), nil, assert.Error},
+		{"fail serial too large", mustCertificate("", mustSerialExtension(t, 0x100000000)), nil, assert.Error},
+		{"fail policy", mustCertificate("", pkix.Extension{Id: oidYubicoPolicy, Value: []byte{1}}), nil, assert.Error},
+		{"fail form factor", mustCertificate("", pkix.Extension{Id: oidYubicoFormFactor, Value: []byte{1, 2}}), nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYubiKeyProperties(tt.crt)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPolicy_String(t *testing.T) {
+	assert.Equal(t, "never", PINPolicyNever.String())
+	assert.Equal(t, "once", PINPolicyOnce.String())
+	assert.Equal(t, "always", PINPolicyAlways.String())
+	assert.Equal(t, "unknown(0)", PINPolicy(0).String())
+	assert.Equal(t, "never", TouchPolicyNever.String())
+	assert.Equal(t, "always", TouchPolicyAlways.String())
+	assert.Equal(t, "cached", TouchPolicyCached.String())
+	assert.Equal(t, "unknown(4)", TouchPolicy(4).String())
+	assert.Equal(t, "5.7.1", Version{5, 7, 1}.String())
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go.step.sm/crypto/kms/attestation"
 	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/pemutil"
 	"go.step.sm/crypto/randutil"
@@ -54,7 +55,7 @@ func mustContent(t *testing.T, filename string) ([]byte, []AttestationAttribute,
 	b, err := io.ReadAll(r)
 	require.NoError(t, err)
 
-	pub, priv, err := parseAttestation(b[:len(b)-256])
+	pub, priv, err := attestation.ParseCloudKMSAttestation(attestation.CloudKMSFormatCaviumV2, b[:len(b)-256], false)
 	require.NoError(t, err)
 	return content, pub, priv
 }
@@ -651,96 +652,6 @@ func TestCloudKMS_verifyAttestation_V1(t *testing.T) {
 
 }
 
-func Test_decode(t *testing.T) {
-	type args struct {
-		data     []byte
-		dest     any
-		wantSize int
-	}
-	tests := []struct {
-		name      string
-		args      args
-		assert    func(*testing.T, any) bool
-		assertion assert.ErrorAssertionFunc
-	}{
-		{"ok", args{[]byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x03, 0x02, 0x01}, &[2]uint32{}, 8}, func(t *testing.T, v any) bool {
-			vv, ok := v.(*[2]uint32)
-			return assert.True(t, ok) && assert.Equal(t, [2]uint32{0x1020304, 0x4030201}, *vv)
-		}, assert.NoError},
-		{"fail buff", args{[]byte{0x01, 0x02, 0x03, 0x04, 0x04, 0x03, 0x02, 0x01}, &[1]uint32{}, 8}, func(t *testing.T, v any) bool {
-			vv, ok := v.(*[1]uint32)
-			return assert.True(t, ok) && assert.Equal(t, [1]uint32{0x1020304}, *vv)
-		}, assert.Error},
-		{"fail size", args{[]byte{0x01, 0x02, 0x03, 0x04}, &[2]uint32{}, 8}, func(t *testing.T, v any) bool {
-			vv, ok := v.(*[2]uint32)
-			return assert.True(t, ok) && assert.Equal(t, [2]uint32{0x00, 0x00}, *vv)
-		}, assert.Error},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.assertion(t, decode(tt.args.data, tt.args.dest, tt.args.wantSize))
-			tt.assert(t, tt.args.dest)
-		})
-	}
-}
-
-func Test_getKeyType(t *testing.T) {
-	tests := []struct {
-		name string
-		v    uint32
-		want string
-	}{
-		{"RSA", 0x0000, "RSA"},
-		{"DSA", 0x0001, "DSA"},
-		{"DH", 0x0002, "DH"},
-		{"EC", 0x0003, "EC"},
-		{"X9_42_DH", 0x0004, "X9_42_DH"},
-		{"KEA", 0x0005, "KEA"},
-		{"GENERIC_SECRET", 0x0010, "GENERIC_SECRET"},
-		{"RC2", 0x0011, "RC2"},
-		{"RC4", 0x0012, "RC4"},
-		{"DES", 0x0013, "DES"},
-		{"DES2", 0x0014, "DES2"},
-		{"DES3", 0x0015, "DES3"},
-		{"CAST", 0x0016, "CAST"},
-		{"CAST3", 0x0017, "CAST3"},
-		{"CAST128", 0x0018, "CAST128"},
-		{"RC5", 0x0019, "RC5"},
-		{"IDEA", 0x001A, "IDEA"},
-		{"SKIPJACK", 0x001B, "SKIPJACK"},
-		{"BATON", 0x001C, "BATON"},
-		{"JUNIPER", 0x001D, "JUNIPER"},
-		{"CDMF", 0x001E, "CDMF"},
-		{"AES", 0x001F, "AES"},
-		{"BLOWFISH", 0x0020, "BLOWFISH"},
-		{"TWOFISH", 0x0021, "TWOFISH"},
-		{"SECURID", 0x0022, "SECURID"},
-		{"HOTP", 0x0023, "HOTP"},
-		{"ACTI", 0x0024, "ACTI"},
-		{"CAMELLIA", 0x0025, "CAMELLIA"},
-		{"ARIA", 0x0026, "ARIA"},
-		{"MD5_HMAC", 0x0027, "MD5_HMAC"},
-		{"SHA_1_HMAC", 0x0028, "SHA_1_HMAC"},
-		{"RIPEMD128_HMAC", 0x0029, "RIPEMD128_HMAC"},
-		{"RIPEMD160_HMAC", 0x002A, "RIPEMD160_HMAC"},
-		{"SHA256_HMAC", 0x002B, "SHA256_HMAC"},
-		{"SHA384_HMAC", 0x002C, "SHA384_HMAC"},
-		{"SHA512_HMAC", 0x002D, "SHA512_HMAC"},
-		{"SHA224_HMAC", 0x002E, "SHA224_HMAC"},
-		{"SEED", 0x002F, "SEED"},
-		{"GOSTR3410", 0x0030, "GOSTR3410"},
-		{"GOSTR3411", 0x0031, "GOSTR3411"},
-		{"GOST28147", 0x0032, "GOST28147"},
-		{"VENDOR_DEFINED", 0x80000000, "VENDOR_DEFINED"},
-		{"UNKNOWN", 0x33, "UNKNOWN"},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			assert.Equal(t, tt.want, getKeyType(tt.v))
-		})
-	}
-}
-
 // TestValidateCaviumRoot validates that the current root certificate for the
 // hard-coded root for Marvell's LiquidSecurity HSM adapters matches the one in
 // this package. The current certificate expires on November 16, 2025. We will
@@ -0,0 +1,214 @@
+//go:build cgo && !nopkcs11
+// +build cgo,!nopkcs11
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"io"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// PKCS #11 v3.0 constants for EdDSA keys. They are not available in the
+// version of github.com/miekg/pkcs11 used by crypto11.
+const (
+	ckkECEdwards           = 0x00000040
+	ckmECEdwardsKeyPairGen = 0x00001055
+	ckmEdDSA               = 0x00001057
+)
+
+// oidEd25519 is the object identifier of Ed25519 keys. It is used in the
+// CKA_EC_PARAMS attribute.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// generateEd25519KeyPair generates an Ed25519 key pair with the given attributes.
+func (m *p11Module) generateEd25519KeyPair(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	params, err := asn1.Marshal(oidEd25519)
+	if err != nil {
+		return nil, err
+	}
+	public.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+	})
+	private.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	})
+
+	var signer *ed25519Signer
+	err = m.withSession(func(sh pkcs11.SessionHandle) error {
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmECEdwardsKeyPairGen, nil)}
+		pubHandle, privHandle, err := m.ctx.GenerateKeyPair(sh, mech, public.ToSlice(), private.ToSlice())
+		if err != nil {
+			return err
+		}
+		pub, err := m.exportEd25519PublicKey(sh, pubHandle)
+		if err != nil {
+			return err
+		}
+		signer = &ed25519Signer{
+			module:             m,
+			handle:             privHandle,
+			pubHandle:          pubHandle,
+			pub:                pub,
+			alwaysAuthenticate: m.alwaysAuthenticate(sh, privHandle),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating Ed25519 key")
+	}
+	return signer, nil
+}
+
+// findEd25519KeyPair returns the Ed25519 key pair with the given id and label,
+// or nil if it cannot be found.
+func (m *p11Module) findEd25519KeyPair(id, label []byte) (crypto11.Signer, error) {
+	var signer *ed25519Signer
+	keyType := pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards)
+	err := m.withSession(func(sh pkcs11.SessionHandle) error {
+		privHandle, err := m.findObject(sh, pkcs11.CKO_PRIVATE_KEY, id, label, keyType)
+		if err != nil || privHandle == nil {
+			return err
+		}
+
+		// Use the id of the private key to find the public key, the label is
+		// not required.
+		attrs, err := m.ctx.GetAttributeValue(sh, *privHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		})
+		if err != nil {
+			return err
+		}
+		pubHandle, err := m.findObject(sh, pkcs11.CKO_PUBLIC_KEY, attrs[0].Value, label, keyType)
+		if err == nil && pubHandle == nil && label != nil {
+			pubHandle, err = m.findObject(sh, pkcs11.CKO_PUBLIC_KEY, attrs[0].Value, nil, keyType)
+		}
+		if err != nil {
+			return err
+		}
+		if pubHandle == nil {
+			return errors.New("public key not found")
+		}
+
+		pub, err := m.exportEd25519PublicKey(sh, *pubHandle)
+		if err != nil {
+			return err
+		}
+		signer = &ed25519Signer{
+			module:             m,
+			handle:             *privHandle,
+			pubHandle:          *pubHandle,
+			pub:                pub,
+			alwaysAuthenticate: m.alwaysAuthenticate(sh, *privHandle),
+		}
+		return nil
+	})
+	if err != nil || signer == nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// exportEd25519PublicKey returns the Ed25519 public key in the given object.
+func (m *p11Module) exportEd25519PublicKey(sh pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (ed25519.PublicKey, error) {
+	attrs, err := m.ctx.GetAttributeValue(sh, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !isEd25519Params(attrs[0].Value) {
+		return nil, errors.New("unsupported EdDSA curve")
+	}
+	return parseEd25519Point(attrs[1].Value)
+}
+
+// isEd25519Params returns true if the given CKA_EC_PARAMS refers to Ed25519.
+// PKCS #11 v3.0 allows the object identifier or the curve name.
+func isEd25519Params(b []byte) bool {
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(b, &oid); err == nil && len(rest) == 0 {
+		return oid.Equal(oidEd25519)
+	}
+	var name string
+	if rest, err := asn1.UnmarshalWithParams(b, &name, "printable"); err == nil && len(rest) == 0 {
+		return name == "edwards25519"
+	}
+	return false
+}
+
+// parseEd25519Point parses the CKA_EC_POINT of an Ed25519 key. The value
+// should be a DER-encoded octet string, but some modules return the raw
+// public key.
+func parseEd25519Point(b []byte) (ed25519.PublicKey, error) {
+	if len(b) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(bytes.Clone(b)), nil
+	}
+	var point []byte
+	if rest, err := asn1.Unmarshal(b, &point); err != nil || len(rest) > 0 {
+		return nil, errors.New("error parsing Ed25519 public key: invalid encoding")
+	}
+	if len(point) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("error parsing Ed25519 public key: invalid size %d", len(point))
+	}
+	return ed25519.PublicKey(point), nil
+}
+
+// ed25519Signer is a crypto11.Signer for Ed25519 keys in a PKCS #11 module.
+type ed25519Signer struct {
+	module             *p11Module
+	handle             pkcs11.ObjectHandle
+	pubHandle          pkcs11.ObjectHandle
+	pub                ed25519.PublicKey
+	alwaysAuthenticate bool
+}
+
+// Public returns the public key.
+func (s *ed25519Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the message using the CKM_EDDSA mechanism. Only pure Ed25519 is
+// supported, the message must not be hashed.
+func (s *ed25519Signer) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	if opts != nil && opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("ed25519: expected opts.HashFunc() zero (unhashed message, for standard Ed25519)")
+	}
+	if o, ok := opts.(*ed25519.Options); ok && o.Context != "" {
+		return nil, errors.New("ed25519: Ed25519ctx is not supported")
+	}
+
+	mech := pkcs11.NewMechanism(ckmEdDSA, nil)
+	signature, err = s.module.sign(s.handle, mech, message, s.alwaysAuthenticate)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing with Ed25519 key")
+	}
+	return signature, nil
+}
+
+// Delete deletes the key pair from the token.
+func (s *ed25519Signer) Delete() error {
+	return s.module.withSession(func(sh pkcs11.SessionHandle) error {
+		if err := s.module.ctx.DestroyObject(sh, s.handle); err != nil {
+			return err
+		}
+		return s.module.ctx.DestroyObject(sh, s.pubHandle)
+	})
+}
@@ -0,0 +1,68 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_isEd25519Params(t *testing.T) {
+	mustMarshal := func(v any, params string) []byte {
+		b, err := asn1.MarshalWithParams(v, params)
+		require.NoError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{"ok oid", mustMarshal(oidEd25519, ""), true},
+		{"ok name", mustMarshal("edwards25519", "printable"), true},
+		{"fail ed448 oid", mustMarshal(asn1.ObjectIdentifier{1, 3, 101, 113}, ""), false},
+		{"fail ed448 name", mustMarshal("edwards448", "printable"), false},
+		{"fail trailing data", append(mustMarshal(oidEd25519, ""), 0), false},
+		{"fail empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isEd25519Params(tt.b))
+		})
+	}
+}
+
+func Test_parseEd25519Point(t *testing.T) {
+	pub := ed25519.PublicKey(bytes.Repeat([]byte{0x01}, ed25519.PublicKeySize))
+	der, err := asn1.Marshal([]byte(pub))
+	require.NoError(t, err)
+	short, err := asn1.Marshal([]byte(pub[:31]))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		b         []byte
+		want      ed25519.PublicKey
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok der", der, pub, assert.NoError},
+		{"ok raw", []byte(pub), pub, assert.NoError},
+		{"fail size", short, nil, assert.Error},
+		{"fail trailing data", append(der, 0), nil, assert.Error},
+		{"fail encoding", []byte{0x04, 0x01}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEd25519Point(tt.b)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build cgo && !nopkcs11
+// +build cgo,!nopkcs11
+
+package pkcs11
+
+import (
+	"crypto/x509"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+)
+
+// failingImportP11 is a P11 that fails to import certificates after the
+// given number of imports.
+type failingImportP11 struct {
+	P11
+	imports int
+}
+
+func (p *failingImportP11) ImportCertificateWithAttributes(template crypto11.AttributeSet, cert *x509.Certificate) error {
+	if p.imports == 0 {
+		return errors.New("import failed")
+	}
+	p.imports--
+	return p.P11.ImportCertificateWithAttributes(template, cert)
+}
@@ -0,0 +1,219 @@
+//go:build cgo && !nopkcs11
+// +build cgo,!nopkcs11
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"io"
+	"math/big"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// pkcs1v15Prefixes are the DER-encoded DigestInfo prefixes of the hashes
+// supported by the CKM_RSA_PKCS mechanism.
+var pkcs1v15Prefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// hashMechanisms maps a hash to the PKCS #11 hash mechanism and mask
+// generation function.
+var hashMechanisms = map[crypto.Hash]struct {
+	mechanism uint
+	mgf       uint
+}{
+	crypto.SHA1:   {pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1},
+	crypto.SHA224: {pkcs11.CKM_SHA224, pkcs11.CKG_MGF1_SHA224},
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// wrapAlwaysAuthenticate returns a signer that authenticates the user before
+// each operation if the private key found with the given id and label has the
+// CKA_ALWAYS_AUTHENTICATE attribute. Otherwise it returns the given signer.
+func (m *p11Module) wrapAlwaysAuthenticate(signer crypto11.Signer, id, label []byte) (crypto11.Signer, error) {
+	if m.loginNotSupported {
+		return signer, nil
+	}
+
+	var (
+		handle pkcs11.ObjectHandle
+		always bool
+	)
+	if err := m.withSession(func(sh pkcs11.SessionHandle) error {
+		h, err := m.findObject(sh, pkcs11.CKO_PRIVATE_KEY, id, label)
+		if err != nil || h == nil {
+			return err
+		}
+		handle, always = *h, m.alwaysAuthenticate(sh, *h)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "error finding private key")
+	}
+	if !always {
+		return signer, nil
+	}
+
+	s := &alwaysAuthenticateSigner{
+		Signer: signer,
+		module: m,
+		handle: handle,
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); ok {
+		return &alwaysAuthenticateSignerDecrypter{s}, nil
+	}
+	return s, nil
+}
+
+// alwaysAuthenticateSigner is a crypto11.Signer for keys with the
+// CKA_ALWAYS_AUTHENTICATE attribute. crypto11 does not support the
+// context-specific login required by these keys, so the operations are done
+// using the PKCS #11 module directly. The crypto11 signer is used to get the
+// public key and to delete the key pair.
+type alwaysAuthenticateSigner struct {
+	crypto11.Signer
+	module *p11Module
+	handle pkcs11.ObjectHandle
+}
+
+// Sign signs the digest using the private key. RSA PKCS #1 v1.5, RSA-PSS and
+// ECDSA keys are supported.
+func (s *alwaysAuthenticateSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch pub := s.Public().(type) {
+	case *rsa.PublicKey:
+		if o, ok := opts.(*rsa.PSSOptions); ok {
+			mech, err := rsaPSSMechanism(pub, o)
+			if err != nil {
+				return nil, err
+			}
+			return s.sign(mech, digest)
+		}
+		data, err := pkcs1v15DigestInfo(opts.HashFunc(), digest)
+		if err != nil {
+			return nil, err
+		}
+		return s.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), data)
+	case *ecdsa.PublicKey:
+		sig, err := s.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if err != nil {
+			return nil, err
+		}
+		return marshalECDSASignature(sig)
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func (s *alwaysAuthenticateSigner) sign(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	sig, err := s.module.sign(s.handle, mech, data, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing data")
+	}
+	return sig, nil
+}
+
+// alwaysAuthenticateSignerDecrypter is the crypto11.SignerDecrypter for RSA
+// keys with the CKA_ALWAYS_AUTHENTICATE attribute.
+type alwaysAuthenticateSignerDecrypter struct {
+	*alwaysAuthenticateSigner
+}
+
+// Decrypt decrypts msg using the private key. It supports RSA PKCS #1 v1.5
+// and RSA-OAEP.
+func (s *alwaysAuthenticateSignerDecrypter) Decrypt(_ io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	mech := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+	case *rsa.OAEPOptions:
+		hash, ok := hashMechanisms[o.Hash]
+		if !ok {
+			return nil, errors.Errorf("unsupported hash function %s", o.Hash)
+		}
+		mgf := hash.mgf
+		if o.MGFHash != 0 {
+			h, ok := hashMechanisms[o.MGFHash]
+			if !ok {
+				return nil, errors.Errorf("unsupported hash function %s", o.MGFHash)
+			}
+			mgf = h.mgf
+		}
+		params := pkcs11.NewOAEPParams(hash.mechanism, mgf, pkcs11.CKZ_DATA_SPECIFIED, o.Label)
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)
+	default:
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	}
+
+	plaintext, err := s.module.decrypt(s.handle, mech, msg, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting data")
+	}
+	return plaintext, nil
+}
+
+// pkcs1v15DigestInfo returns the DigestInfo structure signed by the
+// CKM_RSA_PKCS mechanism. If the hash is zero, the digest is signed directly.
+func pkcs1v15DigestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	if hash == 0 {
+		return digest, nil
+	}
+	prefix, ok := pkcs1v15Prefixes[hash]
+	if !ok {
+		return nil, errors.Errorf("unsupported hash function %s", hash)
+	}
+	if len(digest) != hash.Size() {
+		return nil, errors.Errorf("invalid digest length %d, expected %d", len(digest), hash.Size())
+	}
+	return append(append([]byte{}, prefix...), digest...), nil
+}
+
+// rsaPSSMechanism returns the CKM_RSA_PKCS_PSS mechanism for the given
+// options.
+func rsaPSSMechanism(pub *rsa.PublicKey, opts *rsa.PSSOptions) (*pkcs11.Mechanism, error) {
+	hash := opts.HashFunc()
+	h, ok := hashMechanisms[hash]
+	if !ok {
+		return nil, errors.Errorf("unsupported hash function %s", hash)
+	}
+
+	var saltLength int
+	switch opts.SaltLength {
+	case rsa.PSSSaltLengthAuto:
+		saltLength = (pub.N.BitLen()-1+7)/8 - 2 - hash.Size()
+	case rsa.PSSSaltLengthEqualsHash:
+		saltLength = hash.Size()
+	default:
+		saltLength = opts.SaltLength
+	}
+	if saltLength < 0 {
+		return nil, errors.Errorf("invalid salt length %d", saltLength)
+	}
+
+	params := pkcs11.NewPSSParams(h.mechanism, h.mgf, uint(saltLength))
+	return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), nil
+}
+
+// marshalECDSASignature converts the signature returned by the CKM_ECDSA
+// mechanism, the concatenation of r and s, to the ASN.1 format used by Go.
+func marshalECDSASignature(sig []byte) ([]byte, error) {
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.Errorf("invalid ECDSA signature length %d", len(sig))
+	}
+	n := len(sig) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(sig[:n]),
+		S: new(big.Int).SetBytes(sig[n:]),
+	})
+}
@@ -0,0 +1,90 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pkcs1v15DigestInfo(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("the-message"))
+
+	// The DigestInfo signed with raw RSA must be a valid PKCS #1 v1.5
+	// signature.
+	data, err := pkcs1v15DigestInfo(crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.Hash(0), data)
+	require.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	data, err = pkcs1v15DigestInfo(crypto.Hash(0), []byte("raw data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("raw data"), data)
+
+	_, err = pkcs1v15DigestInfo(crypto.SHA256, digest[:16])
+	assert.Error(t, err)
+	_, err = pkcs1v15DigestInfo(crypto.MD5, digest[:16])
+	assert.Error(t, err)
+}
+
+func Test_rsaPSSMechanism(t *testing.T) {
+	pub := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 2047), E: 65537}
+
+	tests := []struct {
+		name      string
+		opts      *rsa.PSSOptions
+		want      *pkcs11.Mechanism
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok equals hash", &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash},
+			pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 32)), assert.NoError},
+		{"ok auto", &rsa.PSSOptions{Hash: crypto.SHA384, SaltLength: rsa.PSSSaltLengthAuto},
+			pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384, 256-2-48)), assert.NoError},
+		{"ok fixed", &rsa.PSSOptions{Hash: crypto.SHA512, SaltLength: 20},
+			pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512, 20)), assert.NoError},
+		{"fail hash", &rsa.PSSOptions{Hash: crypto.MD5}, nil, assert.Error},
+		{"fail salt length", &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: -10}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rsaPSSMechanism(pub, tt.opts)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_marshalECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("the-message"))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+
+	sig, err := marshalECDSASignature(raw)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig))
+
+	_, err = marshalECDSASignature(raw[:63])
+	assert.Error(t, err)
+	_, err = marshalECDSASignature(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+//go:build cgo && !nopkcs11
+// +build cgo,!nopkcs11
+
+package pkcs11
+
+import (
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// maxIdleSessions is the maximum number of sessions kept open by the
+// p11Module.
+const maxIdleSessions = 8
+
+// p11Context is the P11 implementation used by default. It adds support for
+// EdDSA keys and for keys that require a login before each operation to
+// crypto11.Context.
+type p11Context struct {
+	*crypto11.Context
+	module *p11Module
+}
+
+// configure initializes crypto11 and the module used for the operations not
+// supported by crypto11.
+func configure(config *crypto11.Config) (*p11Context, error) {
+	ctx, err := crypto11.Configure(config)
+	if err != nil {
+		return nil, err
+	}
+	module, err := newP11Module(config)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	return &p11Context{
+		Context: ctx,
+		module:  module,
+	}, nil
+}
+
+// FindKeyPair retrieves a key pair, or nil if it cannot be found. crypto11
+// fails with EdDSA keys, so if it fails, the key is searched as an EdDSA key.
+// Keys with the CKA_ALWAYS_AUTHENTICATE attribute are wrapped so the user is
+// authenticated before each operation.
+func (c *p11Context) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	signer, err := c.Context.FindKeyPair(id, label)
+	if err != nil {
+		if s, edErr := c.module.findEd25519KeyPair(id, label); edErr == nil && s != nil {
+			return s, nil
+		}
+		return nil, err
+	}
+	if signer == nil {
+		return nil, nil
+	}
+	return c.module.wrapAlwaysAuthenticate(signer, id, label)
+}
+
+// GenerateEd25519KeyPairWithAttributes generates an Ed25519 key pair using the
+// given attributes. If required attributes are missing, they will be set to a
+// default value.
+func (c *p11Context) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	return c.module.generateEd25519KeyPair(public, private)
+}
+
+// Close closes the sessions used by the module and the crypto11 context.
+func (c *p11Context) Close() error {
+	c.module.close()
+	return c.Context.Close()
+}
+
+// p11Module implements the operations not supported by crypto11 using the
+// PKCS #11 module directly. crypto11 initializes the module and logs in, the
+// login state is shared by all the sessions of the application.
+type p11Module struct {
+	ctx               *pkcs11.Ctx
+	slot              uint
+	pin               string
+	loginNotSupported bool
+	sessions          chan pkcs11.SessionHandle
+}
+
+func newP11Module(config *crypto11.Config) (*p11Module, error) {
+	ctx := pkcs11.New(config.Path)
+	if ctx == nil {
+		return nil, errors.Errorf("error loading PKCS#11 module %s", config.Path)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "error initializing PKCS#11 module")
+	}
+
+	slot, err := findSlot(ctx, config)
+	if err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+
+	return &p11Module{
+		ctx:               ctx,
+		slot:              slot,
+		pin:               config.Pin,
+		loginNotSupported: config.LoginNotSupported,
+		sessions:          make(chan pkcs11.SessionHandle, maxIdleSessions),
+	}, nil
+}
+
+// findSlot returns the slot selected by the configuration, using the same
+// criteria as crypto11.
+func findSlot(ctx *pkcs11.Ctx, config *crypto11.Config) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing PKCS#11 slots")
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, errors.Wrap(err, "error getting PKCS#11 token info")
+		}
+		if (config.SlotNumber != nil && uint(*config.SlotNumber) == slot) ||
+			(info.SerialNumber != "" && info.SerialNumber == config.TokenSerial) ||
+			(info.Label != "" && info.Label == config.TokenLabel) {
+			return slot, nil
+		}
+	}
+	return 0, errors.New("error finding PKCS#11 token: token not found")
+}
+
+// withSession runs the given function with an idle session, or a new one if
+// there are none. Sessions used in failed operations are closed, as they might
+// have an operation still active.
+func (m *p11Module) withSession(fn func(sh pkcs11.SessionHandle) error) error {
+	var sh pkcs11.SessionHandle
+	select {
+	case sh = <-m.sessions:
+	default:
+		var err error
+		if sh, err = m.ctx.OpenSession(m.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION); err != nil {
+			return errors.Wrap(err, "error opening PKCS#11 session")
+		}
+	}
+
+	if err := fn(sh); err != nil {
+		m.ctx.CloseSession(sh)
+		return err
+	}
+
+	select {
+	case m.sessions <- sh:
+	default:
+		m.ctx.CloseSession(sh)
+	}
+	return nil
+}
+
+func (m *p11Module) close() {
+	for {
+		select {
+		case sh := <-m.sessions:
+			m.ctx.CloseSession(sh)
+		default:
+			// The module is finalized by crypto11.
+			m.ctx.Destroy()
+			return
+		}
+	}
+}
+
+// findObject returns the first object of the given class with the given id
+// and label, and the extra attributes.
+func (m *p11Module) findObject(sh pkcs11.SessionHandle, class uint, id, label []byte, extra ...*pkcs11.Attribute) (*pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+	}
+	if id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if label != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+	template = append(template, extra...)
+	if err := m.ctx.FindObjectsInit(sh, template); err != nil {
+		return nil, err
+	}
+	handles, _, err := m.ctx.FindObjects(sh, 1)
+	if finalErr := m.ctx.FindObjectsFinal(sh); err == nil {
+		err = finalErr
+	}
+	if err != nil || len(handles) == 0 {
+		return nil, err
+	}
+	return &handles[0], nil
+}
+
+// alwaysAuthenticate returns true if the CKA_ALWAYS_AUTHENTICATE attribute of
+// the given private key is set.
+func (m *p11Module) alwaysAuthenticate(sh pkcs11.SessionHandle, handle pkcs11.ObjectHandle) bool {
+	if m.loginNotSupported {
+		return false
+	}
+	attrs, err := m.ctx.GetAttributeValue(sh, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ALWAYS_AUTHENTICATE, nil),
+	})
+	if err != nil || len(attrs) == 0 || len(attrs[0].Value) == 0 {
+		return false
+	}
+	return attrs[0].Value[0] != 0
+}
+
+// sign signs the data using the given key and mechanism. If login is true,
+// the user is authenticated after initializing the operation, as required by
+// keys with the CKA_ALWAYS_AUTHENTICATE attribute.
+func (m *p11Module) sign(handle pkcs11.ObjectHandle, mech *pkcs11.Mechanism, data []byte, login bool) (signature []byte, err error) {
+	err = m.withSession(func(sh pkcs11.SessionHandle) error {
+		if err := m.ctx.SignInit(sh, []*pkcs11.Mechanism{mech}, handle); err != nil {
+			return err
+		}
+		if login {
+			if err := m.ctx.Login(sh, pkcs11.CKU_CONTEXT_SPECIFIC, m.pin); err != nil {
+				return errors.Wrap(err, "error authenticating user")
+			}
+		}
+		signature, err = m.ctx.Sign(sh, data)
+		return err
+	})
+	return
+}
+
+// decrypt decrypts the data using the given key and mechanism. If login is
+// true, the user is authenticated after initializing the operation, as
+// required by keys with the CKA_ALWAYS_AUTHENTICATE attribute.
+func (m *p11Module) decrypt(handle pkcs11.ObjectHandle, mech *pkcs11.Mechanism, data []byte, login bool) (plaintext []byte, err error) {
+	err = m.withSession(func(sh pkcs11.SessionHandle) error {
+		if err := m.ctx.DecryptInit(sh, []*pkcs11.Mechanism{mech}, handle); err != nil {
+			return err
+		}
+		if login {
+			if err := m.ctx.Login(sh, pkcs11.CKU_CONTEXT_SPECIFIC, m.pin); err != nil {
+				return errors.Wrap(err, "error authenticating user")
+			}
+		}
+		plaintext, err = m.ctx.Decrypt(sh, data)
+		return err
+	})
+	return
+}
+
+// isSessionError returns true if the error indicates that the sessions are no
+// longer valid, and the module must be initialized again. CKR_USER_NOT_LOGGED_IN
+// is not a session error, it's also returned when the context specific login
+// of a key with CKA_ALWAYS_AUTHENTICATE fails or is missing, and reconnecting
+// would not fix it.
+func isSessionError(err error) bool {
+	var p11Err pkcs11.Error
+	if !errors.As(err, &p11Err) {
+		return false
+	}
+	switch p11Err {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED,
+		pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_DEVICE_ERROR, pkcs11.CKR_TOKEN_NOT_PRESENT,
+		pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,35 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isSessionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"session closed", pkcs11.Error(pkcs11.CKR_SESSION_CLOSED), true},
+		{"session invalid", errors.Wrap(pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID), "wrapped"), true},
+		{"device removed", pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED), true},
+		{"token not present", pkcs11.Error(pkcs11.CKR_TOKEN_NOT_PRESENT), true},
+		{"user not logged in", pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN), false},
+		{"not initialized", pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED), true},
+		{"other pkcs11 error", pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID), false},
+		{"other error", errors.New("an error"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isSessionError(tt.err))
+		})
+	}
+}
@@ -41,7 +41,7 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 		return nil
 	}
 	var zero int
-	p11, err := crypto11.Configure(&crypto11.Config{
+	p11, err := configure(&crypto11.Config{
 		Path:       path,
 		SlotNumber: &zero,
 		Pin:        "123456",
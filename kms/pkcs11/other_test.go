@@ -6,6 +6,7 @@ package pkcs11
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -186,6 +187,37 @@ func (s *stubPKCS11) GenerateECDSAKeyPairWithLabel(id, label []byte, curve ellip
 	return k, nil
 }
 
+func (s *stubPKCS11) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	var id, label []byte
+	if v := public[crypto11.CkaId]; v != nil {
+		id = v.Value
+	}
+	if v := public[crypto11.CkaLabel]; v != nil {
+		label = v.Value
+	}
+	if id == nil && label == nil {
+		return nil, errors.New("id and label cannot both be nil")
+	}
+	_, p, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k := &privateKey{
+		Signer: p,
+		index:  len(s.signers),
+		stub:   s,
+	}
+	s.signers = append(s.signers, k)
+	s.signerIndex[newKey(id, label, nil)] = k.index
+	s.signerIndex[newKey(id, nil, nil)] = k.index
+	s.signerIndex[newKey(nil, label, nil)] = k.index
+	return k, nil
+}
+
+func (s *stubPKCS11) NewRandomReader() (io.Reader, error) {
+	return rand.Reader, nil
+}
+
 func (s *stubPKCS11) Close() error {
 	return nil
 }
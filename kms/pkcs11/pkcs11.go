@@ -4,17 +4,23 @@
 package pkcs11
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // used to create key identifiers
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"math/big"
 	"runtime"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ThalesIgnite/crypto11"
 	"github.com/pkg/errors"
@@ -30,6 +36,10 @@ const Scheme = string(apiv1.PKCS11)
 // specified.
 const DefaultRSASize = 3072
 
+// maxChainLength is the maximum number of certificates returned by
+// LoadCertificateChain.
+const maxChainLength = 10
+
 // P11 defines the methods on crypto11.Context that this package will use. This
 // interface will be used for unit testing.
 type P11 interface {
@@ -39,11 +49,13 @@ type P11 interface {
 	DeleteCertificate(id, label []byte, serial *big.Int) error
 	GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error)
 	GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error)
+	GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error)
+	NewRandomReader() (io.Reader, error)
 	Close() error
 }
 
 var p11Configure = func(config *crypto11.Config) (P11, error) {
-	return crypto11.Configure(config)
+	return configure(config)
 }
 
 // PKCS11 is the implementation of a KMS using the PKCS #11 standard.
@@ -60,13 +72,21 @@ type PKCS11 struct {
 //   - pkcs11:slot-id=5?pin-value=password
 //   - pkcs11:module-path=/path/to/module.so;token=smallstep?pin-value=password
 //   - pkcs11:token=smallstep;max-sessions=100?pin-value=password
+//   - pkcs11:token=smallstep;max-sessions=10;pool-wait-timeout=5s?pin-value=password
 //
 // The scheme is "pkcs11"; "token", "serial", or "slot-id" defines the
 // cryptographic device to use. "module-path" is the path of the PKCS#11 module
 // to use. It will default to the proxy module of the p11-kit project if none is
 // specified (p11-kit-proxy.so). "pin-value" provides the user's PIN, and
 // "pin-source" defines a file that contains the PIN. "max-sessions" defines the
-// maximum number of PKCS#11 sessions, it defaults to 1024.
+// maximum number of PKCS#11 sessions, it defaults to 1024. "pool-wait-timeout"
+// defines how long an operation waits for a free session when all of them are
+// in use, by default it waits indefinitely.
+//
+// If an operation fails because the sessions are no longer valid, after a
+// token reset or a network issue with the HSM, the module is initialized again
+// and the user logged in. Keys with the CKA_ALWAYS_AUTHENTICATE attribute are
+// supported, the PIN is provided before each operation with those keys.
 //
 // A cryptographic key or object is identified by its "id" or "object"
 // attributes. The "id" is the key identifier for the object, it's a hexadecimal
@@ -105,6 +125,13 @@ func New(_ context.Context, opts apiv1.Options) (*PKCS11, error) {
 		}
 		config.MaxSessions = n
 	}
+	if v := u.Get("pool-wait-timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "kms uri 'pool-wait-timeout' is not valid")
+		}
+		config.PoolWaitTimeout = d
+	}
 
 	// Get module or default to use p11-kit-proxy.so.
 	//
@@ -143,7 +170,9 @@ func New(_ context.Context, opts apiv1.Options) (*PKCS11, error) {
 	}
 
 	return &PKCS11{
-		p11: p11,
+		p11: newReconnectingP11(p11, func() (P11, error) {
+			return p11Configure(&config)
+		}, config.MaxSessions),
 	}, nil
 }
 
@@ -279,23 +308,164 @@ func (k *PKCS11) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 		}, "storeCertificate failed")
 	}
 
-	// Import certificate with the necessary attributes.
-	template, err := crypto11.NewAttributeSetWithIDAndLabel(id, object)
-	if err != nil {
+	if err := importCertificate(k.p11, id, object, req.Certificate, req.Extractable); err != nil {
 		return errors.Wrap(err, "storeCertificate failed")
 	}
-	if req.Extractable {
-		if err := template.Set(crypto11.CkaExtractable, true); err != nil {
-			return errors.Wrap(err, "storeCertificate failed")
+
+	return nil
+}
+
+// LoadCertificateChain implements kms.CertificateChainManager and loads a
+// certificate chain from the PKCS #11 module. The leaf certificate is loaded
+// using the given name, and the rest of the chain is built looking for the
+// certificates with a CKA_ID matching the authority key identifier of the
+// previous certificate in the chain, or with a CKA_LABEL matching its issuer
+// if the authority key identifier is missing or not found.
+func (k *PKCS11) LoadCertificateChain(req *apiv1.LoadCertificateChainRequest) ([]*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("loadCertificateChainRequest 'name' cannot be empty")
+	}
+	cert, err := findCertificate(k.p11, req.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "loadCertificateChain failed")
+	}
+
+	chain := []*x509.Certificate{cert}
+	for len(chain) < maxChainLength && !isSelfSigned(cert) {
+		issuer, err := findIssuer(k.p11, cert)
+		if err != nil {
+			return nil, errors.Wrap(err, "loadCertificateChain failed")
+		}
+		if issuer == nil || containsCertificate(chain, issuer) {
+			break
 		}
+		chain = append(chain, issuer)
+		cert = issuer
 	}
-	if err := k.p11.ImportCertificateWithAttributes(template, req.Certificate); err != nil {
-		return errors.Wrap(err, "storeCertificate failed")
+
+	return chain, nil
+}
+
+// StoreCertificateChain implements kms.CertificateChainManager and stores a
+// certificate chain in the PKCS #11 module. The leaf certificate is stored
+// using the id and object in the given name, the intermediates are stored
+// using their subject key identifier as the CKA_ID and their subject as the
+// CKA_LABEL. Intermediates already present in the module are not stored
+// again. If any certificate cannot be stored, the ones already stored are
+// deleted.
+func (k *PKCS11) StoreCertificateChain(req *apiv1.StoreCertificateChainRequest) error {
+	switch {
+	case req.Name == "":
+		return errors.New("storeCertificateChainRequest 'name' cannot be empty")
+	case len(req.CertificateChain) == 0:
+		return errors.New("storeCertificateChainRequest 'CertificateChain' cannot be empty")
+	}
+	for _, c := range req.CertificateChain {
+		if c == nil {
+			return errors.New("storeCertificateChainRequest 'CertificateChain' cannot contain nil certificates")
+		}
+	}
+
+	id, object, err := parseObject(req.Name)
+	if err != nil {
+		return errors.Wrap(err, "storeCertificateChain failed")
+	}
+
+	// Enforce the use of both id and labels. This is not strictly necessary in
+	// PKCS #11, but it's a good practice.
+	if len(id) == 0 || len(object) == 0 {
+		return errors.Errorf("key with uri %s is not valid, id and object are required", req.Name)
+	}
+
+	// Verify the chain before storing anything.
+	leaf, intermediates := req.CertificateChain[0], req.CertificateChain[1:]
+	for i, c := range intermediates {
+		child := req.CertificateChain[i]
+		if !bytes.Equal(child.RawIssuer, c.RawSubject) {
+			return errors.Errorf("storeCertificateChain failed: certificate %d is not the issuer of certificate %d", i+1, i)
+		}
+		if err := child.CheckSignatureFrom(c); err != nil {
+			return errors.Wrapf(err, "storeCertificateChain failed: certificate %d is not the issuer of certificate %d", i+1, i)
+		}
+	}
+
+	cert, err := k.p11.FindCertificate(id, object, nil)
+	if err != nil {
+		return errors.Wrap(err, "storeCertificateChain failed")
+	}
+	if cert != nil {
+		return errors.Wrap(apiv1.AlreadyExistsError{
+			Message: req.Name + " already exists",
+		}, "storeCertificateChain failed")
+	}
+
+	// Keep track of the stored certificates to delete them on failure.
+	type storedCertificate struct {
+		id, label []byte
+		serial    *big.Int
+	}
+	var stored []storedCertificate
+	rollback := func(err error) error {
+		for i := len(stored) - 1; i >= 0; i-- {
+			_ = k.p11.DeleteCertificate(stored[i].id, stored[i].label, stored[i].serial)
+		}
+		return errors.Wrap(err, "storeCertificateChain failed")
+	}
+
+	if err := importCertificate(k.p11, id, object, leaf, false); err != nil {
+		return errors.Wrap(err, "storeCertificateChain failed")
+	}
+	stored = append(stored, storedCertificate{id, object, leaf.SerialNumber})
+
+	for _, c := range intermediates {
+		keyID := certificateKeyID(c)
+		cert, err := k.p11.FindCertificate(keyID, nil, c.SerialNumber)
+		if err != nil {
+			return rollback(err)
+		}
+		if cert != nil && bytes.Equal(cert.Raw, c.Raw) {
+			continue
+		}
+		label := certificateLabel(c)
+		if err := importCertificate(k.p11, keyID, label, c, false); err != nil {
+			return rollback(err)
+		}
+		stored = append(stored, storedCertificate{keyID, label, c.SerialNumber})
 	}
 
 	return nil
 }
 
+// Stats returns the usage statistics of the connection with the PKCS #11
+// module.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *PKCS11) Stats() Stats {
+	if r, ok := k.p11.(*reconnectingP11); ok {
+		return r.stats()
+	}
+	return Stats{}
+}
+
+// RandomReader returns a reader that generates random data in the PKCS #11
+// module, using C_GenerateRandom. It can be used as an entropy source of a
+// randutil.DRBG.
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *PKCS11) RandomReader() (io.Reader, error) {
+	r, err := k.p11.NewRandomReader()
+	if err != nil {
+		return nil, errors.Wrap(err, "randomReader failed")
+	}
+	return r, nil
+}
+
 // DeleteKey is a utility function to delete a key given an uri.
 func (k *PKCS11) DeleteKey(u string) error {
 	id, object, err := parseObject(u)
@@ -409,7 +579,7 @@ func generateKey(ctx P11, req *apiv1.CreateKeyRequest) (crypto11.Signer, error)
 	case apiv1.ECDSAWithSHA512:
 		return ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P521())
 	case apiv1.PureEd25519:
-		return nil, fmt.Errorf("signature algorithm %s is not supported", req.SignatureAlgorithm)
+		return ctx.GenerateEd25519KeyPairWithAttributes(public, private)
 	default:
 		return nil, fmt.Errorf("signature algorithm %s is not supported", req.SignatureAlgorithm)
 	}
@@ -459,4 +629,94 @@ func findCertificate(ctx P11, rawuri string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// importCertificate stores a certificate with the given id and label.
+func importCertificate(ctx P11, id, object []byte, cert *x509.Certificate, extractable bool) error {
+	template, err := crypto11.NewAttributeSetWithIDAndLabel(id, object)
+	if err != nil {
+		return err
+	}
+	if extractable {
+		if err := template.Set(crypto11.CkaExtractable, true); err != nil {
+			return err
+		}
+	}
+	return ctx.ImportCertificateWithAttributes(template, cert)
+}
+
+// findIssuer returns the issuer of the given certificate. The issuer is
+// looked up using the authority key identifier as the CKA_ID, or if it is not
+// found, using the issuer name as the CKA_LABEL, as intermediates are stored by
+// StoreCertificateChain. It returns nil if the issuer cannot be found.
+func findIssuer(ctx P11, cert *x509.Certificate) (*x509.Certificate, error) {
+	lookups := []struct {
+		id, label []byte
+	}{
+		{id: cert.AuthorityKeyId},
+		{label: toByte(cert.Issuer.String())},
+	}
+	for _, l := range lookups {
+		if len(l.id) == 0 && len(l.label) == 0 {
+			continue
+		}
+		issuer, err := ctx.FindCertificate(l.id, l.label, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error finding issuer of certificate with serial %s", cert.SerialNumber)
+		}
+		if issuer == nil || !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		return issuer, nil
+	}
+	return nil, nil
+}
+
+// certificateLabel returns the label used to store the intermediates of a
+// certificate chain. It is the subject of the certificate, or the hex-encoded
+// key identifier if the subject is empty.
+func certificateLabel(cert *x509.Certificate) []byte {
+	if s := cert.Subject.String(); s != "" {
+		return []byte(s)
+	}
+	return []byte(hex.EncodeToString(certificateKeyID(cert)))
+}
+
+// certificateKeyID returns the subject key identifier of the certificate. If
+// the certificate does not have one, it returns the SHA-1 hash of the subject
+// public key as described in RFC 5280, section 4.2.1.2.
+func certificateKeyID(cert *x509.Certificate) []byte {
+	if len(cert.SubjectKeyId) > 0 {
+		return cert.SubjectKeyId
+	}
+	var info struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &info); err != nil {
+		sum := sha1.Sum(cert.RawSubjectPublicKeyInfo) //nolint:gosec // used as identifier
+		return sum[:]
+	}
+	sum := sha1.Sum(info.SubjectPublicKey.Bytes) //nolint:gosec // used as identifier
+	return sum[:]
+}
+
+// isSelfSigned returns true if the subject and issuer of the certificate are
+// the same and the certificate is signed with its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+func containsCertificate(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
 var _ apiv1.CertificateManager = (*PKCS11)(nil)
+var _ apiv1.CertificateChainManager = (*PKCS11)(nil)
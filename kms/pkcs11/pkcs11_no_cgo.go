@@ -6,6 +6,7 @@ package pkcs11
 import (
 	"context"
 	"crypto"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -51,6 +52,11 @@ func (*PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, erro
 	return nil, errUnsupported
 }
 
+// RandomReader without CGO will always return an error.
+func (*PKCS11) RandomReader() (io.Reader, error) {
+	return nil, errUnsupported
+}
+
 // Close implements the kms.KeyManager interface and without CGO will always
 // return an error.
 func (*PKCS11) Close() error {
@@ -9,15 +9,19 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
 	"math/big"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ThalesIgnite/crypto11"
 	"github.com/pkg/errors"
@@ -72,6 +76,10 @@ func TestNew(t *testing.T) {
 			Type: "pkcs11",
 			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test;max-sessions=100?pin-value=password",
 		}}, k, false},
+		{"ok with pool-wait-timeout", args{context.Background(), apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test;max-sessions=10;pool-wait-timeout=5s?pin-value=password",
+		}}, k, false},
 		{"ok with pin", args{context.Background(), apiv1.Options{
 			Type: "pkcs11",
 			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test",
@@ -126,6 +134,10 @@ func TestNew(t *testing.T) {
 			Type: "pkcs11",
 			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test;max-sessions=0F?pin-value=password",
 		}}, nil, true},
+		{"fail pool-wait-timeout", args{context.Background(), apiv1.Options{
+			Type: "pkcs11",
+			URI:  "pkcs11:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test;pool-wait-timeout=5?pin-value=password",
+		}}, nil, true},
 		{"fail scheme", args{context.Background(), apiv1.Options{
 			Type: "pkcs11",
 			URI:  "foo:module-path=/usr/local/lib/softhsm/libsofthsm2.so;token=pkcs11-test?pin-value=password",
@@ -142,6 +154,11 @@ func TestNew(t *testing.T) {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got != nil {
+				r, ok := got.p11.(*reconnectingP11)
+				require.True(t, ok, "New() p11 is not a *reconnectingP11")
+				got = &PKCS11{p11: r.p11}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
@@ -190,6 +207,9 @@ func TestNew_config(t *testing.T) {
 		{"ok max-sessions", args{ctx, apiv1.Options{URI: "pkcs11:module-path=module.so;slot-id=0;max-sessions=100?pin-value=password"}}, &crypto11.Config{
 			Path: "module.so", SlotNumber: &zero, Pin: "password", MaxSessions: 100,
 		}},
+		{"ok pool-wait-timeout", args{ctx, apiv1.Options{URI: "pkcs11:module-path=module.so;slot-id=0;pool-wait-timeout=1m30s?pin-value=password"}}, &crypto11.Config{
+			Path: "module.so", SlotNumber: &zero, Pin: "password", PoolWaitTimeout: 90 * time.Second,
+		}},
 		{"ok pin-source", args{ctx, apiv1.Options{URI: "pkcs11:module-path=module.so;token=token?pin-source=" + path}}, &crypto11.Config{
 			Path: "module.so", TokenLabel: "token", Pin: "123456",
 		}},
@@ -243,6 +263,15 @@ func TestPKCS11_GetPublicKey(t *testing.T) {
 		{"ECDSA by label", args{&apiv1.GetPublicKeyRequest{
 			Name: "pkcs11:object=ecdsa-p256-key",
 		}}, &ecdsa.PublicKey{}, false},
+		{"Ed25519", args{&apiv1.GetPublicKeyRequest{
+			Name: "pkcs11:id=7378;object=ed25519-key",
+		}}, ed25519.PublicKey{}, false},
+		{"Ed25519 by id", args{&apiv1.GetPublicKeyRequest{
+			Name: "pkcs11:id=7378",
+		}}, ed25519.PublicKey{}, false},
+		{"Ed25519 by label", args{&apiv1.GetPublicKeyRequest{
+			Name: "pkcs11:object=ed25519-key",
+		}}, ed25519.PublicKey{}, false},
 		{"fail name", args{&apiv1.GetPublicKeyRequest{
 			Name: "",
 		}}, nil, true},
@@ -425,6 +454,16 @@ func TestPKCS11_CreateKey(t *testing.T) {
 				SigningKey: testObject,
 			},
 		}, false},
+		{"Ed25519", args{&apiv1.CreateKeyRequest{
+			Name:               testObject,
+			SignatureAlgorithm: apiv1.PureEd25519,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      testObject,
+			PublicKey: ed25519.PublicKey{},
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: testObject,
+			},
+		}, false},
 		{"fail name", args{&apiv1.CreateKeyRequest{
 			Name: "",
 		}}, nil, true},
@@ -442,10 +481,6 @@ func TestPKCS11_CreateKey(t *testing.T) {
 			Bits:               -1,
 			SignatureAlgorithm: apiv1.SHA256WithRSAPSS,
 		}}, nil, true},
-		{"fail ed25519", args{&apiv1.CreateKeyRequest{
-			Name:               "pkcs11:id=9999;object=create-key",
-			SignatureAlgorithm: apiv1.PureEd25519,
-		}}, nil, true},
 		{"fail unknown", args{&apiv1.CreateKeyRequest{
 			Name:               "pkcs11:id=9999;object=create-key",
 			SignatureAlgorithm: apiv1.SignatureAlgorithm(100),
@@ -532,6 +567,9 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 		{"ECDSA P521", args{&apiv1.CreateSignerRequest{
 			SigningKey: "pkcs11:id=7375;object=ecdsa-p521-key",
 		}}, apiv1.ECDSAWithSHA512, crypto.SHA512, false},
+		{"Ed25519", args{&apiv1.CreateSignerRequest{
+			SigningKey: "pkcs11:id=7378;object=ed25519-key",
+		}}, apiv1.PureEd25519, crypto.Hash(0), false},
 		{"fail SigningKey", args{&apiv1.CreateSignerRequest{
 			SigningKey: "",
 		}}, 0, nil, true},
@@ -552,9 +590,12 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 
 			if got != nil {
 				hash := tt.signerOpts.HashFunc()
-				h := hash.New()
-				h.Write(data)
-				digest := h.Sum(nil)
+				digest := data
+				if hash != crypto.Hash(0) {
+					h := hash.New()
+					h.Write(data)
+					digest = h.Sum(nil)
+				}
 				sig, err := got.Sign(rand.Reader, digest, tt.signerOpts)
 				if err != nil {
 					t.Errorf("cyrpto.Signer.Sign() error = %v", err)
@@ -576,6 +617,11 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 					if !verifyASN1(pub, digest, sig) {
 						t.Error("ecdsa.VerifyASN1() failed")
 					}
+				case apiv1.PureEd25519:
+					pub := got.Public().(ed25519.PublicKey)
+					if !ed25519.Verify(pub, data, sig) {
+						t.Error("ed25519.Verify() failed")
+					}
 				default:
 					t.Errorf("signature algorithm %s is not supported", tt.algorithm)
 				}
@@ -613,6 +659,9 @@ func TestPKCS11_CreateDecrypter(t *testing.T) {
 		{"ECDSA P521", args{&apiv1.CreateDecrypterRequest{
 			DecryptionKey: "pkcs11:id=7375;object=ecdsa-p521-key",
 		}}, true},
+		{"Ed25519", args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "pkcs11:id=7378;object=ed25519-key",
+		}}, true},
 		{"fail DecryptionKey", args{&apiv1.CreateDecrypterRequest{
 			DecryptionKey: "",
 		}}, true},
@@ -818,6 +867,271 @@ func TestPKCS11_StoreCertificate(t *testing.T) {
 	}
 }
 
+func mustCertificateChain(t *testing.T, cn string) (leaf, intermediate, root *x509.Certificate) {
+	t.Helper()
+
+	create := func(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+		b, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(b)
+		require.NoError(t, err)
+		return cert
+	}
+
+	now := time.Now()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	intKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rootTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Chain Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		SerialNumber:          big.NewInt(1),
+	}
+	root = create(rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	intermediate = create(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Chain Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		SerialNumber:          big.NewInt(2),
+	}, root, intKey.Public(), rootKey)
+	leaf = create(&x509.Certificate{
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		SerialNumber: big.NewInt(3),
+	}, intermediate, leafKey.Public(), intKey)
+
+	return
+}
+
+func TestPKCS11_StoreCertificateChain(t *testing.T) {
+	k := setupPKCS11(t)
+
+	leaf, intermediate, root := mustCertificateChain(t, "leaf.example.com")
+	otherLeaf, _, _ := mustCertificateChain(t, "other.example.com")
+
+	// Make sure to delete the created certificates
+	t.Cleanup(func() {
+		_ = k.DeleteCertificate(testObject)
+		_ = k.DeleteCertificate(testObjectAlt)
+		_ = k.p11.DeleteCertificate(intermediate.SubjectKeyId, certificateLabel(intermediate), nil)
+		_ = k.p11.DeleteCertificate(root.SubjectKeyId, certificateLabel(root), nil)
+	})
+
+	type args struct {
+		req *apiv1.StoreCertificateChainRequest
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      []*x509.Certificate
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{&apiv1.StoreCertificateChainRequest{
+			Name:             testObject,
+			CertificateChain: []*x509.Certificate{leaf, intermediate, root},
+		}}, []*x509.Certificate{leaf, intermediate, root}, assert.NoError},
+		{"ok shared intermediate", args{&apiv1.StoreCertificateChainRequest{
+			Name:             testObjectAlt,
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, []*x509.Certificate{leaf, intermediate, root}, assert.NoError},
+		{"fail already exists", args{&apiv1.StoreCertificateChainRequest{
+			Name:             testObject,
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, nil, assert.Error},
+		{"fail name", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "",
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, nil, assert.Error},
+		{"fail empty chain", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:id=7770;object=create-cert",
+			CertificateChain: nil,
+		}}, nil, assert.Error},
+		{"fail nil certificate", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:id=7770;object=create-cert",
+			CertificateChain: []*x509.Certificate{leaf, nil},
+		}}, nil, assert.Error},
+		{"fail wrong issuer", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:id=7770;object=create-cert",
+			CertificateChain: []*x509.Certificate{leaf, root},
+		}}, nil, assert.Error},
+		{"fail wrong signature", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:id=7770;object=create-cert",
+			CertificateChain: []*x509.Certificate{otherLeaf, intermediate},
+		}}, nil, assert.Error},
+		{"fail uri", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "http:id=7770;object=create-cert",
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, nil, assert.Error},
+		{"fail missing id", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:object=create-cert",
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, nil, assert.Error},
+		{"fail missing object", args{&apiv1.StoreCertificateChainRequest{
+			Name:             "pkcs11:id=7770;object=",
+			CertificateChain: []*x509.Certificate{leaf, intermediate},
+		}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.assertion(t, k.StoreCertificateChain(tt.args.req))
+			if tt.want != nil {
+				got, err := k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{
+					Name: tt.args.req.Name,
+				})
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPKCS11_StoreCertificateChain_rollback(t *testing.T) {
+	k := setupPKCS11(t)
+
+	leaf, intermediate, root := mustCertificateChain(t, "leaf.example.com")
+	t.Cleanup(func() {
+		_ = k.DeleteCertificate(testObject)
+		_ = k.p11.DeleteCertificate(intermediate.SubjectKeyId, certificateLabel(intermediate), nil)
+		_ = k.p11.DeleteCertificate(root.SubjectKeyId, certificateLabel(root), nil)
+	})
+
+	// the root fails, the leaf and the intermediate are deleted
+	failing := &PKCS11{p11: &failingImportP11{P11: k.p11, imports: 2}}
+	assert.Error(t, failing.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             testObject,
+		CertificateChain: []*x509.Certificate{leaf, intermediate, root},
+	}))
+	_, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: testObject})
+	assert.Error(t, err)
+	cert, err := k.p11.FindCertificate(intermediate.SubjectKeyId, nil, intermediate.SerialNumber)
+	require.NoError(t, err)
+	assert.Nil(t, cert)
+
+	// the chain can be stored again
+	require.NoError(t, k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             testObject,
+		CertificateChain: []*x509.Certificate{leaf, intermediate, root},
+	}))
+	got, err := k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: testObject})
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{leaf, intermediate, root}, got)
+}
+
+func TestPKCS11_LoadCertificateChain(t *testing.T) {
+	k := setupPKCS11(t)
+
+	leaf, intermediate, _ := mustCertificateChain(t, "leaf.example.com")
+	require.NoError(t, k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             testObject,
+		CertificateChain: []*x509.Certificate{leaf, intermediate},
+	}))
+	t.Cleanup(func() {
+		_ = k.DeleteCertificate(testObject)
+		_ = k.p11.DeleteCertificate(intermediate.SubjectKeyId, certificateLabel(intermediate), nil)
+	})
+
+	type args struct {
+		req *apiv1.LoadCertificateChainRequest
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      []*x509.Certificate
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{&apiv1.LoadCertificateChainRequest{
+			Name: testObject,
+		}}, []*x509.Certificate{leaf, intermediate}, assert.NoError},
+		{"ok by id", args{&apiv1.LoadCertificateChainRequest{
+			Name: testObjectByID,
+		}}, []*x509.Certificate{leaf, intermediate}, assert.NoError},
+		{"ok self-signed", args{&apiv1.LoadCertificateChainRequest{
+			Name: "pkcs11:id=7376;object=test-root",
+		}}, testCerts[0].Certificates, assert.NoError},
+		{"fail name", args{&apiv1.LoadCertificateChainRequest{
+			Name: "",
+		}}, nil, assert.Error},
+		{"fail missing", args{&apiv1.LoadCertificateChainRequest{
+			Name: "pkcs11:id=9999;object=test-missing",
+		}}, nil, assert.Error},
+		{"fail uri", args{&apiv1.LoadCertificateChainRequest{
+			Name: "http:id=7370;object=test-name",
+		}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.LoadCertificateChain(tt.args.req)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_certificateKeyID(t *testing.T) {
+	leaf, intermediate, _ := mustCertificateChain(t, "leaf.example.com")
+	noSKI := *intermediate
+	noSKI.SubjectKeyId = nil
+
+	assert.Equal(t, intermediate.SubjectKeyId, certificateKeyID(intermediate))
+	assert.Equal(t, intermediate.SubjectKeyId, certificateKeyID(&noSKI))
+	assert.Equal(t, intermediate.SubjectKeyId, leaf.AuthorityKeyId)
+}
+
+func Test_findIssuer(t *testing.T) {
+	k := setupPKCS11(t)
+
+	leaf, intermediate, _ := mustCertificateChain(t, "leaf.example.com")
+	require.NoError(t, k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             testObject,
+		CertificateChain: []*x509.Certificate{leaf, intermediate},
+	}))
+	t.Cleanup(func() {
+		_ = k.DeleteCertificate(testObject)
+		_ = k.p11.DeleteCertificate(intermediate.SubjectKeyId, certificateLabel(intermediate), nil)
+	})
+
+	noAKI := *leaf
+	noAKI.AuthorityKeyId = nil
+	otherAKI := *leaf
+	otherAKI.AuthorityKeyId = []byte{1, 2, 3, 4}
+	otherLeaf, _, _ := mustCertificateChain(t, "other.example.com")
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want *x509.Certificate
+	}{
+		{"ok", leaf, intermediate},
+		{"ok no authority key id", &noAKI, intermediate},
+		{"ok unknown authority key id", &otherAKI, intermediate},
+		{"ok not found", otherLeaf, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findIssuer(k.p11, tt.cert)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestPKCS11_DeleteKey(t *testing.T) {
 	k := setupPKCS11(t)
 
@@ -911,6 +1225,17 @@ func TestPKCS11_DeleteCertificate(t *testing.T) {
 	}
 }
 
+func TestPKCS11_RandomReader(t *testing.T) {
+	k := setupPKCS11(t)
+	r, err := k.RandomReader()
+	require.NoError(t, err)
+
+	b := make([]byte, 64)
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	assert.NotEqual(t, make([]byte, 64), b)
+}
+
 func TestPKCS11_Close(t *testing.T) {
 	k := mustPKCS11(t)
 	tests := []struct {
@@ -0,0 +1,416 @@
+//go:build cgo && !nopkcs11
+// +build cgo,!nopkcs11
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+)
+
+var errContextClosed = errors.New("pkcs11 context is closed")
+
+// Stats contains usage statistics of the connection with the PKCS #11
+// module.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Stats struct {
+	// MaxSessions is the maximum number of sessions in the pool.
+	MaxSessions int
+	// InUse is the number of operations in progress. Each operation uses a
+	// session from the pool.
+	InUse int64
+	// Operations is the total number of operations performed.
+	Operations uint64
+	// Errors is the number of operations that have failed.
+	Errors uint64
+	// Reconnects is the number of times the module has been initialized again
+	// after an error.
+	Reconnects uint64
+	// FailedReconnects is the number of times the module has failed to
+	// initialize again.
+	FailedReconnects uint64
+	// LastReconnect is the time of the last successful reconnection.
+	LastReconnect time.Time
+}
+
+// reconnectingP11 is a P11 that initializes again the underlying P11 and logs
+// in when an operation fails because the sessions are no longer valid, after
+// a token reset or a network issue with the HSM. Idempotent operations are
+// retried once after reconnecting.
+type reconnectingP11 struct {
+	mu          sync.RWMutex
+	p11         P11
+	gen         uint64
+	closed      bool
+	configure   func() (P11, error)
+	maxSessions int
+
+	inUse            atomic.Int64
+	operations       atomic.Uint64
+	failures         atomic.Uint64
+	reconnects       atomic.Uint64
+	failedReconnects atomic.Uint64
+	lastReconnect    atomic.Int64
+}
+
+func newReconnectingP11(p11 P11, configure func() (P11, error), maxSessions int) *reconnectingP11 {
+	return &reconnectingP11{
+		p11:         p11,
+		configure:   configure,
+		maxSessions: maxSessions,
+	}
+}
+
+// acquire returns the current P11 and its generation. If a previous
+// reconnection failed, it tries to reconnect again. On success, the read lock
+// is held until the operation calls release, so the P11 is not closed by a
+// reconnection or by Close while it's in use.
+func (r *reconnectingP11) acquire() (P11, uint64, error) {
+	for {
+		r.mu.RLock()
+		p11, gen, closed := r.p11, r.gen, r.closed
+		if !closed && p11 != nil {
+			return p11, gen, nil
+		}
+		r.mu.RUnlock()
+
+		if closed {
+			return nil, 0, errContextClosed
+		}
+		if err := r.reconnect(gen); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// release releases the P11 returned by acquire.
+func (r *reconnectingP11) release() {
+	r.mu.RUnlock()
+}
+
+// call runs the given function with the P11 returned by acquire, and
+// releases it.
+func (r *reconnectingP11) call(p11 P11, gen uint64, fn func(p11 P11, gen uint64) error) error {
+	defer r.release()
+	return fn(p11, gen)
+}
+
+// reconnect closes the P11 with the given generation and configures a new
+// one. If the P11 has already been replaced, it does nothing. It waits for the
+// operations using the P11 to finish before closing it, so the caller must
+// not hold it.
+func (r *reconnectingP11) reconnect(gen uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errContextClosed
+	}
+	if r.gen != gen && r.p11 != nil {
+		return nil
+	}
+	if r.p11 != nil {
+		_ = r.p11.Close()
+		r.p11 = nil
+		r.gen++
+	}
+
+	p11, err := r.configure()
+	if err != nil {
+		r.failedReconnects.Add(1)
+		return errors.Wrap(err, "error initializing PKCS#11 module")
+	}
+
+	r.p11 = p11
+	r.gen++
+	r.reconnects.Add(1)
+	r.lastReconnect.Store(time.Now().UnixNano())
+	return nil
+}
+
+// do runs the given function with the current P11. If the function fails
+// because the sessions are no longer valid, it reconnects, and if retry is
+// true, it runs the function again.
+func (r *reconnectingP11) do(retry bool, fn func(p11 P11, gen uint64) error) error {
+	r.operations.Add(1)
+	r.inUse.Add(1)
+	defer r.inUse.Add(-1)
+
+	err := r.try(retry, fn)
+	if err != nil {
+		r.failures.Add(1)
+	}
+	return err
+}
+
+func (r *reconnectingP11) try(retry bool, fn func(p11 P11, gen uint64) error) error {
+	p11, gen, err := r.acquire()
+	if err != nil {
+		return err
+	}
+	if err = r.call(p11, gen, fn); err == nil || !isSessionError(err) {
+		return err
+	}
+	if rerr := r.reconnect(gen); rerr != nil {
+		return rerr
+	}
+	if !retry {
+		return err
+	}
+	if p11, gen, err = r.acquire(); err != nil {
+		return err
+	}
+	return r.call(p11, gen, fn)
+}
+
+func (r *reconnectingP11) stats() Stats {
+	var lastReconnect time.Time
+	if t := r.lastReconnect.Load(); t != 0 {
+		lastReconnect = time.Unix(0, t)
+	}
+	return Stats{
+		MaxSessions:      r.maxSessions,
+		InUse:            r.inUse.Load(),
+		Operations:       r.operations.Load(),
+		Errors:           r.failures.Load(),
+		Reconnects:       r.reconnects.Load(),
+		FailedReconnects: r.failedReconnects.Load(),
+		LastReconnect:    lastReconnect,
+	}
+}
+
+func (r *reconnectingP11) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	var signer crypto11.Signer
+	err := r.do(true, func(p11 P11, gen uint64) (err error) {
+		signer, err = p11.FindKeyPair(id, label)
+		if err == nil && signer != nil {
+			signer = r.wrapSigner(signer, gen, id, label)
+		}
+		return
+	})
+	return signer, err
+}
+
+func (r *reconnectingP11) FindCertificate(id, label []byte, serial *big.Int) (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	err := r.do(true, func(p11 P11, _ uint64) (err error) {
+		cert, err = p11.FindCertificate(id, label, serial)
+		return
+	})
+	return cert, err
+}
+
+func (r *reconnectingP11) ImportCertificateWithAttributes(template crypto11.AttributeSet, certificate *x509.Certificate) error {
+	return r.do(false, func(p11 P11, _ uint64) error {
+		return p11.ImportCertificateWithAttributes(template, certificate)
+	})
+}
+
+func (r *reconnectingP11) DeleteCertificate(id, label []byte, serial *big.Int) error {
+	return r.do(true, func(p11 P11, _ uint64) error {
+		return p11.DeleteCertificate(id, label, serial)
+	})
+}
+
+func (r *reconnectingP11) GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error) {
+	var signer crypto11.SignerDecrypter
+	err := r.do(false, func(p11 P11, gen uint64) error {
+		s, err := p11.GenerateRSAKeyPairWithAttributes(public, private, bits)
+		if err != nil {
+			return err
+		}
+		id, label := attributeIDAndLabel(public)
+		signer = &reconnectingSignerDecrypter{r.newSigner(s, gen, id, label)}
+		return nil
+	})
+	return signer, err
+}
+
+func (r *reconnectingP11) GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error) {
+	var signer crypto11.Signer
+	err := r.do(false, func(p11 P11, gen uint64) error {
+		s, err := p11.GenerateECDSAKeyPairWithAttributes(public, private, curve)
+		if err != nil {
+			return err
+		}
+		id, label := attributeIDAndLabel(public)
+		signer = r.newSigner(s, gen, id, label)
+		return nil
+	})
+	return signer, err
+}
+
+func (r *reconnectingP11) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	var signer crypto11.Signer
+	err := r.do(false, func(p11 P11, gen uint64) error {
+		s, err := p11.GenerateEd25519KeyPairWithAttributes(public, private)
+		if err != nil {
+			return err
+		}
+		id, label := attributeIDAndLabel(public)
+		signer = r.newSigner(s, gen, id, label)
+		return nil
+	})
+	return signer, err
+}
+
+// NewRandomReader returns a reader that generates random data using the
+// current P11 on every read, so that reads keep working after reconnecting.
+func (r *reconnectingP11) NewRandomReader() (io.Reader, error) {
+	return &reconnectingRandomReader{p11: r}, nil
+}
+
+// Close closes the underlying P11 after the operations in progress finish.
+// After closing, all operations will fail.
+func (r *reconnectingP11) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.p11 != nil {
+		return r.p11.Close()
+	}
+	return nil
+}
+
+func (r *reconnectingP11) newSigner(signer crypto11.Signer, gen uint64, id, label []byte) *reconnectingSigner {
+	return &reconnectingSigner{
+		p11:    r,
+		id:     id,
+		label:  label,
+		gen:    gen,
+		signer: signer,
+		pub:    signer.Public(),
+	}
+}
+
+func (r *reconnectingP11) wrapSigner(signer crypto11.Signer, gen uint64, id, label []byte) crypto11.Signer {
+	s := r.newSigner(signer, gen, id, label)
+	if _, ok := signer.(crypto.Decrypter); ok {
+		return &reconnectingSignerDecrypter{s}
+	}
+	return s
+}
+
+// reconnectingSigner is a crypto11.Signer that finds the key again after a
+// reconnection.
+type reconnectingSigner struct {
+	p11       *reconnectingP11
+	id, label []byte
+	pub       crypto.PublicKey
+
+	mu     sync.Mutex
+	gen    uint64
+	signer crypto11.Signer
+}
+
+// use runs the given function with a signer loaded with the current P11.
+func (s *reconnectingSigner) use(fn func(signer crypto11.Signer) error) error {
+	return s.p11.do(true, func(p11 P11, gen uint64) error {
+		signer, err := s.load(p11, gen)
+		if err != nil {
+			return err
+		}
+		return fn(signer)
+	})
+}
+
+func (s *reconnectingSigner) load(p11 P11, gen uint64) (crypto11.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen == gen {
+		return s.signer, nil
+	}
+	signer, err := p11.FindKeyPair(s.id, s.label)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, errors.New("key not found after reconnecting to the PKCS#11 module")
+	}
+	s.gen, s.signer = gen, signer
+	return signer, nil
+}
+
+// Public returns the public key.
+func (s *reconnectingSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the digest with the private key.
+func (s *reconnectingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	err = s.use(func(signer crypto11.Signer) error {
+		signature, err = signer.Sign(rand, digest, opts)
+		return err
+	})
+	return
+}
+
+// Delete deletes the key pair from the token.
+func (s *reconnectingSigner) Delete() error {
+	return s.use(func(signer crypto11.Signer) error {
+		return signer.Delete()
+	})
+}
+
+// reconnectingSignerDecrypter is the crypto11.SignerDecrypter version of
+// reconnectingSigner.
+type reconnectingSignerDecrypter struct {
+	*reconnectingSigner
+}
+
+// Decrypt decrypts msg with the private key.
+func (s *reconnectingSignerDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
+	err = s.use(func(signer crypto11.Signer) error {
+		d, ok := signer.(crypto.Decrypter)
+		if !ok {
+			return errors.New("key is not a decrypter")
+		}
+		plaintext, err = d.Decrypt(rand, msg, opts)
+		return err
+	})
+	return
+}
+
+func attributeIDAndLabel(template crypto11.AttributeSet) (id, label []byte) {
+	if v := template[crypto11.CkaId]; v != nil {
+		id = v.Value
+	}
+	if v := template[crypto11.CkaLabel]; v != nil {
+		label = v.Value
+	}
+	return
+}
+
+// reconnectingRandomReader is a random reader that reconnects and reads
+// again if the sessions are no longer valid.
+type reconnectingRandomReader struct {
+	p11 *reconnectingP11
+}
+
+func (rr *reconnectingRandomReader) Read(b []byte) (n int, err error) {
+	err = rr.p11.do(true, func(p11 P11, _ uint64) error {
+		r, err := p11.NewRandomReader()
+		if err != nil {
+			return err
+		}
+		n, err = r.Read(b)
+		return err
+	})
+	return
+}
@@ -0,0 +1,571 @@
+//go:build cgo && !nopkcs11 && !softhsm2 && !yubihsm2 && !opensc
+// +build cgo,!nopkcs11,!softhsm2,!yubihsm2,!opensc
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// faultyModule simulates a PKCS #11 module that can fail. All the contexts
+// created share the same token.
+type faultyModule struct {
+	mu        sync.Mutex
+	token     *stubPKCS11
+	contexts  []*faultyP11
+	configErr error
+}
+
+func (m *faultyModule) configure() (P11, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.configErr != nil {
+		return nil, m.configErr
+	}
+	p11 := &faultyP11{stubPKCS11: m.token}
+	m.contexts = append(m.contexts, p11)
+	return p11, nil
+}
+
+func (m *faultyModule) setConfigError(err error) {
+	m.mu.Lock()
+	m.configErr = err
+	m.mu.Unlock()
+}
+
+func (m *faultyModule) context(i int) *faultyP11 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.contexts[i]
+}
+
+func (m *faultyModule) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.contexts)
+}
+
+// faultyP11 is a P11 that fails with the injected error.
+type faultyP11 struct {
+	*stubPKCS11
+	mu          sync.Mutex
+	err         error
+	generateErr error
+	closed      bool
+	// hook is called once on the next FindKeyPair.
+	hook func()
+}
+
+func (p *faultyP11) inject(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+func (p *faultyP11) injectGenerate(err error) {
+	p.mu.Lock()
+	p.generateErr = err
+	p.mu.Unlock()
+}
+
+func (p *faultyP11) setHook(fn func()) {
+	p.mu.Lock()
+	p.hook = fn
+	p.mu.Unlock()
+}
+
+func (p *faultyP11) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *faultyP11) check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.New("context is closed")
+	}
+	return p.err
+}
+
+func (p *faultyP11) checkGenerate() error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.generateErr
+}
+
+func (p *faultyP11) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	hook := p.hook
+	p.hook = nil
+	p.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, err := p.stubPKCS11.FindKeyPair(id, label)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return &faultySigner{Signer: s, p11: p}, nil
+}
+
+func (p *faultyP11) FindCertificate(id, label []byte, serial *big.Int) (*x509.Certificate, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stubPKCS11.FindCertificate(id, label, serial)
+}
+
+func (p *faultyP11) ImportCertificateWithAttributes(template crypto11.AttributeSet, cert *x509.Certificate) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stubPKCS11.ImportCertificateWithAttributes(template, cert)
+}
+
+func (p *faultyP11) DeleteCertificate(id, label []byte, serial *big.Int) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stubPKCS11.DeleteCertificate(id, label, serial)
+}
+
+func (p *faultyP11) GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error) {
+	if err := p.checkGenerate(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, err := p.stubPKCS11.GenerateRSAKeyPairWithAttributes(public, private, bits)
+	if err != nil {
+		return nil, err
+	}
+	return &faultySigner{Signer: s, p11: p}, nil
+}
+
+func (p *faultyP11) GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error) {
+	if err := p.checkGenerate(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, err := p.stubPKCS11.GenerateECDSAKeyPairWithAttributes(public, private, curve)
+	if err != nil {
+		return nil, err
+	}
+	return &faultySigner{Signer: s, p11: p}, nil
+}
+
+func (p *faultyP11) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	if err := p.checkGenerate(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, err := p.stubPKCS11.GenerateEd25519KeyPairWithAttributes(public, private)
+	if err != nil {
+		return nil, err
+	}
+	return &faultySigner{Signer: s, p11: p}, nil
+}
+
+func (p *faultyP11) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *faultyP11) NewRandomReader() (io.Reader, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return &faultyRandomReader{p11: p}, nil
+}
+
+// faultyRandomReader is a random reader that fails with the error injected in
+// the context used to create it.
+type faultyRandomReader struct {
+	p11 *faultyP11
+}
+
+func (r *faultyRandomReader) Read(b []byte) (int, error) {
+	if err := r.p11.check(); err != nil {
+		return 0, err
+	}
+	return rand.Read(b)
+}
+
+// faultySigner is a signer that fails with the error injected in the context
+// used to load it.
+type faultySigner struct {
+	crypto11.Signer
+	p11 *faultyP11
+}
+
+func (s *faultySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := s.p11.check(); err != nil {
+		return nil, err
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func (s *faultySigner) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if err := s.p11.check(); err != nil {
+		return nil, err
+	}
+	return s.Signer.(crypto.Decrypter).Decrypt(rand, msg, opts)
+}
+
+func newFaultyPKCS11(t *testing.T) (*PKCS11, *faultyModule) {
+	t.Helper()
+	m := &faultyModule{
+		token: &stubPKCS11{
+			signerIndex: make(map[keyType]int),
+			certIndex:   make(map[keyType]int),
+		},
+	}
+	p11, err := m.configure()
+	require.NoError(t, err)
+	k := &PKCS11{
+		p11: newReconnectingP11(p11, m.configure, 10),
+	}
+	t.Cleanup(func() {
+		k.Close()
+	})
+	return k, m
+}
+
+func mustCreateKey(t *testing.T, k *PKCS11, name string, alg apiv1.SignatureAlgorithm) {
+	t.Helper()
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               name,
+		SignatureAlgorithm: alg,
+		Bits:               2048,
+	})
+	require.NoError(t, err)
+}
+
+func TestPKCS11_reconnect(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantReconnects uint64
+		assertion      assert.ErrorAssertionFunc
+	}{
+		{"ok session handle invalid", pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID), 1, assert.NoError},
+		{"ok session closed", pkcs11.Error(pkcs11.CKR_SESSION_CLOSED), 1, assert.NoError},
+		{"ok device removed", pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED), 1, assert.NoError},
+		{"ok token not present", pkcs11.Error(pkcs11.CKR_TOKEN_NOT_PRESENT), 1, assert.NoError},
+		{"ok wrapped", errors.Wrap(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED), "wrapped"), 1, assert.NoError},
+		{"fail user not logged in", pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN), 0, assert.Error},
+		{"fail other pkcs11 error", pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID), 0, assert.Error},
+		{"fail other error", errors.New("an error"), 0, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, m := newFaultyPKCS11(t)
+			mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+
+			m.context(0).inject(tt.err)
+			pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+				Name: testObject,
+			})
+			tt.assertion(t, err)
+
+			stats := k.Stats()
+			assert.Equal(t, tt.wantReconnects, stats.Reconnects)
+			assert.Equal(t, int(tt.wantReconnects)+1, m.len())
+			assert.Equal(t, tt.wantReconnects > 0, m.context(0).isClosed())
+			if tt.wantReconnects > 0 {
+				assert.IsType(t, &ecdsa.PublicKey{}, pub)
+				assert.False(t, stats.LastReconnect.IsZero())
+				assert.Equal(t, uint64(0), stats.Errors)
+			} else {
+				assert.Nil(t, pub)
+				assert.True(t, stats.LastReconnect.IsZero())
+				assert.Equal(t, uint64(1), stats.Errors)
+			}
+		})
+	}
+}
+
+func TestPKCS11_reconnect_signer(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+	mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: testObject,
+	})
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("the-message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+	// The signer is loaded again after the reconnection.
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+	sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+	assert.Equal(t, 2, m.len())
+
+	// Signers created before a reconnection use the new context.
+	m.context(1).inject(pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID))
+	sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+	assert.Equal(t, 3, m.len())
+	assert.Equal(t, uint64(2), k.Stats().Reconnects)
+
+	// Errors that do not require a reconnection are returned.
+	m.context(2).inject(errors.New("an error"))
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Error(t, err)
+	assert.Equal(t, 3, m.len())
+}
+
+func TestPKCS11_reconnect_decrypter(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+	mustCreateKey(t, k, testObject, apiv1.SHA256WithRSA)
+
+	decrypter, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{
+		DecryptionKey: testObject,
+	})
+	require.NoError(t, err)
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, decrypter.Public().(*rsa.PublicKey), []byte("the-secret"), nil)
+	require.NoError(t, err)
+
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("the-secret"), plaintext)
+	assert.Equal(t, 2, m.len())
+}
+
+func TestPKCS11_reconnect_randomReader(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+
+	r, err := k.RandomReader()
+	require.NoError(t, err)
+
+	b := make([]byte, 32)
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.len())
+	assert.Equal(t, uint64(1), k.Stats().Reconnects)
+
+	m.context(1).inject(errors.New("an error"))
+	_, err = r.Read(b)
+	assert.Error(t, err)
+}
+
+func TestPKCS11_reconnect_failed(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+	mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: testObject,
+	})
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("the-message"))
+
+	// The token is not available.
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+	m.setConfigError(errors.New("token not found"))
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Error(t, err)
+	_, err = k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: testObject})
+	assert.Error(t, err)
+
+	stats := k.Stats()
+	assert.Equal(t, uint64(0), stats.Reconnects)
+	assert.Equal(t, uint64(2), stats.FailedReconnects)
+	assert.Equal(t, uint64(2), stats.Errors)
+	assert.True(t, m.context(0).isClosed())
+
+	// The token is available again.
+	m.setConfigError(nil)
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+	stats = k.Stats()
+	assert.Equal(t, uint64(1), stats.Reconnects)
+	assert.Equal(t, uint64(2), stats.FailedReconnects)
+	assert.Equal(t, 2, m.len())
+}
+
+func TestPKCS11_reconnect_noRetry(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+
+	// Key generation is not retried, but the next operation uses the new
+	// context.
+	m.context(0).injectGenerate(pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID))
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               testObject,
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, m.len())
+	assert.Equal(t, uint64(1), k.Stats().Reconnects)
+
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               testObject,
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	require.NoError(t, err)
+	assert.IsType(t, &ecdsa.PublicKey{}, resp.PublicKey)
+}
+
+func TestPKCS11_reconnect_concurrent(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+	mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: testObject,
+	})
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("the-message"))
+
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, m.len())
+
+	stats := k.Stats()
+	assert.Equal(t, uint64(1), stats.Reconnects)
+	assert.Equal(t, int64(0), stats.InUse)
+	assert.Equal(t, uint64(0), stats.Errors)
+}
+
+func TestPKCS11_reconnect_inUse(t *testing.T) {
+	k, m := newFaultyPKCS11(t)
+	mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: testObject,
+	})
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("the-message"))
+
+	// An operation is in progress when another one fails.
+	var closedInUse bool
+	started, unblock := make(chan struct{}), make(chan struct{})
+	m.context(0).setHook(func() {
+		close(started)
+		<-unblock
+		closedInUse = m.context(0).isClosed()
+	})
+	getErr := make(chan error, 1)
+	go func() {
+		_, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: testObject})
+		getErr <- err
+	}()
+	<-started
+
+	m.context(0).inject(pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED))
+	signErr := make(chan error, 1)
+	go func() {
+		_, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		signErr <- err
+	}()
+
+	// The reconnection waits for the operation in progress.
+	select {
+	case <-signErr:
+		t.Fatal("Sign() did not wait for the operation in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(unblock)
+
+	assert.NoError(t, <-getErr)
+	assert.NoError(t, <-signErr)
+	assert.False(t, closedInUse)
+	assert.True(t, m.context(0).isClosed())
+	assert.Equal(t, 2, m.len())
+}
+
+func TestPKCS11_Stats(t *testing.T) {
+	k, _ := newFaultyPKCS11(t)
+	assert.Equal(t, Stats{MaxSessions: 10}, k.Stats())
+
+	mustCreateKey(t, k, testObject, apiv1.ECDSAWithSHA256)
+	_, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: testObject})
+	require.NoError(t, err)
+	_, err = k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "pkcs11:id=9999;object=missing"})
+	require.Error(t, err)
+
+	// CreateKey runs FindKeyPair and GenerateECDSAKeyPairWithAttributes. A key
+	// not found is not an error of the module.
+	assert.Equal(t, Stats{
+		MaxSessions: 10,
+		Operations:  4,
+	}, k.Stats())
+
+	require.NoError(t, k.Close())
+	_, err = k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: testObject})
+	assert.Error(t, err)
+	assert.Equal(t, Stats{
+		MaxSessions: 10,
+		Operations:  5,
+		Errors:      1,
+	}, k.Stats())
+
+	// Without reconnection support.
+	assert.Equal(t, Stats{}, mustPKCS11(t).Stats())
+}
@@ -32,6 +32,7 @@ var (
 		{"pkcs11:id=7373;object=ecdsa-p256-key", apiv1.ECDSAWithSHA256, 0},
 		{"pkcs11:id=%73%74;object=ecdsa-p384-key", apiv1.ECDSAWithSHA384, 0},
 		{"pkcs11:id=7375;object=ecdsa-p521-key", apiv1.ECDSAWithSHA512, 0},
+		{"pkcs11:id=7378;object=ed25519-key", apiv1.PureEd25519, 0},
 	}
 
 	testCerts = []struct {
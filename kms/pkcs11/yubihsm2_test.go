@@ -33,7 +33,7 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 		t.Skipf("yubiHSM2 test skipped on %s", runtime.GOOS)
 		return nil
 	}
-	p11, err := crypto11.Configure(&crypto11.Config{
+	p11, err := configure(&crypto11.Config{
 		Path:       path,
 		TokenLabel: "YubiHSM",
 		Pin:        "0001password",
@@ -262,6 +262,11 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	pssPub, err := pemutil.Read("../../pemutil/testdata/pkcs8/openssl.rsapss2048.sha256.pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	nebulaPub := x25519.PublicKey{
 		0x7c, 0x7f, 0x14, 0xf3, 0xe2, 0x44, 0x63, 0xa6,
 		0xb3, 0x1d, 0x71, 0xce, 0xc1, 0x1a, 0x1b, 0xba,
@@ -284,6 +289,8 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 		{"key path uri", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/pub.pem"}}, pub, false},
 		{"key full path uri", args{&apiv1.GetPublicKeyRequest{Name: "softkms:" + fullPath}}, pub, false},
 		{"key full path value", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=" + fullPath}}, pub, false},
+		{"rsapss", args{&apiv1.GetPublicKeyRequest{Name: "../../pemutil/testdata/pkcs8/openssl.rsapss2048.sha256.pub.pem"}}, pssPub, false},
+		{"rsapss cert", args{&apiv1.GetPublicKeyRequest{Name: "../../pemutil/testdata/openssl.rsapss.crt"}}, pssPub, false},
 		{"cert", args{&apiv1.GetPublicKeyRequest{Name: "testdata/cert.crt"}}, pub, false},
 		{"cert uri", args{&apiv1.GetPublicKeyRequest{Name: "softkms:testdata/cert.crt"}}, pub, false},
 		{"cert path uri", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/cert.crt"}}, pub, false},
@@ -327,6 +327,11 @@ func (k *TPMKMS) usesWindowsCertificateStore() bool {
 //   - tss2=true: is set to true, the PrivateKey response will contain a [tss2.TPMKey].
 //   - attest-by=<akName>: attest an application key at creation time with the AK identified by `akName`
 //   - qualifying-data=<random>: hexadecimal coded binary data that can be used to guarantee freshness when attesting creation of a key
+//   - decrypt=true: if set to true, an RSA decryption or ECDH key agreement key will be created instead of a signing key
+//   - handle=<handle>: persist the key at the persistent handle `handle`, so that it isn't loaded on every signature
+//   - hmac=true: if set to true, an HMAC key will be created; `bits` is the digest size, 256 (default), 384 or 512
+//   - aes=true: if set to true, an AES key will be created; `bits` is the key size, 128 (default) or 256
+//   - pin-value=<password>: password required to use an HMAC or AES key
 //
 // Some examples usages:
 //
@@ -341,6 +346,22 @@ func (k *TPMKMS) usesWindowsCertificateStore() bool {
 // Create an application key, attested by `my-ak` with "1234" as the Qualifying Data:
 //
 //	tpmkms:name=my-attested-key;attest-by=my-ak;qualifying-data=61626364
+//
+// Create an application key for decryption or ECDH key agreement:
+//
+//	tpmkms:name=my-decryption-key;decrypt=true
+//
+// Create an application key persisted at the persistent handle 0x81000010:
+//
+//	tpmkms:name=my-persistent-key;handle=0x81000010
+//
+// Create an HMAC key, used through [TPMKMS.CreateHMAC]:
+//
+//	tpmkms:name=my-hmac-key;hmac=true
+//
+// Create an AES key, used through [TPMKMS.CreateSymmetricCipher]:
+//
+//	tpmkms:name=my-aes-key;aes=true
 func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	switch {
 	case req.Name == "":
@@ -355,6 +376,10 @@ func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 	}
 
 	ctx := context.Background()
+	if properties.hmac || properties.aes {
+		return k.createSymmetricKey(ctx, properties, req.Bits)
+	}
+
 	caps, err := k.tpm.GetCapabilities(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not get TPM capabilities: %w", err)
@@ -447,6 +472,7 @@ func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 		config := tpm.CreateKeyConfig{
 			Algorithm: v.Type,
 			Size:      size,
+			Decrypt:   properties.decrypt,
 		}
 		key, err = k.tpm.CreateKey(ctx, properties.name, config)
 		if err != nil {
@@ -457,6 +483,16 @@ func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 		}
 	}
 
+	if properties.handle != 0 {
+		if err := k.tpm.PersistKey(ctx, key.Name(), properties.handle); err != nil {
+			_ = k.tpm.DeleteKey(ctx, key.Name())
+			if errors.Is(err, tpm.ErrExists) {
+				return nil, apiv1.AlreadyExistsError{Message: err.Error()}
+			}
+			return nil, fmt.Errorf("failed persisting key: %w", err)
+		}
+	}
+
 	if properties.tss2 {
 		tpmKey, err := key.ToTSS2(ctx)
 		if err != nil {
@@ -471,6 +507,15 @@ func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 	}
 
 	createdKeyURI := fmt.Sprintf("tpmkms:name=%s", key.Name())
+	if properties.decrypt {
+		// decryption keys can't be used for signing
+		return &apiv1.CreateKeyResponse{
+			Name:       createdKeyURI,
+			PublicKey:  signer.Public(),
+			PrivateKey: privateKey,
+		}, nil
+	}
+
 	if properties.attestBy != "" {
 		createdKeyURI = fmt.Sprintf("%s;attest-by=%s", createdKeyURI, key.AttestedBy())
 	}
@@ -486,6 +531,31 @@ func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 	}, nil
 }
 
+// createSymmetricKey creates an HMAC or AES key. These keys have no public
+// key, so the response only contains the name of the key.
+func (k *TPMKMS) createSymmetricKey(ctx context.Context, properties objectProperties, bits int) (*apiv1.CreateKeyResponse, error) {
+	config := tpm.CreateKeyConfig{
+		Algorithm: "AES",
+		Size:      bits,
+		Password:  properties.password,
+	}
+	if properties.hmac {
+		config.Algorithm = "HMAC"
+	}
+
+	key, err := k.tpm.CreateKey(ctx, properties.name, config)
+	if err != nil {
+		if errors.Is(err, tpm.ErrExists) {
+			return nil, apiv1.AlreadyExistsError{Message: err.Error()}
+		}
+		return nil, fmt.Errorf("failed creating %s key: %w", config.Algorithm, err)
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name: fmt.Sprintf("tpmkms:name=%s", key.Name()),
+	}, nil
+}
+
 // DeleteKey deletes a key identified by name from the TPMKMS.
 //
 // # Experimental
@@ -522,12 +592,21 @@ func (k *TPMKMS) DeleteKey(req *apiv1.DeleteKeyRequest) error {
 //
 //   - name=<name>: specify the name to identify the key with
 //   - path=<file>: specify the TSS2 PEM file to use
+//   - handle=<handle>: persistent handle the TSS2 key is persisted at, so that it isn't loaded on every signature
+//   - pin-value=<password>: password of the key or TSS2 key, if it was created with one
+//   - pin-source=<file>: file containing the password of the key
+//
+// Keys identified by name that were persisted at creation time always use
+// their persistent handle.
 func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	if req.Signer != nil {
 		return req.Signer, nil
 	}
 
-	var pemBytes []byte
+	var (
+		pemBytes []byte
+		tss2Opts []tpm.TSS2SignerOption
+	)
 
 	switch {
 	case req.SigningKey != "":
@@ -546,7 +625,11 @@ func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, er
 			if err != nil {
 				return nil, err
 			}
-			signer, err := key.Signer(ctx)
+			var opts []tpm.SignerOption
+			if properties.password != "" {
+				opts = append(opts, tpm.WithKeyPassword(properties.password))
+			}
+			signer, err := key.Signer(ctx, opts...)
 			if err != nil {
 				return nil, fmt.Errorf("failed getting signer for key %q: %w", properties.name, err)
 			}
@@ -555,6 +638,12 @@ func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, er
 			if pemBytes, err = os.ReadFile(properties.path); err != nil {
 				return nil, fmt.Errorf("failed reading key from %q: %w", properties.path, err)
 			}
+			if properties.handle != 0 {
+				tss2Opts = append(tss2Opts, tpm.WithTSS2KeyHandle(properties.handle))
+			}
+			if properties.password != "" {
+				tss2Opts = append(tss2Opts, tpm.WithTSS2Password(properties.password))
+			}
 		default:
 			return nil, fmt.Errorf("failed parsing %q: name and path cannot be empty", req.SigningKey)
 		}
@@ -571,13 +660,268 @@ func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, er
 	}
 
 	ctx := context.Background()
-	signer, err := tpm.CreateTSS2Signer(ctx, k.tpm, key)
+	signer, err := tpm.CreateTSS2Signer(ctx, k.tpm, key, tss2Opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed getting signer for TSS2 PEM: %w", err)
 	}
 	return signer, nil
 }
 
+// CreateDecrypter creates a [crypto.Decrypter] using an RSA key present in the
+// TPM KMS. The key must have been created with the `decrypt=true` property.
+//
+// The `decryptionKey` in the [apiv1.CreateDecrypterRequest] can be used to
+// specify some key properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the key with
+func (k *TPMKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.Decrypter != nil {
+		return req.Decrypter, nil
+	}
+
+	name, err := decryptionKeyName(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	key, err := k.getKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, err := key.Decrypter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting decrypter for key %q: %w", name, err)
+	}
+	return decrypter, nil
+}
+
+// CreateECDH returns a [tpm.ECDH] that can be used to perform ECDH key
+// agreement using an ECC key present in the TPM KMS. The key must have been
+// created with the `decrypt=true` property.
+//
+// The `decryptionKey` in the [apiv1.CreateDecrypterRequest] can be used to
+// specify some key properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the key with
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) CreateECDH(req *apiv1.CreateDecrypterRequest) (*tpm.ECDH, error) {
+	name, err := decryptionKeyName(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	key, err := k.getKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	e, err := key.ECDH(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting ECDH for key %q: %w", name, err)
+	}
+	return e, nil
+}
+
+// decryptionKeyName returns the name of the key in the decryptionKey of the
+// [apiv1.CreateDecrypterRequest].
+func decryptionKeyName(req *apiv1.CreateDecrypterRequest) (string, error) {
+	switch {
+	case req.DecryptionKey == "" && len(req.DecryptionKeyPEM) > 0:
+		return "", errors.New("createDecrypterRequest 'decryptionKeyPEM' is not supported")
+	case req.DecryptionKey == "":
+		return "", errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
+	}
+
+	properties, err := parseNameURI(req.DecryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing %q: %w", req.DecryptionKey, err)
+	}
+
+	switch {
+	case properties.ak:
+		return "", errors.New("decrypting with an AK is not supported")
+	case properties.path != "":
+		return "", errors.New("decrypting with a TSS2 PEM file is not supported")
+	case properties.name == "":
+		return "", fmt.Errorf("failed parsing %q: name cannot be empty", req.DecryptionKey)
+	}
+
+	return properties.name, nil
+}
+
+// Seal seals a secret using the TPM, and stores it in the TPM KMS. The secret
+// can only be unsealed by the same TPM. At most 128 bytes can be sealed.
+//
+// The `name` can be used to specify some properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the sealed secret with
+//   - pcrs=<pcrs>: comma separated list of SHA-256 PCR indexes the secret is bound to
+//   - pin-value=<password>: password required to unseal the secret
+//   - pin-source=<file>: file containing the password required to unseal the secret
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) Seal(name string, secret []byte) error {
+	properties, err := sealedProperties(name)
+	if err != nil {
+		return err
+	}
+
+	config := tpm.SealConfig{
+		PCRs:     properties.pcrs,
+		Password: properties.password,
+	}
+
+	ctx := context.Background()
+	if _, err := k.tpm.Seal(ctx, properties.name, secret, config); err != nil {
+		if errors.Is(err, tpm.ErrExists) {
+			return apiv1.AlreadyExistsError{Message: err.Error()}
+		}
+		return fmt.Errorf("failed sealing secret %q: %w", properties.name, err)
+	}
+
+	return nil
+}
+
+// Unseal returns a secret sealed using [TPMKMS.Seal]. If the secret is bound
+// to PCRs, it can only be unsealed if the PCRs have the same values they had
+// when the secret was sealed.
+//
+// The `name` can be used to specify some properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the sealed secret with
+//   - pin-value=<password>: password required to unseal the secret
+//   - pin-source=<file>: file containing the password required to unseal the secret
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) Unseal(name string) ([]byte, error) {
+	properties, err := sealedProperties(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	secret, err := k.tpm.Unseal(ctx, properties.name, properties.password)
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+
+	return secret, nil
+}
+
+// sealedProperties parses the name of a sealed secret.
+func sealedProperties(name string) (objectProperties, error) {
+	if name == "" {
+		return objectProperties{}, errors.New("name cannot be empty")
+	}
+
+	properties, err := parseNameURI(name)
+	if err != nil {
+		return properties, fmt.Errorf("failed parsing %q: %w", name, err)
+	}
+
+	switch {
+	case properties.ak, properties.decrypt, properties.attestBy != "":
+		return properties, errors.New(`sealed secrets cannot be combined with "ak", "decrypt" or "attest-by"`)
+	case properties.path != "":
+		return properties, errors.New("sealed secrets cannot be stored in a file")
+	case properties.name == "":
+		return properties, fmt.Errorf("failed parsing %q: name cannot be empty", name)
+	}
+
+	return properties, nil
+}
+
+// CreateHMAC returns an [tpm.HMAC] backed by an HMAC key present in the TPM
+// KMS. The key must have been created with the `hmac=true` property. The
+// returned [tpm.HMAC] implements [hash.Hash]. Use its MAC method to handle
+// TPM errors; Sum only reports them through its Err method.
+//
+// The `name` can be used to specify some properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the key with
+//   - pin-value=<password>: password required to use the key
+//   - pin-source=<file>: file containing the password required to use the key
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) CreateHMAC(name string) (*tpm.HMAC, error) {
+	properties, err := symmetricKeyProperties(name)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := k.tpm.GetHMAC(context.Background(), properties.name, properties.password)
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+
+	return h, nil
+}
+
+// CreateSymmetricCipher returns a [tpm.SymmetricCipher] backed by an AES key
+// present in the TPM KMS. The key must have been created with the `aes=true`
+// property. It encrypts and decrypts data in CFB or CTR mode.
+//
+// The `name` can be used to specify some properties. These are as follows:
+//
+//   - name=<name>: specify the name to identify the key with
+//   - pin-value=<password>: password required to use the key
+//   - pin-source=<file>: file containing the password required to use the key
+//
+// # Experimental
+//
+// Notice: This method is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) CreateSymmetricCipher(name string) (*tpm.SymmetricCipher, error) {
+	properties, err := symmetricKeyProperties(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := k.tpm.GetSymmetricCipher(context.Background(), properties.name, properties.password)
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+
+	return c, nil
+}
+
+// symmetricKeyProperties parses the name of an HMAC or AES key.
+func symmetricKeyProperties(name string) (objectProperties, error) {
+	if name == "" {
+		return objectProperties{}, errors.New("name cannot be empty")
+	}
+
+	properties, err := parseNameURI(name)
+	if err != nil {
+		return properties, fmt.Errorf("failed parsing %q: %w", name, err)
+	}
+
+	switch {
+	case properties.ak, properties.decrypt, properties.attestBy != "":
+		return properties, errors.New(`HMAC and AES keys cannot be combined with "ak", "decrypt" or "attest-by"`)
+	case properties.path != "":
+		return properties, errors.New("HMAC and AES keys cannot be stored in a file")
+	case properties.name == "":
+		return properties, fmt.Errorf("failed parsing %q: name cannot be empty", name)
+	}
+
+	return properties, nil
+}
+
 // GetPublicKey returns the public key present in the TPM KMS.
 //
 // The `name` in the [apiv1.GetPublicKeyRequest] can be used to specify some key
@@ -657,11 +1001,24 @@ func (k *TPMKMS) LoadCertificate(req *apiv1.LoadCertificateRequest) (cert *x509.
 
 // LoadCertificateChain loads the certificate chain for the key identified by
 // name from the TPMKMS.
+//
+// If the `nv-index` property is set in the name, the certificate chain is read
+// from that NV index instead. The NV index password can be provided using the
+// `pin-value` or `pin-source` properties.
 func (k *TPMKMS) LoadCertificateChain(req *apiv1.LoadCertificateChainRequest) ([]*x509.Certificate, error) {
 	if req.Name == "" {
 		return nil, errors.New("loadCertificateChainRequest 'name' cannot be empty")
 	}
 
+	properties, err := parseNameURI(req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %q: %w", req.Name, err)
+	}
+
+	if properties.nvIndex != 0 {
+		return k.loadCertificateChainFromNV(properties)
+	}
+
 	if k.usesWindowsCertificateStore() {
 		chain, err := k.loadCertificateChainFromWindowsCertificateStore(&apiv1.LoadCertificateRequest{
 			Name: req.Name,
@@ -672,11 +1029,6 @@ func (k *TPMKMS) LoadCertificateChain(req *apiv1.LoadCertificateChainRequest) ([
 		return chain, nil
 	}
 
-	properties, err := parseNameURI(req.Name)
-	if err != nil {
-		return nil, fmt.Errorf("failed parsing %q: %w", req.Name, err)
-	}
-
 	ctx := context.Background()
 	var chain []*x509.Certificate
 	if properties.ak {
@@ -797,6 +1149,18 @@ func (k *TPMKMS) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 }
 
 // StoreCertificateChain stores the certificate for the key identified by name to the TPMKMS.
+//
+// If the `nv-index` property is set in the name, the certificate chain is
+// written to that NV index instead, as concatenated DER certificates. The NV
+// index is defined, or redefined if it's too small to fit the certificate
+// chain. The NV index password can be provided using the `pin-value` or
+// `pin-source` properties, and the owner hierarchy password used to define it
+// using the `owner-pin-value` or `owner-pin-source` properties. The
+// `nv-attributes` property sets the attributes used to define the NV index, as
+// a comma separated list of `owner-write`, `auth-write`, `write-define`,
+// `write-stclear`, `owner-read`, `auth-read` and `no-da`. New NV indexes
+// default to `owner-write,auth-write,owner-read,auth-read,no-da`, and
+// redefined ones keep their attributes.
 func (k *TPMKMS) StoreCertificateChain(req *apiv1.StoreCertificateChainRequest) error {
 	switch {
 	case req.Name == "":
@@ -805,6 +1169,15 @@ func (k *TPMKMS) StoreCertificateChain(req *apiv1.StoreCertificateChainRequest)
 		return errors.New("storeCertificateChainRequest 'certificateChain' cannot be empty")
 	}
 
+	properties, err := parseNameURI(req.Name)
+	if err != nil {
+		return fmt.Errorf("failed parsing %q: %w", req.Name, err)
+	}
+
+	if properties.nvIndex != 0 {
+		return k.storeCertificateChainToNV(properties, req.CertificateChain)
+	}
+
 	if k.usesWindowsCertificateStore() {
 		if err := k.storeCertificateChainToWindowsCertificateStore(&apiv1.StoreCertificateChainRequest{
 			Name:             req.Name,
@@ -816,11 +1189,6 @@ func (k *TPMKMS) StoreCertificateChain(req *apiv1.StoreCertificateChainRequest)
 		return nil
 	}
 
-	properties, err := parseNameURI(req.Name)
-	if err != nil {
-		return fmt.Errorf("failed parsing %q: %w", req.Name, err)
-	}
-
 	ctx := context.Background()
 	if properties.ak {
 		ak, err := k.getAK(ctx, properties.name)
@@ -942,6 +1310,138 @@ func (k *TPMKMS) storeIntermediateToWindowsCertificateStore(c *x509.Certificate,
 	return nil
 }
 
+// loadCertificateChainFromNV reads the certificate chain stored in the NV
+// index in the properties.
+func (k *TPMKMS) loadCertificateChainFromNV(properties objectProperties) ([]*x509.Certificate, error) {
+	ctx := context.Background()
+	data, err := k.tpm.ReadNV(ctx, properties.nvIndex, tpm.NVAuth{Password: properties.password})
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+
+	chain, err := parseNVCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing certificate chain from NV index 0x%08x: %w", properties.nvIndex, err)
+	}
+
+	return chain, nil
+}
+
+// defaultNVAttributes are the attributes of the NV indexes defined to store
+// certificate chains if the "nv-attributes" property is not set.
+const defaultNVAttributes = tpm.DefaultNVAttributes | tpm.NVNoDA
+
+// storeCertificateChainToNV writes the certificate chain to the NV index in
+// the properties. The NV index is defined if it doesn't exist. If it exists
+// and the certificate chain fits in it, it's overwritten, padded with zeros.
+// Otherwise the NV index is redefined, and its previous contents are restored
+// if the certificate chain can't be stored.
+func (k *TPMKMS) storeCertificateChainToNV(properties objectProperties, chain []*x509.Certificate) error {
+	var data []byte
+	for _, c := range chain {
+		data = append(data, c.Raw...)
+	}
+
+	ctx := context.Background()
+	index, auth := properties.nvIndex, tpm.NVAuth{Password: properties.password}
+	nv, err := k.tpm.GetNV(ctx, index)
+	switch {
+	case errors.Is(err, tpm.ErrNotFound):
+		attrs := properties.nvAttributes
+		if attrs == 0 {
+			attrs = defaultNVAttributes
+		}
+		return k.defineAndWriteNV(ctx, properties, len(data), attrs, data)
+	case err != nil:
+		return fmt.Errorf("failed getting NV index 0x%08x: %w", index, err)
+	case nv.WriteLocked():
+		return fmt.Errorf("failed storing certificate chain to NV index 0x%08x: NV index is locked for writing", index)
+	case nv.Size >= len(data):
+		padded := make([]byte, nv.Size)
+		copy(padded, data)
+		if err := k.tpm.WriteNV(ctx, index, padded, auth); err != nil {
+			return fmt.Errorf("failed storing certificate chain to NV index 0x%08x: %w", index, err)
+		}
+		return nil
+	}
+
+	// The NV index is too small; it's redefined keeping its attributes,
+	// unless others are set. Its contents are read first, so that they can
+	// be restored on failure.
+	previous, err := k.tpm.ReadNV(ctx, index, auth)
+	if err != nil {
+		return fmt.Errorf("failed reading NV index 0x%08x: %w", index, err)
+	}
+	previousAttrs := nv.Attributes &^ (tpm.NVWritten | tpm.NVWriteLocked)
+	attrs := properties.nvAttributes
+	if attrs == 0 {
+		attrs = previousAttrs
+	}
+	if err := k.tpm.UndefineNV(ctx, index, properties.ownerPassword); err != nil {
+		return fmt.Errorf("failed undefining NV index 0x%08x: %w", index, err)
+	}
+	if err := k.defineAndWriteNV(ctx, properties, len(data), attrs, data); err != nil {
+		if rerr := k.defineAndWriteNV(ctx, properties, nv.Size, previousAttrs, previous); rerr != nil {
+			return fmt.Errorf("%w; failed restoring NV index 0x%08x: %w", err, index, rerr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// defineAndWriteNV defines the NV index in the properties and writes data to
+// it. The NV index is undefined if it can't be written.
+func (k *TPMKMS) defineAndWriteNV(ctx context.Context, properties objectProperties, size int, attrs tpm.NVAttributes, data []byte) error {
+	index := properties.nvIndex
+	if err := k.tpm.DefineNV(ctx, index, tpm.NVConfig{
+		Size:          size,
+		Attributes:    attrs,
+		Password:      properties.password,
+		OwnerPassword: properties.ownerPassword,
+	}); err != nil {
+		return fmt.Errorf("failed defining NV index 0x%08x: %w", index, err)
+	}
+	if err := k.tpm.WriteNV(ctx, index, data, tpm.NVAuth{Password: properties.password}); err != nil {
+		_ = k.tpm.UndefineNV(ctx, index, properties.ownerPassword)
+		return fmt.Errorf("failed storing certificate chain to NV index 0x%08x: %w", index, err)
+	}
+	return nil
+}
+
+// deleteCertificateFromNV undefines the NV index in the properties.
+func (k *TPMKMS) deleteCertificateFromNV(properties objectProperties) error {
+	ctx := context.Background()
+	if err := k.tpm.UndefineNV(ctx, properties.nvIndex, properties.ownerPassword); err != nil {
+		return notFoundError(err)
+	}
+	return nil
+}
+
+// parseNVCertificates parses the DER certificates stored in an NV index. NV
+// indexes can be larger than the certificates stored in them, so parsing stops
+// at the first byte that doesn't start a DER sequence.
+func parseNVCertificates(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for len(data) > 0 && data[0] == 0x30 {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &raw)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+		data = rest
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return chain, nil
+}
+
 // DeleteCertificate deletes a certificate for the key identified by name from the
 // TPMKMS. If the instance is configured to use the Windows certificate store, it'll
 // delete the certificate from the certificate store, backed by a CAPIKMS instance.
@@ -950,6 +1450,10 @@ func (k *TPMKMS) storeIntermediateToWindowsCertificateStore(c *x509.Certificate,
 // or serial. This is only supported if the instance is configured to use the Windows
 // certificate store.
 //
+// If the `nv-index` property is set in the name, the NV index storing the
+// certificate chain is undefined. The owner hierarchy password can be provided
+// using the `owner-pin-value` or `owner-pin-source` properties.
+//
 // # Experimental
 //
 // Notice: This method is EXPERIMENTAL and may be changed or removed in a later
@@ -959,6 +1463,15 @@ func (k *TPMKMS) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
 		return errors.New("deleteCertificateRequest 'name' cannot be empty")
 	}
 
+	properties, err := parseNameURI(req.Name)
+	if err != nil {
+		return fmt.Errorf("failed parsing %q: %w", req.Name, err)
+	}
+
+	if properties.nvIndex != 0 {
+		return k.deleteCertificateFromNV(properties)
+	}
+
 	if k.usesWindowsCertificateStore() {
 		if err := k.deleteCertificateFromWindowsCertificateStore(&apiv1.DeleteCertificateRequest{
 			Name: req.Name,
@@ -973,11 +1486,6 @@ func (k *TPMKMS) DeleteCertificate(req *apiv1.DeleteCertificateRequest) error {
 	// certificate store storage will be different, and may need different behavior when
 	// implementing certificate management.
 
-	properties, err := parseNameURI(req.Name)
-	if err != nil {
-		return fmt.Errorf("failed parsing %q: %w", req.Name, err)
-	}
-
 	ctx := context.Background()
 	if properties.ak {
 		ak, err := k.getAK(ctx, properties.name)
@@ -1405,6 +1913,7 @@ type deletingCertificateChainManager interface {
 
 var _ apiv1.KeyManager = (*TPMKMS)(nil)
 var _ apiv1.Attester = (*TPMKMS)(nil)
+var _ apiv1.Decrypter = (*TPMKMS)(nil)
 var _ apiv1.CertificateManager = (*TPMKMS)(nil)
 var _ apiv1.CertificateChainManager = (*TPMKMS)(nil)
 var _ deletingCertificateChainManager = (*TPMKMS)(nil)
@@ -6,6 +6,7 @@ package tpmkms
 import (
 	"context"
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -741,6 +742,289 @@ func TestTPMKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func withDecryptionKey(name, algorithm string, size int) newSimulatedTPMPreparerOption {
+	return func(t *testing.T, tpm *tpmp.TPM) {
+		t.Helper()
+		config := tpmp.CreateKeyConfig{
+			Algorithm: algorithm,
+			Size:      size,
+			Decrypt:   true,
+		}
+		_, err := tpm.CreateKey(context.Background(), name, config)
+		require.NoError(t, err)
+	}
+}
+
+func TestTPMKMS_CreateKey_decrypt(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	k := &TPMKMS{
+		tpm: tpm,
+	}
+
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "tpmkms:name=rsa-key;decrypt=true",
+		SignatureAlgorithm: apiv1.SHA256WithRSA,
+		Bits:               2048,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "tpmkms:name=rsa-key", resp.Name)
+	assert.Nil(t, resp.CreateSignerRequest.Signer)
+	pub, ok := resp.PublicKey.(*rsa.PublicKey)
+	require.True(t, ok)
+
+	decrypter, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{
+		DecryptionKey: resp.Name,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, pub, decrypter.Public())
+
+	msg := []byte("the-secret-message")
+	ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, msg, nil)
+	require.NoError(t, err)
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	require.NoError(t, err)
+	assert.Equal(t, msg, plaintext)
+
+	resp, err = k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "tpmkms:name=ecc-key;decrypt=true",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "tpmkms:name=ecc-key", resp.Name)
+	assert.IsType(t, &ecdsa.PublicKey{}, resp.PublicKey)
+
+	e, err := k.CreateECDH(&apiv1.CreateDecrypterRequest{
+		DecryptionKey: resp.Name,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, resp.PublicKey, e.Public())
+
+	peer, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	got, err := e.ECDH(peer.PublicKey())
+	require.NoError(t, err)
+	want, err := peer.ECDH(e.PublicKey())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestTPMKMS_CreateDecrypter(t *testing.T) {
+	tpm := newSimulatedTPM(t, withKey("key1"), withDecryptionKey("rsa-key", "RSA", 2048), withDecryptionKey("ecc-key", "ECDSA", 256))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		req    *apiv1.CreateDecrypterRequest
+		expErr error
+	}{
+		{"ok/decrypter", &apiv1.CreateDecrypterRequest{Decrypter: key}, nil},
+		{"ok/decryption-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=rsa-key"}, nil},
+		{"fail/empty", &apiv1.CreateDecrypterRequest{}, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")},
+		{"fail/pem", &apiv1.CreateDecrypterRequest{DecryptionKeyPEM: []byte("pem")}, errors.New("createDecrypterRequest 'decryptionKeyPEM' is not supported")},
+		{"fail/uri", &apiv1.CreateDecrypterRequest{DecryptionKey: "baduri:"}, errors.New(`failed parsing "baduri:": URI scheme "baduri" is not supported`)},
+		{"fail/ak", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=ak1;ak=true"}, errors.New("decrypting with an AK is not supported")},
+		{"fail/path", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:path=testdata/key.pem"}, errors.New("decrypting with a TSS2 PEM file is not supported")},
+		{"fail/unknown-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=unknown-key"}, errors.New(`failed getting key "unknown-key": not found`)},
+		{"fail/signing-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=key1"}, errors.New(`failed getting decrypter for key "key1": key "key1" is not a decryption key`)},
+		{"fail/ecc-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=ecc-key"}, errors.New(`failed getting decrypter for key "ecc-key": key "ecc-key" is not an RSA key`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &TPMKMS{
+				tpm: tpm,
+			}
+			got, err := k.CreateDecrypter(tt.req)
+			if tt.expErr != nil {
+				assert.EqualError(t, err, tt.expErr.Error())
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, got)
+		})
+	}
+}
+
+func TestTPMKMS_CreateECDH(t *testing.T) {
+	tpm := newSimulatedTPM(t, withKey("key1"), withDecryptionKey("rsa-key", "RSA", 2048), withDecryptionKey("ecc-key", "ECDSA", 384))
+
+	tests := []struct {
+		name   string
+		req    *apiv1.CreateDecrypterRequest
+		expErr error
+	}{
+		{"ok", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=ecc-key"}, nil},
+		{"fail/empty", &apiv1.CreateDecrypterRequest{}, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")},
+		{"fail/unknown-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=unknown-key"}, errors.New(`failed getting key "unknown-key": not found`)},
+		{"fail/signing-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=key1"}, errors.New(`failed getting ECDH for key "key1": key "key1" is not a decryption key`)},
+		{"fail/rsa-key", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpmkms:name=rsa-key"}, errors.New(`failed getting ECDH for key "rsa-key": key "rsa-key" is not an ECC key`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &TPMKMS{
+				tpm: tpm,
+			}
+			got, err := k.CreateECDH(tt.req)
+			if tt.expErr != nil {
+				assert.EqualError(t, err, tt.expErr.Error())
+				return
+			}
+
+			assert.NoError(t, err)
+			if assert.NotNil(t, got) {
+				assert.Equal(t, ecdh.P384(), got.Curve())
+			}
+		})
+	}
+}
+
+func TestTPMKMS_Seal_Unseal(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	k := &TPMKMS{
+		tpm: tpm,
+	}
+	secret := []byte("the sealed secret")
+
+	// seal a secret without and with a policy
+	require.NoError(t, k.Seal("tpmkms:name=secret1", secret))
+	require.NoError(t, k.Seal("tpmkms:name=secret2;pcrs=16;pin-value=pass", secret))
+
+	tests := []struct {
+		name   string
+		seal   string
+		unseal string
+		expErr error
+	}{
+		{"ok", "", "tpmkms:name=secret1", nil},
+		{"ok/policy", "", "tpmkms:name=secret2;pin-value=pass", nil},
+		{"fail/empty", "", "", errors.New("name cannot be empty")},
+		{"fail/not-found", "", "tpmkms:name=unknown", errors.New(`failed getting sealed data "unknown": not found`)},
+		{"fail/password-required", "", "tpmkms:name=secret2", errors.New(`failed unsealing data "secret2": password is required`)},
+		{"fail/ak", "", "tpmkms:name=secret1;ak=true", errors.New(`sealed secrets cannot be combined with "ak", "decrypt" or "attest-by"`)},
+		{"fail/path", "", "tpmkms:path=/tmp/secret", errors.New("sealed secrets cannot be stored in a file")},
+		{"fail/seal-exists", "tpmkms:name=secret1", "", errors.New(`failed sealing data "secret1": already exists`)},
+		{"fail/seal-invalid-pcrs", "tpmkms:name=secret3;pcrs=a", "", errors.New(`failed parsing "tpmkms:name=secret3;pcrs=a": failed parsing "pcrs": invalid PCR index "a"`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.seal != "" {
+				err := k.Seal(tt.seal, secret)
+				assert.EqualError(t, err, tt.expErr.Error())
+				return
+			}
+
+			got, err := k.Unseal(tt.unseal)
+			if tt.expErr != nil {
+				assert.EqualError(t, err, tt.expErr.Error())
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, secret, got)
+		})
+	}
+}
+
+func TestTPMKMS_HMAC_SymmetricCipher(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	k := &TPMKMS{
+		tpm: tpm,
+	}
+	data := []byte("the data to authenticate and encrypt")
+	iv := []byte("0123456789abcdef")
+
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:name=hmac;hmac=true", Bits: 384})
+	require.NoError(t, err)
+	assert.Equal(t, &apiv1.CreateKeyResponse{Name: "tpmkms:name=hmac"}, resp)
+	resp, err = k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:name=aes;aes=true;pin-value=pass"})
+	require.NoError(t, err)
+	assert.Equal(t, &apiv1.CreateKeyResponse{Name: "tpmkms:name=aes"}, resp)
+
+	_, err = k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:name=hmac;hmac=true"})
+	assert.ErrorIs(t, err, apiv1.AlreadyExistsError{})
+	_, err = k.CreateKey(&apiv1.CreateKeyRequest{Name: "tpmkms:name=aes-192;aes=true", Bits: 192})
+	assert.Error(t, err)
+
+	h, err := k.CreateHMAC("tpmkms:name=hmac")
+	require.NoError(t, err)
+	_, err = h.Write(data)
+	require.NoError(t, err)
+	mac, err := h.MAC()
+	require.NoError(t, err)
+	assert.Len(t, mac, 48)
+	assert.Equal(t, mac, h.Sum(nil))
+
+	c, err := k.CreateSymmetricCipher("tpmkms:name=aes;pin-value=pass")
+	require.NoError(t, err)
+	ciphertext, err := c.Encrypt(tpmp.CipherModeCFB, iv, data)
+	require.NoError(t, err)
+	plaintext, err := c.Decrypt(tpmp.CipherModeCFB, iv, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, data, plaintext)
+
+	_, err = k.CreateHMAC("")
+	assert.EqualError(t, err, "name cannot be empty")
+	_, err = k.CreateHMAC("tpmkms:name=unknown")
+	assert.ErrorIs(t, err, apiv1.NotFoundError{})
+	_, err = k.CreateHMAC("tpmkms:name=aes")
+	assert.EqualError(t, err, `key "aes" is not an HMAC key`)
+	_, err = k.CreateSymmetricCipher("tpmkms:name=hmac;ak=true")
+	assert.EqualError(t, err, `HMAC and AES keys cannot be combined with "ak", "decrypt" or "attest-by"`)
+	_, err = k.CreateSymmetricCipher("tpmkms:path=/tmp/aes.pem")
+	assert.EqualError(t, err, "HMAC and AES keys cannot be stored in a file")
+	_, err = k.CreateSymmetricCipher("tpmkms:name=unknown")
+	assert.ErrorIs(t, err, apiv1.NotFoundError{})
+}
+
+func TestTPMKMS_persistentHandle(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	k := &TPMKMS{
+		tpm: tpm,
+	}
+	ctx := context.Background()
+	digest := []byte("the digest to sign 1234567890123")
+
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "tpmkms:name=key1;handle=0x81000020;tss2=true",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	require.NoError(t, err)
+
+	key, err := tpm.GetKey(ctx, "key1")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x81000020), key.PersistentHandle())
+
+	_, err = k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "tpmkms:name=key2;handle=0x81000020",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+	})
+	assert.ErrorIs(t, err, apiv1.AlreadyExistsError{})
+	_, err = tpm.GetKey(ctx, "key2")
+	assert.ErrorIs(t, err, tpmp.ErrNotFound)
+
+	// signers for keys in storage and for TSS2 keys use the persistent handle
+	pemBytes, err := resp.PrivateKey.(*tss2.TPMKey).EncodeToMemory()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tss2.pem")
+	require.NoError(t, os.WriteFile(path, pemBytes, 0600))
+
+	for _, signingKey := range []string{"tpmkms:name=key1", "tpmkms:path=" + path + ";handle=0x81000020"} {
+		signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: signingKey})
+		require.NoError(t, err)
+		sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+		require.NoError(t, err)
+		assert.True(t, ecdsa.VerifyASN1(resp.PublicKey.(*ecdsa.PublicKey), digest, sig))
+	}
+
+	// deleting the key evicts it
+	require.NoError(t, k.DeleteKey(&apiv1.DeleteKeyRequest{Name: "tpmkms:name=key1"}))
+	handles, err := tpm.ListPersistentHandles(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, handles, uint32(0x81000020))
+}
+
 func TestTPMKMS_GetPublicKey(t *testing.T) {
 	tpmWithKey := newSimulatedTPM(t, withKey("key1"))
 	_, err := tpmWithKey.CreateAK(context.Background(), "ak1")
@@ -1616,6 +1900,128 @@ func (c *customAttestationClient) Attest(context.Context) ([]*x509.Certificate,
 	return c.chain, nil
 }
 
+func TestTPMKMS_NVCertificateChain(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	k := &TPMKMS{
+		tpm: tpm,
+	}
+
+	ca, err := minica.New()
+	require.NoError(t, err)
+	newCert := func(cn string) *x509.Certificate {
+		pub, _, err := keyutil.GenerateDefaultKeyPair()
+		require.NoError(t, err)
+		cert, err := ca.Sign(&x509.Certificate{
+			Subject:   pkix.Name{CommonName: cn},
+			PublicKey: pub,
+		})
+		require.NoError(t, err)
+		return cert
+	}
+	cert := newCert("device")
+	renewed := newCert("device renewed with a longer common name")
+
+	name := "tpmkms:nv-index=0x01500000;pin-value=nv-pass"
+
+	_, err = k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: name})
+	assert.ErrorIs(t, err, apiv1.NotFoundError{})
+
+	err = k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             name,
+		CertificateChain: []*x509.Certificate{cert, ca.Intermediate},
+	})
+	require.NoError(t, err)
+
+	chain, err := k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: name})
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{cert, ca.Intermediate}, chain)
+
+	nv, err := tpm.GetNV(context.Background(), 0x01500000)
+	require.NoError(t, err)
+	chainSize := len(cert.Raw) + len(ca.Intermediate.Raw)
+	assert.Equal(t, chainSize, nv.Size)
+
+	// the NV index is overwritten if the certificate chain fits
+	err = k.StoreCertificate(&apiv1.StoreCertificateRequest{Name: name, Certificate: renewed})
+	require.NoError(t, err)
+
+	got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: name})
+	require.NoError(t, err)
+	assert.Equal(t, renewed, got)
+	chain, err = k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: name})
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{renewed}, chain)
+
+	nv, err = tpm.GetNV(context.Background(), 0x01500000)
+	require.NoError(t, err)
+	assert.Equal(t, chainSize, nv.Size)
+
+	// the previous certificate chain is kept if the NV index can't be
+	// redefined
+	longer := []*x509.Certificate{renewed, ca.Intermediate, ca.Root}
+	err = k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             name + ";nv-attributes=auth-read,write-define",
+		CertificateChain: longer,
+	})
+	assert.Error(t, err)
+
+	chain, err = k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: name})
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{renewed}, chain)
+
+	nv, err = tpm.GetNV(context.Background(), 0x01500000)
+	require.NoError(t, err)
+	assert.Equal(t, chainSize, nv.Size)
+	assert.Equal(t, defaultNVAttributes, nv.Attributes&^(tpmp.NVWritten|tpmp.NVWriteLocked))
+
+	// the NV index is redefined if the certificate chain doesn't fit
+	err = k.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+		Name:             name,
+		CertificateChain: longer,
+	})
+	require.NoError(t, err)
+
+	chain, err = k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: name})
+	require.NoError(t, err)
+	assert.Equal(t, longer, chain)
+
+	nv, err = tpm.GetNV(context.Background(), 0x01500000)
+	require.NoError(t, err)
+	assert.Equal(t, len(renewed.Raw)+len(ca.Intermediate.Raw)+len(ca.Root.Raw), nv.Size)
+
+	// the NV index password is required
+	_, err = k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: "tpmkms:nv-index=0x01500000;pin-value=wrong"})
+	assert.Error(t, err)
+
+	err = k.DeleteCertificate(&apiv1.DeleteCertificateRequest{Name: name})
+	require.NoError(t, err)
+
+	_, err = k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: name})
+	assert.ErrorIs(t, err, apiv1.NotFoundError{})
+
+	err = k.DeleteCertificate(&apiv1.DeleteCertificateRequest{Name: name})
+	assert.ErrorIs(t, err, apiv1.NotFoundError{})
+
+	// the NV index is defined with the given attributes, and the owner
+	// password is used to undefine it
+	withAttributes := "tpmkms:nv-index=0x01500001;pin-value=nv-pass;nv-attributes=owner-write,auth-write,auth-read"
+	err = k.StoreCertificate(&apiv1.StoreCertificateRequest{Name: withAttributes, Certificate: cert})
+	require.NoError(t, err)
+
+	nv, err = tpm.GetNV(context.Background(), 0x01500001)
+	require.NoError(t, err)
+	assert.Equal(t, tpmp.NVOwnerWrite|tpmp.NVAuthWrite|tpmp.NVAuthRead, nv.Attributes&^tpmp.NVWritten)
+
+	got, err = k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: withAttributes})
+	require.NoError(t, err)
+	assert.Equal(t, cert, got)
+
+	err = k.DeleteCertificate(&apiv1.DeleteCertificateRequest{Name: "tpmkms:nv-index=0x01500001;owner-pin-value=wrong"})
+	assert.Error(t, err)
+	err = k.DeleteCertificate(&apiv1.DeleteCertificateRequest{Name: withAttributes})
+	require.NoError(t, err)
+}
+
 func TestTPMKMS_CreateAttestation(t *testing.T) {
 	ctx := context.Background()
 	tpm := newSimulatedTPM(t)
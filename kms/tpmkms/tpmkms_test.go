@@ -2,6 +2,7 @@ package tpmkms
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/asn1"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/tpm"
 	"go.step.sm/crypto/tpm/tss2"
 )
@@ -152,3 +154,37 @@ func Test_SetPreferredSignatureAlgorithms(t *testing.T) {
 func Test_PreferredSignatureAlgorithms(t *testing.T) {
 	assert.Equal(t, PreferredSignatureAlgorithms(), preferredSignatureAlgorithms)
 }
+
+func Test_parseNVCertificates(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	chain := append(append([]byte{}, ca.Intermediate.Raw...), ca.Root.Raw...)
+	padded := append(append([]byte{}, chain...), 0xff, 0xff, 0xff, 0xff)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []*x509.Certificate
+		wantErr bool
+	}{
+		{"ok", chain, []*x509.Certificate{ca.Intermediate, ca.Root}, false},
+		{"ok/padded", padded, []*x509.Certificate{ca.Intermediate, ca.Root}, false},
+		{"ok/single", ca.Intermediate.Raw, []*x509.Certificate{ca.Intermediate}, false},
+		{"fail/empty", nil, nil, true},
+		{"fail/not-a-certificate", []byte{0xff, 0xff}, nil, true},
+		{"fail/truncated", chain[:len(chain)-10], nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNVCertificates(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
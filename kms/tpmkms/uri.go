@@ -6,14 +6,27 @@ package tpmkms
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"go.step.sm/crypto/kms/uri"
+	"go.step.sm/crypto/tpm"
 )
 
 type objectProperties struct {
 	name                      string
 	ak                        bool
 	tss2                      bool
+	decrypt                   bool
+	hmac                      bool
+	aes                       bool
+	pcrs                      []int
+	nvIndex                   uint32
+	nvAttributes              tpm.NVAttributes
+	handle                    uint32
+	password                  string
+	ownerPassword             string
 	attestBy                  string
 	qualifyingData            []byte
 	path                      string
@@ -36,7 +49,33 @@ func parseNameURI(nameURI string) (o objectProperties, err error) {
 	var parseErr error
 	if u, parseErr = uri.ParseWithScheme(Scheme, nameURI); parseErr == nil {
 		o.path = u.Get("path")
-		if name := u.Get("name"); name == "" && o.path == "" {
+		if v := u.Get("nv-index"); v != "" {
+			nvIndex, err := strconv.ParseUint(v, 0, 32)
+			if err != nil || nvIndex == 0 {
+				return o, fmt.Errorf("failed parsing %q: invalid NV index %q", "nv-index", v)
+			}
+			o.nvIndex = uint32(nvIndex)
+		}
+		if v := u.Get("nv-attributes"); v != "" {
+			if o.nvAttributes, err = parseNVAttributes(v); err != nil {
+				return o, fmt.Errorf("failed parsing %q: %w", "nv-attributes", err)
+			}
+		}
+		if v := u.Get("owner-pin-value"); v != "" {
+			o.ownerPassword = v
+		} else if b, err := u.Read("owner-pin-source"); err != nil {
+			return o, fmt.Errorf("failed reading %q: %w", "owner-pin-source", err)
+		} else if b != nil {
+			o.ownerPassword = strings.TrimRightFunc(string(b), unicode.IsSpace)
+		}
+		if v := u.Get("handle"); v != "" {
+			handle, err := strconv.ParseUint(v, 0, 32)
+			if err != nil || handle == 0 {
+				return o, fmt.Errorf("failed parsing %q: invalid persistent handle %q", "handle", v)
+			}
+			o.handle = uint32(handle)
+		}
+		if name := u.Get("name"); name == "" && o.path == "" && o.nvIndex == 0 {
 			if len(u.Values) == 1 {
 				o.name = u.Opaque
 			} else {
@@ -53,7 +92,20 @@ func parseNameURI(nameURI string) (o objectProperties, err error) {
 
 		o.ak = u.GetBool("ak")
 		o.tss2 = u.GetBool("tss2")
+		o.decrypt = u.GetBool("decrypt")
+		o.hmac = u.GetBool("hmac")
+		o.aes = u.GetBool("aes")
 		o.attestBy = u.Get("attest-by")
+		o.password = u.Pin()
+		if pcrs := u.Get("pcrs"); pcrs != "" {
+			for _, v := range strings.Split(pcrs, ",") {
+				pcr, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil {
+					return o, fmt.Errorf("failed parsing %q: invalid PCR index %q", "pcrs", v)
+				}
+				o.pcrs = append(o.pcrs, pcr)
+			}
+		}
 		if qualifyingData := u.GetEncoded("qualifying-data"); qualifyingData != nil {
 			o.qualifyingData = qualifyingData
 		}
@@ -74,6 +126,24 @@ func parseNameURI(nameURI string) (o objectProperties, err error) {
 		if o.ak && o.attestBy != "" {
 			return o, errors.New(`"ak" and "attest-by" are mutually exclusive`)
 		}
+		if o.decrypt && (o.ak || o.attestBy != "") {
+			return o, errors.New(`"decrypt" cannot be combined with "ak" or "attest-by"`)
+		}
+		if o.nvIndex != 0 && (o.ak || o.path != "") {
+			return o, errors.New(`"nv-index" cannot be combined with "ak" or "path"`)
+		}
+		if o.nvIndex == 0 && (o.nvAttributes != 0 || o.ownerPassword != "") {
+			return o, errors.New(`"nv-attributes", "owner-pin-value" and "owner-pin-source" require "nv-index"`)
+		}
+		if o.handle != 0 && (o.ak || o.decrypt) {
+			return o, errors.New(`"handle" cannot be combined with "ak" or "decrypt"`)
+		}
+		if o.hmac && o.aes {
+			return o, errors.New(`"hmac" and "aes" are mutually exclusive`)
+		}
+		if (o.hmac || o.aes) && (o.ak || o.attestBy != "" || o.decrypt || o.handle != 0) {
+			return o, errors.New(`"hmac" and "aes" cannot be combined with "ak", "attest-by", "decrypt" or "handle"`)
+		}
 
 		return
 	}
@@ -87,3 +157,29 @@ func parseNameURI(nameURI string) (o objectProperties, err error) {
 	o.name = nameURI // assumes there's no other properties encoded; just a name
 	return
 }
+
+// nvAttributeNames maps the names used in the "nv-attributes" property to
+// NV index attributes.
+var nvAttributeNames = map[string]tpm.NVAttributes{
+	"owner-write":   tpm.NVOwnerWrite,
+	"auth-write":    tpm.NVAuthWrite,
+	"write-define":  tpm.NVWriteDefine,
+	"write-stclear": tpm.NVWriteSTClear,
+	"owner-read":    tpm.NVOwnerRead,
+	"auth-read":     tpm.NVAuthRead,
+	"no-da":         tpm.NVNoDA,
+}
+
+// parseNVAttributes parses a comma separated list of NV index attribute
+// names, e.g. "owner-write,auth-write,auth-read".
+func parseNVAttributes(s string) (tpm.NVAttributes, error) {
+	var attrs tpm.NVAttributes
+	for _, v := range strings.Split(s, ",") {
+		attr, ok := nvAttributeNames[strings.TrimSpace(v)]
+		if !ok {
+			return 0, fmt.Errorf("invalid NV index attribute %q", v)
+		}
+		attrs |= attr
+	}
+	return attrs, nil
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"go.step.sm/crypto/tpm"
 )
 
 func Test_parseNameURI(t *testing.T) {
@@ -22,7 +24,34 @@ func Test_parseNameURI(t *testing.T) {
 		{"ok/key-without-name-key-with-other-properties", args{"tpmkms:key1;attest-by=ak1"}, objectProperties{name: "key1", attestBy: "ak1"}, false},
 		{"ok/attested-key", args{"tpmkms:name=key2;attest-by=ak1;qualifying-data=61626364"}, objectProperties{name: "key2", attestBy: "ak1", qualifyingData: []byte{'a', 'b', 'c', 'd'}}, false},
 		{"ok/ak", args{"tpmkms:name=ak1;ak=true"}, objectProperties{name: "ak1", ak: true}, false},
+		{"ok/decrypt", args{"tpmkms:name=key3;decrypt=true"}, objectProperties{name: "key3", decrypt: true}, false},
+		{"ok/sealed", args{"tpmkms:name=secret1;pcrs=0,7,16;pin-value=pass"}, objectProperties{name: "secret1", pcrs: []int{0, 7, 16}, password: "pass"}, false},
+		{"ok/nv-index", args{"tpmkms:nv-index=0x01500000"}, objectProperties{nvIndex: 0x01500000}, false},
+		{"ok/nv-index-decimal", args{"tpmkms:nv-index=22020096;pin-value=pass"}, objectProperties{nvIndex: 0x01500000, password: "pass"}, false},
+		{"ok/nv-index-attributes", args{"tpmkms:nv-index=0x01500000;nv-attributes=owner-write,auth-read,no-da;owner-pin-value=owner"}, objectProperties{nvIndex: 0x01500000, nvAttributes: tpm.NVOwnerWrite | tpm.NVAuthRead | tpm.NVNoDA, ownerPassword: "owner"}, false},
+		{"ok/handle", args{"tpmkms:name=key1;handle=0x81000010"}, objectProperties{name: "key1", handle: 0x81000010}, false},
+		{"ok/hmac", args{"tpmkms:name=key4;hmac=true;pin-value=pass"}, objectProperties{name: "key4", hmac: true, password: "pass"}, false},
+		{"ok/aes", args{"tpmkms:name=key5;aes=true"}, objectProperties{name: "key5", aes: true}, false},
+		{"ok/path-handle", args{"tpmkms:path=/path/to/key.pem;handle=0x81000010"}, objectProperties{path: "/path/to/key.pem", handle: 0x81000010}, false},
 		{"fail/empty", args{""}, objectProperties{}, true},
+		{"fail/decrypt-ak", args{"tpmkms:name=ak1;ak=true;decrypt=true"}, objectProperties{}, true},
+		{"fail/decrypt-attest-by", args{"tpmkms:name=key3;attest-by=ak1;decrypt=true"}, objectProperties{}, true},
+		{"fail/pcrs", args{"tpmkms:name=secret1;pcrs=0,seven"}, objectProperties{}, true},
+		{"fail/nv-index", args{"tpmkms:nv-index=abc"}, objectProperties{}, true},
+		{"fail/nv-index-zero", args{"tpmkms:nv-index=0"}, objectProperties{}, true},
+		{"fail/nv-index-ak", args{"tpmkms:nv-index=0x01500000;ak=true"}, objectProperties{}, true},
+		{"fail/nv-attributes", args{"tpmkms:nv-index=0x01500000;nv-attributes=owner-write,written"}, objectProperties{}, true},
+		{"fail/nv-attributes-without-nv-index", args{"tpmkms:name=key1;nv-attributes=owner-write"}, objectProperties{}, true},
+		{"fail/owner-pin-without-nv-index", args{"tpmkms:name=key1;owner-pin-value=owner"}, objectProperties{}, true},
+		{"fail/owner-pin-source", args{"tpmkms:nv-index=0x01500000;owner-pin-source=testdata/missing.txt"}, objectProperties{}, true},
+		{"fail/handle", args{"tpmkms:name=key1;handle=abc"}, objectProperties{}, true},
+		{"fail/handle-ak", args{"tpmkms:name=ak1;ak=true;handle=0x81000010"}, objectProperties{}, true},
+		{"fail/handle-decrypt", args{"tpmkms:name=key3;decrypt=true;handle=0x81000010"}, objectProperties{}, true},
+		{"fail/hmac-aes", args{"tpmkms:name=key4;hmac=true;aes=true"}, objectProperties{}, true},
+		{"fail/hmac-ak", args{"tpmkms:name=key4;hmac=true;ak=true"}, objectProperties{}, true},
+		{"fail/hmac-decrypt", args{"tpmkms:name=key4;hmac=true;decrypt=true"}, objectProperties{}, true},
+		{"fail/aes-attest-by", args{"tpmkms:name=key5;aes=true;attest-by=ak1"}, objectProperties{}, true},
+		{"fail/aes-handle", args{"tpmkms:name=key5;aes=true;handle=0x81000010"}, objectProperties{}, true},
 		{"fail/wrong-scheme", args{nameURI: "tpmkmz:name=bla"}, objectProperties{}, true},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,408 @@
+//go:build cgo && !noyubikey
+// +build cgo,!noyubikey
+
+package yubikey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"unicode"
+
+	"github.com/go-piv/piv-go/v2/piv"
+	"github.com/pkg/errors"
+
+	"go.step.sm/crypto/kms/uri"
+)
+
+// managementKeySize is the size of the 3DES management key used by default on
+// YubiKeys.
+const managementKeySize = 24
+
+// Secret is a string with a PIN, PUK or hex-encoded management key. The fmt
+// and log/slog packages print a redacted value instead of the secret, so it
+// can be safely added to logs and errors.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Secret string
+
+// Format implements fmt.Formatter and prints a redacted value.
+func (s Secret) Format(f fmt.State, _ rune) {
+	io.WriteString(f, s.redacted())
+}
+
+// LogValue implements slog.LogValuer and returns a redacted value.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.redacted())
+}
+
+// MarshalText implements encoding.TextMarshaler and returns a redacted value.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.redacted()), nil
+}
+
+func (s Secret) redacted() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// ChangePINRequest is the parameter used in YubiKey.ChangePIN.
+//
+// The secrets can also be set in the URI, using the pin-value or pin-source
+// parameters for the current PIN, and the new-pin-value or new-pin-source
+// parameters for the new one, e.g.:
+//
+//	yubikey:pin-source=/run/yubikey.pin;new-pin-source=/run/yubikey-new.pin
+//
+// If the current PIN is not set, the one used to open the YubiKey is used.
+type ChangePINRequest struct {
+	URI    string
+	PIN    Secret
+	NewPIN Secret
+}
+
+// ChangePUKRequest is the parameter used in YubiKey.ChangePUK.
+//
+// The secrets can also be set in the URI, using the puk-value or puk-source
+// parameters for the current PUK, and the new-puk-value or new-puk-source
+// parameters for the new one.
+type ChangePUKRequest struct {
+	URI    string
+	PUK    Secret
+	NewPUK Secret
+}
+
+// UnblockPINRequest is the parameter used in YubiKey.UnblockPIN.
+//
+// The secrets can also be set in the URI, using the puk-value or puk-source
+// parameters for the PUK, and the new-pin-value or new-pin-source parameters
+// for the new PIN.
+type UnblockPINRequest struct {
+	URI    string
+	PUK    Secret
+	NewPIN Secret
+}
+
+// SetManagementKeyRequest is the parameter used in YubiKey.SetManagementKey.
+//
+// The new hex-encoded management key can also be set in the URI, using the
+// new-management-key or new-management-key-source parameters, and the
+// protection with the pin-protected parameter, e.g.:
+//
+//	yubikey:new-management-key-source=/run/management.key
+//	yubikey:pin-protected=true
+//
+// If PINProtected is true, the management key is stored in the PIN-protected
+// data object of the YubiKey, and it can be loaded using the
+// pin-protected-management-key=true parameter in the URI passed to New. If
+// PINProtected is true and the ManagementKey is empty, a random management key
+// is generated.
+type SetManagementKeyRequest struct {
+	URI           string
+	ManagementKey Secret
+	PINProtected  bool
+}
+
+// SetRetriesRequest is the parameter used in YubiKey.SetRetries. The number of
+// retries must be between 1 and 255.
+type SetRetriesRequest struct {
+	PINRetries int
+	PUKRetries int
+}
+
+// ChangePIN changes the PIN of the YubiKey. After a successful change, the new
+// PIN is used in the operations that require it.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) ChangePIN(req *ChangePINRequest) error {
+	pin, newPIN := req.PIN, req.NewPIN
+	if err := readSecrets(req.URI, map[string]*Secret{
+		"pin":     &pin,
+		"new-pin": &newPIN,
+	}); err != nil {
+		return err
+	}
+	if pin == "" {
+		pin = Secret(k.pin)
+	}
+	if newPIN == "" {
+		return errors.New("changePINRequest 'NewPIN' cannot be empty")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.SetPIN(string(pin), string(newPIN)); err != nil {
+		return errors.Wrap(err, "error changing pin")
+	}
+	k.pin = string(newPIN)
+	return nil
+}
+
+// ChangePUK changes the PUK of the YubiKey.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) ChangePUK(req *ChangePUKRequest) error {
+	puk, newPUK := req.PUK, req.NewPUK
+	if err := readSecrets(req.URI, map[string]*Secret{
+		"puk":     &puk,
+		"new-puk": &newPUK,
+	}); err != nil {
+		return err
+	}
+	switch {
+	case puk == "":
+		return errors.New("changePUKRequest 'PUK' cannot be empty")
+	case newPUK == "":
+		return errors.New("changePUKRequest 'NewPUK' cannot be empty")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.SetPUK(string(puk), string(newPUK)); err != nil {
+		return errors.Wrap(err, "error changing puk")
+	}
+	return nil
+}
+
+// UnblockPIN uses the PUK to set a new PIN after the PIN has been blocked by
+// too many failed attempts. After a successful unblock, the new PIN is used in
+// the operations that require it.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) UnblockPIN(req *UnblockPINRequest) error {
+	puk, newPIN := req.PUK, req.NewPIN
+	if err := readSecrets(req.URI, map[string]*Secret{
+		"puk":     &puk,
+		"new-pin": &newPIN,
+	}); err != nil {
+		return err
+	}
+	switch {
+	case puk == "":
+		return errors.New("unblockPINRequest 'PUK' cannot be empty")
+	case newPIN == "":
+		return errors.New("unblockPINRequest 'NewPIN' cannot be empty")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.Unblock(string(puk), string(newPIN)); err != nil {
+		return errors.Wrap(err, "error unblocking pin")
+	}
+	k.pin = string(newPIN)
+	return nil
+}
+
+// PINRetries returns the number of attempts remaining to enter the correct
+// PIN.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) PINRetries() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	n, err := k.yk.Retries()
+	if err != nil {
+		return 0, errors.Wrap(err, "error getting pin retries")
+	}
+	return n, nil
+}
+
+// SetManagementKey replaces the management key of the YubiKey, authenticating
+// with the current one. After a successful change, the new management key is
+// used in the operations that require it.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) SetManagementKey(req *SetManagementKeyRequest) error {
+	hexKey, protected := req.ManagementKey, req.PINProtected
+	if req.URI != "" {
+		u, err := parseSecretsURI(req.URI)
+		if err != nil {
+			return err
+		}
+		if hexKey == "" {
+			if hexKey, err = readSecret(u, "new-management-key", "new-management-key", "new-management-key-source"); err != nil {
+				return err
+			}
+		}
+		protected = protected || u.GetBool("pin-protected")
+	}
+
+	var newKey []byte
+	switch {
+	case hexKey != "":
+		b, err := decodeManagementKey(string(hexKey))
+		if err != nil {
+			return err
+		}
+		newKey = b
+	case protected:
+		newKey = make([]byte, managementKeySize)
+		if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+			return errors.Wrap(err, "error generating management key")
+		}
+	default:
+		return errors.New("setManagementKeyRequest 'ManagementKey' cannot be empty")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.SetManagementKey(k.managementKey, newKey); err != nil {
+		return errors.Wrap(err, "error setting management key")
+	}
+
+	// If the new key cannot be stored, the previous one is restored, so the
+	// YubiKey is not left with a management key that is not known.
+	if protected {
+		if err := k.yk.SetMetadata(newKey, &piv.Metadata{ManagementKey: &newKey}); err != nil {
+			if rerr := k.yk.SetManagementKey(newKey, k.managementKey); rerr != nil {
+				return errors.Wrapf(err, "error storing pin-protected management key and restoring the previous one: %v", rerr)
+			}
+			return errors.Wrap(err, "error storing pin-protected management key")
+		}
+	}
+	k.managementKey = newKey
+	return nil
+}
+
+// SetRetries sets the number of retries allowed for the PIN and the PUK. This
+// operation requires the management key and the PIN.
+//
+// IMPORTANT: Changing the number of retries resets the PIN and the PUK to
+// their default values, 123456 and 12345678. It is highly recommended to
+// follow it with ChangePIN and ChangePUK.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) SetRetries(req *SetRetriesRequest) error {
+	if req.PINRetries < 1 || req.PINRetries > 255 || req.PUKRetries < 1 || req.PUKRetries > 255 {
+		return errors.New("setRetriesRequest 'PINRetries' and 'PUKRetries' must be between 1 and 255")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.SetRetries(k.managementKey, k.pin, req.PINRetries, req.PUKRetries); err != nil {
+		return errors.Wrap(err, "error setting retries")
+	}
+	k.pin = piv.DefaultPIN
+	return nil
+}
+
+// Reset resets the PIV application of the YubiKey to its factory settings. It
+// deletes all the keys and certificates, and it resets the PIN, PUK, and
+// management key to their default values. This method blocks the PIN and the
+// PUK before resetting the device.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) Reset() error {
+	m.Lock()
+	defer m.Unlock()
+	if err := k.yk.Reset(); err != nil {
+		return errors.Wrap(err, "error resetting yubikey")
+	}
+	k.pin = piv.DefaultPIN
+	k.managementKey = append([]byte(nil), piv.DefaultManagementKey...)
+	return nil
+}
+
+// loadProtectedManagementKey returns the management key stored in the
+// PIN-protected data object of the YubiKey.
+func loadProtectedManagementKey(yk pivKey, pin string) ([]byte, error) {
+	md, err := yk.Metadata(pin)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading pin-protected management key")
+	}
+	if md.ManagementKey == nil || len(*md.ManagementKey) == 0 {
+		return nil, errors.New("error reading pin-protected management key: key not found")
+	}
+	return *md.ManagementKey, nil
+}
+
+// decodeManagementKey decodes a hex-encoded management key. The errors do not
+// contain the key.
+func decodeManagementKey(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("error decoding management key: invalid hex encoding")
+	}
+	if len(b) != managementKeySize {
+		return nil, errors.Errorf("invalid managementKey: length is not %d bytes", managementKeySize)
+	}
+	return b, nil
+}
+
+// readSecrets sets the empty secrets in the given map using the values in the
+// URI. The keys of the map are the prefixes of the -value and -source
+// parameters.
+func readSecrets(rawuri string, secrets map[string]*Secret) error {
+	if rawuri == "" {
+		return nil
+	}
+	u, err := parseSecretsURI(rawuri)
+	if err != nil {
+		return err
+	}
+	for name, s := range secrets {
+		if *s != "" {
+			continue
+		}
+		if *s, err = readSecret(u, name, name+"-value", name+"-source"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSecretsURI parses a URI with secrets. The errors returned by the uri
+// package contain the full URI, so they are replaced by one without it.
+func parseSecretsURI(rawuri string) (*uri.URI, error) {
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return nil, errors.Errorf("error parsing uri: uri is not a valid %s uri", Scheme)
+	}
+	return u, nil
+}
+
+// readSecret returns the secret in the valueKey parameter of the URI, or the
+// content of the file in the sourceKey parameter, without the surrounding
+// white spaces.
+func readSecret(u *uri.URI, name, valueKey, sourceKey string) (Secret, error) {
+	if v := u.Get(valueKey); v != "" {
+		return Secret(v), nil
+	}
+	if !u.Has(sourceKey) {
+		return "", nil
+	}
+	b, err := u.Read(sourceKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading %s", name)
+	}
+	return Secret(bytes.TrimFunc(b, unicode.IsSpace)), nil
+}
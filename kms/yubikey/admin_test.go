@@ -0,0 +1,351 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-piv/piv-go/v2/piv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func mustSecretFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(filename, []byte(content+"\n"), 0600))
+	return filename
+}
+
+func TestSecret(t *testing.T) {
+	s := Secret("123456")
+	for _, format := range []string{"%v", "%s", "%q", "%d", "%x", "%#v", "%+v"} {
+		assert.Equal(t, "[REDACTED]", fmt.Sprintf(format, s), format)
+	}
+	assert.Equal(t, "{[REDACTED]}", fmt.Sprintf("%v", struct{ PIN Secret }{"123456"}))
+	assert.Empty(t, fmt.Sprint(Secret("")))
+
+	b, err := json.Marshal(ChangePINRequest{PIN: "123456", NewPIN: "654321"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"URI":"","PIN":"[REDACTED]","NewPIN":"[REDACTED]"}`, string(b))
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("change pin", "pin", s)
+	assert.Contains(t, buf.String(), "pin=[REDACTED]")
+	assert.NotContains(t, buf.String(), "123456")
+}
+
+func TestYubiKey_ChangePIN(t *testing.T) {
+	oldPINFile := mustSecretFile(t, "old.pin", "123456")
+	newPINFile := mustSecretFile(t, "new.pin", "654321")
+
+	tests := []struct {
+		name      string
+		pin       string
+		req       *ChangePINRequest
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", "", &ChangePINRequest{PIN: "123456", NewPIN: "654321"}, assert.NoError},
+		{"ok with configured pin", "123456", &ChangePINRequest{NewPIN: "654321"}, assert.NoError},
+		{"ok with uri values", "", &ChangePINRequest{URI: "yubikey:pin-value=123456;new-pin-value=654321"}, assert.NoError},
+		{"ok with uri sources", "", &ChangePINRequest{URI: "yubikey:pin-source=" + oldPINFile + ";new-pin-source=" + newPINFile}, assert.NoError},
+		{"ok fields over uri", "", &ChangePINRequest{URI: "yubikey:pin-value=000000;new-pin-value=000000", PIN: "123456", NewPIN: "654321"}, assert.NoError},
+		{"fail uri", "123456", &ChangePINRequest{URI: "pkcs11:new-pin-value=654321"}, assert.Error},
+		{"fail source", "123456", &ChangePINRequest{URI: "yubikey:new-pin-source=missing.pin"}, assert.Error},
+		{"fail missing new pin", "123456", &ChangePINRequest{}, assert.Error},
+		{"fail invalid pin", "", &ChangePINRequest{PIN: "111111", NewPIN: "654321"}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk, pin: tt.pin}
+			err := k.ChangePIN(tt.req)
+			tt.assertion(t, err)
+			if err != nil {
+				assert.Equal(t, "123456", yk.pin)
+				assert.NotContains(t, err.Error(), "654321")
+				return
+			}
+			assert.Equal(t, "654321", yk.pin)
+			assert.Equal(t, "654321", k.pin)
+		})
+	}
+}
+
+func TestYubiKey_ChangePUK(t *testing.T) {
+	newPUKFile := mustSecretFile(t, "new.puk", "87654321")
+
+	tests := []struct {
+		name      string
+		req       *ChangePUKRequest
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", &ChangePUKRequest{PUK: "12345678", NewPUK: "87654321"}, assert.NoError},
+		{"ok with uri", &ChangePUKRequest{URI: "yubikey:puk-value=12345678;new-puk-source=" + newPUKFile}, assert.NoError},
+		{"fail uri", &ChangePUKRequest{URI: "yubikey:puk-value=%zz"}, assert.Error},
+		{"fail missing puk", &ChangePUKRequest{NewPUK: "87654321"}, assert.Error},
+		{"fail missing new puk", &ChangePUKRequest{PUK: "12345678"}, assert.Error},
+		{"fail invalid puk", &ChangePUKRequest{PUK: "11111111", NewPUK: "87654321"}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk, pin: "123456"}
+			err := k.ChangePUK(tt.req)
+			tt.assertion(t, err)
+			if err != nil {
+				assert.Equal(t, piv.DefaultPUK, yk.puk)
+				return
+			}
+			assert.Equal(t, "87654321", yk.puk)
+			assert.Equal(t, "123456", k.pin)
+		})
+	}
+}
+
+func TestYubiKey_UnblockPIN(t *testing.T) {
+	pukFile := mustSecretFile(t, "puk", "12345678")
+
+	tests := []struct {
+		name      string
+		req       *UnblockPINRequest
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", &UnblockPINRequest{PUK: "12345678", NewPIN: "654321"}, assert.NoError},
+		{"ok with uri", &UnblockPINRequest{URI: "yubikey:puk-source=" + pukFile + ";new-pin-value=654321"}, assert.NoError},
+		{"fail source", &UnblockPINRequest{URI: "yubikey:puk-source=missing.puk;new-pin-value=654321"}, assert.Error},
+		{"fail missing puk", &UnblockPINRequest{NewPIN: "654321"}, assert.Error},
+		{"fail missing new pin", &UnblockPINRequest{PUK: "12345678"}, assert.Error},
+		{"fail invalid puk", &UnblockPINRequest{PUK: "11111111", NewPIN: "654321"}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk, pin: "000000"}
+			err := k.UnblockPIN(tt.req)
+			tt.assertion(t, err)
+			if err != nil {
+				assert.Equal(t, "000000", k.pin)
+				return
+			}
+			assert.Equal(t, "654321", yk.pin)
+			assert.Equal(t, "654321", k.pin)
+		})
+	}
+}
+
+func TestYubiKey_PINRetries(t *testing.T) {
+	yk1 := newStubPivKey(t, ECDSA)
+	yk2 := newStubPivKey(t, ECDSA)
+	yk2.adminErr = errors.New("some error")
+
+	tests := []struct {
+		name      string
+		yk        pivKey
+		want      int
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", yk1, 3, assert.NoError},
+		{"fail", yk2, 0, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{yk: tt.yk}
+			got, err := k.PINRetries()
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestYubiKey_SetManagementKey(t *testing.T) {
+	newKey := []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+	}
+	newKeyHex := hex.EncodeToString(newKey)
+	newKeyFile := mustSecretFile(t, "management.key", newKeyHex)
+
+	failMetadata := func(yk *stubPivKey) { yk.adminErr = errors.New("some error") }
+
+	tests := []struct {
+		name          string
+		managementKey []byte
+		modify        func(*stubPivKey)
+		req           *SetManagementKeyRequest
+		wantKey       []byte
+		wantProtected bool
+		assertion     assert.ErrorAssertionFunc
+	}{
+		{"ok", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{ManagementKey: Secret(newKeyHex)}, newKey, false, assert.NoError},
+		{"ok with uri", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{URI: "yubikey:new-management-key=" + newKeyHex}, newKey, false, assert.NoError},
+		{"ok with uri source", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{URI: "yubikey:new-management-key-source=" + newKeyFile}, newKey, false, assert.NoError},
+		{"ok protected", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{ManagementKey: Secret(newKeyHex), PINProtected: true}, newKey, true, assert.NoError},
+		{"ok protected with uri", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{URI: "yubikey:pin-protected=true;new-management-key=" + newKeyHex}, newKey, true, assert.NoError},
+		{"ok protected random", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{PINProtected: true}, nil, true, assert.NoError},
+		{"fail uri", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{URI: "yubi:new-management-key=" + newKeyHex}, nil, false, assert.Error},
+		{"fail uri source", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{URI: "yubikey:new-management-key-source=missing.key"}, nil, false, assert.Error},
+		{"fail missing key", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{}, nil, false, assert.Error},
+		{"fail decode", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{ManagementKey: "xxyyzz"}, nil, false, assert.Error},
+		{"fail size", piv.DefaultManagementKey, nil, &SetManagementKeyRequest{ManagementKey: "00112233"}, nil, false, assert.Error},
+		{"fail invalid management key", newKey, nil, &SetManagementKeyRequest{ManagementKey: Secret(newKeyHex)}, nil, false, assert.Error},
+		{"fail metadata", piv.DefaultManagementKey, failMetadata, &SetManagementKeyRequest{ManagementKey: Secret(newKeyHex), PINProtected: true}, nil, false, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			if tt.modify != nil {
+				tt.modify(yk)
+			}
+			k := &YubiKey{yk: yk, pin: "123456", managementKey: tt.managementKey}
+			err := k.SetManagementKey(tt.req)
+			tt.assertion(t, err)
+			if err != nil {
+				assert.NotContains(t, err.Error(), newKeyHex)
+				assert.Equal(t, tt.managementKey, k.managementKey)
+				assert.Equal(t, piv.DefaultManagementKey, yk.managementKey)
+				return
+			}
+
+			assert.Len(t, k.managementKey, 24)
+			assert.Equal(t, k.managementKey, yk.managementKey)
+			if tt.wantKey != nil {
+				assert.Equal(t, tt.wantKey, k.managementKey)
+			} else {
+				assert.NotEqual(t, piv.DefaultManagementKey, k.managementKey)
+			}
+			if tt.wantProtected {
+				got, err := loadProtectedManagementKey(yk, "123456")
+				require.NoError(t, err)
+				assert.Equal(t, k.managementKey, got)
+			} else {
+				assert.Nil(t, yk.metadata)
+			}
+		})
+	}
+}
+
+func TestYubiKey_SetRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		pin           string
+		managementKey []byte
+		req           *SetRetriesRequest
+		assertion     assert.ErrorAssertionFunc
+	}{
+		{"ok", "123456", piv.DefaultManagementKey, &SetRetriesRequest{PINRetries: 5, PUKRetries: 4}, assert.NoError},
+		{"ok limits", "123456", piv.DefaultManagementKey, &SetRetriesRequest{PINRetries: 255, PUKRetries: 1}, assert.NoError},
+		{"fail pin retries", "123456", piv.DefaultManagementKey, &SetRetriesRequest{PINRetries: 0, PUKRetries: 4}, assert.Error},
+		{"fail puk retries", "123456", piv.DefaultManagementKey, &SetRetriesRequest{PINRetries: 5, PUKRetries: 256}, assert.Error},
+		{"fail invalid pin", "111111", piv.DefaultManagementKey, &SetRetriesRequest{PINRetries: 5, PUKRetries: 4}, assert.Error},
+		{"fail invalid management key", "123456", make([]byte, 24), &SetRetriesRequest{PINRetries: 5, PUKRetries: 4}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk, pin: tt.pin, managementKey: tt.managementKey}
+			err := k.SetRetries(tt.req)
+			tt.assertion(t, err)
+			if err != nil {
+				assert.Equal(t, 3, yk.retries)
+				assert.Equal(t, tt.pin, k.pin)
+				return
+			}
+			assert.Equal(t, tt.req.PINRetries, yk.retries)
+			assert.Equal(t, piv.DefaultPIN, k.pin)
+		})
+	}
+}
+
+func TestYubiKey_Reset(t *testing.T) {
+	yk1 := newStubPivKey(t, ECDSA)
+	yk1.pin = "654321"
+	yk2 := newStubPivKey(t, ECDSA)
+	yk2.adminErr = errors.New("some error")
+
+	tests := []struct {
+		name      string
+		yk        pivKey
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", yk1, assert.NoError},
+		{"fail", yk2, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			managementKey := bytes.Repeat([]byte{0x01}, 24)
+			k := &YubiKey{yk: tt.yk, pin: "654321", managementKey: managementKey}
+			err := k.Reset()
+			tt.assertion(t, err)
+			if err != nil {
+				assert.Equal(t, "654321", k.pin)
+				assert.Equal(t, managementKey, k.managementKey)
+				return
+			}
+			assert.Equal(t, piv.DefaultPIN, k.pin)
+			assert.Equal(t, piv.DefaultManagementKey, k.managementKey)
+		})
+	}
+}
+
+func TestNew_pinProtectedManagementKey(t *testing.T) {
+	pOpen := pivOpen
+	pCards := pivCards
+	t.Cleanup(func() {
+		registry = newCardRegistry()
+		pivOpen = pOpen
+		pivCards = pCards
+	})
+
+	managementKey := bytes.Repeat([]byte{0x01}, 24)
+	yk1 := newStubPivKey(t, ECDSA)
+	yk1.metadata = &piv.Metadata{ManagementKey: &managementKey}
+	yk2 := newStubPivKey(t, ECDSA)
+
+	tests := []struct {
+		name      string
+		yk        pivKey
+		uri       string
+		want      []byte
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", yk1, "yubikey:pin-protected-management-key=true?pin-value=123456", managementKey, assert.NoError},
+		{"ok not protected", yk1, "yubikey:pin-value=123456", piv.DefaultManagementKey, assert.NoError},
+		{"fail invalid pin", yk1, "yubikey:pin-protected-management-key=true?pin-value=111111", nil, assert.Error},
+		{"fail not found", yk2, "yubikey:pin-protected-management-key=true?pin-value=123456", nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry = newCardRegistry()
+			pivCards = func() ([]string, error) {
+				return []string{"Yubico YubiKey OTP+FIDO+CCID"}, nil
+			}
+			pivOpen = func(card string) (pivKey, error) {
+				return tt.yk, nil
+			}
+
+			got, err := New(context.Background(), apiv1.Options{URI: tt.uri})
+			tt.assertion(t, err)
+			if tt.want == nil {
+				assert.Nil(t, got)
+				return
+			}
+			if assert.NotNil(t, got) {
+				assert.Equal(t, tt.want, got.managementKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,634 @@
+//go:build cgo && !noyubikey
+// +build cgo,!noyubikey
+
+package yubikey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-piv/piv-go/v2/piv"
+	"github.com/pkg/errors"
+)
+
+// CardEventType is the type of a CardEvent.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type CardEventType int
+
+const (
+	// CardConnected is reported when a YubiKey is opened for the first time.
+	CardConnected CardEventType = iota + 1
+	// CardRemoved is reported when a YubiKey in use has been removed, or its
+	// connection is no longer valid.
+	CardRemoved
+	// CardReconnected is reported when a removed YubiKey is inserted again
+	// and the connection has been restored.
+	CardReconnected
+	// CardClosed is reported when the last user of a YubiKey closes it.
+	CardClosed
+)
+
+// String returns a string representation of the event type.
+func (t CardEventType) String() string {
+	switch t {
+	case CardConnected:
+		return "connected"
+	case CardRemoved:
+		return "removed"
+	case CardReconnected:
+		return "reconnected"
+	case CardClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// CardEvent is the event reported to the handler set with
+// SetCardEventHandler.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type CardEvent struct {
+	Type CardEventType
+	// Card is the name of the smart card reader of the YubiKey.
+	Card string
+	// Serial is the serial number of the YubiKey, 0 if it is not known.
+	Serial uint32
+}
+
+// SetCardEventHandler sets a function that is called on every change in the
+// state of the YubiKeys in use. The function is called synchronously, and it
+// must not block. A nil function removes the current handler.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func SetCardEventHandler(fn func(CardEvent)) {
+	registry.setHandler(fn)
+}
+
+// WatchCards periodically looks for changes in the list of smart card readers
+// and reports the removal and reinsertion of the YubiKeys in use, reconnecting
+// to them by serial number. Without it, the removal is detected, and the
+// reconnection attempted, on the first failed operation. WatchCards blocks
+// until the context is done.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WatchCards(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			registry.refresh()
+		}
+	}
+}
+
+// registry is the registry of the YubiKeys in use, shared by all the YubiKey
+// instances.
+var registry = newCardRegistry()
+
+// cardRegistry keeps one connection for each YubiKey in use. The connections
+// are reference counted, and they are closed when the last YubiKey using it is
+// closed.
+type cardRegistry struct {
+	mu      sync.Mutex
+	cards   map[string]*card
+	handler func(CardEvent)
+}
+
+func newCardRegistry() *cardRegistry {
+	return &cardRegistry{
+		cards: make(map[string]*card),
+	}
+}
+
+func (r *cardRegistry) setHandler(fn func(CardEvent)) {
+	r.mu.Lock()
+	r.handler = fn
+	r.mu.Unlock()
+}
+
+// emit reports the given events to the handler. It must be called without
+// holding any lock.
+func (r *cardRegistry) emit(events ...CardEvent) {
+	r.mu.Lock()
+	fn := r.handler
+	r.mu.Unlock()
+	if fn != nil {
+		for _, e := range events {
+			fn(e)
+		}
+	}
+}
+
+// open returns the connection to the YubiKey in the given reader, opening it
+// if necessary.
+func (r *cardRegistry) open(name string) (*card, error) {
+	r.mu.Lock()
+	if c, ok := r.cards[name]; ok {
+		c.refs++
+		r.mu.Unlock()
+		return c, nil
+	}
+
+	yk, err := pivOpen(name)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	c := &card{
+		reg:  r,
+		name: name,
+		yk:   yk,
+		refs: 1,
+	}
+	if serial, err := yk.Serial(); err == nil {
+		c.serial, c.hasSerial = serial, true
+	}
+	c.version = yk.Version()
+	r.cards[name] = c
+	r.mu.Unlock()
+
+	r.emit(CardEvent{Type: CardConnected, Card: name, Serial: c.serial})
+	return c, nil
+}
+
+// release decrements the references to the given card, and it returns true if
+// the connection must be closed.
+func (r *cardRegistry) release(c *card) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c.refs--; c.refs > 0 {
+		return false
+	}
+	if r.cards[c.name] == c {
+		delete(r.cards, c.name)
+	}
+	return true
+}
+
+// find looks for the YubiKey with the given serial number in the readers that
+// are not used by other cards, and returns the connection and the name of the
+// reader.
+func (r *cardRegistry) find(c *card) (pivKey, string, error) {
+	names, err := pivCards()
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		if other, ok := r.cards[name]; ok && other != c {
+			continue
+		}
+		yk, err := pivOpen(name)
+		if err != nil {
+			continue
+		}
+		if serial, err := yk.Serial(); err == nil && serial == c.serial {
+			if r.cards[c.name] == c {
+				delete(r.cards, c.name)
+			}
+			r.cards[name] = c
+			return yk, name, nil
+		}
+		yk.Close()
+	}
+	return nil, "", errors.Errorf("yubikey with serial number %d not found", c.serial)
+}
+
+// refresh looks for the cards that are no longer in the list of readers, and
+// reconnects them if they are back.
+func (r *cardRegistry) refresh() {
+	names, err := pivCards()
+	if err != nil {
+		return
+	}
+	readers := make(map[string]bool, len(names))
+	for _, name := range names {
+		readers[name] = true
+	}
+
+	r.mu.Lock()
+	var cards []*card
+	for name, c := range r.cards {
+		if !readers[name] {
+			cards = append(cards, c)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, c := range cards {
+		_ = c.reconnect(c.generation())
+	}
+}
+
+// removedCardErrors are the messages of the PC/SC errors returned by piv-go
+// when a card is removed or its connection is no longer valid.
+var removedCardErrors = []string{
+	"the smart card has been removed",
+	"the smart card has been reset",
+	"power has been removed from the smart card",
+	"the smart card is not responding to a reset",
+	"no Smart Card is currently in the device",
+	"the specified reader is not currently available",
+	"the specified reader name is not recognized",
+}
+
+var errCardRemoved = errors.New("yubikey has been removed")
+
+// isCardRemovedError returns true if the error was caused by the removal of
+// the card. piv-go does not export the PC/SC errors, so the message is used.
+func isCardRemovedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errCardRemoved) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range removedCardErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// card is a connection to a YubiKey in the registry. It implements pivKey, and
+// if an operation fails because the YubiKey has been removed, it attempts to
+// find it again using the serial number and retries the operation.
+type card struct {
+	reg       *cardRegistry
+	serial    uint32
+	hasSerial bool
+	version   piv.Version
+	refs      int // protected by reg.mu
+
+	mu   sync.Mutex
+	name string
+	yk   pivKey // nil if the card has been removed
+	gen  uint64 // incremented on every reconnection
+}
+
+// generation returns the number of times the card has been reconnected.
+func (c *card) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *card) current() (pivKey, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.yk, c.gen
+}
+
+// reconnect closes the current connection and opens a new one to the YubiKey
+// with the same serial number. It does nothing if the card was already
+// reconnected after the given generation.
+func (c *card) reconnect(gen uint64) error {
+	var events []CardEvent
+	defer func() {
+		c.reg.emit(events...)
+	}()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return nil
+	}
+	if c.yk != nil {
+		c.yk.Close()
+		c.yk = nil
+		events = append(events, CardEvent{Type: CardRemoved, Card: c.name, Serial: c.serial})
+	}
+	if !c.hasSerial {
+		return errCardRemoved
+	}
+
+	yk, name, err := c.reg.find(c)
+	if err != nil {
+		return errors.Wrap(errCardRemoved, err.Error())
+	}
+	c.yk, c.name = yk, name
+	c.gen++
+	events = append(events, CardEvent{Type: CardReconnected, Card: name, Serial: c.serial})
+	return nil
+}
+
+// do runs the given read-only function with the current connection. If the
+// function fails because the card has been removed, it reconnects and runs it
+// again.
+func (c *card) do(fn func(yk pivKey) error) error {
+	return c.run(true, fn)
+}
+
+// doWrite runs the given function, which modifies the card, with the current
+// connection. If the function fails because the card has been removed, it
+// reconnects and returns the original error without running it again. The
+// write might have been applied before the connection was lost, and running it
+// again could, for example, use a stale PIN and consume its retries.
+func (c *card) doWrite(fn func(yk pivKey) error) error {
+	return c.run(false, fn)
+}
+
+func (c *card) run(retry bool, fn func(yk pivKey) error) error {
+	yk, gen := c.current()
+	if yk != nil {
+		err := fn(yk)
+		if !isCardRemovedError(err) {
+			return err
+		}
+		if !retry {
+			_ = c.reconnect(gen)
+			return err
+		}
+	}
+	if err := c.reconnect(gen); err != nil {
+		return err
+	}
+	if yk, _ = c.current(); yk == nil {
+		return errCardRemoved
+	}
+	return fn(yk)
+}
+
+func (c *card) Certificate(slot piv.Slot) (cert *x509.Certificate, err error) {
+	err = c.do(func(yk pivKey) error {
+		cert, err = yk.Certificate(slot)
+		return err
+	})
+	return
+}
+
+func (c *card) SetCertificate(key []byte, slot piv.Slot, cert *x509.Certificate) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetCertificate(key, slot, cert)
+	})
+}
+
+func (c *card) GenerateKey(key []byte, slot piv.Slot, opts piv.Key) (pub crypto.PublicKey, err error) {
+	err = c.doWrite(func(yk pivKey) error {
+		pub, err = yk.GenerateKey(key, slot, opts)
+		return err
+	})
+	return
+}
+
+func (c *card) KeyInfo(slot piv.Slot) (ki piv.KeyInfo, err error) {
+	err = c.do(func(yk pivKey) error {
+		ki, err = yk.KeyInfo(slot)
+		return err
+	})
+	return
+}
+
+func (c *card) PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (priv crypto.PrivateKey, err error) {
+	err = c.do(func(yk pivKey) error {
+		priv, err = yk.PrivateKey(slot, public, auth)
+		return err
+	})
+	return
+}
+
+func (c *card) Attest(slot piv.Slot) (cert *x509.Certificate, err error) {
+	err = c.do(func(yk pivKey) error {
+		cert, err = yk.Attest(slot)
+		return err
+	})
+	return
+}
+
+func (c *card) Serial() (serial uint32, err error) {
+	if c.hasSerial {
+		return c.serial, nil
+	}
+	err = c.do(func(yk pivKey) error {
+		serial, err = yk.Serial()
+		return err
+	})
+	return
+}
+
+func (c *card) Version() piv.Version {
+	return c.version
+}
+
+func (c *card) SetPIN(oldPIN, newPIN string) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetPIN(oldPIN, newPIN)
+	})
+}
+
+func (c *card) SetPUK(oldPUK, newPUK string) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetPUK(oldPUK, newPUK)
+	})
+}
+
+func (c *card) Unblock(puk, newPIN string) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.Unblock(puk, newPIN)
+	})
+}
+
+func (c *card) Retries() (n int, err error) {
+	err = c.do(func(yk pivKey) error {
+		n, err = yk.Retries()
+		return err
+	})
+	return
+}
+
+func (c *card) SetManagementKey(oldKey, newKey []byte) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetManagementKey(oldKey, newKey)
+	})
+}
+
+func (c *card) SetRetries(managementKey []byte, pin string, pinRetries, pukRetries int) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetRetries(managementKey, pin, pinRetries, pukRetries)
+	})
+}
+
+func (c *card) Metadata(pin string) (md *piv.Metadata, err error) {
+	err = c.do(func(yk pivKey) error {
+		md, err = yk.Metadata(pin)
+		return err
+	})
+	return
+}
+
+func (c *card) SetMetadata(key []byte, md *piv.Metadata) error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.SetMetadata(key, md)
+	})
+}
+
+func (c *card) Reset() error {
+	return c.doWrite(func(yk pivKey) error {
+		return yk.Reset()
+	})
+}
+
+// Close releases a reference to the card, and closes the connection if it was
+// the last one.
+func (c *card) Close() error {
+	if !c.reg.release(c) {
+		return nil
+	}
+
+	c.mu.Lock()
+	yk, name := c.yk, c.name
+	c.yk = nil
+	c.mu.Unlock()
+
+	var err error
+	if yk != nil {
+		err = yk.Close()
+	}
+	c.reg.emit(CardEvent{Type: CardClosed, Card: name, Serial: c.serial})
+	return err
+}
+
+// cardPrivateKey is a private key in a card. The keys returned by piv-go are
+// bound to the connection used to get them, so the key is loaded again after
+// the card is reconnected.
+type cardPrivateKey struct {
+	mu   sync.Mutex
+	card *card
+	gen  uint64
+	priv crypto.PrivateKey
+	load func() (crypto.PrivateKey, error)
+}
+
+func newCardPrivateKey(c *card, gen uint64, priv crypto.PrivateKey, load func() (crypto.PrivateKey, error)) *cardPrivateKey {
+	return &cardPrivateKey{
+		card: c,
+		gen:  gen,
+		priv: priv,
+		load: load,
+	}
+}
+
+func (p *cardPrivateKey) reload() error {
+	gen := p.card.generation()
+	priv, err := p.load()
+	if err != nil {
+		return err
+	}
+	p.priv, p.gen = priv, gen
+	return nil
+}
+
+// use runs the given function with the current private key. If the function
+// fails because the card has been removed, it reconnects the card, loads the
+// key again, and runs the function again.
+func (p *cardPrivateKey) use(fn func(priv crypto.PrivateKey) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.card.generation() != p.gen {
+		if err := p.reload(); err != nil {
+			return err
+		}
+	}
+
+	err := fn(p.priv)
+	if !isCardRemovedError(err) {
+		return err
+	}
+	if rerr := p.card.reconnect(p.gen); rerr != nil {
+		return rerr
+	}
+	if rerr := p.reload(); rerr != nil {
+		return rerr
+	}
+	return fn(p.priv)
+}
+
+// cardSigner is a crypto.Signer backed by a cardPrivateKey.
+type cardSigner struct {
+	*cardPrivateKey
+	pub crypto.PublicKey
+}
+
+func (s *cardSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *cardSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (sig []byte, err error) {
+	err = s.use(func(priv crypto.PrivateKey) error {
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return errors.New("private key is not a crypto.Signer")
+		}
+		sig, err = signer.Sign(rand, digest, opts)
+		return err
+	})
+	return
+}
+
+// cardDecrypter is a crypto.Decrypter backed by a cardPrivateKey.
+type cardDecrypter struct {
+	*cardPrivateKey
+	pub crypto.PublicKey
+}
+
+func (d *cardDecrypter) Public() crypto.PublicKey {
+	return d.pub
+}
+
+func (d *cardDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
+	err = d.use(func(priv crypto.PrivateKey) error {
+		decrypter, ok := priv.(crypto.Decrypter)
+		if !ok {
+			return errors.New("private key is not a crypto.Decrypter")
+		}
+		plaintext, err = decrypter.Decrypt(rand, msg, opts)
+		return err
+	})
+	return
+}
+
+// cardECDH is an ecdhKey backed by a cardPrivateKey.
+type cardECDH struct {
+	*cardPrivateKey
+}
+
+func (e *cardECDH) ECDH(peer *ecdh.PublicKey) (secret []byte, err error) {
+	err = e.use(func(priv crypto.PrivateKey) error {
+		key, ok := priv.(ecdhKey)
+		if !ok {
+			return errors.New("private key does not support ECDH")
+		}
+		secret, err = key.ECDH(peer)
+		return err
+	})
+	return
+}
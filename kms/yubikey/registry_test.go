@@ -0,0 +1,492 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-piv/piv-go/v2/piv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+var errRemoved = errors.New("connecting to smart card: the smart card has been removed, so further communication is not possible")
+
+// removablePivKey is a stubPivKey that fails with the PC/SC error returned
+// when the YubiKey is removed.
+type removablePivKey struct {
+	*stubPivKey
+	mu      sync.Mutex
+	removed bool
+	closed  bool
+	signs   int
+	// removeOnWrite removes the card after a write is applied.
+	removeOnWrite bool
+	// afterPrivateKey is called after a private key is returned.
+	afterPrivateKey func()
+}
+
+func (r *removablePivKey) isRemoved() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.removed
+}
+
+func (r *removablePivKey) remove() {
+	r.mu.Lock()
+	r.removed = true
+	r.mu.Unlock()
+}
+
+func (r *removablePivKey) Certificate(slot piv.Slot) (*x509.Certificate, error) {
+	if r.isRemoved() {
+		return nil, errRemoved
+	}
+	return r.stubPivKey.Certificate(slot)
+}
+
+func (r *removablePivKey) KeyInfo(slot piv.Slot) (piv.KeyInfo, error) {
+	if r.isRemoved() {
+		return piv.KeyInfo{}, errRemoved
+	}
+	return r.stubPivKey.KeyInfo(slot)
+}
+
+func (r *removablePivKey) PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error) {
+	if r.isRemoved() {
+		return nil, errRemoved
+	}
+	priv, err := r.stubPivKey.PrivateKey(slot, public, auth)
+	if err != nil {
+		return nil, err
+	}
+	if r.afterPrivateKey != nil {
+		r.afterPrivateKey()
+	}
+	return &removableSigner{Signer: priv.(crypto.Signer), parent: r}, nil
+}
+
+func (r *removablePivKey) SetPIN(oldPIN, newPIN string) error {
+	if r.isRemoved() {
+		return errRemoved
+	}
+	if err := r.stubPivKey.SetPIN(oldPIN, newPIN); err != nil {
+		return err
+	}
+	if r.removeOnWrite {
+		r.remove()
+		return errRemoved
+	}
+	return nil
+}
+
+func (r *removablePivKey) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return nil
+}
+
+// removableSigner is a signer and decrypter bound to a removablePivKey, like the keys
+// returned by piv-go.
+type removableSigner struct {
+	crypto.Signer
+	parent *removablePivKey
+}
+
+func (s *removableSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.parent.mu.Lock()
+	s.parent.signs++
+	s.parent.mu.Unlock()
+	if s.parent.isRemoved() {
+		return nil, errRemoved
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func (s *removableSigner) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if s.parent.isRemoved() {
+		return nil, errRemoved
+	}
+	return s.Signer.(crypto.Decrypter).Decrypt(rand, msg, opts)
+}
+
+// mockCards replaces pivCards and pivOpen with functions returning the given
+// devices, and returns a function to replace them.
+func mockCards(t *testing.T, devices map[string]pivKey) func(map[string]pivKey) {
+	t.Helper()
+	pOpen, pCards, reg := pivOpen, pivCards, registry
+	t.Cleanup(func() {
+		pivOpen, pivCards, registry = pOpen, pCards, reg
+	})
+
+	var mu sync.Mutex
+	registry = newCardRegistry()
+	pivCards = func() ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var names []string
+		for i := 0; i < 10; i++ {
+			if _, ok := devices[fmt.Sprintf("reader %d", i)]; ok {
+				names = append(names, fmt.Sprintf("reader %d", i))
+			}
+		}
+		return names, nil
+	}
+	pivOpen = func(card string) (pivKey, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if yk, ok := devices[card]; ok {
+			return yk, nil
+		}
+		return nil, errors.New("reader not found")
+	}
+	return func(m map[string]pivKey) {
+		mu.Lock()
+		devices = m
+		mu.Unlock()
+	}
+}
+
+// eventRecorder records the card events.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []CardEvent
+}
+
+func (r *eventRecorder) record(e CardEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) get() []CardEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]CardEvent(nil), r.events...)
+}
+
+func newRemovable(t *testing.T, alg symmetricAlgorithm, serial uint32) *removablePivKey {
+	yk := newStubPivKey(t, alg)
+	yk.serial = serial
+	return &removablePivKey{stubPivKey: yk}
+}
+
+func TestCardEventType_String(t *testing.T) {
+	assert.Equal(t, "connected", CardConnected.String())
+	assert.Equal(t, "removed", CardRemoved.String())
+	assert.Equal(t, "reconnected", CardReconnected.String())
+	assert.Equal(t, "closed", CardClosed.String())
+	assert.Equal(t, "unknown(0)", CardEventType(0).String())
+}
+
+func Test_cardRegistry_open(t *testing.T) {
+	yk := newRemovable(t, ECDSA, 112233)
+	mockCards(t, map[string]pivKey{"reader 0": yk})
+	var rec eventRecorder
+	SetCardEventHandler(rec.record)
+
+	c1, err := registry.open("reader 0")
+	require.NoError(t, err)
+	c2, err := registry.open("reader 0")
+	require.NoError(t, err)
+	assert.Same(t, c1, c2)
+	assert.Equal(t, uint32(112233), c1.serial)
+	assert.Equal(t, piv.Version{Major: 5, Minor: 7, Patch: 1}, c1.Version())
+
+	_, err = registry.open("reader 1")
+	assert.Error(t, err)
+
+	// The connection is closed with the last reference.
+	require.NoError(t, c1.Close())
+	assert.False(t, yk.closed)
+	require.NoError(t, c2.Close())
+	assert.True(t, yk.closed)
+
+	// A new connection is opened after closing it.
+	c3, err := registry.open("reader 0")
+	require.NoError(t, err)
+	assert.NotSame(t, c1, c3)
+
+	assert.Equal(t, []CardEvent{
+		{Type: CardConnected, Card: "reader 0", Serial: 112233},
+		{Type: CardClosed, Card: "reader 0", Serial: 112233},
+		{Type: CardConnected, Card: "reader 0", Serial: 112233},
+	}, rec.get())
+}
+
+func Test_card_reconnect(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		yk1 := newRemovable(t, ECDSA, 112233)
+		yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+		other := newRemovable(t, ECDSA, 445566)
+		setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1, "reader 1": other})
+		var rec eventRecorder
+		SetCardEventHandler(rec.record)
+
+		c, err := registry.open("reader 0")
+		require.NoError(t, err)
+		want, err := c.Certificate(piv.SlotSignature)
+		require.NoError(t, err)
+
+		// The YubiKey is reinserted in a different reader.
+		yk1.remove()
+		setDevices(map[string]pivKey{"reader 1": other, "reader 2": yk2})
+
+		got, err := c.Certificate(piv.SlotSignature)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+		assert.Equal(t, "reader 2", c.name)
+		assert.Equal(t, uint64(1), c.generation())
+		assert.True(t, yk1.closed)
+
+		registry.mu.Lock()
+		assert.Equal(t, map[string]*card{"reader 2": c}, registry.cards)
+		registry.mu.Unlock()
+
+		assert.Equal(t, []CardEvent{
+			{Type: CardConnected, Card: "reader 0", Serial: 112233},
+			{Type: CardRemoved, Card: "reader 0", Serial: 112233},
+			{Type: CardReconnected, Card: "reader 2", Serial: 112233},
+		}, rec.get())
+	})
+
+	t.Run("ok not inserted", func(t *testing.T) {
+		yk1 := newRemovable(t, ECDSA, 112233)
+		yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+		setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1})
+		var rec eventRecorder
+		SetCardEventHandler(rec.record)
+
+		c, err := registry.open("reader 0")
+		require.NoError(t, err)
+
+		yk1.remove()
+		setDevices(map[string]pivKey{})
+		_, err = c.Certificate(piv.SlotSignature)
+		assert.ErrorIs(t, err, errCardRemoved)
+		_, err = c.Attest(piv.SlotAuthentication)
+		assert.ErrorIs(t, err, errCardRemoved)
+
+		setDevices(map[string]pivKey{"reader 0": yk2})
+		_, err = c.Certificate(piv.SlotSignature)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []CardEvent{
+			{Type: CardConnected, Card: "reader 0", Serial: 112233},
+			{Type: CardRemoved, Card: "reader 0", Serial: 112233},
+			{Type: CardReconnected, Card: "reader 0", Serial: 112233},
+		}, rec.get())
+	})
+
+	t.Run("fail write not retried", func(t *testing.T) {
+		yk1 := newRemovable(t, ECDSA, 112233)
+		yk1.removeOnWrite = true
+		yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+		setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1})
+
+		c, err := registry.open("reader 0")
+		require.NoError(t, err)
+
+		// The PIN is changed, but the card is removed before responding.
+		setDevices(map[string]pivKey{"reader 1": yk2})
+		err = c.SetPIN(piv.DefaultPIN, "123456")
+		assert.True(t, isCardRemovedError(err))
+		assert.Equal(t, "123456", yk1.pin)
+		assert.Equal(t, "reader 1", c.name)
+		assert.Equal(t, uint64(1), c.generation())
+
+		assert.NoError(t, c.SetPIN("123456", "654321"))
+		assert.Equal(t, "654321", yk2.pin)
+	})
+
+	t.Run("fail without serial", func(t *testing.T) {
+		yk := newRemovable(t, ECDSA, 112233)
+		yk.serialErr = errors.New("some error")
+		mockCards(t, map[string]pivKey{"reader 0": yk})
+
+		c, err := registry.open("reader 0")
+		require.NoError(t, err)
+		assert.False(t, c.hasSerial)
+
+		yk.remove()
+		_, err = c.Certificate(piv.SlotSignature)
+		assert.ErrorIs(t, err, errCardRemoved)
+		_, err = c.Serial()
+		assert.ErrorIs(t, err, errCardRemoved)
+	})
+
+	t.Run("fail other errors", func(t *testing.T) {
+		yk := newRemovable(t, ECDSA, 112233)
+		mockCards(t, map[string]pivKey{"reader 0": yk})
+
+		c, err := registry.open("reader 0")
+		require.NoError(t, err)
+		_, err = c.Certificate(slotMapping["82"])
+		assert.Error(t, err)
+		assert.False(t, isCardRemovedError(err))
+		assert.Equal(t, uint64(0), c.generation())
+	})
+}
+
+func Test_cardRegistry_refresh(t *testing.T) {
+	yk1 := newRemovable(t, ECDSA, 112233)
+	yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+	setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1})
+	var rec eventRecorder
+	SetCardEventHandler(rec.record)
+
+	c, err := registry.open("reader 0")
+	require.NoError(t, err)
+
+	// Nothing changes.
+	registry.refresh()
+	assert.Equal(t, uint64(0), c.generation())
+
+	yk1.remove()
+	setDevices(map[string]pivKey{})
+	registry.refresh()
+	registry.refresh()
+	assert.True(t, yk1.closed)
+
+	setDevices(map[string]pivKey{"reader 3": yk2})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- WatchCards(ctx, time.Millisecond)
+	}()
+	assert.Eventually(t, func() bool {
+		return c.generation() == 1
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	assert.Equal(t, []CardEvent{
+		{Type: CardConnected, Card: "reader 0", Serial: 112233},
+		{Type: CardRemoved, Card: "reader 0", Serial: 112233},
+		{Type: CardReconnected, Card: "reader 3", Serial: 112233},
+	}, rec.get())
+}
+
+func TestYubiKey_CreateSigner_reconnect(t *testing.T) {
+	yk1 := newRemovable(t, RSA, 112233)
+	yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+	setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1})
+
+	k, err := New(context.Background(), apiv1.Options{URI: "yubikey:serial=112233?pin-value=123456"})
+	require.NoError(t, err)
+	k2, err := New(context.Background(), apiv1.Options{URI: "yubikey:pin-value=123456"})
+	require.NoError(t, err)
+	assert.Same(t, k.yk, k2.yk)
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "yubikey:slot-id=9c"})
+	require.NoError(t, err)
+	decrypter, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "yubikey:slot-id=9c"})
+	require.NoError(t, err)
+	assert.Equal(t, yk1.signerMap[piv.SlotSignature].(crypto.Signer).Public(), signer.Public())
+	assert.Equal(t, signer.Public(), decrypter.Public())
+
+	digest := sha256.Sum256([]byte("the-data"))
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	// Remove the key while the signer is in use.
+	yk1.remove()
+	setDevices(map[string]pivKey{"reader 1": yk2})
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, decrypter.Public().(*rsa.PublicKey), []byte("the-secret"))
+	require.NoError(t, err)
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("the-secret"), plaintext)
+
+	// Signer created from the other instance.
+	signer2, err := k2.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "yubikey:slot-id=9c"})
+	require.NoError(t, err)
+	_, err = signer2.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	// Remove the key without reinserting it.
+	yk2.remove()
+	setDevices(map[string]pivKey{})
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.ErrorIs(t, err, errCardRemoved)
+
+	// The connection is shared by both instances.
+	require.NoError(t, k.Close())
+	assert.Equal(t, 1, k2.yk.(*card).refs)
+	require.NoError(t, k2.Close())
+
+	registry.mu.Lock()
+	assert.Empty(t, registry.cards)
+	registry.mu.Unlock()
+}
+
+func TestYubiKey_CreateSigner_reconnectWhileLoading(t *testing.T) {
+	yk1 := newRemovable(t, RSA, 112233)
+	yk2 := &removablePivKey{stubPivKey: yk1.stubPivKey}
+	setDevices := mockCards(t, map[string]pivKey{"reader 0": yk1})
+
+	k, err := New(context.Background(), apiv1.Options{URI: "yubikey:serial=112233?pin-value=123456"})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = k.Close()
+	})
+
+	// The YubiKey is reconnected after the private key is loaded, but before
+	// the signer is created.
+	c := k.yk.(*card)
+	yk1.afterPrivateKey = func() {
+		yk1.afterPrivateKey = nil
+		yk1.remove()
+		setDevices(map[string]pivKey{"reader 1": yk2})
+		require.NoError(t, c.reconnect(c.generation()))
+	}
+
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "yubikey:slot-id=9c"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), c.generation())
+
+	// The stale key is loaded again before its first use.
+	digest := sha256.Sum256([]byte("the-data"))
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), c.generation())
+	assert.Equal(t, 0, yk1.signs)
+	assert.Equal(t, 1, yk2.signs)
+}
+
+func Test_isCardRemovedError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"removed", errRemoved, true},
+		{"reset", errors.New("the smart card has been reset, so any shared state information is invalid"), true},
+		{"reader", fmt.Errorf("connecting: %w", errors.New("the specified reader is not currently available for use")), true},
+		{"errCardRemoved", fmt.Errorf("some error: %w", errCardRemoved), true},
+		{"nil", nil, false},
+		{"other", errors.New("smart card error 6982: security status not satisfied"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isCardRemovedError(tt.err))
+		})
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/asn1"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"net/url"
@@ -48,11 +49,20 @@ type pivKey interface {
 	PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error)
 	Attest(slot piv.Slot) (*x509.Certificate, error)
 	Serial() (uint32, error)
+	Version() piv.Version
+	SetPIN(oldPIN, newPIN string) error
+	SetPUK(oldPUK, newPUK string) error
+	Unblock(puk, newPIN string) error
+	Retries() (int, error)
+	SetManagementKey(oldKey, newKey []byte) error
+	SetRetries(managementKey []byte, pin string, pinRetries, pukRetries int) error
+	Metadata(pin string) (*piv.Metadata, error)
+	SetMetadata(key []byte, m *piv.Metadata) error
+	Reset() error
 	Close() error
 }
 
 var pivCards = piv.Cards
-var pivMap sync.Map
 
 // pivOpen calls piv.Open. It can be replaced by a custom functions for testing
 // purposes.
@@ -60,20 +70,6 @@ var pivOpen = func(card string) (pivKey, error) {
 	return piv.Open(card)
 }
 
-// openCard wraps pivOpen with a cache. It loads a card connection from the
-// cache if present.
-func openCard(card string) (pivKey, error) {
-	if v, ok := pivMap.Load(card); ok {
-		return v.(pivKey), nil
-	}
-	yk, err := pivOpen(card)
-	if err != nil {
-		return nil, err
-	}
-	pivMap.Store(card, yk)
-	return yk, nil
-}
-
 // New initializes a new YubiKey KMS.
 //
 // The most common way to open a YubiKey is to add a URI in the options:
@@ -95,13 +91,19 @@ func openCard(card string) (pivKey, error) {
 //
 //	yubikey:slot-id=9a?pin-value=123456
 //
+// If the management key was stored in the YubiKey protected by the PIN, using
+// SetManagementKey, it can be loaded from the device:
+//
+//	yubikey:pin-protected-management-key=true?pin-source=/var/run/yubikey.pin
+//
 // If the pin or the management key are not provided, we will use the default
 // ones.
 func New(_ context.Context, opts apiv1.Options) (*YubiKey, error) {
-	pin := "123456"
-	managementKey := piv.DefaultManagementKey
+	pin := piv.DefaultPIN
+	managementKey := append([]byte(nil), piv.DefaultManagementKey...)
 
 	var serial string
+	var protectedManagementKey bool
 	if opts.URI != "" {
 		u, err := uri.ParseWithScheme(Scheme, opts.URI)
 		if err != nil {
@@ -124,18 +126,16 @@ func New(_ context.Context, opts apiv1.Options) (*YubiKey, error) {
 		if v := u.Get("serial"); v != "" {
 			serial = v
 		}
+		protectedManagementKey = u.GetBool("pin-protected-management-key")
 	}
 
 	// Deprecated way to set configuration parameters.
 	if opts.ManagementKey != "" {
-		b, err := hex.DecodeString(opts.ManagementKey)
+		b, err := decodeManagementKey(opts.ManagementKey)
 		if err != nil {
-			return nil, errors.Wrap(err, "error decoding management key")
-		}
-		if len(b) != 24 {
-			return nil, errors.New("invalid managementKey: length is not 24 bytes")
+			return nil, err
 		}
-		copy(managementKey, b[:24])
+		managementKey = b
 	}
 
 	if opts.Pin != "" {
@@ -149,33 +149,39 @@ func New(_ context.Context, opts apiv1.Options) (*YubiKey, error) {
 	if len(cards) == 0 {
 		return nil, errors.New("error detecting yubikey: try removing and reconnecting the device")
 	}
-	card := cards[0]
+	cardName := cards[0]
 
-	var yk pivKey
+	var yk *card
 	if serial != "" {
 		// Attempt to locate the yubikey with the given serial.
 		for _, name := range cards {
-			if k, err := openCard(name); err == nil {
-				if s, err := k.Serial(); err == nil {
-					if serial == strconv.FormatUint(uint64(s), 10) {
-						yk = k
-						card = name
-						break
-					}
+			if c, err := registry.open(name); err == nil {
+				if s, err := c.Serial(); err == nil && serial == strconv.FormatUint(uint64(s), 10) {
+					yk = c
+					cardName = name
+					break
 				}
+				c.Close()
 			}
 		}
 		if yk == nil {
 			return nil, errors.Errorf("failed to find key with serial number %s, slot 0x9a might be empty", serial)
 		}
-	} else if yk, err = openCard(cards[0]); err != nil {
+	} else if yk, err = registry.open(cards[0]); err != nil {
 		return nil, errors.Wrap(err, "error opening yubikey")
 	}
 
+	if protectedManagementKey {
+		if managementKey, err = loadProtectedManagementKey(yk, pin); err != nil {
+			yk.Close()
+			return nil, err
+		}
+	}
+
 	return &YubiKey{
 		yk:            yk,
 		pin:           pin,
-		card:          card,
+		card:          cardName,
 		managementKey: managementKey,
 	}, nil
 }
@@ -239,10 +245,13 @@ func (k *YubiKey) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey
 
 // CreateKey generates a new key in the YubiKey and returns the public key.
 func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
-	alg, err := getSignatureAlgorithm(req.SignatureAlgorithm, req.Bits)
+	alg, err := getKeyAlgorithm(req)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkFirmwareVersion(k.yk.Version(), alg); err != nil {
+		return nil, err
+	}
 	slot, name, err := getSlotAndName(req.Name)
 	if err != nil {
 		return nil, err
@@ -269,36 +278,19 @@ func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 // CreateSigner creates a signer using the key present in the YubiKey signature
 // slot.
 func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
-	slot, err := getSlot(req.SigningKey)
-	if err != nil {
-		return nil, err
-	}
-
-	pin := k.pin
-	if pin == "" {
-		// Attempt to get the pin from the uri
-		if u, err := uri.ParseWithScheme(Scheme, req.SigningKey); err == nil {
-			pin = u.Pin()
-		}
-	}
-
-	pub, err := k.getPublicKey(slot)
+	gen := k.generation()
+	priv, pub, err := k.getPrivateKey(req.SigningKey)
 	if err != nil {
 		return nil, err
 	}
 
-	priv, err := k.yk.PrivateKey(slot, pub, piv.KeyAuth{
-		PIN:       pin,
-		PINPolicy: piv.PINPolicyAlways,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving private key")
-	}
-
 	signer, ok := priv.(crypto.Signer)
 	if !ok {
 		return nil, errors.New("private key is not a crypto.Signer")
 	}
+	if p := k.cardPrivateKey(req.SigningKey, gen, priv); p != nil {
+		signer = &cardSigner{cardPrivateKey: p, pub: pub}
+	}
 	return &syncSigner{
 		Signer: signer,
 	}, nil
@@ -307,38 +299,49 @@ func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 // CreateDecrypter creates a crypto.Decrypter using the key present in the configured
 // Yubikey slot.
 func (k *YubiKey) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
-	slot, err := getSlot(req.DecryptionKey)
+	gen := k.generation()
+	priv, pub, err := k.getPrivateKey(req.DecryptionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	pin := k.pin
-	if pin == "" {
-		// Attempt to get the pin from the uri
-		if u, err := uri.ParseWithScheme(Scheme, req.DecryptionKey); err == nil {
-			pin = u.Pin()
-		}
+	decrypter, ok := priv.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("private key is not a crypto.Decrypter")
 	}
-
-	pub, err := k.getPublicKey(slot)
-	if err != nil {
-		return nil, err
+	if p := k.cardPrivateKey(req.DecryptionKey, gen, priv); p != nil {
+		decrypter = &cardDecrypter{cardPrivateKey: p, pub: pub}
 	}
+	return &syncDecrypter{
+		Decrypter: decrypter,
+	}, nil
+}
 
-	priv, err := k.yk.PrivateKey(slot, pub, piv.KeyAuth{
-		PIN:       pin,
-		PINPolicy: piv.PINPolicyAlways,
-	})
+// CreateECDH creates an [ECDH] using the key present in the YubiKey slot set
+// in the DecryptionKey of the request. ECDH is supported on P-256, P-384, and
+// on X25519 keys on YubiKeys with firmware 5.7 or later.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *YubiKey) CreateECDH(req *apiv1.CreateDecrypterRequest) (*ECDH, error) {
+	gen := k.generation()
+	priv, pub, err := k.getPrivateKey(req.DecryptionKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving private key")
+		return nil, err
 	}
 
-	decrypter, ok := priv.(crypto.Decrypter)
+	key, ok := priv.(ecdhKey)
 	if !ok {
-		return nil, errors.New("private key is not a crypto.Decrypter")
+		return nil, errors.New("private key does not support ECDH")
 	}
-	return &syncDecrypter{
-		Decrypter: decrypter,
+	if p := k.cardPrivateKey(req.DecryptionKey, gen, priv); p != nil {
+		key = &cardECDH{cardPrivateKey: p}
+	}
+	return &ECDH{
+		key: key,
+		pub: pub,
 	}, nil
 }
 
@@ -384,12 +387,13 @@ func (k *YubiKey) Serial() (string, error) {
 	return strconv.FormatUint(uint64(serial), 10), nil
 }
 
-// Close releases the connection to the YubiKey.
+// Close releases the connection to the YubiKey. The connection is shared with
+// other instances using the same YubiKey, and it is only closed by the last
+// one.
 func (k *YubiKey) Close() error {
 	if err := k.yk.Close(); err != nil {
 		return errors.Wrap(err, "error closing yubikey")
 	}
-	pivMap.Delete(k.card)
 	return nil
 }
 
@@ -423,6 +427,63 @@ func (k *YubiKey) getPublicKey(slot piv.Slot) (crypto.PublicKey, error) {
 	return cert.PublicKey, nil
 }
 
+// getPrivateKey returns the private key and the public key in the slot set in
+// the given name. If the YubiKey was not configured with a pin, the pin is
+// obtained from the name.
+func (k *YubiKey) getPrivateKey(name string) (crypto.PrivateKey, crypto.PublicKey, error) {
+	slot, err := getSlot(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pin := k.pin
+	if pin == "" {
+		// Attempt to get the pin from the uri
+		if u, err := uri.ParseWithScheme(Scheme, name); err == nil {
+			pin = u.Pin()
+		}
+	}
+
+	pub, err := k.getPublicKey(slot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := k.yk.PrivateKey(slot, pub, piv.KeyAuth{
+		PIN:       pin,
+		PINPolicy: piv.PINPolicyAlways,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error retrieving private key")
+	}
+
+	return priv, pub, nil
+}
+
+// generation returns the generation of the YubiKey in the registry, or 0 if
+// it's not in the registry. It must be read before getting a private key.
+func (k *YubiKey) generation() uint64 {
+	if c, ok := k.yk.(*card); ok {
+		return c.generation()
+	}
+	return 0
+}
+
+// cardPrivateKey returns a private key that is loaded again if the YubiKey is
+// reconnected. The generation must be read before getting the private key, so
+// that if the card is reconnected in between, the key is loaded again on first
+// use. It returns nil if the YubiKey is not in the registry.
+func (k *YubiKey) cardPrivateKey(name string, gen uint64, priv crypto.PrivateKey) *cardPrivateKey {
+	c, ok := k.yk.(*card)
+	if !ok {
+		return nil
+	}
+	return newCardPrivateKey(c, gen, priv, func() (crypto.PrivateKey, error) {
+		priv, _, err := k.getPrivateKey(name)
+		return priv, err
+	})
+}
+
 // signatureAlgorithmMapping is a mapping between the step signature algorithm,
 // and bits for RSA keys, with yubikey ones.
 var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
@@ -480,6 +541,73 @@ func getSignatureAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (piv.Algorith
 	}
 }
 
+// getKeyAlgorithm returns the algorithm of the key to create. X25519 keys
+// cannot be used for signing, so they are not mapped to a signature algorithm,
+// they are requested using the "key-type=x25519" parameter in the name, e.g.,
+// "yubikey:slot-id=9d;key-type=x25519".
+func getKeyAlgorithm(req *apiv1.CreateKeyRequest) (piv.Algorithm, error) {
+	if strings.HasPrefix(strings.ToLower(req.Name), "yubikey:") {
+		u, err := uri.Parse(strings.ToLower(req.Name))
+		if err != nil {
+			return 0, err
+		}
+		switch kt := u.Get("key-type"); kt {
+		case "":
+		case "x25519":
+			if req.SignatureAlgorithm != apiv1.UnspecifiedSignAlgorithm {
+				return 0, errors.Errorf("key-type '%s' cannot be used with signature algorithm '%s'", kt, req.SignatureAlgorithm)
+			}
+			return piv.AlgorithmX25519, nil
+		default:
+			return 0, errors.Errorf("YubiKey does not support key-type '%s'", kt)
+		}
+	}
+
+	return getSignatureAlgorithm(req.SignatureAlgorithm, req.Bits)
+}
+
+// firmwareVersion57 is the first firmware version with support for Ed25519,
+// X25519, RSA 3072 and RSA 4096 keys.
+var firmwareVersion57 = piv.Version{Major: 5, Minor: 7, Patch: 0}
+
+// algorithmRequirements contains the algorithms that are not supported in all
+// YubiKeys, with their name and the minimum firmware version required.
+var algorithmRequirements = map[piv.Algorithm]struct {
+	name    string
+	version piv.Version
+}{
+	piv.AlgorithmEd25519: {"Ed25519", firmwareVersion57},
+	piv.AlgorithmX25519:  {"X25519", firmwareVersion57},
+	piv.AlgorithmRSA3072: {"RSA 3072", firmwareVersion57},
+	piv.AlgorithmRSA4096: {"RSA 4096", firmwareVersion57},
+}
+
+// checkFirmwareVersion returns an error if the given algorithm is not supported
+// by a YubiKey with the given firmware version.
+func checkFirmwareVersion(v piv.Version, alg piv.Algorithm) error {
+	req, ok := algorithmRequirements[alg]
+	if !ok || compareVersions(v, req.version) >= 0 {
+		return nil
+	}
+	return errors.Errorf("YubiKey firmware %s does not support %s keys, firmware %s or later is required",
+		formatVersion(v), req.name, formatVersion(req.version))
+}
+
+func compareVersions(a, b piv.Version) int {
+	switch {
+	case a.Major != b.Major:
+		return a.Major - b.Major
+	case a.Minor != b.Minor:
+		return a.Minor - b.Minor
+	default:
+		return a.Patch - b.Patch
+	}
+}
+
+func formatVersion(v piv.Version) string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
 var slotAttestation = piv.Slot{Key: 0xf9, Object: 0x5fff01}
 
 var slotMapping = map[string]piv.Slot{
@@ -609,4 +737,63 @@ func (s *syncDecrypter) Decrypt(rand io.Reader, msg []byte, opts crypto.Decrypte
 	return s.Decrypter.Decrypt(rand, msg, opts)
 }
 
+// ecdhKey is the interface implemented by the piv keys that support ECDH.
+type ecdhKey interface {
+	ECDH(peer *ecdh.PublicKey) ([]byte, error)
+}
+
+// ECDH is a key in a YubiKey that can perform ECDH key agreements. Like
+// syncSigner and syncDecrypter, it uses a mutex to serialize the operations on
+// the card.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type ECDH struct {
+	key ecdhKey
+	pub crypto.PublicKey
+}
+
+// Public returns the public key of the ECDH key. It returns an
+// [*ecdsa.PublicKey] for P-256 and P-384 keys, and an [*ecdh.PublicKey] for
+// X25519 keys.
+func (e *ECDH) Public() crypto.PublicKey {
+	return e.pub
+}
+
+// PublicKey returns the [ecdh.PublicKey] representation of the key. It returns
+// nil if the key cannot be converted.
+func (e *ECDH) PublicKey() *ecdh.PublicKey {
+	switch pub := e.pub.(type) {
+	case *ecdh.PublicKey:
+		return pub
+	case *ecdsa.PublicKey:
+		k, err := pub.ECDH()
+		if err != nil {
+			return nil
+		}
+		return k
+	default:
+		return nil
+	}
+}
+
+// Curve returns the [ecdh.Curve] of the key. It returns nil if the key cannot
+// be converted.
+func (e *ECDH) Curve() ecdh.Curve {
+	if pub := e.PublicKey(); pub != nil {
+		return pub.Curve()
+	}
+	return nil
+}
+
+// ECDH performs an ECDH exchange and returns the shared secret. The private key
+// and the peer public key must use the same curve.
+func (e *ECDH) ECDH(peer *ecdh.PublicKey) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	return e.key.ECDH(peer)
+}
+
 var _ apiv1.CertificateManager = (*YubiKey)(nil)
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -22,7 +23,6 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"sync"
 	"testing"
 
 	"github.com/go-piv/piv-go/v2/piv"
@@ -45,7 +45,14 @@ type stubPivKey struct {
 	keyOptionsMap map[piv.Slot]piv.Key
 	serial        uint32
 	serialErr     error
+	version       piv.Version
 	closeErr      error
+	pin           string
+	puk           string
+	managementKey []byte
+	metadata      *piv.Metadata
+	retries       int
+	adminErr      error
 }
 
 type symmetricAlgorithm int
@@ -155,6 +162,11 @@ func newStubPivKey(t *testing.T, alg symmetricAlgorithm) *stubPivKey {
 		},
 		keyOptionsMap: map[piv.Slot]piv.Key{},
 		serial:        uint32(sn),
+		version:       piv.Version{Major: 5, Minor: 7, Patch: 1},
+		pin:           piv.DefaultPIN,
+		puk:           piv.DefaultPUK,
+		managementKey: append([]byte(nil), piv.DefaultManagementKey...),
+		retries:       3,
 	}
 }
 
@@ -190,7 +202,7 @@ func (s *stubPivKey) GenerateKey(key []byte, slot piv.Slot, opts piv.Key) (crypt
 		return nil, errors.New("missing or invalid management key")
 	}
 
-	var signer crypto.Signer
+	var signer privateKey
 	switch opts.Algorithm {
 	case piv.AlgorithmEC256:
 		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -210,6 +222,12 @@ func (s *stubPivKey) GenerateKey(key []byte, slot piv.Slot, opts piv.Key) (crypt
 			return nil, err
 		}
 		signer = key
+	case piv.AlgorithmX25519:
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
 	case piv.AlgorithmRSA1024:
 		key, err := rsa.GenerateKey(rand.Reader, 1024)
 		if err != nil {
@@ -266,6 +284,10 @@ func (s *stubPivKey) Close() error {
 	return s.closeErr
 }
 
+func (s *stubPivKey) Version() piv.Version {
+	return s.version
+}
+
 func (s *stubPivKey) Serial() (uint32, error) {
 	if s.serialErr != nil {
 		return 0, s.serialErr
@@ -273,6 +295,87 @@ func (s *stubPivKey) Serial() (uint32, error) {
 	return s.serial, nil
 }
 
+func (s *stubPivKey) SetPIN(oldPIN, newPIN string) error {
+	if oldPIN != s.pin {
+		return errors.New("invalid pin")
+	}
+	s.pin = newPIN
+	return nil
+}
+
+func (s *stubPivKey) SetPUK(oldPUK, newPUK string) error {
+	if oldPUK != s.puk {
+		return errors.New("invalid puk")
+	}
+	s.puk = newPUK
+	return nil
+}
+
+func (s *stubPivKey) Unblock(puk, newPIN string) error {
+	if puk != s.puk {
+		return errors.New("invalid puk")
+	}
+	s.pin = newPIN
+	return nil
+}
+
+func (s *stubPivKey) Retries() (int, error) {
+	if s.adminErr != nil {
+		return 0, s.adminErr
+	}
+	return s.retries, nil
+}
+
+func (s *stubPivKey) SetManagementKey(oldKey, newKey []byte) error {
+	if !bytes.Equal(oldKey, s.managementKey) {
+		return errors.New("invalid management key")
+	}
+	s.managementKey = newKey
+	return nil
+}
+
+func (s *stubPivKey) SetRetries(managementKey []byte, pin string, pinRetries, pukRetries int) error {
+	if !bytes.Equal(managementKey, s.managementKey) {
+		return errors.New("invalid management key")
+	}
+	if pin != s.pin {
+		return errors.New("invalid pin")
+	}
+	s.pin, s.puk, s.retries = piv.DefaultPIN, piv.DefaultPUK, pinRetries
+	return nil
+}
+
+func (s *stubPivKey) Metadata(pin string) (*piv.Metadata, error) {
+	if pin != s.pin {
+		return nil, errors.New("invalid pin")
+	}
+	if s.metadata == nil {
+		return &piv.Metadata{}, nil
+	}
+	return s.metadata, nil
+}
+
+func (s *stubPivKey) SetMetadata(key []byte, m *piv.Metadata) error {
+	if s.adminErr != nil {
+		return s.adminErr
+	}
+	if !bytes.Equal(key, s.managementKey) {
+		return errors.New("invalid management key")
+	}
+	s.metadata = m
+	return nil
+}
+
+func (s *stubPivKey) Reset() error {
+	if s.adminErr != nil {
+		return s.adminErr
+	}
+	s.pin, s.puk = piv.DefaultPIN, piv.DefaultPUK
+	s.managementKey = append([]byte(nil), piv.DefaultManagementKey...)
+	s.metadata = nil
+	return nil
+}
+
 func TestRegister(t *testing.T) {
 	pCards := pivCards
 	t.Cleanup(func() {
@@ -297,7 +400,7 @@ func TestNew(t *testing.T) {
 	pOpen := pivOpen
 	pCards := pivCards
 	t.Cleanup(func() {
-		pivMap = sync.Map{}
+		registry = newCardRegistry()
 		pivOpen = pOpen
 		pivCards = pCards
 	})
@@ -344,21 +447,21 @@ func TestNew(t *testing.T) {
 		wantErr bool
 	}{
 		{"ok", args{ctx, apiv1.Options{}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "123456", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: piv.DefaultManagementKey}, false},
 		{"ok with uri", args{ctx, apiv1.Options{
 			URI: "yubikey:pin-value=111111;management-key=001122334455667788990011223344556677889900112233",
 		}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okMultiplePivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "111111", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}}, false},
 		{"ok with uri and serial", args{ctx, apiv1.Options{
 			URI: "yubikey:serial=112233?pin-value=123456",
 		}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "123456", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: piv.DefaultManagementKey}, false},
@@ -371,59 +474,59 @@ func TestNew(t *testing.T) {
 		{"ok with management-key-source", args{ctx, apiv1.Options{
 			URI: fmt.Sprintf("yubikey:management-key-source=%s?pin-value=123456", managementKeyFile),
 		}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "123456", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: managementKey}, false},
 		{"ok with Pin", args{ctx, apiv1.Options{Pin: "222222"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "222222", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: piv.DefaultManagementKey}, false},
 		{"ok with ManagementKey", args{ctx, apiv1.Options{ManagementKey: "001122334455667788990011223344556677889900112233"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, &YubiKey{yk: yk, pin: "123456", card: "Yubico YubiKey OTP+FIDO+CCID", managementKey: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}}, false},
 		{"fail uri", args{ctx, apiv1.Options{URI: "badschema:"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail management key", args{ctx, apiv1.Options{URI: "yubikey:management-key=xxyyzz"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail management key size", args{ctx, apiv1.Options{URI: "yubikey:management-key=00112233"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail management key source", args{ctx, apiv1.Options{URI: "yubikey:management-key-source=missing.txt"}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail pivCards", args{ctx, apiv1.Options{}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = failPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail no pivCards", args{ctx, apiv1.Options{}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = failNoPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail no pivCards with serial", args{ctx, apiv1.Options{
 			URI: "yubikey:pin-value=111111;serial=332211?pin-value=123456",
 		}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
 		{"fail pivOpen", args{ctx, apiv1.Options{}}, func() {
-			pivMap = sync.Map{}
+			registry = newCardRegistry()
 			pivCards = okPivCards
 			pivOpen = failPivOpen
 		}, nil, true},
@@ -437,6 +540,12 @@ func TestNew(t *testing.T) {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// Compare the connection in the registry instead of the card.
+			if got != nil {
+				if c, ok := got.yk.(*card); ok {
+					got.yk = c.yk
+				}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
@@ -609,6 +718,8 @@ func TestYubiKey_GetPublicKey(t *testing.T) {
 
 func TestYubiKey_CreateKey(t *testing.T) {
 	yk := newStubPivKey(t, ECDSA)
+	ykOld := newStubPivKey(t, ECDSA)
+	ykOld.version = piv.Version{Major: 5, Minor: 4, Patch: 3}
 
 	type fields struct {
 		yk            pivKey
@@ -762,6 +873,56 @@ func TestYubiKey_CreateKey(t *testing.T) {
 				},
 			}
 		}, false},
+		{"ok x25519", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name: "yubikey:slot-id=82;key-type=X25519",
+		}}, func() *apiv1.CreateKeyResponse {
+			return &apiv1.CreateKeyResponse{
+				Name:      "yubikey:slot-id=82",
+				PublicKey: yk.signerMap[slotMapping["82"]].(privateKey).Public(),
+				CreateSignerRequest: apiv1.CreateSignerRequest{
+					SigningKey: "yubikey:slot-id=82",
+				},
+			}
+		}, false},
+		{"ok old firmware p256", fields{ykOld, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name:               "yubikey:slot-id=82",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		}}, func() *apiv1.CreateKeyResponse {
+			return &apiv1.CreateKeyResponse{
+				Name:      "yubikey:slot-id=82",
+				PublicKey: ykOld.signerMap[slotMapping["82"]].(crypto.Signer).Public(),
+				CreateSignerRequest: apiv1.CreateSignerRequest{
+					SigningKey: "yubikey:slot-id=82",
+				},
+			}
+		}, false},
+		{"fail old firmware ed25519", fields{ykOld, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name:               "yubikey:slot-id=83",
+			SignatureAlgorithm: apiv1.PureEd25519,
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail old firmware x25519", fields{ykOld, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name: "yubikey:slot-id=83;key-type=x25519",
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail old firmware rsa 3072", fields{ykOld, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name:               "yubikey:slot-id=83",
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Bits:               3072,
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail old firmware rsa 4096", fields{ykOld, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name:               "yubikey:slot-id=83",
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Bits:               4096,
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail x25519 with signature algorithm", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name:               "yubikey:slot-id=83;key-type=x25519",
+			SignatureAlgorithm: apiv1.PureEd25519,
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail key-type", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name: "yubikey:slot-id=83;key-type=x448",
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
+		{"fail key-type uri", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
+			Name: "yubikey:slot-id=%%FF;key-type=x25519",
+		}}, func() *apiv1.CreateKeyResponse { return nil }, true},
 		{"fail rsa 512", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateKeyRequest{
 			Name:               "yubikey:slot-id=82",
 			SignatureAlgorithm: apiv1.SHA256WithRSA,
@@ -959,6 +1120,14 @@ func TestYubiKey_CreateSigner(t *testing.T) {
 	ykFail := newStubPivKey(t, ECDSA)
 	ykFail.signerMap[piv.SlotSignature] = "not-a-signer"
 
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	yk.keyInfoMap[piv.SlotCardAuthentication] = piv.KeyInfo{
+		PublicKey: edPub,
+		Algorithm: piv.AlgorithmEd25519,
+	}
+	yk.signerMap[piv.SlotCardAuthentication] = edKey
+
 	type fields struct {
 		yk            pivKey
 		pin           string
@@ -980,6 +1149,9 @@ func TestYubiKey_CreateSigner(t *testing.T) {
 		{"ok with pin", fields{yk, "", piv.DefaultManagementKey}, args{&apiv1.CreateSignerRequest{
 			SigningKey: "yubikey:slot-id=9c?pin-value=123456",
 		}}, &syncSigner{Signer: yk.signerMap[piv.SlotSignature].(crypto.Signer)}, false},
+		{"ok ed25519", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateSignerRequest{
+			SigningKey: "yubikey:slot-id=9e",
+		}}, &syncSigner{Signer: edKey}, false},
 		{"fail getSlot", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.CreateSignerRequest{
 			SigningKey: "yubikey:slot-id=%%FF",
 		}}, nil, true},
@@ -1077,6 +1249,125 @@ func TestYubiKey_CreateDecrypter(t *testing.T) {
 	}
 }
 
+func TestYubiKey_CreateECDH(t *testing.T) {
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p256ECDH, err := p256Key.ECDH()
+	require.NoError(t, err)
+
+	yk := newStubPivKey(t, RSA)
+	yk.keyInfoMap[piv.SlotKeyManagement] = piv.KeyInfo{
+		PublicKey: x25519Key.Public(),
+		Algorithm: piv.AlgorithmX25519,
+	}
+	yk.signerMap[piv.SlotKeyManagement] = x25519Key
+	yk.keyInfoMap[piv.SlotCardAuthentication] = piv.KeyInfo{
+		PublicKey: p256Key.Public(),
+		Algorithm: piv.AlgorithmEC256,
+	}
+	yk.signerMap[piv.SlotCardAuthentication] = p256ECDH
+
+	type fields struct {
+		yk  pivKey
+		pin string
+	}
+	type args struct {
+		req *apiv1.CreateDecrypterRequest
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		want      *ECDH
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok x25519", fields{yk, "123456"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=9d",
+		}}, &ECDH{key: x25519Key, pub: x25519Key.Public()}, assert.NoError},
+		{"ok p256", fields{yk, "123456"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=9e",
+		}}, &ECDH{key: p256ECDH, pub: p256Key.Public()}, assert.NoError},
+		{"ok with pin", fields{yk, ""}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=9d?pin-value=123456",
+		}}, &ECDH{key: x25519Key, pub: x25519Key.Public()}, assert.NoError},
+		{"fail getSlot", fields{yk, "123456"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=%%FF",
+		}}, nil, assert.Error},
+		{"fail getPublicKey", fields{yk, "123456"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=85",
+		}}, nil, assert.Error},
+		{"fail privateKey", fields{yk, "654321"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=9d",
+		}}, nil, assert.Error},
+		{"fail rsa", fields{yk, "123456"}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "yubikey:slot-id=9c",
+		}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{
+				yk:  tt.fields.yk,
+				pin: tt.fields.pin,
+			}
+			got, err := k.CreateECDH(tt.args.req)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestECDH(t *testing.T) {
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	x25519Peer, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p384ECDH, err := p384Key.ECDH()
+	require.NoError(t, err)
+	p384Peer, err := ecdh.P384().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("x25519", func(t *testing.T) {
+		e := &ECDH{key: x25519Key, pub: x25519Key.Public()}
+		assert.Equal(t, x25519Key.Public(), e.Public())
+		assert.Equal(t, x25519Key.PublicKey(), e.PublicKey())
+		assert.Equal(t, ecdh.X25519(), e.Curve())
+
+		secret, err := e.ECDH(x25519Peer.PublicKey())
+		require.NoError(t, err)
+		want, err := x25519Peer.ECDH(x25519Key.PublicKey())
+		require.NoError(t, err)
+		assert.Equal(t, want, secret)
+	})
+
+	t.Run("p384", func(t *testing.T) {
+		e := &ECDH{key: p384ECDH, pub: p384Key.Public()}
+		assert.Equal(t, p384Key.Public(), e.Public())
+		assert.Equal(t, p384ECDH.PublicKey(), e.PublicKey())
+		assert.Equal(t, ecdh.P384(), e.Curve())
+
+		secret, err := e.ECDH(p384Peer.PublicKey())
+		require.NoError(t, err)
+		want, err := p384Peer.ECDH(p384ECDH.PublicKey())
+		require.NoError(t, err)
+		assert.Equal(t, want, secret)
+
+		_, err = e.ECDH(x25519Peer.PublicKey())
+		assert.Error(t, err)
+	})
+
+	t.Run("ed25519", func(t *testing.T) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		e := &ECDH{key: x25519Key, pub: pub}
+		assert.Nil(t, e.PublicKey())
+		assert.Nil(t, e.Curve())
+	})
+}
+
 func TestYubiKey_CreateAttestation(t *testing.T) {
 	yk := newStubPivKey(t, ECDSA)
 
@@ -1310,6 +1601,41 @@ func Test_getSignatureAlgorithm(t *testing.T) {
 	}
 }
 
+func Test_checkFirmwareVersion(t *testing.T) {
+	v43 := piv.Version{Major: 4, Minor: 3, Patch: 7}
+	v56 := piv.Version{Major: 5, Minor: 6, Patch: 9}
+	v57 := piv.Version{Major: 5, Minor: 7, Patch: 0}
+	v61 := piv.Version{Major: 6, Minor: 1, Patch: 0}
+
+	type args struct {
+		v   piv.Version
+		alg piv.Algorithm
+	}
+	tests := []struct {
+		name      string
+		args      args
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok EC256", args{v43, piv.AlgorithmEC256}, assert.NoError},
+		{"ok EC384", args{v43, piv.AlgorithmEC384}, assert.NoError},
+		{"ok RSA1024", args{v43, piv.AlgorithmRSA1024}, assert.NoError},
+		{"ok RSA2048", args{v43, piv.AlgorithmRSA2048}, assert.NoError},
+		{"ok Ed25519", args{v57, piv.AlgorithmEd25519}, assert.NoError},
+		{"ok X25519", args{v57, piv.AlgorithmX25519}, assert.NoError},
+		{"ok RSA3072", args{v57, piv.AlgorithmRSA3072}, assert.NoError},
+		{"ok RSA4096", args{v61, piv.AlgorithmRSA4096}, assert.NoError},
+		{"fail Ed25519", args{v56, piv.AlgorithmEd25519}, assert.Error},
+		{"fail X25519", args{v56, piv.AlgorithmX25519}, assert.Error},
+		{"fail RSA3072", args{v43, piv.AlgorithmRSA3072}, assert.Error},
+		{"fail RSA4096", args{v43, piv.AlgorithmRSA4096}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.assertion(t, checkFirmwareVersion(tt.args.v, tt.args.alg))
+		})
+	}
+}
+
 func Test_syncSigner_Sign(t *testing.T) {
 	s, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	require.NoError(t, err)
@@ -24,6 +24,7 @@ import (
 
 	fileutils "go.step.sm/crypto/internal/utils/file"
 	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/rsapss"
 	"go.step.sm/crypto/x25519"
 )
 
@@ -239,7 +240,7 @@ func ParseCertificate(pemData []byte) (*x509.Certificate, error) {
 			continue
 		}
 
-		cert, err := x509.ParseCertificate(block.Bytes)
+		cert, err := rsapss.ParseCertificate(block.Bytes)
 		if err != nil {
 			return nil, errors.Wrap(err, "error parsing certificate")
 		}
@@ -270,7 +271,7 @@ func ParseCertificateBundle(data []byte) ([]*x509.Certificate, error) {
 				continue
 			}
 			var crt *x509.Certificate
-			crt, err = x509.ParseCertificate(block.Bytes)
+			crt, err = rsapss.ParseCertificate(block.Bytes)
 			if err != nil {
 				return nil, &InvalidPEMError{
 					Err:  err,
@@ -288,7 +289,7 @@ func ParseCertificateBundle(data []byte) ([]*x509.Certificate, error) {
 	}
 
 	// DER format (binary)
-	crt, err := x509.ParseCertificate(data)
+	crt, err := rsapss.ParseCertificate(data)
 	if err != nil {
 		return nil, &InvalidPEMError{
 			Message: fmt.Sprintf("error parsing certificate as DER format: %v", err),
@@ -315,7 +316,7 @@ func ParseCertificateRequest(data []byte) (*x509.CertificateRequest, error) {
 			if !strings.HasSuffix(block.Type, "CERTIFICATE REQUEST") {
 				continue
 			}
-			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			csr, err := rsapss.ParseCertificateRequest(block.Bytes)
 			if err != nil {
 				return nil, &InvalidPEMError{
 					Type: PEMTypeCertificateRequest,
@@ -328,7 +329,7 @@ func ParseCertificateRequest(data []byte) (*x509.CertificateRequest, error) {
 	}
 
 	// DER format (binary)
-	csr, err := x509.ParseCertificateRequest(data)
+	csr, err := rsapss.ParseCertificateRequest(data)
 	if err != nil {
 		return nil, &InvalidPEMError{
 			Message: fmt.Sprintf("error parsing certificate request as DER format: %v", err),
@@ -477,6 +478,13 @@ func Parse(b []byte, opts ...Options) (interface{}, error) {
 
 	switch block.Type {
 	case "PUBLIC KEY":
+		if rsapss.IsPKIXPublicKey(block.Bytes) {
+			pub, err := rsapss.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing %s", ctx.filename)
+			}
+			return pub, nil
+		}
 		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
 		return pub, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "RSA PRIVATE KEY":
@@ -486,16 +494,23 @@ func Parse(b []byte, opts ...Options) (interface{}, error) {
 		priv, err := x509.ParseECPrivateKey(block.Bytes)
 		return priv, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+		if rsapss.IsPKCS8PrivateKey(block.Bytes) {
+			priv, err := rsapss.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing %s", ctx.filename)
+			}
+			return priv, nil
+		}
 		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 		return priv, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "OPENSSH PRIVATE KEY":
 		priv, err := ParseOpenSSHPrivateKey(b, withContext(ctx))
 		return priv, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "CERTIFICATE":
-		crt, err := x509.ParseCertificate(block.Bytes)
+		crt, err := rsapss.ParseCertificate(block.Bytes)
 		return crt, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
-		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		csr, err := rsapss.ParseCertificateRequest(block.Bytes)
 		return csr, errors.Wrapf(err, "error parsing %s", ctx.filename)
 	case "ENCRYPTED COSIGN PRIVATE KEY":
 		pass, err := ctx.promptPassword()
@@ -533,9 +548,9 @@ func ParseKey(b []byte, opts ...Options) (interface{}, error) {
 // If the file is encrypted it will ask for a password and it will try
 // to decrypt it.
 //
-// Supported keys algorithms are RSA and EC. Supported standards for private
-// keys are PKCS#1, PKCS#8, RFC5915 for EC, and base64-encoded DER for
-// certificates and public keys.
+// Supported keys algorithms are RSA, RSA restricted to RSASSA-PSS, EC and
+// Ed25519. Supported standards for private keys are PKCS#1, PKCS#8, RFC5915 for
+// EC, and base64-encoded DER for certificates and public keys.
 func Read(filename string, opts ...Options) (interface{}, error) {
 	b, err := fileutils.ReadFile(filename)
 	if err != nil {
@@ -567,6 +582,15 @@ func Serialize(in interface{}, opts ...Options) (*pem.Block, error) {
 			Type:  "PUBLIC KEY",
 			Bytes: b,
 		}
+	case *rsapss.PublicKey:
+		b, err := rsapss.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		p = &pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: b,
+		}
 	case *rsa.PrivateKey:
 		isPrivateKey = true
 		switch {
@@ -627,6 +651,21 @@ func Serialize(in interface{}, opts ...Options) (*pem.Block, error) {
 				Bytes: b,
 			}
 		}
+	case *rsapss.PrivateKey:
+		isPrivateKey = true
+		if ctx.openSSH {
+			return nil, errors.New("RSASSA-PSS keys cannot be serialized using the OpenSSH format")
+		}
+		// RSASSA-PSS keys can only be encoded using PKCS#8
+		ctx.pkcs8 = true
+		b, err := rsapss.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		p = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: b,
+		}
 	case *x509.Certificate:
 		p = &pem.Block{
 			Type:  "CERTIFICATE",
@@ -678,6 +717,22 @@ func Serialize(in interface{}, opts ...Options) (*pem.Block, error) {
 // ParseDER parses the given DER-encoded bytes and results the public or private
 // key encoded.
 func ParseDER(b []byte) (interface{}, error) {
+	// Try RSASSA-PSS keys
+	switch {
+	case rsapss.IsPKCS8PrivateKey(b):
+		key, err := rsapss.ParsePKCS8PrivateKey(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding DER")
+		}
+		return key, nil
+	case rsapss.IsPKIXPublicKey(b):
+		key, err := rsapss.ParsePKIXPublicKey(b)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding DER")
+		}
+		return key, nil
+	}
+
 	// Try private keys
 	key, err := x509.ParsePKCS8PrivateKey(b)
 	if err != nil {
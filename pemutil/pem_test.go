@@ -25,6 +25,7 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/rsapss"
 	"go.step.sm/crypto/x25519"
 )
 
@@ -1410,3 +1411,83 @@ func TestUnbundleCertificate(t *testing.T) {
 		})
 	}
 }
+
+func TestRead_rsapss(t *testing.T) {
+	sha256Params := rsapss.NewParameters(crypto.SHA256)
+	tests := []struct {
+		filename string
+		want     interface{}
+		params   *rsapss.Parameters
+	}{
+		{"testdata/pkcs8/openssl.rsapss2048.pem", &rsapss.PrivateKey{}, nil},
+		{"testdata/pkcs8/openssl.rsapss2048.pub.pem", &rsapss.PublicKey{}, nil},
+		{"testdata/pkcs8/openssl.rsapss2048.sha256.pem", &rsapss.PrivateKey{}, sha256Params},
+		{"testdata/pkcs8/openssl.rsapss2048.sha256.pub.pem", &rsapss.PublicKey{}, sha256Params},
+		{"testdata/openssl.rsapss.crt", &x509.Certificate{}, sha256Params},
+		{"testdata/openssl.rsapss.csr", &x509.CertificateRequest{}, sha256Params},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, err := Read(tt.filename)
+			require.NoError(t, err)
+			require.IsType(t, tt.want, got)
+
+			var pub crypto.PublicKey
+			switch v := got.(type) {
+			case *rsapss.PrivateKey:
+				pub = v.Public()
+			case *rsapss.PublicKey:
+				pub = v
+			case *x509.Certificate:
+				pub = v.PublicKey
+			case *x509.CertificateRequest:
+				pub = v.PublicKey
+			}
+			require.IsType(t, &rsapss.PublicKey{}, pub)
+			assert.Equal(t, tt.params, pub.(*rsapss.PublicKey).Parameters)
+
+			// Serialize and parse keys again.
+			switch got.(type) {
+			case *rsapss.PrivateKey, *rsapss.PublicKey:
+				block, err := Serialize(got)
+				require.NoError(t, err)
+				b, err := os.ReadFile(tt.filename)
+				require.NoError(t, err)
+				assert.Equal(t, b, pem.EncodeToMemory(block))
+			}
+		})
+	}
+}
+
+func TestSerialize_rsapss(t *testing.T) {
+	key, err := rsapss.GenerateKey(rand.Reader, 2048, rsapss.NewParameters(crypto.SHA384))
+	require.NoError(t, err)
+
+	block, err := Serialize(key)
+	require.NoError(t, err)
+	assert.Equal(t, "PRIVATE KEY", block.Type)
+	got, err := ParseDER(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	block, err = Serialize(key, WithPKCS8(false))
+	require.NoError(t, err)
+	assert.Equal(t, "PRIVATE KEY", block.Type)
+
+	block, err = Serialize(key, WithPassword([]byte("mypassword")))
+	require.NoError(t, err)
+	assert.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type)
+	got, err = ParseKey(pem.EncodeToMemory(block), WithPassword([]byte("mypassword")))
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	block, err = Serialize(key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, "PUBLIC KEY", block.Type)
+	got, err = ParseDER(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, key.Public(), got)
+
+	_, err = Serialize(key, WithOpenSSH(true))
+	assert.Error(t, err)
+}
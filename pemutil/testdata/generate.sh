@@ -95,3 +95,17 @@ do
    cp openssh.rsa$size.pem openssh.rsa$size.enc.pem
    $SSH_KEYGEN -p -N mypassword -f openssh.rsa$size.enc.pem
 done
+
+#######################################
+# RSA-PSS                             #
+#######################################
+
+$OPENSSL genpkey -algorithm RSA-PSS -pkeyopt rsa_keygen_bits:2048 -out pkcs8/openssl.rsapss2048.pem
+$OPENSSL genpkey -algorithm RSA-PSS -pkeyopt rsa_keygen_bits:2048 -pkeyopt rsa_pss_keygen_md:sha256 -pkeyopt rsa_pss_keygen_mgf1_md:sha256 -pkeyopt rsa_pss_keygen_saltlen:32 -out pkcs8/openssl.rsapss2048.sha256.pem
+for name in rsapss2048 rsapss2048.sha256
+do
+	$OPENSSL pkey -outform PEM -in "pkcs8/openssl.$name.pem" -pubout -out "pkcs8/openssl.$name.pub.pem"
+done
+
+$OPENSSL req -new -x509 -key pkcs8/openssl.rsapss2048.sha256.pem -subj "/CN=RSA-PSS Root" -days 36500 -sha256 -sigopt rsa_padding_mode:pss -sigopt rsa_pss_saltlen:32 -out openssl.rsapss.crt
+$OPENSSL req -new -key pkcs8/openssl.rsapss2048.sha256.pem -subj "/CN=rsapss.smallstep.com" -sha256 -sigopt rsa_padding_mode:pss -sigopt rsa_pss_saltlen:32 -out openssl.rsapss.csr
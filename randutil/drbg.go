@@ -0,0 +1,318 @@
+package randutil
+
+import (
+	"crypto/rand"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// securityStrength is the security strength of the DRBGs, in bits.
+	securityStrength = 256
+	// nonceSize is the size of the nonce used to instantiate a DRBG.
+	nonceSize = 16
+	// maxRequestSize is the maximum number of bytes generated per request,
+	// 2^19 bits as defined in NIST SP 800-90A for both mechanisms. Larger
+	// reads are split in multiple requests.
+	maxRequestSize = 1 << 16
+	// maxReseedInterval is the maximum number of requests between reseeds
+	// allowed by NIST SP 800-90A.
+	maxReseedInterval = 1 << 48
+)
+
+// DefaultReseedInterval is the default number of requests after which a DRBG
+// is reseeded. Every read of up to 64KiB is a request.
+const DefaultReseedInterval = 1 << 20
+
+// EntropySource is a source of entropy used to seed and reseed a DRBG. The
+// output of the source is checked by the health tests defined in NIST SP
+// 800-90B.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type EntropySource struct {
+	// Name identifies the source in errors.
+	Name string
+	// Reader is the reader entropy is read from. For example, crypto/rand's
+	// Reader, the reader returned by go.step.sm/crypto/tpm/rand.New, or the
+	// reader returned by the RandomReader method of a PKCS #11 KMS.
+	Reader io.Reader
+	// MinEntropy is the assessed min-entropy of every byte of the source, in
+	// bits. It defaults to 8, a full entropy source. Sources with lower
+	// min-entropy provide proportionally more bytes on every seed.
+	MinEntropy float64
+}
+
+// entropySource is an EntropySource with the state of its health tests.
+type entropySource struct {
+	name   string
+	reader io.Reader
+	size   int
+	health *healthTests
+	failed error
+}
+
+func newEntropySource(src EntropySource) (*entropySource, error) {
+	switch {
+	case src.Reader == nil:
+		return nil, errors.Errorf("entropy source %q: reader cannot be nil", src.Name)
+	case src.MinEntropy == 0:
+		src.MinEntropy = 8
+	case src.MinEntropy < 0 || src.MinEntropy > 8 || math.IsNaN(src.MinEntropy):
+		return nil, errors.Errorf("entropy source %q: min-entropy must be between 0 and 8 bits", src.Name)
+	}
+
+	s := &entropySource{
+		name:   src.Name,
+		reader: src.Reader,
+		size:   int(math.Ceil(securityStrength / src.MinEntropy)),
+		health: newHealthTests(src.MinEntropy),
+	}
+
+	// start-up health tests
+	if _, err := s.read(startupSamples); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// read reads n bytes from the source and runs them through the health
+// tests.
+func (s *entropySource) read(n int) ([]byte, error) {
+	if s.failed != nil {
+		return nil, s.failed
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.reader, b); err != nil {
+		return nil, errors.Wrapf(err, "error reading entropy source %q", s.name)
+	}
+	if err := s.health.check(b); err != nil {
+		s.failed = errors.Wrapf(err, "entropy source %q", s.name)
+		return nil, s.failed
+	}
+	return b, nil
+}
+
+type drbgOptions struct {
+	sources         []EntropySource
+	personalization []byte
+	reseedInterval  uint64
+	reseedPeriod    time.Duration
+}
+
+// DRBGOption is the type of the options that can be passed to NewHMACDRBG
+// and NewCTRDRBG.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type DRBGOption func(o *drbgOptions)
+
+// WithEntropySource adds an entropy source used to seed and reseed the DRBG.
+// If multiple sources are added, every seed contains entropy from all of
+// them, so the DRBG is securely seeded as long as one of them is. If no
+// source is added, crypto/rand's Reader is used.
+func WithEntropySource(src EntropySource) DRBGOption {
+	return func(o *drbgOptions) {
+		o.sources = append(o.sources, src)
+	}
+}
+
+// WithPersonalization sets the personalization string used to instantiate
+// the DRBG.
+func WithPersonalization(personalization []byte) DRBGOption {
+	return func(o *drbgOptions) {
+		o.personalization = personalization
+	}
+}
+
+// WithReseedInterval sets the number of requests after which the DRBG is
+// reseeded. Every read of up to 64KiB is a request. It defaults to
+// DefaultReseedInterval.
+func WithReseedInterval(requests uint64) DRBGOption {
+	return func(o *drbgOptions) {
+		o.reseedInterval = requests
+	}
+}
+
+// WithReseedPeriod sets the time after which the DRBG is reseeded. By
+// default, DRBGs are only reseeded after the reseed interval.
+func WithReseedPeriod(d time.Duration) DRBGOption {
+	return func(o *drbgOptions) {
+		o.reseedPeriod = d
+	}
+}
+
+// DRBG is a deterministic random bit generator, as defined in NIST SP
+// 800-90A, seeded and periodically reseeded from one or more entropy
+// sources. It implements io.Reader, and it's safe for concurrent use.
+//
+// If an entropy source fails a health test, the DRBG enters an error state,
+// and all following reads fail.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type DRBG struct {
+	mu             sync.Mutex
+	mechanism      mechanism
+	sources        []*entropySource
+	reseedInterval uint64
+	reseedPeriod   time.Duration
+	reseedCounter  uint64
+	lastReseed     time.Time
+	err            error
+	now            func() time.Time
+}
+
+// NewHMACDRBG returns a new HMAC_DRBG, using HMAC-SHA256, with a security
+// strength of 256 bits.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewHMACDRBG(opts ...DRBGOption) (*DRBG, error) {
+	return newDRBG(&hmacDRBG{}, opts)
+}
+
+// NewCTRDRBG returns a new CTR_DRBG, using AES-256 and a derivation function,
+// with a security strength of 256 bits.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewCTRDRBG(opts ...DRBGOption) (*DRBG, error) {
+	return newDRBG(&ctrDRBG{}, opts)
+}
+
+func newDRBG(m mechanism, opts []DRBGOption) (*DRBG, error) {
+	o := &drbgOptions{
+		reseedInterval: DefaultReseedInterval,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	switch {
+	case o.reseedInterval == 0 || o.reseedInterval > maxReseedInterval:
+		return nil, errors.Errorf("reseed interval must be between 1 and %d", uint64(maxReseedInterval))
+	case o.reseedPeriod < 0:
+		return nil, errors.New("reseed period cannot be negative")
+	}
+
+	if len(o.sources) == 0 {
+		o.sources = []EntropySource{{Name: "os", Reader: rand.Reader}}
+	}
+
+	d := &DRBG{
+		mechanism:      m,
+		reseedInterval: o.reseedInterval,
+		reseedPeriod:   o.reseedPeriod,
+		now:            time.Now,
+	}
+	for _, src := range o.sources {
+		s, err := newEntropySource(src)
+		if err != nil {
+			return nil, errors.Wrap(err, "error starting entropy source")
+		}
+		d.sources = append(d.sources, s)
+	}
+
+	entropy, err := d.entropyInput()
+	if err != nil {
+		return nil, errors.Wrap(err, "error instantiating DRBG")
+	}
+	nonce, err := d.sources[0].read(nonceSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "error instantiating DRBG")
+	}
+	d.mechanism.instantiate(entropy, nonce, o.personalization)
+	d.reseedCounter = 1
+	d.lastReseed = d.now()
+
+	return d, nil
+}
+
+// entropyInput returns the concatenation of the entropy read from all the
+// sources. If a source fails a health test, the DRBG enters an error state.
+func (d *DRBG) entropyInput() ([]byte, error) {
+	var entropy []byte
+	for _, s := range d.sources {
+		b, err := s.read(s.size)
+		if err != nil {
+			if errors.Is(err, ErrHealthTest) {
+				d.err = err
+			}
+			return nil, err
+		}
+		entropy = append(entropy, b...)
+	}
+	return entropy, nil
+}
+
+// Read fills p with random bytes. It reseeds the DRBG if the reseed interval
+// or period has been reached.
+func (d *DRBG) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		if err := d.maybeReseed(); err != nil {
+			return 0, err
+		}
+		size := min(len(p), maxRequestSize)
+		d.mechanism.generate(p[:size], nil)
+		d.reseedCounter++
+		p = p[size:]
+	}
+
+	return n, nil
+}
+
+// Reseed reseeds the DRBG with new entropy from all the sources, and the
+// given additional input.
+func (d *DRBG) Reseed(additionalInput []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.reseed(additionalInput)
+}
+
+func (d *DRBG) maybeReseed() error {
+	switch {
+	case d.err != nil:
+		return d.err
+	case d.reseedCounter > d.reseedInterval:
+		return d.reseed(nil)
+	case d.reseedPeriod > 0 && d.now().Sub(d.lastReseed) >= d.reseedPeriod:
+		return d.reseed(nil)
+	default:
+		return nil
+	}
+}
+
+func (d *DRBG) reseed(additionalInput []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	entropy, err := d.entropyInput()
+	if err != nil {
+		return errors.Wrap(err, "error reseeding DRBG")
+	}
+	d.mechanism.reseed(entropy, additionalInput)
+	d.reseedCounter = 1
+	d.lastReseed = d.now()
+	return nil
+}
@@ -0,0 +1,293 @@
+package randutil
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// newTestSource returns a deterministic entropy source with the AES-CTR key
+// stream of the given key byte.
+func newTestSource(key byte) io.Reader {
+	block, _ := aes.NewCipher(bytes.Repeat([]byte{key}, 16))
+	return cipher.StreamReader{
+		S: cipher.NewCTR(block, make([]byte, aes.BlockSize)),
+		R: zeroReader{},
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countingReader counts the bytes read from the underlying reader, and
+// fails or returns zeros when requested.
+type countingReader struct {
+	mu    sync.Mutex
+	r     io.Reader
+	n     int
+	err   error
+	zeros bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return 0, c.err
+	case c.zeros:
+		clear(p)
+		return len(p), nil
+	}
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) read() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func Test_mechanisms(t *testing.T) {
+	// NIST CAVP test vectors, without prediction resistance, personalization
+	// or additional input. The returned bits are the output of the second
+	// generate call.
+	tests := []struct {
+		name      string
+		mechanism mechanism
+		entropy   string
+		nonce     string
+		want      string
+	}{
+		{"HMAC_DRBG SHA-256", &hmacDRBG{},
+			"ca851911349384bffe89de1cbdc46e6831e44d34a4fb935ee285dd14b71a7488",
+			"659ba96c601dc69fc902940805ec0ca8",
+			"e528e9abf2dece54d47c7e75e5fe302149f817ea9fb4bee6f4199697d04d5b89d54fbb978a15b5c443c9ec21036d2460b6f73ebad0dc2aba6e624abf07745bc107694bb7547bb0995f70de25d6b29e2d3011bb19d27676c07162c8b5ccde0668961df86803482cb37ed6d5c0bb8d50cf1f50d476aa0458bdaba806f48be9dcb8"},
+		{"CTR_DRBG AES-256 use df", &ctrDRBG{},
+			"36401940fa8b1fba91a1661f211d78a0b9389a74e5bccfece8d766af1a6d3b14",
+			"496f25b0f1301b4f501be30380a137eb",
+			"5862eb38bd558dd978a696e6df164782ddd887e7e9a6c9f3f1fbafb78941b535a64912dfd224c6dc7454e5250b3d97165e16260c2faf1cc7735cb75fb4f07e1d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := mustDecodeHex(t, tt.want)
+			tt.mechanism.instantiate(mustDecodeHex(t, tt.entropy), mustDecodeHex(t, tt.nonce), nil)
+			got := make([]byte, len(want))
+			tt.mechanism.generate(got, nil)
+			tt.mechanism.generate(got, nil)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func Test_mechanisms_inputs(t *testing.T) {
+	entropy := bytes.Repeat([]byte{1}, 32)
+	nonce := bytes.Repeat([]byte{2}, 16)
+	for name, newMechanism := range map[string]func() mechanism{
+		"hmac": func() mechanism { return &hmacDRBG{} },
+		"ctr":  func() mechanism { return &ctrDRBG{} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			generate := func(m mechanism, additionalInput []byte) []byte {
+				b := make([]byte, 100)
+				m.generate(b, additionalInput)
+				return b
+			}
+
+			a, b := newMechanism(), newMechanism()
+			a.instantiate(entropy, nonce, nil)
+			b.instantiate(entropy, nonce, nil)
+			assert.Equal(t, generate(a, nil), generate(b, nil))
+			assert.NotEqual(t, generate(a, nil), generate(b, []byte("additional input")))
+
+			a.instantiate(entropy, nonce, nil)
+			b.instantiate(entropy, nonce, []byte("personalization"))
+			assert.NotEqual(t, generate(a, nil), generate(b, nil))
+
+			a.instantiate(entropy, nonce, nil)
+			b.instantiate(entropy, nonce, nil)
+			b.reseed(entropy, nil)
+			assert.NotEqual(t, generate(a, nil), generate(b, nil))
+		})
+	}
+}
+
+func TestDRBG(t *testing.T) {
+	for name, newDRBG := range map[string]func(...DRBGOption) (*DRBG, error){
+		"hmac": NewHMACDRBG,
+		"ctr":  NewCTRDRBG,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// deterministic sources generate the same output
+			newTestDRBG := func(opts ...DRBGOption) *DRBG {
+				d, err := newDRBG(append([]DRBGOption{
+					WithEntropySource(EntropySource{Name: "one", Reader: newTestSource(1)}),
+					WithEntropySource(EntropySource{Name: "two", Reader: newTestSource(2)}),
+				}, opts...)...)
+				require.NoError(t, err)
+				return d
+			}
+			read := func(d *DRBG, n int) []byte {
+				b := make([]byte, n)
+				_, err := io.ReadFull(d, b)
+				require.NoError(t, err)
+				return b
+			}
+
+			a, b := newTestDRBG(), newTestDRBG()
+			assert.Equal(t, read(a, 1000), read(b, 1000))
+			assert.Equal(t, read(a, 200000), read(b, 200000))
+			assert.NotEqual(t, read(a, 32), read(a, 32))
+
+			b = newTestDRBG(WithPersonalization([]byte("personalization")))
+			assert.NotEqual(t, read(newTestDRBG(), 32), read(b, 32))
+
+			a, b = newTestDRBG(), newTestDRBG()
+			require.NoError(t, b.Reseed([]byte("additional input")))
+			assert.NotEqual(t, read(a, 32), read(b, 32))
+
+			// the default source is crypto/rand
+			d, err := newDRBG()
+			require.NoError(t, err)
+			assert.NotEqual(t, read(d, 32), read(d, 32))
+		})
+	}
+}
+
+func TestDRBG_reseed(t *testing.T) {
+	one := &countingReader{r: newTestSource(1)}
+	two := &countingReader{r: newTestSource(2)}
+	d, err := NewHMACDRBG(
+		WithEntropySource(EntropySource{Name: "one", Reader: one}),
+		WithEntropySource(EntropySource{Name: "two", Reader: two, MinEntropy: 4}),
+		WithReseedInterval(2),
+		WithReseedPeriod(time.Hour),
+	)
+	require.NoError(t, err)
+
+	// start-up tests, entropy input, and nonce from the first source
+	assert.Equal(t, 1024+32+16, one.read())
+	assert.Equal(t, 1024+64, two.read())
+
+	b := make([]byte, 32)
+	_, err = d.Read(b)
+	require.NoError(t, err)
+	_, err = d.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, 1024+32+16, one.read())
+
+	// reseed after the interval
+	_, err = d.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, 1024+32+16+32, one.read())
+	assert.Equal(t, 1024+64+64, two.read())
+
+	// large reads are split in multiple requests
+	_, err = d.Read(make([]byte, 3*maxRequestSize))
+	require.NoError(t, err)
+	assert.Equal(t, 1024+32+16+64, one.read())
+
+	// reseed after the period
+	now := time.Now()
+	d.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = d.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, 1024+32+16+96, one.read())
+	_, err = d.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, 1024+32+16+96, one.read())
+
+	// explicit reseed
+	require.NoError(t, d.Reseed(nil))
+	assert.Equal(t, 1024+32+16+128, one.read())
+
+	// reads fail while a source fails, and work when it recovers
+	two.mu.Lock()
+	two.err = errors.New("an error")
+	two.mu.Unlock()
+	assert.Error(t, d.Reseed(nil))
+	two.mu.Lock()
+	two.err = nil
+	two.mu.Unlock()
+	assert.NoError(t, d.Reseed(nil))
+
+	// health test failures are permanent
+	two.mu.Lock()
+	two.zeros = true
+	two.mu.Unlock()
+	err = d.Reseed(nil)
+	assert.ErrorIs(t, err, ErrHealthTest)
+	assert.Contains(t, err.Error(), `entropy source "two"`)
+	two.mu.Lock()
+	two.zeros = false
+	two.mu.Unlock()
+	_, err = d.Read(b)
+	assert.ErrorIs(t, err, ErrHealthTest)
+	assert.ErrorIs(t, d.Reseed(nil), ErrHealthTest)
+}
+
+func TestDRBG_concurrent(t *testing.T) {
+	d, err := NewCTRDRBG(WithReseedInterval(10))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = make([]byte, 1000)
+			_, err := io.ReadFull(d, results[i])
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	for i := 1; i < len(results); i++ {
+		assert.NotEqual(t, results[0], results[i])
+	}
+}
+
+func TestNewHMACDRBG_fail(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []DRBGOption
+		want string
+	}{
+		{"fail reseed interval", []DRBGOption{WithReseedInterval(0)}, "reseed interval must be between 1 and 281474976710656"},
+		{"fail reseed interval max", []DRBGOption{WithReseedInterval(1<<48 + 1)}, "reseed interval must be between 1 and 281474976710656"},
+		{"fail reseed period", []DRBGOption{WithReseedPeriod(-time.Second)}, "reseed period cannot be negative"},
+		{"fail reader", []DRBGOption{WithEntropySource(EntropySource{Name: "nil"})}, `error starting entropy source: entropy source "nil": reader cannot be nil`},
+		{"fail min-entropy", []DRBGOption{WithEntropySource(EntropySource{Name: "os", Reader: newTestSource(1), MinEntropy: 9})}, `error starting entropy source: entropy source "os": min-entropy must be between 0 and 8 bits`},
+		{"fail read", []DRBGOption{WithEntropySource(EntropySource{Name: "error", Reader: &countingReader{err: errors.New("an error")}})}, `error starting entropy source: error reading entropy source "error": an error`},
+		{"fail health test", []DRBGOption{WithEntropySource(EntropySource{Name: "zero", Reader: zeroReader{}})}, `error starting entropy source: entropy source "zero": repetition count test failed: entropy source health test failed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewHMACDRBG(tt.opts...)
+			assert.EqualError(t, err, tt.want)
+			assert.Nil(t, d)
+		})
+	}
+}
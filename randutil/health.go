@@ -0,0 +1,99 @@
+package randutil
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// healthTestAlpha is the false positive probability of the health
+	// tests, 2^-40.
+	healthTestAlpha = 40
+	// aptWindowSize is the window size of the adaptive proportion test for
+	// non-binary samples.
+	aptWindowSize = 512
+	// startupSamples is the number of samples run through the health tests
+	// when an entropy source is started.
+	startupSamples = 1024
+)
+
+// ErrHealthTest is the error returned when the output of an entropy source
+// fails a health test. An entropy source that has failed a health test is
+// never used again.
+var ErrHealthTest = errors.New("entropy source health test failed")
+
+// healthTests are the continuous health tests defined in NIST SP 800-90B
+// section 4.4, the repetition count test and the adaptive proportion test,
+// using bytes as samples.
+type healthTests struct {
+	rctCutoff int
+	aptCutoff int
+
+	// repetition count test state
+	rctLast  byte
+	rctCount int
+
+	// adaptive proportion test state
+	aptFirst byte
+	aptCount int
+	aptIndex int
+}
+
+// newHealthTests returns the health tests for a source with the given
+// min-entropy per byte, in bits.
+func newHealthTests(minEntropy float64) *healthTests {
+	return &healthTests{
+		rctCutoff: 1 + int(math.Ceil(healthTestAlpha/minEntropy)),
+		aptCutoff: aptCutoff(aptWindowSize, math.Pow(2, -minEntropy)),
+	}
+}
+
+// aptCutoff returns the smallest cutoff c such that the probability of
+// seeing a sample with probability p at least c times in a window of w
+// samples is at most 2^-40.
+func aptCutoff(w int, p float64) int {
+	alpha := math.Pow(2, -healthTestAlpha)
+	logP, logQ := math.Log(p), math.Log1p(-p)
+	lgW, _ := math.Lgamma(float64(w + 1))
+
+	// accumulate the upper tail of the binomial distribution, from the
+	// largest count down, until it exceeds alpha
+	var tail float64
+	for c := w; c > 0; c-- {
+		lgC, _ := math.Lgamma(float64(c + 1))
+		lgWC, _ := math.Lgamma(float64(w - c + 1))
+		tail += math.Exp(lgW - lgC - lgWC + float64(c)*logP + float64(w-c)*logQ)
+		if tail > alpha {
+			return c + 1
+		}
+	}
+	return 1
+}
+
+// check runs the samples through the health tests. It returns ErrHealthTest
+// if any of them fails.
+func (h *healthTests) check(samples []byte) error {
+	for _, b := range samples {
+		if h.rctCount > 0 && b == h.rctLast {
+			if h.rctCount++; h.rctCount >= h.rctCutoff {
+				return errors.Wrap(ErrHealthTest, "repetition count test failed")
+			}
+		} else {
+			h.rctLast, h.rctCount = b, 1
+		}
+
+		switch {
+		case h.aptIndex == 0:
+			h.aptFirst, h.aptCount = b, 1
+		case b == h.aptFirst:
+			if h.aptCount++; h.aptCount >= h.aptCutoff {
+				return errors.Wrap(ErrHealthTest, "adaptive proportion test failed")
+			}
+		}
+		if h.aptIndex++; h.aptIndex == aptWindowSize {
+			h.aptIndex = 0
+		}
+	}
+	return nil
+}
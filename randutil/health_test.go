@@ -0,0 +1,63 @@
+package randutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newHealthTests(t *testing.T) {
+	tests := []struct {
+		minEntropy float64
+		rctCutoff  int
+		aptCutoff  int
+	}{
+		{8, 6, 19},
+		{4, 11, 78},
+		{1, 41, 336},
+		{0.5, 81, 432},
+	}
+	for _, tt := range tests {
+		h := newHealthTests(tt.minEntropy)
+		assert.Equal(t, tt.rctCutoff, h.rctCutoff)
+		assert.Equal(t, tt.aptCutoff, h.aptCutoff)
+	}
+}
+
+func Test_healthTests_check(t *testing.T) {
+	random := make([]byte, 1<<16)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	// the repetition count test fails on the cutoff
+	h := newHealthTests(8)
+	assert.NoError(t, h.check(random))
+	assert.NoError(t, h.check([]byte{1, 2, 2, 2, 2, 2, 3}))
+	assert.ErrorIs(t, h.check(bytes.Repeat([]byte{4}, 6)), ErrHealthTest)
+
+	// the repetition count continues between checks
+	h = newHealthTests(8)
+	assert.NoError(t, h.check([]byte{5, 5, 5}))
+	assert.EqualError(t, h.check([]byte{5, 5, 5}), "repetition count test failed: entropy source health test failed")
+
+	// the adaptive proportion test fails when the first sample of a window
+	// is too common
+	h = newHealthTests(8)
+	window := make([]byte, aptWindowSize)
+	for i := range window {
+		window[i] = byte(i%251 + 1)
+	}
+	assert.NoError(t, h.check(window))
+	for i := 0; i < h.aptCutoff; i++ {
+		window[i*10] = 0
+	}
+	assert.NoError(t, h.check(window[:(h.aptCutoff-1)*10]))
+	assert.EqualError(t, h.check(window[(h.aptCutoff-1)*10:]), "adaptive proportion test failed: entropy source health test failed")
+
+	// the same samples in a new window pass
+	h = newHealthTests(8)
+	assert.NoError(t, h.check(window[1:]))
+}
@@ -0,0 +1,213 @@
+package randutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// mechanism is a DRBG mechanism defined in NIST SP 800-90A. The mechanisms
+// don't check their inputs or their reseed counter, that's done by the DRBG
+// using them.
+type mechanism interface {
+	instantiate(entropy, nonce, personalization []byte)
+	reseed(entropy, additionalInput []byte)
+	generate(out, additionalInput []byte)
+}
+
+// hmacDRBG is the HMAC_DRBG mechanism, using HMAC-SHA256, defined in NIST SP
+// 800-90A section 10.1.2.
+type hmacDRBG struct {
+	k []byte
+	v []byte
+}
+
+func (d *hmacDRBG) update(provided ...[]byte) {
+	h := hmac.New(sha256.New, d.k)
+	h.Write(d.v)
+	h.Write([]byte{0x00})
+	for _, p := range provided {
+		h.Write(p)
+	}
+	d.k = h.Sum(d.k[:0])
+
+	h = hmac.New(sha256.New, d.k)
+	h.Write(d.v)
+	d.v = h.Sum(d.v[:0])
+
+	if totalLen(provided) == 0 {
+		return
+	}
+
+	h = hmac.New(sha256.New, d.k)
+	h.Write(d.v)
+	h.Write([]byte{0x01})
+	for _, p := range provided {
+		h.Write(p)
+	}
+	d.k = h.Sum(d.k[:0])
+
+	h = hmac.New(sha256.New, d.k)
+	h.Write(d.v)
+	d.v = h.Sum(d.v[:0])
+}
+
+func (d *hmacDRBG) instantiate(entropy, nonce, personalization []byte) {
+	d.k = make([]byte, sha256.Size)
+	d.v = make([]byte, sha256.Size)
+	for i := range d.v {
+		d.v[i] = 0x01
+	}
+	d.update(entropy, nonce, personalization)
+}
+
+func (d *hmacDRBG) reseed(entropy, additionalInput []byte) {
+	d.update(entropy, additionalInput)
+}
+
+func (d *hmacDRBG) generate(out, additionalInput []byte) {
+	if len(additionalInput) > 0 {
+		d.update(additionalInput)
+	}
+	h := hmac.New(sha256.New, d.k)
+	for len(out) > 0 {
+		h.Reset()
+		h.Write(d.v)
+		d.v = h.Sum(d.v[:0])
+		out = out[copy(out, d.v):]
+	}
+	d.update(additionalInput)
+}
+
+const (
+	// ctrKeyLen is the size of the AES-256 key of the CTR_DRBG.
+	ctrKeyLen = 32
+	// ctrSeedLen is the seed length of the CTR_DRBG, the key length plus the
+	// block length.
+	ctrSeedLen = ctrKeyLen + aes.BlockSize
+)
+
+// ctrDRBG is the CTR_DRBG mechanism, using AES-256 and a derivation
+// function, defined in NIST SP 800-90A section 10.2.1.
+type ctrDRBG struct {
+	block cipher.Block
+	v     [aes.BlockSize]byte
+}
+
+func (d *ctrDRBG) setKey(key []byte) {
+	// aes.NewCipher only fails with invalid key sizes.
+	d.block, _ = aes.NewCipher(key)
+}
+
+func (d *ctrDRBG) increment() {
+	for i := len(d.v) - 1; i >= 0; i-- {
+		d.v[i]++
+		if d.v[i] != 0 {
+			return
+		}
+	}
+}
+
+func (d *ctrDRBG) update(provided []byte) {
+	var temp [ctrSeedLen]byte
+	for i := 0; i < ctrSeedLen; i += aes.BlockSize {
+		d.increment()
+		d.block.Encrypt(temp[i:], d.v[:])
+	}
+	for i := range provided {
+		temp[i] ^= provided[i]
+	}
+	d.setKey(temp[:ctrKeyLen])
+	copy(d.v[:], temp[ctrKeyLen:])
+}
+
+func (d *ctrDRBG) instantiate(entropy, nonce, personalization []byte) {
+	d.setKey(make([]byte, ctrKeyLen))
+	d.v = [aes.BlockSize]byte{}
+	d.update(blockCipherDF(ctrSeedLen, entropy, nonce, personalization))
+}
+
+func (d *ctrDRBG) reseed(entropy, additionalInput []byte) {
+	d.update(blockCipherDF(ctrSeedLen, entropy, additionalInput))
+}
+
+func (d *ctrDRBG) generate(out, additionalInput []byte) {
+	var seed []byte
+	if len(additionalInput) > 0 {
+		seed = blockCipherDF(ctrSeedLen, additionalInput)
+		d.update(seed)
+	}
+	var block [aes.BlockSize]byte
+	for len(out) > 0 {
+		d.increment()
+		d.block.Encrypt(block[:], d.v[:])
+		out = out[copy(out, block[:]):]
+	}
+	d.update(seed)
+}
+
+// blockCipherDF is the Block_Cipher_df derivation function, using AES-256,
+// defined in NIST SP 800-90A section 10.3.2. It returns `n` bytes derived
+// from the concatenation of the inputs.
+func blockCipherDF(n int, input ...[]byte) []byte {
+	// S = L || N || input || 0x80, padded with zeros to the block size
+	l := totalLen(input)
+	s := make([]byte, 8, 8+l+aes.BlockSize)
+	binary.BigEndian.PutUint32(s[0:], uint32(l)) //nolint:gosec // inputs are much smaller than 4GiB
+	binary.BigEndian.PutUint32(s[4:], uint32(n)) //nolint:gosec // n is at most ctrSeedLen
+	for _, in := range input {
+		s = append(s, in...)
+	}
+	s = append(s, 0x80)
+	for len(s)%aes.BlockSize != 0 {
+		s = append(s, 0x00)
+	}
+
+	key := make([]byte, ctrKeyLen)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	block, _ := aes.NewCipher(key)
+
+	temp := make([]byte, 0, ctrSeedLen)
+	var iv [aes.BlockSize]byte
+	for i := uint32(0); len(temp) < ctrSeedLen; i++ {
+		binary.BigEndian.PutUint32(iv[:], i)
+		temp = append(temp, bcc(block, iv[:], s)...)
+	}
+
+	block, _ = aes.NewCipher(temp[:ctrKeyLen])
+	x := temp[ctrKeyLen:ctrSeedLen]
+	out := make([]byte, 0, n+aes.BlockSize)
+	for len(out) < n {
+		block.Encrypt(x, x)
+		out = append(out, x...)
+	}
+	return out[:n]
+}
+
+// bcc is the BCC function defined in NIST SP 800-90A section 10.3.3. It's
+// the CBC-MAC of the concatenation of the data.
+func bcc(block cipher.Block, data ...[]byte) []byte {
+	chaining := make([]byte, aes.BlockSize)
+	var i int
+	for _, d := range data {
+		for _, b := range d {
+			chaining[i] ^= b
+			if i++; i == aes.BlockSize {
+				block.Encrypt(chaining, chaining)
+				i = 0
+			}
+		}
+	}
+	return chaining
+}
+
+func totalLen(b [][]byte) (n int) {
+	for _, v := range b {
+		n += len(v)
+	}
+	return
+}
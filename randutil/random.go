@@ -1,4 +1,5 @@
-// Package randutil provides methods to generate random strings and salts.
+// Package randutil provides methods to generate random strings and salts, and
+// NIST SP 800-90A DRBGs seeded from multiple entropy sources.
 package randutil
 
 import (
@@ -6,12 +7,18 @@ import (
 	"encoding/hex"
 	"io"
 	"math/big"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
 var ascii string
 
+var (
+	readerMu sync.RWMutex
+	reader   io.Reader
+)
+
 func init() {
 	// initialize the charcters in ascii
 	aciiBytes := make([]byte, 94)
@@ -21,10 +28,42 @@ func init() {
 	ascii = string(aciiBytes)
 }
 
+// Reader returns the reader used to generate random data in this package and
+// to generate keys in keyutil. It's crypto/rand's Reader unless a different
+// one is set using SetReader.
+func Reader() io.Reader {
+	readerMu.RLock()
+	defer readerMu.RUnlock()
+	if reader == nil {
+		return rand.Reader
+	}
+	return reader
+}
+
+// SetReader sets the reader used to generate random data in this package and
+// to generate keys in keyutil, for example a DRBG created with NewHMACDRBG or
+// NewCTRDRBG. Passing nil restores crypto/rand's Reader.
+//
+// Since Go 1.26, the standard library ignores custom readers when generating
+// EC and RSA keys, unless GODEBUG cryptocustomrand=1 is set, which is
+// the default for modules declaring a go version older than 1.26. Otherwise,
+// keyutil fails to generate those keys while a custom reader is set, instead
+// of generating them with crypto/rand.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func SetReader(r io.Reader) {
+	readerMu.Lock()
+	defer readerMu.Unlock()
+	reader = r
+}
+
 // Salt generates a new random salt of the given size.
 func Salt(size int) ([]byte, error) {
 	salt := make([]byte, size)
-	_, err := io.ReadFull(rand.Reader, salt)
+	_, err := io.ReadFull(Reader(), salt)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating salt")
 	}
@@ -34,7 +73,7 @@ func Salt(size int) ([]byte, error) {
 // Bytes generates a new byte slice of the given size.
 func Bytes(size int) ([]byte, error) {
 	bytes := make([]byte, size)
-	_, err := io.ReadFull(rand.Reader, bytes)
+	_, err := io.ReadFull(Reader(), bytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating bytes")
 	}
@@ -49,7 +88,7 @@ func String(length int, chars string) (string, error) {
 	runes := []rune(chars)
 	x := int64(len(runes))
 	for i := range result {
-		num, err := rand.Int(rand.Reader, big.NewInt(x))
+		num, err := rand.Int(Reader(), big.NewInt(x))
 		if err != nil {
 			return "", errors.Wrap(err, "error creating random number")
 		}
@@ -89,7 +128,7 @@ func Alphabet(length int) (string, error) {
 // part has 122 bits.
 func UUIDv4() (string, error) {
 	var uuid [16]byte
-	_, err := io.ReadFull(rand.Reader, uuid[:])
+	_, err := io.ReadFull(Reader(), uuid[:])
 	if err != nil {
 		return "", errors.Wrap(err, "error generating uuid")
 	}
@@ -182,6 +182,37 @@ func TestUUIDv4(t *testing.T) {
 	}
 }
 
+func TestSetReader(t *testing.T) {
+	t.Cleanup(func() {
+		SetReader(nil)
+	})
+	assert.Equal(t, rand.Reader, Reader())
+
+	SetReader(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	b, err := Bytes(4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, b)
+	b, err = Salt(4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{5, 6, 7, 8}, b)
+	_, err = Bytes(4)
+	assert.Error(t, err)
+
+	drbg, err := NewHMACDRBG()
+	assert.NoError(t, err)
+	SetReader(drbg)
+	assert.Equal(t, drbg, Reader())
+	uuid, err := UUIDv4()
+	assert.NoError(t, err)
+	assert.Len(t, uuid, 36)
+	s, err := Alphanumeric(32)
+	assert.NoError(t, err)
+	assert.Len(t, s, 32)
+
+	SetReader(nil)
+	assert.Equal(t, rand.Reader, Reader())
+}
+
 type errorReader struct{}
 
 func (r *errorReader) Read(p []byte) (int, error) {
@@ -0,0 +1,508 @@
+// Package rsapss implements RSA keys restricted to the RSASSA-PSS signature
+// scheme, as defined in RFC 4055. These keys use the id-RSASSA-PSS object
+// identifier in the SubjectPublicKeyInfo and PKCS #8 structures, instead of
+// rsaEncryption, and they can optionally restrict the hash, mask generation
+// function and minimum salt length used in the signatures.
+//
+// The Go standard library does not support these keys, crypto/x509 rejects
+// them when they appear in public keys, private keys or certificates. This
+// package provides the types and methods to parse, serialize and sign with
+// them.
+package rsapss
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	// Hash functions that can be used in RSASSA-PSS parameters.
+	_ "crypto/sha1" //nolint:gosec // default hash in RFC 4055
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	// OIDPublicKeyRSAPSS is the id-RSASSA-PSS object identifier used in
+	// the algorithm of keys restricted to RSASSA-PSS signatures.
+	OIDPublicKeyRSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+	oidMGF1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+var hashMapping = []struct {
+	hash crypto.Hash
+	oid  asn1.ObjectIdentifier
+}{
+	{crypto.SHA1, oidSHA1},
+	{crypto.SHA224, oidSHA224},
+	{crypto.SHA256, oidSHA256},
+	{crypto.SHA384, oidSHA384},
+	{crypto.SHA512, oidSHA512},
+}
+
+// pssParameters reflects the ASN.1 structure RSASSA-PSS-params defined in RFC
+// 4055 section 3.1. All the fields are optional and the defaults are SHA-1,
+// MGF1 with SHA-1, 20 and 1.
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SaltLength   int                      `asn1:"optional,explicit,tag:2,default:20"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// Parameters are the RSASSA-PSS parameters that restrict the use of a key.
+type Parameters struct {
+	// Hash is the hash function used to create the message digest.
+	Hash crypto.Hash
+	// MGFHash is the hash function used by the MGF1 mask generation function.
+	MGFHash crypto.Hash
+	// SaltLength is the minimum length of the salt.
+	SaltLength int
+}
+
+// NewParameters returns the parameters that RFC 4055 recommends for the given
+// hash: MGF1 with the same hash function and a salt of the size of the hash.
+func NewParameters(h crypto.Hash) *Parameters {
+	return &Parameters{
+		Hash:       h,
+		MGFHash:    h,
+		SaltLength: h.Size(),
+	}
+}
+
+// Validate returns an error if the parameters cannot be used to sign with the
+// Go crypto/rsa package.
+func (p *Parameters) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if _, err := hashOID(p.Hash); err != nil {
+		return err
+	}
+	if p.MGFHash != p.Hash {
+		return fmt.Errorf("rsapss: unsupported mask generation hash %s with hash %s", p.MGFHash, p.Hash)
+	}
+	if p.SaltLength < 0 {
+		return fmt.Errorf("rsapss: invalid salt length %d", p.SaltLength)
+	}
+	// crypto/rsa interprets a salt length of 0 as rsa.PSSSaltLengthAuto, so
+	// it cannot create signatures with an empty salt.
+	if p.SaltLength == 0 {
+		return errors.New("rsapss: unsupported salt length 0")
+	}
+	return nil
+}
+
+// SignatureAlgorithm returns the x509.SignatureAlgorithm that matches the
+// hash of the parameters. It returns x509.UnknownSignatureAlgorithm if the hash
+// is not one of SHA-256, SHA-384 or SHA-512.
+func (p *Parameters) SignatureAlgorithm() x509.SignatureAlgorithm {
+	if p == nil {
+		return x509.SHA256WithRSAPSS
+	}
+	switch p.Hash {
+	case crypto.SHA256:
+		return x509.SHA256WithRSAPSS
+	case crypto.SHA384:
+		return x509.SHA384WithRSAPSS
+	case crypto.SHA512:
+		return x509.SHA512WithRSAPSS
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
+
+// PSSOptions returns the rsa.PSSOptions used to sign a digest created with the
+// hash function in opts. If p is nil, any hash is allowed and the salt length
+// will be the one in opts if it is a *rsa.PSSOptions, or the length of the hash
+// otherwise. If p is not nil, the hash must match the parameters one, and the
+// salt length will be the one in the parameters, unless opts requires a longer
+// one.
+func (p *Parameters) PSSOptions(opts crypto.SignerOpts) (*rsa.PSSOptions, error) {
+	h := opts.HashFunc()
+	if h == 0 {
+		return nil, errors.New("rsapss: a hash function is required")
+	}
+
+	if p == nil {
+		saltLength := rsa.PSSSaltLengthEqualsHash
+		if o, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength = o.SaltLength
+		}
+		return &rsa.PSSOptions{
+			SaltLength: saltLength,
+			Hash:       h,
+		}, nil
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if h != p.Hash {
+		return nil, fmt.Errorf("rsapss: hash %s does not match key restriction %s", h, p.Hash)
+	}
+	saltLength := p.SaltLength
+	if o, ok := opts.(*rsa.PSSOptions); ok && o.SaltLength > saltLength {
+		saltLength = o.SaltLength
+	}
+	return &rsa.PSSOptions{
+		SaltLength: saltLength,
+		Hash:       h,
+	}, nil
+}
+
+// Marshal returns the ASN.1 DER encoding of the RSASSA-PSS-params structure.
+// Fields with default values are omitted.
+func (p *Parameters) Marshal() ([]byte, error) {
+	h, err := hashOID(p.Hash)
+	if err != nil {
+		return nil, err
+	}
+	mgfH, err := hashOID(p.MGFHash)
+	if err != nil {
+		return nil, err
+	}
+	mgfHash, err := asn1.Marshal(pkix.AlgorithmIdentifier{
+		Algorithm:  mgfH,
+		Parameters: asn1.NullRawValue,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error marshaling parameters: %w", err)
+	}
+
+	params := pssParameters{
+		SaltLength:   p.SaltLength,
+		TrailerField: 1,
+	}
+	if p.Hash != crypto.SHA1 {
+		params.Hash = pkix.AlgorithmIdentifier{
+			Algorithm:  h,
+			Parameters: asn1.NullRawValue,
+		}
+	}
+	if p.MGFHash != crypto.SHA1 {
+		params.MGF = pkix.AlgorithmIdentifier{
+			Algorithm:  oidMGF1,
+			Parameters: asn1.RawValue{FullBytes: mgfHash},
+		}
+	}
+
+	b, err := asn1.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error marshaling parameters: %w", err)
+	}
+	return b, nil
+}
+
+// AlgorithmIdentifier returns the AlgorithmIdentifier for id-RSASSA-PSS with
+// the parameters encoded. If p is nil, the parameters will be absent.
+func (p *Parameters) AlgorithmIdentifier() (pkix.AlgorithmIdentifier, error) {
+	if p == nil {
+		return pkix.AlgorithmIdentifier{
+			Algorithm: OIDPublicKeyRSAPSS,
+		}, nil
+	}
+	b, err := p.Marshal()
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  OIDPublicKeyRSAPSS,
+		Parameters: asn1.RawValue{FullBytes: b},
+	}, nil
+}
+
+// ParseParameters parses an ASN.1 DER encoded RSASSA-PSS-params structure.
+func ParseParameters(der []byte) (*Parameters, error) {
+	var params pssParameters
+	if rest, err := asn1.Unmarshal(der, &params); err != nil {
+		return nil, fmt.Errorf("rsapss: error parsing parameters: %w", err)
+	} else if len(rest) != 0 {
+		return nil, errors.New("rsapss: error parsing parameters: trailing data")
+	}
+	if params.TrailerField != 1 {
+		return nil, fmt.Errorf("rsapss: unsupported trailer field %d", params.TrailerField)
+	}
+
+	p := &Parameters{
+		Hash:       crypto.SHA1,
+		MGFHash:    crypto.SHA1,
+		SaltLength: params.SaltLength,
+	}
+
+	var err error
+	if len(params.Hash.Algorithm) > 0 {
+		if p.Hash, err = hashFromOID(params.Hash.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if len(params.MGF.Algorithm) > 0 {
+		if !params.MGF.Algorithm.Equal(oidMGF1) {
+			return nil, fmt.Errorf("rsapss: unsupported mask generation function %s", params.MGF.Algorithm)
+		}
+		var mgfHash pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(params.MGF.Parameters.FullBytes, &mgfHash); err != nil {
+			return nil, fmt.Errorf("rsapss: error parsing mask generation function: %w", err)
+		}
+		if p.MGFHash, err = hashFromOID(mgfHash.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if p.SaltLength < 0 {
+		return nil, fmt.Errorf("rsapss: invalid salt length %d", p.SaltLength)
+	}
+
+	return p, nil
+}
+
+// parseAlgorithmIdentifier parses the parameters of an id-RSASSA-PSS
+// AlgorithmIdentifier. It returns nil parameters if they are not present.
+func parseAlgorithmIdentifier(ai pkix.AlgorithmIdentifier) (*Parameters, error) {
+	if !ai.Algorithm.Equal(OIDPublicKeyRSAPSS) {
+		return nil, fmt.Errorf("rsapss: unexpected algorithm %s", ai.Algorithm)
+	}
+	if len(ai.Parameters.FullBytes) == 0 || ai.Parameters.Tag == asn1.TagNull {
+		return nil, nil
+	}
+	return ParseParameters(ai.Parameters.FullBytes)
+}
+
+func hashOID(h crypto.Hash) (asn1.ObjectIdentifier, error) {
+	for _, m := range hashMapping {
+		if m.hash == h {
+			return m.oid, nil
+		}
+	}
+	return nil, fmt.Errorf("rsapss: unsupported hash function %s", h)
+}
+
+func hashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, m := range hashMapping {
+		if m.oid.Equal(oid) {
+			return m.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("rsapss: unsupported hash algorithm %s", oid)
+}
+
+// PublicKey is an RSA public key restricted to RSASSA-PSS signatures.
+type PublicKey struct {
+	*rsa.PublicKey
+	// Parameters are the restrictions in the key, if nil the key can be used
+	// with any RSASSA-PSS parameters.
+	Parameters *Parameters
+}
+
+// Equal reports whether k and x have the same value, including the
+// parameters.
+func (k *PublicKey) Equal(x crypto.PublicKey) bool {
+	xx, ok := x.(*PublicKey)
+	if !ok || !k.PublicKey.Equal(xx.PublicKey) {
+		return false
+	}
+	if k.Parameters == nil || xx.Parameters == nil {
+		return k.Parameters == xx.Parameters
+	}
+	return *k.Parameters == *xx.Parameters
+}
+
+// Verify verifies an RSASSA-PSS signature of the given digest honoring the
+// restrictions of the key. The salt length in the parameters is a minimum, so
+// signatures with a longer salt are also valid.
+func (k *PublicKey) Verify(digest, sig []byte, opts crypto.SignerOpts) error {
+	pssOpts, err := k.Parameters.PSSOptions(opts)
+	if err != nil {
+		return err
+	}
+	minSaltLength := pssOpts.SaltLength
+	pssOpts.SaltLength = rsa.PSSSaltLengthAuto
+	if err := rsa.VerifyPSS(k.PublicKey, pssOpts.Hash, digest, sig, pssOpts); err != nil {
+		return err
+	}
+	if k.Parameters == nil {
+		return nil
+	}
+	saltLength, err := pssSaltLength(k.PublicKey, pssOpts.Hash, sig)
+	if err != nil {
+		return err
+	}
+	if saltLength < minSaltLength {
+		return fmt.Errorf("rsapss: salt length %d is lower than the minimum %d", saltLength, minSaltLength)
+	}
+	return nil
+}
+
+// pssSaltLength returns the length of the salt in a valid RSASSA-PSS
+// signature, using MGF1 with the given hash. It follows the EMSA-PSS decoding
+// operation in RFC 8017, section 9.1.2.
+func pssSaltLength(pub *rsa.PublicKey, h crypto.Hash, sig []byte) (int, error) {
+	k := pub.Size()
+	s := new(big.Int).SetBytes(sig)
+	if len(sig) != k || s.Cmp(pub.N) >= 0 {
+		return 0, rsa.ErrVerification
+	}
+	m := new(big.Int).Exp(s, big.NewInt(int64(pub.E)), pub.N)
+
+	emBits := pub.N.BitLen() - 1
+	emLen := (emBits + 7) / 8
+	hLen := h.Size()
+	if emLen < hLen+2 {
+		return 0, rsa.ErrVerification
+	}
+	em := m.FillBytes(make([]byte, k))[k-emLen:]
+	db, mHash := em[:emLen-hLen-1], em[emLen-hLen-1:emLen-1]
+
+	// Unmask the DB using MGF1.
+	var mask []byte
+	var counter [4]byte
+	for i := uint32(0); len(mask) < len(db); i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		hh := h.New()
+		hh.Write(mHash)
+		hh.Write(counter[:])
+		mask = hh.Sum(mask)
+	}
+	for i := range db {
+		db[i] ^= mask[i]
+	}
+	db[0] &= 0xff >> (8*emLen - emBits)
+
+	// The DB is a padding of zeros, 0x01 and the salt.
+	for i, b := range db {
+		switch b {
+		case 0:
+		case 1:
+			return len(db) - i - 1, nil
+		default:
+			return 0, rsa.ErrVerification
+		}
+	}
+	return 0, rsa.ErrVerification
+}
+
+// PrivateKey is an RSA private key restricted to RSASSA-PSS signatures.
+type PrivateKey struct {
+	*rsa.PrivateKey
+	// Parameters are the restrictions in the key, if nil the key can be used
+	// with any RSASSA-PSS parameters.
+	Parameters *Parameters
+}
+
+// GenerateKey generates a new RSA key of the given bit size restricted to the
+// given parameters.
+func GenerateKey(random io.Reader, bits int, params *Parameters) (*PrivateKey, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(random, bits)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{
+		PrivateKey: key,
+		Parameters: params,
+	}, nil
+}
+
+// Public returns the *PublicKey corresponding to k.
+func (k *PrivateKey) Public() crypto.PublicKey {
+	return &PublicKey{
+		PublicKey:  &k.PrivateKey.PublicKey,
+		Parameters: k.Parameters,
+	}
+}
+
+// Equal reports whether k and x have the same value, including the
+// parameters.
+func (k *PrivateKey) Equal(x crypto.PrivateKey) bool {
+	xx, ok := x.(*PrivateKey)
+	if !ok || !k.PrivateKey.Equal(xx.PrivateKey) {
+		return false
+	}
+	return k.Public().(*PublicKey).Equal(xx.Public())
+}
+
+// Sign signs the digest using RSASSA-PSS, the key parameters are enforced, and
+// a *rsa.PSSOptions is not required.
+func (k *PrivateKey) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	pssOpts, err := k.Parameters.PSSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return rsa.SignPSS(random, k.PrivateKey, pssOpts.Hash, digest, pssOpts)
+}
+
+// Decrypt always fails, RSASSA-PSS keys cannot be used for decryption.
+func (k *PrivateKey) Decrypt(io.Reader, []byte, crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errors.New("rsapss: key is restricted to RSASSA-PSS signatures")
+}
+
+// Signer is a crypto.Signer that enforces the RSASSA-PSS parameters on another
+// RSA crypto.Signer, for example, a key in a KMS whose certificate or public key
+// is restricted to RSASSA-PSS.
+type Signer struct {
+	crypto.Signer
+	// Parameters are the restrictions in the key, if nil the key can be used
+	// with any RSASSA-PSS parameters.
+	Parameters *Parameters
+}
+
+// NewSigner returns a new Signer that signs using signer and the given
+// parameters. The public key of the signer must be an RSA public key.
+func NewSigner(signer crypto.Signer, params *Parameters) (*Signer, error) {
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("rsapss: unsupported public key type %T", signer.Public())
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &Signer{
+		Signer:     signer,
+		Parameters: params,
+	}, nil
+}
+
+// Public returns the *PublicKey of the signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return &PublicKey{
+		PublicKey:  s.Signer.Public().(*rsa.PublicKey),
+		Parameters: s.Parameters,
+	}
+}
+
+// Sign signs the digest using RSASSA-PSS with the underlying signer, the
+// parameters are enforced, and a *rsa.PSSOptions is not required.
+func (s *Signer) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	pssOpts, err := s.Parameters.PSSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.Signer.Sign(random, digest, pssOpts)
+}
+
+// Unwrap returns the unrestricted RSA signer and the parameters of the given
+// signer if it is a *PrivateKey or a *Signer. The returned signer does not
+// enforce the parameters and it expects a *rsa.PSSOptions to create RSASSA-PSS
+// signatures.
+func Unwrap(signer crypto.Signer) (crypto.Signer, *Parameters, bool) {
+	switch s := signer.(type) {
+	case *PrivateKey:
+		return s.PrivateKey, s.Parameters, true
+	case *Signer:
+		return s.Signer, s.Parameters, true
+	default:
+		return signer, nil, false
+	}
+}
@@ -0,0 +1,318 @@
+package rsapss
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustGenerateKey(t *testing.T, params *Parameters) *PrivateKey {
+	t.Helper()
+	key, err := GenerateKey(rand.Reader, 2048, params)
+	require.NoError(t, err)
+	return key
+}
+
+func mustReadPEM(t *testing.T, filename string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	block, _ := pem.Decode(b)
+	require.NotNil(t, block)
+	return block.Bytes
+}
+
+func TestNewParameters(t *testing.T) {
+	assert.Equal(t, &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA256, SaltLength: 32}, NewParameters(crypto.SHA256))
+	assert.Equal(t, &Parameters{Hash: crypto.SHA384, MGFHash: crypto.SHA384, SaltLength: 48}, NewParameters(crypto.SHA384))
+	assert.Equal(t, &Parameters{Hash: crypto.SHA512, MGFHash: crypto.SHA512, SaltLength: 64}, NewParameters(crypto.SHA512))
+}
+
+func TestParameters_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  *Parameters
+		wantErr bool
+	}{
+		{"ok nil", nil, false},
+		{"ok sha1", &Parameters{Hash: crypto.SHA1, MGFHash: crypto.SHA1, SaltLength: 20}, false},
+		{"ok sha256", NewParameters(crypto.SHA256), false},
+		{"fail salt 0", &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA256}, true},
+		{"fail hash", &Parameters{Hash: crypto.MD5, MGFHash: crypto.MD5, SaltLength: 16}, true},
+		{"fail mgf hash", &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA1, SaltLength: 32}, true},
+		{"fail salt", &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA256, SaltLength: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParameters_SignatureAlgorithm(t *testing.T) {
+	tests := []struct {
+		name   string
+		params *Parameters
+		want   x509.SignatureAlgorithm
+	}{
+		{"nil", nil, x509.SHA256WithRSAPSS},
+		{"sha1", &Parameters{Hash: crypto.SHA1, MGFHash: crypto.SHA1, SaltLength: 20}, x509.UnknownSignatureAlgorithm},
+		{"sha256", NewParameters(crypto.SHA256), x509.SHA256WithRSAPSS},
+		{"sha384", NewParameters(crypto.SHA384), x509.SHA384WithRSAPSS},
+		{"sha512", NewParameters(crypto.SHA512), x509.SHA512WithRSAPSS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.params.SignatureAlgorithm())
+		})
+	}
+}
+
+func TestParameters_PSSOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  *Parameters
+		opts    crypto.SignerOpts
+		want    *rsa.PSSOptions
+		wantErr bool
+	}{
+		{"ok nil", nil, crypto.SHA384, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, false},
+		{"ok nil pss", nil, &rsa.PSSOptions{SaltLength: 10, Hash: crypto.SHA256}, &rsa.PSSOptions{SaltLength: 10, Hash: crypto.SHA256}, false},
+		{"ok params", NewParameters(crypto.SHA256), crypto.SHA256, &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}, false},
+		{"ok params min salt", NewParameters(crypto.SHA256), &rsa.PSSOptions{SaltLength: 10, Hash: crypto.SHA256}, &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}, false},
+		{"ok params bigger salt", NewParameters(crypto.SHA256), &rsa.PSSOptions{SaltLength: 64, Hash: crypto.SHA256}, &rsa.PSSOptions{SaltLength: 64, Hash: crypto.SHA256}, false},
+		{"fail no hash", nil, crypto.Hash(0), nil, true},
+		{"fail hash", NewParameters(crypto.SHA256), crypto.SHA384, nil, true},
+		{"fail params", &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA1}, crypto.SHA256, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.params.PSSOptions(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParameters_Marshal(t *testing.T) {
+	sha256Params, err := asn1.Marshal(pssParameters{
+		Hash:         pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mustMarshal(t, pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue})}},
+		SaltLength:   32,
+		TrailerField: 1,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		params  *Parameters
+		want    []byte
+		wantErr bool
+	}{
+		{"ok defaults", &Parameters{Hash: crypto.SHA1, MGFHash: crypto.SHA1, SaltLength: 20}, []byte{0x30, 0x00}, false},
+		{"ok sha256", NewParameters(crypto.SHA256), sha256Params, false},
+		{"fail", &Parameters{Hash: crypto.MD5, MGFHash: crypto.MD5, SaltLength: 16}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.params.Marshal()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			params, err := ParseParameters(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		der     []byte
+		want    *Parameters
+		wantErr bool
+	}{
+		{"ok defaults", []byte{0x30, 0x00}, &Parameters{Hash: crypto.SHA1, MGFHash: crypto.SHA1, SaltLength: 20}, false},
+		{"ok salt", []byte{0x30, 0x05, 0xa2, 0x03, 0x02, 0x01, 0x40}, &Parameters{Hash: crypto.SHA1, MGFHash: crypto.SHA1, SaltLength: 64}, false},
+		{"fail asn1", []byte{0x30, 0x05}, nil, true},
+		{"fail trailing data", []byte{0x30, 0x00, 0x00}, nil, true},
+		{"fail trailer field", []byte{0x30, 0x05, 0xa3, 0x03, 0x02, 0x01, 0x02}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseParameters(tt.der)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPrivateKey_Sign(t *testing.T) {
+	digest := sha256.Sum256([]byte("the-digest"))
+	unrestricted := mustGenerateKey(t, nil)
+	restricted := mustGenerateKey(t, NewParameters(crypto.SHA256))
+
+	tests := []struct {
+		name    string
+		key     *PrivateKey
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok unrestricted", unrestricted, crypto.SHA256, false},
+		{"ok unrestricted pss", unrestricted, &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, false},
+		{"ok restricted", restricted, crypto.SHA256, false},
+		{"ok restricted pss", restricted, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256}, false},
+		{"fail restricted hash", restricted, crypto.SHA384, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.key.Sign(rand.Reader, digest[:], tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			pub := tt.key.Public().(*PublicKey)
+			assert.NoError(t, pub.Verify(digest[:], sig, tt.opts))
+			assert.NoError(t, rsa.VerifyPSS(pub.PublicKey, crypto.SHA256, digest[:], sig, nil))
+		})
+	}
+
+	// Restricted keys require the minimum salt length.
+	sig, err := rsa.SignPSS(rand.Reader, restricted.PrivateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: 20})
+	require.NoError(t, err)
+	assert.Error(t, restricted.Public().(*PublicKey).Verify(digest[:], sig, crypto.SHA256))
+
+	// Longer salts are valid.
+	for _, saltLength := range []int{32, 33, 64, rsa.PSSSaltLengthAuto} {
+		sig, err = rsa.SignPSS(rand.Reader, restricted.PrivateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: saltLength})
+		require.NoError(t, err)
+		assert.NoError(t, restricted.Public().(*PublicKey).Verify(digest[:], sig, crypto.SHA256))
+	}
+
+	// PKCS #1 v1.5 signatures are not valid.
+	sig, err = rsa.SignPKCS1v15(rand.Reader, unrestricted.PrivateKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	assert.Error(t, unrestricted.Public().(*PublicKey).Verify(digest[:], sig, crypto.SHA256))
+}
+
+func Test_pssSaltLength(t *testing.T) {
+	digest := sha256.Sum256([]byte("the-digest"))
+	key := mustGenerateKey(t, nil)
+	for _, want := range []int{1, 20, 32, 64, 222} {
+		sig, err := rsa.SignPSS(rand.Reader, key.PrivateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: want})
+		require.NoError(t, err)
+		got, err := pssSaltLength(&key.PublicKey, crypto.SHA256, sig)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := pssSaltLength(&key.PublicKey, crypto.SHA256, []byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestPrivateKey_Decrypt(t *testing.T) {
+	key := mustGenerateKey(t, nil)
+	_, err := key.Decrypt(rand.Reader, []byte("ciphertext"), nil)
+	assert.Error(t, err)
+}
+
+func TestPrivateKey_Equal(t *testing.T) {
+	key := mustGenerateKey(t, NewParameters(crypto.SHA256))
+	other := &PrivateKey{PrivateKey: key.PrivateKey, Parameters: NewParameters(crypto.SHA256)}
+	unrestricted := &PrivateKey{PrivateKey: key.PrivateKey}
+
+	assert.True(t, key.Equal(key))
+	assert.True(t, key.Equal(other))
+	assert.False(t, key.Equal(unrestricted))
+	assert.False(t, key.Equal(key.PrivateKey))
+	assert.True(t, key.Public().(*PublicKey).Equal(other.Public()))
+	assert.False(t, key.Public().(*PublicKey).Equal(unrestricted.Public()))
+	assert.False(t, key.Public().(*PublicKey).Equal(&key.PrivateKey.PublicKey))
+}
+
+func TestNewSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("the-digest"))
+	params := NewParameters(crypto.SHA256)
+
+	signer, err := NewSigner(rsaKey, params)
+	require.NoError(t, err)
+	assert.Equal(t, &PublicKey{PublicKey: &rsaKey.PublicKey, Parameters: params}, signer.Public())
+
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.NoError(t, signer.Public().(*PublicKey).Verify(digest[:], sig, crypto.SHA256))
+
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA512)
+	assert.Error(t, err)
+
+	_, err = NewSigner(ecKey, params)
+	assert.Error(t, err)
+	_, err = NewSigner(rsaKey, &Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA512})
+	assert.Error(t, err)
+}
+
+func TestUnwrap(t *testing.T) {
+	key := mustGenerateKey(t, NewParameters(crypto.SHA384))
+	signer, err := NewSigner(key.PrivateKey, NewParameters(crypto.SHA512))
+	require.NoError(t, err)
+
+	s, params, ok := Unwrap(key)
+	assert.True(t, ok)
+	assert.Equal(t, key.PrivateKey, s)
+	assert.Equal(t, NewParameters(crypto.SHA384), params)
+
+	s, params, ok = Unwrap(signer)
+	assert.True(t, ok)
+	assert.Equal(t, key.PrivateKey, s)
+	assert.Equal(t, NewParameters(crypto.SHA512), params)
+
+	s, params, ok = Unwrap(key.PrivateKey)
+	assert.False(t, ok)
+	assert.Equal(t, key.PrivateKey, s)
+	assert.Nil(t, params)
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := asn1.Marshal(v)
+	require.NoError(t, err)
+	return b
+}
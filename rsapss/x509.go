@@ -0,0 +1,256 @@
+package rsapss
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// publicKeyInfo reflects the ASN.1 structure SubjectPublicKeyInfo defined in
+// RFC 5280.
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// pkcs8 reflects the ASN.1 structure PrivateKeyInfo defined in RFC 5208.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	// optional attributes omitted.
+}
+
+// IsPKIXPublicKey reports whether the given ASN.1 DER encoded
+// SubjectPublicKeyInfo uses the id-RSASSA-PSS algorithm.
+func IsPKIXPublicKey(der []byte) bool {
+	var pki publicKeyInfo
+	if _, err := asn1.Unmarshal(der, &pki); err != nil {
+		return false
+	}
+	return pki.Algorithm.Algorithm.Equal(OIDPublicKeyRSAPSS)
+}
+
+// IsPKCS8PrivateKey reports whether the given ASN.1 DER encoded PKCS #8
+// private key uses the id-RSASSA-PSS algorithm.
+func IsPKCS8PrivateKey(der []byte) bool {
+	var priv pkcs8
+	if _, err := asn1.Unmarshal(der, &priv); err != nil {
+		return false
+	}
+	return priv.Algo.Algorithm.Equal(OIDPublicKeyRSAPSS)
+}
+
+// ParsePKIXPublicKey parses an ASN.1 DER encoded SubjectPublicKeyInfo with the
+// id-RSASSA-PSS algorithm.
+func ParsePKIXPublicKey(der []byte) (*PublicKey, error) {
+	var pki publicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &pki); err != nil {
+		return nil, fmt.Errorf("rsapss: error parsing public key: %w", err)
+	} else if len(rest) != 0 {
+		return nil, errors.New("rsapss: error parsing public key: trailing data")
+	}
+
+	params, err := parseAlgorithmIdentifier(pki.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKCS1PublicKey(pki.PublicKey.RightAlign())
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error parsing public key: %w", err)
+	}
+
+	return &PublicKey{
+		PublicKey:  pub,
+		Parameters: params,
+	}, nil
+}
+
+// MarshalPKIXPublicKey returns the ASN.1 DER encoded SubjectPublicKeyInfo of
+// the given key using the id-RSASSA-PSS algorithm.
+func MarshalPKIXPublicKey(pub *PublicKey) ([]byte, error) {
+	if pub == nil || pub.PublicKey == nil {
+		return nil, errors.New("rsapss: public key cannot be nil")
+	}
+	ai, err := pub.Parameters.AlgorithmIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	b := x509.MarshalPKCS1PublicKey(pub.PublicKey)
+	der, err := asn1.Marshal(publicKeyInfo{
+		Algorithm: ai,
+		PublicKey: asn1.BitString{
+			Bytes:     b,
+			BitLength: 8 * len(b),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error marshaling public key: %w", err)
+	}
+	return der, nil
+}
+
+// ParsePKCS8PrivateKey parses an ASN.1 DER encoded PKCS #8 private key with the
+// id-RSASSA-PSS algorithm.
+func ParsePKCS8PrivateKey(der []byte) (*PrivateKey, error) {
+	var priv pkcs8
+	if _, err := asn1.Unmarshal(der, &priv); err != nil {
+		return nil, fmt.Errorf("rsapss: error parsing private key: %w", err)
+	}
+
+	params, err := parseAlgorithmIdentifier(priv.Algo)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS1PrivateKey(priv.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error parsing private key: %w", err)
+	}
+
+	return &PrivateKey{
+		PrivateKey: key,
+		Parameters: params,
+	}, nil
+}
+
+// MarshalPKCS8PrivateKey returns the ASN.1 DER encoded PKCS #8 private key of
+// the given key using the id-RSASSA-PSS algorithm.
+func MarshalPKCS8PrivateKey(key *PrivateKey) ([]byte, error) {
+	if key == nil || key.PrivateKey == nil {
+		return nil, errors.New("rsapss: private key cannot be nil")
+	}
+	ai, err := key.Parameters.AlgorithmIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(pkcs8{
+		Version:    0,
+		Algo:       ai,
+		PrivateKey: x509.MarshalPKCS1PrivateKey(key.PrivateKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rsapss: error marshaling private key: %w", err)
+	}
+	return der, nil
+}
+
+// ParseCertificate parses a single certificate from the given ASN.1 DER data
+// using x509.ParseCertificate. If the certificate public key uses the
+// id-RSASSA-PSS algorithm, the PublicKey will be a *PublicKey and the
+// PublicKeyAlgorithm x509.RSA.
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := FixCertificate(cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// ParseCertificateRequest parses a single certificate request from the given
+// ASN.1 DER data using x509.ParseCertificateRequest. If the certificate
+// request public key uses the id-RSASSA-PSS algorithm, the PublicKey will be a
+// *PublicKey and the PublicKeyAlgorithm x509.RSA.
+func ParseCertificateRequest(der []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := FixCertificateRequest(csr); err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// FixCertificate sets the PublicKey and PublicKeyAlgorithm of a certificate
+// parsed with crypto/x509 if the public key uses the id-RSASSA-PSS algorithm.
+// Other certificates are not modified.
+func FixCertificate(cert *x509.Certificate) error {
+	if cert.PublicKey != nil || !IsPKIXPublicKey(cert.RawSubjectPublicKeyInfo) {
+		return nil
+	}
+	pub, err := ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return err
+	}
+	cert.PublicKey = pub
+	cert.PublicKeyAlgorithm = x509.RSA
+	return nil
+}
+
+// FixCertificateRequest sets the PublicKey and PublicKeyAlgorithm of a
+// certificate request parsed with crypto/x509 if the public key uses the
+// id-RSASSA-PSS algorithm. Other certificate requests are not modified.
+func FixCertificateRequest(csr *x509.CertificateRequest) error {
+	if csr.PublicKey != nil || !IsPKIXPublicKey(csr.RawSubjectPublicKeyInfo) {
+		return nil
+	}
+	pub, err := ParsePKIXPublicKey(csr.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return err
+	}
+	csr.PublicKey = pub
+	csr.PublicKeyAlgorithm = x509.RSA
+	return nil
+}
+
+// CheckCertificateRequestSignature reports whether the signature on the given
+// certificate request is valid. It supports the certificate requests supported
+// by crypto/x509 and the ones with a public key restricted to RSASSA-PSS.
+func CheckCertificateRequestSignature(csr *x509.CertificateRequest) error {
+	pub, ok := csr.PublicKey.(*PublicKey)
+	if !ok {
+		return csr.CheckSignature()
+	}
+	return verifyCertificateRequestSignature(csr, pub)
+}
+
+// verifyCertificateRequestSignature verifies the RSASSA-PSS signature of a
+// certificate request. crypto/x509 only supports RSASSA-PSS signatures with a
+// salt of the size of the hash, and it does not support restricted keys.
+func verifyCertificateRequestSignature(csr *x509.CertificateRequest, pub *PublicKey) error {
+	var cr struct {
+		TBSCSR             asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(csr.Raw, &cr); err != nil {
+		return fmt.Errorf("rsapss: error parsing certificate request: %w", err)
+	}
+	params, err := parseAlgorithmIdentifier(cr.SignatureAlgorithm)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		return errors.New("rsapss: signature algorithm parameters are missing")
+	}
+	if pub.Parameters != nil && (params.Hash != pub.Parameters.Hash || params.SaltLength < pub.Parameters.SaltLength) {
+		return errors.New("rsapss: signature parameters do not match key restrictions")
+	}
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	h := params.Hash.New()
+	h.Write(cr.TBSCSR.FullBytes)
+	sig := cr.SignatureValue.RightAlign()
+	if err := rsa.VerifyPSS(pub.PublicKey, params.Hash, h.Sum(nil), sig, &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthAuto,
+		Hash:       params.Hash,
+	}); err != nil {
+		return err
+	}
+	// The salt length in the parameters is a minimum.
+	saltLength, err := pssSaltLength(pub.PublicKey, params.Hash, sig)
+	if err != nil {
+		return err
+	}
+	if saltLength < params.SaltLength {
+		return fmt.Errorf("rsapss: salt length %d is lower than the minimum %d", saltLength, params.SaltLength)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package rsapss
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePKIXPublicKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		der     []byte
+		want    *Parameters
+		wantErr bool
+	}{
+		{"ok", mustReadPEM(t, "../pemutil/testdata/pkcs8/openssl.rsapss2048.pub.pem"), nil, false},
+		{"ok sha256", mustReadPEM(t, "../pemutil/testdata/pkcs8/openssl.rsapss2048.sha256.pub.pem"), NewParameters(crypto.SHA256), false},
+		{"fail rsa", mustReadPEM(t, "../pemutil/testdata/openssl.rsa2048.pub.pem"), nil, true},
+		{"fail asn1", []byte("foo"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, !tt.wantErr, IsPKIXPublicKey(tt.der))
+			got, err := ParsePKIXPublicKey(tt.der)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Parameters)
+			assert.Equal(t, 2048, got.Size()*8)
+
+			der, err := MarshalPKIXPublicKey(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.der, der)
+		})
+	}
+}
+
+func TestParsePKCS8PrivateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		der     []byte
+		want    *Parameters
+		wantErr bool
+	}{
+		{"ok", mustReadPEM(t, "../pemutil/testdata/pkcs8/openssl.rsapss2048.pem"), nil, false},
+		{"ok sha256", mustReadPEM(t, "../pemutil/testdata/pkcs8/openssl.rsapss2048.sha256.pem"), NewParameters(crypto.SHA256), false},
+		{"fail rsa", mustReadPEM(t, "../pemutil/testdata/pkcs8/openssl.rsa2048.pem"), nil, true},
+		{"fail asn1", []byte("foo"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, !tt.wantErr, IsPKCS8PrivateKey(tt.der))
+			got, err := ParsePKCS8PrivateKey(tt.der)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Parameters)
+
+			der, err := MarshalPKCS8PrivateKey(got)
+			require.NoError(t, err)
+			key, err := ParsePKCS8PrivateKey(der)
+			require.NoError(t, err)
+			assert.True(t, got.Equal(key))
+		})
+	}
+}
+
+func TestMarshal_nil(t *testing.T) {
+	_, err := MarshalPKIXPublicKey(nil)
+	assert.Error(t, err)
+	_, err = MarshalPKCS8PrivateKey(nil)
+	assert.Error(t, err)
+}
+
+func TestParseCertificate(t *testing.T) {
+	der := mustReadPEM(t, "../pemutil/testdata/openssl.rsapss.crt")
+	cert, err := ParseCertificate(der)
+	require.NoError(t, err)
+	assert.Equal(t, x509.RSA, cert.PublicKeyAlgorithm)
+	assert.Equal(t, x509.SHA256WithRSAPSS, cert.SignatureAlgorithm)
+	pub, ok := cert.PublicKey.(*PublicKey)
+	require.True(t, ok)
+	assert.Equal(t, NewParameters(crypto.SHA256), pub.Parameters)
+
+	// crypto/x509 can verify the signature with the unrestricted key.
+	verify := &x509.Certificate{PublicKey: pub.PublicKey}
+	assert.NoError(t, verify.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature))
+
+	// Certificates without RSASSA-PSS keys are not modified.
+	cert, err = ParseCertificate(mustReadPEM(t, "../pemutil/testdata/ca.crt"))
+	require.NoError(t, err)
+	_, ok = cert.PublicKey.(*PublicKey)
+	assert.False(t, ok)
+
+	_, err = ParseCertificate([]byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestParseCertificateRequest(t *testing.T) {
+	der := mustReadPEM(t, "../pemutil/testdata/openssl.rsapss.csr")
+	csr, err := ParseCertificateRequest(der)
+	require.NoError(t, err)
+	assert.Equal(t, x509.RSA, csr.PublicKeyAlgorithm)
+	_, ok := csr.PublicKey.(*PublicKey)
+	require.True(t, ok)
+	assert.NoError(t, CheckCertificateRequestSignature(csr))
+
+	// Modify the signature.
+	bad := *csr
+	bad.Raw = append([]byte{}, csr.Raw...)
+	bad.Raw[len(bad.Raw)-1] ^= 0xff
+	assert.Error(t, CheckCertificateRequestSignature(&bad))
+
+	// Signature parameters must match the key restrictions.
+	restricted := *csr
+	restricted.PublicKey = &PublicKey{
+		PublicKey:  csr.PublicKey.(*PublicKey).PublicKey,
+		Parameters: NewParameters(crypto.SHA384),
+	}
+	assert.Error(t, CheckCertificateRequestSignature(&restricted))
+
+	_, err = ParseCertificateRequest([]byte("foo"))
+	assert.Error(t, err)
+}
+
+func TestCheckCertificateRequestSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: "test"},
+		SignatureAlgorithm: x509.SHA256WithRSAPSS,
+	}, key)
+	require.NoError(t, err)
+	csr, err := ParseCertificateRequest(der)
+	require.NoError(t, err)
+	assert.NoError(t, CheckCertificateRequestSignature(csr))
+
+	// The salt length in the signature algorithm is a minimum.
+	var cr struct {
+		TBSCSR             asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}
+	_, err = asn1.Unmarshal(der, &cr)
+	require.NoError(t, err)
+	digest := sha256.Sum256(cr.TBSCSR.FullBytes)
+	sig, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: 64})
+	require.NoError(t, err)
+	cr.SignatureValue = asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)}
+	der, err = asn1.Marshal(cr)
+	require.NoError(t, err)
+
+	csr, err = ParseCertificateRequest(der)
+	require.NoError(t, err)
+	csr.PublicKey = &PublicKey{
+		PublicKey:  &key.PublicKey,
+		Parameters: NewParameters(crypto.SHA256),
+	}
+	assert.NoError(t, CheckCertificateRequestSignature(csr))
+}
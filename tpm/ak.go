@@ -4,17 +4,16 @@ import (
 	"context"
 	"crypto"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/asn1"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/smallstep/go-attestation/attest"
-	x509ext "github.com/smallstep/go-attestation/x509"
 
+	internalkey "go.step.sm/crypto/tpm/internal/key"
 	"go.step.sm/crypto/tpm/storage"
+	"go.step.sm/crypto/x509util"
 )
 
 // AK models a TPM 2.0 Attestation Key. An AK can be used
@@ -133,7 +132,13 @@ func (ak *AK) MarshalJSON() ([]byte, error) {
 // CreateAK creates and stores a new AK identified by `name`.
 // If no name is  provided, a random 10 character name is generated.
 // If an AK with the same name exists, `ErrExists` is returned.
+// If the owner hierarchy has a password, it must be configured
+// using [WithOwnerPassword].
 func (t *TPM) CreateAK(ctx context.Context, name string) (ak *AK, err error) {
+	if _, err = t.persistPrimaryKeys(ctx); err != nil {
+		return nil, err
+	}
+
 	if err = t.open(ctx); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -203,10 +208,6 @@ func (t *TPM) GetAK(ctx context.Context, name string) (ak *AK, err error) {
 	return akFromStorage(sak, t), nil
 }
 
-var (
-	oidSubjectAlternativeName = asn1.ObjectIdentifier{2, 5, 29, 17}
-)
-
 // GetAKByPermanentIdentifier returns an AK for which a certificate
 // exists with `permanentIdentifier` as one of the Subject Alternative
 // Names. It returns `ErrNotFound` if it doesn't exist.
@@ -331,8 +332,13 @@ type EncryptedCredential attest.EncryptedCredential
 
 // ActivateCredential decrypts the secret using the key to prove that the AK was
 // generated on the same TPM as the EK. This operation is synonymous with
-// TPM2_ActivateCredential.
+// TPM2_ActivateCredential. If the endorsement hierarchy has a
+// password, it must be configured using [WithEndorsementPassword].
 func (ak *AK) ActivateCredential(ctx context.Context, in EncryptedCredential) (secret []byte, err error) {
+	if ak.tpm.options.endorsementPassword != "" {
+		return ak.activateCredentialWithAuth(ctx, in)
+	}
+
 	if err := ak.tpm.open(ctx); err != nil {
 		return secret, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -349,6 +355,26 @@ func (ak *AK) ActivateCredential(ctx context.Context, in EncryptedCredential) (s
 	return
 }
 
+// activateCredentialWithAuth activates the credential authorizing the use
+// of the EK with the endorsement password. go-attestation always uses empty
+// endorsement authorization.
+func (ak *AK) activateCredentialWithAuth(ctx context.Context, in EncryptedCredential) (secret []byte, err error) {
+	if _, err = ak.tpm.persistPrimaryKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = ak.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, ak.tpm, &err)
+
+	if secret, err = internalkey.ActivateCredential(ak.tpm.rwc, ak.data, in.Credential, in.Secret, ak.tpm.options.endorsementPassword); err != nil {
+		return nil, fmt.Errorf("failed activating credential with AK %q: %w", ak.name, err)
+	}
+
+	return
+}
+
 // Blobs returns a container for the private and public AK blobs.
 // The resulting blobs are compatible with tpm2-tools, so can be used
 // like this (after having been written to ak.priv and ak.pub):
@@ -431,19 +457,9 @@ func (ak *AK) HasValidPermanentIdentifier(permanentIdentifier string) bool {
 
 	// TODO(hs): before continuing, add check if the cert is still valid?
 
-	var sanExtension pkix.Extension
-	for _, ext := range akCert.Extensions {
-		if ext.Id.Equal(oidSubjectAlternativeName) {
-			sanExtension = ext
-			break
-		}
-	}
-
-	if sanExtension.Value == nil {
-		return false
-	}
-
-	san, err := x509ext.ParseSubjectAltName(sanExtension) // TODO(hs): move to a package under our control?
+	// the SAN can contain other names, like the EK URI, in addition to the
+	// permanent identifiers.
+	san, err := x509util.ParseSubjectAlternativeNames(akCert)
 	if err != nil {
 		return false
 	}
@@ -451,7 +467,7 @@ func (ak *AK) HasValidPermanentIdentifier(permanentIdentifier string) bool {
 	// loop through the permanent identifier values and return
 	// if the requested PermanentIdentifier was found.
 	for _, p := range san.PermanentIdentifiers {
-		if p.IdentifierValue == permanentIdentifier {
+		if p.Identifier == permanentIdentifier {
 			return true
 		}
 	}
@@ -0,0 +1,432 @@
+// Package ca implements the server side of the TPM attestation flow performed
+// by [attestation.Client]. It validates the EK of the TPM, challenges the TPM
+// to activate a credential bound to the AK, and issues a certificate for the
+// AK after the TPM proved it could decrypt the credential.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+//
+// [attestation.Client]: https://pkg.go.dev/go.step.sm/crypto/tpm/attestation#Client
+package ca
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/smallstep/go-attestation/attest"
+
+	"go.step.sm/crypto/tpm/ekverify"
+	"go.step.sm/crypto/x509util"
+)
+
+const (
+	// DefaultAKTemplate is the template used to issue AK certificates. The
+	// subject is empty, and the SANs contain the EK URI as a URI and as a
+	// permanent identifier.
+	DefaultAKTemplate = `{
+	"subject": {{ toJson .Subject }},
+	"sans": {{ toJson .SANs }},
+	"keyUsage": ["digitalSignature"],
+	"unknownExtKeyUsage": ["2.23.133.8.3"]
+}`
+
+	// defaultValidity is the default validity of AK certificates.
+	defaultValidity = 24 * time.Hour
+
+	// defaultSessionTTL is the time a client has to activate the credential
+	// and send the secret after attestation.
+	defaultSessionTTL = 5 * time.Minute
+
+	// maxRequestSize is the maximum size of a request body.
+	maxRequestSize = 1 << 20
+)
+
+// TPMInfoKey is the key of the TPM information in the template data
+// used to issue AK certificates. Its value is a [TPMInfo].
+const TPMInfoKey = "TPMInfo"
+
+// TPMInfo is the information about the TPM sent by the client.
+type TPMInfo struct {
+	Manufacturer    string `json:"manufacturer,omitempty"`
+	Model           string `json:"model,omitempty"`
+	FirmwareVersion string `json:"firmwareVersion,omitempty"`
+}
+
+// CA is an attestation CA. It implements [http.Handler], serving the
+// /attest and /secret endpoints.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type CA struct {
+	chain           []*x509.Certificate
+	signer          crypto.Signer
+	ekRoots         *x509.CertPool
+	ekIntermediates *x509.CertPool
+	ekVerifier      *ekverify.Verifier
+	allowedEKs      map[string]struct{}
+	template        string
+	validity        time.Duration
+	sessionTTL      time.Duration
+	now             func() time.Time
+
+	mu       sync.Mutex
+	sessions map[[sha256.Size]byte]*session
+}
+
+// session is an attestation that's waiting for the secret.
+type session struct {
+	secret    []byte
+	ekURI     *url.URL
+	akPublic  crypto.PublicKey
+	tpmInfo   TPMInfo
+	expiresAt time.Time
+}
+
+// Option is the type of options for the CA.
+type Option func(ca *CA) error
+
+// WithEKRoots sets the roots used to verify EK certificates.
+func WithEKRoots(roots *x509.CertPool) Option {
+	return func(ca *CA) error {
+		ca.ekRoots = roots
+		return nil
+	}
+}
+
+// WithEKIntermediates sets the intermediates used to verify EK
+// certificates, in addition to the intermediates sent by the client.
+func WithEKIntermediates(intermediates *x509.CertPool) Option {
+	return func(ca *CA) error {
+		ca.ekIntermediates = intermediates
+		return nil
+	}
+}
+
+// WithEKVerifier sets the verifier used to verify EK certificates against
+// the roots of the TPM manufacturers. If set, the EK roots and intermediates
+// are not used.
+func WithEKVerifier(v *ekverify.Verifier) Option {
+	return func(ca *CA) error {
+		ca.ekVerifier = v
+		return nil
+	}
+}
+
+// WithAllowedEKs sets the EK public keys that are allowed without an EK
+// certificate, e.g. for TPMs without an EK certificate.
+func WithAllowedEKs(eks ...crypto.PublicKey) Option {
+	return func(ca *CA) error {
+		for _, ek := range eks {
+			u, err := ekURI(ek)
+			if err != nil {
+				return err
+			}
+			ca.allowedEKs[u.String()] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithTemplate sets the template used to issue AK certificates. The
+// template data contains an empty subject, the SANs with the EK URI, and
+// the [TPMInfo] at [TPMInfoKey].
+func WithTemplate(text string) Option {
+	return func(ca *CA) error {
+		if text == "" {
+			return errors.New("template cannot be empty")
+		}
+		ca.template = text
+		return nil
+	}
+}
+
+// WithValidity sets the validity of AK certificates.
+func WithValidity(d time.Duration) Option {
+	return func(ca *CA) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid validity %s", d)
+		}
+		ca.validity = d
+		return nil
+	}
+}
+
+// WithSessionTTL sets the time clients have to send the secret after
+// attestation.
+func WithSessionTTL(d time.Duration) Option {
+	return func(ca *CA) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid session TTL %s", d)
+		}
+		ca.sessionTTL = d
+		return nil
+	}
+}
+
+// New creates a new attestation CA. AK certificates are signed by `signer`,
+// and issued by the first certificate in `chain`. The chain is returned to
+// clients after the AK certificate.
+//
+// EK certificates are verified using the roots set with [WithEKRoots].
+// EKs without a certificate are only allowed if they're set with
+// [WithAllowedEKs].
+func New(chain []*x509.Certificate, signer crypto.Signer, opts ...Option) (*CA, error) {
+	switch {
+	case len(chain) == 0:
+		return nil, errors.New("certificate chain cannot be empty")
+	case signer == nil:
+		return nil, errors.New("signer cannot be nil")
+	}
+	if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(chain[0].PublicKey) {
+		return nil, errors.New("signer public key does not match the issuer certificate")
+	}
+
+	ca := &CA{
+		chain:      chain,
+		signer:     signer,
+		allowedEKs: make(map[string]struct{}),
+		template:   DefaultAKTemplate,
+		validity:   defaultValidity,
+		sessionTTL: defaultSessionTTL,
+		now:        time.Now,
+		sessions:   make(map[[sha256.Size]byte]*session),
+	}
+	for _, o := range opts {
+		if err := o(ca); err != nil {
+			return nil, fmt.Errorf("failed applying option to attestation CA: %w", err)
+		}
+	}
+
+	return ca, nil
+}
+
+// ServeHTTP implements [http.Handler].
+func (ca *CA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	switch r.URL.Path {
+	case "/attest":
+		ca.attest(w, r)
+	case "/secret":
+		ca.secret(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+type attestationParameters struct {
+	Public                  []byte `json:"public,omitempty"`
+	UseTCSDActivationFormat bool   `json:"useTCSDActivationFormat,omitempty"`
+	CreateData              []byte `json:"createData,omitempty"`
+	CreateAttestation       []byte `json:"createAttestation,omitempty"`
+	CreateSignature         []byte `json:"createSignature,omitempty"`
+}
+
+type tpmInfo struct {
+	Version attest.TPMVersion `json:"version,omitempty"`
+	TPMInfo
+}
+
+type attestationRequest struct {
+	TPMInfo      tpmInfo               `json:"tpmInfo"`
+	EKPub        []byte                `json:"ek,omitempty"`
+	EKCerts      [][]byte              `json:"ekCerts,omitempty"`
+	AKCert       []byte                `json:"akCert,omitempty"`
+	AttestParams attestationParameters `json:"params"`
+}
+
+type attestationResponse struct {
+	Credential []byte `json:"credential"`
+	Secret     []byte `json:"secret"` // encrypted secret
+}
+
+type secretRequest struct {
+	Secret []byte `json:"secret"` // decrypted secret
+}
+
+type secretResponse struct {
+	CertificateChain [][]byte `json:"chain"`
+}
+
+// attest validates the EK and the AK attestation parameters, and returns a
+// credential that can only be activated by the TPM the EK and AK belong to.
+func (ca *CA) attest(w http.ResponseWriter, r *http.Request) {
+	var req attestationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "failed decoding attestation request")
+		return
+	}
+
+	if req.TPMInfo.Version != attest.TPMVersion20 {
+		writeError(w, http.StatusBadRequest, "only TPM 2.0 is supported")
+		return
+	}
+
+	ek, err := ca.verifyEK(r.Context(), req.EKPub, req.EKCerts)
+	if err != nil {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("failed validating EK: %v", err))
+		return
+	}
+	u, err := ekURI(ek)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed validating EK: %v", err))
+		return
+	}
+
+	params := attest.AttestationParameters{
+		Public:                  req.AttestParams.Public,
+		UseTCSDActivationFormat: req.AttestParams.UseTCSDActivationFormat,
+		CreateData:              req.AttestParams.CreateData,
+		CreateAttestation:       req.AttestParams.CreateAttestation,
+		CreateSignature:         req.AttestParams.CreateSignature,
+	}
+	akPub, err := attest.ParseAKPublic(attest.TPMVersion20, params.Public)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed parsing AK: %v", err))
+		return
+	}
+
+	activation := attest.ActivationParameters{
+		TPMVersion: attest.TPMVersion20,
+		EK:         ek,
+		AK:         params,
+	}
+	secret, encrypted, err := activation.Generate()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed validating AK: %v", err))
+		return
+	}
+
+	now := ca.now()
+	ca.mu.Lock()
+	for k, s := range ca.sessions {
+		if now.After(s.expiresAt) {
+			delete(ca.sessions, k)
+		}
+	}
+	ca.sessions[sha256.Sum256(secret)] = &session{
+		secret:    secret,
+		ekURI:     u,
+		akPublic:  akPub.Public,
+		tpmInfo:   req.TPMInfo.TPMInfo,
+		expiresAt: now.Add(ca.sessionTTL),
+	}
+	ca.mu.Unlock()
+
+	writeJSON(w, &attestationResponse{
+		Credential: encrypted.Credential,
+		Secret:     encrypted.Secret,
+	})
+}
+
+// secret verifies the secret decrypted by the TPM, and issues the AK
+// certificate. The secret can only be used once.
+func (ca *CA) secret(w http.ResponseWriter, r *http.Request) {
+	var req secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "failed decoding secret request")
+		return
+	}
+
+	k := sha256.Sum256(req.Secret)
+	ca.mu.Lock()
+	s, ok := ca.sessions[k]
+	delete(ca.sessions, k)
+	ca.mu.Unlock()
+
+	if !ok || subtle.ConstantTimeCompare(s.secret, req.Secret) != 1 || ca.now().After(s.expiresAt) {
+		writeError(w, http.StatusForbidden, "invalid secret")
+		return
+	}
+
+	cert, err := ca.sign(s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed issuing AK certificate")
+		return
+	}
+
+	chain := make([][]byte, 0, len(ca.chain)+1)
+	chain = append(chain, cert.Raw)
+	for _, c := range ca.chain {
+		chain = append(chain, c.Raw)
+	}
+
+	writeJSON(w, &secretResponse{
+		CertificateChain: chain,
+	})
+}
+
+// sign issues the certificate for the AK in the session.
+func (ca *CA) sign(s *session) (*x509.Certificate, error) {
+	data := x509util.NewTemplateData()
+	data.SetSubject(x509util.Subject{})
+	data.SetSubjectAlternativeNames(
+		x509util.SubjectAlternativeName{Type: x509util.URIType, Value: s.ekURI.String()},
+		x509util.SubjectAlternativeName{Type: x509util.PermanentIdentifierType, Value: s.ekURI.String()},
+	)
+	data.Set(TPMInfoKey, s.tpmInfo)
+
+	cert, err := x509util.NewCertificateFromX509(&x509.Certificate{
+		PublicKey: s.akPublic,
+	}, x509util.WithTemplate(ca.template, data))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating AK certificate: %w", err)
+	}
+
+	template := cert.GetCertificate()
+	if template.NotBefore.IsZero() {
+		template.NotBefore = ca.now().Add(-time.Minute).Truncate(time.Second)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(ca.validity)
+	}
+
+	return x509util.CreateCertificate(template, ca.chain[0], s.akPublic, ca.signer)
+}
+
+// ekURI returns the URI identifying the EK. It has the form
+// urn:ek:sha256:<base64 encoded SHA256 of the EK public key>.
+func ekURI(ek crypto.PublicKey) (*url.URL, error) {
+	b, err := x509.MarshalPKIXPublicKey(ek)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling EK public key: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return &url.URL{
+		Scheme: "urn",
+		Opaque: "ek:sha256:" + base64.StdEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,89 @@
+//go:build tpmsimulator
+// +build tpmsimulator
+
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/tpm"
+	"go.step.sm/crypto/tpm/attestation"
+	"go.step.sm/crypto/tpm/simulator"
+	"go.step.sm/crypto/tpm/storage"
+	"go.step.sm/crypto/x509util"
+)
+
+func newSimulatedTPM(t *testing.T) *tpm.TPM {
+	t.Helper()
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	instance, err := tpm.New(tpm.WithSimulator(sim), tpm.WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+	return instance
+}
+
+func TestCA_simulator(t *testing.T) {
+	ctx := context.Background()
+	instance := newSimulatedTPM(t)
+	eks, err := instance.GetEKs(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, eks)
+	ek := eks[0]
+	ekURI, err := ek.FingerprintURI()
+	require.NoError(t, err)
+
+	// the simulator has no EK certificate, so the EK is allowed explicitly
+	ca, mca := mustCA(t, WithAllowedEKs(ek.Public()))
+	srv := httptest.NewServer(ca)
+	t.Cleanup(srv.Close)
+
+	client, err := attestation.NewClient(srv.URL)
+	require.NoError(t, err)
+
+	ak, err := instance.CreateAK(ctx, "ak")
+	require.NoError(t, err)
+
+	chain, err := client.Attest(ctx, instance, ek, ak)
+	require.NoError(t, err)
+	require.Len(t, chain, 3)
+	assert.Equal(t, mca.Intermediate, chain[1])
+	assert.Equal(t, mca.Root, chain[2])
+
+	roots := x509.NewCertPool()
+	roots.AddCert(mca.Root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(mca.Intermediate)
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	require.NoError(t, err)
+
+	sans, err := x509util.ParseSubjectAlternativeNames(chain[0])
+	require.NoError(t, err)
+	assert.Equal(t, []x509util.PermanentIdentifier{{Identifier: ekURI.String()}}, sans.PermanentIdentifiers)
+
+	require.NoError(t, ak.SetCertificateChain(ctx, chain))
+	assert.True(t, ak.HasValidPermanentIdentifier(ekURI.String()))
+	assert.Empty(t, ca.sessions)
+
+	// attestation fails for EKs that aren't allowed
+	ca, _ = mustCA(t)
+	srv2 := httptest.NewServer(ca)
+	t.Cleanup(srv2.Close)
+	client, err = attestation.NewClient(srv2.URL)
+	require.NoError(t, err)
+	_, err = client.Attest(ctx, instance, ek, ak)
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/tpm/ekverify"
+	"go.step.sm/crypto/x509util"
+)
+
+func mustCA(t *testing.T, opts ...Option) (*CA, *minica.CA) {
+	t.Helper()
+	mca, err := minica.New()
+	require.NoError(t, err)
+	ca, err := New([]*x509.Certificate{mca.Intermediate, mca.Root}, mca.Signer, opts...)
+	require.NoError(t, err)
+	return ca, mca
+}
+
+// mustEKCertificate returns an EK certificate with a critical SAN containing
+// the TPM information, like the ones issued by TPM manufacturers.
+func mustEKCertificate(t *testing.T, mca *minica.CA, ek crypto.PublicKey) *x509.Certificate {
+	t.Helper()
+	name, err := asn1.Marshal(pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 1}, Value: "id:53544D20"},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 2}, Value: "ST33HTPHAHD4"},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 3}, Value: "id:00010101"},
+	}}.ToRDNSequence())
+	require.NoError(t, err)
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Tag: 4, Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: name},
+	})
+	require.NoError(t, err)
+	cert, err := mca.Sign(&x509.Certificate{
+		PublicKey:          ek,
+		KeyUsage:           x509.KeyUsageKeyEncipherment,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{{2, 23, 133, 8, 1}},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidSubjectAlternativeName, Critical: true, Value: san},
+		},
+	})
+	require.NoError(t, err)
+	return cert
+}
+
+func TestNew(t *testing.T) {
+	mca, err := minica.New()
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	chain := []*x509.Certificate{mca.Intermediate}
+
+	tests := []struct {
+		name    string
+		chain   []*x509.Certificate
+		signer  crypto.Signer
+		opts    []Option
+		wantErr bool
+	}{
+		{"ok", chain, mca.Signer, nil, false},
+		{"ok/options", chain, mca.Signer, []Option{
+			WithEKRoots(x509.NewCertPool()), WithEKIntermediates(x509.NewCertPool()),
+			WithAllowedEKs(other.Public()), WithTemplate(x509util.DefaultLeafTemplate),
+			WithValidity(time.Hour), WithSessionTTL(time.Minute),
+		}, false},
+		{"fail/chain", nil, mca.Signer, nil, true},
+		{"fail/signer", chain, nil, nil, true},
+		{"fail/signer-mismatch", chain, other, nil, true},
+		{"fail/allowed-eks", chain, mca.Signer, []Option{WithAllowedEKs("not a key")}, true},
+		{"fail/template", chain, mca.Signer, []Option{WithTemplate("")}, true},
+		{"fail/validity", chain, mca.Signer, []Option{WithValidity(0)}, true},
+		{"fail/session-ttl", chain, mca.Signer, []Option{WithSessionTTL(-time.Second)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.chain, tt.signer, tt.opts...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, got)
+		})
+	}
+}
+
+func TestCA_verifyEK(t *testing.T) {
+	ekCA, err := minica.New()
+	require.NoError(t, err)
+	otherCA, err := minica.New()
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ekCA.Root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(ekCA.Intermediate)
+
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ekPub, err := x509.MarshalPKIXPublicKey(ek.Public())
+	require.NoError(t, err)
+	ekCert := mustEKCertificate(t, ekCA, ek.Public())
+	otherEKCert := mustEKCertificate(t, otherCA, ek.Public())
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	mismatchCert := mustEKCertificate(t, ekCA, other.Public())
+
+	withRoots, _ := mustCA(t, WithEKRoots(roots))
+	withIntermediates, _ := mustCA(t, WithEKRoots(roots), WithEKIntermediates(intermediates))
+	bundle := ekverify.NewBundle()
+	bundle.AddCertificates("STM", ekCA.Root)
+	verifier, err := ekverify.New(ekverify.WithBundle(bundle), ekverify.WithMaxFetches(0))
+	require.NoError(t, err)
+	withVerifier, _ := mustCA(t, WithEKVerifier(verifier))
+	withAllowed, _ := mustCA(t, WithAllowedEKs(ek.Public()))
+	withNothing, _ := mustCA(t)
+
+	tests := []struct {
+		name    string
+		ca      *CA
+		ekPub   []byte
+		ekCerts [][]byte
+		wantErr bool
+	}{
+		{"ok/certificate", withRoots, ekPub, [][]byte{ekCert.Raw, ekCA.Intermediate.Raw}, false},
+		{"ok/certificate-only", withRoots, nil, [][]byte{ekCert.Raw, ekCA.Intermediate.Raw}, false},
+		{"ok/configured-intermediates", withIntermediates, ekPub, [][]byte{ekCert.Raw}, false},
+		{"ok/verifier", withVerifier, ekPub, [][]byte{ekCert.Raw, ekCA.Intermediate.Raw}, false},
+		{"ok/allowed", withAllowed, ekPub, nil, false},
+		{"ok/allowed-with-certificate", withAllowed, ekPub, [][]byte{otherEKCert.Raw}, false},
+		{"fail/empty", withRoots, nil, nil, true},
+		{"fail/public-key", withRoots, []byte("foo"), nil, true},
+		{"fail/certificate", withRoots, ekPub, [][]byte{[]byte("foo")}, true},
+		{"fail/not-allowed", withRoots, ekPub, nil, true},
+		{"fail/mismatch", withRoots, ekPub, [][]byte{mismatchCert.Raw, ekCA.Intermediate.Raw}, true},
+		{"fail/no-intermediates", withRoots, ekPub, [][]byte{ekCert.Raw}, true},
+		{"fail/untrusted", withRoots, ekPub, [][]byte{otherEKCert.Raw, otherCA.Intermediate.Raw}, true},
+		{"fail/verifier", withVerifier, ekPub, [][]byte{otherEKCert.Raw, otherCA.Intermediate.Raw}, true},
+		{"fail/no-roots", withNothing, ekPub, [][]byte{ekCert.Raw, ekCA.Intermediate.Raw}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ca.verifyEK(context.Background(), tt.ekPub, tt.ekCerts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, ek.Public(), got)
+		})
+	}
+}
+
+func TestCA_sign(t *testing.T) {
+	ca, mca := mustCA(t, WithValidity(time.Hour))
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ak, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	u, err := ekURI(ek.Public())
+	require.NoError(t, err)
+
+	cert, err := ca.sign(&session{ekURI: u, akPublic: ak.Public()})
+	require.NoError(t, err)
+	assert.Equal(t, ak.Public(), cert.PublicKey)
+	assert.Equal(t, mca.Intermediate.Subject, cert.Issuer)
+	assert.Empty(t, cert.Subject.String())
+	assert.Equal(t, time.Hour, cert.NotAfter.Sub(cert.NotBefore))
+	assert.Equal(t, x509.KeyUsageDigitalSignature, cert.KeyUsage)
+	assert.Equal(t, []asn1.ObjectIdentifier{{2, 23, 133, 8, 3}}, cert.UnknownExtKeyUsage)
+	if assert.Len(t, cert.URIs, 1) {
+		assert.Equal(t, u.String(), cert.URIs[0].String())
+	}
+
+	sans, err := x509util.ParseSubjectAlternativeNames(cert)
+	require.NoError(t, err)
+	assert.Equal(t, []x509util.PermanentIdentifier{{Identifier: u.String()}}, sans.PermanentIdentifiers)
+
+	// custom template with the TPM information
+	ca, _ = mustCA(t, WithTemplate(`{
+	"subject": {"commonName": {{ toJson .TPMInfo.Model }}},
+	"sans": {{ toJson .SANs }}
+}`))
+	cert, err = ca.sign(&session{ekURI: u, akPublic: ak.Public(), tpmInfo: TPMInfo{Model: "model"}})
+	require.NoError(t, err)
+	assert.Equal(t, "model", cert.Subject.CommonName)
+
+	ca, _ = mustCA(t, WithTemplate(`{{ fail "forbidden" }}`))
+	_, err = ca.sign(&session{ekURI: u, akPublic: ak.Public()})
+	assert.Error(t, err)
+}
+
+func TestCA_ServeHTTP(t *testing.T) {
+	ca, _ := mustCA(t)
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ekPub, err := x509.MarshalPKIXPublicKey(ek.Public())
+	require.NoError(t, err)
+	allowed, _ := mustCA(t, WithAllowedEKs(ek.Public()))
+
+	mustJSON := func(v any) []byte {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return b
+	}
+
+	tests := []struct {
+		name       string
+		ca         *CA
+		method     string
+		path       string
+		body       []byte
+		wantStatus int
+	}{
+		{"fail/method", ca, http.MethodGet, "/attest", nil, http.StatusMethodNotAllowed},
+		{"fail/path", ca, http.MethodPost, "/foo", nil, http.StatusNotFound},
+		{"fail/attest-json", ca, http.MethodPost, "/attest", []byte("{"), http.StatusBadRequest},
+		{"fail/attest-version", ca, http.MethodPost, "/attest", mustJSON(attestationRequest{EKPub: ekPub}), http.StatusBadRequest},
+		{"fail/attest-ek", ca, http.MethodPost, "/attest", mustJSON(attestationRequest{
+			TPMInfo: tpmInfo{Version: 2}, EKPub: ekPub,
+		}), http.StatusForbidden},
+		{"fail/attest-ak", allowed, http.MethodPost, "/attest", mustJSON(attestationRequest{
+			TPMInfo: tpmInfo{Version: 2}, EKPub: ekPub, AttestParams: attestationParameters{Public: []byte("foo")},
+		}), http.StatusBadRequest},
+		{"fail/secret-json", ca, http.MethodPost, "/secret", []byte("{"), http.StatusBadRequest},
+		{"fail/secret", ca, http.MethodPost, "/secret", mustJSON(secretRequest{Secret: []byte("foo")}), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.ca.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			var resp struct {
+				Error string `json:"error"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.NotEmpty(t, resp.Error)
+		})
+	}
+}
+
+func TestCA_secret_expired(t *testing.T) {
+	ca, _ := mustCA(t)
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	u, err := ekURI(ek.Public())
+	require.NoError(t, err)
+
+	now := time.Now()
+	ca.now = func() time.Time { return now }
+	secret := []byte("secret")
+	ca.sessions[sha256.Sum256(secret)] = &session{
+		secret:    secret,
+		ekURI:     u,
+		akPublic:  ek.Public(),
+		expiresAt: now.Add(-time.Second),
+	}
+
+	body, err := json.Marshal(secretRequest{Secret: secret})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	ca.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/secret", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, ca.sessions)
+}
@@ -0,0 +1,106 @@
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// oidSubjectAlternativeName is the OID of the SAN extension. EK certificates
+// have a critical SAN with the TPM manufacturer, model and version encoded
+// as a directory name, which isn't handled by crypto/x509.
+var oidSubjectAlternativeName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// verifyEK returns the EK public key after validating it. EKs set with
+// [WithAllowedEKs] are always allowed. Otherwise, the first certificate in
+// `ekCerts` must be valid for the EK public key, and chain to the EK roots,
+// or be verified by the EK verifier, using the other certificates as
+// intermediates.
+func (ca *CA) verifyEK(ctx context.Context, ekPub []byte, ekCerts [][]byte) (crypto.PublicKey, error) {
+	var ek crypto.PublicKey
+	if len(ekPub) > 0 {
+		var err error
+		if ek, err = x509.ParsePKIXPublicKey(ekPub); err != nil {
+			return nil, fmt.Errorf("failed parsing EK public key: %w", err)
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, der := range ekCerts {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing EK certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if ek == nil {
+		if len(certs) == 0 {
+			return nil, errors.New("EK public key or certificate is required")
+		}
+		ek = certs[0].PublicKey
+	}
+
+	u, err := ekURI(ek)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ca.allowedEKs[u.String()]; ok {
+		return ek, nil
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("EK %q is not allowed without certificate", u)
+	}
+	if pub, ok := ek.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(certs[0].PublicKey) {
+		return nil, errors.New("EK public key does not match the EK certificate")
+	}
+	if ca.ekVerifier != nil {
+		if _, err := ca.ekVerifier.Verify(ctx, certs[0], certs[1:]...); err != nil {
+			return nil, err
+		}
+		return ek, nil
+	}
+	if err := ca.verifyEKCertificate(certs[0], certs[1:]); err != nil {
+		return nil, err
+	}
+
+	return ek, nil
+}
+
+// verifyEKCertificate verifies the EK certificate chains to the EK roots.
+func (ca *CA) verifyEKCertificate(cert *x509.Certificate, intermediates []*x509.Certificate) error {
+	if ca.ekRoots == nil {
+		return errors.New("EK certificate can't be verified: no EK roots configured")
+	}
+
+	pool := x509.NewCertPool()
+	if ca.ekIntermediates != nil {
+		pool = ca.ekIntermediates.Clone()
+	}
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+
+	// the critical SAN with the TPM information is accepted as is
+	c := *cert
+	c.UnhandledCriticalExtensions = nil
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAlternativeName) {
+			c.UnhandledCriticalExtensions = append(c.UnhandledCriticalExtensions, oid)
+		}
+	}
+
+	if _, err := c.Verify(x509.VerifyOptions{
+		Roots:         ca.ekRoots,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("failed verifying EK certificate: %w", err)
+	}
+
+	return nil
+}
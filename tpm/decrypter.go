@@ -0,0 +1,286 @@
+package tpm
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+// decrypter implements crypto.Decrypter backed by a TPM RSA key.
+type decrypter struct {
+	tpm    *TPM
+	key    Key
+	public *rsa.PublicKey
+}
+
+// Public returns the decrypters public key.
+func (d *decrypter) Public() crypto.PublicKey {
+	return d.public
+}
+
+// Decrypt implements crypto.Decrypter. It is backed by a TPM key, which is
+// loaded on every call to Decrypt(). Both RSA PKCS #1 v1.5 and RSA-OAEP are
+// supported. The TPM appends a NUL byte to OAEP labels, so a non-empty label
+// must end with a NUL byte.
+func (d *decrypter) Decrypt(_ io.Reader, msg []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
+	scheme, label, err := rsaDecryptScheme(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if err = d.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, d.tpm, &err)
+
+	session, err := d.tpm.encryptedSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting with key %q: %w", d.key.name, err)
+	}
+
+	plaintext, err = internalkey.Decrypt(d.tpm.rwc, d.key.data, session, scheme, label, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting with key %q: %w", d.key.name, err)
+	}
+
+	return
+}
+
+// rsaDecryptScheme returns the TPM decryption scheme and label for the given
+// crypto.DecrypterOpts.
+func rsaDecryptScheme(opts crypto.DecrypterOpts) (*tpm2.AsymScheme, string, error) {
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		return &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}, "", nil
+	case *rsa.OAEPOptions:
+		hash, err := tpm2.HashToAlgorithm(o.Hash)
+		if err != nil {
+			return nil, "", fmt.Errorf("unsupported OAEP hash %s", o.Hash)
+		}
+		if o.MGFHash != 0 && o.MGFHash != o.Hash {
+			return nil, "", errors.New("OAEP MGF1 hash must be the same as the OAEP hash")
+		}
+		var label string
+		if len(o.Label) > 0 {
+			if o.Label[len(o.Label)-1] != 0 {
+				return nil, "", errors.New("OAEP label must be terminated by a NUL byte")
+			}
+			if bytes.IndexByte(o.Label[:len(o.Label)-1], 0) >= 0 {
+				return nil, "", errors.New("OAEP label cannot contain NUL bytes before the terminator")
+			}
+			label = string(o.Label[:len(o.Label)-1])
+		}
+		return &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: hash}, label, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported decrypter options %T", opts)
+	}
+}
+
+// GetDecrypter returns a crypto.Decrypter for a TPM RSA Key identified by
+// `name`. The Key must have been created with the `Decrypt` option set.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetDecrypter(ctx context.Context, name string) (cdecrypter crypto.Decrypter, err error) {
+	key, pub, err := t.getDecryptionKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %q is not an RSA key", name)
+	}
+
+	cdecrypter = &decrypter{
+		tpm:    t,
+		key:    *key,
+		public: rsaPub,
+	}
+
+	return
+}
+
+// Decrypter returns a crypto.Decrypter backed by the Key. The Key must be an
+// RSA key created with the `Decrypt` option set.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *Key) Decrypter(ctx context.Context) (crypto.Decrypter, error) {
+	return k.tpm.GetDecrypter(ctx, k.name)
+}
+
+// ECDH performs Elliptic Curve Diffie-Hellman key agreement using a TPM ECC
+// key. The private key never leaves the TPM; the shared secret is computed
+// using the TPM2_ECDH_ZGen command.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type ECDH struct {
+	tpm    *TPM
+	key    Key
+	public *ecdsa.PublicKey
+}
+
+// Public returns the public key as a crypto.PublicKey. The underlying type is
+// *ecdsa.PublicKey.
+func (e *ECDH) Public() crypto.PublicKey {
+	return e.public
+}
+
+// Curve returns the [ecdh.Curve] of the key.
+func (e *ECDH) Curve() ecdh.Curve {
+	switch e.public.Curve {
+	case elliptic.P256():
+		return ecdh.P256()
+	case elliptic.P384():
+		return ecdh.P384()
+	case elliptic.P521():
+		return ecdh.P521()
+	default:
+		return nil
+	}
+}
+
+// PublicKey returns the [ecdh.PublicKey] representation of the key. It returns
+// nil if the key cannot be converted.
+func (e *ECDH) PublicKey() *ecdh.PublicKey {
+	pub, err := e.public.ECDH()
+	if err != nil {
+		return nil
+	}
+	return pub
+}
+
+// ECDH performs an ECDH exchange and returns the shared secret. The private key
+// and public key must use the same curve.
+//
+// This performs ECDH as specified in SEC 1, Version 2.0, Section 3.3.1, and
+// returns the x-coordinate encoded according to SEC 1, Version 2.0, Section
+// 2.3.5, the same as [ecdh.PrivateKey.ECDH].
+func (e *ECDH) ECDH(pub *ecdh.PublicKey) (secret []byte, err error) {
+	curve := e.Curve()
+	if pub == nil || curve == nil || pub.Curve() != curve {
+		return nil, errors.New("public key does not match the curve of the TPM key")
+	}
+
+	// Uncompressed points are encoded as 0x04 || X || Y.
+	raw := pub.Bytes()
+	size := (len(raw) - 1) / 2
+	point := tpm2.ECPoint{
+		XRaw: raw[1 : 1+size],
+		YRaw: raw[1+size:],
+	}
+
+	ctx := context.Background()
+	if err = e.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, e.tpm, &err)
+
+	session, err := e.tpm.encryptedSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed computing shared secret with key %q: %w", e.key.name, err)
+	}
+
+	z, err := internalkey.ECDHZGen(e.tpm.rwc, e.key.data, session, point)
+	if err != nil {
+		return nil, fmt.Errorf("failed computing shared secret with key %q: %w", e.key.name, err)
+	}
+
+	// The TPM may strip leading zeros, so the result is padded to the size of
+	// the field.
+	if len(z.XRaw) > size {
+		return nil, errors.New("invalid shared secret returned by the TPM")
+	}
+	secret = make([]byte, size)
+	copy(secret[size-len(z.XRaw):], z.XRaw)
+
+	return
+}
+
+// GetECDH returns an [ECDH] for a TPM ECC Key identified by `name`. The Key
+// must have been created with the `Decrypt` option set.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetECDH(ctx context.Context, name string) (*ECDH, error) {
+	key, pub, err := t.getDecryptionKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %q is not an ECC key", name)
+	}
+
+	return &ECDH{
+		tpm:    t,
+		key:    *key,
+		public: ecdsaPub,
+	}, nil
+}
+
+// ECDH returns an [ECDH] backed by the Key. The Key must be an ECC key created
+// with the `Decrypt` option set.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *Key) ECDH(ctx context.Context) (*ECDH, error) {
+	return k.tpm.GetECDH(ctx, k.name)
+}
+
+// getDecryptionKey returns the Key identified by `name` and its public key. It
+// returns an error if the Key can't be used for decryption.
+func (t *TPM) getDecryptionKey(ctx context.Context, name string) (key *Key, pub crypto.PublicKey, err error) {
+	if err = t.open(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	skey, err := t.store.GetKey(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil, fmt.Errorf("failed getting key %q: %w", name, ErrNotFound)
+		}
+		return nil, nil, fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+
+	public, err := internalkey.Public(skey.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed getting public area of key %q: %w", name, err)
+	}
+	if public.Attributes&tpm2.FlagDecrypt == 0 || public.Attributes&tpm2.FlagRestricted != 0 {
+		return nil, nil, fmt.Errorf("key %q is not a decryption key", name)
+	}
+
+	if pub, err = public.Key(); err != nil {
+		return nil, nil, fmt.Errorf("failed getting public key of key %q: %w", name, err)
+	}
+
+	return keyFromStorage(skey, t), pub, nil
+}
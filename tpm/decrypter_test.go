@@ -0,0 +1,39 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rsaDecryptScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       crypto.DecrypterOpts
+		wantScheme *tpm2.AsymScheme
+		wantLabel  string
+		assertion  assert.ErrorAssertionFunc
+	}{
+		{"ok nil", nil, &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}, "", assert.NoError},
+		{"ok pkcs1v15", &rsa.PKCS1v15DecryptOptions{}, &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}, "", assert.NoError},
+		{"ok oaep", &rsa.OAEPOptions{Hash: crypto.SHA256}, &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: tpm2.AlgSHA256}, "", assert.NoError},
+		{"ok oaep mgf hash", &rsa.OAEPOptions{Hash: crypto.SHA384, MGFHash: crypto.SHA384}, &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: tpm2.AlgSHA384}, "", assert.NoError},
+		{"ok oaep label", &rsa.OAEPOptions{Hash: crypto.SHA1, Label: []byte("label\x00")}, &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: tpm2.AlgSHA1}, "label", assert.NoError},
+		{"fail hash", &rsa.OAEPOptions{Hash: crypto.MD5}, nil, "", assert.Error},
+		{"fail mgf hash", &rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.SHA1}, nil, "", assert.Error},
+		{"fail label", &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}, nil, "", assert.Error},
+		{"fail label nul", &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("la\x00bel\x00")}, nil, "", assert.Error},
+		{"fail opts", crypto.SHA256, nil, "", assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, label, err := rsaDecryptScheme(tt.opts)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.wantScheme, scheme)
+			assert.Equal(t, tt.wantLabel, label)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+// ExportedKey is a Key duplicated to a new parent in another TPM. It can be
+// imported by the TPM the new parent belongs to using [TPM.ImportKey].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type ExportedKey struct {
+	// Public is the TPM public area of the Key.
+	Public []byte `json:"public"`
+	// Duplicate is the private area of the Key, encrypted for the new
+	// parent.
+	Duplicate []byte `json:"duplicate"`
+	// Seed is the seed used to encrypt the private area, encrypted to the
+	// public key of the new parent.
+	Seed []byte `json:"seed"`
+	// Password indicates that the Key was created with a password. The
+	// password is part of the private area, so it's also required to use
+	// the imported Key.
+	Password bool `json:"password,omitempty"`
+}
+
+// ImportParent returns the public area of the storage root key (SRK) of the
+// TPM. It's used as the parent when Keys are exported to this TPM using
+// [TPM.ExportKey].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ImportParent(ctx context.Context) (parent []byte, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if parent, err = internalkey.SRKPublic(t.rwc); err != nil {
+		return nil, fmt.Errorf("failed getting import parent: %w", err)
+	}
+
+	return
+}
+
+// ExportKey duplicates the Key identified by `name` to the new parent with
+// public area `parent`, as returned by [TPM.ImportParent] of the target TPM.
+// The Key must have been created with [CreateKeyConfig.Duplicable] set. The
+// Key isn't removed from this TPM.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ExportKey(ctx context.Context, name string, parent []byte) (exported *ExportedKey, err error) {
+	if len(parent) == 0 {
+		return nil, errors.New("parent cannot be empty")
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	key, err := t.store.GetKey(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed getting key %q: %w", name, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+
+	policy, err := unmarshalKeyPolicy(key.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+
+	exported = &ExportedKey{
+		Password: policy != nil && policy.Password,
+	}
+	if exported.Public, exported.Duplicate, exported.Seed, err = internalkey.Duplicate(t.rwc, key.Data, parent); err != nil {
+		return nil, fmt.Errorf("failed exporting key %q: %w", name, err)
+	}
+
+	return
+}
+
+// ImportKey imports a Key exported to this TPM using [TPM.ExportKey], and
+// stores it as a new Key identified by `name`. If no name is provided, a
+// random 10 character name is generated. If a Key with the same name exists,
+// `ErrExists` is returned.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ImportKey(ctx context.Context, name string, exported *ExportedKey) (key *Key, err error) {
+	if exported == nil {
+		return nil, errors.New("exported key cannot be nil")
+	}
+
+	return t.importKey(ctx, name, exported.Password, func(keyName string) ([]byte, error) {
+		return internalkey.Import(t.rwc, keyName, exported.Public, exported.Duplicate, exported.Seed)
+	})
+}
+
+// ImportPrivateKey imports a software RSA or ECDSA private key into the TPM,
+// and stores it as a new Key identified by `name`. If no name is provided, a
+// random 10 character name is generated. If a Key with the same name exists,
+// `ErrExists` is returned.
+//
+// The private key is sent to the TPM unencrypted, so it returns
+// [ErrEncryptedSessionsNotSupported] if encrypted sessions are required.
+// After it's imported, the Key can only be used through the TPM, but it
+// can't be proved that the private key isn't available elsewhere.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ImportPrivateKey(ctx context.Context, name string, privateKey crypto.PrivateKey) (key *Key, err error) {
+	if t.options.encryptedSessions {
+		return nil, fmt.Errorf("failed importing key %q: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
+	return t.importKey(ctx, name, false, func(keyName string) ([]byte, error) {
+		return internalkey.ImportPrivateKey(t.rwc, keyName, privateKey)
+	})
+}
+
+// importKey imports a Key using fn, and adds it to storage.
+func (t *TPM) importKey(ctx context.Context, name string, password bool, fn func(keyName string) ([]byte, error)) (key *Key, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	now := time.Now()
+	if name, err = processName(name); err != nil {
+		return nil, err
+	}
+
+	_, err = t.store.GetKey(name)
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("failed importing key %q: %w", name, ErrExists)
+	case errors.Is(err, storage.ErrNoStorageConfigured):
+		return nil, fmt.Errorf("failed importing key %q: %w", name, err)
+	}
+
+	data, err := fn(prefixKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed importing key %q: %w", name, err)
+	}
+
+	var policyData []byte
+	if password {
+		if policyData, err = json.Marshal(&serializedKeyPolicy{Password: true}); err != nil {
+			return nil, fmt.Errorf("failed marshaling key policy: %w", err)
+		}
+	}
+
+	key = &Key{
+		name:      name,
+		data:      data,
+		createdAt: now,
+		policy:    policyData,
+		tpm:       t,
+	}
+
+	if err := t.store.AddKey(key.toStorage()); err != nil {
+		return nil, fmt.Errorf("failed adding key %q to storage: %w", name, err)
+	}
+
+	if err := t.store.Persist(); err != nil {
+		return nil, fmt.Errorf("failed persisting key %q to storage: %w", name, err)
+	}
+
+	return
+}
@@ -147,12 +147,19 @@ func keyType(p crypto.PublicKey) string {
 // when interaction with the TPM fails. It will loop through
 // the TPM EKs and download the EK certificate if it's available
 // online. The TPM EKs don't change after the first lookup, so
-// the result is cached for future lookups.
+// the result is cached for future lookups. If the endorsement
+// hierarchy has a password, it must be configured using
+// [WithEndorsementPassword].
 func (t *TPM) GetEKs(ctx context.Context) (eks []*EK, err error) {
 	if len(t.eks) > 0 {
 		return t.eks, nil
 	}
 
+	ekPublic, err := t.persistPrimaryKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if err = t.open(ctx); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -160,7 +167,12 @@ func (t *TPM) GetEKs(ctx context.Context) (eks []*EK, err error) {
 
 	aeks, err := t.attestTPM.EKs()
 	if err != nil {
-		return nil, fmt.Errorf("failed getting EKs: %w", err)
+		// without an EK certificate, the EK is recreated using empty
+		// endorsement authorization, so the persisted EK is used instead.
+		if ekPublic == nil {
+			return nil, fmt.Errorf("failed getting EKs: %w", err)
+		}
+		aeks, err = []attest.EK{{Public: ekPublic}}, nil
 	}
 
 	// an arbitrary limit, so that we don't start making a large number of HTTP requests (if needed)
@@ -0,0 +1,249 @@
+package ekverify
+
+import (
+	"bytes"
+	"crypto/x509"
+	"embed"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// embeddedRoots contains the bundled manufacturer roots. Each file is named
+// after the ASCII representation of the TPM manufacturer ID, e.g. GOOG.pem,
+// and contains the PEM encoded roots for that manufacturer, with comments
+// noting where they were obtained and their fingerprints.
+//
+// Only roots that have been obtained from the manufacturer and checked
+// against real EK certificates are bundled. Roots for other manufacturers
+// must be added using [Bundle.LoadDir], [Bundle.AddPEM] or
+// [Bundle.AddCertificates].
+//
+//go:embed roots
+var embeddedRoots embed.FS
+
+// embeddedIntermediates contains the bundled intermediates of the
+// manufacturers in embeddedRoots, so EK certificates can be verified without
+// retrieving them.
+//
+//go:embed intermediates
+var embeddedIntermediates embed.FS
+
+// Bundle is a set of EK roots, indexed by the ASCII representation of the
+// TPM manufacturer ID, e.g. "IFX" for Infineon or "STM" for ST
+// Microelectronics. It's safe for concurrent use, so roots can be updated
+// while a [Verifier] uses the Bundle.
+type Bundle struct {
+	mu            sync.RWMutex
+	roots         map[string][]*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+// NewBundle returns an empty Bundle.
+func NewBundle() *Bundle {
+	return &Bundle{
+		roots: make(map[string][]*x509.Certificate),
+	}
+}
+
+// DefaultBundle returns a new Bundle with the roots and intermediates
+// embedded in this package.
+func DefaultBundle() (*Bundle, error) {
+	sub, err := fs.Sub(embeddedRoots, "roots")
+	if err != nil {
+		return nil, fmt.Errorf("failed reading embedded roots: %w", err)
+	}
+	b := NewBundle()
+	if err := b.LoadFS(sub); err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(embeddedIntermediates, "intermediates")
+	if err != nil {
+		return nil, fmt.Errorf("failed reading embedded intermediates: %w", err)
+	}
+	for _, e := range entries {
+		data, err := fs.ReadFile(embeddedIntermediates, path.Join("intermediates", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %q: %w", e.Name(), err)
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing %q: %w", e.Name(), err)
+		}
+		b.AddIntermediates(certs...)
+	}
+
+	return b, nil
+}
+
+// AddCertificates adds roots for the manufacturer.
+func (b *Bundle) AddCertificates(manufacturer string, certs ...*x509.Certificate) {
+	if len(certs) == 0 {
+		return
+	}
+	id := normalize(manufacturer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, cert := range certs {
+		if !containsCertificate(b.roots[id], cert) {
+			b.roots[id] = append(b.roots[id], cert)
+		}
+	}
+}
+
+// AddIntermediates adds intermediates used to build the chain of EK
+// certificates of any manufacturer. Intermediates are not trusted, EK
+// certificates must still chain to a root of their manufacturer.
+func (b *Bundle) AddIntermediates(certs ...*x509.Certificate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, cert := range certs {
+		if !containsCertificate(b.intermediates, cert) {
+			b.intermediates = append(b.intermediates, cert)
+		}
+	}
+}
+
+// Intermediates returns the intermediates in the Bundle.
+func (b *Bundle) Intermediates() []*x509.Certificate {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*x509.Certificate(nil), b.intermediates...)
+}
+
+// AddPEM adds the PEM encoded roots in data for the manufacturer. Data
+// outside of PEM blocks is ignored.
+func (b *Bundle) AddPEM(manufacturer string, data []byte) error {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return fmt.Errorf("failed parsing roots for %q: %w", manufacturer, err)
+	}
+	b.AddCertificates(manufacturer, certs...)
+	return nil
+}
+
+// LoadFS adds the roots in the files at the top level of fsys. Files must be
+// named after the manufacturer, with a .pem, .crt or .cer extension, and can
+// contain PEM encoded certificates or a single DER encoded certificate.
+// Other files are ignored.
+func (b *Bundle) LoadFS(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("failed reading roots: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		ext := path.Ext(name)
+		if e.IsDir() || !isCertificateFile(ext) {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("failed reading %q: %w", name, err)
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("failed parsing %q: %w", name, err)
+		}
+		b.AddCertificates(strings.TrimSuffix(name, ext), certs...)
+	}
+	return nil
+}
+
+// LoadDir adds the roots in the files in dir, as described in
+// [Bundle.LoadFS].
+func (b *Bundle) LoadDir(dir string) error {
+	return b.LoadFS(os.DirFS(dir))
+}
+
+// Roots returns the roots for the manufacturer.
+func (b *Bundle) Roots(manufacturer string) []*x509.Certificate {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	roots := b.roots[normalize(manufacturer)]
+	return append([]*x509.Certificate(nil), roots...)
+}
+
+// Manufacturers returns the sorted manufacturers with at least one root.
+func (b *Bundle) Manufacturers() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ids := make([]string, 0, len(b.roots))
+	for id := range b.roots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func normalize(manufacturer string) string {
+	return strings.ToUpper(strings.TrimSpace(manufacturer))
+}
+
+func isCertificateFile(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".pem", ".crt", ".cer":
+		return true
+	default:
+		return false
+	}
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificates parses the PEM encoded certificates in data, or a single
+// DER encoded certificate if data doesn't contain PEM blocks.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		if isComment(data) {
+			return nil, nil
+		}
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// isComment reports whether data is empty, or only contains comments, like
+// the embedded bundles without roots.
+func isComment(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return false
+		}
+	}
+	return true
+}
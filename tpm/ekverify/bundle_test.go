@@ -0,0 +1,99 @@
+package ekverify
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+)
+
+func mustPEM(certs ...*x509.Certificate) []byte {
+	var b []byte
+	for _, c := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return b
+}
+
+func TestDefaultBundle(t *testing.T) {
+	b, err := DefaultBundle()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GOOG"}, b.Manufacturers())
+	for _, id := range b.Manufacturers() {
+		assert.NotEmpty(t, b.Roots(id))
+	}
+	for _, c := range b.Intermediates() {
+		assert.True(t, c.IsCA)
+	}
+	assert.Len(t, b.Intermediates(), 2)
+}
+
+func TestBundle(t *testing.T) {
+	ca1, err := minica.New()
+	require.NoError(t, err)
+	ca2, err := minica.New()
+	require.NoError(t, err)
+
+	b := NewBundle()
+	assert.Empty(t, b.Manufacturers())
+	assert.Empty(t, b.Roots("IFX"))
+
+	b.AddCertificates("IFX", ca1.Root)
+	b.AddCertificates(" ifx ", ca1.Root)
+	b.AddCertificates("STM")
+	assert.Equal(t, []string{"IFX"}, b.Manufacturers())
+	assert.Equal(t, []*x509.Certificate{ca1.Root}, b.Roots("IFX"))
+
+	require.NoError(t, b.AddPEM("STM", append([]byte("# comment\n"), mustPEM(ca1.Root, ca2.Root)...)))
+	assert.Equal(t, []string{"IFX", "STM"}, b.Manufacturers())
+	assert.Equal(t, []*x509.Certificate{ca1.Root, ca2.Root}, b.Roots("stm"))
+
+	assert.Empty(t, b.Intermediates())
+	b.AddIntermediates(ca1.Intermediate, ca2.Intermediate, ca1.Intermediate)
+	assert.Equal(t, []*x509.Certificate{ca1.Intermediate, ca2.Intermediate}, b.Intermediates())
+	assert.Equal(t, []string{"IFX", "STM"}, b.Manufacturers())
+
+	assert.Error(t, b.AddPEM("NTC", []byte("-----BEGIN CERTIFICATE-----\nZm9v\n-----END CERTIFICATE-----\n")))
+	assert.Error(t, b.AddPEM("NTC", []byte("garbage")))
+	assert.Equal(t, []string{"IFX", "STM"}, b.Manufacturers())
+}
+
+func TestBundle_LoadFS(t *testing.T) {
+	ca1, err := minica.New()
+	require.NoError(t, err)
+	ca2, err := minica.New()
+	require.NoError(t, err)
+
+	b := NewBundle()
+	require.NoError(t, b.LoadFS(fstest.MapFS{
+		"IFX.pem":        {Data: mustPEM(ca1.Root, ca2.Root)},
+		"NTC.cer":        {Data: ca1.Root.Raw},
+		"STM.crt":        {Data: mustPEM(ca2.Root)},
+		"AMD.pem":        {Data: []byte("# no roots yet\n")},
+		"README.md":      {Data: []byte("not a certificate")},
+		"INTC/INTC.pem":  {Data: mustPEM(ca1.Root)},
+		"INTC/README.md": {Data: []byte("ignored")},
+	}))
+	assert.Equal(t, []string{"IFX", "NTC", "STM"}, b.Manufacturers())
+	assert.Equal(t, []*x509.Certificate{ca1.Root, ca2.Root}, b.Roots("IFX"))
+	assert.Equal(t, []*x509.Certificate{ca1.Root}, b.Roots("NTC"))
+	assert.Equal(t, []*x509.Certificate{ca2.Root}, b.Roots("STM"))
+
+	assert.Error(t, NewBundle().LoadFS(fstest.MapFS{
+		"IFX.cer": {Data: []byte("garbage")},
+	}))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "IFX.pem"), mustPEM(ca1.Root), 0o600))
+	b = NewBundle()
+	require.NoError(t, b.LoadDir(dir))
+	assert.Equal(t, []*x509.Certificate{ca1.Root}, b.Roots("IFX"))
+	assert.Error(t, NewBundle().LoadDir(filepath.Join(dir, "missing")))
+}
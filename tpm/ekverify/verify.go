@@ -0,0 +1,400 @@
+// Package ekverify verifies TPM Endorsement Key (EK) certificates. It checks
+// the certificates conform to the TCG EK Credential Profile, and that they
+// chain to a root of the TPM manufacturer named in the certificate.
+//
+// Only the roots of Google Cloud vTPMs (manufacturer ID "GOOG") are embedded
+// in this package. The roots of discrete and firmware TPMs, e.g. Infineon,
+// STMicroelectronics, Nuvoton, Intel or AMD, are not bundled, and must be
+// obtained from the manufacturer and loaded into a [Bundle] with
+// [Bundle.LoadDir], [Bundle.AddPEM] or [Bundle.AddCertificates].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+package ekverify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.step.sm/crypto/tpm/manufacturer"
+	"go.step.sm/crypto/x509util"
+)
+
+var (
+	oidSubjectAlternativeName     = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidSubjectDirectoryAttributes = asn1.ObjectIdentifier{2, 5, 29, 9}
+	oidTPMSpecification           = asn1.ObjectIdentifier{2, 23, 133, 2, 16}
+	oidExtKeyUsageEKCertificate   = asn1.ObjectIdentifier{2, 23, 133, 8, 1}
+)
+
+const (
+	// defaultMaxFetches is the default maximum number of intermediates
+	// retrieved when verifying an EK certificate.
+	defaultMaxFetches = 4
+
+	// maxCertificateSize is the maximum size of a retrieved certificate.
+	maxCertificateSize = 1 << 16
+)
+
+// Client is the interface of the HTTP client used to retrieve intermediate
+// certificates using the Authority Information Access extension.
+type Client interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Verifier verifies EK certificates.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Verifier struct {
+	bundle        *Bundle
+	client        Client
+	intermediates []*x509.Certificate
+	maxFetches    int
+	now           func() time.Time
+}
+
+// Option is the type of options for the Verifier.
+type Option func(v *Verifier) error
+
+// WithBundle sets the Bundle with the roots used to verify EK certificates.
+// By default, the roots and intermediates embedded in this package are used,
+// which only verify the EK certificates of Google Cloud vTPMs.
+func WithBundle(b *Bundle) Option {
+	return func(v *Verifier) error {
+		if b == nil {
+			return errors.New("bundle cannot be nil")
+		}
+		v.bundle = b
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to retrieve intermediate
+// certificates.
+func WithHTTPClient(c Client) Option {
+	return func(v *Verifier) error {
+		if c == nil {
+			return errors.New("client cannot be nil")
+		}
+		v.client = c
+		return nil
+	}
+}
+
+// WithIntermediates sets intermediates that are used in addition to the
+// intermediates provided when verifying an EK certificate.
+func WithIntermediates(certs ...*x509.Certificate) Option {
+	return func(v *Verifier) error {
+		v.intermediates = append(v.intermediates, certs...)
+		return nil
+	}
+}
+
+// WithMaxFetches sets the maximum number of intermediates retrieved when
+// verifying an EK certificate. Zero disables retrieving intermediates.
+func WithMaxFetches(n int) Option {
+	return func(v *Verifier) error {
+		if n < 0 {
+			return fmt.Errorf("invalid maximum number of fetches %d", n)
+		}
+		v.maxFetches = n
+		return nil
+	}
+}
+
+// WithCurrentTime sets the time at which EK certificates are verified. By
+// default, the current time is used.
+func WithCurrentTime(t time.Time) Option {
+	return func(v *Verifier) error {
+		v.now = func() time.Time { return t }
+		return nil
+	}
+}
+
+// New returns a new Verifier.
+func New(opts ...Option) (*Verifier, error) {
+	v := &Verifier{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		maxFetches: defaultMaxFetches,
+		now:        time.Now,
+	}
+	for _, applyTo := range opts {
+		if err := applyTo(v); err != nil {
+			return nil, err
+		}
+	}
+	if v.bundle == nil {
+		b, err := DefaultBundle()
+		if err != nil {
+			return nil, err
+		}
+		v.bundle = b
+	}
+	return v, nil
+}
+
+// Specification is the TPM specification an EK certificate was issued for.
+type Specification struct {
+	Family   string
+	Level    int
+	Revision int
+}
+
+// Result is the result of a successful EK certificate verification.
+type Result struct {
+	// Manufacturer is the TPM manufacturer ID from the hardware SAN.
+	Manufacturer manufacturer.ID
+	// Model is the TPM model from the hardware SAN.
+	Model string
+	// Version is the TPM firmware version from the hardware SAN.
+	Version string
+	// Specification is the TPM specification from the Subject Directory
+	// Attributes extension. It's nil if the extension is not present.
+	Specification *Specification
+	// Chain is the verified chain, starting with the EK certificate and
+	// ending with the manufacturer root.
+	Chain []*x509.Certificate
+}
+
+// Verify verifies the EK certificate. The certificate must conform to the
+// TCG EK Credential Profile, and chain to a root for the manufacturer in its
+// hardware SAN, using the intermediates provided and configured. Missing
+// intermediates are retrieved using the Authority Information Access
+// extension.
+func (v *Verifier) Verify(ctx context.Context, cert *x509.Certificate, intermediates ...*x509.Certificate) (*Result, error) {
+	if cert == nil {
+		return nil, errors.New("EK certificate cannot be nil")
+	}
+
+	res, err := checkProfile(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	ascii, _ := manufacturer.GetEncodings(res.Manufacturer)
+	roots := v.bundle.Roots(ascii)
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no roots for TPM manufacturer %q (%s); load them into the bundle", ascii, manufacturer.GetNameByASCII(ascii))
+	}
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+	pool := x509.NewCertPool()
+	for _, c := range v.bundle.Intermediates() {
+		pool.AddCert(c)
+	}
+	for _, c := range v.intermediates {
+		pool.AddCert(c)
+	}
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+
+	// the critical SAN with the TPM information is checked by checkProfile
+	c := *cert
+	c.UnhandledCriticalExtensions = nil
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAlternativeName) {
+			c.UnhandledCriticalExtensions = append(c.UnhandledCriticalExtensions, oid)
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: pool,
+		CurrentTime:   v.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	// intermediates are retrieved following the AIA of the last
+	// certificate retrieved, until the chain can be verified.
+	last := cert
+	for fetches := 0; ; fetches++ {
+		chains, err := c.Verify(opts)
+		if err == nil {
+			// the chain starts with the original certificate, not the copy
+			res.Chain = append([]*x509.Certificate{cert}, chains[0][1:]...)
+			return res, nil
+		}
+		var uaErr x509.UnknownAuthorityError
+		if !errors.As(err, &uaErr) {
+			return nil, fmt.Errorf("failed verifying EK certificate: %w", err)
+		}
+		if fetches >= v.maxFetches || len(last.IssuingCertificateURL) == 0 {
+			return nil, fmt.Errorf("failed verifying EK certificate: %w", err)
+		}
+		if last, err = v.fetch(ctx, last.IssuingCertificateURL); err != nil {
+			return nil, err
+		}
+		pool.AddCert(last)
+	}
+}
+
+// fetch retrieves an issuer certificate from the first URL that works.
+func (v *Verifier) fetch(ctx context.Context, urls []string) (*x509.Certificate, error) {
+	var errs []error
+	for _, u := range urls {
+		cert, err := v.download(ctx, u)
+		if err == nil {
+			return cert, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("failed retrieving intermediate: %w", errors.Join(errs...))
+}
+
+func (v *Verifier) download(ctx context.Context, u string) (*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+	r, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving certificate from %q: %w", u, err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http request to %q failed with status %d", u, r.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCertificateSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading response body: %w", err)
+	}
+	certs, err := parseCertificates(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing certificate from %q: %w", u, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found at %q", u)
+	}
+	return certs[0], nil
+}
+
+// checkProfile checks the EK certificate extensions required by the TCG EK
+// Credential Profile, and returns the TPM information they contain.
+func checkProfile(cert *x509.Certificate) (*Result, error) {
+	if cert.BasicConstraintsValid && cert.IsCA {
+		return nil, errors.New("EK certificate cannot be a CA")
+	}
+
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+			return nil, errors.New("EK certificate key usage must include keyEncipherment")
+		}
+	case *ecdsa.PublicKey:
+		if cert.KeyUsage&x509.KeyUsageKeyAgreement == 0 {
+			return nil, errors.New("EK certificate key usage must include keyAgreement")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported EK public key type %T", cert.PublicKey)
+	}
+
+	if !hasEKCertificateUsage(cert) {
+		return nil, errors.New("EK certificate extended key usage must include tcg-kp-EKCertificate")
+	}
+
+	sans, err := x509util.ParseSubjectAlternativeNames(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing EK certificate SANs: %w", err)
+	}
+	details := sans.TPMHardwareDetails
+	if details.Manufacturer == "" || details.Model == "" || details.Version == "" {
+		return nil, errors.New("EK certificate does not have a hardware SAN")
+	}
+	id, err := parseManufacturerID(details.Manufacturer)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := parseSpecification(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Manufacturer:  id,
+		Model:         details.Model,
+		Version:       details.Version,
+		Specification: spec,
+	}, nil
+}
+
+func hasEKCertificateUsage(cert *x509.Certificate) bool {
+	for _, oid := range cert.UnknownExtKeyUsage {
+		if oid.Equal(oidExtKeyUsageEKCertificate) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseManufacturerID parses the TPM manufacturer in the hardware SAN, which
+// is encoded as "id:" followed by the hexadecimal manufacturer ID.
+func parseManufacturerID(s string) (manufacturer.ID, error) {
+	h, ok := strings.CutPrefix(s, "id:")
+	if !ok {
+		return 0, fmt.Errorf("invalid TPM manufacturer %q", s)
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b) != 4 {
+		return 0, fmt.Errorf("invalid TPM manufacturer %q", s)
+	}
+	return manufacturer.ID(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])), nil
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type tpmSpecification struct {
+	Family   string `asn1:"utf8"`
+	Level    int
+	Revision int
+}
+
+// parseSpecification parses the TPM specification in the Subject Directory
+// Attributes extension, if present.
+func parseSpecification(cert *x509.Certificate) (*Specification, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectDirectoryAttributes) {
+			continue
+		}
+		var attrs []attribute
+		if rest, err := asn1.Unmarshal(ext.Value, &attrs); err != nil || len(rest) > 0 {
+			return nil, errors.New("failed parsing EK certificate subject directory attributes")
+		}
+		for _, attr := range attrs {
+			if !attr.Type.Equal(oidTPMSpecification) || len(attr.Values) == 0 {
+				continue
+			}
+			var spec tpmSpecification
+			if rest, err := asn1.Unmarshal(attr.Values[0].FullBytes, &spec); err != nil || len(rest) > 0 {
+				return nil, errors.New("failed parsing EK certificate TPM specification")
+			}
+			return &Specification{
+				Family:   spec.Family,
+				Level:    spec.Level,
+				Revision: spec.Revision,
+			}, nil
+		}
+	}
+	return nil, nil //nolint:nilnil // the specification is optional
+}
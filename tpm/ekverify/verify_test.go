@@ -0,0 +1,313 @@
+package ekverify
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/tpm/manufacturer"
+)
+
+func mustHardwareSAN(t *testing.T, manufacturerID, model, version string) pkix.Extension {
+	t.Helper()
+	name, err := asn1.Marshal(pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 1}, Value: manufacturerID},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 2}, Value: model},
+		{Type: asn1.ObjectIdentifier{2, 23, 133, 2, 3}, Value: version},
+	}}.ToRDNSequence())
+	require.NoError(t, err)
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Tag: 4, Class: asn1.ClassContextSpecific, IsCompound: true, Bytes: name},
+	})
+	require.NoError(t, err)
+	return pkix.Extension{Id: oidSubjectAlternativeName, Critical: true, Value: san}
+}
+
+func mustSpecification(t *testing.T, spec tpmSpecification) pkix.Extension {
+	t.Helper()
+	value, err := asn1.Marshal(spec)
+	require.NoError(t, err)
+	attrs, err := asn1.Marshal([]attribute{
+		{Type: oidTPMSpecification, Values: []asn1.RawValue{{FullBytes: value}}},
+	})
+	require.NoError(t, err)
+	return pkix.Extension{Id: oidSubjectDirectoryAttributes, Value: attrs}
+}
+
+// mustEKCertificate returns an Infineon EK certificate conforming to the TCG
+// EK Credential Profile. The template can be modified with fn.
+func mustEKCertificate(t *testing.T, ca *minica.CA, pub crypto.PublicKey, fn func(*x509.Certificate)) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		PublicKey:             pub,
+		KeyUsage:              x509.KeyUsageKeyEncipherment,
+		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{oidExtKeyUsageEKCertificate},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			mustHardwareSAN(t, "id:49465800", "SLB9670", "id:000F0014"),
+			mustSpecification(t, tpmSpecification{Family: "2.0", Level: 0, Revision: 138}),
+		},
+	}
+	if _, ok := pub.(*ecdsa.PublicKey); ok {
+		template.KeyUsage = x509.KeyUsageKeyAgreement
+	}
+	if fn != nil {
+		fn(template)
+	}
+	cert, err := ca.Sign(template)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestNew(t *testing.T) {
+	v, err := New()
+	require.NoError(t, err)
+	assert.NotNil(t, v.bundle)
+	assert.NotNil(t, v.client)
+	assert.Equal(t, defaultMaxFetches, v.maxFetches)
+
+	bundle := NewBundle()
+	now := time.Now()
+	v, err = New(WithBundle(bundle), WithHTTPClient(http.DefaultClient), WithMaxFetches(0), WithCurrentTime(now))
+	require.NoError(t, err)
+	assert.Same(t, bundle, v.bundle)
+	assert.Equal(t, http.DefaultClient, v.client)
+	assert.Equal(t, 0, v.maxFetches)
+	assert.Equal(t, now, v.now())
+
+	for name, opt := range map[string]Option{
+		"bundle":     WithBundle(nil),
+		"client":     WithHTTPClient(nil),
+		"maxFetches": WithMaxFetches(-1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(opt)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	ctx := context.Background()
+	ekCA, err := minica.New()
+	require.NoError(t, err)
+	otherCA, err := minica.New()
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/intermediate.crt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ekCA.Intermediate.Raw)
+	})
+	mux.HandleFunc("/intermediate.pem", func(w http.ResponseWriter, r *http.Request) {
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ekCA.Intermediate.Raw})
+	})
+	mux.HandleFunc("/garbage.crt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("garbage"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	withAIA := func(urls ...string) func(*x509.Certificate) {
+		return func(c *x509.Certificate) {
+			for _, u := range urls {
+				c.IssuingCertificateURL = append(c.IssuingCertificateURL, srv.URL+u)
+			}
+		}
+	}
+	rsaCert := mustEKCertificate(t, ekCA, rsaKey.Public(), nil)
+	ecCert := mustEKCertificate(t, ekCA, ecKey.Public(), nil)
+	aiaCert := mustEKCertificate(t, ekCA, rsaKey.Public(), withAIA("/intermediate.crt"))
+	aiaPEMCert := mustEKCertificate(t, ekCA, rsaKey.Public(), withAIA("/not-found.crt", "/intermediate.pem"))
+	aiaNotFoundCert := mustEKCertificate(t, ekCA, rsaKey.Public(), withAIA("/not-found.crt"))
+	aiaGarbageCert := mustEKCertificate(t, ekCA, rsaKey.Public(), withAIA("/garbage.crt"))
+	noSpecCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.ExtraExtensions = c.ExtraExtensions[:1]
+	})
+	untrustedCert := mustEKCertificate(t, otherCA, rsaKey.Public(), nil)
+	noRootsCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.ExtraExtensions[0] = mustHardwareSAN(t, "id:4E544300", "NPCT75x", "id:00070002")
+	})
+	caCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.IsCA = true
+	})
+	rsaKeyUsageCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.KeyUsage = x509.KeyUsageDigitalSignature
+	})
+	ecKeyUsageCert := mustEKCertificate(t, ekCA, ecKey.Public(), func(c *x509.Certificate) {
+		c.KeyUsage = x509.KeyUsageKeyEncipherment
+	})
+	noEKUCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.UnknownExtKeyUsage = nil
+	})
+	noSANCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.ExtraExtensions = c.ExtraExtensions[1:]
+	})
+	badManufacturerCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.ExtraExtensions[0] = mustHardwareSAN(t, "IFX", "SLB9670", "id:000F0014")
+	})
+	badSpecCert := mustEKCertificate(t, ekCA, rsaKey.Public(), func(c *x509.Certificate) {
+		c.ExtraExtensions[1].Value = []byte{0x30, 0x01}
+	})
+
+	bundle := NewBundle()
+	bundle.AddCertificates("IFX", ekCA.Root)
+	mustVerifier := func(opts ...Option) *Verifier {
+		v, err := New(append([]Option{WithBundle(bundle)}, opts...)...)
+		require.NoError(t, err)
+		return v
+	}
+	verifier := mustVerifier()
+	withIntermediates := mustVerifier(WithIntermediates(ekCA.Intermediate))
+	withoutFetches := mustVerifier(WithMaxFetches(0))
+	expired := mustVerifier(WithCurrentTime(time.Now().Add(48 * time.Hour)))
+
+	spec := &Specification{Family: "2.0", Level: 0, Revision: 138}
+	tests := []struct {
+		name          string
+		verifier      *Verifier
+		cert          *x509.Certificate
+		intermediates []*x509.Certificate
+		wantSpec      *Specification
+		wantErr       bool
+	}{
+		{"ok/rsa", verifier, rsaCert, []*x509.Certificate{ekCA.Intermediate}, spec, false},
+		{"ok/ecdsa", verifier, ecCert, []*x509.Certificate{ekCA.Intermediate}, spec, false},
+		{"ok/configured-intermediates", withIntermediates, rsaCert, nil, spec, false},
+		{"ok/aia", verifier, aiaCert, nil, spec, false},
+		{"ok/aia-pem", verifier, aiaPEMCert, nil, spec, false},
+		{"ok/no-specification", verifier, noSpecCert, []*x509.Certificate{ekCA.Intermediate}, nil, false},
+		{"fail/nil", verifier, nil, nil, nil, true},
+		{"fail/no-intermediates", verifier, rsaCert, nil, nil, true},
+		{"fail/aia-disabled", withoutFetches, aiaCert, nil, nil, true},
+		{"fail/aia-not-found", verifier, aiaNotFoundCert, nil, nil, true},
+		{"fail/aia-garbage", verifier, aiaGarbageCert, nil, nil, true},
+		{"fail/untrusted", verifier, untrustedCert, []*x509.Certificate{otherCA.Intermediate}, nil, true},
+		{"fail/no-roots", verifier, noRootsCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/expired", expired, rsaCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/ca", verifier, caCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/rsa-key-usage", verifier, rsaKeyUsageCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/ecdsa-key-usage", verifier, ecKeyUsageCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/no-extended-key-usage", verifier, noEKUCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/no-hardware-san", verifier, noSANCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/manufacturer", verifier, badManufacturerCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+		{"fail/specification", verifier, badSpecCert, []*x509.Certificate{ekCA.Intermediate}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verifier.Verify(ctx, tt.cert, tt.intermediates...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, manufacturer.ID(0x49465800), got.Manufacturer)
+			assert.Equal(t, "SLB9670", got.Model)
+			assert.Equal(t, "id:000F0014", got.Version)
+			assert.Equal(t, tt.wantSpec, got.Specification)
+			assert.Equal(t, []*x509.Certificate{tt.cert, ekCA.Intermediate, ekCA.Root}, got.Chain)
+		})
+	}
+}
+
+func TestVerifier_Verify_defaultBundle(t *testing.T) {
+	ctx := context.Background()
+	root, err := os.ReadFile("roots/GOOG.pem")
+	require.NoError(t, err)
+	roots, err := parseCertificates(root)
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	intermediates, err := os.ReadFile("intermediates/GOOG.pem")
+	require.NoError(t, err)
+	inters, err := parseCertificates(intermediates)
+	require.NoError(t, err)
+	require.Len(t, inters, 2)
+
+	// Intermediates are not retrieved, the chain is built using the embedded
+	// ones.
+	v, err := New(WithMaxFetches(0), WithCurrentTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"ok/rsa", "testdata/gce-ek-rsa.pem"},
+		{"ok/ecc", "testdata/gce-ek-ecc.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			require.NoError(t, err)
+			certs, err := parseCertificates(data)
+			require.NoError(t, err)
+			require.Len(t, certs, 1)
+
+			got, err := v.Verify(ctx, certs[0])
+			require.NoError(t, err)
+			assert.Equal(t, manufacturer.ID(0x474F4F47), got.Manufacturer)
+			assert.Equal(t, "vTPM", got.Model)
+			assert.Equal(t, "id:20160511", got.Version)
+			assert.Equal(t, []*x509.Certificate{certs[0], inters[1], roots[0]}, got.Chain)
+		})
+	}
+
+	// The chain must still end at a manufacturer root.
+	b := NewBundle()
+	b.AddIntermediates(inters...)
+	v, err = New(WithBundle(b), WithMaxFetches(0), WithCurrentTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	data, err := os.ReadFile("testdata/gce-ek-rsa.pem")
+	require.NoError(t, err)
+	certs, err := parseCertificates(data)
+	require.NoError(t, err)
+	_, err = v.Verify(ctx, certs[0])
+	assert.Error(t, err)
+}
+
+func Test_parseManufacturerID(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    manufacturer.ID
+		wantErr bool
+	}{
+		{"ok/infineon", "id:49465800", 0x49465800, false},
+		{"ok/stm", "id:53544D20", 0x53544D20, false},
+		{"fail/prefix", "49465800", 0, true},
+		{"fail/hex", "id:IFX", 0, true},
+		{"fail/length", "id:494658", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseManufacturerID(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -15,3 +15,7 @@ var ErrExists = errors.New("already exists")
 // ErrNoStorageConfigured is returned when a TPM operation is
 // performed that requires a storage to have been configured
 var ErrNoStorageConfigured = storage.ErrNoStorageConfigured
+
+// ErrEncryptedSessionsNotSupported is returned when encrypted sessions are
+// required, but the operation can't be performed using encrypted sessions.
+var ErrEncryptedSessionsNotSupported = errors.New("operation not supported with encrypted sessions")
@@ -0,0 +1,122 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/smallstep/go-attestation/attest"
+)
+
+// EventLog is a parsed TCG event log, also known as measurement log. The
+// events in an EventLog are untrusted until they're replayed against PCR
+// values that were verified using a Quote.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type EventLog struct {
+	log *attest.EventLog
+}
+
+// Event is an event in an EventLog that was replayed against the value of
+// the PCR it was measured into.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Event struct {
+	// Index is the index of the PCR the event was measured into.
+	Index int
+	// Type is the type of the event. The type is not covered by the
+	// digest, so it can't be trusted without additional context.
+	Type attest.EventType
+	// Data is the event data.
+	Data []byte
+	// Digest is the digest of the event that was extended into the PCR.
+	Digest []byte
+}
+
+// ParseEventLog parses a TCG event log. Both the TPM 1.2 (SHA1) and the
+// TPM 2.0 crypto agile formats are supported.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func ParseEventLog(b []byte) (*EventLog, error) {
+	if len(b) == 0 {
+		return nil, errors.New("event log is empty")
+	}
+	log, err := attest.ParseEventLog(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing event log: %w", err)
+	}
+	return &EventLog{log: log}, nil
+}
+
+// Hashes returns the hash algorithms of the digests in the EventLog.
+func (l *EventLog) Hashes() []crypto.Hash {
+	hashes := make([]crypto.Hash, 0, len(l.log.Algs))
+	for _, alg := range l.log.Algs {
+		switch alg {
+		case attest.HashSHA1:
+			hashes = append(hashes, crypto.SHA1)
+		case attest.HashSHA256:
+			hashes = append(hashes, crypto.SHA256)
+		}
+	}
+	return hashes
+}
+
+// Replay replays the EventLog against the values of `pcrs`, and returns the
+// events measured into them. An error is returned if replaying the events for
+// a PCR doesn't result in its value. PCRs without events are skipped.
+//
+// Replay doesn't verify the PCR values. They should be verified first using
+// VerifyQuote.
+func (l *EventLog) Replay(pcrs []PCR) ([]Event, error) {
+	if len(pcrs) == 0 {
+		return nil, errors.New("no PCRs provided")
+	}
+
+	events, err := l.log.Verify(toAttestPCRs(pcrs))
+	if err != nil {
+		return nil, fmt.Errorf("failed replaying event log: %w", err)
+	}
+
+	out := make([]Event, 0, len(events))
+	for _, e := range events {
+		out = append(out, Event{
+			Index:  e.Index,
+			Type:   e.Type,
+			Data:   e.Data,
+			Digest: e.Digest,
+		})
+	}
+
+	return out, nil
+}
+
+// MeasurementLog returns the TCG event log of the platform. An
+// error is returned if the event log isn't available.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) MeasurementLog(ctx context.Context) (b []byte, err error) {
+	if err = t.open(ctx); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if b, err = t.attestTPM.MeasurementLog(); err != nil {
+		return nil, fmt.Errorf("failed reading measurement log: %w", err)
+	}
+
+	return
+}
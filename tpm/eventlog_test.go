@@ -0,0 +1,144 @@
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/smallstep/go-attestation/attest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	index int
+	typ   uint32
+	data  []byte
+}
+
+// newTestEventLog returns a crypto agile event log with SHA256 digests of the
+// provided events.
+func newTestEventLog(t *testing.T, events []testEvent) []byte {
+	t.Helper()
+
+	le := binary.LittleEndian
+	w := func(b *bytes.Buffer, v any) {
+		require.NoError(t, binary.Write(b, le, v))
+	}
+
+	// TCG_EfiSpecIDEventStruct
+	specID := new(bytes.Buffer)
+	specID.WriteString("Spec ID Event03\x00")
+	w(specID, uint32(0))              // platform class
+	w(specID, []uint8{0, 2, 0, 2})    // version minor, major, errata, uintn size
+	w(specID, uint32(1))              // number of algorithms
+	w(specID, uint16(tpm2.AlgSHA256)) // algorithm ID
+	w(specID, uint16(sha256.Size))    // digest size
+	w(specID, uint8(0))               // vendor info size
+
+	b := new(bytes.Buffer)
+	w(b, uint32(0))    // PCR index
+	w(b, uint32(0x03)) // EV_NO_ACTION
+	w(b, [20]byte{})   // SHA1 digest
+	w(b, uint32(specID.Len()))
+	b.Write(specID.Bytes())
+
+	for _, e := range events {
+		digest := sha256.Sum256(e.data)
+		w(b, uint32(e.index))
+		w(b, e.typ)
+		w(b, uint32(1)) // number of digests
+		w(b, uint16(tpm2.AlgSHA256))
+		b.Write(digest[:])
+		w(b, uint32(len(e.data)))
+		b.Write(e.data)
+	}
+
+	return b.Bytes()
+}
+
+// replayTestEvents returns the SHA256 PCR values resulting from extending
+// zeroed PCRs with the events.
+func replayTestEvents(events []testEvent) map[int][]byte {
+	pcrs := map[int][]byte{}
+	for _, e := range events {
+		v, ok := pcrs[e.index]
+		if !ok {
+			v = make([]byte, sha256.Size)
+		}
+		digest := sha256.Sum256(e.data)
+		h := sha256.New()
+		h.Write(v)
+		h.Write(digest[:])
+		pcrs[e.index] = h.Sum(nil)
+	}
+	return pcrs
+}
+
+func TestParseEventLog(t *testing.T) {
+	events := []testEvent{
+		{16, 0x0d, []byte("first event")},
+		{16, 0x0d, []byte("second event")},
+		{23, 0x0d, []byte("third event")},
+	}
+	b := newTestEventLog(t, events)
+
+	log, err := ParseEventLog(b)
+	require.NoError(t, err)
+	assert.Equal(t, []crypto.Hash{crypto.SHA256}, log.Hashes())
+
+	_, err = ParseEventLog(nil)
+	assert.EqualError(t, err, "event log is empty")
+
+	_, err = ParseEventLog([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestEventLog_Replay(t *testing.T) {
+	events := []testEvent{
+		{16, 0x0d, []byte("first event")},
+		{16, 0x0d, []byte("second event")},
+		{23, 0x0d, []byte("third event")},
+	}
+	log, err := ParseEventLog(newTestEventLog(t, events))
+	require.NoError(t, err)
+
+	values := replayTestEvents(events)
+	pcr16 := PCR{Index: 16, Digest: values[16], Hash: crypto.SHA256}
+	pcr23 := PCR{Index: 23, Digest: values[23], Hash: crypto.SHA256}
+
+	tests := []struct {
+		name       string
+		pcrs       []PCR
+		wantEvents int
+		wantErr    bool
+	}{
+		{"ok", []PCR{pcr16, pcr23}, 3, false},
+		{"ok/single-pcr", []PCR{pcr16}, 2, false},
+		{"ok/pcr-without-events", []PCR{pcr23, {Index: 7, Digest: make([]byte, 32), Hash: crypto.SHA256}}, 1, false},
+		{"fail/no-pcrs", nil, 0, true},
+		{"fail/wrong-value", []PCR{{Index: 16, Digest: values[23], Hash: crypto.SHA256}}, 0, true},
+		{"fail/wrong-hash", []PCR{{Index: 16, Digest: values[16][:20], Hash: crypto.SHA1}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := log.Replay(tt.pcrs)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			if assert.Len(t, got, tt.wantEvents) {
+				for _, e := range got {
+					assert.Equal(t, attest.EventType(0x0d), e.Type)
+					digest := sha256.Sum256(e.Data)
+					assert.Equal(t, digest[:], e.Digest)
+				}
+			}
+		})
+	}
+}
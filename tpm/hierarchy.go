@@ -0,0 +1,244 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+)
+
+const (
+	// permanentOwnerAuthSet is set in TPMA_PERMANENT if the owner hierarchy
+	// has a password.
+	permanentOwnerAuthSet = 1 << 0
+	// permanentEndorsementAuthSet is set in TPMA_PERMANENT if the
+	// endorsement hierarchy has a password.
+	permanentEndorsementAuthSet = 1 << 1
+	// permanentLockoutAuthSet is set in TPMA_PERMANENT if the lockout
+	// authority has a password.
+	permanentLockoutAuthSet = 1 << 2
+	// permanentInLockout is set in TPMA_PERMANENT if the TPM is in lockout.
+	permanentInLockout = 1 << 9
+)
+
+// Hierarchy identifies a TPM hierarchy, or the lockout authority, whose
+// authorization can be changed.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Hierarchy uint32
+
+const (
+	// HierarchyOwner is the owner, or storage, hierarchy.
+	HierarchyOwner = Hierarchy(tpm2.HandleOwner)
+	// HierarchyEndorsement is the endorsement hierarchy.
+	HierarchyEndorsement = Hierarchy(tpm2.HandleEndorsement)
+	// HierarchyLockout is the lockout authority. It's used to reset and
+	// configure the dictionary attack protections.
+	HierarchyLockout = Hierarchy(tpm2.HandleLockout)
+)
+
+// String returns a text representation of the hierarchy.
+func (h Hierarchy) String() string {
+	switch h {
+	case HierarchyOwner:
+		return "owner"
+	case HierarchyEndorsement:
+		return "endorsement"
+	case HierarchyLockout:
+		return "lockout"
+	default:
+		return fmt.Sprintf("unknown(0x%08x)", uint32(h))
+	}
+}
+
+// DictionaryAttackInfo describes the dictionary attack protection state of
+// the TPM, and which hierarchies have a password set.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type DictionaryAttackInfo struct {
+	// LockoutCounter is the current number of authorization failures.
+	LockoutCounter uint32
+	// MaxAuthFail is the number of authorization failures before the TPM
+	// enters lockout.
+	MaxAuthFail uint32
+	// LockoutInterval is the time after which the lockout counter is
+	// decremented by one.
+	LockoutInterval time.Duration
+	// LockoutRecovery is the time after a failed lockout authorization
+	// before the lockout authority can be used again.
+	LockoutRecovery time.Duration
+	// InLockout indicates that the TPM is in lockout, and keys subject to
+	// dictionary attack protections can't be used.
+	InLockout bool
+	// OwnerAuthSet indicates that the owner hierarchy has a password.
+	OwnerAuthSet bool
+	// EndorsementAuthSet indicates that the endorsement hierarchy has a
+	// password.
+	EndorsementAuthSet bool
+	// LockoutAuthSet indicates that the lockout authority has a password.
+	LockoutAuthSet bool
+}
+
+// GetDictionaryAttackInfo returns the dictionary attack lockout counter and
+// parameters of the TPM.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetDictionaryAttackInfo(ctx context.Context) (info *DictionaryAttackInfo, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	props, err := readTPMProperties(t.rwc, tpm2.TPMAPermanent, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting dictionary attack info: %w", err)
+	}
+	lockoutProps, err := readTPMProperties(t.rwc, tpm2.LockoutCounter, 4)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting dictionary attack info: %w", err)
+	}
+	for tag, value := range lockoutProps {
+		props[tag] = value
+	}
+
+	permanent := props[tpm2.TPMAPermanent]
+	info = &DictionaryAttackInfo{
+		LockoutCounter:     props[tpm2.LockoutCounter],
+		MaxAuthFail:        props[tpm2.MaxAuthFail],
+		LockoutInterval:    time.Duration(props[tpm2.LockoutInterval]) * time.Second,
+		LockoutRecovery:    time.Duration(props[tpm2.LockoutRecovery]) * time.Second,
+		InLockout:          permanent&permanentInLockout != 0,
+		OwnerAuthSet:       permanent&permanentOwnerAuthSet != 0,
+		EndorsementAuthSet: permanent&permanentEndorsementAuthSet != 0,
+		LockoutAuthSet:     permanent&permanentLockoutAuthSet != 0,
+	}
+
+	return
+}
+
+// readTPMProperties reads `count` TPM properties, starting at `first`.
+func readTPMProperties(rw io.ReadWriter, first tpm2.TPMProp, count uint32) (map[tpm2.TPMProp]uint32, error) {
+	vals, _, err := tpm2.GetCapability(rw, tpm2.CapabilityTPMProperties, count, uint32(first))
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[tpm2.TPMProp]uint32, len(vals))
+	for _, v := range vals {
+		prop, ok := v.(tpm2.TaggedProperty)
+		if !ok {
+			return nil, fmt.Errorf("unexpected TPM property type %T", v)
+		}
+		props[prop.Tag] = prop.Value
+	}
+	return props, nil
+}
+
+// ResetLockout resets the dictionary attack lockout counter, taking the TPM
+// out of lockout. It requires the password of the lockout authority. If the
+// lockout password is wrong, the lockout authority can't be used until the
+// lockout recovery time has passed.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ResetLockout(ctx context.Context, lockoutPassword string) (err error) {
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed resetting lockout: %w", ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := tpm2.DictionaryAttackLockReset(t.rwc, passwordAuth(lockoutPassword)); err != nil {
+		return fmt.Errorf("failed resetting lockout: %w", err)
+	}
+
+	return
+}
+
+// ChangeHierarchyAuth changes the password of the hierarchy from
+// `oldPassword` to `newPassword`. Passwords are set by changing them from an
+// empty password, and removed by changing them to an empty password. If the
+// password of the owner or endorsement hierarchy is changed, the password
+// the TPM instance uses for it is updated too.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ChangeHierarchyAuth(ctx context.Context, hierarchy Hierarchy, oldPassword, newPassword string) (err error) {
+	switch hierarchy {
+	case HierarchyOwner, HierarchyEndorsement, HierarchyLockout:
+	default:
+		return fmt.Errorf("invalid hierarchy %s", hierarchy)
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed changing %s hierarchy password: %w", hierarchy, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := tpm2.HierarchyChangeAuth(t.rwc, tpmutil.Handle(hierarchy), passwordAuth(oldPassword), newPassword); err != nil {
+		return fmt.Errorf("failed changing %s hierarchy password: %w", hierarchy, err)
+	}
+
+	switch hierarchy {
+	case HierarchyOwner:
+		t.options.ownerPassword = newPassword
+	case HierarchyEndorsement:
+		t.options.endorsementPassword = newPassword
+	}
+
+	return
+}
+
+// passwordAuth returns the authorization area for a password session.
+func passwordAuth(password string) tpm2.AuthCommand {
+	return tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession, Auth: []byte(password)}
+}
+
+// persistPrimaryKeys makes the SRK and the EK persistent using the owner and
+// endorsement passwords, so that AK and EK operations don't create them
+// using empty hierarchy authorization. It returns the public key of the EK,
+// or nil if no hierarchy password was configured.
+func (t *TPM) persistPrimaryKeys(ctx context.Context) (ek crypto.PublicKey, err error) {
+	if isInternalCall(ctx) || (t.options.ownerPassword == "" && t.options.endorsementPassword == "") {
+		return nil, nil
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := internalkey.PersistPrimaryKeys(t.rwc, t.options.ownerPassword, t.options.endorsementPassword); err != nil {
+		return nil, fmt.Errorf("failed persisting primary keys: %w", err)
+	}
+	if ek, err = internalkey.EKPublic(t.rwc); err != nil {
+		return nil, fmt.Errorf("failed getting EK public key: %w", err)
+	}
+
+	return
+}
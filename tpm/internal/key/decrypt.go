@@ -0,0 +1,152 @@
+package key
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// decryptAttributes are the attributes of keys used for decryption or key
+// agreement. These keys can't be used for signing.
+const decryptAttributes = tpm2.FlagStorageDefault ^ tpm2.FlagRestricted
+
+func decryptTemplateFromConfig(opts *KeyConfig) (tpm2.Public, error) {
+	switch opts.Algorithm {
+	case RSA:
+		if opts.Size < 0 || opts.Size > 65535 { // basic sanity check
+			return tpm2.Public{}, fmt.Errorf("incorrect size parameter")
+		}
+		// The scheme is left empty, so that the scheme can be provided when
+		// decrypting.
+		return tpm2.Public{
+			Type:       tpm2.AlgRSA,
+			NameAlg:    tpm2.AlgSHA256,
+			Attributes: decryptAttributes,
+			RSAParameters: &tpm2.RSAParams{
+				KeyBits: uint16(opts.Size),
+			},
+		}, nil
+	case ECDSA:
+		var (
+			nameAlg tpm2.Algorithm
+			curveID tpm2.EllipticCurve
+			size    int
+		)
+		switch opts.Size {
+		case 256:
+			nameAlg, curveID, size = tpm2.AlgSHA256, tpm2.CurveNISTP256, 32
+		case 384:
+			nameAlg, curveID, size = tpm2.AlgSHA384, tpm2.CurveNISTP384, 48
+		case 521:
+			nameAlg, curveID, size = tpm2.AlgSHA512, tpm2.CurveNISTP521, 66
+		default:
+			return tpm2.Public{}, fmt.Errorf("unsupported key size: %v", opts.Size)
+		}
+		return tpm2.Public{
+			Type:       tpm2.AlgECC,
+			NameAlg:    nameAlg,
+			Attributes: decryptAttributes,
+			ECCParameters: &tpm2.ECCParams{
+				Sign: &tpm2.SigScheme{
+					Alg: tpm2.AlgNull,
+				},
+				CurveID: curveID,
+				Point: tpm2.ECPoint{
+					XRaw: make([]byte, size),
+					YRaw: make([]byte, size),
+				},
+			},
+		}, nil
+	default:
+		return tpm2.Public{}, fmt.Errorf("unsupported algorithm type: %q", opts.Algorithm)
+	}
+}
+
+// Public returns the TPM public area of a serialized key.
+func Public(data []byte) (tpm2.Public, error) {
+	sk, err := deserialize(data)
+	if err != nil {
+		return tpm2.Public{}, err
+	}
+	pub, err := tpm2.DecodePublic(sk.Public)
+	if err != nil {
+		return tpm2.Public{}, fmt.Errorf("failed decoding public area: %w", err)
+	}
+	return pub, nil
+}
+
+// Decrypt loads a serialized RSA key and decrypts the ciphertext using the
+// TPM2_RSA_Decrypt command with the given scheme and label. The key must have
+// been created with the decrypt attribute set. If session is set, the
+// plaintext is encrypted in the response.
+func Decrypt(rwc io.ReadWriteCloser, data []byte, session *Session, scheme *tpm2.AsymScheme, label string, ciphertext []byte) (plaintext []byte, err error) {
+	if session != nil {
+		return session.decrypt(rwc, data, scheme, label, ciphertext)
+	}
+
+	err = withLoadedKey(rwc, data, func(handle tpmutil.Handle) error {
+		if plaintext, err = tpm2.RSADecrypt(rwc, handle, "", ciphertext, scheme, label); err != nil {
+			return fmt.Errorf("RSADecrypt() failed: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// ECDHZGen loads a serialized ECC key and computes the shared point with the
+// given public point using the TPM2_ECDH_ZGen command. The key must have been
+// created with the decrypt attribute set. If session is set, the shared point
+// is encrypted in the response.
+func ECDHZGen(rwc io.ReadWriteCloser, data []byte, session *Session, point tpm2.ECPoint) (z *tpm2.ECPoint, err error) {
+	if session != nil {
+		return session.ecdhZGen(rwc, data, point)
+	}
+
+	err = withLoadedKey(rwc, data, func(handle tpmutil.Handle) error {
+		if z, err = tpm2.ECDHZGen(rwc, handle, "", point); err != nil {
+			return fmt.Errorf("ECDHZGen() failed: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+func deserialize(data []byte) (*serializedKey, error) {
+	var sk serializedKey
+	if err := json.Unmarshal(data, &sk); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling key: %w", err)
+	}
+	if sk.TPMVersion != 2 {
+		return nil, fmt.Errorf("unsupported TPM version %d", sk.TPMVersion)
+	}
+	return &sk, nil
+}
+
+// withLoadedKey loads the serialized key under the SRK, runs fn with the
+// handle of the loaded key, and flushes it afterwards.
+func withLoadedKey(rwc io.ReadWriteCloser, data []byte, fn func(handle tpmutil.Handle) error) error {
+	sk, err := deserialize(data)
+	if err != nil {
+		return err
+	}
+	if sk.Encoding != keyEncodingEncrypted {
+		return errors.New("only keys with encrypted key blobs can be loaded")
+	}
+
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+
+	handle, _, err := tpm2.Load(rwc, srk, "", sk.Public, sk.Blob)
+	if err != nil {
+		return fmt.Errorf("Load() failed: %w", err)
+	}
+	defer tpm2.FlushContext(rwc, handle)
+
+	return fn(handle)
+}
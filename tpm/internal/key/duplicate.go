@@ -0,0 +1,214 @@
+package key
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// cmdDuplicate is the command code of TPM2_Duplicate, not available in
+// github.com/google/go-tpm/legacy/tpm2.
+const cmdDuplicate tpmutil.Command = 0x0000014B
+
+// duplicableAttributes are the attributes cleared from the template of keys
+// that can be duplicated to another TPM.
+const duplicableAttributes = tpm2.FlagFixedTPM | tpm2.FlagFixedParent
+
+// importedAttributes are the attributes cleared from the public area of
+// software keys imported into the TPM.
+const importedAttributes = duplicableAttributes | tpm2.FlagSensitiveDataOrigin
+
+// duplicationPolicyDigest returns the digest of the policy that allows a key
+// to be duplicated: TPM2_PolicyCommandCode(TPM_CC_Duplicate).
+func duplicationPolicyDigest() []byte {
+	cc := binary.BigEndian.AppendUint32(nil, uint32(cmdDuplicate))
+	return policyUpdate(make([]byte, policyDigestSize), tpm2.CmdPolicyCommandCode, cc)
+}
+
+// SRKPublic returns the encoded public area of the SRK. Keys can be
+// duplicated to this TPM using it as their new parent.
+func SRKPublic(rwc io.ReadWriteCloser) ([]byte, error) {
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+	pub, _, _, err := tpm2.ReadPublic(rwc, srk)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+	b, err := pub.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding SRK public area: %w", err)
+	}
+	return b, nil
+}
+
+// Duplicate duplicates a serialized key to the new parent with the encoded
+// public area `parent`, using TPM2_Duplicate. The key must have been
+// created duplicable. It returns the public area of the key, the duplicate
+// private blob, and the seed encrypted to the new parent.
+func Duplicate(rwc io.ReadWriteCloser, data, parent []byte) (public, duplicate, seed []byte, err error) {
+	parentPublic, err := tpm2.DecodePublic(parent)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed decoding parent public area: %w", err)
+	}
+
+	sk, err := deserialize(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = withLoadedKey(rwc, data, func(handle tpmutil.Handle) error {
+		parentHandle, _, err := tpm2.LoadExternal(rwc, parentPublic, tpm2.Private{Type: tpm2.AlgNull}, tpm2.HandleNull)
+		if err != nil {
+			return fmt.Errorf("LoadExternal() failed: %w", err)
+		}
+		defer tpm2.FlushContext(rwc, parentHandle)
+
+		session, err := startPolicySession(rwc)
+		if err != nil {
+			return err
+		}
+		defer tpm2.FlushContext(rwc, session)
+
+		if err := tpm2.PolicyCommandCode(rwc, session, cmdDuplicate); err != nil {
+			return fmt.Errorf("PolicyCommandCode() failed: %w", err)
+		}
+
+		duplicate, seed, err = duplicateObject(rwc, session, handle, parentHandle)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return sk.Public, duplicate, seed, nil
+}
+
+// duplicateObject runs TPM2_Duplicate without an inner wrapper.
+func duplicateObject(rwc io.ReadWriteCloser, session, object, parent tpmutil.Handle) (duplicate, seed []byte, err error) {
+	auth, err := tpmutil.Pack(session, tpmutil.U16Bytes(nil), tpm2.AttrContinueSession, tpmutil.U16Bytes(nil))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed encoding authorization: %w", err)
+	}
+
+	resp, code, err := tpmutil.RunCommand(rwc, tpm2.TagSessions, cmdDuplicate, object, parent, tpmutil.U32Bytes(auth), tpmutil.U16Bytes(nil), tpm2.AlgNull)
+	if err := commandError("Duplicate", code, err); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		paramSize           uint32
+		encryptionKey       tpmutil.U16Bytes
+		duplicated, symSeed tpmutil.U16Bytes
+	)
+	if _, err := tpmutil.Unpack(resp, &paramSize, &encryptionKey, &duplicated, &symSeed); err != nil {
+		return nil, nil, fmt.Errorf("failed decoding duplicate: %w", err)
+	}
+
+	return duplicated, symSeed, nil
+}
+
+// Import imports a key duplicated to this TPM under the SRK, using
+// TPM2_Import, and returns the serialized key.
+func Import(rwc io.ReadWriteCloser, keyName string, public, duplicate, seed []byte) ([]byte, error) {
+	if len(seed) == 0 {
+		return nil, errors.New("duplicated key seed cannot be empty")
+	}
+	return importKey(rwc, keyName, public, duplicate, seed)
+}
+
+// ImportPrivateKey imports a software RSA or ECDSA private key under the
+// SRK, using TPM2_Import, and returns the serialized key. The private key is
+// sent to the TPM unencrypted.
+func ImportPrivateKey(rwc io.ReadWriteCloser, keyName string, key crypto.PrivateKey) ([]byte, error) {
+	var (
+		tmpl      tpm2.Public
+		sensitive tpm2.Private
+		err       error
+	)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("multi-prime RSA keys are not supported")
+		}
+		if tmpl, err = templateFromConfig(&KeyConfig{Algorithm: RSA, Size: k.N.BitLen()}); err != nil {
+			return nil, err
+		}
+		tmpl.RSAParameters = &tpm2.RSAParams{
+			KeyBits:    uint16(k.N.BitLen()),
+			ModulusRaw: k.N.Bytes(),
+		}
+		if k.E != 65537 {
+			tmpl.RSAParameters.ExponentRaw = uint32(k.E)
+		}
+		sensitive = tpm2.Private{
+			Type:      tpm2.AlgRSA,
+			Sensitive: k.Primes[0].Bytes(),
+		}
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if tmpl, err = templateFromConfig(&KeyConfig{Algorithm: ECDSA, Size: k.Curve.Params().BitSize}); err != nil {
+			return nil, err
+		}
+		params := *tmpl.ECCParameters
+		params.Point = tpm2.ECPoint{
+			XRaw: k.X.FillBytes(make([]byte, size)),
+			YRaw: k.Y.FillBytes(make([]byte, size)),
+		}
+		tmpl.ECCParameters = &params
+		sensitive = tpm2.Private{
+			Type:      tpm2.AlgECC,
+			Sensitive: k.D.FillBytes(make([]byte, size)),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	tmpl.Attributes &^= importedAttributes
+
+	public, err := tmpl.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding public area: %w", err)
+	}
+	private, err := sensitive.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding sensitive area: %w", err)
+	}
+	// Without inner and outer wrappers, the duplicate is the TPM2B_SENSITIVE.
+	duplicate, err := tpmutil.Pack(tpmutil.U16Bytes(private))
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding sensitive area: %w", err)
+	}
+
+	return importKey(rwc, keyName, public, duplicate, nil)
+}
+
+func importKey(rwc io.ReadWriteCloser, keyName string, public, duplicate, seed []byte) ([]byte, error) {
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+
+	auth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}
+	blob, err := tpm2.Import(rwc, srk, auth, public, duplicate, seed, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Import() failed: %w", err)
+	}
+
+	out := serializedKey{
+		Encoding:   keyEncodingEncrypted,
+		TPMVersion: uint8(2), // hardcoded to not import github.com/google/go-attestation/attest
+		Name:       keyName,
+		Public:     public,
+		Blob:       blob,
+	}
+
+	return out.Serialize()
+}
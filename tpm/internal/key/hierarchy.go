@@ -0,0 +1,77 @@
+package key
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// PersistPrimaryKeys makes the SRK and the EK persistent at the handles AKs,
+// Keys and credential activation look them up at, if they aren't persisted
+// yet. The primary keys are created using the owner and endorsement
+// passwords, so that they don't have to be recreated using empty hierarchy
+// authorization afterwards.
+func PersistPrimaryKeys(rwc io.ReadWriteCloser, ownerPassword, endorsementPassword string) error {
+	if _, _, err := getPrimaryKeyHandleWithAuth(rwc, commonSrkEquivalentHandle, ownerPassword, endorsementPassword); err != nil {
+		return fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+	if _, _, err := getPrimaryKeyHandleWithAuth(rwc, commonEkEquivalentHandle, ownerPassword, endorsementPassword); err != nil {
+		return fmt.Errorf("failed to get EK handle: %w", err)
+	}
+	return nil
+}
+
+// EKPublic returns the public key of the persistent EK.
+func EKPublic(rwc io.ReadWriteCloser) (crypto.PublicKey, error) {
+	pub, _, _, err := tpm2.ReadPublic(rwc, commonEkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+	return pub.Key()
+}
+
+// ActivateCredential decrypts the credential using the serialized AK and the
+// persistent EK. The use of the EK is authorized using the endorsement
+// password. The credential and secret are size prefixed, as generated by
+// go-attestation.
+func ActivateCredential(rwc io.ReadWriteCloser, data, credential, secret []byte, endorsementPassword string) ([]byte, error) {
+	if len(credential) < 2 {
+		return nil, errors.New("malformed credential blob")
+	}
+	if len(secret) < 2 {
+		return nil, errors.New("malformed encrypted secret")
+	}
+
+	ek, _, err := getPrimaryKeyHandle(rwc, commonEkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get EK handle: %w", err)
+	}
+
+	var result []byte
+	err = withLoadedKey(rwc, data, func(ak tpmutil.Handle) error {
+		session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+		if err != nil {
+			return fmt.Errorf("StartAuthSession() failed: %w", err)
+		}
+		defer tpm2.FlushContext(rwc, session)
+
+		auth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession, Auth: []byte(endorsementPassword)}
+		if _, _, err := tpm2.PolicySecret(rwc, tpm2.HandleEndorsement, auth, session, nil, nil, nil, 0); err != nil {
+			return fmt.Errorf("PolicySecret() failed: %w", err)
+		}
+
+		if result, err = tpm2.ActivateCredentialUsingAuth(rwc, []tpm2.AuthCommand{
+			{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession},
+			{Session: session, Attributes: tpm2.AttrContinueSession},
+		}, ak, ek, credential[2:], secret[2:]); err != nil {
+			return fmt.Errorf("ActivateCredential() failed: %w", err)
+		}
+		return nil
+	})
+
+	return result, err
+}
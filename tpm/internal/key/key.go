@@ -106,6 +106,19 @@ type CreateConfig struct {
 	// Size is used to specify the bit size of the key or elliptic curve. For
 	// example, '256' is used to specify curve P-256.
 	Size int
+	// Decrypt indicates that the key is created for decryption (RSA) or
+	// key agreement (ECDH) instead of signing.
+	Decrypt bool
+	// Password is the authorization value of the key.
+	Password string
+	// Policy is the authorization policy of the key. If set, the key can
+	// only be used in a policy session that satisfies the policy.
+	Policy *PolicyConfig
+	// Duplicable indicates that the key can be duplicated to another TPM
+	// using TPM2_Duplicate. It can't be combined with a Policy.
+	Duplicable bool
+	// Session is used to create the key using an encrypted session, if set.
+	Session *Session
 }
 
 func (c *CreateConfig) Validate() error {
@@ -116,6 +129,14 @@ func (c *CreateConfig) Validate() error {
 		}
 	case "ECDSA":
 		break
+	case "HMAC":
+		if c.Size != 0 && c.Size != 256 && c.Size != 384 && c.Size != 512 {
+			return fmt.Errorf("%d bits HMAC keys are not supported; supported sizes are 256, 384 and 512", c.Size)
+		}
+	case "AES":
+		if c.Size != 0 && c.Size != 128 && c.Size != 256 {
+			return fmt.Errorf("%d bits AES keys are not supported; supported sizes are 128 and 256", c.Size)
+		}
 	default:
 		return fmt.Errorf("unsupported algorithm %q", c.Algorithm)
 	}
@@ -124,12 +145,12 @@ func (c *CreateConfig) Validate() error {
 
 var tpmEkTemplate *tpm2.Public
 
-func ekTemplate(rwc io.ReadWriteCloser) (tpm2.Public, error) {
+func ekTemplate(rwc io.ReadWriteCloser, ownerPassword string) (tpm2.Public, error) {
 	if tpmEkTemplate != nil {
 		return *tpmEkTemplate, nil
 	}
 
-	nonce, err := tpm2.NVReadEx(rwc, nvramEkNonceIndex, tpm2.HandleOwner, "", 0)
+	nonce, err := tpm2.NVReadEx(rwc, nvramEkNonceIndex, tpm2.HandleOwner, ownerPassword, 0)
 	if err != nil {
 		tpmEkTemplate = &defaultEKTemplate // No nonce, use the default template
 	} else {
@@ -143,6 +164,12 @@ func ekTemplate(rwc io.ReadWriteCloser) (tpm2.Public, error) {
 
 // Return value: handle, whether we generated a new one, error
 func getPrimaryKeyHandle(rwc io.ReadWriteCloser, pHnd tpmutil.Handle) (tpmutil.Handle, bool, error) {
+	return getPrimaryKeyHandleWithAuth(rwc, pHnd, "", "")
+}
+
+// getPrimaryKeyHandleWithAuth is like getPrimaryKeyHandle, but creates and
+// persists the primary key using the owner and endorsement passwords.
+func getPrimaryKeyHandleWithAuth(rwc io.ReadWriteCloser, pHnd tpmutil.Handle, ownerPassword, endorsementPassword string) (tpmutil.Handle, bool, error) {
 	_, _, _, err := tpm2.ReadPublic(rwc, pHnd)
 	if err == nil {
 		// Found the persistent handle, assume it's the key we want.
@@ -153,20 +180,20 @@ func getPrimaryKeyHandle(rwc io.ReadWriteCloser, pHnd tpmutil.Handle) (tpmutil.H
 	var keyHnd tpmutil.Handle
 	switch pHnd {
 	case commonSrkEquivalentHandle:
-		keyHnd, _, err = tpm2.CreatePrimary(rwc, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", defaultSRKTemplate)
+		keyHnd, _, err = tpm2.CreatePrimary(rwc, tpm2.HandleOwner, tpm2.PCRSelection{}, ownerPassword, "", defaultSRKTemplate)
 	case commonEkEquivalentHandle:
 		var tmpl tpm2.Public
-		if tmpl, err = ekTemplate(rwc); err != nil {
+		if tmpl, err = ekTemplate(rwc, ownerPassword); err != nil {
 			return 0, false, fmt.Errorf("ek template: %v", err)
 		}
-		keyHnd, _, err = tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", tmpl)
+		keyHnd, _, err = tpm2.CreatePrimary(rwc, tpm2.HandleEndorsement, tpm2.PCRSelection{}, endorsementPassword, "", tmpl)
 	}
 	if err != nil {
 		return 0, false, fmt.Errorf("ReadPublic failed (%v), and then CreatePrimary failed: %v", rerr, err)
 	}
 	defer tpm2.FlushContext(rwc, keyHnd)
 
-	err = tpm2.EvictControl(rwc, "", tpm2.HandleOwner, keyHnd, pHnd)
+	err = tpm2.EvictControl(rwc, ownerPassword, tpm2.HandleOwner, keyHnd, pHnd)
 	if err != nil {
 		return 0, false, fmt.Errorf("EvictControl failed: %v", err)
 	}
@@ -4,6 +4,7 @@
 package key
 
 import (
+	"errors"
 	"fmt"
 	"io"
 
@@ -16,12 +17,49 @@ func create(rwc io.ReadWriteCloser, keyName string, config CreateConfig) ([]byte
 		return nil, fmt.Errorf("failed to get SRK handle: %w", err)
 	}
 
-	tmpl, err := templateFromConfig(&KeyConfig{Algorithm: Algorithm(config.Algorithm), Size: config.Size})
+	keyConfig := &KeyConfig{Algorithm: Algorithm(config.Algorithm), Size: config.Size}
+	templateFn := templateFromConfig
+	switch {
+	case isSymmetric(keyConfig.Algorithm):
+		if config.Decrypt || config.Duplicable || config.Policy != nil {
+			return nil, errors.New("HMAC and AES keys can't be decryption keys, duplicable or have a policy")
+		}
+		templateFn = symmetricTemplateFromConfig
+	case config.Decrypt:
+		templateFn = decryptTemplateFromConfig
+	}
+
+	tmpl, err := templateFn(keyConfig)
 	if err != nil {
 		return nil, fmt.Errorf("incorrect key options: %w", err)
 	}
 
-	blob, pub, creationData, _, _, err := tpm2.CreateKey(rwc, srk, tpm2.PCRSelection{}, "", "", tmpl)
+	switch {
+	case config.Duplicable && config.Policy != nil:
+		return nil, errors.New("duplicable keys can't have a policy")
+	case config.Duplicable:
+		// Duplication requires a policy session, so the policy of the key
+		// only allows TPM2_Duplicate. The key can still be used with its
+		// password.
+		tmpl.NameAlg = tpm2.AlgSHA256
+		tmpl.Attributes &^= duplicableAttributes
+		tmpl.AuthPolicy = duplicationPolicyDigest()
+	case config.Policy != nil:
+		// Policies are computed using SHA256, so the name algorithm of
+		// the key has to match.
+		tmpl.NameAlg = tpm2.AlgSHA256
+		tmpl.Attributes &^= tpm2.FlagUserWithAuth
+		if tmpl.AuthPolicy, err = keyPolicyDigest(config.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	var blob, pub, creationData []byte
+	if config.Session != nil {
+		pub, blob, creationData, err = config.Session.create(rwc, tmpl, config.Password, nil)
+	} else {
+		blob, pub, creationData, _, _, err = tpm2.CreateKey(rwc, srk, tpm2.PCRSelection{}, "", config.Password, tmpl)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("CreateKey() failed: %w", err)
 	}
@@ -4,11 +4,28 @@
 package key
 
 import (
+	"errors"
 	"fmt"
 	"io"
 )
 
 func create(_ io.ReadWriteCloser, keyName string, config CreateConfig) ([]byte, error) {
+	if config.Decrypt {
+		return nil, errors.New("creating decryption keys is not supported on Windows")
+	}
+	if isSymmetric(Algorithm(config.Algorithm)) {
+		return nil, errors.New("creating HMAC and AES keys is not supported on Windows")
+	}
+	if config.Password != "" || config.Policy != nil {
+		return nil, errors.New("creating keys with a password or policy is not supported on Windows")
+	}
+	if config.Duplicable {
+		return nil, errors.New("creating duplicable keys is not supported on Windows")
+	}
+	if config.Session != nil {
+		return nil, errors.New("creating keys using encrypted sessions is not supported on Windows")
+	}
+
 	pcp, err := openPCP()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open PCP: %w", err)
@@ -0,0 +1,81 @@
+package key
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// Persist loads the serialized key under the SRK and makes it persistent at
+// the persistent handle `handle`, using TPM2_EvictControl. The owner
+// password is required if the owner hierarchy has a password.
+func Persist(rwc io.ReadWriteCloser, data []byte, handle tpmutil.Handle, ownerPassword string) error {
+	return withLoadedKey(rwc, data, func(loaded tpmutil.Handle) error {
+		if err := tpm2.EvictControl(rwc, ownerPassword, tpm2.HandleOwner, loaded, handle); err != nil {
+			return fmt.Errorf("EvictControl() failed: %w", err)
+		}
+		return nil
+	})
+}
+
+// PersistSRK creates the SRK and makes it persistent at the persistent handle
+// `handle`, using TPM2_EvictControl. The SRK is created using the same
+// template keys are created under, so keys can be loaded using the handle as
+// their parent.
+func PersistSRK(rwc io.ReadWriteCloser, handle tpmutil.Handle, ownerPassword string) error {
+	srk, _, err := tpm2.CreatePrimary(rwc, tpm2.HandleOwner, tpm2.PCRSelection{}, ownerPassword, "", defaultSRKTemplate)
+	if err != nil {
+		return fmt.Errorf("CreatePrimary() failed: %w", err)
+	}
+	defer tpm2.FlushContext(rwc, srk)
+
+	if err := tpm2.EvictControl(rwc, ownerPassword, tpm2.HandleOwner, srk, handle); err != nil {
+		return fmt.Errorf("EvictControl() failed: %w", err)
+	}
+	return nil
+}
+
+// PersistedAt returns whether the serialized key is the object persisted at
+// the persistent handle `handle`. It returns false if no object is persisted
+// at the handle.
+func PersistedAt(rwc io.ReadWriteCloser, data []byte, handle tpmutil.Handle) (bool, error) {
+	pub, err := Public(data)
+	if err != nil {
+		return false, err
+	}
+	name, err := publicName(pub)
+	if err != nil {
+		return false, err
+	}
+
+	_, persistedName, _, err := tpm2.ReadPublic(rwc, handle)
+	if err != nil {
+		var herr tpm2.HandleError
+		if errors.As(err, &herr) && herr.Code == tpm2.RCHandle {
+			return false, nil
+		}
+		return false, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+
+	return bytes.Equal(name, persistedName), nil
+}
+
+// withKeyHandle runs fn with the persistent handle of the key if `handle` is
+// set and the key is persisted at it. Otherwise, the key is loaded from the
+// serialized key, and flushed afterwards.
+func withKeyHandle(rwc io.ReadWriteCloser, data []byte, handle tpmutil.Handle, fn func(handle tpmutil.Handle) error) error {
+	if handle != 0 {
+		ok, err := PersistedAt(rwc, data, handle)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fn(handle)
+		}
+	}
+	return withLoadedKey(rwc, data, fn)
+}
@@ -0,0 +1,308 @@
+package key
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+const (
+	// Command codes of the policy commands not available in
+	// github.com/google/go-tpm/legacy/tpm2.
+	cmdPolicyAuthorize     tpmutil.Command = 0x0000016A
+	cmdPolicyAuthValue     tpmutil.Command = 0x0000016B
+	cmdVerifySignature     tpmutil.Command = 0x00000177
+	sizeOfPCRSelect                        = 3
+	policyDigestSize                       = sha256.Size
+	policySessionNonceSize                 = 16
+)
+
+// PolicyConfig describes the authorization policy of a key. Policy digests
+// are always computed using SHA256.
+type PolicyConfig struct {
+	// PCRs is the selection of PCRs the key is bound to.
+	PCRs tpm2.PCRSelection
+	// PCRDigest is the digest of the values the PCRs must have for the key
+	// to be used.
+	PCRDigest []byte
+	// Password indicates that the key password is required in the policy
+	// session.
+	Password bool
+	// Authority is the public area of the key that approves the policies
+	// that can be used with the key. If set, PCRs and Password are ignored,
+	// and the key can only be used with an ApprovedPolicy signed by the
+	// authority.
+	Authority *tpm2.Public
+	// PolicyRef is an optional value that qualifies the policies approved by
+	// the authority.
+	PolicyRef []byte
+}
+
+// ApprovedPolicy is a policy approved by the authority of a key with an
+// authorized policy.
+type ApprovedPolicy struct {
+	// PCRs is the selection of PCRs of the approved policy.
+	PCRs tpm2.PCRSelection
+	// PCRDigest is the digest of the expected values of the PCRs.
+	PCRDigest []byte
+	// Password indicates that the key password is required.
+	Password bool
+	// Signature is the signature of the authority over the approved policy
+	// digest and the policy reference.
+	Signature *tpm2.Signature
+}
+
+// PolicyDigest computes the digest of a policy that requires the PCRs
+// in `sel` to have values with digest `pcrDigest`, and the key password if
+// `password` is set. The PCR digest is the SHA256 digest of the
+// concatenation of the expected PCR values, in order of their index.
+func PolicyDigest(sel tpm2.PCRSelection, pcrDigest []byte, password bool) []byte {
+	digest := make([]byte, policyDigestSize)
+	if len(sel.PCRs) > 0 {
+		digest = policyUpdate(digest, tpm2.CmdPolicyPCR, encodePCRSelection(sel), pcrDigest)
+	}
+	if password {
+		digest = policyUpdate(digest, cmdPolicyAuthValue)
+	}
+	return digest
+}
+
+// AuthorizedPolicyDigest computes the digest of a TPM2_PolicyAuthorize policy
+// for the authority with public area `authority` and the given policy
+// reference.
+func AuthorizedPolicyDigest(authority tpm2.Public, policyRef []byte) ([]byte, error) {
+	name, err := publicName(authority)
+	if err != nil {
+		return nil, err
+	}
+	digest := policyUpdate(make([]byte, policyDigestSize), cmdPolicyAuthorize, name)
+	h := sha256.New()
+	h.Write(digest)
+	h.Write(policyRef)
+	return h.Sum(nil), nil
+}
+
+// ApprovalDigest returns the digest the authority signs to approve a policy
+// with digest `approvedPolicy` and the given policy reference.
+func ApprovalDigest(approvedPolicy, policyRef []byte) []byte {
+	h := sha256.New()
+	h.Write(approvedPolicy)
+	h.Write(policyRef)
+	return h.Sum(nil)
+}
+
+// Sign loads a serialized key and signs the digest with it. If `handle` is
+// set, the key persisted at it is used instead of loading the key. The
+// password is the authorization value of the key. If the key was created
+// with a policy, the policy is satisfied in a policy session before signing.
+// Keys with an authorized policy require an approved policy.
+func Sign(rwc io.ReadWriteCloser, data []byte, handle tpmutil.Handle, password string, policy *PolicyConfig, approved *ApprovedPolicy, digest []byte, scheme *tpm2.SigScheme) (sig *tpm2.Signature, err error) {
+	err = withKeyHandle(rwc, data, handle, func(handle tpmutil.Handle) error {
+		if policy == nil {
+			if sig, err = tpm2.Sign(rwc, handle, password, digest, nil, scheme); err != nil {
+				return fmt.Errorf("Sign() failed: %w", err)
+			}
+			return nil
+		}
+
+		session, err := keyPolicySession(rwc, policy, approved)
+		if err != nil {
+			return err
+		}
+		defer tpm2.FlushContext(rwc, session)
+
+		if sig, err = tpm2.SignWithSession(rwc, session, handle, password, digest, nil, scheme); err != nil {
+			return fmt.Errorf("SignWithSession() failed: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// keyPolicyDigest returns the policy digest for the configuration.
+func keyPolicyDigest(policy *PolicyConfig) ([]byte, error) {
+	if policy.Authority != nil {
+		return AuthorizedPolicyDigest(*policy.Authority, policy.PolicyRef)
+	}
+	if len(policy.PCRs.PCRs) > 0 && len(policy.PCRDigest) == 0 {
+		return nil, errors.New("PCR digest is required")
+	}
+	return PolicyDigest(policy.PCRs, policy.PCRDigest, policy.Password), nil
+}
+
+// PCRDigest returns the SHA256 digest of the current values of the PCRs in
+// the selection, as used by TPM2_PolicyPCR.
+func PCRDigest(rwc io.ReadWriteCloser, sel tpm2.PCRSelection) ([]byte, error) {
+	pcrs := slices.Clone(sel.PCRs)
+	slices.Sort(pcrs)
+	h := sha256.New()
+	for _, pcr := range pcrs {
+		v, err := tpm2.ReadPCR(rwc, pcr, sel.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("ReadPCR() failed: %w", err)
+		}
+		h.Write(v)
+	}
+	return h.Sum(nil), nil
+}
+
+// keyPolicySession starts a policy session and satisfies the policy of a key
+// in it.
+func keyPolicySession(rwc io.ReadWriteCloser, policy *PolicyConfig, approved *ApprovedPolicy) (tpmutil.Handle, error) {
+	if policy.Authority != nil && approved == nil {
+		return 0, errors.New("key requires a policy approved by its authority")
+	}
+
+	session, err := startPolicySession(rwc)
+	if err != nil {
+		return 0, err
+	}
+
+	if policy.Authority == nil {
+		err = runPolicy(rwc, session, policy.PCRs, policy.PCRDigest, policy.Password)
+	} else {
+		err = runApprovedPolicy(rwc, session, policy, approved)
+	}
+	if err != nil {
+		tpm2.FlushContext(rwc, session)
+		return 0, err
+	}
+
+	return session, nil
+}
+
+// runApprovedPolicy runs the commands of the approved policy, and then
+// replaces the policy digest with the authorized policy digest using
+// TPM2_PolicyAuthorize.
+func runApprovedPolicy(rwc io.ReadWriteCloser, session tpmutil.Handle, policy *PolicyConfig, approved *ApprovedPolicy) error {
+	if approved.Signature == nil {
+		return errors.New("approved policy is not signed")
+	}
+	if err := runPolicy(rwc, session, approved.PCRs, approved.PCRDigest, approved.Password); err != nil {
+		return err
+	}
+
+	approvedDigest, err := tpm2.PolicyGetDigest(rwc, session)
+	if err != nil {
+		return fmt.Errorf("PolicyGetDigest() failed: %w", err)
+	}
+
+	authority, name, err := tpm2.LoadExternal(rwc, *policy.Authority, tpm2.Private{Type: tpm2.AlgNull}, tpm2.HandleOwner)
+	if err != nil {
+		return fmt.Errorf("LoadExternal() failed: %w", err)
+	}
+	defer tpm2.FlushContext(rwc, authority)
+
+	ticket, err := verifySignature(rwc, authority, ApprovalDigest(approvedDigest, policy.PolicyRef), approved.Signature)
+	if err != nil {
+		return err
+	}
+
+	return policyAuthorize(rwc, session, approvedDigest, policy.PolicyRef, name, ticket)
+}
+
+func startPolicySession(rwc io.ReadWriteCloser) (tpmutil.Handle, error) {
+	session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, policySessionNonceSize), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, fmt.Errorf("StartAuthSession() failed: %w", err)
+	}
+	return session, nil
+}
+
+// runPolicy runs TPM2_PolicyPCR if PCRs are selected, and TPM2_PolicyPassword
+// if password is set.
+func runPolicy(rwc io.ReadWriteCloser, session tpmutil.Handle, pcrs tpm2.PCRSelection, pcrDigest []byte, password bool) error {
+	if len(pcrs.PCRs) > 0 {
+		if err := tpm2.PolicyPCR(rwc, session, pcrDigest, pcrs); err != nil {
+			return fmt.Errorf("PolicyPCR() failed: %w", err)
+		}
+	}
+	if password {
+		if err := tpm2.PolicyPassword(rwc, session); err != nil {
+			return fmt.Errorf("PolicyPassword() failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifySignature runs TPM2_VerifySignature and returns the verification
+// ticket.
+func verifySignature(rwc io.ReadWriteCloser, key tpmutil.Handle, digest []byte, sig *tpm2.Signature) (*tpm2.Ticket, error) {
+	encodedSig, err := sig.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding signature: %w", err)
+	}
+	resp, code, err := tpmutil.RunCommand(rwc, tpm2.TagNoSessions, cmdVerifySignature, key, tpmutil.U16Bytes(digest), tpmutil.RawBytes(encodedSig))
+	if err := commandError("VerifySignature", code, err); err != nil {
+		return nil, err
+	}
+	var ticket tpm2.Ticket
+	if _, err := tpmutil.Unpack(resp, &ticket); err != nil {
+		return nil, fmt.Errorf("failed decoding verification ticket: %w", err)
+	}
+	return &ticket, nil
+}
+
+// policyAuthorize runs TPM2_PolicyAuthorize.
+func policyAuthorize(rwc io.ReadWriteCloser, session tpmutil.Handle, approvedPolicy, policyRef, name []byte, ticket *tpm2.Ticket) error {
+	_, code, err := tpmutil.RunCommand(rwc, tpm2.TagNoSessions, cmdPolicyAuthorize, session, tpmutil.U16Bytes(approvedPolicy), tpmutil.U16Bytes(policyRef), tpmutil.U16Bytes(name), ticket)
+	return commandError("PolicyAuthorize", code, err)
+}
+
+func commandError(cmd string, code tpmutil.ResponseCode, err error) error {
+	switch {
+	case err != nil:
+		return fmt.Errorf("%s() failed: %w", cmd, err)
+	case code != tpmutil.RCSuccess:
+		return fmt.Errorf("%s() failed: response code 0x%x", cmd, uint32(code))
+	default:
+		return nil
+	}
+}
+
+// policyUpdate extends the policy digest with a command code and its
+// parameters.
+func policyUpdate(digest []byte, cc tpmutil.Command, params ...[]byte) []byte {
+	h := sha256.New()
+	h.Write(digest)
+	_ = binary.Write(h, binary.BigEndian, uint32(cc))
+	for _, p := range params {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// encodePCRSelection encodes a PCR selection as a TPML_PCR_SELECTION.
+func encodePCRSelection(sel tpm2.PCRSelection) []byte {
+	bitmap := make([]byte, sizeOfPCRSelect)
+	for _, pcr := range sel.PCRs {
+		if pcr >= 0 && pcr < sizeOfPCRSelect*8 {
+			bitmap[pcr/8] |= 1 << (pcr % 8)
+		}
+	}
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.BigEndian, uint32(1))
+	_ = binary.Write(b, binary.BigEndian, uint16(sel.Hash))
+	b.WriteByte(sizeOfPCRSelect)
+	b.Write(bitmap)
+	return b.Bytes()
+}
+
+// publicName returns the name of the public area.
+func publicName(pub tpm2.Public) ([]byte, error) {
+	name, err := pub.Name()
+	if err != nil {
+		return nil, fmt.Errorf("failed computing name: %w", err)
+	}
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.BigEndian, uint16(name.Digest.Alg))
+	b.Write(name.Digest.Value)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package key
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// MaxSealedDataSize is the maximum size of the data that can be sealed in a
+// data object. It's the value of MAX_SYM_DATA in the TPM 2.0 specification.
+const MaxSealedDataSize = 128
+
+// SealConfig is used to pass configuration when sealing data.
+type SealConfig struct {
+	// PCRs is the selection of PCRs the sealed data is bound to. If no PCRs
+	// are selected, the data can be unsealed using the password.
+	PCRs tpm2.PCRSelection
+	// Password is the authorization value of the sealed data object.
+	Password string
+	// Session is used to seal and unseal the data using encrypted sessions,
+	// if set.
+	Session *Session
+}
+
+// Seal creates a data object under the SRK that contains the given data. If
+// PCRs are selected in the configuration, the data object can only be unsealed
+// while the selected PCRs have the same values they have now. If a password is
+// also set, it will be required as well. It returns the public and private
+// blobs of the created object.
+func Seal(rwc io.ReadWriteCloser, data []byte, config SealConfig) (public, private []byte, err error) {
+	switch {
+	case len(data) == 0:
+		return nil, nil, errors.New("data to seal cannot be empty")
+	case len(data) > MaxSealedDataSize:
+		return nil, nil, fmt.Errorf("data to seal cannot be longer than %d bytes", MaxSealedDataSize)
+	}
+
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+
+	tmpl := tpm2.Public{
+		Type:       tpm2.AlgKeyedHash,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent,
+	}
+	if len(config.PCRs.PCRs) > 0 {
+		if tmpl.AuthPolicy, err = policyDigest(rwc, config); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		tmpl.Attributes |= tpm2.FlagUserWithAuth
+	}
+
+	if config.Session != nil {
+		// The data couldn't be unsealed without sending the password in
+		// the clear.
+		if len(config.PCRs.PCRs) > 0 && config.Password != "" {
+			return nil, nil, errPasswordInPolicy
+		}
+		if public, private, _, err = config.Session.create(rwc, tmpl, config.Password, data); err != nil {
+			return nil, nil, err
+		}
+		return public, private, nil
+	}
+
+	private, public, _, _, _, err = tpm2.CreateKeyWithSensitive(rwc, srk, tpm2.PCRSelection{}, "", config.Password, tmpl, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CreateKeyWithSensitive() failed: %w", err)
+	}
+
+	return public, private, nil
+}
+
+// Unseal loads the data object with the given public and private blobs and
+// returns the data sealed in it. The configuration must be the same one used
+// to seal the data.
+func Unseal(rwc io.ReadWriteCloser, public, private []byte, config SealConfig) ([]byte, error) {
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+
+	handle, _, err := tpm2.Load(rwc, srk, "", public, private)
+	if err != nil {
+		return nil, fmt.Errorf("Load() failed: %w", err)
+	}
+	defer tpm2.FlushContext(rwc, handle)
+
+	if config.Session != nil {
+		return config.Session.unseal(rwc, handle, config)
+	}
+
+	if len(config.PCRs.PCRs) == 0 {
+		data, err := tpm2.Unseal(rwc, handle, config.Password)
+		if err != nil {
+			return nil, fmt.Errorf("Unseal() failed: %w", err)
+		}
+		return data, nil
+	}
+
+	session, err := policySession(rwc, tpm2.SessionPolicy, config)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rwc, session)
+
+	data, err := tpm2.UnsealWithSession(rwc, session, handle, config.Password)
+	if err != nil {
+		return nil, fmt.Errorf("UnsealWithSession() failed: %w", err)
+	}
+	return data, nil
+}
+
+// policyDigest returns the policy digest for the configuration, computed
+// using a trial session with the current values of the PCRs.
+func policyDigest(rwc io.ReadWriteCloser, config SealConfig) ([]byte, error) {
+	session, err := policySession(rwc, tpm2.SessionTrial, config)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rwc, session)
+
+	digest, err := tpm2.PolicyGetDigest(rwc, session)
+	if err != nil {
+		return nil, fmt.Errorf("PolicyGetDigest() failed: %w", err)
+	}
+	return digest, nil
+}
+
+// policySession starts a session of the given type and runs the policy
+// commands for the configuration.
+func policySession(rwc io.ReadWriteCloser, sessionType tpm2.SessionType, config SealConfig) (tpmutil.Handle, error) {
+	session, _, err := tpm2.StartAuthSession(rwc, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, sessionType, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, fmt.Errorf("StartAuthSession() failed: %w", err)
+	}
+
+	if err := tpm2.PolicyPCR(rwc, session, nil, config.PCRs); err != nil {
+		tpm2.FlushContext(rwc, session)
+		return 0, fmt.Errorf("PolicyPCR() failed: %w", err)
+	}
+
+	if config.Password != "" {
+		if err := tpm2.PolicyPassword(rwc, session); err != nil {
+			tpm2.FlushContext(rwc, session)
+			return 0, fmt.Errorf("PolicyPassword() failed: %w", err)
+		}
+	}
+
+	return session, nil
+}
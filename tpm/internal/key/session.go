@@ -0,0 +1,387 @@
+package key
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+
+	legacy "github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+const (
+	// sessionNonceSize is the size of the nonces of encrypted sessions.
+	sessionNonceSize = 16
+	// sessionKeyBits is the size of the AES key used for parameter
+	// encryption.
+	sessionKeyBits = 128
+)
+
+// errPasswordInPolicy is returned when an operation with encrypted sessions
+// would require sending a password in the clear in a policy session.
+var errPasswordInPolicy = errors.New("passwords in policy sessions are not supported with encrypted sessions")
+
+// Session starts salted and bound HMAC sessions with AES-CFB parameter
+// encryption. Sessions are salted with the EK, so that the session key can
+// only be computed by the TPM the EK belongs to, and bound to the SRK.
+//
+// The EK public area is read from the TPM once, when the Session is created,
+// and used to salt all sessions started afterwards. The public area is read
+// over the same bus the sessions protect, so unless it's verified when the
+// Session is created, the EK is only trusted on first use: an active
+// interposer present at that time can replace it with its own key and
+// recover the session keys.
+type Session struct {
+	ekHandle  tpm2.TPMHandle
+	ekPublic  tpm2.TPMTPublic
+	srkHandle tpm2.TPMHandle
+	srkName   tpm2.TPM2BName
+}
+
+// NewSession returns a Session using the EK and the SRK of the TPM. Both
+// are created and persisted if they don't exist yet.
+//
+// If verifyEK is not nil, it's called with the public key of the EK read
+// from the TPM, and the Session is only returned if it succeeds. If it's
+// nil, the EK is trusted on first use.
+func NewSession(rwc io.ReadWriteCloser, verifyEK func(ekPublic crypto.PublicKey) error) (*Session, error) {
+	ek, _, err := getPrimaryKeyHandle(rwc, commonEkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get EK handle: %w", err)
+	}
+	ekPub, _, _, err := legacy.ReadPublic(rwc, ek)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+	if verifyEK != nil {
+		pub, err := ekPub.Key()
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding EK public key: %w", err)
+		}
+		if err := verifyEK(pub); err != nil {
+			return nil, fmt.Errorf("failed verifying EK: %w", err)
+		}
+	}
+	ekPublic, err := toPublic(ekPub)
+	if err != nil {
+		return nil, err
+	}
+
+	srk, _, err := getPrimaryKeyHandle(rwc, commonSrkEquivalentHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SRK handle: %w", err)
+	}
+	_, srkName, _, err := legacy.ReadPublic(rwc, srk)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+
+	return &Session{
+		ekHandle:  tpm2.TPMHandle(ek),
+		ekPublic:  *ekPublic,
+		srkHandle: tpm2.TPMHandle(srk),
+		srkName:   tpm2.TPM2BName{Buffer: srkName},
+	}, nil
+}
+
+// EK certificate NV indices defined in the TCG EK Credential Profile.
+const (
+	nvIndexRSAEKCertificate = tpmutil.Handle(0x01C00002)
+	nvIndexECCEKCertificate = tpmutil.Handle(0x01C0000A)
+)
+
+// ReadEKCertificate reads the EK certificate for an EK with the given public
+// key from the NV index defined in the TCG EK Credential Profile. The
+// certificate is read in the clear, so it must be verified before it's
+// trusted.
+func ReadEKCertificate(rwc io.ReadWriter, ekPublic crypto.PublicKey) (*x509.Certificate, error) {
+	var index tpmutil.Handle
+	switch ekPublic.(type) {
+	case *rsa.PublicKey:
+		index = nvIndexRSAEKCertificate
+	case *ecdsa.PublicKey:
+		index = nvIndexECCEKCertificate
+	default:
+		return nil, fmt.Errorf("unsupported EK public key type %T", ekPublic)
+	}
+
+	data, err := legacy.NVReadEx(rwc, index, index, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("NVReadEx() failed: %w", err)
+	}
+	// some TPMs pad the certificate stored in the NV index
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed parsing EK certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(raw.FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing EK certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// hmac returns a one-off session authorizing an object with the given
+// authorization value, and encrypting parameters as set by `encryption`.
+func (s *Session) hmac(auth []byte, encryption tpm2.AuthOption) tpm2.Session {
+	return tpm2.HMAC(tpm2.TPMAlgSHA256, sessionNonceSize,
+		tpm2.Auth(auth),
+		tpm2.Salted(s.ekHandle, s.ekPublic),
+		tpm2.Bound(s.srkHandle, s.srkName, nil),
+		encryption,
+	)
+}
+
+// policy starts a policy session with the same salt and parameter
+// encryption as the HMAC sessions.
+func (s *Session) policy(t transport.TPM, encryption tpm2.AuthOption) (tpm2.Session, func() error, error) {
+	sess, closer, err := tpm2.PolicySession(t, tpm2.TPMAlgSHA256, sessionNonceSize,
+		tpm2.Salted(s.ekHandle, s.ekPublic),
+		tpm2.Bound(s.srkHandle, s.srkName, nil),
+		encryption,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("StartAuthSession() failed: %w", err)
+	}
+	return sess, closer, nil
+}
+
+// GetRandom returns `size` random bytes generated by the TPM. The response
+// is encrypted.
+func (s *Session) GetRandom(rwc io.ReadWriter, size uint16) ([]byte, error) {
+	rsp, err := tpm2.GetRandom{
+		BytesRequested: size,
+	}.Execute(transport.FromReadWriter(rwc), s.hmac(nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptOut)))
+	if err != nil {
+		return nil, fmt.Errorf("GetRandom() failed: %w", err)
+	}
+	return rsp.RandomBytes.Buffer, nil
+}
+
+// create creates an object under the SRK using the template, with the given
+// password and sensitive data. The sensitive values and the private blob are
+// encrypted. It returns the public and private blobs, and the creation data
+// of the object, encoded as the legacy API does.
+func (s *Session) create(rwc io.ReadWriter, tmpl legacy.Public, password string, data []byte) (public, private, creationData []byte, err error) {
+	inPublic, err := tmpl.Encode()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed encoding template: %w", err)
+	}
+
+	sensitive := &tpm2.TPMSSensitiveCreate{
+		UserAuth: tpm2.TPM2BAuth{Buffer: []byte(password)},
+	}
+	if len(data) > 0 {
+		sensitive.Data = tpm2.NewTPMUSensitiveCreate(&tpm2.TPM2BSensitiveData{Buffer: data})
+	}
+
+	rsp, err := tpm2.Create{
+		ParentHandle: tpm2.AuthHandle{
+			Handle: s.srkHandle,
+			Name:   s.srkName,
+			Auth:   s.hmac(nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptInOut)),
+		},
+		InSensitive: tpm2.TPM2BSensitiveCreate{Sensitive: sensitive},
+		InPublic:    tpm2.BytesAs2B[tpm2.TPMTPublic](inPublic),
+	}.Execute(transport.FromReadWriter(rwc))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Create() failed: %w", err)
+	}
+
+	return rsp.OutPublic.Bytes(), rsp.OutPrivate.Buffer, rsp.CreationData.Bytes(), nil
+}
+
+// Sign signs the digest with the serialized key, like [Sign], using an
+// encrypted session. The digest is encrypted, and the password is used to
+// compute the session HMAC instead of being sent in the clear. Keys with a
+// policy are not supported.
+func (s *Session) Sign(rwc io.ReadWriteCloser, data []byte, handle tpmutil.Handle, password string, digest []byte, scheme *legacy.SigScheme) (sig *legacy.Signature, err error) {
+	err = withKeyHandle(rwc, data, handle, func(handle tpmutil.Handle) error {
+		_, name, _, err := legacy.ReadPublic(rwc, handle)
+		if err != nil {
+			return fmt.Errorf("ReadPublic() failed: %w", err)
+		}
+
+		rsp, err := tpm2.Sign{
+			KeyHandle: tpm2.AuthHandle{
+				Handle: tpm2.TPMHandle(handle),
+				Name:   tpm2.TPM2BName{Buffer: name},
+				Auth:   s.hmac([]byte(password), tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptIn)),
+			},
+			Digest:   tpm2.TPM2BDigest{Buffer: digest},
+			InScheme: toSigScheme(scheme),
+			Validation: tpm2.TPMTTKHashCheck{
+				Tag:       tpm2.TPMSTHashCheck,
+				Hierarchy: tpm2.TPMRHNull,
+			},
+		}.Execute(transport.FromReadWriter(rwc))
+		if err != nil {
+			return fmt.Errorf("Sign() failed: %w", err)
+		}
+
+		if sig, err = legacy.DecodeSignature(bytes.NewBuffer(tpm2.Marshal(rsp.Signature))); err != nil {
+			return fmt.Errorf("failed decoding signature: %w", err)
+		}
+		return nil
+	})
+	return
+}
+
+// unseal unseals the loaded data object using an encrypted session. The
+// sealed data is encrypted in the response.
+func (s *Session) unseal(rwc io.ReadWriter, handle tpmutil.Handle, config SealConfig) ([]byte, error) {
+	_, name, _, err := legacy.ReadPublic(rwc, handle)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPublic() failed: %w", err)
+	}
+
+	encryption := tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptOut)
+	t := transport.FromReadWriter(rwc)
+
+	auth := s.hmac([]byte(config.Password), encryption)
+	if len(config.PCRs.PCRs) > 0 {
+		// TPM2_PolicyPassword sends the password in the clear.
+		if config.Password != "" {
+			return nil, errPasswordInPolicy
+		}
+		sess, closer, err := s.policy(t, encryption)
+		if err != nil {
+			return nil, err
+		}
+		defer closer() //nolint:errcheck // the session is flushed on best effort
+
+		if _, err := (tpm2.PolicyPCR{
+			PolicySession: sess.Handle(),
+			Pcrs:          toPCRSelection(config.PCRs),
+		}).Execute(t); err != nil {
+			return nil, fmt.Errorf("PolicyPCR() failed: %w", err)
+		}
+		auth = sess
+	}
+
+	rsp, err := tpm2.Unseal{
+		ItemHandle: tpm2.AuthHandle{
+			Handle: tpm2.TPMHandle(handle),
+			Name:   tpm2.TPM2BName{Buffer: name},
+			Auth:   auth,
+		},
+	}.Execute(t)
+	if err != nil {
+		return nil, fmt.Errorf("Unseal() failed: %w", err)
+	}
+	return rsp.OutData.Buffer, nil
+}
+
+// decrypt loads the serialized RSA key and decrypts the ciphertext, like
+// [Decrypt], using an encrypted session. The plaintext is encrypted in the
+// response.
+func (s *Session) decrypt(rwc io.ReadWriteCloser, data []byte, scheme *legacy.AsymScheme, label string, ciphertext []byte) (plaintext []byte, err error) {
+	inScheme := tpm2.TPMTRSADecrypt{Scheme: tpm2.TPMAlgRSAES, Details: tpm2.NewTPMUAsymScheme(tpm2.TPMAlgRSAES, &tpm2.TPMSEncSchemeRSAES{})}
+	if scheme != nil && scheme.Alg == legacy.AlgOAEP {
+		inScheme = tpm2.TPMTRSADecrypt{Scheme: tpm2.TPMAlgOAEP, Details: tpm2.NewTPMUAsymScheme(tpm2.TPMAlgOAEP, &tpm2.TPMSEncSchemeOAEP{
+			HashAlg: tpm2.TPMIAlgHash(scheme.Hash),
+		})}
+	}
+	// the legacy API appends the NUL terminator to non-empty labels
+	var inLabel tpm2.TPM2BData
+	if label != "" {
+		inLabel.Buffer = append([]byte(label), 0)
+	}
+
+	err = loadedObject(rwc, data, func(handle tpm2.TPMHandle, name tpm2.TPM2BName) error {
+		rsp, err := tpm2.RSADecrypt{
+			KeyHandle: tpm2.AuthHandle{
+				Handle: handle,
+				Name:   name,
+				Auth:   s.hmac(nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptOut)),
+			},
+			CipherText: tpm2.TPM2BPublicKeyRSA{Buffer: ciphertext},
+			InScheme:   inScheme,
+			Label:      inLabel,
+		}.Execute(transport.FromReadWriter(rwc))
+		if err != nil {
+			return fmt.Errorf("RSADecrypt() failed: %w", err)
+		}
+		plaintext = rsp.Message.Buffer
+		return nil
+	})
+	return
+}
+
+// ecdhZGen loads the serialized ECC key and computes the shared point, like
+// [ECDHZGen], using an encrypted session. The shared point is encrypted in
+// the response.
+func (s *Session) ecdhZGen(rwc io.ReadWriteCloser, data []byte, point legacy.ECPoint) (z *legacy.ECPoint, err error) {
+	err = loadedObject(rwc, data, func(handle tpm2.TPMHandle, name tpm2.TPM2BName) error {
+		rsp, err := tpm2.ECDHZGen{
+			KeyHandle: tpm2.AuthHandle{
+				Handle: handle,
+				Name:   name,
+				Auth:   s.hmac(nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptOut)),
+			},
+			InPoint: tpm2.New2B(tpm2.TPMSECCPoint{
+				X: tpm2.TPM2BECCParameter{Buffer: point.XRaw},
+				Y: tpm2.TPM2BECCParameter{Buffer: point.YRaw},
+			}),
+		}.Execute(transport.FromReadWriter(rwc))
+		if err != nil {
+			return fmt.Errorf("ECDHZGen() failed: %w", err)
+		}
+		out, err := rsp.OutPoint.Contents()
+		if err != nil {
+			return fmt.Errorf("failed decoding shared point: %w", err)
+		}
+		z = &legacy.ECPoint{XRaw: out.X.Buffer, YRaw: out.Y.Buffer}
+		return nil
+	})
+	return
+}
+
+// toPublic converts a public area of the legacy API.
+func toPublic(pub legacy.Public) (*tpm2.TPMTPublic, error) {
+	b, err := pub.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding public area: %w", err)
+	}
+	p, err := tpm2.Unmarshal[tpm2.TPMTPublic](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding public area: %w", err)
+	}
+	return p, nil
+}
+
+// toSigScheme converts a signature scheme of the legacy API. A nil scheme
+// uses the scheme of the key.
+func toSigScheme(scheme *legacy.SigScheme) tpm2.TPMTSigScheme {
+	if scheme == nil {
+		return tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull}
+	}
+	return tpm2.TPMTSigScheme{
+		Scheme: tpm2.TPMIAlgSigScheme(scheme.Alg),
+		Details: tpm2.NewTPMUSigScheme(tpm2.TPMAlgID(scheme.Alg), &tpm2.TPMSSchemeHash{
+			HashAlg: tpm2.TPMIAlgHash(scheme.Hash),
+		}),
+	}
+}
+
+// toPCRSelection converts a PCR selection of the legacy API.
+func toPCRSelection(sel legacy.PCRSelection) tpm2.TPMLPCRSelection {
+	pcrs := make([]uint, len(sel.PCRs))
+	for i, pcr := range sel.PCRs {
+		pcrs[i] = uint(pcr) //nolint:gosec // PCR indexes are validated when sealing
+	}
+	return tpm2.TPMLPCRSelection{
+		PCRSelections: []tpm2.TPMSPCRSelection{{
+			Hash:      tpm2.TPMIAlgHash(sel.Hash),
+			PCRSelect: tpm2.PCClientCompatible.PCRs(pcrs...),
+		}},
+	}
+}
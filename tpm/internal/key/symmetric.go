@@ -0,0 +1,193 @@
+package key
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	legacy "github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// maxBufferSize is the size of the TPM2B_MAX_BUFFER parameters used to send
+// data to HMAC sequences and symmetric encryption commands. Larger data is
+// sent in multiple commands.
+const maxBufferSize = 1024
+
+// symmetricAttributes are the attributes of HMAC and AES keys.
+const symmetricAttributes = legacy.FlagFixedTPM | legacy.FlagFixedParent | legacy.FlagSensitiveDataOrigin | legacy.FlagUserWithAuth
+
+// isSymmetric returns whether the algorithm is an HMAC or AES algorithm.
+func isSymmetric(alg Algorithm) bool {
+	return alg == "HMAC" || alg == "AES"
+}
+
+// symmetricTemplateFromConfig returns the template of an HMAC or AES key. The
+// size of HMAC keys is the size of the digest, and defaults to 256 bits. The
+// size of AES keys defaults to 128 bits. AES keys don't have a mode, so it's
+// selected when data is encrypted or decrypted.
+func symmetricTemplateFromConfig(opts *KeyConfig) (legacy.Public, error) {
+	switch opts.Algorithm {
+	case "HMAC":
+		var hash legacy.Algorithm
+		switch opts.Size {
+		case 0, 256:
+			hash = legacy.AlgSHA256
+		case 384:
+			hash = legacy.AlgSHA384
+		case 512:
+			hash = legacy.AlgSHA512
+		default:
+			return legacy.Public{}, fmt.Errorf("unsupported HMAC size: %d", opts.Size)
+		}
+		return legacy.Public{
+			Type:       legacy.AlgKeyedHash,
+			NameAlg:    legacy.AlgSHA256,
+			Attributes: symmetricAttributes | legacy.FlagSign,
+			KeyedHashParameters: &legacy.KeyedHashParams{
+				Alg:  legacy.AlgHMAC,
+				Hash: hash,
+			},
+		}, nil
+	case "AES":
+		var bits uint16
+		switch opts.Size {
+		case 0, 128:
+			bits = 128
+		case 256:
+			bits = 256
+		default:
+			return legacy.Public{}, fmt.Errorf("unsupported AES key size: %d", opts.Size)
+		}
+		return legacy.Public{
+			Type:       legacy.AlgSymCipher,
+			NameAlg:    legacy.AlgSHA256,
+			Attributes: symmetricAttributes | legacy.FlagSign | legacy.FlagDecrypt,
+			SymCipherParameters: &legacy.SymCipherParams{
+				Symmetric: &legacy.SymScheme{
+					Alg:     legacy.AlgAES,
+					KeyBits: bits,
+					Mode:    legacy.AlgNull,
+				},
+			},
+		}, nil
+	default:
+		return legacy.Public{}, fmt.Errorf("unsupported algorithm type: %q", opts.Algorithm)
+	}
+}
+
+// objectAuth returns the session authorizing the use of an object with the
+// password. If an encrypted session is set, parameters are encrypted as set
+// by `encryption`.
+func objectAuth(session *Session, password []byte, encryption tpm2.AuthOption) tpm2.Session {
+	if session == nil {
+		return tpm2.PasswordAuth(password)
+	}
+	return session.hmac(password, encryption)
+}
+
+// loadedObject loads the serialized key, and runs fn with the handle and the
+// name of the loaded key.
+func loadedObject(rwc io.ReadWriteCloser, data []byte, fn func(handle tpm2.TPMHandle, name tpm2.TPM2BName) error) error {
+	pub, err := Public(data)
+	if err != nil {
+		return err
+	}
+	name, err := publicName(pub)
+	if err != nil {
+		return err
+	}
+	return withLoadedKey(rwc, data, func(handle tpmutil.Handle) error {
+		return fn(tpm2.TPMHandle(handle), tpm2.TPM2BName{Buffer: name})
+	})
+}
+
+// HMAC computes the HMAC of the message using the serialized HMAC key. The
+// message is sent to the TPM in an HMAC sequence, so it can be of any size.
+// The key is flushed once the sequence is started, as the sequence holds its
+// own copy of it. If session is set, the message and the HMAC are encrypted.
+func HMAC(rwc io.ReadWriteCloser, data []byte, password string, session *Session, message []byte) ([]byte, error) {
+	t := transport.FromReadWriter(rwc)
+
+	var sequence tpm2.AuthHandle
+	if err := loadedObject(rwc, data, func(handle tpm2.TPMHandle, name tpm2.TPM2BName) error {
+		rsp, err := tpm2.HmacStart{
+			Handle: tpm2.AuthHandle{
+				Handle: handle,
+				Name:   name,
+				Auth:   objectAuth(session, []byte(password), tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptIn)),
+			},
+			HashAlg: tpm2.TPMAlgNull,
+		}.Execute(t)
+		if err != nil {
+			return fmt.Errorf("HMAC_Start() failed: %w", err)
+		}
+		sequence.Handle = rsp.SequenceHandle
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for len(message) > maxBufferSize {
+		sequence.Auth = objectAuth(session, nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptIn))
+		if _, err := (tpm2.SequenceUpdate{
+			SequenceHandle: sequence,
+			Buffer:         tpm2.TPM2BMaxBuffer{Buffer: message[:maxBufferSize]},
+		}).Execute(t); err != nil {
+			_ = legacy.FlushContext(rwc, tpmutil.Handle(sequence.Handle))
+			return nil, fmt.Errorf("SequenceUpdate() failed: %w", err)
+		}
+		message = message[maxBufferSize:]
+	}
+
+	sequence.Auth = objectAuth(session, nil, tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptInOut))
+	rsp, err := tpm2.SequenceComplete{
+		SequenceHandle: sequence,
+		Buffer:         tpm2.TPM2BMaxBuffer{Buffer: message},
+		Hierarchy:      tpm2.TPMRHNull,
+	}.Execute(t)
+	if err != nil {
+		_ = legacy.FlushContext(rwc, tpmutil.Handle(sequence.Handle))
+		return nil, fmt.Errorf("SequenceComplete() failed: %w", err)
+	}
+
+	return rsp.Result.Buffer, nil
+}
+
+// EncryptDecrypt encrypts or decrypts the message using the serialized AES
+// key, in the given mode and with the given initialization vector. If session
+// is set, the message and the result are encrypted.
+func EncryptDecrypt(rwc io.ReadWriteCloser, data []byte, password string, session *Session, decrypt bool, mode legacy.Algorithm, iv, message []byte) ([]byte, error) {
+	if len(iv) == 0 {
+		return nil, errors.New("initialization vector cannot be empty")
+	}
+
+	var result []byte
+	err := loadedObject(rwc, data, func(handle tpm2.TPMHandle, name tpm2.TPM2BName) error {
+		t := transport.FromReadWriter(rwc)
+		for len(message) > 0 {
+			size := min(len(message), maxBufferSize)
+			rsp, err := tpm2.EncryptDecrypt2{
+				KeyHandle: tpm2.AuthHandle{
+					Handle: handle,
+					Name:   name,
+					Auth:   objectAuth(session, []byte(password), tpm2.AESEncryption(sessionKeyBits, tpm2.EncryptInOut)),
+				},
+				Message: tpm2.TPM2BMaxBuffer{Buffer: message[:size]},
+				Decrypt: tpm2.TPMIYesNo(decrypt),
+				Mode:    tpm2.TPMIAlgSymMode(mode),
+				IV:      tpm2.TPM2BIV{Buffer: iv},
+			}.Execute(t)
+			if err != nil {
+				return fmt.Errorf("EncryptDecrypt2() failed: %w", err)
+			}
+			result = append(result, rsp.OutData.Buffer...)
+			iv = rsp.IV.Buffer
+			message = message[size:]
+		}
+		return nil
+	})
+	return result, err
+}
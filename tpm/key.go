@@ -26,6 +26,8 @@ type Key struct {
 	chain      []*x509.Certificate
 	createdAt  time.Time
 	blobs      *Blobs
+	policy     []byte
+	handle     uint32
 	tpm        *TPM
 }
 
@@ -78,6 +80,17 @@ func (k *Key) CertificateChain() []*x509.Certificate {
 	return k.chain
 }
 
+// PersistentHandle returns the persistent handle the Key was persisted at
+// using [TPM.PersistKey]. It returns 0 if the Key isn't persistent.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *Key) PersistentHandle() uint32 {
+	return k.handle
+}
+
 // CreatedAt returns the the creation time of the Key.
 func (k *Key) CreatedAt() time.Time {
 	return k.createdAt.Truncate(time.Second)
@@ -114,11 +127,32 @@ type comparablePublicKey interface {
 // CreateKeyConfig is used to pass configuration
 // when creating Keys.
 type CreateKeyConfig struct {
-	// Algorithm to be used, either RSA or ECDSA.
+	// Algorithm to be used, either RSA, ECDSA, HMAC or AES. HMAC and AES
+	// keys can be used through [TPM.GetHMAC] and [TPM.GetSymmetricCipher].
+	// They can't be combined with Decrypt, Duplicable or Policy, and they're
+	// not supported on Windows.
 	Algorithm string
 	// Size is used to specify the bit size of the key or elliptic curve. For
-	// example, '256' is used to specify curve P-256.
+	// example, '256' is used to specify curve P-256. For HMAC keys it's the
+	// size of the digest, either 256 (default), 384 or 512. For AES keys it's
+	// either 128 (default) or 256.
 	Size int
+	// Decrypt creates a key that can be used for RSA decryption or ECDH key
+	// agreement instead of signing. Decryption keys can be used through
+	// [TPM.GetDecrypter] and [TPM.GetECDH]. It's not supported on Windows.
+	Decrypt bool
+	// Password is the password of the key. If set, it has to be provided
+	// when the key is used, using [WithKeyPassword]. It's not supported on
+	// Windows.
+	Password string
+	// Policy is the authorization policy of the key. If set, the key can
+	// only be used when the policy is satisfied. It's not supported on
+	// Windows.
+	Policy *KeyPolicy
+	// Duplicable creates a key that can be exported to another TPM using
+	// [TPM.ExportKey]. It can't be combined with Policy, and it's not
+	// supported on Windows.
+	Duplicable bool
 
 	// TODO(hs): move key name to this struct?
 }
@@ -164,12 +198,35 @@ func (t *TPM) CreateKey(ctx context.Context, name string, config CreateKeyConfig
 	}
 
 	createConfig := internalkey.CreateConfig{
-		Algorithm: config.Algorithm,
-		Size:      config.Size,
+		Algorithm:  config.Algorithm,
+		Size:       config.Size,
+		Decrypt:    config.Decrypt,
+		Password:   config.Password,
+		Duplicable: config.Duplicable,
 	}
 	if err := t.validate(&createConfig); err != nil {
 		return nil, fmt.Errorf("invalid key creation parameters: %w", err)
 	}
+
+	policy, err := t.newKeyPolicy(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key policy: %w", err)
+	}
+
+	var policyData []byte
+	if policy != nil {
+		if createConfig.Policy, err = policy.config(); err != nil {
+			return nil, fmt.Errorf("invalid key policy: %w", err)
+		}
+		if policyData, err = json.Marshal(policy); err != nil {
+			return nil, fmt.Errorf("failed marshaling key policy: %w", err)
+		}
+	}
+
+	if createConfig.Session, err = t.encryptedSession(ctx); err != nil {
+		return nil, fmt.Errorf("failed creating key %q: %w", name, err)
+	}
+
 	data, err := internalkey.Create(t.rwc, prefixKey(name), createConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating key %q: %w", name, err)
@@ -179,6 +236,7 @@ func (t *TPM) CreateKey(ctx context.Context, name string, config CreateKeyConfig
 		name:      name,
 		data:      data,
 		createdAt: now,
+		policy:    policyData,
 		tpm:       t,
 	}
 
@@ -214,6 +272,11 @@ func (w attestValidationWrapper) Validate() error {
 // name is generated. If a Key with the same name exists, `ErrExists` is
 // returned.
 func (t *TPM) AttestKey(ctx context.Context, akName, name string, config AttestKeyConfig) (key *Key, err error) {
+	// attested keys are created by go-attestation, without sessions
+	if t.options.encryptedSessions {
+		return nil, fmt.Errorf("failed creating attested key %q: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
 	if err = t.open(ctx); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -351,6 +414,12 @@ func (t *TPM) GetKeysAttestedBy(ctx context.Context, akName string) (keys []*Key
 // DeleteKey removes the Key identified by `name`. It returns `ErrNotfound`
 // if it doesn't exist.
 func (t *TPM) DeleteKey(ctx context.Context, name string) (err error) {
+	// persistent Keys are evicted first, so that they don't remain in the
+	// TPM after being deleted.
+	if err := t.EvictKey(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
 	if err := t.open(ctx); err != nil {
 		return fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -379,9 +448,11 @@ func (t *TPM) DeleteKey(ctx context.Context, name string) (err error) {
 	return
 }
 
-// Signer returns a crypto.Signer backed by the Key.
-func (k *Key) Signer(ctx context.Context) (crypto.Signer, error) {
-	return k.tpm.GetSigner(ctx, k.name)
+// Signer returns a crypto.Signer backed by the Key. The password and the
+// approved policy required by Keys with a password or policy can be
+// provided using [WithKeyPassword] and [WithApprovedPolicy].
+func (k *Key) Signer(ctx context.Context, opts ...SignerOption) (crypto.Signer, error) {
+	return k.tpm.GetSigner(ctx, k.name, opts...)
 }
 
 // CertificationParameters returns information about the key that can be used to
@@ -482,6 +553,8 @@ func (k *Key) toStorage() *storage.Key {
 		AttestedBy: k.attestedBy,
 		Chain:      k.chain,
 		CreatedAt:  k.createdAt.UTC(),
+		Policy:     k.policy,
+		Handle:     k.handle,
 	}
 }
 
@@ -494,6 +567,8 @@ func keyFromStorage(sk *storage.Key, t *TPM) *Key {
 		attestedBy: sk.AttestedBy,
 		chain:      sk.Chain,
 		createdAt:  sk.CreatedAt.Local(),
+		policy:     sk.Policy,
+		handle:     sk.Handle,
 		tpm:        t,
 	}
 }
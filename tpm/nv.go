@@ -0,0 +1,403 @@
+package tpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+const (
+	// minNVIndex is the first NV index handle.
+	minNVIndex = 0x01000000
+	// maxNVIndex is the last NV index handle.
+	maxNVIndex = 0x01ffffff
+	// defaultNVBufferSize is the NV buffer size used if the TPM doesn't
+	// report TPM_PT_NV_BUFFER_MAX.
+	defaultNVBufferSize = 512
+)
+
+// NVAttributes are the attributes of an NV index. They determine how the
+// NV index can be read and written.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type NVAttributes uint32
+
+const (
+	// NVOwnerWrite allows writing the NV index using owner authorization.
+	NVOwnerWrite = NVAttributes(tpm2.AttrOwnerWrite)
+	// NVAuthWrite allows writing the NV index using the NV index password.
+	NVAuthWrite = NVAttributes(tpm2.AttrAuthWrite)
+	// NVWriteDefine allows the NV index to be locked for writing
+	// permanently. The lock only takes effect after the index is written.
+	NVWriteDefine = NVAttributes(tpm2.AttrWriteDefine)
+	// NVWriteSTClear allows the NV index to be locked for writing until
+	// the next TPM reset or restart.
+	NVWriteSTClear = NVAttributes(tpm2.AttrWriteSTClear)
+	// NVOwnerRead allows reading the NV index using owner authorization.
+	NVOwnerRead = NVAttributes(tpm2.AttrOwnerRead)
+	// NVAuthRead allows reading the NV index using the NV index password.
+	NVAuthRead = NVAttributes(tpm2.AttrAuthRead)
+	// NVNoDA exempts the NV index from dictionary attack protections.
+	NVNoDA = NVAttributes(tpm2.AttrNoDA)
+	// NVWriteLocked is set by the TPM if the NV index is locked for
+	// writing. It can't be used when defining an NV index.
+	NVWriteLocked = NVAttributes(tpm2.AttrWriteLocked)
+	// NVWritten is set by the TPM if the NV index has been written. It
+	// can't be used when defining an NV index.
+	NVWritten = NVAttributes(tpm2.AttrWritten)
+
+	// DefaultNVAttributes are the attributes used when no attributes are
+	// provided. The NV index can be read and written using both owner
+	// authorization and the NV index password.
+	DefaultNVAttributes = NVOwnerWrite | NVAuthWrite | NVOwnerRead | NVAuthRead
+)
+
+// String returns a text representation of the NV attributes.
+func (a NVAttributes) String() string {
+	return tpm2.NVAttr(a).String()
+}
+
+// NVIndex describes an NV index defined in the TPM.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type NVIndex struct {
+	// Index is the NV index handle.
+	Index uint32
+	// Size is the size of the NV index in bytes.
+	Size int
+	// Attributes are the attributes of the NV index.
+	Attributes NVAttributes
+}
+
+// Written returns whether the NV index has been written.
+func (i *NVIndex) Written() bool {
+	return i.Attributes&NVWritten != 0
+}
+
+// WriteLocked returns whether the NV index is locked for writing.
+func (i *NVIndex) WriteLocked() bool {
+	return i.Attributes&NVWriteLocked != 0
+}
+
+// NVConfig is used to pass configuration when defining an NV index.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type NVConfig struct {
+	// Size is the size of the NV index in bytes.
+	Size int
+	// Attributes are the attributes of the NV index. If not set,
+	// DefaultNVAttributes is used.
+	Attributes NVAttributes
+	// Password is the password of the NV index. It's used when the NV
+	// index is read or written using NV index authorization.
+	Password string
+	// OwnerPassword is the owner hierarchy password. If not set, the
+	// password configured using WithOwnerPassword is used.
+	OwnerPassword string
+}
+
+// NVAuth selects the authorization used to read, write or lock an NV
+// index.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type NVAuth struct {
+	// Owner selects owner authorization instead of NV index
+	// authorization.
+	Owner bool
+	// Password is the owner hierarchy password if Owner is set, and the
+	// NV index password otherwise. If Owner is set and Password isn't,
+	// the password configured using WithOwnerPassword is used.
+	Password string
+}
+
+func (a NVAuth) handle(index tpmutil.Handle) tpmutil.Handle {
+	if a.Owner {
+		return tpm2.HandleOwner
+	}
+	return index
+}
+
+func (a NVAuth) password(t *TPM) string {
+	if a.Owner {
+		return t.ownerAuth(a.Password)
+	}
+	return a.Password
+}
+
+// ownerAuth returns the owner hierarchy password, or the password configured
+// using WithOwnerPassword if it's empty.
+func (t *TPM) ownerAuth(password string) string {
+	if password == "" {
+		return t.options.ownerPassword
+	}
+	return password
+}
+
+func nvHandle(index uint32) (tpmutil.Handle, error) {
+	if index < minNVIndex || index > maxNVIndex {
+		return 0, fmt.Errorf("invalid NV index 0x%08x", index)
+	}
+	return tpmutil.Handle(index), nil
+}
+
+// DefineNV defines the NV index `index` in the TPM. It returns `ErrExists`
+// if the NV index is already defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) DefineNV(ctx context.Context, index uint32, config NVConfig) (err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return err
+	}
+	if config.Size <= 0 || config.Size > 65535 {
+		return fmt.Errorf("invalid NV index size %d", config.Size)
+	}
+	attrs := config.Attributes
+	if attrs == 0 {
+		attrs = DefaultNVAttributes
+	}
+	if attrs&(NVWritten|NVWriteLocked) != 0 {
+		return fmt.Errorf("invalid NV index attributes %s", attrs)
+	}
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed defining NV index 0x%08x: %w", index, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if _, err := t.readNVPublic(handle); err == nil {
+		return fmt.Errorf("failed defining NV index 0x%08x: %w", index, ErrExists)
+	}
+
+	if err := tpm2.NVDefineSpace(t.rwc, tpm2.HandleOwner, handle, t.ownerAuth(config.OwnerPassword), config.Password, nil, tpm2.NVAttr(attrs), uint16(config.Size)); err != nil {
+		return fmt.Errorf("failed defining NV index 0x%08x: %w", index, err)
+	}
+
+	return
+}
+
+// GetNV returns the description of NV index `index`. It returns
+// `ErrNotFound` if the NV index isn't defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetNV(ctx context.Context, index uint32) (nv *NVIndex, err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	pub, err := t.readNVPublic(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NVIndex{
+		Index:      index,
+		Size:       int(pub.DataSize),
+		Attributes: NVAttributes(pub.Attributes),
+	}, nil
+}
+
+// WriteNV writes `data` to NV index `index`, starting at the beginning of
+// the NV index. It returns `ErrNotFound` if the NV index isn't defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) WriteNV(ctx context.Context, index uint32, data []byte, auth NVAuth) (err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("data to write cannot be empty")
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed writing NV index 0x%08x: %w", index, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	pub, err := t.readNVPublic(handle)
+	if err != nil {
+		return err
+	}
+	if len(data) > int(pub.DataSize) {
+		return fmt.Errorf("data size %d exceeds NV index 0x%08x size %d", len(data), index, pub.DataSize)
+	}
+
+	bufferSize := t.nvBufferSize()
+	for offset := 0; offset < len(data); offset += bufferSize {
+		end := min(offset+bufferSize, len(data))
+		if err := tpm2.NVWrite(t.rwc, auth.handle(handle), handle, auth.password(t), data[offset:end], uint16(offset)); err != nil {
+			return fmt.Errorf("failed writing NV index 0x%08x: %w", index, err)
+		}
+	}
+
+	return
+}
+
+// ReadNV returns the contents of NV index `index`. It returns `ErrNotFound`
+// if the NV index isn't defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ReadNV(ctx context.Context, index uint32, auth NVAuth) (data []byte, err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.options.encryptedSessions {
+		return nil, fmt.Errorf("failed reading NV index 0x%08x: %w", index, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if _, err := t.readNVPublic(handle); err != nil {
+		return nil, err
+	}
+
+	if data, err = tpm2.NVReadEx(t.rwc, handle, auth.handle(handle), auth.password(t), t.nvBufferSize()); err != nil {
+		return nil, fmt.Errorf("failed reading NV index 0x%08x: %w", index, err)
+	}
+
+	return
+}
+
+// LockNV locks NV index `index` for writing. The NV index must have been
+// defined with either NVWriteDefine, which locks it permanently, or
+// NVWriteSTClear, which locks it until the next TPM reset or restart. It
+// returns `ErrNotFound` if the NV index isn't defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) LockNV(ctx context.Context, index uint32, auth NVAuth) (err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return err
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed locking NV index 0x%08x: %w", index, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if _, err := t.readNVPublic(handle); err != nil {
+		return err
+	}
+
+	if err := tpm2.NVWriteLock(t.rwc, auth.handle(handle), handle, auth.password(t)); err != nil {
+		return fmt.Errorf("failed locking NV index 0x%08x: %w", index, err)
+	}
+
+	return
+}
+
+// UndefineNV removes NV index `index` from the TPM using owner
+// authorization. If `ownerPassword` is empty, the password configured using
+// WithOwnerPassword is used. It returns `ErrNotFound` if the NV index isn't
+// defined.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) UndefineNV(ctx context.Context, index uint32, ownerPassword string) (err error) {
+	handle, err := nvHandle(index)
+	if err != nil {
+		return err
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed undefining NV index 0x%08x: %w", index, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if _, err := t.readNVPublic(handle); err != nil {
+		return err
+	}
+
+	if err := tpm2.NVUndefineSpace(t.rwc, t.ownerAuth(ownerPassword), tpm2.HandleOwner, handle); err != nil {
+		return fmt.Errorf("failed undefining NV index 0x%08x: %w", index, err)
+	}
+
+	return
+}
+
+// readNVPublic reads the public area of an NV index. It returns
+// `ErrNotFound` if the NV index isn't defined.
+func (t *TPM) readNVPublic(handle tpmutil.Handle) (tpm2.NVPublic, error) {
+	pub, err := tpm2.NVReadPublic(t.rwc, handle)
+	if err != nil {
+		var herr tpm2.HandleError
+		if errors.As(err, &herr) && herr.Code == tpm2.RCHandle {
+			return pub, fmt.Errorf("failed reading NV index 0x%08x: %w", uint32(handle), ErrNotFound)
+		}
+		return pub, fmt.Errorf("failed reading NV index 0x%08x: %w", uint32(handle), err)
+	}
+	return pub, nil
+}
+
+// nvBufferSize returns the maximum number of bytes that can be read or
+// written in a single NV command.
+func (t *TPM) nvBufferSize() int {
+	props, _, err := tpm2.GetCapability(t.rwc, tpm2.CapabilityTPMProperties, 1, uint32(tpm2.NVMaxBufferSize))
+	if err != nil || len(props) != 1 {
+		return defaultNVBufferSize
+	}
+	prop, ok := props[0].(tpm2.TaggedProperty)
+	if !ok || prop.Tag != tpm2.NVMaxBufferSize || prop.Value == 0 {
+		return defaultNVBufferSize
+	}
+	return int(prop.Value)
+}
@@ -0,0 +1,290 @@
+package tpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+const (
+	// minPersistentHandle is the first persistent handle in the owner
+	// hierarchy.
+	minPersistentHandle = 0x81000000
+	// maxPersistentHandle is the last persistent handle in the owner
+	// hierarchy.
+	maxPersistentHandle = 0x817fffff
+	// maxHandlesPerCapability is the number of handles requested from the
+	// TPM at once when listing persistent handles.
+	maxHandlesPerCapability = 64
+)
+
+func persistentHandle(handle uint32) (tpmutil.Handle, error) {
+	if handle < minPersistentHandle || handle > maxPersistentHandle {
+		return 0, fmt.Errorf("invalid persistent handle 0x%08x", handle)
+	}
+	return tpmutil.Handle(handle), nil
+}
+
+// PersistKey makes the Key identified by `name` persistent at the persistent
+// handle `handle`. Signers for persistent Keys use the persistent handle
+// instead of loading the Key on every signature. It returns `ErrExists` if
+// an object is already persisted at the handle.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) PersistKey(ctx context.Context, name string, handle uint32) (err error) {
+	h, err := persistentHandle(handle)
+	if err != nil {
+		return err
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed persisting key %q: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	key, err := t.store.GetKey(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed getting key %q: %w", name, ErrNotFound)
+		}
+		return fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+	if key.Handle != 0 {
+		return fmt.Errorf("key %q is already persisted at handle 0x%08x", name, key.Handle)
+	}
+
+	if err := t.checkPersistentHandleFree(h); err != nil {
+		return err
+	}
+
+	if err := internalkey.Persist(t.rwc, key.Data, h, t.options.ownerPassword); err != nil {
+		return fmt.Errorf("failed persisting key %q: %w", name, err)
+	}
+
+	// the key is evicted again if it can't be stored, so that the handle
+	// isn't left in use by a key unknown to the storage
+	key.Handle = handle
+	defer func() {
+		if err != nil {
+			key.Handle = 0
+			_ = t.store.UpdateKey(key)
+			_ = tpm2.EvictControl(t.rwc, t.options.ownerPassword, tpm2.HandleOwner, h, h)
+		}
+	}()
+
+	if err := t.store.UpdateKey(key); err != nil {
+		return fmt.Errorf("failed updating key %q in storage: %w", name, err)
+	}
+
+	if err := t.store.Persist(); err != nil {
+		return fmt.Errorf("failed persisting key %q to storage: %w", name, err)
+	}
+
+	return
+}
+
+// EvictKey evicts the Key identified by `name` from its persistent handle.
+// The Key is kept in storage, and it's loaded on every signature again. It's
+// a no-op if the Key isn't persistent.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) EvictKey(ctx context.Context, name string) (err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	key, err := t.store.GetKey(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed getting key %q: %w", name, ErrNotFound)
+		}
+		return fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+	if key.Handle == 0 {
+		return nil
+	}
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed evicting key %q: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
+	// the object at the handle is only evicted if it's still the Key
+	h := tpmutil.Handle(key.Handle)
+	ok, err := internalkey.PersistedAt(t.rwc, key.Data, h)
+	if err != nil {
+		return fmt.Errorf("failed evicting key %q: %w", name, err)
+	}
+	if ok {
+		if err := tpm2.EvictControl(t.rwc, t.options.ownerPassword, tpm2.HandleOwner, h, h); err != nil {
+			return fmt.Errorf("failed evicting key %q: %w", name, err)
+		}
+	}
+
+	key.Handle = 0
+	if err := t.store.UpdateKey(key); err != nil {
+		return fmt.Errorf("failed updating key %q in storage: %w", name, err)
+	}
+
+	if err := t.store.Persist(); err != nil {
+		return fmt.Errorf("failed persisting key %q to storage: %w", name, err)
+	}
+
+	return
+}
+
+// PersistSRK makes the storage root key (SRK) persistent at the persistent
+// handle `handle`. Keys are always loaded under the SRK at the default
+// handle 0x81000001, but other software can use the handle as the parent of
+// the Keys exported using [Key.ToTSS2]. It returns `ErrExists` if an object
+// is already persisted at the handle.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) PersistSRK(ctx context.Context, handle uint32) (err error) {
+	h, err := persistentHandle(handle)
+	if err != nil {
+		return err
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed persisting SRK: %w", ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := t.checkPersistentHandleFree(h); err != nil {
+		return err
+	}
+
+	if err := internalkey.PersistSRK(t.rwc, h, t.options.ownerPassword); err != nil {
+		return fmt.Errorf("failed persisting SRK: %w", err)
+	}
+
+	return
+}
+
+// ListPersistentHandles returns the persistent handles in use in the TPM,
+// including the ones not created by this package.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ListPersistentHandles(ctx context.Context) (handles []uint32, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	next := uint32(tpm2.HandleTypePersistent) << 24
+	for {
+		values, more, err := tpm2.GetCapability(t.rwc, tpm2.CapabilityHandles, maxHandlesPerCapability, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting persistent handles: %w", err)
+		}
+		for _, v := range values {
+			h, ok := v.(tpmutil.Handle)
+			if !ok {
+				return nil, fmt.Errorf("unexpected capability value %T", v)
+			}
+			handles = append(handles, uint32(h))
+			next = uint32(h) + 1
+		}
+		if !more || len(values) == 0 {
+			break
+		}
+	}
+
+	return
+}
+
+// EvictPersistentHandle evicts the object persisted at the persistent handle
+// `handle`. If a Key is persisted at the handle, it's kept in storage, and
+// it's loaded on every signature again. It returns `ErrNotFound` if no
+// object is persisted at the handle.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) EvictPersistentHandle(ctx context.Context, handle uint32) (err error) {
+	h, err := persistentHandle(handle)
+	if err != nil {
+		return err
+	}
+
+	if t.options.encryptedSessions {
+		return fmt.Errorf("failed evicting persistent handle 0x%08x: %w", handle, ErrEncryptedSessionsNotSupported)
+	}
+
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := tpm2.EvictControl(t.rwc, t.options.ownerPassword, tpm2.HandleOwner, h, h); err != nil {
+		if isHandleError(err) {
+			return fmt.Errorf("failed evicting persistent handle 0x%08x: %w", handle, ErrNotFound)
+		}
+		return fmt.Errorf("failed evicting persistent handle 0x%08x: %w", handle, err)
+	}
+
+	keys, err := t.store.ListKeys()
+	if err != nil {
+		return fmt.Errorf("failed listing keys: %w", err)
+	}
+	for _, key := range keys {
+		if key.Handle != handle {
+			continue
+		}
+		key.Handle = 0
+		if err := t.store.UpdateKey(key); err != nil {
+			return fmt.Errorf("failed updating key %q in storage: %w", key.Name, err)
+		}
+		if err := t.store.Persist(); err != nil {
+			return fmt.Errorf("failed persisting key %q to storage: %w", key.Name, err)
+		}
+	}
+
+	return
+}
+
+// checkPersistentHandleFree returns `ErrExists` if an object is persisted at
+// the handle.
+func (t *TPM) checkPersistentHandleFree(handle tpmutil.Handle) error {
+	_, _, _, err := tpm2.ReadPublic(t.rwc, handle)
+	switch {
+	case err == nil:
+		return fmt.Errorf("persistent handle 0x%08x: %w", uint32(handle), ErrExists)
+	case isHandleError(err):
+		return nil
+	default:
+		return fmt.Errorf("failed reading persistent handle 0x%08x: %w", uint32(handle), err)
+	}
+}
+
+func isHandleError(err error) bool {
+	var herr tpm2.HandleError
+	return errors.As(err, &herr) && herr.Code == tpm2.RCHandle
+}
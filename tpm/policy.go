@@ -0,0 +1,414 @@
+package tpm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+)
+
+// KeyPolicy is the authorization policy of a Key. A Key with a policy can
+// only be used in a policy session that satisfies the policy.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type KeyPolicy struct {
+	// PCRs binds the Key to the values of the selected PCRs. The Key can
+	// only be used while the PCRs have the values they had when the Key
+	// was created.
+	PCRs *PCRSelection
+	// Password requires the Key password to be provided when the Key is
+	// used. Keys with a policy can't be used with just their password.
+	Password bool
+	// Authority is the public key that approves the policies that can be
+	// used with the Key. The Key can then be used with any ApprovedPolicy
+	// signed by the Authority, e.g. to allow the Key to be used after a
+	// firmware update. PCRs and Password can't be set if Authority is set;
+	// they're part of the ApprovedPolicy instead. RSA and ECDSA keys are
+	// supported.
+	Authority crypto.PublicKey
+	// PolicyRef is an optional value that qualifies the policies approved by
+	// the Authority.
+	PolicyRef []byte
+}
+
+// ApprovedPolicy is a policy approved by the Authority of a Key with an
+// authorized KeyPolicy. The Authority approves the policy by signing it
+// using [ApprovedPolicy.Sign].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type ApprovedPolicy struct {
+	// PCRs are the values the PCRs must have for the Key to be used. All
+	// PCRs must be in the same PCR bank.
+	PCRs []PCR
+	// Password requires the Key password to be provided when the Key is
+	// used.
+	Password bool
+	// Signature is the signature of the Authority over the policy.
+	Signature []byte
+}
+
+// Digest returns the policy digest of the ApprovedPolicy.
+func (p *ApprovedPolicy) Digest() ([]byte, error) {
+	sel, pcrDigest, err := p.pcrSelection()
+	if err != nil {
+		return nil, err
+	}
+	return internalkey.PolicyDigest(sel, pcrDigest, p.Password), nil
+}
+
+// Sign approves the policy for Keys with `authority` as their Authority and
+// the given policy reference, and sets its Signature.
+func (p *ApprovedPolicy) Sign(authority crypto.Signer, policyRef []byte) error {
+	switch authority.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("unsupported authority key type %T", authority.Public())
+	}
+
+	digest, err := p.Digest()
+	if err != nil {
+		return err
+	}
+
+	sig, err := authority.Sign(rand.Reader, internalkey.ApprovalDigest(digest, policyRef), crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed signing policy: %w", err)
+	}
+	p.Signature = sig
+
+	return nil
+}
+
+// pcrSelection returns the PCR selection of the ApprovedPolicy, and the
+// digest of the PCR values.
+func (p *ApprovedPolicy) pcrSelection() (tpm2.PCRSelection, []byte, error) {
+	if len(p.PCRs) == 0 {
+		return tpm2.PCRSelection{}, nil, nil
+	}
+
+	pcrs := slices.Clone(p.PCRs)
+	slices.SortFunc(pcrs, func(a, b PCR) int {
+		return a.Index - b.Index
+	})
+
+	sel := tpm2.PCRSelection{}
+	h := sha256.New()
+	for i, pcr := range pcrs {
+		if pcr.Hash != pcrs[0].Hash {
+			return sel, nil, errors.New("approved policy PCRs must be in the same PCR bank")
+		}
+		if pcr.Index < 0 || pcr.Index > 23 {
+			return sel, nil, fmt.Errorf("invalid PCR index %d", pcr.Index)
+		}
+		if i > 0 && pcr.Index == pcrs[i-1].Index {
+			return sel, nil, fmt.Errorf("duplicate PCR index %d", pcr.Index)
+		}
+		sel.PCRs = append(sel.PCRs, pcr.Index)
+		h.Write(pcr.Digest)
+	}
+
+	alg, err := tpm2.HashToAlgorithm(pcrs[0].Hash)
+	if err != nil {
+		return sel, nil, fmt.Errorf("invalid PCR hash %s: %w", pcrs[0].Hash, err)
+	}
+	sel.Hash = alg
+
+	return sel, h.Sum(nil), nil
+}
+
+// toInternal converts the ApprovedPolicy to the format used to sign with a
+// Key with the given authority.
+func (p *ApprovedPolicy) toInternal(authority tpm2.Public) (*internalkey.ApprovedPolicy, error) {
+	if len(p.Signature) == 0 {
+		return nil, errors.New("approved policy is not signed")
+	}
+	sel, pcrDigest, err := p.pcrSelection()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := authoritySignature(authority, p.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return &internalkey.ApprovedPolicy{
+		PCRs:      sel,
+		PCRDigest: pcrDigest,
+		Password:  p.Password,
+		Signature: sig,
+	}, nil
+}
+
+// serializedKeyPolicy is the format of the authorization policy of a Key
+// in storage.
+type serializedKeyPolicy struct {
+	Password       bool           `json:"password,omitempty"`
+	PCRs           []int          `json:"pcrs,omitempty"`
+	PCRHash        tpm2.Algorithm `json:"pcrHash,omitempty"`
+	PCRDigest      []byte         `json:"pcrDigest,omitempty"`
+	PolicyPassword bool           `json:"policyPassword,omitempty"`
+	Authority      []byte         `json:"authority,omitempty"`
+	PolicyRef      []byte         `json:"policyRef,omitempty"`
+}
+
+func unmarshalKeyPolicy(data []byte) (*serializedKeyPolicy, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	sp := &serializedKeyPolicy{}
+	if err := json.Unmarshal(data, sp); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling key policy: %w", err)
+	}
+	return sp, nil
+}
+
+// hasPolicy returns whether the Key has a policy, in addition to or instead
+// of its password.
+func (sp *serializedKeyPolicy) hasPolicy() bool {
+	return len(sp.PCRs) > 0 || sp.PolicyPassword || len(sp.Authority) > 0
+}
+
+func (sp *serializedKeyPolicy) pcrSelection() tpm2.PCRSelection {
+	return tpm2.PCRSelection{
+		Hash: sp.PCRHash,
+		PCRs: sp.PCRs,
+	}
+}
+
+func (sp *serializedKeyPolicy) authority() (*tpm2.Public, error) {
+	if len(sp.Authority) == 0 {
+		return nil, nil
+	}
+	pub, err := tpm2.DecodePublic(sp.Authority)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding policy authority: %w", err)
+	}
+	return &pub, nil
+}
+
+// config returns the policy configuration of the Key. It returns nil if the
+// Key doesn't have a policy.
+func (sp *serializedKeyPolicy) config() (*internalkey.PolicyConfig, error) {
+	if sp == nil || !sp.hasPolicy() {
+		return nil, nil
+	}
+	authority, err := sp.authority()
+	if err != nil {
+		return nil, err
+	}
+	return &internalkey.PolicyConfig{
+		PCRs:      sp.pcrSelection(),
+		PCRDigest: sp.PCRDigest,
+		Password:  sp.PolicyPassword,
+		Authority: authority,
+		PolicyRef: sp.PolicyRef,
+	}, nil
+}
+
+// newKeyPolicy returns the serialized policy for a Key created with the
+// configuration. The current values of the PCRs are read from the TPM, so
+// the TPM must be open. It returns nil if the Key doesn't require
+// authorization.
+func (t *TPM) newKeyPolicy(config CreateKeyConfig) (*serializedKeyPolicy, error) {
+	if config.Password == "" && config.Policy == nil {
+		return nil, nil
+	}
+	if config.Decrypt {
+		return nil, errors.New("password and policy are not supported for decryption keys")
+	}
+
+	sp := &serializedKeyPolicy{
+		Password: config.Password != "",
+	}
+
+	policy := config.Policy
+	if policy == nil {
+		return sp, nil
+	}
+
+	switch {
+	case policy.Password && config.Password == "":
+		return nil, errors.New("policy requires a password, but no password was provided")
+	case policy.Authority != nil && (policy.PCRs != nil || policy.Password):
+		return nil, errors.New("policy authority can't be combined with PCRs or password")
+	case policy.Authority != nil:
+		pub, err := authorityPublic(policy.Authority)
+		if err != nil {
+			return nil, err
+		}
+		if sp.Authority, err = pub.Encode(); err != nil {
+			return nil, fmt.Errorf("failed encoding policy authority: %w", err)
+		}
+		sp.PolicyRef = policy.PolicyRef
+		return sp, nil
+	case policy.PCRs == nil && !policy.Password:
+		return nil, errors.New("policy requires PCRs, password or authority")
+	}
+
+	sp.PolicyPassword = policy.Password
+	if policy.PCRs != nil {
+		indexes, err := policy.PCRs.indexes()
+		if err != nil {
+			return nil, err
+		}
+		hash := policy.PCRs.Hash
+		if hash == 0 {
+			hash = crypto.SHA256
+		}
+		if sp.PCRHash, err = tpm2.HashToAlgorithm(hash); err != nil {
+			return nil, fmt.Errorf("invalid PCR hash %s: %w", hash, err)
+		}
+		sp.PCRs = slices.Clone(indexes)
+		slices.Sort(sp.PCRs)
+		sp.PCRs = slices.Compact(sp.PCRs)
+		if sp.PCRDigest, err = internalkey.PCRDigest(t.rwc, sp.pcrSelection()); err != nil {
+			return nil, fmt.Errorf("failed reading PCRs: %w", err)
+		}
+	}
+
+	return sp, nil
+}
+
+// authorityPublic returns the TPM public area used to verify policies
+// approved by the authority with public key `pub`.
+func authorityPublic(pub crypto.PublicKey) (tpm2.Public, error) {
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		exponent := uint32(p.E)
+		if p.E == 65537 {
+			exponent = 0 // default exponent
+		}
+		return tpm2.Public{
+			Type:       tpm2.AlgRSA,
+			NameAlg:    tpm2.AlgSHA256,
+			Attributes: tpm2.FlagSign | tpm2.FlagUserWithAuth,
+			RSAParameters: &tpm2.RSAParams{
+				Sign: &tpm2.SigScheme{
+					Alg:  tpm2.AlgRSASSA,
+					Hash: tpm2.AlgSHA256,
+				},
+				KeyBits:     uint16(p.N.BitLen()),
+				ExponentRaw: exponent,
+				ModulusRaw:  p.N.Bytes(),
+			},
+		}, nil
+	case *ecdsa.PublicKey:
+		var curveID tpm2.EllipticCurve
+		switch p.Curve {
+		case elliptic.P256():
+			curveID = tpm2.CurveNISTP256
+		case elliptic.P384():
+			curveID = tpm2.CurveNISTP384
+		case elliptic.P521():
+			curveID = tpm2.CurveNISTP521
+		default:
+			return tpm2.Public{}, fmt.Errorf("unsupported authority curve %s", p.Curve.Params().Name)
+		}
+		size := (p.Curve.Params().BitSize + 7) / 8
+		return tpm2.Public{
+			Type:       tpm2.AlgECC,
+			NameAlg:    tpm2.AlgSHA256,
+			Attributes: tpm2.FlagSign | tpm2.FlagUserWithAuth,
+			ECCParameters: &tpm2.ECCParams{
+				Sign: &tpm2.SigScheme{
+					Alg:  tpm2.AlgECDSA,
+					Hash: tpm2.AlgSHA256,
+				},
+				CurveID: curveID,
+				Point: tpm2.ECPoint{
+					XRaw: p.X.FillBytes(make([]byte, size)),
+					YRaw: p.Y.FillBytes(make([]byte, size)),
+				},
+			},
+		}, nil
+	default:
+		return tpm2.Public{}, fmt.Errorf("unsupported authority key type %T", pub)
+	}
+}
+
+// authoritySignature converts a signature created by the authority to the
+// TPM format.
+func authoritySignature(authority tpm2.Public, sig []byte) (*tpm2.Signature, error) {
+	switch authority.Type {
+	case tpm2.AlgRSA:
+		return &tpm2.Signature{
+			Alg: tpm2.AlgRSASSA,
+			RSA: &tpm2.SignatureRSA{
+				HashAlg:   tpm2.AlgSHA256,
+				Signature: sig,
+			},
+		}, nil
+	case tpm2.AlgECC:
+		var esig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &esig); err != nil || len(rest) > 0 {
+			return nil, errors.New("failed parsing approved policy signature")
+		}
+		return &tpm2.Signature{
+			Alg: tpm2.AlgECDSA,
+			ECC: &tpm2.SignatureECC{
+				HashAlg: tpm2.AlgSHA256,
+				R:       esig.R,
+				S:       esig.S,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported authority type %s", authority.Type)
+	}
+}
+
+// signatureScheme returns the scheme used to sign with a key with public key
+// `pub`. ECDSA keys are created with a fixed scheme, so it returns nil for
+// them.
+func signatureScheme(pub crypto.PublicKey, opts crypto.SignerOpts) (*tpm2.SigScheme, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		hash, err := tpm2.HashToAlgorithm(opts.HashFunc())
+		if err != nil {
+			return nil, fmt.Errorf("unsupported hash %s: %w", opts.HashFunc(), err)
+		}
+		alg := tpm2.AlgRSASSA
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			alg = tpm2.AlgRSAPSS
+		}
+		return &tpm2.SigScheme{Alg: alg, Hash: hash}, nil
+	case *ecdsa.PublicKey:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// encodeSignature encodes a TPM signature in the format used by
+// crypto.Signer implementations.
+func encodeSignature(sig *tpm2.Signature) ([]byte, error) {
+	switch {
+	case sig.RSA != nil:
+		return bytes.Clone(sig.RSA.Signature), nil
+	case sig.ECC != nil:
+		return asn1.Marshal(struct {
+			R, S *big.Int
+		}{sig.ECC.R, sig.ECC.S})
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %s", sig.Alg)
+	}
+}
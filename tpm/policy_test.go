@@ -0,0 +1,155 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+)
+
+func TestApprovedPolicy_Digest(t *testing.T) {
+	pcr := func(index int, hash crypto.Hash) PCR {
+		return PCR{Index: index, Digest: make([]byte, hash.Size()), Hash: hash}
+	}
+
+	empty := make([]byte, 32)
+	ordered, err := (&ApprovedPolicy{PCRs: []PCR{pcr(7, crypto.SHA256), pcr(16, crypto.SHA256)}}).Digest()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *ApprovedPolicy
+		want    []byte
+		wantErr string
+	}{
+		{"ok/empty", &ApprovedPolicy{}, empty, ""},
+		{"ok/unordered", &ApprovedPolicy{PCRs: []PCR{pcr(16, crypto.SHA256), pcr(7, crypto.SHA256)}}, ordered, ""},
+		{"fail/mixed-banks", &ApprovedPolicy{PCRs: []PCR{pcr(7, crypto.SHA256), pcr(16, crypto.SHA1)}}, nil, "approved policy PCRs must be in the same PCR bank"},
+		{"fail/index", &ApprovedPolicy{PCRs: []PCR{pcr(24, crypto.SHA256)}}, nil, "invalid PCR index 24"},
+		{"fail/duplicate", &ApprovedPolicy{PCRs: []PCR{pcr(7, crypto.SHA256), pcr(7, crypto.SHA256)}}, nil, "duplicate PCR index 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Digest()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	withPassword, err := (&ApprovedPolicy{Password: true}).Digest()
+	require.NoError(t, err)
+	assert.NotEqual(t, empty, withPassword)
+}
+
+func TestApprovedPolicy_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	policy := &ApprovedPolicy{Password: true}
+	digest, err := policy.Digest()
+	require.NoError(t, err)
+	approval := internalkey.ApprovalDigest(digest, []byte("ref"))
+
+	require.NoError(t, policy.Sign(rsaKey, []byte("ref")))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, approval, policy.Signature))
+
+	require.NoError(t, policy.Sign(ecKey, []byte("ref")))
+	assert.True(t, ecdsa.VerifyASN1(&ecKey.PublicKey, approval, policy.Signature))
+
+	assert.EqualError(t, policy.Sign(edKey, nil), "unsupported authority key type ed25519.PublicKey")
+}
+
+func Test_authorityPublic(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pub, err := authorityPublic(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	key, err := pub.Key()
+	require.NoError(t, err)
+	assert.Equal(t, &rsaKey.PublicKey, key)
+
+	pub, err = authorityPublic(&ecKey.PublicKey)
+	require.NoError(t, err)
+	key, err = pub.Key()
+	require.NoError(t, err)
+	assert.True(t, ecKey.PublicKey.Equal(key))
+
+	_, err = authorityPublic(edKey.Public())
+	assert.EqualError(t, err, "unsupported authority key type ed25519.PublicKey")
+}
+
+func Test_authoritySignature(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecPub, err := authorityPublic(&ecKey.PublicKey)
+	require.NoError(t, err)
+
+	sig, err := ecKey.Sign(rand.Reader, make([]byte, 32), crypto.SHA256)
+	require.NoError(t, err)
+	got, err := authoritySignature(ecPub, sig)
+	require.NoError(t, err)
+	assert.Equal(t, tpm2.AlgECDSA, got.Alg)
+
+	encoded, err := encodeSignature(got)
+	require.NoError(t, err)
+	assert.Equal(t, sig, encoded)
+
+	_, err = authoritySignature(ecPub, []byte("not-asn1"))
+	assert.EqualError(t, err, "failed parsing approved policy signature")
+
+	got, err = authoritySignature(tpm2.Public{Type: tpm2.AlgRSA}, []byte{1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, &tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: []byte{1, 2, 3}}}, got)
+
+	_, err = encodeSignature(&tpm2.Signature{Alg: tpm2.AlgNull})
+	assert.Error(t, err)
+
+	encoded, err = encodeSignature(&tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{R: big.NewInt(1), S: big.NewInt(2)}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02}, encoded)
+}
+
+func Test_signatureScheme(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	got, err := signatureScheme(&rsaKey.PublicKey, crypto.SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256}, got)
+
+	got, err = signatureScheme(&rsaKey.PublicKey, &rsa.PSSOptions{Hash: crypto.SHA384})
+	require.NoError(t, err)
+	assert.Equal(t, &tpm2.SigScheme{Alg: tpm2.AlgRSAPSS, Hash: tpm2.AlgSHA384}, got)
+
+	got, err = signatureScheme(&ecKey.PublicKey, crypto.SHA256)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = signatureScheme(&rsaKey.PublicKey, crypto.MD5)
+	assert.Error(t, err)
+}
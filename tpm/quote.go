@@ -0,0 +1,241 @@
+package tpm
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/smallstep/go-attestation/attest"
+)
+
+// PCR is the value of a Platform Configuration Register (PCR) in the
+// PCR bank for a specific hash algorithm.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type PCR struct {
+	// Index is the index of the PCR.
+	Index int
+	// Digest is the value of the PCR.
+	Digest []byte
+	// Hash is the hash algorithm of the PCR bank.
+	Hash crypto.Hash
+}
+
+// PCRSelection selects a set of PCRs in the PCR bank for a specific hash
+// algorithm.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type PCRSelection struct {
+	// Hash is the hash algorithm of the PCR bank. It defaults to SHA256.
+	// Only SHA1 and SHA256 are supported.
+	Hash crypto.Hash
+	// PCRs is the list of PCR indexes to select. If empty, all 24 PCRs
+	// are selected.
+	PCRs []int
+}
+
+// Quote is the result of a TPM2_Quote operation: a signed attestation of
+// the values of a selection of PCRs, created using an AK.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Quote struct {
+	// Quote is the TPMS_ATTEST structure that was signed.
+	Quote []byte
+	// Signature is the TPMT_SIGNATURE over Quote.
+	Signature []byte
+}
+
+// hashAlg returns the hash algorithm of the selection, in the format
+// used by go-attestation.
+func (s PCRSelection) hashAlg() (attest.HashAlg, error) {
+	switch s.Hash {
+	case 0, crypto.SHA256:
+		return attest.HashSHA256, nil
+	case crypto.SHA1:
+		return attest.HashSHA1, nil
+	default:
+		return 0, fmt.Errorf("unsupported PCR hash %s", s.Hash)
+	}
+}
+
+// indexes returns the selected PCR indexes. All 24 PCRs are returned if
+// none are selected.
+func (s PCRSelection) indexes() ([]int, error) {
+	if len(s.PCRs) == 0 {
+		pcrs := make([]int, 24)
+		for i := range pcrs {
+			pcrs[i] = i
+		}
+		return pcrs, nil
+	}
+	for _, pcr := range s.PCRs {
+		if pcr < 0 || pcr > 23 {
+			return nil, fmt.Errorf("invalid PCR index %d", pcr)
+		}
+	}
+	return s.PCRs, nil
+}
+
+// ReadPCRs returns the current values of the PCRs in `selection`. The
+// PCRs are returned in order of their index.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ReadPCRs(ctx context.Context, selection PCRSelection) (pcrs []PCR, err error) {
+	alg, err := selection.hashAlg()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := selection.indexes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = t.open(ctx); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	all, err := t.attestTPM.PCRs(alg)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading PCRs: %w", err)
+	}
+
+	selected := make(map[int]bool, len(indexes))
+	for _, index := range indexes {
+		selected[index] = true
+	}
+
+	pcrs = make([]PCR, 0, len(indexes))
+	for _, pcr := range all {
+		if selected[pcr.Index] {
+			pcrs = append(pcrs, PCR{
+				Index:  pcr.Index,
+				Digest: pcr.Digest,
+				Hash:   pcr.DigestAlg,
+			})
+		}
+	}
+
+	return
+}
+
+// Quote returns a Quote over the PCRs in `selection`, signed by the AK. The
+// nonce is included in the Quote to prevent replays. Some TPMs don't support
+// nonces longer than 20 bytes, so the nonce should be hashed if it's used to
+// bind additional data to the Quote.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (ak *AK) Quote(ctx context.Context, nonce []byte, selection PCRSelection) (quote *Quote, err error) {
+	alg, err := selection.hashAlg()
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := selection.indexes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ak.tpm.open(ctx); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, ak.tpm, &err)
+
+	loadedAK, err := ak.tpm.attestTPM.LoadAK(ak.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading AK %q: %w", ak.name, err)
+	}
+	defer loadedAK.Close(ak.tpm.attestTPM)
+
+	q, err := loadedAK.QuotePCRs(ak.tpm.attestTPM, nonce, alg, indexes)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating quote with AK %q: %w", ak.name, err)
+	}
+
+	return &Quote{
+		Quote:     q.Quote,
+		Signature: q.Signature,
+	}, nil
+}
+
+// VerifyQuote verifies that `quote` was signed by the AK with public key
+// `akPublic`, that it contains `nonce`, and that the PCR digest in the
+// Quote matches the values of `pcrs`. The PCRs must contain exactly the PCRs
+// the Quote was created over; PCRs in other PCR banks are ignored. Only RSA
+// AKs are supported.
+//
+// VerifyQuote doesn't verify that the AK belongs to a genuine TPM. That
+// has to be established separately, e.g. using credential activation or
+// by verifying the AK certificate.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func VerifyQuote(akPublic crypto.PublicKey, quote *Quote, nonce []byte, pcrs []PCR) error {
+	switch {
+	case akPublic == nil:
+		return errors.New("AK public key is required")
+	case quote == nil:
+		return errors.New("quote is required")
+	case len(nonce) == 0:
+		return errors.New("nonce is required")
+	}
+
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(quote.Signature))
+	if err != nil {
+		return fmt.Errorf("failed decoding quote signature: %w", err)
+	}
+	if sig.RSA == nil {
+		return fmt.Errorf("unsupported quote signature algorithm %s", sig.Alg)
+	}
+	hash, err := sig.RSA.HashAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("unsupported quote signature hash: %w", err)
+	}
+
+	akp := &attest.AKPublic{
+		Public: akPublic,
+		Hash:   hash,
+	}
+	q := attest.Quote{
+		Version:   attest.TPMVersion20,
+		Quote:     quote.Quote,
+		Signature: quote.Signature,
+	}
+	if err := akp.Verify(q, toAttestPCRs(pcrs), nonce); err != nil {
+		return fmt.Errorf("failed verifying quote: %w", err)
+	}
+
+	return nil
+}
+
+// toAttestPCRs converts PCRs to the format used by go-attestation.
+func toAttestPCRs(pcrs []PCR) []attest.PCR {
+	out := make([]attest.PCR, 0, len(pcrs))
+	for _, pcr := range pcrs {
+		out = append(out, attest.PCR{
+			Index:     pcr.Index,
+			Digest:    pcr.Digest,
+			DigestAlg: pcr.Hash,
+		})
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/smallstep/go-attestation/attest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPCRSelection_hashAlg(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    crypto.Hash
+		want    attest.HashAlg
+		wantErr bool
+	}{
+		{"ok/default", 0, attest.HashSHA256, false},
+		{"ok/sha256", crypto.SHA256, attest.HashSHA256, false},
+		{"ok/sha1", crypto.SHA1, attest.HashSHA1, false},
+		{"fail/sha384", crypto.SHA384, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PCRSelection{Hash: tt.hash}.hashAlg()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPCRSelection_indexes(t *testing.T) {
+	all := make([]int, 24)
+	for i := range all {
+		all[i] = i
+	}
+
+	tests := []struct {
+		name    string
+		pcrs    []int
+		want    []int
+		wantErr bool
+	}{
+		{"ok/all", nil, all, false},
+		{"ok/some", []int{0, 7, 16}, []int{0, 7, 16}, false},
+		{"fail/negative", []int{-1}, nil, true},
+		{"fail/too-large", []int{24}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PCRSelection{PCRs: tt.pcrs}.indexes()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVerifyQuote(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		akPublic  crypto.PublicKey
+		quote     *Quote
+		nonce     []byte
+		expErrMsg string
+	}{
+		{"fail/no-ak", nil, &Quote{}, []byte("nonce"), "AK public key is required"},
+		{"fail/no-quote", key.Public(), nil, []byte("nonce"), "quote is required"},
+		{"fail/no-nonce", key.Public(), &Quote{}, nil, "nonce is required"},
+		{"fail/invalid-signature", key.Public(), &Quote{Signature: []byte{1, 2, 3}}, []byte("nonce"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyQuote(tt.akPublic, tt.quote, tt.nonce, nil)
+			if tt.expErrMsg != "" {
+				assert.EqualError(t, err, tt.expErrMsg)
+				return
+			}
+			assert.Error(t, err)
+		})
+	}
+}
@@ -6,11 +6,13 @@ package rand
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.step.sm/crypto/randutil"
 	"go.step.sm/crypto/tpm"
 	"go.step.sm/crypto/tpm/simulator"
 )
@@ -50,3 +52,19 @@ func TestNew(t *testing.T) {
 		require.Equal(t, 256, rsaKey.Size()) // 2048 bits; 256 bytes expected to have been read
 	}
 }
+
+func TestNew_drbg(t *testing.T) {
+	r, err := New(withSimulator(t))
+	require.NoError(t, err)
+
+	drbg, err := randutil.NewCTRDRBG(
+		randutil.WithEntropySource(randutil.EntropySource{Name: "tpm", Reader: r}),
+		randutil.WithEntropySource(randutil.EntropySource{Name: "os", Reader: rand.Reader}),
+		randutil.WithReseedInterval(1),
+	)
+	require.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), drbg)
+	require.NoError(t, err)
+	require.NotNil(t, ecdsaKey)
+}
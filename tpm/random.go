@@ -31,8 +31,16 @@ func (t *TPM) GenerateRandom(ctx context.Context, size uint16) (random []byte, e
 	return t.generateRandom(ctx, size)
 }
 
-func (t *TPM) generateRandom(_ context.Context, size uint16) (random []byte, err error) {
-	random, err = tpm2.GetRandom(t.rwc, size)
+func (t *TPM) generateRandom(ctx context.Context, size uint16) (random []byte, err error) {
+	session, err := t.encryptedSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		random, err = session.GetRandom(t.rwc, size)
+	} else {
+		random, err = tpm2.GetRandom(t.rwc, size)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed generating random data: %w", err)
 	}
@@ -0,0 +1,338 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+// Sealed models a TPM 2.0 sealed data object. The data in a Sealed
+// object can only be unsealed by the TPM that sealed it, optionally
+// only when the PCRs it's bound to have the values they had when the
+// data was sealed.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type Sealed struct {
+	name      string
+	data      []byte
+	createdAt time.Time
+	tpm       *TPM
+}
+
+// Name returns the Sealed data object name. The name uniquely
+// identifies the object if a TPM with persistent storage is used.
+func (s *Sealed) Name() string {
+	return s.name
+}
+
+// CreatedAt returns the creation time of the Sealed data object.
+func (s *Sealed) CreatedAt() time.Time {
+	return s.createdAt.Truncate(time.Second)
+}
+
+// PCRs returns the indexes of the PCRs the Sealed data object is
+// bound to. It returns an empty slice if the object isn't bound to
+// any PCR.
+func (s *Sealed) PCRs() []int {
+	ss, err := unmarshalSealed(s.data)
+	if err != nil {
+		return nil
+	}
+	return ss.PCRs
+}
+
+// Unseal returns the data sealed in the Sealed data object.
+func (s *Sealed) Unseal(ctx context.Context, password string) ([]byte, error) {
+	return s.tpm.Unseal(ctx, s.name, password)
+}
+
+// SealConfig is used to pass configuration when sealing data.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type SealConfig struct {
+	// PCRs is the list of PCR indexes the sealed data is bound to. If
+	// set, the data can only be unsealed when the PCRs have the same
+	// values they have when the data is sealed.
+	PCRs []int
+	// PCRHash is the hash algorithm of the PCR bank to use. It defaults
+	// to SHA256.
+	PCRHash crypto.Hash
+	// Password is the password required to unseal the data. If PCRs are
+	// set, both the password and the PCR values are required.
+	Password string
+}
+
+// serializedSealed is the format of the data of a Sealed data object.
+type serializedSealed struct {
+	Public   []byte         `json:"public"`
+	Private  []byte         `json:"private"`
+	PCRs     []int          `json:"pcrs,omitempty"`
+	PCRHash  tpm2.Algorithm `json:"pcrHash,omitempty"`
+	Password bool           `json:"password,omitempty"`
+}
+
+func unmarshalSealed(data []byte) (*serializedSealed, error) {
+	ss := &serializedSealed{}
+	if err := json.Unmarshal(data, ss); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling sealed data: %w", err)
+	}
+	return ss, nil
+}
+
+func (ss *serializedSealed) config(password string) internalkey.SealConfig {
+	config := internalkey.SealConfig{
+		Password: password,
+	}
+	if len(ss.PCRs) > 0 {
+		config.PCRs = tpm2.PCRSelection{
+			Hash: ss.PCRHash,
+			PCRs: ss.PCRs,
+		}
+	}
+	return config
+}
+
+// Seal seals `data` in a new Sealed data object identified by `name`. If
+// no name is provided, a random 10 character name is generated. If a
+// Sealed data object with the same name exists, `ErrExists` is returned.
+// At most 128 bytes of data can be sealed.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) Seal(ctx context.Context, name string, data []byte, config SealConfig) (sealed *Sealed, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	now := time.Now()
+	if name, err = processName(name); err != nil {
+		return nil, err
+	}
+
+	_, err = t.store.GetSealed(name)
+	switch {
+	case err == nil:
+		return nil, fmt.Errorf("failed sealing data %q: %w", name, ErrExists)
+	case errors.Is(err, storage.ErrNoStorageConfigured):
+		return nil, fmt.Errorf("failed sealing data %q: %w", name, err)
+	}
+
+	ss := &serializedSealed{
+		Password: config.Password != "",
+	}
+	if len(config.PCRs) > 0 {
+		hash := config.PCRHash
+		if hash == 0 {
+			hash = crypto.SHA256
+		}
+		if ss.PCRHash, err = tpm2.HashToAlgorithm(hash); err != nil {
+			return nil, fmt.Errorf("invalid PCR hash %s: %w", hash, err)
+		}
+		for _, pcr := range config.PCRs {
+			if pcr < 0 || pcr > 23 {
+				return nil, fmt.Errorf("invalid PCR index %d", pcr)
+			}
+		}
+		ss.PCRs = slices.Clone(config.PCRs)
+		slices.Sort(ss.PCRs)
+		ss.PCRs = slices.Compact(ss.PCRs)
+	}
+
+	sealConfig := ss.config(config.Password)
+	if sealConfig.Session, err = t.encryptedSession(ctx); err != nil {
+		return nil, fmt.Errorf("failed sealing data %q: %w", name, err)
+	}
+	// unsealing would require sending the password in the clear
+	if sealConfig.Session != nil && ss.Password && len(ss.PCRs) > 0 {
+		return nil, fmt.Errorf("failed sealing data %q with PCRs and a password: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
+	if ss.Public, ss.Private, err = internalkey.Seal(t.rwc, data, sealConfig); err != nil {
+		return nil, fmt.Errorf("failed sealing data %q: %w", name, err)
+	}
+
+	sdata, err := json.Marshal(ss)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling sealed data %q: %w", name, err)
+	}
+
+	sealed = &Sealed{
+		name:      name,
+		data:      sdata,
+		createdAt: now,
+		tpm:       t,
+	}
+
+	if err := t.store.AddSealed(sealed.toStorage()); err != nil {
+		return nil, fmt.Errorf("failed adding sealed data %q to storage: %w", name, err)
+	}
+
+	if err := t.store.Persist(); err != nil {
+		return nil, fmt.Errorf("failed persisting sealed data %q to storage: %w", name, err)
+	}
+
+	return
+}
+
+// Unseal returns the data sealed in the Sealed data object identified by
+// `name`. It returns `ErrNotFound` if it doesn't exist.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) Unseal(ctx context.Context, name, password string) (data []byte, err error) {
+	if err = t.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	sealed, err := t.store.GetSealed(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed getting sealed data %q: %w", name, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed getting sealed data %q: %w", name, err)
+	}
+
+	ss, err := unmarshalSealed(sealed.Data)
+	if err != nil {
+		return nil, err
+	}
+	if ss.Password && password == "" {
+		return nil, fmt.Errorf("failed unsealing data %q: password is required", name)
+	}
+	if !ss.Password && len(ss.PCRs) > 0 {
+		password = ""
+	}
+
+	sealConfig := ss.config(password)
+	if sealConfig.Session, err = t.encryptedSession(ctx); err != nil {
+		return nil, fmt.Errorf("failed unsealing data %q: %w", name, err)
+	}
+	if sealConfig.Session != nil && ss.Password && len(ss.PCRs) > 0 {
+		return nil, fmt.Errorf("failed unsealing data %q with PCRs and a password: %w", name, ErrEncryptedSessionsNotSupported)
+	}
+
+	if data, err = internalkey.Unseal(t.rwc, ss.Public, ss.Private, sealConfig); err != nil {
+		return nil, fmt.Errorf("failed unsealing data %q: %w", name, err)
+	}
+
+	return
+}
+
+// GetSealed returns the Sealed data object identified by `name`. It
+// returns `ErrNotFound` if it doesn't exist.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetSealed(ctx context.Context, name string) (sealed *Sealed, err error) {
+	if err = t.open(ctx); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	ss, err := t.store.GetSealed(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("failed getting sealed data %q: %w", name, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed getting sealed data %q: %w", name, err)
+	}
+
+	return sealedFromStorage(ss, t), nil
+}
+
+// ListSealed returns a slice of Sealed data objects. The result is
+// (currently) not ordered.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) ListSealed(ctx context.Context) (sealed []*Sealed, err error) {
+	if err = t.open(ctx); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	ss, err := t.store.ListSealed()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing sealed data: %w", err)
+	}
+
+	sealed = make([]*Sealed, 0, len(ss))
+	for _, s := range ss {
+		sealed = append(sealed, sealedFromStorage(s, t))
+	}
+
+	return
+}
+
+// DeleteSealed removes the Sealed data object identified by `name`. It
+// returns `ErrNotFound` if it doesn't exist.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) DeleteSealed(ctx context.Context, name string) (err error) {
+	if err := t.open(ctx); err != nil {
+		return fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	if err := t.store.DeleteSealed(name); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed deleting sealed data %q: %w", name, ErrNotFound)
+		}
+		return fmt.Errorf("failed deleting sealed data %q from storage: %w", name, err)
+	}
+
+	if err := t.store.Persist(); err != nil {
+		return fmt.Errorf("failed persisting storage: %w", err)
+	}
+
+	return
+}
+
+// toStorage transforms the Sealed data object to the struct used for
+// persisting Sealed data objects.
+func (s *Sealed) toStorage() *storage.Sealed {
+	return &storage.Sealed{
+		Name:      s.name,
+		Data:      s.data,
+		CreatedAt: s.createdAt.UTC(),
+	}
+}
+
+// sealedFromStorage recreates a Sealed data object from the struct used
+// for persisting Sealed data objects.
+func sealedFromStorage(ss *storage.Sealed, t *TPM) *Sealed {
+	return &Sealed{
+		name:      ss.Name,
+		data:      ss.Data,
+		createdAt: ss.CreatedAt.Local(),
+		tpm:       t,
+	}
+}
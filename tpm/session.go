@@ -0,0 +1,68 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+)
+
+// encryptedSession returns the Session used to start encrypted sessions,
+// or nil if encrypted sessions are not required. The Session is created on
+// first use, and the EK used to salt sessions is fixed for the lifetime of
+// the TPM instance. It must be called with the TPM opened for go-tpm calls.
+//
+// If neither [WithEKPublicKey] nor [WithEKVerifier] is used, the EK read
+// from the TPM on first use is trusted.
+func (t *TPM) encryptedSession(ctx context.Context) (*internalkey.Session, error) {
+	if !t.options.encryptedSessions {
+		return nil, nil //nolint:nilnil // a nil Session disables encrypted sessions
+	}
+	if t.session == nil {
+		session, err := internalkey.NewSession(t.rwc, t.ekVerifyFunc(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed starting encrypted session: %w", err)
+		}
+		t.session = session
+	}
+	return t.session, nil
+}
+
+// ekVerifyFunc returns the function used to verify the EK before it's used
+// to salt sessions, or nil if the EK is trusted on first use.
+func (t *TPM) ekVerifyFunc(ctx context.Context) func(crypto.PublicKey) error {
+	switch {
+	case t.options.ekPublicKey != nil:
+		return func(pub crypto.PublicKey) error {
+			if !equalPublicKeys(t.options.ekPublicKey, pub) {
+				return errors.New("EK public key does not match the configured EK public key")
+			}
+			return nil
+		}
+	case t.options.ekVerifier != nil:
+		return func(pub crypto.PublicKey) error {
+			cert, err := internalkey.ReadEKCertificate(t.rwc, pub)
+			if err != nil {
+				return fmt.Errorf("failed reading EK certificate: %w", err)
+			}
+			if !equalPublicKeys(cert.PublicKey, pub) {
+				return errors.New("EK certificate public key does not match the EK")
+			}
+			if _, err := t.options.ekVerifier.Verify(ctx, cert); err != nil {
+				return err
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+}
+
+func equalPublicKeys(a, b crypto.PublicKey) bool {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); ok {
+		return k.Equal(b)
+	}
+	return false
+}
@@ -7,15 +7,57 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
 	"go.step.sm/crypto/tpm/storage"
 	"go.step.sm/crypto/tpm/tss2"
 )
 
 // signer implements crypto.Signer backed by a TPM key.
 type signer struct {
-	tpm    *TPM
-	key    Key
-	public crypto.PublicKey
+	tpm      *TPM
+	key      Key
+	public   crypto.PublicKey
+	policy   *serializedKeyPolicy
+	password string
+	approved *ApprovedPolicy
+}
+
+// SignerOption is used to provide options when getting a crypto.Signer
+// for a Key.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type SignerOption func(s *signer)
+
+// WithKeyPassword sets the password used to sign with a Key created with a
+// password.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WithKeyPassword(password string) SignerOption {
+	return func(s *signer) {
+		s.password = password
+	}
+}
+
+// WithApprovedPolicy sets the policy used to sign with a Key with an
+// authorized policy. The policy must be signed by the authority of the Key.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WithApprovedPolicy(policy *ApprovedPolicy) SignerOption {
+	return func(s *signer) {
+		s.approved = policy
+	}
 }
 
 // Public returns the signers public key.
@@ -25,8 +67,12 @@ func (s *signer) Public() crypto.PublicKey {
 
 // Sign implements crypto.Signer. It is backed by a TPM key.
 // The TPM key is loaded lazily, meaning that every call to Sign()
-// will reload the TPM key to be used.
+// will reload the TPM key to be used, unless the Key is persistent.
 func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	if s.policy != nil || s.key.handle != 0 || s.tpm.options.encryptedSessions {
+		return s.signWithGoTPM(digest, opts)
+	}
+
 	ctx := context.Background()
 	if err = s.tpm.open(ctx); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
@@ -53,8 +99,58 @@ func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (si
 	return signer.Sign(rand, digest, opts)
 }
 
-// GetSigner returns a crypto.Signer for a TPM Key identified by `name`.
-func (t *TPM) GetSigner(ctx context.Context, name string) (csigner crypto.Signer, err error) {
+// signWithGoTPM signs the digest with a Key that requires a password or a
+// policy session, with a persistent Key, or using an encrypted session.
+func (s *signer) signWithGoTPM(digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	scheme, err := signatureScheme(s.public, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := s.policy.config()
+	if err != nil {
+		return nil, err
+	}
+
+	var approved *internalkey.ApprovedPolicy
+	if s.approved != nil && config != nil && config.Authority != nil {
+		if approved, err = s.approved.toInternal(*config.Authority); err != nil {
+			return nil, fmt.Errorf("invalid approved policy: %w", err)
+		}
+	}
+
+	ctx := context.Background()
+	if err = s.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, s.tpm, &err)
+
+	session, err := s.tpm.encryptedSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed signing with key %q: %w", s.key.name, err)
+	}
+
+	var sig *tpm2.Signature
+	switch {
+	case session != nil && config != nil:
+		// policy sessions are started using the legacy API
+		return nil, fmt.Errorf("failed signing with key %q with a policy: %w", s.key.name, ErrEncryptedSessionsNotSupported)
+	case session != nil:
+		sig, err = session.Sign(s.tpm.rwc, s.key.data, tpmutil.Handle(s.key.handle), s.password, digest, scheme)
+	default:
+		sig, err = internalkey.Sign(s.tpm.rwc, s.key.data, tpmutil.Handle(s.key.handle), s.password, config, approved, digest, scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed signing with key %q: %w", s.key.name, err)
+	}
+
+	return encodeSignature(sig)
+}
+
+// GetSigner returns a crypto.Signer for a TPM Key identified by `name`. The
+// password and the approved policy required by Keys with a password or
+// policy can be provided using [WithKeyPassword] and [WithApprovedPolicy].
+func (t *TPM) GetSigner(ctx context.Context, name string, opts ...SignerOption) (csigner crypto.Signer, err error) {
 	if err = t.open(ctx); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -68,26 +164,49 @@ func (t *TPM) GetSigner(ctx context.Context, name string) (csigner crypto.Signer
 		return nil, fmt.Errorf("failed getting signer for key %q: %w", name, err)
 	}
 
-	loadedKey, err := t.attestTPM.LoadKey(key.Data)
-	if err != nil {
-		return nil, err
-	}
-	defer loadedKey.Close()
+	// persistent Keys aren't loaded; the public key is read from the Key data
+	var public crypto.PublicKey
+	if key.Handle != 0 {
+		pub, err := internalkey.Public(key.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed getting signer for key %q: %w", name, err)
+		}
+		if public, err = pub.Key(); err != nil {
+			return nil, fmt.Errorf("failed getting signer for key %q: %w", name, err)
+		}
+	} else {
+		loadedKey, err := t.attestTPM.LoadKey(key.Data)
+		if err != nil {
+			return nil, err
+		}
+		defer loadedKey.Close()
 
-	priv, err := loadedKey.Private(loadedKey.Public())
-	if err != nil {
-		return nil, fmt.Errorf("failed getting TPM private key %q: %w", name, err)
+		priv, err := loadedKey.Private(loadedKey.Public())
+		if err != nil {
+			return nil, fmt.Errorf("failed getting TPM private key %q: %w", name, err)
+		}
+
+		if _, ok := priv.(crypto.Signer); !ok {
+			return nil, fmt.Errorf("failed getting TPM private key %q as crypto.Signer", name)
+		}
+		public = loadedKey.Public()
 	}
 
-	if _, ok := priv.(crypto.Signer); !ok {
-		return nil, fmt.Errorf("failed getting TPM private key %q as crypto.Signer", name)
+	policy, err := unmarshalKeyPolicy(key.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting signer for key %q: %w", name, err)
 	}
 
-	csigner = &signer{
+	s := &signer{
 		tpm:    t,
-		key:    Key{name: name, data: key.Data, attestedBy: key.AttestedBy, createdAt: key.CreatedAt, tpm: t},
-		public: loadedKey.Public(),
+		key:    Key{name: name, data: key.Data, attestedBy: key.AttestedBy, createdAt: key.CreatedAt, policy: key.Policy, handle: key.Handle, tpm: t},
+		public: public,
+		policy: policy,
 	}
+	for _, fn := range opts {
+		fn(s)
+	}
+	csigner = s
 
 	return
 }
@@ -110,8 +229,51 @@ func (s *tss2Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts)
 	return
 }
 
-// CreateTSS2Signer returns a crypto.Signer using the given [TPM] and [tss2.TPMKey].
-func CreateTSS2Signer(ctx context.Context, t *TPM, key *tss2.TPMKey) (csigner crypto.Signer, err error) {
+// TSS2SignerOption is used to provide options when creating a crypto.Signer
+// for a [tss2.TPMKey].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type TSS2SignerOption func(s *tss2.Signer)
+
+// WithTSS2KeyHandle sets the persistent handle the [tss2.TPMKey] is persisted
+// at, e.g. using [TPM.PersistKey]. The persistent key is used for signing
+// instead of loading the key on every signature.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WithTSS2KeyHandle(handle uint32) TSS2SignerOption {
+	return func(s *tss2.Signer) {
+		s.SetKeyHandle(tpmutil.Handle(handle))
+	}
+}
+
+// WithTSS2Password sets the password of the [tss2.TPMKey]. It's required for
+// keys with a password, and for keys with a policy that requires it.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WithTSS2Password(password string) TSS2SignerOption {
+	return func(s *tss2.Signer) {
+		s.SetPassword(password)
+	}
+}
+
+// CreateTSS2Signer returns a crypto.Signer using the given [TPM] and
+// [tss2.TPMKey]. Loadable and importable keys are supported. If the key has a
+// policy, it's satisfied in a policy session before signing; keys with an
+// authorized policy use the signed policies included in the key.
+func CreateTSS2Signer(ctx context.Context, t *TPM, key *tss2.TPMKey, opts ...TSS2SignerOption) (csigner crypto.Signer, err error) {
+	if t.options.encryptedSessions {
+		return nil, fmt.Errorf("failed creating TSS2 signer: %w", ErrEncryptedSessionsNotSupported)
+	}
+
 	if err := t.open(goTPMCall(ctx)); err != nil {
 		return nil, fmt.Errorf("failed opening TPM: %w", err)
 	}
@@ -121,6 +283,9 @@ func CreateTSS2Signer(ctx context.Context, t *TPM, key *tss2.TPMKey) (csigner cr
 	if err != nil {
 		return nil, fmt.Errorf("failed creating TSS2 signer: %w", err)
 	}
+	for _, fn := range opts {
+		fn(s)
+	}
 
 	csigner = &tss2Signer{
 		Signer: s,
@@ -1,13 +1,30 @@
-//nolint:unused // ignore unused types for now
+// Package skae implements the TCG Subject Key Attestation Evidence (SKAE)
+// X.509 extension. The extension embeds the evidence that the subject key
+// of a certificate was certified by a TPM AK, and a reference to the
+// certificate of the AK.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
 package skae
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
+	"fmt"
 	"math/big"
 
+	"github.com/google/go-tpm/legacy/tpm2"
 	"github.com/smallstep/go-attestation/attest"
 )
 
@@ -15,104 +32,560 @@ var (
 	oidSubjectKeyAttestationEvidence = asn1.ObjectIdentifier{2, 23, 133, 6, 1, 1} // SKAE (Subject Key Attestation Evidence) OID: 2.23.133.6.1.1
 	oidAuthorityInfoAccessOcsp       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1}
 	oidAuthorityInfoAccessIssuers    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 2}
+	oidAES256GCM                     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	oidRSAESOAEP                     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1                          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA256                        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
 )
 
-func CreateSubjectKeyAttestationEvidenceExtension(_ *x509.Certificate, _ attest.CertificationParameters, _ bool) (pkix.Extension, error) {
-	return pkix.Extension{}, errors.New("not implemented yet") // return early; not verified to be working as expected yet
+const (
+	// tagAttestEvidence and tagEnvelopedAttestEvidence are the tags of the
+	// KeyAttestationEvidence CHOICE.
+	tagAttestEvidence          = 0
+	tagEnvelopedAttestEvidence = 1
 
-	/*
-		asn1Issuer, err := asn1.Marshal(akCert.Issuer.ToRDNSequence()) //nolint:govet // intentionally breaking early
-		if err != nil {
-			return pkix.Extension{}, fmt.Errorf("error marshaling issuer: %w", err)
-		}
+	// tagURI is the tag of the uniformResourceIdentifier GeneralName.
+	tagURI = 6
 
-		skaeExtension := asn1SKAE{
-			TCGSpecVersion:         asn1TCGSpecVersion{Major: 2, Minor: 0},
-			KeyAttestationEvidence: asn1KeyAttestationEvidence{},
-		}
+	// tpmGeneratedMagic is the magic value of TPMS_ATTEST structures
+	// created by a TPM.
+	tpmGeneratedMagic = 0xff544347
+)
+
+// ErrNotFound is returned when a certificate doesn't have the SKAE
+// extension.
+var ErrNotFound = errors.New("SKAE extension not found")
+
+// SubjectKeyAttestationEvidence is the decoded SKAE extension. Either
+// AttestationEvidence or EnvelopedAttestationEvidence is set.
+type SubjectKeyAttestationEvidence struct {
+	// Major and Minor are the TCG specification version.
+	Major, Minor int
+	// AttestationEvidence is the plain evidence.
+	AttestationEvidence *AttestationEvidence
+	// EnvelopedAttestationEvidence is the encrypted evidence.
+	EnvelopedAttestationEvidence *EnvelopedAttestationEvidence
+}
+
+// AttestationEvidence is the evidence that a key was certified by a TPM AK.
+type AttestationEvidence struct {
+	// CertifyInfo is the TPMS_ATTEST structure created by TPM2_Certify.
+	CertifyInfo []byte
+	// Signature is the TPMT_SIGNATURE of the AK over CertifyInfo.
+	Signature []byte
+	// OCSPServer and IssuingCertificateURL are the AIA of the AK
+	// certificate.
+	OCSPServer            []string
+	IssuingCertificateURL []string
+	// RawIssuer and SerialNumber identify the AK certificate.
+	RawIssuer    []byte
+	SerialNumber *big.Int
+}
 
-		attestationEvidence := asn1AttestationEvidence{
-			TPMCertifyInfo: asn1TPMCertifyInfo{
-				CertifyInfo: asn1.BitString{ // TODO: check if setting the values like this is correct
-					Bytes:     params.CreateAttestation,
-					BitLength: len(params.CreateAttestation) * 8,
-				},
-				Signature: asn1.BitString{
-					Bytes:     params.CreateSignature,
-					BitLength: len(params.CreateSignature) * 8,
-				},
+// EnvelopedAttestationEvidence is the AttestationEvidence encrypted to one
+// or more recipients. It can be decrypted using [EnvelopedAttestationEvidence.Decrypt].
+type EnvelopedAttestationEvidence struct {
+	eae asn1EnvelopedAttestationEvidence
+}
+
+// CreateSubjectKeyAttestationEvidenceExtension creates the SKAE extension
+// for a key certified by the AK with certificate `akCert`, using the key
+// certification parameters `params`. If recipients are provided, the
+// evidence is encrypted to them, and can only be read by the holders of
+// their private keys. Only RSA recipients are supported.
+func CreateSubjectKeyAttestationEvidenceExtension(akCert *x509.Certificate, params attest.CertificationParameters, recipients ...*x509.Certificate) (pkix.Extension, error) {
+	if akCert == nil {
+		return pkix.Extension{}, errors.New("AK certificate is required")
+	}
+	if len(params.CreateAttestation) == 0 || len(params.CreateSignature) == 0 {
+		return pkix.Extension{}, errors.New("certification parameters are required")
+	}
+
+	asn1Issuer, err := rawIssuer(akCert)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	attestationEvidence := asn1AttestationEvidence{
+		TPMCertifyInfo: asn1TPMCertifyInfo{
+			CertifyInfo: asn1.BitString{
+				Bytes:     params.CreateAttestation,
+				BitLength: len(params.CreateAttestation) * 8,
 			},
-			TPMIdentityCredAccessInfo: asn1TPMIdentityCredentialAccessInfo{
-				AuthorityInfoAccess: createAIA(akCert),
-				IssuerSerial: issuerAndSerial{
-					IssuerName:   asn1.RawValue{FullBytes: asn1Issuer},
-					SerialNumber: akCert.SerialNumber,
-				},
+			Signature: asn1.BitString{
+				Bytes:     params.CreateSignature,
+				BitLength: len(params.CreateSignature) * 8,
 			},
-		}
+		},
+		TPMIdentityCredAccessInfo: asn1TPMIdentityCredentialAccessInfo{
+			AuthorityInfoAccess: createAIA(akCert),
+			IssuerSerial: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: asn1Issuer},
+				SerialNumber: akCert.SerialNumber,
+			},
+		},
+	}
 
+	var evidence []byte
+	if len(recipients) == 0 {
+		if evidence, err = asn1.MarshalWithParams(attestationEvidence, fmt.Sprintf("tag:%d", tagAttestEvidence)); err != nil {
+			return pkix.Extension{}, fmt.Errorf("failed marshaling attestation evidence: %w", err)
+		}
+	} else {
 		aeb, err := asn1.Marshal(attestationEvidence)
 		if err != nil {
-			return pkix.Extension{}, fmt.Errorf("error marshaling attestation evidence: %w", err)
+			return pkix.Extension{}, fmt.Errorf("failed marshaling attestation evidence: %w", err)
 		}
+		eae, err := envelope(aeb, recipients)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+		if evidence, err = asn1.MarshalWithParams(*eae, fmt.Sprintf("tag:%d", tagEnvelopedAttestEvidence)); err != nil {
+			return pkix.Extension{}, fmt.Errorf("failed marshaling enveloped attestation evidence: %w", err)
+		}
+	}
 
-		if !shouldEncrypt {
-			//skaeExtension.KeyAttestationEvidence.AttestEvidence = attestationEvidence
-			skaeExtension.KeyAttestationEvidence.Evidence = asn1.RawValue{
-				Class:      asn1.ClassContextSpecific,
-				IsCompound: true,
-				Tag:        0, // CHOICE "0"
-				Bytes:      aeb,
-			}
-		} else {
-			// TODO: encrypt the AttestEvidence to (right) recipient; set it as the EnvelopedAttestEvidence
-			encryptedAEB := aeb
-
-			eae := asn1EnvelopedAttestationEvidence{
-				RecipientInfos: []recipientInfo{}, // TODO: fill recipient(s)
-				EncryptedAttestInfo: asn1EncryptedAttestationInfo{
-					EncryptionAlgorithm: pkix.AlgorithmIdentifier{
-						Algorithm: nil, // TODO: select and fill
-					},
-					EncryptedAttestEvidence: encryptedAEB,
-				},
-			}
-
-			eaeBytes, err := asn1.Marshal(eae)
-			if err != nil {
-				return pkix.Extension{}, errors.New("error marshaling EnvelopedAttestationEvidence")
-			}
+	skaeExtension := asn1SKAE{
+		TCGSpecVersion:         asn1TCGSpecVersion{Major: 2, Minor: 0},
+		KeyAttestationEvidence: asn1.RawValue{FullBytes: evidence},
+	}
+	skaeExtensionBytes, err := asn1.Marshal(skaeExtension)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed marshaling SKAE extension: %w", err)
+	}
 
-			skaeExtension.KeyAttestationEvidence.Evidence = asn1.RawValue{
-				Class:      asn1.ClassContextSpecific,
-				IsCompound: true,
-				Tag:        1, // CHOICE "1"
-				Bytes:      eaeBytes,
-			}
+	return pkix.Extension{
+		Id:       oidSubjectKeyAttestationEvidence,
+		Critical: false, // non standard extension; don't break clients
+		Value:    skaeExtensionBytes,
+	}, nil
+}
 
-			return pkix.Extension{}, errors.New("encrypting the AttestEvidence is not yet supported")
+// ParseSubjectKeyAttestationEvidence parses the SKAE extension of `cert`.
+// It returns [ErrNotFound] if the certificate doesn't have the extension.
+func ParseSubjectKeyAttestationEvidence(cert *x509.Certificate) (*SubjectKeyAttestationEvidence, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSubjectKeyAttestationEvidence) {
+			return ParseSubjectKeyAttestationEvidenceExtension(ext)
 		}
+	}
+	return nil, ErrNotFound
+}
 
-		skaeExtensionBytes, err := asn1.Marshal(skaeExtension)
+// ParseSubjectKeyAttestationEvidenceExtension parses the SKAE extension
+// `ext`.
+func ParseSubjectKeyAttestationEvidenceExtension(ext pkix.Extension) (*SubjectKeyAttestationEvidence, error) {
+	if !ext.Id.Equal(oidSubjectKeyAttestationEvidence) {
+		return nil, fmt.Errorf("unexpected extension %s", ext.Id)
+	}
+
+	var skae asn1SKAE
+	if rest, err := asn1.Unmarshal(ext.Value, &skae); err != nil {
+		return nil, fmt.Errorf("failed parsing SKAE extension: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("failed parsing SKAE extension: trailing data")
+	}
+
+	result := &SubjectKeyAttestationEvidence{
+		Major: skae.TCGSpecVersion.Major,
+		Minor: skae.TCGSpecVersion.Minor,
+	}
+
+	evidence := skae.KeyAttestationEvidence
+	if evidence.Class != asn1.ClassContextSpecific {
+		return nil, errors.New("failed parsing SKAE extension: invalid key attestation evidence")
+	}
+	switch evidence.Tag {
+	case tagAttestEvidence:
+		ae, err := parseAttestationEvidence(evidence.FullBytes, fmt.Sprintf("tag:%d", tagAttestEvidence))
 		if err != nil {
-			return pkix.Extension{}, fmt.Errorf("creating SKAE extension failed: %w", err)
+			return nil, err
+		}
+		result.AttestationEvidence = ae
+	case tagEnvelopedAttestEvidence:
+		var eae asn1EnvelopedAttestationEvidence
+		if _, err := asn1.UnmarshalWithParams(evidence.FullBytes, &eae, fmt.Sprintf("tag:%d", tagEnvelopedAttestEvidence)); err != nil {
+			return nil, fmt.Errorf("failed parsing enveloped attestation evidence: %w", err)
+		}
+		result.EnvelopedAttestationEvidence = &EnvelopedAttestationEvidence{eae: eae}
+	default:
+		return nil, fmt.Errorf("failed parsing SKAE extension: unexpected key attestation evidence tag %d", evidence.Tag)
+	}
+
+	return result, nil
+}
+
+func parseAttestationEvidence(der []byte, params string) (*AttestationEvidence, error) {
+	var ae asn1AttestationEvidence
+	if rest, err := asn1.UnmarshalWithParams(der, &ae, params); err != nil {
+		return nil, fmt.Errorf("failed parsing attestation evidence: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("failed parsing attestation evidence: trailing data")
+	}
+
+	result := &AttestationEvidence{
+		CertifyInfo:  ae.TPMCertifyInfo.CertifyInfo.RightAlign(),
+		Signature:    ae.TPMCertifyInfo.Signature.RightAlign(),
+		RawIssuer:    ae.TPMIdentityCredAccessInfo.IssuerSerial.IssuerName.FullBytes,
+		SerialNumber: ae.TPMIdentityCredAccessInfo.IssuerSerial.SerialNumber,
+	}
+	for _, aia := range ae.TPMIdentityCredAccessInfo.AuthorityInfoAccess {
+		if aia.Location.Class != asn1.ClassContextSpecific || aia.Location.Tag != tagURI {
+			continue
+		}
+		switch {
+		case aia.Method.Equal(oidAuthorityInfoAccessOcsp):
+			result.OCSPServer = append(result.OCSPServer, string(aia.Location.Bytes))
+		case aia.Method.Equal(oidAuthorityInfoAccessIssuers):
+			result.IssuingCertificateURL = append(result.IssuingCertificateURL, string(aia.Location.Bytes))
 		}
+	}
+
+	return result, nil
+}
+
+// VerifyOptions are the options used to verify the SKAE extension of a
+// certificate.
+type VerifyOptions struct {
+	// AKCertificate is the certificate of the AK referenced in the SKAE
+	// extension. It's required.
+	AKCertificate *x509.Certificate
+	// Public is the TPM public area of the certified key. It's required,
+	// and it's verified to be the key certified by the AK, and to match the
+	// public key of the certificate. Without it, the evidence can't be
+	// bound to the certificate.
+	Public []byte
+	// Recipient and Decrypter are used to decrypt enveloped attestation
+	// evidence.
+	Recipient *x509.Certificate
+	Decrypter crypto.Decrypter
+}
+
+// Verify verifies the SKAE extension of `cert`. The evidence must reference
+// the AK certificate in the options, must be signed by its key, and must
+// certify the TPM public area in the options, which must match the public
+// key of `cert`. The certified key must be a non-restricted key created by
+// the TPM that can't be duplicated. It returns the verified evidence.
+func Verify(cert *x509.Certificate, opts VerifyOptions) (*AttestationEvidence, error) {
+	skae, err := ParseSubjectKeyAttestationEvidence(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	ae := skae.AttestationEvidence
+	if skae.EnvelopedAttestationEvidence != nil {
+		if ae, err = skae.EnvelopedAttestationEvidence.Decrypt(opts.Recipient, opts.Decrypter); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ae.Verify(opts.AKCertificate); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Public) == 0 {
+		return nil, errors.New("public area of the certified key is required")
+	}
+	if err := ae.verifyPublic(opts.Public, cert.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return ae, nil
+}
+
+// Verify verifies that `akCert` is the AK certificate referenced by the
+// evidence, and that the certify information is a TPMS_ATTEST structure
+// for TPM2_Certify, signed by the AK.
+func (ae *AttestationEvidence) Verify(akCert *x509.Certificate) error {
+	if akCert == nil {
+		return errors.New("AK certificate is required")
+	}
+
+	issuer, err := rawIssuer(akCert)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(ae.RawIssuer, issuer) || ae.SerialNumber == nil || akCert.SerialNumber == nil || ae.SerialNumber.Cmp(akCert.SerialNumber) != 0 {
+		return errors.New("AK certificate does not match the attestation evidence")
+	}
+
+	att, err := tpm2.DecodeAttestationData(ae.CertifyInfo)
+	if err != nil {
+		return fmt.Errorf("failed decoding certify info: %w", err)
+	}
+	if att.Magic != tpmGeneratedMagic {
+		return errors.New("certify info was not created by a TPM")
+	}
+	if att.Type != tpm2.TagAttestCertify || att.AttestedCertifyInfo == nil {
+		return fmt.Errorf("unexpected certify info type 0x%x", att.Type)
+	}
+
+	if err := verifySignature(akCert.PublicKey, ae.CertifyInfo, ae.Signature); err != nil {
+		return fmt.Errorf("failed verifying certify info signature: %w", err)
+	}
+
+	return nil
+}
+
+// verifyPublic verifies that `public` is the TPM public area of the key
+// certified in the evidence, and that its key is `subjectKey`. As in
+// go-attestation, the key must have been generated by the TPM, it can't be
+// duplicated, and it must not be restricted.
+func (ae *AttestationEvidence) verifyPublic(public []byte, subjectKey crypto.PublicKey) error {
+	pub, err := tpm2.DecodePublic(public)
+	if err != nil {
+		return fmt.Errorf("failed decoding public area: %w", err)
+	}
+	switch {
+	case pub.Attributes&tpm2.FlagFixedTPM == 0:
+		return errors.New("certified key is exportable")
+	case pub.Attributes&tpm2.FlagRestricted != 0:
+		return errors.New("certified key is restricted")
+	case pub.Attributes&tpm2.FlagFixedParent == 0:
+		return errors.New("certified key can be duplicated to a different parent")
+	case pub.Attributes&tpm2.FlagSensitiveDataOrigin == 0:
+		return errors.New("certified key is not created by the TPM")
+	}
+	att, err := tpm2.DecodeAttestationData(ae.CertifyInfo)
+	if err != nil {
+		return fmt.Errorf("failed decoding certify info: %w", err)
+	}
+	match, err := att.AttestedCertifyInfo.Name.MatchesPublic(pub)
+	if err != nil {
+		return fmt.Errorf("failed comparing certified name: %w", err)
+	}
+	if !match {
+		return errors.New("certify info refers to a different key")
+	}
+
+	key, err := pub.Key()
+	if err != nil {
+		return fmt.Errorf("failed getting public key: %w", err)
+	}
+	if k, ok := key.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(subjectKey) {
+		return errors.New("certified key does not match the certificate public key")
+	}
+
+	return nil
+}
+
+// verifySignature verifies the TPMT_SIGNATURE `sig` over `data`.
+func verifySignature(pub crypto.PublicKey, data, sig []byte) error {
+	s, err := tpm2.DecodeSignature(bytes.NewBuffer(sig))
+	if err != nil {
+		return fmt.Errorf("failed decoding signature: %w", err)
+	}
 
-		result := pkix.Extension{
-			Id:       oidSubjectKeyAttestationEvidence,
-			Critical: false, // non standard extension; don't break clients
-			Value:    skaeExtensionBytes,
+	var hashAlg tpm2.Algorithm
+	switch {
+	case s.RSA != nil:
+		hashAlg = s.RSA.HashAlg
+	case s.ECC != nil:
+		hashAlg = s.ECC.HashAlg
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", s.Alg)
+	}
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		switch s.Alg {
+		case tpm2.AlgRSASSA:
+			return rsa.VerifyPKCS1v15(p, hash, digest, s.RSA.Signature)
+		case tpm2.AlgRSAPSS:
+			return rsa.VerifyPSS(p, hash, digest, s.RSA.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+	case *ecdsa.PublicKey:
+		if s.Alg == tpm2.AlgECDSA {
+			if !ecdsa.Verify(p, digest, s.ECC.R, s.ECC.S) {
+				return errors.New("invalid signature")
+			}
+			return nil
 		}
+	default:
+		return fmt.Errorf("unsupported AK public key type %T", pub)
+	}
+
+	return fmt.Errorf("signature algorithm %s does not match the AK public key", s.Alg)
+}
+
+// envelope encrypts the DER encoded attestation evidence `aeb` to the
+// recipients. The evidence is encrypted using AES-256-GCM with a random
+// key, which is encrypted to each recipient using RSAES-OAEP with SHA-256.
+func envelope(aeb []byte, recipients []*x509.Certificate) (*asn1EnvelopedAttestationEvidence, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed generating key: %w", err)
+	}
+
+	oaepParams, err := marshalOAEPParameters()
+	if err != nil {
+		return nil, err
+	}
 
-		b, err := asn1.Marshal(result)
+	eae := &asn1EnvelopedAttestationEvidence{}
+	for _, r := range recipients {
+		pub, ok := r.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported recipient public key type %T", r.PublicKey)
+		}
+		encryptedKey, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed encrypting key: %w", err)
+		}
+		issuer, err := rawIssuer(r)
 		if err != nil {
-			return result, err
+			return nil, err
 		}
+		eae.RecipientInfos = append(eae.RecipientInfos, recipientInfo{
+			Version: 0,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: issuer},
+				SerialNumber: r.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidRSAESOAEP,
+				Parameters: asn1.RawValue{FullBytes: oaepParams},
+			},
+			EncryptedKey: encryptedKey,
+		})
+	}
 
-		_ = b
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed generating nonce: %w", err)
+	}
+	gcmParams, err := asn1.Marshal(gcmParameters{Nonce: nonce, ICVLen: aead.Overhead()})
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling GCM parameters: %w", err)
+	}
+
+	eae.EncryptedAttestInfo = asn1EncryptedAttestationInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256GCM,
+			Parameters: asn1.RawValue{FullBytes: gcmParams},
+		},
+		EncryptedAttestEvidence: aead.Seal(nil, nonce, aeb, nil),
+	}
 
-		return result, nil
-	*/
+	return eae, nil
+}
+
+// Decrypt decrypts the attestation evidence using the private key `key` of
+// the recipient with certificate `recipient`.
+func (e *EnvelopedAttestationEvidence) Decrypt(recipient *x509.Certificate, key crypto.Decrypter) (*AttestationEvidence, error) {
+	if recipient == nil || key == nil {
+		return nil, errors.New("recipient and decrypter are required to decrypt enveloped attestation evidence")
+	}
+
+	issuer, err := rawIssuer(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	var ri *recipientInfo
+	for i, r := range e.eae.RecipientInfos {
+		if bytes.Equal(r.IssuerAndSerialNumber.IssuerName.FullBytes, issuer) &&
+			r.IssuerAndSerialNumber.SerialNumber != nil && recipient.SerialNumber != nil &&
+			r.IssuerAndSerialNumber.SerialNumber.Cmp(recipient.SerialNumber) == 0 {
+			ri = &e.eae.RecipientInfos[i]
+			break
+		}
+	}
+	if ri == nil {
+		return nil, errors.New("attestation evidence is not encrypted to the recipient")
+	}
+
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+		return nil, fmt.Errorf("unsupported key encryption algorithm %s", ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+	oaepParams, err := marshalOAEPParameters()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(ri.KeyEncryptionAlgorithm.Parameters.FullBytes, oaepParams) {
+		return nil, errors.New("unsupported RSAES-OAEP parameters")
+	}
+	cek, err := key.Decrypt(rand.Reader, ri.EncryptedKey, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting key: %w", err)
+	}
+
+	info := e.eae.EncryptedAttestInfo
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidAES256GCM) {
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", info.EncryptionAlgorithm.Algorithm)
+	}
+	var params gcmParameters
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed parsing GCM parameters: %w", err)
+	}
+	aead, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.Nonce) != aead.NonceSize() || params.ICVLen != aead.Overhead() {
+		return nil, errors.New("unsupported GCM parameters")
+	}
+	aeb, err := aead.Open(nil, params.Nonce, info.EncryptedAttestEvidence, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting attestation evidence: %w", err)
+	}
+
+	return parseAttestationEvidence(aeb, "")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("invalid key size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// marshalOAEPParameters returns the RSAES-OAEP-params for RSAES-OAEP with
+// SHA-256 and MGF1 with SHA-256.
+func marshalOAEPParameters() ([]byte, error) {
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	sha256Bytes, err := asn1.Marshal(sha256Alg)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling RSAES-OAEP parameters: %w", err)
+	}
+	b, err := asn1.Marshal(rsaesOAEPParameters{
+		HashAlgorithm: sha256Alg,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidMGF1,
+			Parameters: asn1.RawValue{FullBytes: sha256Bytes},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling RSAES-OAEP parameters: %w", err)
+	}
+	return b, nil
+}
+
+// rawIssuer returns the DER encoded issuer of `cert`.
+func rawIssuer(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.RawIssuer) > 0 {
+		return cert.RawIssuer, nil
+	}
+	b, err := asn1.Marshal(cert.Issuer.ToRDNSequence())
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling issuer: %w", err)
+	}
+	return b, nil
 }
 
 func createAIA(ak *x509.Certificate) []asn1AuthorityInfoAccessSyntax {
@@ -120,13 +593,13 @@ func createAIA(ak *x509.Certificate) []asn1AuthorityInfoAccessSyntax {
 	for _, server := range ak.OCSPServer {
 		aiaValues = append(aiaValues, asn1AuthorityInfoAccessSyntax{
 			Method:   oidAuthorityInfoAccessOcsp,
-			Location: asn1.RawValue{Tag: asn1.TagOID, Class: asn1.ClassContextSpecific, Bytes: []byte(server)},
+			Location: asn1.RawValue{Tag: tagURI, Class: asn1.ClassContextSpecific, Bytes: []byte(server)},
 		})
 	}
 	for _, url := range ak.IssuingCertificateURL {
 		aiaValues = append(aiaValues, asn1AuthorityInfoAccessSyntax{
 			Method:   oidAuthorityInfoAccessIssuers,
-			Location: asn1.RawValue{Tag: asn1.TagOID, Class: asn1.ClassContextSpecific, Bytes: []byte(url)},
+			Location: asn1.RawValue{Tag: tagURI, Class: asn1.ClassContextSpecific, Bytes: []byte(url)},
 		})
 	}
 	return aiaValues
@@ -134,7 +607,7 @@ func createAIA(ak *x509.Certificate) []asn1AuthorityInfoAccessSyntax {
 
 type asn1SKAE struct {
 	TCGSpecVersion         asn1TCGSpecVersion
-	KeyAttestationEvidence asn1KeyAttestationEvidence
+	KeyAttestationEvidence asn1.RawValue // CHOICE of [0] AttestationEvidence and [1] EnvelopedAttestationEvidence
 }
 
 type asn1TCGSpecVersion struct {
@@ -142,12 +615,6 @@ type asn1TCGSpecVersion struct {
 	Minor int
 }
 
-type asn1KeyAttestationEvidence struct {
-	// AttestEvidence          asn1AttestationEvidence // TODO: ASN1 CHOICE between those two
-	// EnvelopedAttestEvidence asn1EnvelopedAttestationEvidence
-	Evidence asn1.RawValue
-}
-
 type asn1AttestationEvidence struct {
 	TPMCertifyInfo            asn1TPMCertifyInfo
 	TPMIdentityCredAccessInfo asn1TPMIdentityCredentialAccessInfo
@@ -187,5 +654,18 @@ type recipientInfo struct {
 
 type asn1EncryptedAttestationInfo struct {
 	EncryptionAlgorithm     pkix.AlgorithmIdentifier
-	EncryptedAttestEvidence []byte // -- The ciphertext resulting from the encryption of DER-encoded AttestationEvidence (against public key in recipientinfo; something like that)
+	EncryptedAttestEvidence []byte // -- The ciphertext resulting from the encryption of DER-encoded AttestationEvidence
+}
+
+// gcmParameters are the AES-GCM parameters defined in RFC 5084.
+type gcmParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// rsaesOAEPParameters are the RSAES-OAEP parameters defined in RFC 4055.
+// The pSourceAlgorithm is always the default.
+type rsaesOAEPParameters struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
 }
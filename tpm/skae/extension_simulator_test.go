@@ -0,0 +1,79 @@
+//go:build tpmsimulator
+// +build tpmsimulator
+
+package skae
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/tpm"
+	"go.step.sm/crypto/tpm/simulator"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+func newSimulatedTPM(t *testing.T) *tpm.TPM {
+	t.Helper()
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	instance, err := tpm.New(tpm.WithSimulator(sim), tpm.WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+	return instance
+}
+
+func TestSubjectKeyAttestationEvidence_simulator(t *testing.T) {
+	ctx := context.Background()
+	instance := newSimulatedTPM(t)
+	ca, err := minica.New()
+	require.NoError(t, err)
+
+	ak, err := instance.CreateAK(ctx, "ak")
+	require.NoError(t, err)
+	akCert := mustSign(t, ca, &x509.Certificate{PublicKey: ak.Public()})
+
+	recipientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	recipient := mustSign(t, ca, &x509.Certificate{PublicKey: recipientKey.Public()})
+
+	for alg, size := range map[string]int{"RSA": 2048, "ECDSA": 256} {
+		alg, size := alg, size
+		t.Run(alg, func(t *testing.T) {
+			key, err := instance.AttestKey(ctx, "ak", "key-"+alg, tpm.AttestKeyConfig{Algorithm: alg, Size: size})
+			require.NoError(t, err)
+			params, err := key.CertificationParameters(ctx)
+			require.NoError(t, err)
+			signer, err := key.Signer(ctx)
+			require.NoError(t, err)
+
+			for _, recipients := range [][]*x509.Certificate{nil, {recipient}} {
+				ext, err := CreateSubjectKeyAttestationEvidenceExtension(akCert, params, recipients...)
+				require.NoError(t, err)
+				cert := mustSign(t, ca, &x509.Certificate{
+					PublicKey:       signer.Public(),
+					ExtraExtensions: []pkix.Extension{ext},
+				})
+
+				ae, err := Verify(cert, VerifyOptions{
+					AKCertificate: akCert,
+					Public:        params.Public,
+					Recipient:     recipient,
+					Decrypter:     recipientKey,
+				})
+				require.NoError(t, err)
+				assert.Equal(t, params.CreateAttestation, ae.CertifyInfo)
+			}
+		})
+	}
+}
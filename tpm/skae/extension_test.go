@@ -1,88 +1,350 @@
 package skae
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"math/big"
-	"reflect"
 	"testing"
 
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 	"github.com/smallstep/go-attestation/attest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/minica"
 )
 
-func TestCreateSubjectKeyAttestationEvidenceExtension(t *testing.T) {
-	t.Skip("skipping because SKAE is not complete yet")
-	akCert := &x509.Certificate{
-		Issuer: pkix.Name{
-			CommonName: "AK Test Issuer",
-		},
-		SerialNumber: big.NewInt(1337),
-		OCSPServer: []string{
-			"https://www.example.com/ocsp/1",
-			"https://www.example.com/ocsp/2",
+type testKey struct {
+	key    *ecdsa.PrivateKey
+	public []byte
+	name   tpm2.Name
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	return newTestKeyWithAttributes(t, tpm2.FlagSign|tpm2.FlagFixedTPM|tpm2.FlagFixedParent|tpm2.FlagSensitiveDataOrigin|tpm2.FlagUserWithAuth)
+}
+
+func newTestKeyWithAttributes(t *testing.T, attrs tpm2.KeyProp) *testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pub := tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: attrs,
+		ECCParameters: &tpm2.ECCParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+			CurveID: tpm2.CurveNISTP256,
+			Point:   tpm2.ECPoint{XRaw: key.X.FillBytes(make([]byte, 32)), YRaw: key.Y.FillBytes(make([]byte, 32))},
 		},
-		IssuingCertificateURL: []string{
-			"https://www.example.com/issuing/cert1",
+	}
+	public, err := pub.Encode()
+	require.NoError(t, err)
+	name, err := pub.Name()
+	require.NoError(t, err)
+	return &testKey{key: key, public: public, name: name}
+}
+
+// certify returns the certification parameters for `key`, signed by `ak`.
+func certify(t *testing.T, ak crypto.Signer, key *testKey) attest.CertificationParameters {
+	t.Helper()
+	signerHandle := tpmutil.Handle(0x80000000)
+	data, err := tpm2.AttestationData{
+		Magic:           tpmGeneratedMagic,
+		Type:            tpm2.TagAttestCertify,
+		QualifiedSigner: tpm2.Name{Handle: &signerHandle},
+		ExtraData:       []byte("nonce"),
+		ClockInfo:       tpm2.ClockInfo{Clock: 1, Safe: 1},
+		AttestedCertifyInfo: &tpm2.CertifyInfo{
+			Name:          key.name,
+			QualifiedName: key.name,
 		},
+	}.Encode()
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(data)
+	rawSig, err := ak.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	var sig tpm2.Signature
+	switch ak.Public().(type) {
+	case *rsa.PublicKey:
+		sig = tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: rawSig}}
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		_, err := asn1.Unmarshal(rawSig, &esig)
+		require.NoError(t, err)
+		sig = tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: esig.R, S: esig.S}}
 	}
-	type args struct {
-		akCert        *x509.Certificate
-		params        attest.CertificationParameters
-		shouldEncrypt bool
+	sigBytes, err := sig.Encode()
+	require.NoError(t, err)
+
+	return attest.CertificationParameters{
+		Public:            key.public,
+		CreateAttestation: data,
+		CreateSignature:   sigBytes,
 	}
+}
+
+func mustSign(t *testing.T, ca *minica.CA, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	cert, err := ca.Sign(template)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestCreateSubjectKeyAttestationEvidenceExtension(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	akKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	akCert := mustSign(t, ca, &x509.Certificate{
+		PublicKey:             akKey.Public(),
+		OCSPServer:            []string{"https://www.example.com/ocsp/1", "https://www.example.com/ocsp/2"},
+		IssuingCertificateURL: []string{"https://www.example.com/issuing/cert1"},
+	})
+	recipientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	recipient := mustSign(t, ca, &x509.Certificate{PublicKey: recipientKey.Public()})
+	ecRecipientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecRecipient := mustSign(t, ca, &x509.Certificate{PublicKey: ecRecipientKey.Public()})
+
+	key := newTestKey(t)
+	params := certify(t, akKey, key)
+
+	tests := []struct {
+		name       string
+		akCert     *x509.Certificate
+		params     attest.CertificationParameters
+		recipients []*x509.Certificate
+		enveloped  bool
+		wantErr    bool
+	}{
+		{"ok", akCert, params, nil, false, false},
+		{"ok/enveloped", akCert, params, []*x509.Certificate{recipient}, true, false},
+		{"fail/ak", nil, params, nil, false, true},
+		{"fail/params", akCert, attest.CertificationParameters{}, nil, false, true},
+		{"fail/recipient", akCert, params, []*x509.Certificate{ecRecipient}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ext, err := CreateSubjectKeyAttestationEvidenceExtension(tt.akCert, tt.params, tt.recipients...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, pkix.Extension{}, ext)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, asn1.ObjectIdentifier{2, 23, 133, 6, 1, 1}, ext.Id)
+			assert.False(t, ext.Critical)
+
+			cert := mustSign(t, ca, &x509.Certificate{
+				PublicKey:       key.key.Public(),
+				ExtraExtensions: []pkix.Extension{ext},
+			})
+			skae, err := ParseSubjectKeyAttestationEvidence(cert)
+			require.NoError(t, err)
+			assert.Equal(t, 2, skae.Major)
+			assert.Equal(t, 0, skae.Minor)
+
+			ae := skae.AttestationEvidence
+			if tt.enveloped {
+				assert.Nil(t, ae)
+				require.NotNil(t, skae.EnvelopedAttestationEvidence)
+				ae, err = skae.EnvelopedAttestationEvidence.Decrypt(recipient, recipientKey)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, &AttestationEvidence{
+				CertifyInfo:           params.CreateAttestation,
+				Signature:             params.CreateSignature,
+				OCSPServer:            akCert.OCSPServer,
+				IssuingCertificateURL: akCert.IssuingCertificateURL,
+				RawIssuer:             akCert.RawIssuer,
+				SerialNumber:          akCert.SerialNumber,
+			}, ae)
+
+			got, err := Verify(cert, VerifyOptions{
+				AKCertificate: akCert,
+				Public:        key.public,
+				Recipient:     recipient,
+				Decrypter:     recipientKey,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, ae, got)
+		})
+	}
+}
+
+func TestParseSubjectKeyAttestationEvidence(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	cert := mustSign(t, ca, &x509.Certificate{PublicKey: ca.Intermediate.PublicKey})
+
+	_, err = ParseSubjectKeyAttestationEvidence(cert)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	mustMarshal := func(v any) []byte {
+		b, err := asn1.Marshal(v)
+		require.NoError(t, err)
+		return b
+	}
+	tests := []struct {
+		name string
+		ext  pkix.Extension
+	}{
+		{"fail/oid", pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3}}},
+		{"fail/asn1", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: []byte("foo")}},
+		{"fail/trailing-data", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: append(mustMarshal(asn1SKAE{
+			KeyAttestationEvidence: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true},
+		}), 0)}},
+		{"fail/class", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: mustMarshal(asn1SKAE{
+			KeyAttestationEvidence: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true},
+		})}},
+		{"fail/tag", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: mustMarshal(asn1SKAE{
+			KeyAttestationEvidence: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true},
+		})}},
+		{"fail/evidence", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: mustMarshal(asn1SKAE{
+			KeyAttestationEvidence: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true},
+		})}},
+		{"fail/enveloped-evidence", pkix.Extension{Id: oidSubjectKeyAttestationEvidence, Value: mustMarshal(asn1SKAE{
+			KeyAttestationEvidence: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true},
+		})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSubjectKeyAttestationEvidenceExtension(tt.ext)
+			assert.Error(t, err)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	rsaAK, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaAKCert := mustSign(t, ca, &x509.Certificate{PublicKey: rsaAK.Public()})
+	ecAK, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecAKCert := mustSign(t, ca, &x509.Certificate{PublicKey: ecAK.Public()})
+	otherAKCert := mustSign(t, ca, &x509.Certificate{PublicKey: rsaAK.Public()})
+	recipientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	recipient := mustSign(t, ca, &x509.Certificate{PublicKey: recipientKey.Public()})
+	otherRecipient := mustSign(t, ca, &x509.Certificate{PublicKey: recipientKey.Public()})
+
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	newCert := func(t *testing.T, akCert *x509.Certificate, params attest.CertificationParameters, recipients ...*x509.Certificate) *x509.Certificate {
+		t.Helper()
+		ext, err := CreateSubjectKeyAttestationEvidenceExtension(akCert, params, recipients...)
+		require.NoError(t, err)
+		return mustSign(t, ca, &x509.Certificate{
+			PublicKey:       key.key.Public(),
+			ExtraExtensions: []pkix.Extension{ext},
+		})
+	}
+
+	rsaParams := certify(t, rsaAK, key)
+	ecParams := certify(t, ecAK, key)
+	tampered := rsaParams
+	tampered.CreateSignature = append([]byte{}, rsaParams.CreateSignature...)
+	tampered.CreateSignature[len(tampered.CreateSignature)-1] ^= 0xff
+	notCertify := rsaParams
+	notCertify.CreateAttestation = append([]byte{}, rsaParams.CreateAttestation...)
+	notCertify.CreateAttestation[5] = 0x19 // TPM_ST_ATTEST_QUOTE
+
 	tests := []struct {
 		name    string
-		args    args
-		want    pkix.Extension
+		cert    *x509.Certificate
+		opts    VerifyOptions
 		wantErr bool
 	}{
-		{
-			name: "attest",
-			args: args{
-				akCert: akCert,
-				params: attest.CertificationParameters{
-					CreateAttestation: []byte("test-fake-create-attestation"),
-					CreateSignature:   []byte("test-fake-create-signature"),
-				},
-				shouldEncrypt: false,
-			},
-			want: pkix.Extension{
-				Id:       asn1.ObjectIdentifier{2, 23, 133, 6, 1, 1},
-				Critical: false,
-				Value:    []byte{},
-			},
-			wantErr: false,
-		},
-		{
-			name: "enveloped-attest",
-			args: args{
-				akCert: akCert,
-				params: attest.CertificationParameters{
-					CreateAttestation: []byte("test-fake-create-attestation"),
-					CreateSignature:   []byte("test-fake-create-signature"),
-				},
-				shouldEncrypt: true,
-			},
-			// want: pkix.Extension{
-			// 	Id:       asn1.ObjectIdentifier{2, 23, 133, 6, 1, 1},
-			// 	Critical: false,
-			// 	Value:    []byte{},
-			// },
-			want:    pkix.Extension{},
-			wantErr: true,
-		},
+		{"ok/rsa", newCert(t, rsaAKCert, rsaParams), VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, false},
+		{"ok/ecdsa", newCert(t, ecAKCert, ecParams), VerifyOptions{AKCertificate: ecAKCert, Public: key.public}, false},
+		{"ok/enveloped", newCert(t, rsaAKCert, rsaParams, otherRecipient, recipient), VerifyOptions{
+			AKCertificate: rsaAKCert, Public: key.public, Recipient: recipient, Decrypter: recipientKey,
+		}, false},
+		{"fail/no-extension", rsaAKCert, VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, true},
+		{"fail/no-ak", newCert(t, rsaAKCert, rsaParams), VerifyOptions{Public: key.public}, true},
+		{"fail/other-ak", newCert(t, rsaAKCert, rsaParams), VerifyOptions{AKCertificate: otherAKCert, Public: key.public}, true},
+		{"fail/wrong-ak-key", newCert(t, ecAKCert, rsaParams), VerifyOptions{AKCertificate: ecAKCert, Public: key.public}, true},
+		{"fail/signature", newCert(t, rsaAKCert, tampered), VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, true},
+		{"fail/not-certify", newCert(t, rsaAKCert, notCertify), VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, true},
+		{"fail/no-public", newCert(t, rsaAKCert, rsaParams), VerifyOptions{AKCertificate: rsaAKCert}, true},
+		{"fail/no-public-other-key", newCert(t, rsaAKCert, certify(t, rsaAK, otherKey)), VerifyOptions{AKCertificate: rsaAKCert}, true},
+		{"fail/public", newCert(t, rsaAKCert, rsaParams), VerifyOptions{AKCertificate: rsaAKCert, Public: otherKey.public}, true},
+		{"fail/public-asn1", newCert(t, rsaAKCert, rsaParams), VerifyOptions{AKCertificate: rsaAKCert, Public: []byte("foo")}, true},
+		{"fail/subject-key", newCert(t, rsaAKCert, certify(t, rsaAK, otherKey)), VerifyOptions{AKCertificate: rsaAKCert, Public: otherKey.public}, true},
+		{"fail/other-key", newCert(t, rsaAKCert, certify(t, rsaAK, otherKey)), VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, true},
+		{"fail/no-decrypter", newCert(t, rsaAKCert, rsaParams, recipient), VerifyOptions{AKCertificate: rsaAKCert, Public: key.public}, true},
+		{"fail/other-recipient", newCert(t, rsaAKCert, rsaParams, recipient), VerifyOptions{
+			AKCertificate: rsaAKCert, Public: key.public, Recipient: otherRecipient, Decrypter: recipientKey,
+		}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := CreateSubjectKeyAttestationEvidenceExtension(tt.args.akCert, tt.args.params, tt.args.shouldEncrypt)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("CreateSubjectKeyAttestationEvidenceExtension() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := Verify(tt.cert, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("CreateSubjectKeyAttestationEvidenceExtension() = %v, want %v", got, tt.want)
+			assert.NoError(t, err)
+			assert.NotNil(t, got)
+		})
+	}
+}
+
+func TestVerify_attributes(t *testing.T) {
+	ca, err := minica.New()
+	require.NoError(t, err)
+	ak, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	akCert := mustSign(t, ca, &x509.Certificate{PublicKey: ak.Public()})
+
+	attrs := tpm2.FlagSign | tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth
+	tests := []struct {
+		name    string
+		attrs   tpm2.KeyProp
+		wantErr bool
+	}{
+		{"ok", attrs, false},
+		{"fail/fixed-tpm", attrs &^ tpm2.FlagFixedTPM, true},
+		{"fail/fixed-parent", attrs &^ tpm2.FlagFixedParent, true},
+		{"fail/sensitive-data-origin", attrs &^ tpm2.FlagSensitiveDataOrigin, true},
+		{"fail/restricted", attrs | tpm2.FlagRestricted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := newTestKeyWithAttributes(t, tt.attrs)
+			ext, err := CreateSubjectKeyAttestationEvidenceExtension(akCert, certify(t, ak, key))
+			require.NoError(t, err)
+			cert := mustSign(t, ca, &x509.Certificate{
+				PublicKey:       key.key.Public(),
+				ExtraExtensions: []pkix.Extension{ext},
+			})
+
+			got, err := Verify(cert, VerifyOptions{AKCertificate: akCert, Public: key.public})
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
 			}
+			assert.NoError(t, err)
+			assert.NotNil(t, got)
 		})
 	}
 }
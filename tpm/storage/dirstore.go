@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -14,12 +15,20 @@ import (
 // stores TPM objects in a directory. Each object will be stored in a
 // separate file in the directory. The name of the file is constructed
 // by prefixing the name of the object with its type.
+//
+// Objects are written to a temporary file first, and renamed afterwards, so
+// that they're never left partially written. Modifications are performed
+// while holding an exclusive lock on the directory, which allows multiple
+// processes to use the same directory.
 type Dirstore struct {
 	store     *diskv.Diskv
 	directory string
 }
 
-const tpmExtension = ".tpmobj"
+const (
+	tpmExtension = ".tpmobj"
+	lockFilename = ".lock"
+)
 
 func advancedTransform(key string) *diskv.PathKey {
 	path := strings.Split(key, "/")
@@ -50,8 +59,10 @@ func NewDirstore(directory string) *Dirstore {
 			BasePath:          directory,
 			AdvancedTransform: advancedTransform,
 			InverseTransform:  inverseTransform,
-			CacheSizeMax:      1024 * 1024,
-			// TODO(hs): add TempDir for atomic operations?
+			// objects aren't cached, because they can be modified by
+			// other processes
+			CacheSizeMax: 0,
+			TempDir:      filepath.Join(directory, ".tmp"),
 		}),
 		directory: directory,
 	}
@@ -100,44 +111,50 @@ func (s *Dirstore) GetKey(name string) (*Key, error) {
 }
 
 func (s *Dirstore) AddKey(key *Key) error {
-	kk := keyForKey(key.Name)
-	if s.store.Has(kk) {
-		return ErrExists
-	}
-	data, err := json.Marshal(key)
-	if err != nil {
-		return fmt.Errorf("failed serializing key: %w", err)
-	}
-	if err := s.store.WriteStream(kk, bytes.NewBuffer(data), true); err != nil {
-		return fmt.Errorf("failed writing key to disk: %w", err)
-	}
-	return nil
+	return s.withLock(func() error {
+		kk := keyForKey(key.Name)
+		if s.store.Has(kk) {
+			return ErrExists
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed serializing key: %w", err)
+		}
+		if err := s.store.WriteStream(kk, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing key to disk: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *Dirstore) UpdateKey(key *Key) error {
-	kk := keyForKey(key.Name)
-	if !s.store.Has(kk) {
-		return ErrNotFound
-	}
-	data, err := json.Marshal(key)
-	if err != nil {
-		return fmt.Errorf("failed serializing key: %w", err)
-	}
-	if err := s.store.WriteStream(kk, bytes.NewBuffer(data), true); err != nil {
-		return fmt.Errorf("failed writing key to disk: %w", err)
-	}
-	return nil
+	return s.withLock(func() error {
+		kk := keyForKey(key.Name)
+		if !s.store.Has(kk) {
+			return ErrNotFound
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed serializing key: %w", err)
+		}
+		if err := s.store.WriteStream(kk, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing key to disk: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *Dirstore) DeleteKey(name string) error {
-	key := keyForKey(name)
-	if !s.store.Has(key) {
-		return ErrNotFound
-	}
-	if err := s.store.Erase(key); err != nil {
-		return fmt.Errorf("failed deleting key from disk: %w", err)
-	}
-	return nil
+	return s.withLock(func() error {
+		key := keyForKey(name)
+		if !s.store.Has(key) {
+			return ErrNotFound
+		}
+		if err := s.store.Erase(key); err != nil {
+			return fmt.Errorf("failed deleting key from disk: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *Dirstore) ListAKs() ([]*AK, error) {
@@ -183,44 +200,153 @@ func (s *Dirstore) GetAK(name string) (*AK, error) {
 }
 
 func (s *Dirstore) AddAK(ak *AK) error {
-	akKey := keyForAK(ak.Name)
-	if s.store.Has(akKey) {
-		return ErrExists
-	}
-	data, err := json.Marshal(ak)
-	if err != nil {
-		return fmt.Errorf("failed serializing AK: %w", err)
+	return s.withLock(func() error {
+		akKey := keyForAK(ak.Name)
+		if s.store.Has(akKey) {
+			return ErrExists
+		}
+		data, err := json.Marshal(ak)
+		if err != nil {
+			return fmt.Errorf("failed serializing AK: %w", err)
+		}
+		if err := s.store.WriteStream(akKey, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing AK to disk: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Dirstore) UpdateAK(ak *AK) error {
+	return s.withLock(func() error {
+		akKey := keyForAK(ak.Name)
+		if !s.store.Has(akKey) {
+			return ErrNotFound
+		}
+		data, err := json.Marshal(ak)
+		if err != nil {
+			return fmt.Errorf("failed serializing AK: %w", err)
+		}
+		if err := s.store.WriteStream(akKey, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing AK to disk: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Dirstore) DeleteAK(name string) error {
+	return s.withLock(func() error {
+		key := keyForAK(name)
+		if !s.store.Has(key) {
+			return ErrNotFound
+		}
+		if err := s.store.Erase(key); err != nil {
+			return fmt.Errorf("failed deleting AK from disk: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Dirstore) ListSealed() ([]*Sealed, error) {
+	var result = make([]*Sealed, 0)
+	c := s.store.KeysPrefix(sealedPrefix, nil)
+	for k := range c {
+		data, err := s.store.Read(k)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading sealed data from store: %w", err)
+		}
+		sealed := &Sealed{}
+		if err := json.Unmarshal(data, sealed); err != nil {
+			return nil, fmt.Errorf("failed unmarshaling sealed data: %w", err)
+		}
+		result = append(result, sealed)
 	}
-	if err := s.store.WriteStream(akKey, bytes.NewBuffer(data), true); err != nil {
-		return fmt.Errorf("failed writing AK to disk: %w", err)
+	return result, nil
+}
+
+func (s *Dirstore) ListSealedNames() []string {
+	var result = make([]string, 0)
+	c := s.store.KeysPrefix(sealedPrefix, nil)
+	for k := range c {
+		result = append(result, strings.TrimPrefix(k, sealedPrefix))
 	}
-	return nil
+	return result
 }
 
-func (s *Dirstore) UpdateAK(ak *AK) error {
-	akKey := keyForAK(ak.Name)
-	if !s.store.Has(akKey) {
-		return ErrNotFound
+func (s *Dirstore) GetSealed(name string) (*Sealed, error) {
+	sk := keyForSealed(name)
+	if !s.store.Has(sk) {
+		return nil, ErrNotFound
 	}
-	data, err := json.Marshal(ak)
+	data, err := s.store.Read(sk)
 	if err != nil {
-		return fmt.Errorf("failed serializing AK: %w", err)
+		return nil, fmt.Errorf("failed reading sealed data from store: %w", err)
 	}
-	if err := s.store.WriteStream(akKey, bytes.NewBuffer(data), true); err != nil {
-		return fmt.Errorf("failed writing AK to disk: %w", err)
+	sealed := &Sealed{}
+	if err := json.Unmarshal(data, sealed); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling sealed data: %w", err)
 	}
-	return nil
+	return sealed, nil
 }
 
-func (s *Dirstore) DeleteAK(name string) error {
-	key := keyForAK(name)
-	if !s.store.Has(key) {
-		return ErrNotFound
+func (s *Dirstore) AddSealed(sealed *Sealed) error {
+	return s.withLock(func() error {
+		sk := keyForSealed(sealed.Name)
+		if s.store.Has(sk) {
+			return ErrExists
+		}
+		data, err := json.Marshal(sealed)
+		if err != nil {
+			return fmt.Errorf("failed serializing sealed data: %w", err)
+		}
+		if err := s.store.WriteStream(sk, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing sealed data to disk: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Dirstore) UpdateSealed(sealed *Sealed) error {
+	return s.withLock(func() error {
+		sk := keyForSealed(sealed.Name)
+		if !s.store.Has(sk) {
+			return ErrNotFound
+		}
+		data, err := json.Marshal(sealed)
+		if err != nil {
+			return fmt.Errorf("failed serializing sealed data: %w", err)
+		}
+		if err := s.store.WriteStream(sk, bytes.NewBuffer(data), true); err != nil {
+			return fmt.Errorf("failed writing sealed data to disk: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Dirstore) DeleteSealed(name string) error {
+	return s.withLock(func() error {
+		sk := keyForSealed(name)
+		if !s.store.Has(sk) {
+			return ErrNotFound
+		}
+		if err := s.store.Erase(sk); err != nil {
+			return fmt.Errorf("failed deleting sealed data from disk: %w", err)
+		}
+		return nil
+	})
+}
+
+// withLock runs fn while holding an exclusive lock on the directory.
+func (s *Dirstore) withLock(fn func() error) error {
+	if err := os.MkdirAll(s.directory, 0700); err != nil {
+		return fmt.Errorf("failed creating directory: %w", err)
 	}
-	if err := s.store.Erase(key); err != nil {
-		return fmt.Errorf("failed deleting AK from disk: %w", err)
+	l, err := lockFile(filepath.Join(s.directory, lockFilename))
+	if err != nil {
+		return err
 	}
-	return nil
+	defer l.unlock()
+
+	return fn()
 }
 
 func (s *Dirstore) Persist() error {
@@ -1,6 +1,10 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"testing"
 
 	"github.com/peterbourgon/diskv/v3"
@@ -122,3 +126,115 @@ func TestDirstore_AKOperations(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, []*AK{ak2}, aks)
 }
+
+func TestDirstore_SealedOperations(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	store := NewDirstore(tempDir)
+	sealed1 := &Sealed{Name: "1st-sealed", Data: []byte{1, 2, 3, 4}}
+	sealed2 := &Sealed{Name: "2nd-sealed"}
+
+	err := store.AddSealed(sealed1)
+	require.NoError(t, err)
+
+	err = store.AddSealed(sealed2)
+	require.NoError(t, err)
+
+	err = store.AddSealed(sealed1)
+	require.EqualError(t, err, "already exists")
+
+	s, err := store.GetSealed("1st-sealed")
+	require.NoError(t, err)
+	require.Equal(t, sealed1, s)
+
+	s.Data = []byte{5, 6, 7, 8}
+	err = store.UpdateSealed(s)
+	require.NoError(t, err)
+
+	err = store.UpdateSealed(&Sealed{Name: "3rd-sealed"})
+	require.EqualError(t, err, "not found")
+
+	s, err = store.GetSealed("3rd-sealed")
+	require.EqualError(t, err, "not found")
+	require.Nil(t, s)
+
+	names := store.ListSealedNames()
+	require.Equal(t, []string{"1st-sealed", "2nd-sealed"}, names)
+
+	sealed, err := store.ListSealed()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*Sealed{{Name: "1st-sealed", Data: []byte{5, 6, 7, 8}}, sealed2}, sealed)
+
+	// sealed data is not listed as keys
+	require.Empty(t, store.ListKeyNames())
+
+	err = store.DeleteSealed("3rd-sealed")
+	require.EqualError(t, err, "not found")
+
+	err = store.DeleteSealed("1st-sealed")
+	require.NoError(t, err)
+
+	sealed, err = store.ListSealed()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*Sealed{sealed2}, sealed)
+}
+
+func TestDirstore_concurrentWriters(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	const writers, keysPerWriter = 8, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*keysPerWriter)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each writer uses its own instance, like separate processes do
+			store := NewDirstore(tempDir)
+			for j := 0; j < keysPerWriter; j++ {
+				if err := store.AddKey(&Key{Name: fmt.Sprintf("key-%d-%d", i, j)}); err != nil {
+					errs <- err
+					return
+				}
+				// all writers try to add the same AK
+				if err := store.AddAK(&AK{Name: "ak"}); err != nil && !errors.Is(err, ErrExists) {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	store := NewDirstore(tempDir)
+	var expected []string
+	for i := 0; i < writers; i++ {
+		for j := 0; j < keysPerWriter; j++ {
+			expected = append(expected, fmt.Sprintf("key-%d-%d", i, j))
+		}
+	}
+	require.ElementsMatch(t, expected, store.ListKeyNames())
+	require.Equal(t, []string{"ak"}, store.ListAKNames())
+
+	// objects written by other instances are visible, and not cached
+	other := NewDirstore(tempDir)
+	key, err := store.GetKey("key-0-0")
+	require.NoError(t, err)
+	key.Data = []byte{1, 2, 3, 4}
+	require.NoError(t, other.UpdateKey(key))
+	got, err := store.GetKey("key-0-0")
+	require.NoError(t, err)
+	require.Equal(t, key, got)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(tempDir + "/.tmp")
+	if err == nil {
+		require.Empty(t, entries)
+	}
+}
@@ -97,6 +97,48 @@ func (f *FeedthroughStore) DeleteAK(name string) error {
 	return f.store.DeleteAK(name)
 }
 
+func (f *FeedthroughStore) ListSealed() ([]*Sealed, error) {
+	if f.store == nil {
+		return nil, ErrNoStorageConfigured
+	}
+	return f.store.ListSealed()
+}
+
+func (f *FeedthroughStore) ListSealedNames() []string {
+	if f.store == nil {
+		return nil
+	}
+	return f.store.ListSealedNames()
+}
+
+func (f *FeedthroughStore) GetSealed(name string) (*Sealed, error) {
+	if f.store == nil {
+		return nil, ErrNoStorageConfigured
+	}
+	return f.store.GetSealed(name)
+}
+
+func (f *FeedthroughStore) AddSealed(sealed *Sealed) error {
+	if f.store == nil {
+		return ErrNoStorageConfigured
+	}
+	return f.store.AddSealed(sealed)
+}
+
+func (f *FeedthroughStore) UpdateSealed(sealed *Sealed) error {
+	if f.store == nil {
+		return ErrNoStorageConfigured
+	}
+	return f.store.UpdateSealed(sealed)
+}
+
+func (f *FeedthroughStore) DeleteSealed(name string) error {
+	if f.store == nil {
+		return ErrNoStorageConfigured
+	}
+	return f.store.DeleteSealed(name)
+}
+
 func (f *FeedthroughStore) Persist() error {
 	if f.store == nil {
 		return nil
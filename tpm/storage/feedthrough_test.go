@@ -225,3 +225,69 @@ func TestFeedthroughStore_AKOperations(t *testing.T) {
 	err = store.Load()
 	require.NoError(t, err)
 }
+
+func TestFeedthroughStore_NilSealedOperations(t *testing.T) {
+	t.Parallel()
+
+	store := NewFeedthroughStore(nil)
+
+	err := store.AddSealed(&Sealed{Name: "1st-sealed"})
+	require.ErrorIs(t, err, ErrNoStorageConfigured)
+
+	s, err := store.GetSealed("1st-sealed")
+	require.ErrorIs(t, err, ErrNoStorageConfigured)
+	require.Nil(t, s)
+
+	err = store.UpdateSealed(&Sealed{Name: "1st-sealed"})
+	require.ErrorIs(t, err, ErrNoStorageConfigured)
+
+	names := store.ListSealedNames()
+	require.Empty(t, names)
+
+	sealed, err := store.ListSealed()
+	require.ErrorIs(t, err, ErrNoStorageConfigured)
+	require.Empty(t, sealed)
+
+	err = store.DeleteSealed("1st-sealed")
+	require.ErrorIs(t, err, ErrNoStorageConfigured)
+}
+
+func TestFeedthroughStore_SealedOperations(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	store := NewFeedthroughStore(NewDirstore(tempDir))
+
+	sealed1 := &Sealed{Name: "1st-sealed"}
+	sealed2 := &Sealed{Name: "2nd-sealed"}
+
+	err := store.AddSealed(sealed1)
+	require.NoError(t, err)
+
+	err = store.AddSealed(sealed2)
+	require.NoError(t, err)
+
+	err = store.AddSealed(&Sealed{Name: "1st-sealed"})
+	require.EqualError(t, err, "already exists")
+
+	s, err := store.GetSealed("1st-sealed")
+	require.NoError(t, err)
+	require.Equal(t, sealed1, s)
+
+	err = store.UpdateSealed(s)
+	require.NoError(t, err)
+
+	names := store.ListSealedNames()
+	require.Equal(t, []string{"1st-sealed", "2nd-sealed"}, names)
+
+	sealed, err := store.ListSealed()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*Sealed{sealed1, sealed2}, sealed)
+
+	err = store.DeleteSealed("1st-sealed")
+	require.NoError(t, err)
+
+	sealed, err = store.ListSealed()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*Sealed{sealed2}, sealed)
+}
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"os"
 	"regexp"
 	"strings"
 
@@ -13,9 +18,33 @@ import (
 // Filestore is a concrete implementation of the TPMStore interface that
 // keeps an in-memory map of AKs and TPM Keys. The current state of the
 // in-memory storage can be persisted to a file.
+//
+// Changes made to the in-memory storage are recorded, and replayed on top of
+// the current contents of the file when persisting, while holding an
+// exclusive lock on the file. This allows multiple processes to use the same
+// file. The file is written to a temporary file first, and renamed
+// afterwards, so that it's never left partially written.
 type Filestore struct {
 	store    *jsonstore.JSONStore
 	filepath string
+	changes  []change
+	info     os.FileInfo
+}
+
+type changeOp int
+
+const (
+	opAdd changeOp = iota
+	opUpdate
+	opDelete
+)
+
+// change is a modification of the in-memory storage that hasn't been
+// persisted yet.
+type change struct {
+	op    changeOp
+	key   string
+	value json.RawMessage
 }
 
 // NewFilestore creates a new instance of a Filestore
@@ -34,7 +63,7 @@ func (s *Filestore) AddKey(k *Key) error {
 	if err := s.store.Get(kk, nil); err != nil {
 		nsk := &jsonstore.NoSuchKeyError{}
 		if errors.As(err, nsk) {
-			return s.store.Set(kk, k)
+			return s.add(kk, k)
 		}
 		return err
 	}
@@ -47,7 +76,7 @@ func (s *Filestore) AddAK(ak *AK) error {
 	if err := s.store.Get(akKey, nil); err != nil {
 		nsk := &jsonstore.NoSuchKeyError{}
 		if errors.As(err, nsk) {
-			return s.store.Set(akKey, ak)
+			return s.add(akKey, ak)
 		}
 		return err
 	}
@@ -91,7 +120,7 @@ func (s *Filestore) UpdateKey(k *Key) error {
 		return err
 	}
 
-	return s.store.Set(kk, k)
+	return s.update(kk, k)
 }
 
 func (s *Filestore) UpdateAK(ak *AK) error {
@@ -104,7 +133,7 @@ func (s *Filestore) UpdateAK(ak *AK) error {
 		return err
 	}
 
-	return s.store.Set(akKey, ak)
+	return s.update(akKey, ak)
 }
 
 func (s *Filestore) DeleteKey(name string) error {
@@ -117,7 +146,7 @@ func (s *Filestore) DeleteKey(name string) error {
 		return err
 	}
 
-	s.store.Delete(kk)
+	s.delete(kk)
 	return nil
 }
 
@@ -131,12 +160,12 @@ func (s *Filestore) DeleteAK(name string) error {
 		return err
 	}
 
-	s.store.Delete(ka)
+	s.delete(ka)
 	return nil
 }
 
 func (s *Filestore) ListKeys() ([]*Key, error) {
-	keys := s.store.GetAll(regexp.MustCompile(keyPrefix))
+	keys := s.store.GetAll(regexp.MustCompile("^" + keyPrefix))
 	var result = make([]*Key, 0, len(keys))
 	for _, v := range keys {
 		key := &Key{}
@@ -151,7 +180,7 @@ func (s *Filestore) ListKeys() ([]*Key, error) {
 }
 
 func (s *Filestore) ListAKs() ([]*AK, error) {
-	aks := s.store.GetAll(regexp.MustCompile(akPrefix))
+	aks := s.store.GetAll(regexp.MustCompile("^" + akPrefix))
 	var result = make([]*AK, 0, len(aks))
 	for _, v := range aks {
 		ak := &AK{}
@@ -189,17 +218,276 @@ func (s *Filestore) ListAKNames() []string {
 	return result
 }
 
+func (s *Filestore) AddSealed(sealed *Sealed) error {
+	sk := keyForSealed(sealed.Name)
+	if err := s.store.Get(sk, nil); err != nil {
+		nsk := &jsonstore.NoSuchKeyError{}
+		if errors.As(err, nsk) {
+			return s.add(sk, sealed)
+		}
+		return err
+	}
+
+	return ErrExists
+}
+
+func (s *Filestore) GetSealed(name string) (*Sealed, error) {
+	sealed := &Sealed{}
+	if err := s.store.Get(keyForSealed(name), sealed); err != nil {
+		nsk := &jsonstore.NoSuchKeyError{}
+		if errors.As(err, nsk) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+func (s *Filestore) UpdateSealed(sealed *Sealed) error {
+	sk := keyForSealed(sealed.Name)
+	if err := s.store.Get(sk, nil); err != nil {
+		nsk := &jsonstore.NoSuchKeyError{}
+		if errors.As(err, nsk) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return s.update(sk, sealed)
+}
+
+func (s *Filestore) DeleteSealed(name string) error {
+	sk := keyForSealed(name)
+	if err := s.store.Get(sk, nil); err != nil {
+		nsk := &jsonstore.NoSuchKeyError{}
+		if errors.As(err, nsk) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	s.delete(sk)
+	return nil
+}
+
+func (s *Filestore) ListSealed() ([]*Sealed, error) {
+	objects := s.store.GetAll(regexp.MustCompile("^" + sealedPrefix))
+	var result = make([]*Sealed, 0, len(objects))
+	for _, v := range objects {
+		sealed := &Sealed{}
+		err := json.Unmarshal(v, sealed)
+		if err != nil {
+			return nil, fmt.Errorf("failed unmarshaling sealed data: %w", err)
+		}
+		result = append(result, sealed)
+	}
+
+	return result, nil
+}
+
+func (s *Filestore) ListSealedNames() []string {
+	keys := s.store.Keys()
+	var result = make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasPrefix(k, sealedPrefix) {
+			result = append(result, strings.TrimPrefix(k, sealedPrefix))
+		}
+	}
+
+	return result
+}
+
+func (s *Filestore) add(key string, v any) error {
+	return s.set(opAdd, key, v)
+}
+
+func (s *Filestore) update(key string, v any) error {
+	return s.set(opUpdate, key, v)
+}
+
+func (s *Filestore) set(op changeOp, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Set(key, json.RawMessage(data)); err != nil {
+		return err
+	}
+	s.changes = append(s.changes, change{op: op, key: key, value: data})
+	return nil
+}
+
+func (s *Filestore) delete(key string) {
+	s.store.Delete(key)
+	s.changes = append(s.changes, change{op: opDelete, key: key})
+}
+
+// Persist writes the changes made since the last Load or Persist to the
+// file. The changes are applied to the current contents of the file, so
+// that changes persisted by other processes in the meantime aren't lost.
+// It returns ErrExists if an object that was added exists in the file, and
+// ErrNotFound if an object that was updated doesn't exist in the file
+// anymore. In both cases the file isn't modified, the pending changes are
+// discarded, and the in-memory storage is reset to the contents of the file,
+// as if Load was called.
 func (s *Filestore) Persist() error {
-	return jsonstore.Save(s.store, s.filepath)
+	l, err := lockFile(s.filepath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+
+	store, info, err := readJSONStore(s.filepath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		store = &jsonstore.JSONStore{Data: map[string]json.RawMessage{}}
+	case err != nil:
+		return fmt.Errorf("failed reading %q: %w", s.filepath, err)
+	}
+
+	// The changes are applied to a copy, so that the in-memory storage can be
+	// reset to the contents of the file if they conflict.
+	updated := &jsonstore.JSONStore{Data: maps.Clone(store.Data)}
+	if updated.Data == nil {
+		updated.Data = map[string]json.RawMessage{}
+	}
+	for _, c := range s.changes {
+		_, exists := updated.Data[c.key]
+		switch c.op {
+		case opAdd:
+			if exists {
+				s.reset(store, info)
+				return fmt.Errorf("failed persisting %q: %w", c.key, ErrExists)
+			}
+			updated.Data[c.key] = c.value
+		case opUpdate:
+			if !exists {
+				s.reset(store, info)
+				return fmt.Errorf("failed persisting %q: %w", c.key, ErrNotFound)
+			}
+			updated.Data[c.key] = c.value
+		case opDelete:
+			delete(updated.Data, c.key)
+		}
+	}
+	store = updated
+
+	data, err := encodeJSONStore(store, strings.HasSuffix(s.filepath, ".gz"))
+	if err != nil {
+		return fmt.Errorf("failed encoding %q: %w", s.filepath, err)
+	}
+	if err := writeFileAtomic(s.filepath, data); err != nil {
+		return err
+	}
+	if info, err = os.Stat(s.filepath); err != nil {
+		return fmt.Errorf("failed reading %q: %w", s.filepath, err)
+	}
+
+	s.reset(store, info)
+
+	return nil
 }
 
+// reset sets the in-memory storage to the given contents of the file, and
+// discards the pending changes.
+func (s *Filestore) reset(store *jsonstore.JSONStore, info os.FileInfo) {
+	s.store = store
+	s.changes = nil
+	s.info = info
+}
+
+// Load reads the storage from the file. Changes that weren't persisted are
+// discarded. If the file didn't change since it was last loaded or persisted,
+// and there are no pending changes, the in-memory storage is kept as is.
 func (s *Filestore) Load() error {
-	store, err := jsonstore.Open(s.filepath)
+	if len(s.changes) == 0 && s.info != nil && s.store != nil {
+		if info, err := os.Stat(s.filepath); err == nil && sameFile(s.info, info) {
+			return nil
+		}
+	}
+
+	store, info, err := readJSONStore(s.filepath)
 	if err != nil { // TODO: handle different types of errors related to file system?
-		store = new(jsonstore.JSONStore)
+		store, info = new(jsonstore.JSONStore), nil
 	}
-	s.store = store
+	s.reset(store, info)
 	return nil
 }
 
+// sameFile returns whether the file described by a and b is the same, and
+// hasn't been modified. Files are always replaced when persisted, so a
+// modification results in a different file.
+func sameFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// readJSONStore reads a [jsonstore.JSONStore] from the file at `path`, using
+// the same format as [jsonstore.Open]. It also returns the information of
+// the file that was read.
+func readJSONStore(path string) (*jsonstore.JSONStore, os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	data := make(map[string]string)
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, nil, err
+	}
+
+	store := &jsonstore.JSONStore{Data: make(map[string]json.RawMessage, len(data))}
+	for k, v := range data {
+		store.Data[k] = json.RawMessage(v)
+	}
+
+	return store, info, nil
+}
+
+// encodeJSONStore encodes a [jsonstore.JSONStore] using the same format as
+// [jsonstore.Save].
+func encodeJSONStore(store *jsonstore.JSONStore, gz bool) ([]byte, error) {
+	data := make(map[string]string, len(store.Data))
+	for k, v := range store.Data {
+		data[k] = string(v)
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if gz {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
 var _ TPMStore = (*Filestore)(nil)
@@ -2,11 +2,15 @@ package storage
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/schollz/jsonstore"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFilestore_AddKey(t *testing.T) {
@@ -544,3 +548,189 @@ func TestFilestore_ListAKNames(t *testing.T) {
 
 	assert.ElementsMatch(t, expected, got)
 }
+
+func TestFilestore_SealedOperations(t *testing.T) {
+	t.Parallel()
+	t0 := time.Time{} // we're hit by https://github.com/stretchr/testify/issues/950
+	filepath := t.TempDir() + "/store.json"
+	store := NewFilestore(filepath)
+	assert.NoError(t, store.Load())
+
+	// a key with a name that contains the prefix of other types
+	assert.NoError(t, store.AddKey(&Key{Name: "my-sealed-key", CreatedAt: t0}))
+
+	sealed1 := &Sealed{Name: "my-key-1", Data: []byte{1, 2, 3, 4}, CreatedAt: t0}
+	sealed2 := &Sealed{Name: "my-key-2", Data: []byte{5, 6, 7, 8}, CreatedAt: t0}
+	assert.NoError(t, store.AddSealed(sealed1))
+	assert.NoError(t, store.AddSealed(sealed2))
+	assert.ErrorIs(t, store.AddSealed(sealed1), ErrExists)
+	assert.NoError(t, store.Persist())
+
+	store = NewFilestore(filepath)
+	assert.NoError(t, store.Load())
+
+	got, err := store.GetSealed("my-key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, sealed1, got)
+
+	_, err = store.GetSealed("my-key-3")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	got.Data = []byte{9}
+	assert.NoError(t, store.UpdateSealed(got))
+	assert.ErrorIs(t, store.UpdateSealed(&Sealed{Name: "my-key-3"}), ErrNotFound)
+
+	assert.ElementsMatch(t, []string{"my-key-1", "my-key-2"}, store.ListSealedNames())
+	sealed, err := store.ListSealed()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Sealed{got, sealed2}, sealed)
+
+	keys, err := store.ListKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Key{{Name: "my-sealed-key", CreatedAt: t0}}, keys)
+
+	assert.NoError(t, store.DeleteSealed("my-key-1"))
+	assert.ErrorIs(t, store.DeleteSealed("my-key-1"), ErrNotFound)
+	assert.Equal(t, []string{"my-key-2"}, store.ListSealedNames())
+}
+
+func TestFilestore_concurrentWriters(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"store.json", "store.json.gz"} {
+		dir := t.TempDir()
+		filepath := dir + "/" + name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			const writers, keysPerWriter = 8, 5
+			var wg sync.WaitGroup
+			errs := make(chan error, writers*keysPerWriter)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					// each writer uses its own instance, like separate processes do
+					store := NewFilestore(filepath)
+					for j := 0; j < keysPerWriter; j++ {
+						if err := store.Load(); err != nil {
+							errs <- err
+							return
+						}
+						if err := store.AddKey(&Key{Name: fmt.Sprintf("key-%d-%d", i, j)}); err != nil {
+							errs <- err
+							return
+						}
+						if err := store.Persist(); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			store := NewFilestore(filepath)
+			require.NoError(t, store.Load())
+			var expected []string
+			for i := 0; i < writers; i++ {
+				for j := 0; j < keysPerWriter; j++ {
+					expected = append(expected, fmt.Sprintf("key-%d-%d", i, j))
+				}
+			}
+			assert.ElementsMatch(t, expected, store.ListKeyNames())
+
+			// no temporary files are left behind
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.Contains(t, []string{name, name + ".lock"}, e.Name())
+			}
+		})
+	}
+}
+
+func TestFilestore_conflictingWriters(t *testing.T) {
+	t.Parallel()
+	filepath := t.TempDir() + "/store.json"
+	store1 := NewFilestore(filepath)
+	store2 := NewFilestore(filepath)
+	require.NoError(t, store1.Load())
+	require.NoError(t, store2.Load())
+
+	require.NoError(t, store1.AddKey(&Key{Name: "key", Data: []byte{1}}))
+	require.NoError(t, store2.AddKey(&Key{Name: "key", Data: []byte{2}}))
+	require.NoError(t, store2.AddAK(&AK{Name: "ak"}))
+	require.NoError(t, store1.Persist())
+	assert.ErrorIs(t, store2.Persist(), ErrExists)
+
+	// the failed write reset the in-memory storage to the file contents
+	key, err := store2.GetKey("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, key.Data)
+	assert.Empty(t, store2.ListAKNames())
+
+	// the failed write didn't modify the file, and the conflicting changes
+	// aren't persisted again
+	require.NoError(t, store2.Persist())
+	require.NoError(t, store2.Load())
+	key, err = store2.GetKey("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, key.Data)
+	assert.Empty(t, store2.ListAKNames())
+
+	// the key was deleted by another writer
+	require.NoError(t, store1.DeleteKey("key"))
+	require.NoError(t, store1.Persist())
+	key.Data = []byte{3}
+	require.NoError(t, store2.UpdateKey(key))
+	assert.ErrorIs(t, store2.Persist(), ErrNotFound)
+	_, err = store2.GetKey("key")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// changes can be persisted after a conflict
+	require.NoError(t, store2.AddKey(&Key{Name: "key", Data: []byte{4}}))
+	require.NoError(t, store2.Persist())
+	require.NoError(t, store1.Load())
+	key, err = store1.GetKey("key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{4}, key.Data)
+}
+
+func TestFilestore_Load_changeDetection(t *testing.T) {
+	t.Parallel()
+	filepath := t.TempDir() + "/store.json"
+	store1 := NewFilestore(filepath)
+	store2 := NewFilestore(filepath)
+	require.NoError(t, store1.Load())
+	require.NoError(t, store2.Load())
+
+	require.NoError(t, store1.AddKey(&Key{Name: "1st-key"}))
+	require.NoError(t, store1.Persist())
+
+	// changes by other writers are visible after Load
+	assert.Empty(t, store2.ListKeyNames())
+	require.NoError(t, store2.Load())
+	assert.Equal(t, []string{"1st-key"}, store2.ListKeyNames())
+
+	// the in-memory storage is kept if the file didn't change
+	memStore := store2.store
+	require.NoError(t, store2.Load())
+	assert.Same(t, memStore, store2.store)
+
+	// unchanged writers keep their own changes on Persist
+	require.NoError(t, store2.AddKey(&Key{Name: "2nd-key"}))
+	require.NoError(t, store1.AddAK(&AK{Name: "1st-ak"}))
+	require.NoError(t, store1.Persist())
+	require.NoError(t, store2.Persist())
+	assert.ElementsMatch(t, []string{"1st-key", "2nd-key"}, store2.ListKeyNames())
+	assert.Equal(t, []string{"1st-ak"}, store2.ListAKNames())
+
+	// pending changes are discarded on Load
+	require.NoError(t, store1.Load())
+	require.NoError(t, store1.DeleteKey("1st-key"))
+	require.NoError(t, store1.Load())
+	assert.ElementsMatch(t, []string{"1st-key", "2nd-key"}, store1.ListKeyNames())
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileLock is an exclusive advisory lock on a file. It's used to prevent
+// multiple processes, e.g. an agent and a CLI, from modifying the storage at
+// the same time.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile acquires an exclusive lock on the file at `path`, creating it if
+// it doesn't exist. It blocks until the lock is acquired.
+func lockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening lock file: %w", err)
+	}
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed locking %q: %w", path, err)
+	}
+	return &fileLock{f: f}, nil
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return fmt.Errorf("failed unlocking %q: %w", l.f.Name(), err)
+	}
+	return l.f.Close()
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// `path`, and renames it to `path` afterwards, so that readers never see a
+// partially written file.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed creating temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return fmt.Errorf("failed writing temporary file: %w", err)
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("failed syncing temporary file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed closing temporary file: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed renaming temporary file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package storage
+
+import "os"
+
+// lock is a no-op on platforms without file locking; writes are still
+// atomic, but concurrent writers aren't serialized.
+func lock(*os.File) error {
+	return nil
+}
+
+func unlock(*os.File) error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Migrate copies all AKs, Keys and sealed objects from `src` to `dst`, e.g.
+// to migrate from a [Filestore] to a [Dirstore]. The objects aren't removed
+// from `src`. It returns ErrExists if an object with the same name exists in
+// `dst`, before any object is copied.
+func Migrate(dst, src TPMStore) error {
+	if dst == nil || src == nil {
+		return errors.New("source and destination storage are required")
+	}
+
+	if err := src.Load(); err != nil {
+		return fmt.Errorf("failed loading source storage: %w", err)
+	}
+	if err := dst.Load(); err != nil {
+		return fmt.Errorf("failed loading destination storage: %w", err)
+	}
+
+	aks, err := src.ListAKs()
+	if err != nil {
+		return fmt.Errorf("failed listing AKs: %w", err)
+	}
+	keys, err := src.ListKeys()
+	if err != nil {
+		return fmt.Errorf("failed listing keys: %w", err)
+	}
+	objects, err := src.ListSealed()
+	if err != nil {
+		return fmt.Errorf("failed listing sealed data: %w", err)
+	}
+
+	// check for conflicts first, so that nothing is copied on error
+	for _, ak := range aks {
+		if err := checkNotExists(dst.GetAK(ak.Name)); err != nil {
+			return fmt.Errorf("failed migrating AK %q: %w", ak.Name, err)
+		}
+	}
+	for _, key := range keys {
+		if err := checkNotExists(dst.GetKey(key.Name)); err != nil {
+			return fmt.Errorf("failed migrating key %q: %w", key.Name, err)
+		}
+	}
+	for _, sealed := range objects {
+		if err := checkNotExists(dst.GetSealed(sealed.Name)); err != nil {
+			return fmt.Errorf("failed migrating sealed data %q: %w", sealed.Name, err)
+		}
+	}
+
+	for _, ak := range aks {
+		if err := dst.AddAK(ak); err != nil {
+			return fmt.Errorf("failed migrating AK %q: %w", ak.Name, err)
+		}
+	}
+	for _, key := range keys {
+		if err := dst.AddKey(key); err != nil {
+			return fmt.Errorf("failed migrating key %q: %w", key.Name, err)
+		}
+	}
+	for _, sealed := range objects {
+		if err := dst.AddSealed(sealed); err != nil {
+			return fmt.Errorf("failed migrating sealed data %q: %w", sealed.Name, err)
+		}
+	}
+
+	if err := dst.Persist(); err != nil {
+		return fmt.Errorf("failed persisting destination storage: %w", err)
+	}
+
+	return nil
+}
+
+func checkNotExists[T any](_ T, err error) error {
+	switch {
+	case err == nil:
+		return ErrExists
+	case errors.Is(err, ErrNotFound):
+		return nil
+	default:
+		return err
+	}
+}
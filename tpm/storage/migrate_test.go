@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	t0 := time.Time{} // we're hit by https://github.com/stretchr/testify/issues/950
+	ak := &AK{Name: "ak", Data: []byte{1}, CreatedAt: t0}
+	key := &Key{Name: "key", Data: []byte{2}, AttestedBy: "ak", CreatedAt: t0}
+	sealed := &Sealed{Name: "sealed", Data: []byte{3}, CreatedAt: t0}
+
+	fill := func(t *testing.T, s TPMStore) {
+		t.Helper()
+		require.NoError(t, s.Load())
+		require.NoError(t, s.AddAK(ak))
+		require.NoError(t, s.AddKey(key))
+		require.NoError(t, s.AddSealed(sealed))
+		require.NoError(t, s.Persist())
+	}
+	check := func(t *testing.T, s TPMStore) {
+		t.Helper()
+		require.NoError(t, s.Load())
+		aks, err := s.ListAKs()
+		require.NoError(t, err)
+		assert.Equal(t, []*AK{ak}, aks)
+		keys, err := s.ListKeys()
+		require.NoError(t, err)
+		assert.Equal(t, []*Key{key}, keys)
+		objects, err := s.ListSealed()
+		require.NoError(t, err)
+		assert.Equal(t, []*Sealed{sealed}, objects)
+	}
+
+	t.Run("filestore-to-dirstore", func(t *testing.T) {
+		t.Parallel()
+		src := NewFilestore(t.TempDir() + "/store.json")
+		dst := NewDirstore(t.TempDir())
+		fill(t, src)
+		require.NoError(t, Migrate(dst, src))
+		check(t, NewDirstore(dst.directory))
+		check(t, src)
+	})
+	t.Run("dirstore-to-filestore", func(t *testing.T) {
+		t.Parallel()
+		src := NewDirstore(t.TempDir())
+		dst := NewFilestore(t.TempDir() + "/store.json.gz")
+		fill(t, src)
+		require.NoError(t, Migrate(dst, src))
+		check(t, NewFilestore(dst.filepath))
+		check(t, src)
+	})
+	t.Run("fail/exists", func(t *testing.T) {
+		t.Parallel()
+		src := NewFilestore(t.TempDir() + "/store.json")
+		dst := NewDirstore(t.TempDir())
+		fill(t, src)
+		require.NoError(t, dst.AddSealed(&Sealed{Name: "sealed"}))
+		assert.ErrorIs(t, Migrate(dst, src), ErrExists)
+		// nothing was copied
+		assert.Empty(t, dst.ListAKNames())
+		assert.Empty(t, dst.ListKeyNames())
+	})
+	t.Run("fail/nil", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, Migrate(nil, NewDirstore(t.TempDir())))
+	})
+}
@@ -33,6 +33,13 @@ type TPMStore interface {
 	UpdateAK(ak *AK) error
 	DeleteAK(name string) error
 
+	ListSealed() ([]*Sealed, error)
+	ListSealedNames() []string
+	GetSealed(name string) (*Sealed, error)
+	AddSealed(sealed *Sealed) error
+	UpdateSealed(sealed *Sealed) error
+	DeleteSealed(name string) error
+
 	Persist() error
 	Load() error
 }
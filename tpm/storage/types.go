@@ -73,6 +73,12 @@ type Key struct {
 	AttestedBy string
 	Chain      []*x509.Certificate
 	CreatedAt  time.Time
+	// Policy is the serialized authorization policy of the Key. It's empty
+	// for Keys that can be used without authorization.
+	Policy []byte
+	// Handle is the persistent handle the Key is persisted at. It's zero
+	// for Keys that are loaded from Data on every use.
+	Handle uint32
 }
 
 // MarshalJSON marshals the Key into JSON.
@@ -88,6 +94,8 @@ func (key *Key) MarshalJSON() ([]byte, error) {
 		Data:       key.Data,
 		AttestedBy: key.AttestedBy,
 		CreatedAt:  key.CreatedAt,
+		Policy:     key.Policy,
+		Handle:     key.Handle,
 	}
 
 	if len(chain) > 0 {
@@ -112,6 +120,8 @@ func (key *Key) UnmarshalJSON(data []byte) error {
 	key.Data = sk.Data
 	key.AttestedBy = sk.AttestedBy
 	key.CreatedAt = sk.CreatedAt
+	key.Policy = sk.Policy
+	key.Handle = sk.Handle
 
 	if len(sk.Chain) > 0 {
 		chain := make([]*x509.Certificate, len(sk.Chain))
@@ -128,16 +138,53 @@ func (key *Key) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Sealed is the type used to store sealed data objects.
+type Sealed struct {
+	Name      string
+	Data      []byte
+	CreatedAt time.Time
+}
+
+// MarshalJSON marshals the Sealed data object into JSON.
+func (s *Sealed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(serializedSealed{
+		Name:      s.Name,
+		Type:      typeSealed,
+		Data:      s.Data,
+		CreatedAt: s.CreatedAt,
+	})
+}
+
+// UnmarshalJSON unmarshals `data` into a Sealed data object.
+func (s *Sealed) UnmarshalJSON(data []byte) error {
+	ss := &serializedSealed{}
+	if err := json.Unmarshal(data, ss); err != nil {
+		return fmt.Errorf("failed unmarshaling serialized sealed data: %w", err)
+	}
+
+	if ss.Type != typeSealed {
+		return fmt.Errorf("unexpected serialized data type %q", ss.Type)
+	}
+
+	s.Name = ss.Name
+	s.Data = ss.Data
+	s.CreatedAt = ss.CreatedAt
+
+	return nil
+}
+
 const (
-	akPrefix  = "ak-"
-	keyPrefix = "key-"
+	akPrefix     = "ak-"
+	keyPrefix    = "key-"
+	sealedPrefix = "sealed-"
 )
 
 type tpmObjectType string
 
 const (
-	typeAK  tpmObjectType = "AK"
-	typeKey tpmObjectType = "KEY"
+	typeAK     tpmObjectType = "AK"
+	typeKey    tpmObjectType = "KEY"
+	typeSealed tpmObjectType = "SEALED"
 )
 
 // serializedAK is the struct used when marshaling
@@ -159,6 +206,17 @@ type serializedKey struct {
 	AttestedBy string        `json:"attestedBy"`
 	Chain      [][]byte      `json:"chain"`
 	CreatedAt  time.Time     `json:"createdAt"`
+	Policy     []byte        `json:"policy,omitempty"`
+	Handle     uint32        `json:"handle,omitempty"`
+}
+
+// serializedSealed is the struct used when marshaling
+// a storage Sealed data object to JSON.
+type serializedSealed struct {
+	Name      string        `json:"name"`
+	Type      tpmObjectType `json:"type"`
+	Data      []byte        `json:"data"`
+	CreatedAt time.Time     `json:"createdAt"`
 }
 
 // keyForAK returns the key to use when storing an AK.
@@ -170,3 +228,8 @@ func keyForAK(name string) string {
 func keyForKey(name string) string {
 	return fmt.Sprintf("%s%s", keyPrefix, name)
 }
+
+// keyForSealed returns the key to use when storing a Sealed data object.
+func keyForSealed(name string) string {
+	return fmt.Sprintf("%s%s", sealedPrefix, name)
+}
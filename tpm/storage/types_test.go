@@ -69,6 +69,8 @@ func TestKey_MarshalUnmarshal(t *testing.T) {
 		AttestedBy: "ak1",
 		Chain:      []*x509.Certificate{cert, ca.Intermediate},
 		CreatedAt:  time.Time{},
+		Policy:     []byte(`{"password":true}`),
+		Handle:     0x81000010,
 	}
 
 	data, err := json.Marshal(key)
@@ -79,3 +81,25 @@ func TestKey_MarshalUnmarshal(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, key, rkey)
 }
+
+func TestSealed_MarshalUnmarshal(t *testing.T) {
+	sealed := &Sealed{
+		Name:      "sealed1",
+		Data:      []byte{1, 2, 3, 4},
+		CreatedAt: time.Time{},
+	}
+
+	data, err := json.Marshal(sealed)
+	require.NoError(t, err)
+
+	var rsealed = &Sealed{}
+	err = json.Unmarshal(data, rsealed)
+	require.NoError(t, err)
+	require.Equal(t, sealed, rsealed)
+
+	// a key can't be unmarshaled as sealed data
+	data, err = json.Marshal(&Key{Name: "key1"})
+	require.NoError(t, err)
+	err = json.Unmarshal(data, rsealed)
+	require.EqualError(t, err, `unexpected serialized data type "KEY"`)
+}
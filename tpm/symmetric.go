@@ -0,0 +1,317 @@
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	_ "crypto/sha1" //nolint:gosec // HMAC keys can use SHA-1
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+
+	internalkey "go.step.sm/crypto/tpm/internal/key"
+	"go.step.sm/crypto/tpm/storage"
+)
+
+// CipherMode is the block cipher mode used to encrypt and decrypt data using
+// a TPM AES Key.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type CipherMode int
+
+const (
+	// CipherModeCFB is the AES-CFB mode.
+	CipherModeCFB CipherMode = iota + 1
+	// CipherModeCTR is the AES-CTR mode.
+	CipherModeCTR
+)
+
+// String returns a text representation of the cipher mode.
+func (m CipherMode) String() string {
+	switch m {
+	case CipherModeCFB:
+		return "CFB"
+	case CipherModeCTR:
+		return "CTR"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(m))
+	}
+}
+
+func (m CipherMode) algorithm() (tpm2.Algorithm, error) {
+	switch m {
+	case CipherModeCFB:
+		return tpm2.AlgCFB, nil
+	case CipherModeCTR:
+		return tpm2.AlgCTR, nil
+	default:
+		return 0, fmt.Errorf("unsupported cipher mode %s", m)
+	}
+}
+
+// HMAC computes HMACs using a TPM HMAC Key. It implements [hash.Hash]. Data
+// written to it is buffered, and sent to the TPM when the HMAC is computed,
+// so the HMAC key never leaves the TPM.
+//
+// As [hash.Hash] can't return errors, Sum returns its input unchanged if the
+// TPM fails to compute the HMAC, e.g. if the password is wrong, or the TPM is
+// in lockout, and the error is returned by Err. Prefer MAC, which returns the
+// error directly.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type HMAC struct {
+	tpm      *TPM
+	key      Key
+	hash     crypto.Hash
+	password string
+	buf      []byte
+	err      error
+}
+
+var _ hash.Hash = (*HMAC)(nil)
+
+// Write adds data to the HMAC. It never returns an error.
+func (h *HMAC) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	return len(p), nil
+}
+
+// Sum appends the HMAC of the data written so far to b. It doesn't change
+// the underlying state. If the TPM fails to compute the HMAC, b is returned
+// unchanged, and the error is returned by Err. Callers must check Err before
+// using the result, or use MAC instead.
+func (h *HMAC) Sum(b []byte) []byte {
+	mac, err := h.MAC()
+	if h.err = err; err != nil {
+		return b
+	}
+	return append(b, mac...)
+}
+
+// Err returns the error of the last call to Sum, or nil if it succeeded.
+func (h *HMAC) Err() error {
+	return h.err
+}
+
+// MAC returns the HMAC of the data written so far, computed by the TPM. It
+// doesn't change the underlying state.
+func (h *HMAC) MAC() (mac []byte, err error) {
+	ctx := context.Background()
+	if err = h.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, h.tpm, &err)
+
+	session, err := h.tpm.encryptedSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed computing HMAC with key %q: %w", h.key.name, err)
+	}
+
+	if mac, err = internalkey.HMAC(h.tpm.rwc, h.key.data, h.password, session, h.buf); err != nil {
+		return nil, fmt.Errorf("failed computing HMAC with key %q: %w", h.key.name, err)
+	}
+
+	return
+}
+
+// Reset resets the HMAC to its initial state.
+func (h *HMAC) Reset() {
+	h.buf = h.buf[:0]
+	h.err = nil
+}
+
+// Size returns the number of bytes Sum appends.
+func (h *HMAC) Size() int {
+	return h.hash.Size()
+}
+
+// BlockSize returns the block size of the hash function of the HMAC.
+func (h *HMAC) BlockSize() int {
+	return h.hash.New().BlockSize()
+}
+
+// GetHMAC returns an [HMAC] for a TPM HMAC Key identified by `name`. The
+// password is required if the Key was created with one.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetHMAC(ctx context.Context, name, password string) (*HMAC, error) {
+	key, public, err := t.getSymmetricKey(ctx, name, tpm2.AlgKeyedHash)
+	if err != nil {
+		return nil, err
+	}
+	if public.KeyedHashParameters == nil || public.KeyedHashParameters.Alg != tpm2.AlgHMAC {
+		return nil, fmt.Errorf("key %q is not an HMAC key", name)
+	}
+	h, err := public.KeyedHashParameters.Hash.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting HMAC hash of key %q: %w", name, err)
+	}
+
+	return &HMAC{
+		tpm:      t,
+		key:      *key,
+		hash:     h,
+		password: password,
+	}, nil
+}
+
+// HMAC returns an [HMAC] backed by the Key. The Key must be an HMAC key.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *Key) HMAC(ctx context.Context, password string) (*HMAC, error) {
+	return k.tpm.GetHMAC(ctx, k.name, password)
+}
+
+// SymmetricCipher encrypts and decrypts data using a TPM AES Key. The AES
+// key never leaves the TPM.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+type SymmetricCipher struct {
+	tpm      *TPM
+	key      Key
+	keySize  int
+	password string
+}
+
+// KeySize returns the size of the AES key in bits.
+func (c *SymmetricCipher) KeySize() int {
+	return c.keySize
+}
+
+// BlockSize returns the AES block size, which is also the size of the
+// initialization vectors.
+func (c *SymmetricCipher) BlockSize() int {
+	return aes.BlockSize
+}
+
+// Encrypt encrypts the plaintext using the given mode and initialization
+// vector. The initialization vector must be [aes.BlockSize] bytes, and it
+// must be unique for every encryption using the same Key.
+func (c *SymmetricCipher) Encrypt(mode CipherMode, iv, plaintext []byte) ([]byte, error) {
+	return c.encryptDecrypt(false, mode, iv, plaintext)
+}
+
+// Decrypt decrypts the ciphertext using the given mode and the
+// initialization vector used to encrypt it.
+func (c *SymmetricCipher) Decrypt(mode CipherMode, iv, ciphertext []byte) ([]byte, error) {
+	return c.encryptDecrypt(true, mode, iv, ciphertext)
+}
+
+func (c *SymmetricCipher) encryptDecrypt(decrypt bool, mode CipherMode, iv, message []byte) (result []byte, err error) {
+	alg, err := mode.algorithm()
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid initialization vector size %d; it must be %d bytes", len(iv), aes.BlockSize)
+	}
+	if len(message) == 0 {
+		return []byte{}, nil
+	}
+
+	ctx := context.Background()
+	if err = c.tpm.open(goTPMCall(ctx)); err != nil {
+		return nil, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, c.tpm, &err)
+
+	session, err := c.tpm.encryptedSession(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed using key %q: %w", c.key.name, err)
+	}
+
+	if result, err = internalkey.EncryptDecrypt(c.tpm.rwc, c.key.data, c.password, session, decrypt, alg, iv, message); err != nil {
+		if decrypt {
+			return nil, fmt.Errorf("failed decrypting with key %q: %w", c.key.name, err)
+		}
+		return nil, fmt.Errorf("failed encrypting with key %q: %w", c.key.name, err)
+	}
+
+	return
+}
+
+// GetSymmetricCipher returns a [SymmetricCipher] for a TPM AES Key
+// identified by `name`. The password is required if the Key was created
+// with one.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (t *TPM) GetSymmetricCipher(ctx context.Context, name, password string) (*SymmetricCipher, error) {
+	key, public, err := t.getSymmetricKey(ctx, name, tpm2.AlgSymCipher)
+	if err != nil {
+		return nil, err
+	}
+	if public.SymCipherParameters == nil || public.SymCipherParameters.Symmetric == nil || public.SymCipherParameters.Symmetric.Alg != tpm2.AlgAES {
+		return nil, fmt.Errorf("key %q is not an AES key", name)
+	}
+
+	return &SymmetricCipher{
+		tpm:      t,
+		key:      *key,
+		keySize:  int(public.SymCipherParameters.Symmetric.KeyBits),
+		password: password,
+	}, nil
+}
+
+// SymmetricCipher returns a [SymmetricCipher] backed by the Key. The Key must
+// be an AES key.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *Key) SymmetricCipher(ctx context.Context, password string) (*SymmetricCipher, error) {
+	return k.tpm.GetSymmetricCipher(ctx, k.name, password)
+}
+
+// getSymmetricKey returns the Key identified by `name` and its public area.
+// It returns an error if the Key isn't of type `keyType`.
+func (t *TPM) getSymmetricKey(ctx context.Context, name string, keyType tpm2.Algorithm) (key *Key, public tpm2.Public, err error) {
+	if err = t.open(ctx); err != nil {
+		return nil, public, fmt.Errorf("failed opening TPM: %w", err)
+	}
+	defer closeTPM(ctx, t, &err)
+
+	skey, err := t.store.GetKey(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, public, fmt.Errorf("failed getting key %q: %w", name, ErrNotFound)
+		}
+		return nil, public, fmt.Errorf("failed getting key %q: %w", name, err)
+	}
+
+	if public, err = internalkey.Public(skey.Data); err != nil {
+		return nil, public, fmt.Errorf("failed getting public area of key %q: %w", name, err)
+	}
+	if public.Type != keyType {
+		switch keyType {
+		case tpm2.AlgKeyedHash:
+			return nil, public, fmt.Errorf("key %q is not an HMAC key", name)
+		default:
+			return nil, public, fmt.Errorf("key %q is not an AES key", name)
+		}
+	}
+
+	return keyFromStorage(skey, t), public, nil
+}
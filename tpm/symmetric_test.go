@@ -0,0 +1,53 @@
+package tpm
+
+import (
+	"crypto"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipherMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      CipherMode
+		wantName  string
+		wantAlg   tpm2.Algorithm
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok cfb", CipherModeCFB, "CFB", tpm2.AlgCFB, assert.NoError},
+		{"ok ctr", CipherModeCTR, "CTR", tpm2.AlgCTR, assert.NoError},
+		{"fail zero", CipherMode(0), "unknown(0)", 0, assert.Error},
+		{"fail unknown", CipherMode(3), "unknown(3)", 0, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantName, tt.mode.String())
+			alg, err := tt.mode.algorithm()
+			tt.assertion(t, err)
+			assert.Equal(t, tt.wantAlg, alg)
+		})
+	}
+}
+
+func TestHMAC_sizes(t *testing.T) {
+	tests := []struct {
+		name          string
+		hash          crypto.Hash
+		wantSize      int
+		wantBlockSize int
+	}{
+		{"sha1", crypto.SHA1, 20, 64},
+		{"sha256", crypto.SHA256, 32, 64},
+		{"sha384", crypto.SHA384, 48, 128},
+		{"sha512", crypto.SHA512, 64, 128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HMAC{hash: tt.hash}
+			assert.Equal(t, tt.wantSize, h.Size())
+			assert.Equal(t, tt.wantBlockSize, h.BlockSize())
+		})
+	}
+}
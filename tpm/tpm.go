@@ -2,6 +2,7 @@ package tpm
 
 import (
 	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"io"
@@ -10,7 +11,9 @@ import (
 
 	"github.com/smallstep/go-attestation/attest"
 
+	"go.step.sm/crypto/tpm/ekverify"
 	closer "go.step.sm/crypto/tpm/internal/close"
+	internalkey "go.step.sm/crypto/tpm/internal/key"
 	"go.step.sm/crypto/tpm/internal/open"
 	"go.step.sm/crypto/tpm/internal/socket"
 	"go.step.sm/crypto/tpm/simulator"
@@ -37,6 +40,7 @@ type TPM struct {
 	info                   *Info
 	caps                   *Capabilities
 	eks                    []*EK
+	session                *internalkey.Session
 }
 
 // NewTPMOption is used to provide options when instantiating a new
@@ -111,14 +115,119 @@ func WithCapabilities(caps *Capabilities) NewTPMOption {
 	}
 }
 
+// WithEncryptedSessions requires encrypted sessions for key creation,
+// signing, decryption, ECDH, sealing, unsealing and random generation.
+// Sessions are salted with the EK and use AES-CFB parameter encryption, so
+// that passwords, sealed data, plaintexts, shared secrets and random bytes
+// don't go over the bus in the clear. Operations that can't be performed
+// using encrypted sessions return [ErrEncryptedSessionsNotSupported]. These
+// include NV index operations, resetting the lockout, changing hierarchy
+// passwords, persisting and evicting keys and the SRK, and importing private
+// keys with [TPM.ImportPrivateKey].
+//
+// By default, the EK is trusted on first use: its public key is read from
+// the TPM, over the same bus the sessions protect, the first time an
+// encrypted session is needed. This protects against passive observers, and
+// against interposers added later, but an active interposer present at that
+// time can replace the EK with its own key and recover the session keys. Use
+// [WithEKPublicKey] or [WithEKVerifier] to only salt sessions with a
+// verified EK.
+//
+// # Experimental
+//
+// Notice: This option is EXPERIMENTAL and may be changed or removed
+// in a later release.
+func WithEncryptedSessions() NewTPMOption {
+	return func(o *options) error {
+		o.encryptedSessions = true
+		return nil
+	}
+}
+
+// WithEKPublicKey sets the public key of the EK used to salt encrypted
+// sessions. The key must be obtained from a trusted source, like a verified
+// EK certificate. Encrypted sessions fail if the EK in the TPM doesn't have
+// this public key, instead of trusting the EK read from the TPM on first use.
+//
+// # Experimental
+//
+// Notice: This option is EXPERIMENTAL and may be changed or removed
+// in a later release.
+func WithEKPublicKey(pub crypto.PublicKey) NewTPMOption {
+	return func(o *options) error {
+		if _, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); !ok {
+			return fmt.Errorf("unsupported EK public key type %T", pub)
+		}
+		o.ekPublicKey = pub
+		return nil
+	}
+}
+
+// WithEKVerifier sets the verifier used to verify the EK used to salt
+// encrypted sessions. The EK certificate is read from the NV index defined
+// in the TCG EK Credential Profile, and encrypted sessions fail if the
+// certificate can't be verified, or if it doesn't match the EK. TPMs without
+// an EK certificate in NV, like those with certificates that are only
+// available online, must use [WithEKPublicKey] instead.
+//
+// # Experimental
+//
+// Notice: This option is EXPERIMENTAL and may be changed or removed
+// in a later release.
+func WithEKVerifier(v *ekverify.Verifier) NewTPMOption {
+	return func(o *options) error {
+		if v == nil {
+			return errors.New("EK verifier cannot be nil")
+		}
+		o.ekVerifier = v
+		return nil
+	}
+}
+
+// WithOwnerPassword sets the password of the owner hierarchy. It's used to
+// create and persist the SRK, to persist and evict Keys, and to define and
+// access NV indices using owner authorization if no other password is
+// provided.
+//
+// # Experimental
+//
+// Notice: This option is EXPERIMENTAL and may be changed or removed
+// in a later release.
+func WithOwnerPassword(password string) NewTPMOption {
+	return func(o *options) error {
+		o.ownerPassword = password
+		return nil
+	}
+}
+
+// WithEndorsementPassword sets the password of the endorsement hierarchy.
+// It's used to create and persist the EK, and to authorize the use of the EK
+// when activating AK credentials.
+//
+// # Experimental
+//
+// Notice: This option is EXPERIMENTAL and may be changed or removed
+// in a later release.
+func WithEndorsementPassword(password string) NewTPMOption {
+	return func(o *options) error {
+		o.endorsementPassword = password
+		return nil
+	}
+}
+
 type options struct {
-	deviceName     string
-	attestConfig   *attest.OpenConfig
-	simulator      simulator.Simulator
-	commandChannel CommandChannel
-	store          storage.TPMStore
-	downloader     *downloader
-	caps           *Capabilities
+	deviceName          string
+	attestConfig        *attest.OpenConfig
+	simulator           simulator.Simulator
+	commandChannel      CommandChannel
+	store               storage.TPMStore
+	downloader          *downloader
+	caps                *Capabilities
+	encryptedSessions   bool
+	ekPublicKey         crypto.PublicKey
+	ekVerifier          *ekverify.Verifier
+	ownerPassword       string
+	endorsementPassword string
 }
 
 func (o *options) validate() error {
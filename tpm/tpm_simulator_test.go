@@ -4,11 +4,16 @@
 package tpm
 
 import (
+	"bytes"
 	"context"
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
@@ -20,6 +25,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 	"github.com/smallstep/go-attestation/attest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -27,6 +34,7 @@ import (
 	"go.step.sm/crypto/keyutil"
 	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/tpm/algorithm"
+	"go.step.sm/crypto/tpm/ekverify"
 	"go.step.sm/crypto/tpm/simulator"
 	"go.step.sm/crypto/tpm/storage"
 	"go.step.sm/crypto/tpm/tss2"
@@ -942,6 +950,429 @@ func Test_signer_Sign(t *testing.T) {
 	}
 }
 
+func TestTPM_GetDecrypter(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	decrypter, err := tpm.GetDecrypter(ctx, "non-existing-key")
+	require.EqualError(t, err, `failed getting key "non-existing-key": not found`)
+	require.Nil(t, decrypter)
+
+	_, err = tpm.CreateKey(ctx, "signing-key", CreateKeyConfig{Algorithm: "RSA", Size: 2048})
+	require.NoError(t, err)
+	decrypter, err = tpm.GetDecrypter(ctx, "signing-key")
+	require.EqualError(t, err, `key "signing-key" is not a decryption key`)
+	require.Nil(t, decrypter)
+
+	_, err = tpm.CreateKey(ctx, "ecc-key", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Decrypt: true})
+	require.NoError(t, err)
+	decrypter, err = tpm.GetDecrypter(ctx, "ecc-key")
+	require.EqualError(t, err, `key "ecc-key" is not an RSA key`)
+	require.Nil(t, decrypter)
+}
+
+func Test_decrypter_Decrypt(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+	config := CreateKeyConfig{
+		Algorithm: "RSA",
+		Size:      2048,
+		Decrypt:   true,
+	}
+	key, err := tpm.CreateKey(ctx, "decryption-key", config)
+	require.NoError(t, err)
+	require.NotNil(t, key)
+
+	decrypter, err := key.Decrypter(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, decrypter)
+
+	pub, ok := decrypter.Public().(*rsa.PublicKey)
+	require.True(t, ok)
+
+	// the public key is the same as the one returned by the signer
+	signer, err := key.Signer(ctx)
+	require.NoError(t, err)
+	require.True(t, pub.Equal(signer.Public()))
+
+	// decryption keys can't be used for signing
+	digest := make([]byte, 32)
+	_, err = signer.Sign(rand.Reader, digest, crypto.SHA256)
+	require.Error(t, err)
+
+	msg := []byte("the-secret-message")
+
+	t.Run("PKCS1v15", func(t *testing.T) {
+		ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, pub, msg)
+		require.NoError(t, err)
+		plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
+		require.NoError(t, err)
+		assert.Equal(t, msg, plaintext)
+		plaintext, err = decrypter.Decrypt(rand.Reader, ciphertext, &rsa.PKCS1v15DecryptOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, msg, plaintext)
+	})
+
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384} {
+		t.Run(fmt.Sprintf("OAEP-%s", h), func(t *testing.T) {
+			ciphertext, err := rsa.EncryptOAEP(h.New(), rand.Reader, pub, msg, nil)
+			require.NoError(t, err)
+			plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: h})
+			require.NoError(t, err)
+			assert.Equal(t, msg, plaintext)
+		})
+	}
+
+	t.Run("OAEP with label", func(t *testing.T) {
+		label := []byte("the-label\x00")
+		ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, msg, label)
+		require.NoError(t, err)
+		plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: label})
+		require.NoError(t, err)
+		assert.Equal(t, msg, plaintext)
+	})
+
+	t.Run("fail wrong label", func(t *testing.T) {
+		ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, msg, []byte("the-label\x00"))
+		require.NoError(t, err)
+		plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("other-label\x00")})
+		assert.Error(t, err)
+		assert.Nil(t, plaintext)
+	})
+}
+
+func TestECDH_ECDH(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	_, err := tpm.GetECDH(ctx, "non-existing-key")
+	require.EqualError(t, err, `failed getting key "non-existing-key": not found`)
+
+	_, err = tpm.CreateKey(ctx, "rsa-key", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Decrypt: true})
+	require.NoError(t, err)
+	_, err = tpm.GetECDH(ctx, "rsa-key")
+	require.EqualError(t, err, `key "rsa-key" is not an ECC key`)
+
+	tests := []struct {
+		size  int
+		curve ecdh.Curve
+	}{
+		{256, ecdh.P256()},
+		{384, ecdh.P384()},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("P-%d", tc.size), func(t *testing.T) {
+			name := fmt.Sprintf("ecdh-key-%d", tc.size)
+			key, err := tpm.CreateKey(ctx, name, CreateKeyConfig{Algorithm: "ECDSA", Size: tc.size, Decrypt: true})
+			require.NoError(t, err)
+
+			e, err := key.ECDH(ctx)
+			require.NoError(t, err)
+			require.Equal(t, tc.curve, e.Curve())
+			require.IsType(t, &ecdsa.PublicKey{}, e.Public())
+			require.NotNil(t, e.PublicKey())
+
+			peer, err := tc.curve.GenerateKey(rand.Reader)
+			require.NoError(t, err)
+
+			got, err := e.ECDH(peer.PublicKey())
+			require.NoError(t, err)
+			want, err := peer.ECDH(e.PublicKey())
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			// the peer key must use the same curve
+			other, err := ecdh.P521().GenerateKey(rand.Reader)
+			require.NoError(t, err)
+			_, err = e.ECDH(other.PublicKey())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func extendPCR(t *testing.T, tpm *TPM, pcr int) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, tpm.open(goTPMCall(ctx)))
+	defer tpm.close(goTPMCall(ctx))
+	err := tpm2.PCRExtend(tpm.rwc, tpmutil.Handle(pcr), tpm2.AlgSHA256, make([]byte, 32), "")
+	require.NoError(t, err)
+}
+
+func TestTPM_Seal(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+	secret := []byte("the sealed secret")
+
+	tests := []struct {
+		name     string
+		config   SealConfig
+		password string
+	}{
+		{"no-policy", SealConfig{}, ""},
+		{"password", SealConfig{Password: "pass"}, "pass"},
+		{"pcrs", SealConfig{PCRs: []int{16}}, ""},
+		{"pcrs-and-password", SealConfig{PCRs: []int{16, 23}, Password: "pass"}, "pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tpm.Seal(ctx, tt.name, secret, tt.config)
+			require.NoError(t, err)
+			require.Equal(t, tt.name, sealed.Name())
+			require.Equal(t, tt.config.PCRs, sealed.PCRs())
+			require.Same(t, tpm, sealed.tpm)
+
+			data, err := sealed.Unseal(ctx, tt.password)
+			require.NoError(t, err)
+			assert.Equal(t, secret, data)
+
+			if tt.password != "" {
+				_, err = tpm.Unseal(ctx, tt.name, "wrong")
+				assert.Error(t, err)
+				_, err = tpm.Unseal(ctx, tt.name, "")
+				assert.EqualError(t, err, fmt.Sprintf("failed unsealing data %q: password is required", tt.name))
+			}
+		})
+	}
+
+	_, err := tpm.Seal(ctx, "no-policy", secret, SealConfig{})
+	assert.ErrorIs(t, err, ErrExists)
+
+	_, err = tpm.Seal(ctx, "too-long", make([]byte, 129), SealConfig{})
+	assert.Error(t, err)
+
+	_, err = tpm.Seal(ctx, "bad-pcr", secret, SealConfig{PCRs: []int{24}})
+	assert.EqualError(t, err, "invalid PCR index 24")
+
+	// extending a PCR the data is bound to prevents unsealing it
+	extendPCR(t, tpm, 16)
+	_, err = tpm.Unseal(ctx, "pcrs", "")
+	assert.Error(t, err)
+	_, err = tpm.Unseal(ctx, "pcrs-and-password", "pass")
+	assert.Error(t, err)
+
+	data, err := tpm.Unseal(ctx, "password", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, secret, data)
+
+	_, err = tpm.Unseal(ctx, "non-existing", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTPM_ListSealed(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	_, err := tpm.Seal(ctx, "first", []byte("first secret"), SealConfig{})
+	require.NoError(t, err)
+	_, err = tpm.Seal(ctx, "", []byte("second secret"), SealConfig{PCRs: []int{16}})
+	require.NoError(t, err)
+
+	sealed, err := tpm.ListSealed(ctx)
+	require.NoError(t, err)
+	require.Len(t, sealed, 2)
+
+	s, err := tpm.GetSealed(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, "first", s.Name())
+	require.Empty(t, s.PCRs())
+
+	err = tpm.DeleteSealed(ctx, "first")
+	require.NoError(t, err)
+
+	_, err = tpm.GetSealed(ctx, "first")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = tpm.DeleteSealed(ctx, "first")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	sealed, err = tpm.ListSealed(ctx)
+	require.NoError(t, err)
+	require.Len(t, sealed, 1)
+}
+
+func TestTPM_ReadPCRs(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	pcrs, err := tpm.ReadPCRs(ctx, PCRSelection{})
+	require.NoError(t, err)
+	require.Len(t, pcrs, 24)
+	for i, pcr := range pcrs {
+		assert.Equal(t, i, pcr.Index)
+		assert.Equal(t, crypto.SHA256, pcr.Hash)
+		assert.Len(t, pcr.Digest, 32)
+	}
+
+	pcrs, err = tpm.ReadPCRs(ctx, PCRSelection{Hash: crypto.SHA1, PCRs: []int{0, 16}})
+	require.NoError(t, err)
+	require.Len(t, pcrs, 2)
+	assert.Equal(t, 0, pcrs[0].Index)
+	assert.Equal(t, 16, pcrs[1].Index)
+	assert.Equal(t, crypto.SHA1, pcrs[1].Hash)
+	assert.Len(t, pcrs[1].Digest, 20)
+
+	_, err = tpm.ReadPCRs(ctx, PCRSelection{Hash: crypto.SHA512})
+	assert.EqualError(t, err, "unsupported PCR hash SHA-512")
+
+	_, err = tpm.ReadPCRs(ctx, PCRSelection{PCRs: []int{24}})
+	assert.EqualError(t, err, "invalid PCR index 24")
+}
+
+func TestAK_Quote(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	ak, err := tpm.CreateAK(ctx, "first-ak")
+	require.NoError(t, err)
+
+	// measure the events into PCR 16, so that they can be replayed
+	events := []testEvent{
+		{16, 0x0d, []byte("first event")},
+		{16, 0x0d, []byte("second event")},
+	}
+	require.NoError(t, tpm.open(goTPMCall(ctx)))
+	for _, e := range events {
+		digest := sha256.Sum256(e.data)
+		err := tpm2.PCRExtend(tpm.rwc, tpmutil.Handle(e.index), tpm2.AlgSHA256, digest[:], "")
+		require.NoError(t, err)
+	}
+	require.NoError(t, tpm.close(goTPMCall(ctx)))
+
+	selection := PCRSelection{PCRs: []int{0, 16}}
+	pcrs, err := tpm.ReadPCRs(ctx, selection)
+	require.NoError(t, err)
+	require.Len(t, pcrs, 2)
+	assert.Equal(t, replayTestEvents(events)[16], pcrs[1].Digest)
+
+	nonce := []byte("0123456789abcdef")
+	quote, err := ak.Quote(ctx, nonce, selection)
+	require.NoError(t, err)
+	require.NotEmpty(t, quote.Quote)
+	require.NotEmpty(t, quote.Signature)
+
+	err = VerifyQuote(ak.Public(), quote, nonce, pcrs)
+	require.NoError(t, err)
+
+	// the nonce must match
+	err = VerifyQuote(ak.Public(), quote, []byte("another nonce"), pcrs)
+	assert.Error(t, err)
+
+	// the PCR values must match
+	tampered := []PCR{pcrs[0], {Index: 16, Digest: make([]byte, 32), Hash: crypto.SHA256}}
+	err = VerifyQuote(ak.Public(), quote, nonce, tampered)
+	assert.Error(t, err)
+
+	// all quoted PCRs must be provided
+	err = VerifyQuote(ak.Public(), quote, nonce, pcrs[:1])
+	assert.Error(t, err)
+
+	// the quote must be signed by the AK
+	other, err := tpm.CreateAK(ctx, "second-ak")
+	require.NoError(t, err)
+	err = VerifyQuote(other.Public(), quote, nonce, pcrs)
+	assert.Error(t, err)
+
+	// the event log can be replayed against the verified PCRs
+	log, err := ParseEventLog(newTestEventLog(t, events))
+	require.NoError(t, err)
+	replayed, err := log.Replay(pcrs)
+	require.NoError(t, err)
+	if assert.Len(t, replayed, 2) {
+		assert.Equal(t, []byte("first event"), replayed[0].Data)
+		assert.Equal(t, []byte("second event"), replayed[1].Data)
+	}
+
+	_, err = log.Replay(tampered)
+	assert.Error(t, err)
+
+	_, err = ak.Quote(ctx, nonce, PCRSelection{Hash: crypto.SHA384})
+	assert.EqualError(t, err, "unsupported PCR hash SHA-384")
+}
+
+func TestTPM_NV(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+	const index = 0x01500000
+
+	// larger than the NV buffer, so that it's written in multiple commands
+	data := make([]byte, 1500)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	err = tpm.DefineNV(ctx, index, NVConfig{
+		Size:       len(data),
+		Attributes: DefaultNVAttributes | NVWriteDefine,
+		Password:   "nv-pass",
+	})
+	require.NoError(t, err)
+
+	err = tpm.DefineNV(ctx, index, NVConfig{Size: 10})
+	assert.ErrorIs(t, err, ErrExists)
+
+	nv, err := tpm.GetNV(ctx, index)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(index), nv.Index)
+	assert.Equal(t, len(data), nv.Size)
+	assert.False(t, nv.Written())
+	assert.False(t, nv.WriteLocked())
+
+	err = tpm.WriteNV(ctx, index, data, NVAuth{Password: "nv-pass"})
+	require.NoError(t, err)
+
+	got, err := tpm.ReadNV(ctx, index, NVAuth{Password: "nv-pass"})
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	got, err = tpm.ReadNV(ctx, index, NVAuth{Owner: true})
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	_, err = tpm.ReadNV(ctx, index, NVAuth{Password: "wrong-pass"})
+	assert.Error(t, err)
+
+	err = tpm.WriteNV(ctx, index, append(data, 1), NVAuth{Owner: true})
+	assert.EqualError(t, err, "data size 1501 exceeds NV index 0x01500000 size 1500")
+
+	// partial writes start at the beginning of the NV index
+	err = tpm.WriteNV(ctx, index, []byte("hello"), NVAuth{Owner: true})
+	require.NoError(t, err)
+	got, err = tpm.ReadNV(ctx, index, NVAuth{Owner: true})
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("hello"), data[5:]...), got)
+
+	err = tpm.LockNV(ctx, index, NVAuth{Password: "nv-pass"})
+	require.NoError(t, err)
+
+	nv, err = tpm.GetNV(ctx, index)
+	require.NoError(t, err)
+	assert.True(t, nv.Written())
+	assert.True(t, nv.WriteLocked())
+
+	err = tpm.WriteNV(ctx, index, data, NVAuth{Owner: true})
+	assert.Error(t, err)
+
+	err = tpm.UndefineNV(ctx, index, "")
+	require.NoError(t, err)
+
+	_, err = tpm.GetNV(ctx, index)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = tpm.ReadNV(ctx, index, NVAuth{Owner: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = tpm.WriteNV(ctx, index, data, NVAuth{Owner: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = tpm.LockNV(ctx, index, NVAuth{Owner: true})
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = tpm.UndefineNV(ctx, index, "")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = tpm.DefineNV(ctx, 0x81000001, NVConfig{Size: 10})
+	assert.EqualError(t, err, "invalid NV index 0x81000001")
+	err = tpm.DefineNV(ctx, index, NVConfig{Size: 0})
+	assert.EqualError(t, err, "invalid NV index size 0")
+	err = tpm.DefineNV(ctx, index, NVConfig{Size: 10, Attributes: NVWritten})
+	assert.Error(t, err)
+}
+
 func TestCreateTSS2Signer(t *testing.T) {
 	ctx := context.Background()
 	tpm := newSimulatedTPM(t)
@@ -1115,3 +1546,1078 @@ func TestTPMOnlyFailsWithoutStorageWhenRequired(t *testing.T) {
 	_, err = tpm.GetAKByPermanentIdentifier(ctx, "permanent-identifier")
 	require.ErrorIs(t, err, ErrNoStorageConfigured)
 }
+
+func TestTPM_CreateKey_policy(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+	digest := sha256.Sum256([]byte("data"))
+
+	verify := func(t *testing.T, signer crypto.Signer, opts crypto.SignerOpts) {
+		t.Helper()
+		sig, err := signer.Sign(rand.Reader, digest[:], opts)
+		require.NoError(t, err)
+		switch pub := signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			assert.True(t, ecdsa.VerifyASN1(pub, digest[:], sig))
+		case *rsa.PublicKey:
+			if pss, ok := opts.(*rsa.PSSOptions); ok {
+				assert.NoError(t, rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, pss))
+			} else {
+				assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig))
+			}
+		default:
+			t.Fatalf("unexpected public key type %T", pub)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		config   CreateKeyConfig
+		password string
+	}{
+		{"password", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Password: "pass"}, "pass"},
+		{"pcrs", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Policy: &KeyPolicy{PCRs: &PCRSelection{PCRs: []int{16}}}}, ""},
+		{"pcrs-and-password", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Password: "pass", Policy: &KeyPolicy{
+			PCRs:     &PCRSelection{PCRs: []int{16, 23}},
+			Password: true,
+		}}, "pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tpm.CreateKey(ctx, tt.name, tt.config)
+			require.NoError(t, err)
+
+			signer, err := key.Signer(ctx, WithKeyPassword(tt.password))
+			require.NoError(t, err)
+			verify(t, signer, crypto.SHA256)
+			if _, ok := signer.Public().(*rsa.PublicKey); ok {
+				verify(t, signer, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthAuto})
+			}
+
+			// the policy is encoded in the TSS2 key
+			tpmKey, err := key.ToTSS2(ctx)
+			require.NoError(t, err)
+			tss2Signer, err := CreateTSS2Signer(ctx, tpm, tpmKey, WithTSS2Password(tt.password))
+			require.NoError(t, err)
+			verify(t, tss2Signer, crypto.SHA256)
+
+			// the policy is persisted with the key
+			key, err = tpm.GetKey(ctx, tt.name)
+			require.NoError(t, err)
+			signer, err = key.Signer(ctx, WithKeyPassword(tt.password))
+			require.NoError(t, err)
+			verify(t, signer, crypto.SHA256)
+
+			if tt.password != "" {
+				signer, err = key.Signer(ctx, WithKeyPassword("wrong"))
+				require.NoError(t, err)
+				_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	_, err := tpm.CreateKey(ctx, "no-password", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Policy: &KeyPolicy{Password: true}})
+	assert.EqualError(t, err, "invalid key policy: policy requires a password, but no password was provided")
+
+	_, err = tpm.CreateKey(ctx, "decrypt", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Decrypt: true, Password: "pass"})
+	assert.EqualError(t, err, "invalid key policy: password and policy are not supported for decryption keys")
+
+	// extending a PCR the key is bound to prevents signing with it
+	extendPCR(t, tpm, 16)
+	for _, name := range []string{"pcrs", "pcrs-and-password"} {
+		signer, err := tpm.GetSigner(ctx, name, WithKeyPassword("pass"))
+		require.NoError(t, err)
+		_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		assert.Error(t, err)
+	}
+
+	signer, err := tpm.GetSigner(ctx, "password", WithKeyPassword("pass"))
+	require.NoError(t, err)
+	verify(t, signer, crypto.SHA256)
+}
+
+func TestTPM_CreateKey_authorizedPolicy(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+	digest := sha256.Sum256([]byte("data"))
+
+	rsaAuthority, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecAuthority, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	pcrs, err := tpm.ReadPCRs(ctx, PCRSelection{PCRs: []int{16}})
+	require.NoError(t, err)
+
+	for _, authority := range []crypto.Signer{rsaAuthority, ecAuthority} {
+		name := fmt.Sprintf("%T", authority.Public())
+		t.Run(name, func(t *testing.T) {
+			policyRef := []byte("policy-ref")
+			key, err := tpm.CreateKey(ctx, "", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Password: "pass", Policy: &KeyPolicy{
+				Authority: authority.Public(),
+				PolicyRef: policyRef,
+			}})
+			require.NoError(t, err)
+
+			// without an approved policy
+			signer, err := key.Signer(ctx)
+			require.NoError(t, err)
+			_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			assert.Error(t, err)
+
+			// policy bound to the current value of PCR 16
+			approved := &ApprovedPolicy{PCRs: pcrs}
+			require.NoError(t, approved.Sign(authority, policyRef))
+			signer, err = key.Signer(ctx, WithApprovedPolicy(approved))
+			require.NoError(t, err)
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			require.NoError(t, err)
+			assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+			// policy that requires the password
+			withPassword := &ApprovedPolicy{Password: true}
+			require.NoError(t, withPassword.Sign(authority, policyRef))
+			signer, err = key.Signer(ctx, WithApprovedPolicy(withPassword), WithKeyPassword("pass"))
+			require.NoError(t, err)
+			_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			require.NoError(t, err)
+
+			// policy with the wrong policy reference
+			wrongRef := &ApprovedPolicy{PCRs: pcrs}
+			require.NoError(t, wrongRef.Sign(authority, []byte("other-ref")))
+			signer, err = key.Signer(ctx, WithApprovedPolicy(wrongRef))
+			require.NoError(t, err)
+			_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			assert.Error(t, err)
+
+			// policy with PCR values that don't match
+			otherPCRs := []PCR{{Index: 16, Digest: make([]byte, 32), Hash: crypto.SHA256}}
+			if bytes.Equal(pcrs[0].Digest, otherPCRs[0].Digest) {
+				otherPCRs[0].Digest[0] = 1
+			}
+			wrongPCRs := &ApprovedPolicy{PCRs: otherPCRs}
+			require.NoError(t, wrongPCRs.Sign(authority, policyRef))
+			signer, err = key.Signer(ctx, WithApprovedPolicy(wrongPCRs))
+			require.NoError(t, err)
+			_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			assert.Error(t, err)
+
+			tpmKey, err := key.ToTSS2(ctx, approved, withPassword)
+			require.NoError(t, err)
+			assert.False(t, tpmKey.EmptyAuth)
+			if assert.Len(t, tpmKey.Policy, 1) {
+				assert.Equal(t, 0x16A, tpmKey.Policy[0].CommandCode)
+			}
+			if assert.Len(t, tpmKey.AuthPolicy, 2) {
+				assert.Len(t, tpmKey.AuthPolicy[0].Policy, 2)
+				assert.Equal(t, int(tpm2.CmdPolicyPCR), tpmKey.AuthPolicy[0].Policy[0].CommandCode)
+				assert.Len(t, tpmKey.AuthPolicy[1].Policy, 2)
+				assert.Equal(t, int(tpm2.CmdPolicyPassword), tpmKey.AuthPolicy[1].Policy[0].CommandCode)
+			}
+
+			// the TSS2 signer uses the first signed policy that is satisfied
+			tss2Signer, err := CreateTSS2Signer(ctx, tpm, tpmKey)
+			require.NoError(t, err)
+			sig, err = tss2Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			require.NoError(t, err)
+			assert.True(t, ecdsa.VerifyASN1(tss2Signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+			tpmKey, err = key.ToTSS2(ctx, wrongPCRs, withPassword)
+			require.NoError(t, err)
+			tss2Signer, err = CreateTSS2Signer(ctx, tpm, tpmKey, WithTSS2Password("pass"))
+			require.NoError(t, err)
+			_, err = tss2Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			require.NoError(t, err)
+
+			tss2Signer, err = CreateTSS2Signer(ctx, tpm, tpmKey, WithTSS2Password("wrong"))
+			require.NoError(t, err)
+			_, err = tss2Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err = tpm.CreateKey(ctx, "", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Policy: &KeyPolicy{
+		Authority: ecAuthority.Public(),
+		PCRs:      &PCRSelection{PCRs: []int{16}},
+	}})
+	assert.EqualError(t, err, "invalid key policy: policy authority can't be combined with PCRs or password")
+}
+
+func TestKey_ToTSS2_policy(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	key, err := tpm.CreateKey(ctx, "", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Password: "pass", Policy: &KeyPolicy{
+		PCRs:     &PCRSelection{PCRs: []int{7}},
+		Password: true,
+	}})
+	require.NoError(t, err)
+
+	tpmKey, err := key.ToTSS2(ctx)
+	require.NoError(t, err)
+	assert.False(t, tpmKey.EmptyAuth)
+	if assert.Len(t, tpmKey.Policy, 2) {
+		assert.Equal(t, int(tpm2.CmdPolicyPCR), tpmKey.Policy[0].CommandCode)
+		assert.Equal(t, tss2.NewPolicyPassword(), tpmKey.Policy[1])
+	}
+	assert.Empty(t, tpmKey.AuthPolicy)
+
+	_, err = key.ToTSS2(ctx, &ApprovedPolicy{})
+	assert.EqualError(t, err, "failed encoding key policy: approved policies require a key with an authorized policy")
+
+	_, err = tpmKey.Encode()
+	assert.NoError(t, err)
+}
+
+func TestTPM_ExportKey_ImportKey(t *testing.T) {
+	ctx := context.Background()
+	digest := sha256.Sum256([]byte("data"))
+
+	// the target TPM uses a fixed seed, so that its SRK is the same after the
+	// simulator is opened again.
+	target, err := simulator.New(simulator.WithSeed("0102030405060708"))
+	require.NoError(t, err)
+	require.NoError(t, target.Open())
+	t.Cleanup(func() {
+		require.NoError(t, target.Close())
+	})
+	targetTPM, err := New(WithSimulator(target), WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+
+	parent, err := targetTPM.ImportParent(ctx)
+	require.NoError(t, err)
+	require.NoError(t, target.Close())
+
+	// export keys from the source TPM
+	source, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, source.Open())
+	sourceTPM, err := New(WithSimulator(source), WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+
+	_, err = sourceTPM.CreateKey(ctx, "ecdsa", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Duplicable: true})
+	require.NoError(t, err)
+	_, err = sourceTPM.CreateKey(ctx, "rsa", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Password: "password", Duplicable: true})
+	require.NoError(t, err)
+	_, err = sourceTPM.CreateKey(ctx, "fixed", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+
+	_, err = sourceTPM.CreateKey(ctx, "policy", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Duplicable: true, Policy: &KeyPolicy{PCRs: &PCRSelection{Hash: crypto.SHA256, PCRs: []int{16}}}})
+	assert.Error(t, err)
+
+	exported := map[string]*ExportedKey{}
+	publicKeys := map[string]crypto.PublicKey{}
+	for _, name := range []string{"ecdsa", "rsa"} {
+		exported[name], err = sourceTPM.ExportKey(ctx, name, parent)
+		require.NoError(t, err)
+		key, err := sourceTPM.GetKey(ctx, name)
+		require.NoError(t, err)
+		signer, err := key.Signer(ctx, WithKeyPassword("password"))
+		require.NoError(t, err)
+		publicKeys[name] = signer.Public()
+	}
+	assert.False(t, exported["ecdsa"].Password)
+	assert.True(t, exported["rsa"].Password)
+
+	_, err = sourceTPM.ExportKey(ctx, "fixed", parent)
+	assert.Error(t, err)
+
+	_, err = sourceTPM.ExportKey(ctx, "non-existing", parent)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, source.Close())
+
+	// import keys into the target TPM
+	require.NoError(t, target.Open())
+
+	for name, ek := range exported {
+		key, err := targetTPM.ImportKey(ctx, name, ek)
+		require.NoError(t, err)
+		assert.Equal(t, name, key.Name())
+
+		signer, err := targetTPM.GetSigner(ctx, name, WithKeyPassword("password"))
+		require.NoError(t, err)
+		assert.Equal(t, publicKeys[name], signer.Public())
+
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		require.NoError(t, err)
+		switch pub := publicKeys[name].(type) {
+		case *ecdsa.PublicKey:
+			assert.True(t, ecdsa.VerifyASN1(pub, digest[:], sig))
+		case *rsa.PublicKey:
+			assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig))
+		}
+	}
+
+	_, err = targetTPM.ImportKey(ctx, "ecdsa", exported["ecdsa"])
+	assert.ErrorIs(t, err, ErrExists)
+
+	// the password of the key is preserved
+	signer, err := targetTPM.GetSigner(ctx, "rsa")
+	require.NoError(t, err)
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Error(t, err)
+}
+
+func TestTPM_ImportPrivateKey(t *testing.T) {
+	ctx := context.Background()
+	tpm := newSimulatedTPM(t)
+	digest := sha256.Sum256([]byte("data"))
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		key     crypto.PrivateKey
+		wantErr bool
+	}{
+		{"ok/ecdsa", ecdsaKey, false},
+		{"ok/rsa", rsaKey, false},
+		{"fail/ed25519", ed25519Key, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tpm.ImportPrivateKey(ctx, "", tt.key)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			signer, err := key.Signer(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.key.(crypto.Signer).Public(), signer.Public())
+
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			require.NoError(t, err)
+			switch pub := signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				assert.True(t, ecdsa.VerifyASN1(pub, digest[:], sig))
+			case *rsa.PublicKey:
+				assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig))
+			}
+		})
+	}
+}
+
+func TestTPM_PersistKey(t *testing.T) {
+	ctx := context.Background()
+	tpm := newSimulatedTPM(t)
+	digest := sha256.Sum256([]byte("data"))
+
+	key, err := tpm.CreateKey(ctx, "persistent", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), key.PersistentHandle())
+	other, err := tpm.CreateKey(ctx, "other", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Password: "password"})
+	require.NoError(t, err)
+
+	assert.Error(t, tpm.PersistKey(ctx, "persistent", 0x01500000))
+	assert.ErrorIs(t, tpm.PersistKey(ctx, "non-existing", 0x81000010), ErrNotFound)
+
+	require.NoError(t, tpm.PersistKey(ctx, "persistent", 0x81000010))
+	assert.Error(t, tpm.PersistKey(ctx, "persistent", 0x81000011))
+	assert.ErrorIs(t, tpm.PersistKey(ctx, "other", 0x81000010), ErrExists)
+	require.NoError(t, tpm.PersistKey(ctx, "other", 0x81000011))
+
+	key, err = tpm.GetKey(ctx, "persistent")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x81000010), key.PersistentHandle())
+
+	handles, err := tpm.ListPersistentHandles(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, handles, uint32(0x81000010))
+	assert.Contains(t, handles, uint32(0x81000011))
+
+	// signers use the persistent handle
+	for k, password := range map[*Key]string{key: "", other: "password"} {
+		signer, err := k.Signer(ctx, WithKeyPassword(password))
+		require.NoError(t, err)
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		require.NoError(t, err)
+		switch pub := signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			assert.True(t, ecdsa.VerifyASN1(pub, digest[:], sig))
+		case *rsa.PublicKey:
+			assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig))
+		}
+	}
+
+	// persistent keys can be used with TSS2 signers
+	tpmKey, err := key.ToTSS2(ctx)
+	require.NoError(t, err)
+	tss2Signer, err := CreateTSS2Signer(ctx, tpm, tpmKey, WithTSS2KeyHandle(key.PersistentHandle()))
+	require.NoError(t, err)
+	sig, err := tss2Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(tss2Signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+	// evicting the handle keeps the key
+	require.NoError(t, tpm.EvictPersistentHandle(ctx, 0x81000010))
+	assert.ErrorIs(t, tpm.EvictPersistentHandle(ctx, 0x81000010), ErrNotFound)
+	key, err = tpm.GetKey(ctx, "persistent")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), key.PersistentHandle())
+
+	// the TSS2 signer falls back to loading the key
+	sig, err = tss2Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(tss2Signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+	// evicting and deleting keys
+	require.NoError(t, tpm.EvictKey(ctx, "persistent"))
+	require.NoError(t, tpm.DeleteKey(ctx, "other"))
+	handles, err = tpm.ListPersistentHandles(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, handles, uint32(0x81000010))
+	assert.NotContains(t, handles, uint32(0x81000011))
+
+	// persisting the SRK
+	assert.Error(t, tpm.PersistSRK(ctx, 0x80000000))
+	require.NoError(t, tpm.PersistSRK(ctx, 0x81000012))
+	assert.ErrorIs(t, tpm.PersistSRK(ctx, 0x81000012), ErrExists)
+	handles, err = tpm.ListPersistentHandles(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, handles, uint32(0x81000012))
+	require.NoError(t, tpm.EvictPersistentHandle(ctx, 0x81000012))
+}
+
+// failingStore is a storage.TPMStore that fails to update keys or to
+// persist its contents.
+type failingStore struct {
+	storage.TPMStore
+	failUpdate, failPersist bool
+}
+
+func (s *failingStore) UpdateKey(key *storage.Key) error {
+	if s.failUpdate {
+		return errors.New("update failed")
+	}
+	return s.TPMStore.UpdateKey(key)
+}
+
+func (s *failingStore) Persist() error {
+	if s.failPersist {
+		return errors.New("persist failed")
+	}
+	return s.TPMStore.Persist()
+}
+
+func TestTPM_PersistKey_storageFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStore{TPMStore: storage.NewDirstore(t.TempDir())}
+	tpm, err := New(withSimulator(t), WithStore(store))
+	require.NoError(t, err)
+
+	_, err = tpm.CreateKey(ctx, "persistent", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name                    string
+		failUpdate, failPersist bool
+	}{
+		{"update", true, false},
+		{"persist", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store.failUpdate, store.failPersist = tc.failUpdate, tc.failPersist
+			assert.Error(t, tpm.PersistKey(ctx, "persistent", 0x81000010))
+
+			// the handle is free again, and the key isn't persistent
+			store.failUpdate, store.failPersist = false, false
+			handles, err := tpm.ListPersistentHandles(ctx)
+			require.NoError(t, err)
+			assert.NotContains(t, handles, uint32(0x81000010))
+			key, err := tpm.GetKey(ctx, "persistent")
+			require.NoError(t, err)
+			assert.Equal(t, uint32(0), key.PersistentHandle())
+		})
+	}
+
+	require.NoError(t, tpm.PersistKey(ctx, "persistent", 0x81000010))
+	require.NoError(t, tpm.EvictKey(ctx, "persistent"))
+}
+
+// recordingSimulator records the commands and responses exchanged with the
+// simulator.
+type recordingSimulator struct {
+	simulator.Simulator
+	traffic bytes.Buffer
+}
+
+func (s *recordingSimulator) Read(p []byte) (int, error) {
+	n, err := s.Simulator.Read(p)
+	s.traffic.Write(p[:n])
+	return n, err
+}
+
+func (s *recordingSimulator) Write(p []byte) (int, error) {
+	s.traffic.Write(p)
+	return s.Simulator.Write(p)
+}
+
+func TestTPM_encryptedSessions(t *testing.T) {
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	recorder := &recordingSimulator{Simulator: sim}
+	tpm, err := New(WithSimulator(recorder), WithStore(storage.NewDirstore(t.TempDir())), WithEncryptedSessions())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	password := "the-key-password"
+	secret := []byte("the sealed secret")
+	digest := sha256.Sum256([]byte("data"))
+
+	// keys are created and used with encrypted sessions
+	key, err := tpm.CreateKey(ctx, "rsa", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Password: password})
+	require.NoError(t, err)
+	signer, err := key.Signer(ctx, WithKeyPassword(password))
+	require.NoError(t, err)
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig))
+	sig, err = signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthAuto})
+	require.NoError(t, err)
+	assert.NoError(t, rsa.VerifyPSS(signer.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig, nil))
+
+	signer, err = key.Signer(ctx, WithKeyPassword("wrong"))
+	require.NoError(t, err)
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Error(t, err)
+
+	key, err = tpm.CreateKey(ctx, "ecdsa", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+	signer, err = key.Signer(ctx)
+	require.NoError(t, err)
+	sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], sig))
+
+	// sealed data is sealed and unsealed with encrypted sessions
+	for name, config := range map[string]SealConfig{
+		"password": {Password: password},
+		"pcrs":     {PCRs: []int{16}},
+	} {
+		_, err := tpm.Seal(ctx, name, secret, config)
+		require.NoError(t, err)
+		data, err := tpm.Unseal(ctx, name, config.Password)
+		require.NoError(t, err)
+		assert.Equal(t, secret, data)
+	}
+
+	random, err := tpm.GenerateRandom(ctx, 32)
+	require.NoError(t, err)
+	require.Len(t, random, 32)
+
+	// the secrets don't go over the bus in the clear
+	traffic := recorder.traffic.Bytes()
+	assert.False(t, bytes.Contains(traffic, []byte(password)))
+	assert.False(t, bytes.Contains(traffic, secret))
+	assert.False(t, bytes.Contains(traffic, digest[:]))
+	assert.False(t, bytes.Contains(traffic, random))
+
+	// operations that can't use encrypted sessions fail
+	_, err = tpm.Seal(ctx, "pcrs-and-password", secret, SealConfig{PCRs: []int{16}, Password: password})
+	assert.ErrorIs(t, err, ErrEncryptedSessionsNotSupported)
+
+	_, err = tpm.CreateAK(ctx, "ak")
+	require.NoError(t, err)
+	_, err = tpm.AttestKey(ctx, "ak", "attested", AttestKeyConfig{Algorithm: "ECDSA", Size: 256})
+	assert.ErrorIs(t, err, ErrEncryptedSessionsNotSupported)
+
+	key, err = tpm.CreateKey(ctx, "policy", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Policy: &KeyPolicy{PCRs: &PCRSelection{PCRs: []int{16}}}})
+	require.NoError(t, err)
+	signer, err = key.Signer(ctx)
+	require.NoError(t, err)
+	_, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.ErrorIs(t, err, ErrEncryptedSessionsNotSupported)
+}
+
+func TestTPM_encryptedSessionsDecryption(t *testing.T) {
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	recorder := &recordingSimulator{Simulator: sim}
+	tpm, err := New(WithSimulator(recorder), WithStore(storage.NewDirstore(t.TempDir())), WithEncryptedSessions())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	secret := []byte("the encrypted secret")
+
+	// decryption uses encrypted sessions
+	key, err := tpm.CreateKey(ctx, "rsa", CreateKeyConfig{Algorithm: "RSA", Size: 2048, Decrypt: true})
+	require.NoError(t, err)
+	decrypter, err := key.Decrypter(ctx)
+	require.NoError(t, err)
+	pub := decrypter.Public().(*rsa.PublicKey)
+	for name, opts := range map[string]crypto.DecrypterOpts{
+		"pkcs1":      nil,
+		"oaep":       &rsa.OAEPOptions{Hash: crypto.SHA256},
+		"oaep-label": &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label\x00")},
+	} {
+		var ciphertext []byte
+		if o, ok := opts.(*rsa.OAEPOptions); ok {
+			ciphertext, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, secret, o.Label)
+		} else {
+			ciphertext, err = rsa.EncryptPKCS1v15(rand.Reader, pub, secret)
+		}
+		require.NoError(t, err, name)
+		plaintext, err := decrypter.Decrypt(nil, ciphertext, opts)
+		require.NoError(t, err, name)
+		assert.Equal(t, secret, plaintext, name)
+	}
+
+	// ECDH uses encrypted sessions
+	key, err = tpm.CreateKey(ctx, "ecdh", CreateKeyConfig{Algorithm: "ECDSA", Size: 256, Decrypt: true})
+	require.NoError(t, err)
+	e, err := key.ECDH(ctx)
+	require.NoError(t, err)
+	peer, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	got, err := e.ECDH(peer.PublicKey())
+	require.NoError(t, err)
+	want, err := peer.ECDH(e.PublicKey())
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// the secrets don't go over the bus in the clear
+	traffic := recorder.traffic.Bytes()
+	assert.False(t, bytes.Contains(traffic, secret))
+	assert.False(t, bytes.Contains(traffic, got))
+}
+
+func TestTPM_encryptedSessionsNotSupported(t *testing.T) {
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	ctx := context.Background()
+	store := storage.NewDirstore(t.TempDir())
+
+	// persist a key and define an NV index without encrypted sessions
+	plain, err := New(WithSimulator(sim), WithStore(store))
+	require.NoError(t, err)
+	_, err = plain.CreateKey(ctx, "persisted", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+	require.NoError(t, plain.PersistKey(ctx, "persisted", 0x81000010))
+	index := uint32(0x01500000)
+	require.NoError(t, plain.DefineNV(ctx, index, NVConfig{Size: 16}))
+
+	tpm, err := New(WithSimulator(sim), WithStore(store), WithEncryptedSessions())
+	require.NoError(t, err)
+	_, err = tpm.CreateKey(ctx, "key", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"ImportPrivateKey", func() error {
+			_, err := tpm.ImportPrivateKey(ctx, "imported", privateKey)
+			return err
+		}},
+		{"DefineNV", func() error { return tpm.DefineNV(ctx, 0x01500001, NVConfig{Size: 16}) }},
+		{"WriteNV", func() error { return tpm.WriteNV(ctx, index, []byte("data"), NVAuth{Owner: true}) }},
+		{"ReadNV", func() error {
+			_, err := tpm.ReadNV(ctx, index, NVAuth{Owner: true})
+			return err
+		}},
+		{"LockNV", func() error { return tpm.LockNV(ctx, index, NVAuth{Owner: true}) }},
+		{"UndefineNV", func() error { return tpm.UndefineNV(ctx, index, "") }},
+		{"ResetLockout", func() error { return tpm.ResetLockout(ctx, "") }},
+		{"ChangeHierarchyAuth", func() error { return tpm.ChangeHierarchyAuth(ctx, HierarchyOwner, "", "owner-password") }},
+		{"PersistKey", func() error { return tpm.PersistKey(ctx, "key", 0x81000011) }},
+		{"EvictKey", func() error { return tpm.EvictKey(ctx, "persisted") }},
+		{"PersistSRK", func() error { return tpm.PersistSRK(ctx, 0x81000012) }},
+		{"EvictPersistentHandle", func() error { return tpm.EvictPersistentHandle(ctx, 0x81000010) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.fn(), ErrEncryptedSessionsNotSupported)
+		})
+	}
+
+	// nothing was changed
+	handles, err := plain.ListPersistentHandles(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, handles, uint32(0x81000010))
+	assert.NotContains(t, handles, uint32(0x81000011))
+	assert.NotContains(t, handles, uint32(0x81000012))
+	nv, err := plain.GetNV(ctx, index)
+	require.NoError(t, err)
+	assert.False(t, nv.Written())
+	assert.NoError(t, plain.ResetLockout(ctx, ""))
+}
+
+func TestTPM_encryptedSessionsEKVerification(t *testing.T) {
+	sim, err := simulator.New()
+	require.NoError(t, err)
+	require.NoError(t, sim.Open())
+	t.Cleanup(func() {
+		require.NoError(t, sim.Close())
+	})
+	ctx := context.Background()
+
+	tpm, err := New(WithSimulator(sim), WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+	eks, err := tpm.GetEKs(ctx)
+	require.NoError(t, err)
+	var ekPublic crypto.PublicKey
+	for _, ek := range eks {
+		if _, ok := ek.Public().(*rsa.PublicKey); ok {
+			ekPublic = ek.Public()
+		}
+	}
+	require.NotNil(t, ekPublic)
+
+	// sessions are salted with the pinned EK
+	pinned, err := New(WithSimulator(sim), WithStore(storage.NewDirstore(t.TempDir())), WithEncryptedSessions(), WithEKPublicKey(ekPublic))
+	require.NoError(t, err)
+	random, err := pinned.GenerateRandom(ctx, 32)
+	require.NoError(t, err)
+	assert.Len(t, random, 32)
+
+	// sessions aren't salted with an EK that doesn't match the pinned one
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	mismatch, err := New(WithSimulator(sim), WithStore(storage.NewDirstore(t.TempDir())), WithEncryptedSessions(), WithEKPublicKey(other.Public()))
+	require.NoError(t, err)
+	_, err = mismatch.GenerateRandom(ctx, 32)
+	assert.ErrorContains(t, err, "EK public key does not match the configured EK public key")
+	_, err = mismatch.CreateKey(ctx, "key", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	assert.Error(t, err)
+
+	// sessions aren't salted with an EK that can't be verified
+	verifier, err := ekverify.New(ekverify.WithBundle(ekverify.NewBundle()), ekverify.WithMaxFetches(0))
+	require.NoError(t, err)
+	unverified, err := New(WithSimulator(sim), WithStore(storage.NewDirstore(t.TempDir())), WithEncryptedSessions(), WithEKVerifier(verifier))
+	require.NoError(t, err)
+	_, err = unverified.GenerateRandom(ctx, 32)
+	assert.ErrorContains(t, err, "failed verifying EK")
+
+	// invalid options
+	_, err = New(WithEKPublicKey("not a key"))
+	assert.ErrorContains(t, err, "unsupported EK public key type string")
+	_, err = New(WithEKVerifier(nil))
+	assert.ErrorContains(t, err, "EK verifier cannot be nil")
+}
+
+func TestTPM_DictionaryAttackInfo(t *testing.T) {
+	tpm := newSimulatedTPM(t)
+	ctx := context.Background()
+
+	info, err := tpm.GetDictionaryAttackInfo(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, info.LockoutCounter)
+	assert.NotZero(t, info.MaxAuthFail)
+	assert.NotZero(t, info.LockoutInterval)
+	assert.False(t, info.InLockout)
+	assert.False(t, info.OwnerAuthSet)
+	assert.False(t, info.EndorsementAuthSet)
+	assert.False(t, info.LockoutAuthSet)
+
+	require.NoError(t, tpm.ResetLockout(ctx, ""))
+	require.NoError(t, tpm.ChangeHierarchyAuth(ctx, HierarchyLockout, "", "lockout-password"))
+	require.NoError(t, tpm.ResetLockout(ctx, "lockout-password"))
+
+	info, err = tpm.GetDictionaryAttackInfo(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, info.LockoutCounter)
+	assert.True(t, info.LockoutAuthSet)
+
+	assert.Error(t, tpm.ChangeHierarchyAuth(ctx, Hierarchy(tpm2.HandlePlatform), "", "platform-password"))
+	assert.Error(t, tpm.ChangeHierarchyAuth(ctx, HierarchyOwner, "wrong-password", "owner-password"))
+	assert.Error(t, tpm.ResetLockout(ctx, "wrong-password"))
+}
+
+func TestTPM_hierarchyAuth(t *testing.T) {
+	ctx := context.Background()
+	sim := withSimulator(t)
+
+	tpm, err := New(sim, WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+	require.NoError(t, tpm.ChangeHierarchyAuth(ctx, HierarchyOwner, "", "owner-password"))
+	require.NoError(t, tpm.ChangeHierarchyAuth(ctx, HierarchyEndorsement, "", "endorsement-password"))
+	assert.Equal(t, "owner-password", tpm.options.ownerPassword)
+	assert.Equal(t, "endorsement-password", tpm.options.endorsementPassword)
+
+	info, err := tpm.GetDictionaryAttackInfo(ctx)
+	require.NoError(t, err)
+	assert.True(t, info.OwnerAuthSet)
+	assert.True(t, info.EndorsementAuthSet)
+	assert.False(t, info.LockoutAuthSet)
+
+	// without the hierarchy passwords the primary keys can't be created
+	noAuth, err := New(sim, WithStore(storage.NewDirstore(t.TempDir())))
+	require.NoError(t, err)
+	_, err = noAuth.GetEKs(ctx)
+	assert.Error(t, err)
+	_, err = noAuth.CreateAK(ctx, "no-auth-ak")
+	assert.Error(t, err)
+
+	withAuth, err := New(sim, WithStore(storage.NewDirstore(t.TempDir())),
+		WithOwnerPassword("owner-password"), WithEndorsementPassword("endorsement-password"))
+	require.NoError(t, err)
+
+	eks, err := withAuth.GetEKs(ctx)
+	require.NoError(t, err)
+	require.Len(t, eks, 1)
+	require.IsType(t, &rsa.PublicKey{}, eks[0].Public())
+
+	ak, err := withAuth.CreateAK(ctx, "ak")
+	require.NoError(t, err)
+	params, err := ak.AttestationParameters(ctx)
+	require.NoError(t, err)
+
+	activation := attest.ActivationParameters{
+		TPMVersion: attest.TPMVersion20,
+		EK:         eks[0].Public(),
+		AK:         params,
+	}
+	expectedSecret, encryptedCredentials, err := activation.Generate()
+	require.NoError(t, err)
+	secret, err := ak.ActivateCredential(ctx, EncryptedCredential(*encryptedCredentials))
+	require.NoError(t, err)
+	assert.Equal(t, expectedSecret, secret)
+
+	// NV indices use the configured owner password by default
+	const index = 0x01000300
+	require.NoError(t, withAuth.DefineNV(ctx, index, NVConfig{Size: 8}))
+	require.NoError(t, withAuth.WriteNV(ctx, index, []byte("password"), NVAuth{Owner: true}))
+	data, err := withAuth.ReadNV(ctx, index, NVAuth{Owner: true})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("password"), data)
+	require.NoError(t, withAuth.UndefineNV(ctx, index, ""))
+}
+
+func TestTPM_HMAC(t *testing.T) {
+	ctx := context.Background()
+	sim := withSimulator(t)
+	dir := t.TempDir()
+	tpm, err := New(sim, WithStore(storage.NewDirstore(dir)))
+	require.NoError(t, err)
+
+	data := make([]byte, 3000)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	key, err := tpm.CreateKey(ctx, "hmac", CreateKeyConfig{Algorithm: "HMAC"})
+	require.NoError(t, err)
+	h, err := key.HMAC(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 32, h.Size())
+	assert.Equal(t, 64, h.BlockSize())
+
+	// the HMAC is deterministic, and doesn't depend on how data is written
+	_, err = h.Write(data)
+	require.NoError(t, err)
+	mac := h.Sum(nil)
+	require.Len(t, mac, 32)
+	assert.Equal(t, append([]byte("prefix"), mac...), h.Sum([]byte("prefix")))
+
+	h.Reset()
+	_, err = io.Copy(h, bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, mac, h.Sum(nil))
+
+	h.Reset()
+	_, err = h.Write(data[:10])
+	require.NoError(t, err)
+	short, err := h.MAC()
+	require.NoError(t, err)
+	assert.NotEqual(t, mac, short)
+
+	// the same key computes the same HMAC, and a different key a different one
+	other, err := tpm.GetHMAC(ctx, "hmac", "")
+	require.NoError(t, err)
+	_, err = other.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, mac, other.Sum(nil))
+	_, err = tpm.CreateKey(ctx, "other", CreateKeyConfig{Algorithm: "HMAC", Size: 256})
+	require.NoError(t, err)
+	other, err = tpm.GetHMAC(ctx, "other", "")
+	require.NoError(t, err)
+	_, err = other.Write(data)
+	require.NoError(t, err)
+	assert.NotEqual(t, mac, other.Sum(nil))
+
+	for _, tc := range []struct {
+		size      int
+		macSize   int
+		blockSize int
+	}{
+		{384, 48, 128},
+		{512, 64, 128},
+	} {
+		name := fmt.Sprintf("hmac-%d", tc.size)
+		_, err := tpm.CreateKey(ctx, name, CreateKeyConfig{Algorithm: "HMAC", Size: tc.size})
+		require.NoError(t, err)
+		h, err := tpm.GetHMAC(ctx, name, "")
+		require.NoError(t, err)
+		assert.Equal(t, tc.macSize, h.Size())
+		assert.Equal(t, tc.blockSize, h.BlockSize())
+		_, err = h.Write(data)
+		require.NoError(t, err)
+		assert.Len(t, h.Sum(nil), tc.macSize)
+	}
+
+	// keys with a password require it
+	_, err = tpm.CreateKey(ctx, "password", CreateKeyConfig{Algorithm: "HMAC", Password: "the-password"})
+	require.NoError(t, err)
+	h, err = tpm.GetHMAC(ctx, "password", "the-password")
+	require.NoError(t, err)
+	_, err = h.Write(data)
+	require.NoError(t, err)
+	passwordMAC := h.Sum(nil)
+	require.NoError(t, h.Err())
+	require.Len(t, passwordMAC, 32)
+
+	h, err = tpm.GetHMAC(ctx, "password", "wrong")
+	require.NoError(t, err)
+	_, err = h.Write(data)
+	require.NoError(t, err)
+	_, err = h.MAC()
+	assert.Error(t, err)
+	assert.NotPanics(t, func() {
+		assert.Equal(t, []byte("prefix"), h.Sum([]byte("prefix")))
+	})
+	assert.EqualError(t, h.Err(), err.Error())
+	h.Reset()
+	assert.NoError(t, h.Err())
+
+	// encrypted sessions compute the same HMAC
+	encrypted, err := New(sim, WithStore(storage.NewDirstore(dir)), WithEncryptedSessions())
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name, password string
+		expected       []byte
+	}{
+		{"hmac", "", mac},
+		{"password", "the-password", passwordMAC},
+	} {
+		h, err := encrypted.GetHMAC(ctx, tc.name, tc.password)
+		require.NoError(t, err)
+		_, err = h.Write(data)
+		require.NoError(t, err)
+		got, err := h.MAC()
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, got)
+	}
+
+	// invalid keys and configurations
+	_, err = tpm.GetHMAC(ctx, "unknown", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = tpm.CreateKey(ctx, "ecdsa", CreateKeyConfig{Algorithm: "ECDSA", Size: 256})
+	require.NoError(t, err)
+	_, err = tpm.GetHMAC(ctx, "ecdsa", "")
+	assert.EqualError(t, err, `key "ecdsa" is not an HMAC key`)
+	_, err = tpm.GetSymmetricCipher(ctx, "hmac", "")
+	assert.EqualError(t, err, `key "hmac" is not an AES key`)
+	_, err = tpm.CreateKey(ctx, "invalid", CreateKeyConfig{Algorithm: "HMAC", Size: 128})
+	assert.Error(t, err)
+	_, err = tpm.CreateKey(ctx, "invalid", CreateKeyConfig{Algorithm: "HMAC", Decrypt: true})
+	assert.Error(t, err)
+	_, err = tpm.CreateKey(ctx, "invalid", CreateKeyConfig{Algorithm: "HMAC", Duplicable: true})
+	assert.Error(t, err)
+}
+
+func TestTPM_SymmetricCipher(t *testing.T) {
+	ctx := context.Background()
+	sim := withSimulator(t)
+	dir := t.TempDir()
+	tpm, err := New(sim, WithStore(storage.NewDirstore(dir)))
+	require.NoError(t, err)
+
+	iv := make([]byte, 16)
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+	plaintext := make([]byte, 3000)
+	_, err = rand.Read(plaintext)
+	require.NoError(t, err)
+
+	_, err = tpm.CreateKey(ctx, "aes-256", CreateKeyConfig{Algorithm: "AES", Size: 256, Password: "the-password"})
+	require.NoError(t, err)
+	key, err := tpm.CreateKey(ctx, "aes", CreateKeyConfig{Algorithm: "AES"})
+	require.NoError(t, err)
+
+	c, err := key.SymmetricCipher(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 128, c.KeySize())
+	assert.Equal(t, 16, c.BlockSize())
+	c256, err := tpm.GetSymmetricCipher(ctx, "aes-256", "the-password")
+	require.NoError(t, err)
+	assert.Equal(t, 256, c256.KeySize())
+
+	ciphertexts := map[string][]byte{}
+	for name, c := range map[string]*SymmetricCipher{"aes": c, "aes-256": c256} {
+		for _, mode := range []CipherMode{CipherModeCFB, CipherModeCTR} {
+			ciphertext, err := c.Encrypt(mode, iv, plaintext)
+			require.NoError(t, err)
+			require.Len(t, ciphertext, len(plaintext))
+			assert.NotEqual(t, plaintext, ciphertext)
+
+			// data larger than a single TPM command is chained
+			prefix, err := c.Encrypt(mode, iv, plaintext[:1024])
+			require.NoError(t, err)
+			assert.Equal(t, ciphertext[:1024], prefix)
+
+			decrypted, err := c.Decrypt(mode, iv, ciphertext)
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, decrypted)
+
+			ciphertexts[name+"/"+mode.String()] = ciphertext
+		}
+	}
+	assert.NotEqual(t, ciphertexts["aes/CFB"], ciphertexts["aes/CTR"])
+	assert.NotEqual(t, ciphertexts["aes/CFB"], ciphertexts["aes-256/CFB"])
+
+	empty, err := c.Encrypt(CipherModeCFB, iv, nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	// encrypted sessions produce the same results
+	encrypted, err := New(sim, WithStore(storage.NewDirstore(dir)), WithEncryptedSessions())
+	require.NoError(t, err)
+	ec, err := encrypted.GetSymmetricCipher(ctx, "aes-256", "the-password")
+	require.NoError(t, err)
+	ciphertext, err := ec.Encrypt(CipherModeCTR, iv, plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, ciphertexts["aes-256/CTR"], ciphertext)
+	decrypted, err := ec.Decrypt(CipherModeCFB, iv, ciphertexts["aes-256/CFB"])
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// invalid parameters
+	_, err = c.Encrypt(CipherModeCFB, iv[:8], plaintext)
+	assert.EqualError(t, err, "invalid initialization vector size 8; it must be 16 bytes")
+	_, err = c.Decrypt(CipherMode(0), iv, plaintext)
+	assert.EqualError(t, err, "unsupported cipher mode unknown(0)")
+	wrong, err := tpm.GetSymmetricCipher(ctx, "aes-256", "wrong")
+	require.NoError(t, err)
+	_, err = wrong.Encrypt(CipherModeCFB, iv, plaintext)
+	assert.Error(t, err)
+	_, err = tpm.GetSymmetricCipher(ctx, "unknown", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = tpm.GetHMAC(ctx, "aes", "")
+	assert.EqualError(t, err, `key "aes" is not an HMAC key`)
+	_, err = tpm.CreateKey(ctx, "invalid", CreateKeyConfig{Algorithm: "AES", Size: 192})
+	assert.Error(t, err)
+	_, err = tpm.CreateKey(ctx, "invalid", CreateKeyConfig{Algorithm: "AES", Policy: &KeyPolicy{PCRs: &PCRSelection{PCRs: []int{16}}}})
+	assert.Error(t, err)
+}
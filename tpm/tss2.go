@@ -2,7 +2,10 @@ package tpm
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/google/go-tpm/legacy/tpm2"
 	"github.com/google/go-tpm/tpmutil"
 
 	"go.step.sm/crypto/tpm/tss2"
@@ -29,15 +32,101 @@ func (ak *AK) ToTSS2(ctx context.Context) (*tss2.TPMKey, error) {
 	), nil
 }
 
-// ToTSS2 gets the public and private blobs and returns a [*tss2.TPMKey].
-func (k *Key) ToTSS2(ctx context.Context) (*tss2.TPMKey, error) {
+// ToTSS2 gets the public and private blobs and returns a [*tss2.TPMKey]. If
+// the Key has a password or policy, they're encoded in the [*tss2.TPMKey]
+// too. Policies approved by the authority of a Key with an authorized policy
+// can be included using `approved`.
+func (k *Key) ToTSS2(ctx context.Context, approved ...*ApprovedPolicy) (*tss2.TPMKey, error) {
 	blobs, err := k.Blobs(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return tss2.New(
-		blobs.public,
-		blobs.private,
+
+	opts := []tss2.TPMOption{
 		tss2.WithParent(commonSrkEquivalentHandle), // default parent used by go-tpm/go-attestation
-	), nil
+	}
+	policy, err := unmarshalKeyPolicy(k.policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		policyOpts, err := policy.tss2Options(approved)
+		if err != nil {
+			return nil, fmt.Errorf("failed encoding key policy: %w", err)
+		}
+		opts = append(opts, policyOpts...)
+	}
+
+	return tss2.New(blobs.public, blobs.private, opts...), nil
+}
+
+// tss2Options returns the options to encode the password and policy of a
+// Key in a [*tss2.TPMKey].
+func (sp *serializedKeyPolicy) tss2Options(approved []*ApprovedPolicy) ([]tss2.TPMOption, error) {
+	opts := []tss2.TPMOption{
+		tss2.WithEmptyAuth(!sp.Password),
+	}
+
+	authority, err := sp.authority()
+	if err != nil {
+		return nil, err
+	}
+	if authority == nil {
+		if len(approved) > 0 {
+			return nil, errors.New("approved policies require a key with an authorized policy")
+		}
+		policy, err := tss2Policy(sp.pcrSelection(), sp.PCRDigest, sp.PolicyPassword)
+		if err != nil {
+			return nil, err
+		}
+		if len(policy) > 0 {
+			opts = append(opts, tss2.WithPolicy(policy...))
+		}
+		return opts, nil
+	}
+
+	authorize, err := tss2.NewPolicyAuthorize(*authority, sp.PolicyRef, nil)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, tss2.WithPolicy(authorize))
+
+	for i, p := range approved {
+		ap, err := p.toInternal(*authority)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approved policy: %w", err)
+		}
+		policy, err := tss2Policy(ap.PCRs, ap.PCRDigest, ap.Password)
+		if err != nil {
+			return nil, err
+		}
+		signed, err := tss2.NewPolicyAuthorize(*authority, sp.PolicyRef, ap.Signature)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, tss2.WithAuthPolicy(tss2.TPMAuthPolicy{
+			Name:   fmt.Sprintf("approved-policy-%d", i+1),
+			Policy: append(policy, signed),
+		}))
+	}
+
+	return opts, nil
+}
+
+// tss2Policy returns the TSS2 policy commands for a policy that requires the
+// PCRs in `sel` to have values with digest `pcrDigest`, and the key password
+// if `password` is set.
+func tss2Policy(sel tpm2.PCRSelection, pcrDigest []byte, password bool) ([]tss2.TPMPolicy, error) {
+	var policy []tss2.TPMPolicy
+	if len(sel.PCRs) > 0 {
+		p, err := tss2.NewPolicyPCR(sel, pcrDigest)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, p)
+	}
+	if password {
+		policy = append(policy, tss2.NewPolicyPassword())
+	}
+	return policy, nil
 }
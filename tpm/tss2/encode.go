@@ -34,6 +34,34 @@ func New(pub, priv []byte, opts ...TPMOption) *TPMKey {
 	return key
 }
 
+// NewImportable creates a new importable [TPMKey] with the given public key,
+// the duplicate of the private key, and the seed used to wrap it, encrypted
+// to the parent. The key is imported under its parent before being loaded.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewImportable(pub, dup, secret []byte, opts ...TPMOption) *TPMKey {
+	key := New(pub, dup, opts...)
+	key.Type = oidImportableKey
+	key.Secret = addPrefixLength(secret)
+	return key
+}
+
+// NewSealed creates a new [TPMKey] for the data sealed in the given public and
+// private blobs.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewSealed(pub, priv []byte, opts ...TPMOption) *TPMKey {
+	key := New(pub, priv, opts...)
+	key.Type = oidSealedKey
+	return key
+}
+
 // Encode encodes the [TPMKey] returns a [*pem.Block].
 func (k *TPMKey) Encode() (*pem.Block, error) {
 	b, err := MarshalPrivateKey(k)
@@ -157,3 +157,36 @@ MCgGBmeBBQoBA6ADAQH/AgRAAAABBAgABnB1YmxpYwQJAAdwcml2YXRl
 		})
 	}
 }
+
+func TestNewImportable_NewSealed(t *testing.T) {
+	importable := NewImportable([]byte("public"), []byte("duplicate"), []byte("secret"), WithParent(0x81000001))
+	assert.Equal(t, &TPMKey{
+		Type:       oidImportableKey,
+		EmptyAuth:  true,
+		Secret:     append([]byte{0, 6}, []byte("secret")...),
+		Parent:     0x81000001,
+		PublicKey:  append([]byte{0, 6}, []byte("public")...),
+		PrivateKey: append([]byte{0, 9}, []byte("duplicate")...),
+	}, importable)
+	assert.True(t, importable.IsImportable())
+	assert.False(t, importable.IsLoadable())
+	assert.False(t, importable.IsSealed())
+
+	sealed := NewSealed([]byte("public"), []byte("private"), WithEmptyAuth(false))
+	assert.Equal(t, &TPMKey{
+		Type:       oidSealedKey,
+		Parent:     0x40000001,
+		PublicKey:  append([]byte{0, 6}, []byte("public")...),
+		PrivateKey: append([]byte{0, 7}, []byte("private")...),
+	}, sealed)
+	assert.True(t, sealed.IsSealed())
+	assert.False(t, sealed.IsLoadable())
+
+	for _, key := range []*TPMKey{importable, sealed} {
+		b, err := MarshalPrivateKey(key)
+		assert.NoError(t, err)
+		parsed, err := ParsePrivateKey(b)
+		assert.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	}
+}
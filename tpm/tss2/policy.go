@@ -0,0 +1,369 @@
+package tss2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+const (
+	// Command codes of the policy commands not available in
+	// github.com/google/go-tpm/legacy/tpm2.
+	cmdPolicyAuthorize = 0x0000016A
+	cmdPolicyAuthValue = 0x0000016B
+	cmdVerifySignature = 0x00000177
+	// sizeOfPCRSelect is the size of the PCR bitmap in a TPMS_PCR_SELECTION.
+	sizeOfPCRSelect = 3
+	// policySessionNonceSize is the size of the caller nonce of policy
+	// sessions.
+	policySessionNonceSize = 16
+)
+
+// WithEmptyAuth sets whether the [TPMKey] has an empty authorization value.
+// Keys with a password must set it to false.
+func WithEmptyAuth(emptyAuth bool) TPMOption {
+	return func(t *TPMKey) {
+		t.EmptyAuth = emptyAuth
+	}
+}
+
+// WithPolicy appends the given policy commands to the [TPMKey] policy.
+func WithPolicy(policy ...TPMPolicy) TPMOption {
+	return func(t *TPMKey) {
+		t.Policy = append(t.Policy, policy...)
+	}
+}
+
+// WithAuthPolicy appends the given signed policies to the [TPMKey].
+func WithAuthPolicy(authPolicy ...TPMAuthPolicy) TPMOption {
+	return func(t *TPMKey) {
+		t.AuthPolicy = append(t.AuthPolicy, authPolicy...)
+	}
+}
+
+// NewPolicyPCR returns a TPM2_PolicyPCR policy command for the PCRs in the
+// selection. The commandPolicy is the TPML_PCR_SELECTION followed by the
+// digest of the expected PCR values.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewPolicyPCR(sel tpm2.PCRSelection, pcrDigest []byte) (TPMPolicy, error) {
+	if len(sel.PCRs) == 0 {
+		return TPMPolicy{}, errors.New("PCR selection cannot be empty")
+	}
+	bitmap := make([]byte, sizeOfPCRSelect)
+	for _, pcr := range sel.PCRs {
+		if pcr < 0 || pcr >= sizeOfPCRSelect*8 {
+			return TPMPolicy{}, fmt.Errorf("invalid PCR index %d", pcr)
+		}
+		bitmap[pcr/8] |= 1 << (pcr % 8)
+	}
+
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.BigEndian, uint32(1))
+	_ = binary.Write(b, binary.BigEndian, uint16(sel.Hash))
+	b.WriteByte(sizeOfPCRSelect)
+	b.Write(bitmap)
+	b.Write(pcrDigest)
+
+	return TPMPolicy{
+		CommandCode:   int(tpm2.CmdPolicyPCR),
+		CommandPolicy: b.Bytes(),
+	}, nil
+}
+
+// NewPolicyPassword returns a TPM2_PolicyPassword policy command. It
+// requires the password of the key to be provided when the policy is used.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewPolicyPassword() TPMPolicy {
+	return TPMPolicy{
+		CommandCode:   int(tpm2.CmdPolicyPassword),
+		CommandPolicy: []byte{},
+	}
+}
+
+// NewPolicyAuthorize returns a TPM2_PolicyAuthorize policy command for the
+// authority with the given public area and policy reference. The
+// commandPolicy is the TPM2B_PUBLIC of the authority, followed by the
+// TPM2B_DIGEST of the policy reference and the TPMT_SIGNATURE of the
+// approved policy. If the signature is nil, a null signature is encoded; this
+// is the format used in the policy of the key, while the signed policies are
+// stored as [TPMAuthPolicy].
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func NewPolicyAuthorize(authority tpm2.Public, policyRef []byte, sig *tpm2.Signature) (TPMPolicy, error) {
+	pub, err := authority.Encode()
+	if err != nil {
+		return TPMPolicy{}, fmt.Errorf("failed encoding authority: %w", err)
+	}
+
+	var encodedSig []byte
+	if sig == nil {
+		encodedSig, err = tpmutil.Pack(tpm2.AlgNull)
+	} else {
+		encodedSig, err = sig.Encode()
+	}
+	if err != nil {
+		return TPMPolicy{}, fmt.Errorf("failed encoding signature: %w", err)
+	}
+
+	b, err := tpmutil.Pack(tpmutil.U16Bytes(pub), tpmutil.U16Bytes(policyRef), tpmutil.RawBytes(encodedSig))
+	if err != nil {
+		return TPMPolicy{}, fmt.Errorf("failed encoding policy: %w", err)
+	}
+
+	return TPMPolicy{
+		CommandCode:   cmdPolicyAuthorize,
+		CommandPolicy: b,
+	}, nil
+}
+
+// validatePolicy checks that the commands in the policy are supported.
+func validatePolicy(policy []TPMPolicy) error {
+	for _, p := range policy {
+		switch p.CommandCode {
+		case int(tpm2.CmdPolicyPCR), int(tpm2.CmdPolicyPassword), cmdPolicyAuthValue,
+			int(tpm2.CmdPolicyCommandCode), cmdPolicyAuthorize:
+		default:
+			return fmt.Errorf("policy command 0x%x is not supported", p.CommandCode)
+		}
+	}
+	return nil
+}
+
+// keyPolicySession starts a policy session and satisfies the policy of the
+// key in it. If the policy starts with TPM2_PolicyAuthorize, the signed
+// policies of the key for the same authority are tried in order, until one
+// of them is satisfied. It returns the session handle, and whether the key
+// password is required in the session.
+func keyPolicySession(rw io.ReadWriter, key *TPMKey) (tpmutil.Handle, bool, error) {
+	if key.Policy[0].CommandCode != cmdPolicyAuthorize {
+		return runPolicySession(rw, key.Policy)
+	}
+
+	authority, policyRef, _, err := decodePolicyAuthorize(key.Policy[0].CommandPolicy)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var errs []error
+	for _, ap := range key.AuthPolicy {
+		if len(ap.Policy) == 0 {
+			continue
+		}
+		signed := ap.Policy[len(ap.Policy)-1]
+		if signed.CommandCode != cmdPolicyAuthorize {
+			continue
+		}
+		signedAuthority, signedRef, _, err := decodePolicyAuthorize(signed.CommandPolicy)
+		if err != nil || !sameAuthority(signedAuthority, authority) || !bytes.Equal(signedRef, policyRef) {
+			continue
+		}
+
+		session, password, err := runPolicySession(rw, append(slices.Clone(ap.Policy), key.Policy[1:]...))
+		if err == nil {
+			return session, password, nil
+		}
+		errs = append(errs, fmt.Errorf("policy %q: %w", ap.Name, err))
+	}
+
+	if len(errs) == 0 {
+		return 0, false, errors.New("key requires a signed policy, but none was found")
+	}
+	return 0, false, fmt.Errorf("failed satisfying key policy: %w", errors.Join(errs...))
+}
+
+// sameAuthority returns true if both public areas are the same.
+func sameAuthority(a, b tpm2.Public) bool {
+	ea, err := a.Encode()
+	if err != nil {
+		return false
+	}
+	eb, err := b.Encode()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ea, eb)
+}
+
+// runPolicySession starts a policy session and runs the policy commands in
+// it. It returns the session handle, and whether the key password is required
+// in the session.
+func runPolicySession(rw io.ReadWriter, policy []TPMPolicy) (_ tpmutil.Handle, password bool, err error) {
+	session, _, err := tpm2.StartAuthSession(rw, tpm2.HandleNull, tpm2.HandleNull, make([]byte, policySessionNonceSize), nil, tpm2.SessionPolicy, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, false, fmt.Errorf("error starting policy session: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tpm2.FlushContext(rw, session)
+		}
+	}()
+
+	for _, p := range policy {
+		switch p.CommandCode {
+		case int(tpm2.CmdPolicyPCR):
+			sel, pcrDigest, err := decodePolicyPCR(p.CommandPolicy)
+			if err != nil {
+				return 0, false, err
+			}
+			if err := tpm2.PolicyPCR(rw, session, pcrDigest, sel); err != nil {
+				return 0, false, fmt.Errorf("error running PolicyPCR: %w", err)
+			}
+		case int(tpm2.CmdPolicyPassword), cmdPolicyAuthValue:
+			// TPM2_PolicyPassword and TPM2_PolicyAuthValue result in the same
+			// policy digest. The password is sent in the clear instead of
+			// being used to compute an HMAC.
+			if err := tpm2.PolicyPassword(rw, session); err != nil {
+				return 0, false, fmt.Errorf("error running PolicyPassword: %w", err)
+			}
+			password = true
+		case int(tpm2.CmdPolicyCommandCode):
+			if len(p.CommandPolicy) != 4 {
+				return 0, false, errors.New("malformed PolicyCommandCode policy")
+			}
+			cc := tpmutil.Command(binary.BigEndian.Uint32(p.CommandPolicy))
+			if err := tpm2.PolicyCommandCode(rw, session, cc); err != nil {
+				return 0, false, fmt.Errorf("error running PolicyCommandCode: %w", err)
+			}
+		case cmdPolicyAuthorize:
+			if err := runPolicyAuthorize(rw, session, p.CommandPolicy); err != nil {
+				return 0, false, err
+			}
+		default:
+			return 0, false, fmt.Errorf("policy command 0x%x is not supported", p.CommandCode)
+		}
+	}
+
+	return session, password, nil
+}
+
+// runPolicyAuthorize verifies the signature of the authority over the
+// current policy digest, and runs TPM2_PolicyAuthorize to replace it with the
+// authorized policy digest.
+func runPolicyAuthorize(rw io.ReadWriter, session tpmutil.Handle, commandPolicy []byte) error {
+	authority, policyRef, sig, err := decodePolicyAuthorize(commandPolicy)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		return errors.New("policy authorization is not signed")
+	}
+
+	approvedPolicy, err := tpm2.PolicyGetDigest(rw, session)
+	if err != nil {
+		return fmt.Errorf("error running PolicyGetDigest: %w", err)
+	}
+
+	h, err := authority.NameAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("error getting authority hash: %w", err)
+	}
+	hh := h.New()
+	hh.Write(approvedPolicy)
+	hh.Write(policyRef)
+
+	handle, name, err := tpm2.LoadExternal(rw, authority, tpm2.Private{Type: tpm2.AlgNull}, tpm2.HandleOwner)
+	if err != nil {
+		return fmt.Errorf("error loading authority: %w", err)
+	}
+	defer tpm2.FlushContext(rw, handle)
+
+	encodedSig, err := sig.Encode()
+	if err != nil {
+		return fmt.Errorf("error encoding signature: %w", err)
+	}
+	resp, err := runCommand(rw, cmdVerifySignature, handle, tpmutil.U16Bytes(hh.Sum(nil)), tpmutil.RawBytes(encodedSig))
+	if err != nil {
+		return fmt.Errorf("error verifying policy signature: %w", err)
+	}
+	var ticket tpm2.Ticket
+	if _, err := tpmutil.Unpack(resp, &ticket); err != nil {
+		return fmt.Errorf("error decoding verification ticket: %w", err)
+	}
+
+	if _, err := runCommand(rw, cmdPolicyAuthorize, session, tpmutil.U16Bytes(approvedPolicy), tpmutil.U16Bytes(policyRef), tpmutil.U16Bytes(name), ticket); err != nil {
+		return fmt.Errorf("error running PolicyAuthorize: %w", err)
+	}
+	return nil
+}
+
+// runCommand runs a command without sessions.
+func runCommand(rw io.ReadWriter, cmd tpmutil.Command, in ...any) ([]byte, error) {
+	resp, code, err := tpmutil.RunCommand(rw, tpm2.TagNoSessions, cmd, in...)
+	switch {
+	case err != nil:
+		return nil, err
+	case code != tpmutil.RCSuccess:
+		return nil, fmt.Errorf("response code 0x%x", uint32(code))
+	default:
+		return resp, nil
+	}
+}
+
+// decodePolicyPCR decodes the commandPolicy of a TPM2_PolicyPCR policy, as
+// encoded by [NewPolicyPCR].
+func decodePolicyPCR(b []byte) (tpm2.PCRSelection, []byte, error) {
+	var (
+		count uint32
+		hash  tpm2.Algorithm
+		size  uint8
+	)
+	buf := bytes.NewBuffer(b)
+	if err := tpmutil.UnpackBuf(buf, &count, &hash, &size); err != nil || count != 1 || buf.Len() < int(size) {
+		return tpm2.PCRSelection{}, nil, errors.New("malformed PolicyPCR policy")
+	}
+
+	sel := tpm2.PCRSelection{Hash: hash}
+	for i, v := range buf.Next(int(size)) {
+		for j := 0; j < 8; j++ {
+			if v&(1<<j) != 0 {
+				sel.PCRs = append(sel.PCRs, i*8+j)
+			}
+		}
+	}
+	return sel, buf.Bytes(), nil
+}
+
+// decodePolicyAuthorize decodes the commandPolicy of a TPM2_PolicyAuthorize
+// policy, as encoded by [NewPolicyAuthorize]. The signature is nil if the
+// policy contains a null signature.
+func decodePolicyAuthorize(b []byte) (tpm2.Public, []byte, *tpm2.Signature, error) {
+	var pub, policyRef tpmutil.U16Bytes
+	buf := bytes.NewBuffer(b)
+	if err := tpmutil.UnpackBuf(buf, &pub, &policyRef); err != nil {
+		return tpm2.Public{}, nil, nil, errors.New("malformed PolicyAuthorize policy")
+	}
+	authority, err := tpm2.DecodePublic(pub)
+	if err != nil {
+		return tpm2.Public{}, nil, nil, fmt.Errorf("malformed PolicyAuthorize authority: %w", err)
+	}
+
+	var alg tpm2.Algorithm
+	if err := tpmutil.UnpackBuf(bytes.NewBuffer(buf.Bytes()), &alg); err != nil {
+		return tpm2.Public{}, nil, nil, errors.New("malformed PolicyAuthorize signature")
+	}
+	if alg == tpm2.AlgNull {
+		return authority, policyRef, nil, nil
+	}
+	sig, err := tpm2.DecodeSignature(buf)
+	if err != nil {
+		return tpm2.Public{}, nil, nil, fmt.Errorf("malformed PolicyAuthorize signature: %w", err)
+	}
+	return authority, policyRef, sig, nil
+}
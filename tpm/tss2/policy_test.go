@@ -0,0 +1,136 @@
+package tss2
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPolicyPCR(t *testing.T) {
+	digest := make([]byte, 32)
+	tests := []struct {
+		name    string
+		sel     tpm2.PCRSelection
+		want    TPMPolicy
+		wantErr bool
+	}{
+		{"ok", tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{0, 7, 16}}, TPMPolicy{
+			CommandCode:   0x17F,
+			CommandPolicy: append([]byte{0, 0, 0, 1, 0, 0x0b, 3, 0x81, 0, 1}, digest...),
+		}, false},
+		{"fail/empty", tpm2.PCRSelection{Hash: tpm2.AlgSHA256}, TPMPolicy{}, true},
+		{"fail/index", tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{24}}, TPMPolicy{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPolicyPCR(tt.sel, digest)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewPolicyAuthorize(t *testing.T) {
+	authority := tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSign | tpm2.FlagUserWithAuth,
+		ECCParameters: &tpm2.ECCParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+			CurveID: tpm2.CurveNISTP256,
+			Point:   tpm2.ECPoint{XRaw: make([]byte, 32), YRaw: make([]byte, 32)},
+		},
+	}
+	pub, err := authority.Encode()
+	assert.NoError(t, err)
+
+	got, err := NewPolicyAuthorize(authority, []byte("ref"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0x16A, got.CommandCode)
+
+	want := append([]byte{0, byte(len(pub))}, pub...)
+	want = append(want, 0, 3, 'r', 'e', 'f', 0, 0x10)
+	assert.Equal(t, want, got.CommandPolicy)
+
+	sig := &tpm2.Signature{Alg: tpm2.AlgRSASSA, RSA: &tpm2.SignatureRSA{HashAlg: tpm2.AlgSHA256, Signature: []byte{1, 2, 3}}}
+	got, err = NewPolicyAuthorize(authority, nil, sig)
+	assert.NoError(t, err)
+	want = append([]byte{0, byte(len(pub))}, pub...)
+	want = append(want, 0, 0, 0, 0x14, 0, 0x0b, 0, 3, 1, 2, 3)
+	assert.Equal(t, want, got.CommandPolicy)
+}
+
+func TestPolicyOptions(t *testing.T) {
+	pcr, err := NewPolicyPCR(tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{7}}, make([]byte, 32))
+	assert.NoError(t, err)
+	authPolicy := TPMAuthPolicy{Name: "policy", Policy: []TPMPolicy{pcr}}
+
+	key := New([]byte("public"), []byte("private"),
+		WithEmptyAuth(false),
+		WithPolicy(pcr, NewPolicyPassword()),
+		WithAuthPolicy(authPolicy),
+	)
+	assert.False(t, key.EmptyAuth)
+	assert.Equal(t, []TPMPolicy{pcr, {CommandCode: 0x18C, CommandPolicy: []byte{}}}, key.Policy)
+	assert.Equal(t, []TPMAuthPolicy{authPolicy}, key.AuthPolicy)
+
+	b, err := MarshalPrivateKey(key)
+	assert.NoError(t, err)
+	parsed, err := ParsePrivateKey(b)
+	assert.NoError(t, err)
+	assert.False(t, parsed.EmptyAuth)
+	assert.Len(t, parsed.Policy, 2)
+	assert.Equal(t, pcr, parsed.Policy[0])
+	assert.Len(t, parsed.AuthPolicy, 1)
+}
+
+func Test_decodePolicy(t *testing.T) {
+	sel := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{0, 7, 16}}
+	digest := bytes.Repeat([]byte{1}, 32)
+	pcr, err := NewPolicyPCR(sel, digest)
+	assert.NoError(t, err)
+	gotSel, gotDigest, err := decodePolicyPCR(pcr.CommandPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, sel, gotSel)
+	assert.Equal(t, digest, gotDigest)
+
+	_, _, err = decodePolicyPCR([]byte{0, 0, 0, 2, 0, 0x0b, 3, 0, 0, 0})
+	assert.Error(t, err)
+
+	authority := tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSign | tpm2.FlagUserWithAuth,
+		ECCParameters: &tpm2.ECCParams{
+			Sign:    &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: tpm2.AlgSHA256},
+			CurveID: tpm2.CurveNISTP256,
+			Point:   tpm2.ECPoint{XRaw: make([]byte, 32), YRaw: make([]byte, 32)},
+		},
+	}
+	sig := &tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: big.NewInt(1), S: big.NewInt(2)}}
+	for _, s := range []*tpm2.Signature{nil, sig} {
+		p, err := NewPolicyAuthorize(authority, []byte("ref"), s)
+		assert.NoError(t, err)
+		gotAuthority, gotRef, gotSig, err := decodePolicyAuthorize(p.CommandPolicy)
+		assert.NoError(t, err)
+		assert.True(t, sameAuthority(authority, gotAuthority))
+		assert.Equal(t, []byte("ref"), gotRef)
+		assert.Equal(t, s, gotSig)
+	}
+
+	_, _, _, err = decodePolicyAuthorize([]byte{0, 1})
+	assert.Error(t, err)
+}
+
+func Test_validatePolicy(t *testing.T) {
+	assert.NoError(t, validatePolicy(nil))
+	assert.NoError(t, validatePolicy([]TPMPolicy{NewPolicyPassword(), {CommandCode: 0x16B}, {CommandCode: 0x16C}}))
+	assert.Error(t, validatePolicy([]TPMPolicy{{CommandCode: int(tpm2.CmdPolicySecret)}}))
+}
@@ -17,6 +17,7 @@
 package tss2
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -88,6 +89,11 @@ type Signer struct {
 	publicKey   crypto.PublicKey
 	tpmKey      *TPMKey
 	srkTemplate tpm2.Public
+	keyHandle   tpmutil.Handle
+	password    string
+	// imported is the private blob of an importable key, after it's imported
+	// under its parent.
+	imported []byte
 }
 
 // CreateSigner creates a new [crypto.Signer] with the given TPM (rw) and
@@ -98,14 +104,14 @@ func CreateSigner(rw io.ReadWriter, key *TPMKey) (*Signer, error) {
 		return nil, fmt.Errorf("invalid TPM channel: rw cannot be nil")
 	case key == nil:
 		return nil, fmt.Errorf("invalid TPM key: key cannot be nil")
-	case !key.Type.Equal(oidLoadableKey):
+	case key.IsSealed():
+		return nil, errors.New("invalid TSS2 key: sealed data cannot be used for signing")
+	case !key.IsLoadable() && !key.IsImportable():
 		return nil, fmt.Errorf("invalid TSS2 key: type %q is not valid", key.Type.String())
-	case len(key.Policy) != 0:
-		return nil, errors.New("invalid TSS2 key: policy is not implemented")
-	case len(key.AuthPolicy) != 0:
-		return nil, errors.New("invalid TSS2 key: auth policy is not implemented")
-	case len(key.Secret) > 0:
+	case key.IsLoadable() && len(key.Secret) > 0:
 		return nil, errors.New("invalid TSS2 key: secret should not be set")
+	case key.IsImportable() && !validateKey(key.Secret):
+		return nil, errors.New("invalid TSS2 key: secret is invalid")
 	case !validateParent(key.Parent):
 		return nil, fmt.Errorf("invalid TSS2 key: parent '%d' is not valid", key.Parent)
 	case !validateKey(key.PublicKey):
@@ -114,6 +120,15 @@ func CreateSigner(rw io.ReadWriter, key *TPMKey) (*Signer, error) {
 		return nil, errors.New("invalid TSS2 key: private key key is invalid")
 	}
 
+	if err := validatePolicy(key.Policy); err != nil {
+		return nil, fmt.Errorf("invalid TSS2 key: %w", err)
+	}
+	for _, ap := range key.AuthPolicy {
+		if err := validatePolicy(ap.Policy); err != nil {
+			return nil, fmt.Errorf("invalid TSS2 key: auth policy %q: %w", ap.Name, err)
+		}
+	}
+
 	publicKey, err := key.Public()
 	if err != nil {
 		return nil, fmt.Errorf("error decoding TSS2 public key: %w", err)
@@ -157,6 +172,35 @@ func (s *Signer) SetCommandChannel(rw io.ReadWriter) {
 	s.m.Unlock()
 }
 
+// SetKeyHandle sets the persistent handle the key is persisted at. If set,
+// the persistent key is used for signing instead of loading the key on every
+// signature. If the key isn't persisted at the handle, it's loaded from its
+// blobs.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (s *Signer) SetKeyHandle(handle tpmutil.Handle) {
+	s.m.Lock()
+	s.keyHandle = handle
+	s.m.Unlock()
+}
+
+// SetPassword sets the password of the key. It's required for keys without
+// an empty authorization value, and for keys with a policy that requires the
+// password.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (s *Signer) SetPassword(password string) {
+	s.m.Lock()
+	s.password = password
+	s.m.Unlock()
+}
+
 // Public implements the [crypto.Signer] interface.
 func (s *Signer) Public() crypto.PublicKey {
 	return s.publicKey
@@ -164,43 +208,150 @@ func (s *Signer) Public() crypto.PublicKey {
 
 // Sign implements the [crypto.Signer] interface.
 func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
+	keyHandle, err := s.persistentKeyHandle()
+	if err != nil {
+		return nil, err
+	}
+	if keyHandle == 0 {
+		var flush func()
+		if keyHandle, flush, err = s.loadKey(); err != nil {
+			return nil, err
+		}
+		defer flush()
+	}
+
+	auth := keyAuth{password: s.password}
+	if len(s.tpmKey.Policy) > 0 {
+		var password bool
+		if auth.session, password, err = keyPolicySession(s.rw, s.tpmKey); err != nil {
+			return nil, err
+		}
+		defer tpm2.FlushContext(s.rw, auth.session)
+		if !password {
+			auth.password = ""
+		}
+	}
+
+	switch p := s.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return signECDSA(s.rw, keyHandle, auth, digest, p.Curve)
+	case *rsa.PublicKey:
+		return signRSA(s.rw, keyHandle, auth, digest, opts)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", s.publicKey)
+	}
+}
+
+// keyAuth is the authorization used to sign with a key. If session is set,
+// the policy session is used, and the password is only sent if the policy
+// requires it.
+type keyAuth struct {
+	session  tpmutil.Handle
+	password string
+}
+
+func (a keyAuth) sign(rw io.ReadWriter, key tpmutil.Handle, digest []byte, scheme *tpm2.SigScheme) (*tpm2.Signature, error) {
+	if a.session == 0 {
+		return tpm2.Sign(rw, key, a.password, digest, nil, scheme)
+	}
+	return tpm2.SignWithSession(rw, a.session, key, a.password, digest, nil, scheme)
+}
+
+// persistentKeyHandle returns the persistent handle set using
+// [Signer.SetKeyHandle], if the key is persisted at it. It returns 0
+// otherwise.
+func (s *Signer) persistentKeyHandle() (tpmutil.Handle, error) {
+	if s.keyHandle == 0 {
+		return 0, nil
+	}
+
+	public, err := tpm2.DecodePublic(s.tpmKey.PublicKey[2:])
+	if err != nil {
+		return 0, fmt.Errorf("error decoding public key: %w", err)
+	}
+	name, err := public.Name()
+	if err != nil {
+		return 0, fmt.Errorf("error computing key name: %w", err)
+	}
+	keyName, err := tpmutil.Pack(name.Digest.Alg, tpmutil.RawBytes(name.Digest.Value))
+	if err != nil {
+		return 0, fmt.Errorf("error encoding key name: %w", err)
+	}
+
+	_, persistedName, _, err := tpm2.ReadPublic(s.rw, s.keyHandle)
+	if err != nil {
+		var herr tpm2.HandleError
+		if errors.As(err, &herr) && herr.Code == tpm2.RCHandle {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading persistent key: %w", err)
+	}
+	if !bytes.Equal(keyName, persistedName) {
+		return 0, nil
+	}
+
+	return s.keyHandle, nil
+}
+
+// loadKey loads the key under its parent, and returns the handle of the
+// loaded key and a function to flush it. Importable keys are imported under
+// the parent the first time they're used.
+func (s *Signer) loadKey() (tpmutil.Handle, func(), error) {
 	parent, err := convert.SafeUint32(s.tpmKey.Parent)
 	if err != nil {
-		return nil, fmt.Errorf("failed converting parent handle: %w", err)
+		return 0, nil, fmt.Errorf("failed converting parent handle: %w", err)
 	}
 
 	parentHandle := tpmutil.Handle(parent)
 	if !handleIsPersistent(s.tpmKey.Parent) {
 		parentHandle, _, err = tpm2.CreatePrimary(s.rw, parentHandle, tpm2.PCRSelection{}, "", "", s.srkTemplate)
 		if err != nil {
-			return nil, fmt.Errorf("error creating primary: %w", err)
+			return 0, nil, fmt.Errorf("error creating primary: %w", err)
 		}
 		defer tpm2.FlushContext(s.rw, parentHandle)
 	}
 
-	keyHandle, _, err := tpm2.Load(s.rw, parentHandle, "", s.tpmKey.PublicKey[2:], s.tpmKey.PrivateKey[2:])
+	private := s.tpmKey.PrivateKey[2:]
+	if s.tpmKey.IsImportable() {
+		if private, err = s.importKey(parentHandle); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	keyHandle, _, err := tpm2.Load(s.rw, parentHandle, "", s.tpmKey.PublicKey[2:], private)
 	if err != nil {
-		return nil, fmt.Errorf("error loading key handle: %w", err)
+		return 0, nil, fmt.Errorf("error loading key handle: %w", err)
 	}
-	defer tpm2.FlushContext(s.rw, keyHandle)
 
-	switch p := s.publicKey.(type) {
-	case *ecdsa.PublicKey:
-		return signECDSA(s.rw, keyHandle, digest, p.Curve)
-	case *rsa.PublicKey:
-		return signRSA(s.rw, keyHandle, digest, opts)
-	default:
-		return nil, fmt.Errorf("unsupported signing key type %T", s.publicKey)
+	return keyHandle, func() { tpm2.FlushContext(s.rw, keyHandle) }, nil
+}
+
+// importKey imports an importable key under the parent, and returns its
+// private blob. The key is only imported once, even if multiple signatures
+// are created concurrently.
+func (s *Signer) importKey(parent tpmutil.Handle) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.imported == nil {
+		auth := tpm2.AuthCommand{Session: tpm2.HandlePasswordSession, Attributes: tpm2.AttrContinueSession}
+		imported, err := tpm2.Import(s.rw, parent, auth, s.tpmKey.PublicKey[2:], s.tpmKey.PrivateKey[2:], s.tpmKey.Secret[2:], nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error importing key: %w", err)
+		}
+		s.imported = imported
 	}
+
+	return s.imported, nil
 }
 
 // https://github.com/smallstep/go-attestation/blob/f5480326fb6d63859537ec89fbea7c62485bc4da/attest/wrapped_tpm20.go#L513
-func signECDSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, curve elliptic.Curve) ([]byte, error) {
+func signECDSA(rw io.ReadWriter, key tpmutil.Handle, auth keyAuth, digest []byte, curve elliptic.Curve) ([]byte, error) {
 	scheme, err := curveSigScheme(curve)
 	if err != nil {
 		return nil, err
 	}
-	sig, err := tpm2.Sign(rw, key, "", digest, nil, scheme)
+	sig, err := auth.sign(rw, key, digest, scheme)
 	if err != nil {
 		return nil, fmt.Errorf("error creating ECDSA signature: %w", err)
 	}
@@ -214,7 +365,7 @@ func signECDSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, curve ellipt
 }
 
 // https://github.com/smallstep/go-attestation/blob/f5480326fb6d63859537ec89fbea7c62485bc4da/attest/wrapped_tpm20.go#L527
-func signRSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+func signRSA(rw io.ReadWriter, key tpmutil.Handle, auth keyAuth, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
 	h, err := tpm2.HashToAlgorithm(opts.HashFunc())
 	if err != nil {
 		return nil, fmt.Errorf("error getting algorithm: %w", err)
@@ -232,7 +383,7 @@ func signRSA(rw io.ReadWriter, key tpmutil.Handle, digest []byte, opts crypto.Si
 		scheme.Alg = tpm2.AlgRSAPSS
 	}
 
-	sig, err := tpm2.Sign(rw, key, "", digest, nil, scheme)
+	sig, err := auth.sign(rw, key, digest, scheme)
 	if err != nil {
 		return nil, fmt.Errorf("error creating RSA signature: %w", err)
 	}
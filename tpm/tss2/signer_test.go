@@ -7,7 +7,11 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"sync"
 	"testing"
 
 	"github.com/google/go-tpm/legacy/tpm2"
@@ -189,6 +193,195 @@ func TestSign_SetTPM(t *testing.T) {
 	}
 }
 
+func TestSign_importable(t *testing.T) {
+	rw := openTPM(t)
+	t.Cleanup(func() {
+		assert.NoError(t, rw.Close())
+	})
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("importable-key"))
+
+	for _, srkTemplate := range []tpm2.Public{RSASRKTemplate, ECCSRKTemplate} {
+		parentHnd, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+		require.NoError(t, err)
+		parent, _, _, err := tpm2.ReadPublic(rw, parentHnd)
+		require.NoError(t, err)
+		require.NoError(t, tpm2.FlushContext(rw, parentHnd))
+
+		for _, key := range []crypto.Signer{rsaKey, ecKey} {
+			t.Run(fmt.Sprintf("%v/%T", srkTemplate.Type, key), func(t *testing.T) {
+				tpmKey, err := WrapKey(parent, key)
+				require.NoError(t, err)
+				assert.True(t, tpmKey.IsImportable())
+
+				b, err := tpmKey.EncodeToMemory()
+				require.NoError(t, err)
+				tpmKey, err = ParsePrivateKey(parsePEM(string(b)))
+				require.NoError(t, err)
+
+				signer, err := CreateSigner(rw, tpmKey)
+				require.NoError(t, err)
+				signer.SetSRKTemplate(srkTemplate)
+				assert.Equal(t, key.Public(), signer.Public())
+
+				// the second signature uses the imported key
+				for i := 0; i < 2; i++ {
+					sig, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+					require.NoError(t, err)
+					switch pub := key.Public().(type) {
+					case *ecdsa.PublicKey:
+						assert.True(t, ecdsa.VerifyASN1(pub, sum[:], sig))
+					case *rsa.PublicKey:
+						assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig))
+					}
+				}
+
+				if pub, ok := key.Public().(*rsa.PublicKey); ok {
+					opts := &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash}
+					sig, err := signer.Sign(rand.Reader, sum[:], opts)
+					require.NoError(t, err)
+					assert.NoError(t, rsa.VerifyPSS(pub, crypto.SHA256, sum[:], sig, opts))
+				}
+			})
+		}
+	}
+
+	_, err = WrapKey(RSASRKTemplate, "not a key")
+	assert.Error(t, err)
+}
+
+// serialRW serializes the TPM commands sent by concurrent goroutines, and
+// counts the TPM2_Import commands.
+type serialRW struct {
+	mu      sync.Mutex
+	rw      io.ReadWriter
+	imports int
+}
+
+func (s *serialRW) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	if len(p) >= 10 && tpmutil.Command(binary.BigEndian.Uint32(p[6:10])) == tpm2.CmdImport {
+		s.imports++
+	}
+	return s.rw.Write(p)
+}
+
+func (s *serialRW) Read(p []byte) (int, error) {
+	defer s.mu.Unlock()
+	return s.rw.Read(p)
+}
+
+func TestSign_importableConcurrent(t *testing.T) {
+	rw := openTPM(t)
+	t.Cleanup(func() {
+		assert.NoError(t, rw.Close())
+	})
+
+	// use a persistent parent to not run out of transient object slots
+	const parentHandle = tpmutil.Handle(0x81000100)
+	hnd, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", RSASRKTemplate)
+	require.NoError(t, err)
+	require.NoError(t, tpm2.EvictControl(rw, "", tpm2.HandleOwner, hnd, parentHandle))
+	require.NoError(t, tpm2.FlushContext(rw, hnd))
+	t.Cleanup(func() {
+		assert.NoError(t, tpm2.EvictControl(rw, "", tpm2.HandleOwner, parentHandle, parentHandle))
+	})
+	parent, _, _, err := tpm2.ReadPublic(rw, parentHandle)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpmKey, err := WrapKey(parent, key, WithParent(parentHandle))
+	require.NoError(t, err)
+
+	srw := &serialRW{rw: rw}
+	signer, err := CreateSigner(srw, tpmKey)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("importable-key"))
+	var wg sync.WaitGroup
+	sigs := make([][]byte, 2)
+	errs := make([]error, 2)
+	for i := range sigs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sigs[i], errs[i] = signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range sigs {
+		require.NoError(t, errs[i])
+		assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, sum[:], sigs[i]))
+	}
+	assert.Equal(t, 1, srw.imports)
+}
+
+func TestSign_policy(t *testing.T) {
+	rw := openTPM(t)
+	t.Cleanup(func() {
+		assert.NoError(t, rw.Close())
+	})
+
+	parentHnd, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", ECCSRKTemplate)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, tpm2.FlushContext(rw, parentHnd))
+	})
+
+	sel := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{16}}
+	pcr, err := tpm2.ReadPCR(rw, 16, tpm2.AlgSHA256)
+	require.NoError(t, err)
+	pcrDigest := sha256.Sum256(pcr)
+	policyPCR, err := NewPolicyPCR(sel, pcrDigest[:])
+	require.NoError(t, err)
+	policy := []TPMPolicy{
+		policyPCR,
+		NewPolicyPassword(),
+		{CommandCode: int(tpm2.CmdPolicyCommandCode), CommandPolicy: []byte{0, 0, 1, 0x5d}}, // TPM2_Sign
+	}
+
+	// compute the policy digest running the policy
+	session, password, err := runPolicySession(rw, policy)
+	require.NoError(t, err)
+	assert.True(t, password)
+	policyDigest, err := tpm2.PolicyGetDigest(rw, session)
+	require.NoError(t, err)
+	require.NoError(t, tpm2.FlushContext(rw, session))
+
+	params := defaultKeyParamsEC
+	params.Attributes &^= tpm2.FlagUserWithAuth
+	params.AuthPolicy = policyDigest
+	priv, pub, _, _, _, err := tpm2.CreateKey(rw, parentHnd, tpm2.PCRSelection{}, "", "pass", params)
+	require.NoError(t, err)
+
+	signer, err := CreateSigner(rw, New(pub, priv, WithEmptyAuth(false), WithPolicy(policy...)))
+	require.NoError(t, err)
+	signer.SetSRKTemplate(ECCSRKTemplate)
+
+	sum := sha256.Sum256([]byte("policy-key"))
+	signer.SetPassword("pass")
+	sig, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), sum[:], sig))
+
+	signer.SetPassword("wrong")
+	_, err = signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	assert.Error(t, err)
+
+	// the policy fails after extending the PCR
+	signer.SetPassword("pass")
+	require.NoError(t, tpm2.PCRExtend(rw, 16, tpm2.AlgSHA256, sum[:], ""))
+	_, err = signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	assert.Error(t, err)
+}
+
 func TestCreateSigner(t *testing.T) {
 	var rw bytes.Buffer
 	key, err := ParsePrivateKey(parsePEM(p256TSS2PEM))
@@ -202,6 +395,11 @@ func TestCreateSigner(t *testing.T) {
 	modKey := func(fn TPMOption) *TPMKey {
 		return New(key.PublicKey[2:], key.PrivateKey[2:], fn)
 	}
+	importable := NewImportable(key.PublicKey[2:], key.PrivateKey[2:], []byte("secret"))
+	withPolicy := New(key.PublicKey[2:], key.PrivateKey[2:], WithEmptyAuth(false), WithPolicy(
+		NewPolicyPassword(),
+		TPMPolicy{CommandCode: int(tpm2.CmdPolicyCommandCode), CommandPolicy: []byte{0, 0, 1, 0x5d}},
+	))
 
 	type args struct {
 		rw  io.ReadWriter
@@ -216,6 +414,12 @@ func TestCreateSigner(t *testing.T) {
 		{"ok", args{&rw, key}, &Signer{
 			rw: &rw, publicKey: publicKey, tpmKey: key, srkTemplate: RSASRKTemplate,
 		}, assert.NoError},
+		{"ok importable", args{&rw, importable}, &Signer{
+			rw: &rw, publicKey: publicKey, tpmKey: importable, srkTemplate: RSASRKTemplate,
+		}, assert.NoError},
+		{"ok policy", args{&rw, withPolicy}, &Signer{
+			rw: &rw, publicKey: publicKey, tpmKey: withPolicy, srkTemplate: RSASRKTemplate,
+		}, assert.NoError},
 		{"fail rw", args{nil, key}, nil, assert.Error},
 		{"fail key", args{&rw, nil}, nil, assert.Error},
 		{"fail type", args{&rw, modKey(func(k *TPMKey) {
@@ -230,6 +434,15 @@ func TestCreateSigner(t *testing.T) {
 		{"fail secret", args{&rw, modKey(func(k *TPMKey) {
 			k.Secret = []byte("secret")
 		})}, nil, assert.Error},
+		{"fail importable secret", args{&rw, modKey(func(k *TPMKey) {
+			k.Type = oidImportableKey
+		})}, nil, assert.Error},
+		{"fail authPolicy command", args{&rw, modKey(func(k *TPMKey) {
+			k.Policy = []TPMPolicy{{CommandCode: cmdPolicyAuthorize, CommandPolicy: []byte("command-policy")}}
+			k.AuthPolicy = []TPMAuthPolicy{{Name: "auth", Policy: []TPMPolicy{
+				{CommandCode: int(tpm2.CmdPolicySecret), CommandPolicy: []byte("command-policy")},
+			}}}
+		})}, nil, assert.Error},
 		{"fail parent", args{&rw, modKey(func(k *TPMKey) {
 			k.Parent = 0
 		})}, nil, assert.Error},
@@ -330,7 +543,7 @@ func Test_signECDSA_fail(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := signECDSA(tt.args.rw, tt.args.key, tt.args.digest, tt.args.curve)
+			got, err := signECDSA(tt.args.rw, tt.args.key, keyAuth{}, tt.args.digest, tt.args.curve)
 			tt.assertion(t, err)
 			assert.Equal(t, tt.want, got)
 		})
@@ -367,7 +580,7 @@ func Test_signRSA_fail(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := signRSA(tt.args.rw, tt.args.key, tt.args.digest, tt.args.opts)
+			got, err := signRSA(tt.args.rw, tt.args.key, keyAuth{}, tt.args.digest, tt.args.opts)
 			tt.assertion(t, err)
 			assert.Equal(t, tt.want, got)
 		})
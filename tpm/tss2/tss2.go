@@ -59,6 +59,24 @@ type TPMAuthPolicy struct {
 	Policy []TPMPolicy `asn1:"explicit,tag:1"`
 }
 
+// IsLoadable returns true if the [TPMKey] is a key that can be loaded directly
+// under its parent.
+func (k *TPMKey) IsLoadable() bool {
+	return k.Type.Equal(oidLoadableKey)
+}
+
+// IsImportable returns true if the [TPMKey] is a key that must be imported
+// under its parent before it can be loaded.
+func (k *TPMKey) IsImportable() bool {
+	return k.Type.Equal(oidImportableKey)
+}
+
+// IsSealed returns true if the [TPMKey] contains sealed data instead of a
+// key.
+func (k *TPMKey) IsSealed() bool {
+	return k.Type.Equal(oidSealedKey)
+}
+
 // ParsePrivateKey parses a single TPM key from the given ASN.1 DER data.
 //
 // # Experimental
@@ -84,7 +102,6 @@ func ParsePrivateKey(derBytes []byte) (*TPMKey, error) {
 		}
 	}
 
-	// TODO(mariano): generate key with policy
 	if tag, ok := readOptionalTag(&input, 1); ok {
 		var policy cryptobyte.String
 		if !tag.ReadASN1(&policy, cryptobyte_asn1.SEQUENCE) {
@@ -96,14 +113,12 @@ func ParsePrivateKey(derBytes []byte) (*TPMKey, error) {
 		}
 	}
 
-	// TODO(mariano): generate key with secret
 	if tag, ok := readOptionalTag(&input, 2); ok {
 		if key.Secret, ok = readOctetString(&tag); !ok {
 			return nil, errors.New("malformed TSS2 secret")
 		}
 	}
 
-	// TODO(mariano): generate key with authPolicy
 	if tag, ok := readOptionalTag(&input, 3); ok {
 		if key.AuthPolicy, err = readTPMAuthPolicy(&tag); err != nil {
 			return nil, err
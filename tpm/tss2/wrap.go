@@ -0,0 +1,157 @@
+package tss2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	gotpm "github.com/google/go-tpm/tpm2"
+
+	"go.step.sm/crypto/internal/utils/convert"
+)
+
+// seedSize is the size of the obfuscation value of wrapped keys.
+const seedSize = 32
+
+// WrapKey wraps an RSA or ECDSA private key to the parent with the given
+// public area, and returns an importable [TPMKey]. The key can only be
+// imported by the TPM the parent belongs to. The parent handle is set using
+// [WithParent], and defaults to the owner hierarchy, in which case the parent
+// is recreated from the SRK template when the key is used.
+//
+// Wrapped keys are signing keys without a password or policy.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func WrapKey(parent tpm2.Public, key any, opts ...TPMOption) (*TPMKey, error) {
+	public, sensitive, err := wrappedKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := public.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding public key: %w", err)
+	}
+	tpmPublic, err := toTPMTPublic(pub)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %w", err)
+	}
+	name, err := gotpm.ObjectName(tpmPublic)
+	if err != nil {
+		return nil, fmt.Errorf("error computing key name: %w", err)
+	}
+
+	parentPub, err := parent.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding parent: %w", err)
+	}
+	parentPublic, err := toTPMTPublic(parentPub)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding parent: %w", err)
+	}
+	encapsulationKey, err := gotpm.ImportEncapsulationKey(parentPublic)
+	if err != nil {
+		return nil, fmt.Errorf("error importing parent: %w", err)
+	}
+
+	dup, secret, err := gotpm.CreateDuplicate(rand.Reader, encapsulationKey, name.Buffer, gotpm.Marshal(sensitive))
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping key: %w", err)
+	}
+
+	return NewImportable(pub, dup, secret, opts...), nil
+}
+
+// wrappedKey returns the public and sensitive areas of the key.
+func wrappedKey(key any) (*tpm2.Public, *gotpm.TPMTSensitive, error) {
+	seed := make([]byte, seedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, fmt.Errorf("error generating seed: %w", err)
+	}
+
+	public := &tpm2.Public{
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: tpm2.FlagSign | tpm2.FlagUserWithAuth,
+	}
+	sensitive := &gotpm.TPMTSensitive{
+		SeedValue: gotpm.TPM2BDigest{Buffer: seed},
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, nil, errors.New("unsupported RSA key: multi-prime keys are not supported")
+		}
+		var bits uint16
+		switch size := k.N.BitLen(); size {
+		case 1024, 2048, 3072, 4096:
+			bits = uint16(size)
+		default:
+			return nil, nil, fmt.Errorf("unsupported RSA key size %d", size)
+		}
+		exponent, err := convert.SafeUint32(k.E)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unsupported RSA exponent: %w", err)
+		}
+		if exponent == 1<<16+1 {
+			exponent = 0 // default exponent
+		}
+		public.Type = tpm2.AlgRSA
+		public.RSAParameters = &tpm2.RSAParams{
+			KeyBits:     bits,
+			ExponentRaw: exponent,
+			ModulusRaw:  k.N.FillBytes(make([]byte, bits/8)),
+		}
+		sensitive.SensitiveType = gotpm.TPMAlgRSA
+		sensitive.Sensitive = gotpm.NewTPMUSensitiveComposite(gotpm.TPMAlgRSA, &gotpm.TPM2BPrivateKeyRSA{
+			Buffer: k.Primes[0].FillBytes(make([]byte, bits/16)),
+		})
+	case *ecdsa.PrivateKey:
+		curveID, err := curveToTPM(k.Curve)
+		if err != nil {
+			return nil, nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		public.Type = tpm2.AlgECC
+		public.ECCParameters = &tpm2.ECCParams{
+			CurveID: curveID,
+			Point: tpm2.ECPoint{
+				XRaw: k.X.FillBytes(make([]byte, size)),
+				YRaw: k.Y.FillBytes(make([]byte, size)),
+			},
+		}
+		sensitive.SensitiveType = gotpm.TPMAlgECC
+		sensitive.Sensitive = gotpm.NewTPMUSensitiveComposite(gotpm.TPMAlgECC, &gotpm.TPM2BECCParameter{
+			Buffer: k.D.FillBytes(make([]byte, size)),
+		})
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return public, sensitive, nil
+}
+
+func curveToTPM(curve elliptic.Curve) (tpm2.EllipticCurve, error) {
+	switch curve {
+	case elliptic.P256():
+		return tpm2.CurveNISTP256, nil
+	case elliptic.P384():
+		return tpm2.CurveNISTP384, nil
+	case elliptic.P521():
+		return tpm2.CurveNISTP521, nil
+	default:
+		return 0, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+// toTPMTPublic decodes an encoded public area using the go-tpm direct API.
+func toTPMTPublic(b []byte) (*gotpm.TPMTPublic, error) {
+	return gotpm.Unmarshal[gotpm.TPMTPublic](b)
+}
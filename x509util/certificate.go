@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+
+	"go.step.sm/crypto/rsapss"
 )
 
 // Certificate is the JSON representation of a X.509 certificate. It is used to
@@ -47,7 +49,7 @@ type Certificate struct {
 // NewCertificate creates a new Certificate from an x509.CertificateRequest and
 // will apply some template options.
 func NewCertificate(cr *x509.CertificateRequest, opts ...Option) (*Certificate, error) {
-	if err := cr.CheckSignature(); err != nil {
+	if err := rsapss.CheckCertificateRequestSignature(cr); err != nil {
 		return nil, errors.Wrap(err, "error validating certificate request")
 	}
 
@@ -207,6 +209,11 @@ func (c *Certificate) hasExtension(oid ObjectIdentifier) bool {
 
 // CreateCertificate signs the given template using the parent private key and
 // returns it.
+//
+// The public key and the signer can be restricted to RSASSA-PSS using the
+// types in the rsapss package. If the parent certificate has a restricted
+// public key, the signature parameters in the public key will be used, even if
+// the signer is a regular RSA signer.
 func CreateCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
 	var err error
 	// Complete certificate.
@@ -221,6 +228,11 @@ func CreateCertificate(template, parent *x509.Certificate, pub crypto.PublicKey,
 		}
 	}
 
+	// Keys restricted to RSASSA-PSS are not supported by crypto/x509.
+	if isRSAPSSRestricted(parent, pub, signer) {
+		return createRSAPSSCertificate(template, parent, pub, signer)
+	}
+
 	// Sign certificate
 	asn1Data, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
 	if err != nil {
@@ -235,7 +247,7 @@ func CreateCertificate(template, parent *x509.Certificate, pub crypto.PublicKey,
 
 // CreateCertificateTemplate creates a X.509 certificate template from the given certificate request.
 func CreateCertificateTemplate(cr *x509.CertificateRequest) (*x509.Certificate, error) {
-	if err := cr.CheckSignature(); err != nil {
+	if err := rsapss.CheckCertificateRequestSignature(cr); err != nil {
 		return nil, errors.Wrap(err, "error validating certificate request")
 	}
 	// Set SubjectAltName extension as critical if Subject is empty.
@@ -1182,13 +1182,13 @@ func (s *SerialNumber) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
-	// Assume a number.
-	var i int64
-	if err := json.Unmarshal(data, &i); err != nil {
+	// Assume a number, serial numbers are usually larger than an int64.
+	b := new(big.Int)
+	if err := b.UnmarshalJSON(data); err != nil {
 		return errors.Wrap(err, "error unmarshaling json")
 	}
 	*s = SerialNumber{
-		Int: new(big.Int).SetInt64(i),
+		Int: b,
 	}
 	return nil
 }
@@ -1220,6 +1220,7 @@ func TestSerialNumber_UnmarshalJSON(t *testing.T) {
 		{"string", args{[]byte(`"12345"`)}, expected, false},
 		{"stringHex", args{[]byte(`"0x3039"`)}, expected, false},
 		{"number", args{[]byte(`12345`)}, expected, false},
+		{"bigNumber", args{[]byte(`340282366920938463463374607431768211455`)}, SerialNumber{new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))}, false},
 		{"badString", args{[]byte(`"123s"`)}, SerialNumber{}, true},
 		{"object", args{[]byte(`{}`)}, SerialNumber{}, true},
 		{"badJSON", args{[]byte(`{`)}, SerialNumber{}, true},
@@ -0,0 +1,621 @@
+package x509util
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRevocationListDuration is the time between the this update and the
+// next update of a revocation list, used if the next update is not set.
+const DefaultRevocationListDuration = 24 * time.Hour
+
+var (
+	oidExtensionCRLNumber                = []int{2, 5, 29, 20}
+	oidExtensionReasonCode               = []int{2, 5, 29, 21}
+	oidExtensionInvalidityDate           = []int{2, 5, 29, 24}
+	oidExtensionDeltaCRLIndicator        = []int{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = []int{2, 5, 29, 28}
+	oidExtensionAuthorityKeyID           = []int{2, 5, 29, 35}
+	oidExtensionFreshestCRL              = []int{2, 5, 29, 46}
+)
+
+// RevocationList is the JSON representation of a X.509 certificate revocation
+// list. It is used to build a revocation list from a template.
+//
+// The issuer and the authority key identifier are always taken from the
+// issuer certificate when the revocation list is signed, they are only
+// informational when a revocation list is parsed.
+type RevocationList struct {
+	Issuer                   Issuer                    `json:"issuer"`
+	Number                   CRLNumber                 `json:"number"`
+	ThisUpdate               time.Time                 `json:"thisUpdate"`
+	NextUpdate               time.Time                 `json:"nextUpdate"`
+	RevokedCertificates      []RevocationListEntry     `json:"revokedCertificates"`
+	DeltaCRLIndicator        CRLNumber                 `json:"deltaCRLIndicator"`
+	IssuingDistributionPoint *IssuingDistributionPoint `json:"issuingDistributionPoint"`
+	FreshestCRL              FreshestCRL               `json:"freshestCRL"`
+	AuthorityKeyID           AuthorityKeyID            `json:"authorityKeyId"`
+	Extensions               []Extension               `json:"extensions"`
+	SignatureAlgorithm       SignatureAlgorithm        `json:"signatureAlgorithm"`
+}
+
+// RevocationListEntry is the JSON representation of a revoked certificate in
+// a revocation list.
+type RevocationListEntry struct {
+	SerialNumber   SerialNumber `json:"serialNumber"`
+	RevocationTime time.Time    `json:"revocationTime"`
+	ReasonCode     ReasonCode   `json:"reasonCode"`
+	InvalidityDate time.Time    `json:"invalidityDate"`
+	Extensions     []Extension  `json:"extensions"`
+}
+
+// NewRevocationList creates a new RevocationList applying the given template
+// options. If no template is given, an empty revocation list is returned.
+//
+// The revocation list can be signed using GetRevocationList and
+// CreateRevocationList.
+func NewRevocationList(opts ...Option) (*RevocationList, error) {
+	o, err := new(Options).apply(&x509.CertificateRequest{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no template is set, return an empty revocation list.
+	if o.CertBuffer == nil {
+		return &RevocationList{}, nil
+	}
+
+	// With templates
+	var rl RevocationList
+	if err := json.NewDecoder(o.CertBuffer).Decode(&rl); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling revocation list")
+	}
+
+	return &rl, nil
+}
+
+// NewRevocationListFromX509 creates a new RevocationList from an
+// x509.RevocationList. The delta CRL indicator, issuing distribution point,
+// freshest CRL and invalidity date extensions are decoded into their fields.
+// If any of them is improperly encoded or uses features not supported by
+// RevocationList, it is kept as a raw extension.
+func NewRevocationListFromX509(crl *x509.RevocationList) *RevocationList {
+	rl := &RevocationList{
+		Issuer:             newIssuer(crl.Issuer),
+		ThisUpdate:         crl.ThisUpdate,
+		NextUpdate:         crl.NextUpdate,
+		AuthorityKeyID:     crl.AuthorityKeyId,
+		SignatureAlgorithm: SignatureAlgorithm(crl.SignatureAlgorithm),
+	}
+	if crl.Number != nil {
+		rl.Number = CRLNumber{Int: crl.Number}
+	}
+
+	for _, e := range crl.Extensions {
+		var ok bool
+		switch {
+		case e.Id.Equal(oidExtensionCRLNumber), e.Id.Equal(oidExtensionAuthorityKeyID):
+			ok = true // already parsed by crypto/x509
+		case e.Id.Equal(oidExtensionDeltaCRLIndicator):
+			ok = rl.DeltaCRLIndicator.parseExtension(e)
+		case e.Id.Equal(oidExtensionIssuingDistributionPoint):
+			rl.IssuingDistributionPoint, ok = parseIssuingDistributionPoint(e)
+		case e.Id.Equal(oidExtensionFreshestCRL):
+			rl.FreshestCRL, ok = parseFreshestCRL(e)
+		}
+		if !ok {
+			rl.Extensions = append(rl.Extensions, newExtension(e))
+		}
+	}
+
+	for _, rc := range crl.RevokedCertificateEntries {
+		entry := RevocationListEntry{
+			SerialNumber:   SerialNumber{Int: rc.SerialNumber},
+			RevocationTime: rc.RevocationTime,
+			ReasonCode:     ReasonCode(rc.ReasonCode),
+		}
+		for _, e := range rc.Extensions {
+			var ok bool
+			switch {
+			case e.Id.Equal(oidExtensionReasonCode):
+				ok = true // already parsed by crypto/x509
+			case e.Id.Equal(oidExtensionInvalidityDate):
+				entry.InvalidityDate, ok = parseInvalidityDate(e)
+			}
+			if !ok {
+				entry.Extensions = append(entry.Extensions, newExtension(e))
+			}
+		}
+		rl.RevokedCertificates = append(rl.RevokedCertificates, entry)
+	}
+
+	return rl
+}
+
+// ParseRevocationList parses a DER encoded revocation list and returns its
+// RevocationList representation. The signature of the revocation list is not
+// verified, use x509.RevocationList.CheckSignatureFrom for it.
+func ParseRevocationList(der []byte) (*RevocationList, error) {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing revocation list")
+	}
+	return NewRevocationListFromX509(crl), nil
+}
+
+// ParseRevocationListPEM parses a PEM encoded revocation list and returns its
+// RevocationList representation. The signature of the revocation list is not
+// verified, use x509.RevocationList.CheckSignatureFrom for it.
+func ParseRevocationListPEM(data []byte) (*RevocationList, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("error decoding PEM: not a valid PEM encoded block")
+	}
+	if block.Type != "X509 CRL" {
+		return nil, errors.Errorf("error decoding PEM: unexpected type %q", block.Type)
+	}
+	return ParseRevocationList(block.Bytes)
+}
+
+// GetRevocationList returns the x509.RevocationList representation of the
+// revocation list. It returns an error if any of the extensions cannot be
+// encoded.
+func (r *RevocationList) GetRevocationList() (*x509.RevocationList, error) {
+	crl := &x509.RevocationList{
+		Number:             r.Number.Int,
+		ThisUpdate:         r.ThisUpdate,
+		NextUpdate:         r.NextUpdate,
+		SignatureAlgorithm: x509.SignatureAlgorithm(r.SignatureAlgorithm),
+	}
+
+	// Defined extensions.
+	if r.DeltaCRLIndicator.Int != nil {
+		if len(r.FreshestCRL) > 0 {
+			return nil, errors.New("error creating revocation list: freshestCRL cannot be used in a delta CRL")
+		}
+		ext, err := r.DeltaCRLIndicator.extension()
+		if err != nil {
+			return nil, err
+		}
+		crl.ExtraExtensions = append(crl.ExtraExtensions, ext)
+	}
+	if r.IssuingDistributionPoint != nil {
+		ext, err := r.IssuingDistributionPoint.extension()
+		if err != nil {
+			return nil, err
+		}
+		crl.ExtraExtensions = append(crl.ExtraExtensions, ext)
+	}
+	if len(r.FreshestCRL) > 0 {
+		ext, err := r.FreshestCRL.extension()
+		if err != nil {
+			return nil, err
+		}
+		crl.ExtraExtensions = append(crl.ExtraExtensions, ext)
+	}
+
+	// Custom Extensions.
+	crl.ExtraExtensions = appendExtensions(crl.ExtraExtensions, r.Extensions)
+
+	// Revoked certificates.
+	for _, rc := range r.RevokedCertificates {
+		entry := x509.RevocationListEntry{
+			SerialNumber:   rc.SerialNumber.Int,
+			RevocationTime: rc.RevocationTime,
+			ReasonCode:     int(rc.ReasonCode),
+		}
+		if !rc.InvalidityDate.IsZero() {
+			b, err := asn1.MarshalWithParams(rc.InvalidityDate.UTC(), "generalized")
+			if err != nil {
+				return nil, errors.Wrap(err, "error marshaling invalidity date")
+			}
+			entry.ExtraExtensions = append(entry.ExtraExtensions, pkix.Extension{
+				Id:    oidExtensionInvalidityDate,
+				Value: b,
+			})
+		}
+		entry.ExtraExtensions = appendExtensions(entry.ExtraExtensions, rc.Extensions)
+		crl.RevokedCertificateEntries = append(crl.RevokedCertificateEntries, entry)
+	}
+
+	return crl, nil
+}
+
+// appendExtensions appends the non empty extensions to the given list.
+func appendExtensions(dst []pkix.Extension, extensions []Extension) []pkix.Extension {
+	for _, e := range extensions {
+		if len(e.ID) > 0 {
+			dst = append(dst, pkix.Extension{
+				Id:       asn1.ObjectIdentifier(e.ID),
+				Critical: e.Critical,
+				Value:    e.Value,
+			})
+		}
+	}
+	return dst
+}
+
+// CreateRevocationList signs the given template using the issuer private key
+// and returns it.
+//
+// If the template does not set the this update, the current time is used. If
+// it does not set the next update, it will be the this update plus the
+// DefaultRevocationListDuration.
+func CreateRevocationList(template *x509.RevocationList, issuer *x509.Certificate, signer crypto.Signer) (*x509.RevocationList, error) {
+	// Complete revocation list.
+	if template.ThisUpdate.IsZero() {
+		template.ThisUpdate = time.Now()
+	}
+	if template.NextUpdate.IsZero() {
+		template.NextUpdate = template.ThisUpdate.Add(DefaultRevocationListDuration)
+	}
+
+	// Sign revocation list
+	asn1Data, err := x509.CreateRevocationList(rand.Reader, template, issuer, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating revocation list")
+	}
+	crl, err := x509.ParseRevocationList(asn1Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing revocation list")
+	}
+	return crl, nil
+}
+
+// CRLNumber is the JSON representation of a CRL number, used in the CRL
+// number and the delta CRL indicator extensions.
+type CRLNumber struct {
+	*big.Int
+}
+
+// MarshalJSON implements the json.Marshaler interface, and encodes a
+// CRLNumber using the big.Int marshaler.
+func (n CRLNumber) MarshalJSON() ([]byte, error) {
+	if n.Int == nil {
+		return []byte(`null`), nil
+	}
+	return n.Int.MarshalJSON()
+}
+
+// UnmarshalJSON implements the json.Unmarshal interface and unmarshals an
+// integer or a string into a CRL number. Strings support the same prefixes
+// than SerialNumber.
+func (n *CRLNumber) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*n = CRLNumber{}
+		return nil
+	}
+	var sn SerialNumber
+	if err := sn.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*n = CRLNumber(sn)
+	return nil
+}
+
+// extension returns the delta CRL indicator extension with the CRL number of
+// the base CRL.
+func (n CRLNumber) extension() (pkix.Extension, error) {
+	b, err := asn1.Marshal(n.Int)
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling delta CRL indicator")
+	}
+	return pkix.Extension{
+		Id:       oidExtensionDeltaCRLIndicator,
+		Critical: true,
+		Value:    b,
+	}, nil
+}
+
+func (n *CRLNumber) parseExtension(e pkix.Extension) bool {
+	var i *big.Int
+	if rest, err := asn1.Unmarshal(e.Value, &i); err != nil || len(rest) > 0 {
+		return false
+	}
+	n.Int = i
+	return true
+}
+
+// Names used for reason codes.
+const (
+	ReasonCodeUnspecified          = "unspecified"
+	ReasonCodeKeyCompromise        = "keyCompromise"
+	ReasonCodeCACompromise         = "cACompromise"
+	ReasonCodeAffiliationChanged   = "affiliationChanged"
+	ReasonCodeSuperseded           = "superseded"
+	ReasonCodeCessationOfOperation = "cessationOfOperation"
+	ReasonCodeCertificateHold      = "certificateHold"
+	ReasonCodeRemoveFromCRL        = "removeFromCRL"
+	ReasonCodePrivilegeWithdrawn   = "privilegeWithdrawn"
+	ReasonCodeAACompromise         = "aACompromise"
+)
+
+// reasonCodes maps the reason codes defined in RFC 5280, section 5.3.1, to
+// their names and their bit in the ReasonFlags used in distribution points.
+// The unspecified and removeFromCRL reasons don't have a flag.
+var reasonCodes = []struct {
+	code ReasonCode
+	name string
+	flag int
+}{
+	{0, ReasonCodeUnspecified, -1},
+	{1, ReasonCodeKeyCompromise, 1},
+	{2, ReasonCodeCACompromise, 2},
+	{3, ReasonCodeAffiliationChanged, 3},
+	{4, ReasonCodeSuperseded, 4},
+	{5, ReasonCodeCessationOfOperation, 5},
+	{6, ReasonCodeCertificateHold, 6},
+	{8, ReasonCodeRemoveFromCRL, -1},
+	{9, ReasonCodePrivilegeWithdrawn, 7},
+	{10, ReasonCodeAACompromise, 8},
+}
+
+// ReasonCode is the JSON representation of the reason a certificate was
+// revoked. It's encoded as the name of the reason, as defined in RFC 5280,
+// section 5.3.1. The unspecified reason is not encoded in the revocation list.
+type ReasonCode int
+
+// MarshalJSON implements the json.Marshaler interface and converts a reason
+// code into its name. Unknown reason codes are encoded as numbers.
+func (r ReasonCode) MarshalJSON() ([]byte, error) {
+	for _, rc := range reasonCodes {
+		if rc.code == r {
+			return json.Marshal(rc.name)
+		}
+	}
+	return json.Marshal(int(r))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface and converts a
+// reason name or number into a reason code.
+func (r *ReasonCode) UnmarshalJSON(data []byte) error {
+	if s, ok := maybeString(data); ok {
+		for _, rc := range reasonCodes {
+			if convertName(s) == convertName(rc.name) {
+				*r = rc.code
+				return nil
+			}
+		}
+		return errors.Errorf("unsupported reasonCode %s", s)
+	}
+
+	var i int
+	if err := json.Unmarshal(data, &i); err != nil {
+		return errors.Wrap(err, "error unmarshaling json")
+	}
+	*r = ReasonCode(i)
+	return nil
+}
+
+// reasonFlags encodes the reason codes as an ASN.1 ReasonFlags bit string.
+// Without reasons it returns the zero value, so the optional field is
+// omitted; an empty bit string would mean no reasons are covered.
+func reasonFlags(reasons []ReasonCode) (asn1.BitString, error) {
+	if len(reasons) == 0 {
+		return asn1.BitString{}, nil
+	}
+
+	var bits []int
+	var length int
+	for _, r := range reasons {
+		flag := -1
+		for _, rc := range reasonCodes {
+			if rc.code == r {
+				flag = rc.flag
+				break
+			}
+		}
+		if flag < 0 {
+			return asn1.BitString{}, errors.Errorf("reason code %d cannot be used in a distribution point", int(r))
+		}
+		bits = append(bits, flag)
+		length = max(length, flag+1)
+	}
+
+	bs := asn1.BitString{
+		Bytes:     make([]byte, (length+7)/8),
+		BitLength: length,
+	}
+	for _, b := range bits {
+		bs.Bytes[b/8] |= 0x80 >> (b % 8)
+	}
+	return bs, nil
+}
+
+// reasonCodesFromFlags decodes an ASN.1 ReasonFlags bit string.
+func reasonCodesFromFlags(bs asn1.BitString) []ReasonCode {
+	var reasons []ReasonCode
+	for _, rc := range reasonCodes {
+		if rc.flag >= 0 && bs.At(rc.flag) == 1 {
+			reasons = append(reasons, rc.code)
+		}
+	}
+	return reasons
+}
+
+// IssuingDistributionPoint is the JSON representation of the issuing
+// distribution point extension. The distribution point only supports URIs.
+type IssuingDistributionPoint struct {
+	FullName                   MultiString  `json:"fullName"`
+	OnlyContainsUserCerts      bool         `json:"onlyContainsUserCerts"`
+	OnlyContainsCACerts        bool         `json:"onlyContainsCACerts"`
+	OnlySomeReasons            []ReasonCode `json:"onlySomeReasons"`
+	IndirectCRL                bool         `json:"indirectCRL"`
+	OnlyContainsAttributeCerts bool         `json:"onlyContainsAttributeCerts"`
+}
+
+// distributionPointName mirrors the ASN.1 structure DistributionPointName in
+// RFC 5280, section 4.2.1.13.
+type distributionPointName struct {
+	FullName     []asn1.RawValue `asn1:"optional,tag:0"`
+	RelativeName asn1.RawValue   `asn1:"optional,tag:1"`
+}
+
+// asn1IssuingDistributionPoint mirrors the ASN.1 structure
+// IssuingDistributionPoint in RFC 5280, section 5.2.5.
+type asn1IssuingDistributionPoint struct {
+	DistributionPoint          distributionPointName `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool                  `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool                  `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString        `asn1:"optional,tag:3"`
+	IndirectCRL                bool                  `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool                  `asn1:"optional,tag:5"`
+}
+
+// asn1DistributionPoint mirrors the ASN.1 structure DistributionPoint in RFC
+// 5280, section 4.2.1.13.
+type asn1DistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+func newDistributionPointName(uris []string) distributionPointName {
+	var dp distributionPointName
+	for _, uri := range uris {
+		dp.FullName = append(dp.FullName, asn1.RawValue{
+			Tag:   nameTypeURI,
+			Class: asn1.ClassContextSpecific,
+			Bytes: []byte(uri),
+		})
+	}
+	return dp
+}
+
+// uris returns the URIs in the distribution point name. It returns false if
+// the name contains other types of names.
+func (dp distributionPointName) uris() ([]string, bool) {
+	if len(dp.RelativeName.FullBytes) > 0 {
+		return nil, false
+	}
+	var uris []string
+	for _, name := range dp.FullName {
+		if name.Class != asn1.ClassContextSpecific || name.Tag != nameTypeURI {
+			return nil, false
+		}
+		uris = append(uris, string(name.Bytes))
+	}
+	return uris, true
+}
+
+// extension returns the issuing distribution point extension.
+func (i IssuingDistributionPoint) extension() (pkix.Extension, error) {
+	var count int
+	for _, b := range []bool{i.OnlyContainsUserCerts, i.OnlyContainsCACerts, i.OnlyContainsAttributeCerts} {
+		if b {
+			count++
+		}
+	}
+	if count > 1 {
+		return pkix.Extension{}, errors.New("error creating revocation list: only one of onlyContainsUserCerts, onlyContainsCACerts or onlyContainsAttributeCerts can be set")
+	}
+
+	onlySomeReasons, err := reasonFlags(i.OnlySomeReasons)
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error creating revocation list")
+	}
+
+	b, err := asn1.Marshal(asn1IssuingDistributionPoint{
+		DistributionPoint:          newDistributionPointName(i.FullName),
+		OnlyContainsUserCerts:      i.OnlyContainsUserCerts,
+		OnlyContainsCACerts:        i.OnlyContainsCACerts,
+		OnlySomeReasons:            onlySomeReasons,
+		IndirectCRL:                i.IndirectCRL,
+		OnlyContainsAttributeCerts: i.OnlyContainsAttributeCerts,
+	})
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling issuing distribution point")
+	}
+	return pkix.Extension{
+		Id:       oidExtensionIssuingDistributionPoint,
+		Critical: true,
+		Value:    b,
+	}, nil
+}
+
+func parseIssuingDistributionPoint(e pkix.Extension) (*IssuingDistributionPoint, bool) {
+	var idp asn1IssuingDistributionPoint
+	if rest, err := asn1.Unmarshal(e.Value, &idp); err != nil || len(rest) > 0 {
+		return nil, false
+	}
+	uris, ok := idp.DistributionPoint.uris()
+	if !ok {
+		return nil, false
+	}
+	return &IssuingDistributionPoint{
+		FullName:                   uris,
+		OnlyContainsUserCerts:      idp.OnlyContainsUserCerts,
+		OnlyContainsCACerts:        idp.OnlyContainsCACerts,
+		OnlySomeReasons:            reasonCodesFromFlags(idp.OnlySomeReasons),
+		IndirectCRL:                idp.IndirectCRL,
+		OnlyContainsAttributeCerts: idp.OnlyContainsAttributeCerts,
+	}, true
+}
+
+// FreshestCRL contains the list of URIs that will be encoded in the freshest
+// CRL extension. The freshest CRL extension points to the delta CRLs of a
+// complete CRL.
+type FreshestCRL MultiString
+
+// UnmarshalJSON implements the json.Unmarshaler interface in FreshestCRL.
+func (f *FreshestCRL) UnmarshalJSON(data []byte) error {
+	ms, err := unmarshalMultiString(data)
+	if err != nil {
+		return err
+	}
+	*f = ms
+	return nil
+}
+
+// extension returns the freshest CRL extension, with one distribution point
+// per URI.
+func (f FreshestCRL) extension() (pkix.Extension, error) {
+	dps := make([]asn1DistributionPoint, len(f))
+	for i, uri := range f {
+		dps[i].DistributionPoint = newDistributionPointName([]string{uri})
+	}
+	b, err := asn1.Marshal(dps)
+	if err != nil {
+		return pkix.Extension{}, errors.Wrap(err, "error marshaling freshest CRL")
+	}
+	return pkix.Extension{
+		Id:    oidExtensionFreshestCRL,
+		Value: b,
+	}, nil
+}
+
+func parseFreshestCRL(e pkix.Extension) (FreshestCRL, bool) {
+	var dps []asn1DistributionPoint
+	if rest, err := asn1.Unmarshal(e.Value, &dps); err != nil || len(rest) > 0 {
+		return nil, false
+	}
+	var f FreshestCRL
+	for _, dp := range dps {
+		if dp.Reason.BitLength > 0 || len(dp.CRLIssuer.FullBytes) > 0 {
+			return nil, false
+		}
+		uris, ok := dp.DistributionPoint.uris()
+		if !ok {
+			return nil, false
+		}
+		f = append(f, uris...)
+	}
+	return f, true
+}
+
+func parseInvalidityDate(e pkix.Extension) (time.Time, bool) {
+	var t time.Time
+	if rest, err := asn1.UnmarshalWithParams(e.Value, &t, "generalized"); err != nil || len(rest) > 0 {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,408 @@
+package x509util
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createRevocationList(t *testing.T) (*RevocationList, []byte, *x509.Certificate) {
+	t.Helper()
+
+	iss, issPriv := createIssuerCertificate(t, "issuer")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	rl := &RevocationList{
+		Number:     CRLNumber{big.NewInt(10)},
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificates: []RevocationListEntry{
+			{SerialNumber: SerialNumber{big.NewInt(1)}, RevocationTime: now.Add(-time.Hour), ReasonCode: 1, InvalidityDate: now.Add(-2 * time.Hour)},
+			{SerialNumber: SerialNumber{big.NewInt(2)}, RevocationTime: now.Add(-time.Minute), Extensions: []Extension{
+				{ID: []int{1, 2, 3, 4}, Critical: false, Value: []byte{0x05, 0x00}},
+			}},
+		},
+		DeltaCRLIndicator: CRLNumber{big.NewInt(9)},
+		IssuingDistributionPoint: &IssuingDistributionPoint{
+			FullName:              []string{"http://ca.example.com/delta.crl"},
+			OnlyContainsUserCerts: true,
+			OnlySomeReasons:       []ReasonCode{1, 10},
+		},
+		Extensions: []Extension{
+			{ID: []int{1, 2, 3, 5}, Critical: false, Value: []byte{0x05, 0x00}},
+		},
+	}
+
+	template, err := rl.GetRevocationList()
+	require.NoError(t, err)
+	crl, err := CreateRevocationList(template, iss, issPriv)
+	require.NoError(t, err)
+
+	rl.Issuer = newIssuer(iss.Subject)
+	rl.AuthorityKeyID = iss.SubjectKeyId
+	rl.SignatureAlgorithm = SignatureAlgorithm(x509.PureEd25519)
+	return rl, crl.Raw, iss
+}
+
+func TestNewRevocationList(t *testing.T) {
+	data := NewTemplateData()
+	data.SetCRLNumber(new(big.Int).Lsh(big.NewInt(1), 100))
+	data.SetRevokedCertificates(RevocationListEntry{
+		SerialNumber:   SerialNumber{new(big.Int).Lsh(big.NewInt(1), 127)},
+		RevocationTime: time.Unix(1700000000, 0).UTC(),
+		ReasonCode:     4,
+	})
+
+	type args struct {
+		opts []Option
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *RevocationList
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{[]Option{WithTemplate(DefaultRevocationListTemplate, data)}}, &RevocationList{
+			Number: CRLNumber{new(big.Int).Lsh(big.NewInt(1), 100)},
+			RevokedCertificates: []RevocationListEntry{{
+				SerialNumber:   SerialNumber{new(big.Int).Lsh(big.NewInt(1), 127)},
+				RevocationTime: time.Unix(1700000000, 0).UTC(),
+				ReasonCode:     4,
+			}},
+		}, assert.NoError},
+		{"ok custom", args{[]Option{WithTemplate(`{
+			"number": "0x10",
+			"deltaCRLIndicator": 15,
+			"issuingDistributionPoint": {"fullName": "http://ca.example.com/delta.crl", "onlySomeReasons": ["keyCompromise", "ca_compromise"]},
+			"revokedCertificates": [{"serialNumber": "1234", "reasonCode": "superseded"}]
+		}`, NewTemplateData())}}, &RevocationList{
+			Number:            CRLNumber{big.NewInt(16)},
+			DeltaCRLIndicator: CRLNumber{big.NewInt(15)},
+			IssuingDistributionPoint: &IssuingDistributionPoint{
+				FullName:        []string{"http://ca.example.com/delta.crl"},
+				OnlySomeReasons: []ReasonCode{1, 2},
+			},
+			RevokedCertificates: []RevocationListEntry{{
+				SerialNumber: SerialNumber{big.NewInt(1234)},
+				ReasonCode:   4,
+			}},
+		}, assert.NoError},
+		{"ok no template", args{nil}, &RevocationList{}, assert.NoError},
+		{"fail template", args{[]Option{WithTemplate(`{{ fail "fatal error }}`, NewTemplateData())}}, nil, assert.Error},
+		{"fail unmarshal", args{[]Option{WithTemplate(`{"number": "foo"}`, NewTemplateData())}}, nil, assert.Error},
+		{"fail reasonCode", args{[]Option{WithTemplate(`{"revokedCertificates": [{"reasonCode": "foo"}]}`, NewTemplateData())}}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRevocationList(tt.args.opts...)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRevocationList_GetRevocationList(t *testing.T) {
+	tests := []struct {
+		name      string
+		rl        *RevocationList
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", &RevocationList{Number: CRLNumber{big.NewInt(1)}}, assert.NoError},
+		{"ok freshestCRL", &RevocationList{Number: CRLNumber{big.NewInt(1)}, FreshestCRL: []string{"http://ca.example.com/delta.crl"}}, assert.NoError},
+		{"fail freshestCRL in delta", &RevocationList{
+			Number:            CRLNumber{big.NewInt(2)},
+			DeltaCRLIndicator: CRLNumber{big.NewInt(1)},
+			FreshestCRL:       []string{"http://ca.example.com/delta.crl"},
+		}, assert.Error},
+		{"fail onlyContains", &RevocationList{
+			Number: CRLNumber{big.NewInt(1)},
+			IssuingDistributionPoint: &IssuingDistributionPoint{
+				OnlyContainsUserCerts: true,
+				OnlyContainsCACerts:   true,
+			},
+		}, assert.Error},
+		{"fail onlySomeReasons", &RevocationList{
+			Number: CRLNumber{big.NewInt(1)},
+			IssuingDistributionPoint: &IssuingDistributionPoint{
+				OnlySomeReasons: []ReasonCode{8},
+			},
+		}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.rl.GetRevocationList()
+			tt.assertion(t, err)
+		})
+	}
+}
+
+func TestRevocationList_GetRevocationList_extensions(t *testing.T) {
+	rl := &RevocationList{
+		Number:            CRLNumber{big.NewInt(2)},
+		DeltaCRLIndicator: CRLNumber{big.NewInt(1)},
+		IssuingDistributionPoint: &IssuingDistributionPoint{
+			FullName:            []string{"http://ca.example.com/delta.crl"},
+			OnlyContainsCACerts: true,
+			OnlySomeReasons:     []ReasonCode{1, 9},
+		},
+		RevokedCertificates: []RevocationListEntry{
+			{SerialNumber: SerialNumber{big.NewInt(1)}, InvalidityDate: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+	}
+
+	crl, err := rl.GetRevocationList()
+	require.NoError(t, err)
+	assert.Equal(t, []pkix.Extension{
+		{Id: []int{2, 5, 29, 27}, Critical: true, Value: []byte{0x02, 0x01, 0x01}},
+		{Id: []int{2, 5, 29, 28}, Critical: true, Value: []byte{
+			0x30, 0x2c,
+			0xa0, 0x23, 0xa0, 0x21, 0x86, 0x1f,
+			'h', 't', 't', 'p', ':', '/', '/', 'c', 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', '/', 'd', 'e', 'l', 't', 'a', '.', 'c', 'r', 'l',
+			0x82, 0x01, 0xff,
+			0x83, 0x02, 0x00, 0x41,
+		}},
+	}, crl.ExtraExtensions)
+	assert.Equal(t, []pkix.Extension{
+		{Id: []int{2, 5, 29, 24}, Value: append([]byte{0x18, 0x0f}, "20240102030405Z"...)},
+	}, crl.RevokedCertificateEntries[0].ExtraExtensions)
+
+	// Without reasons, onlySomeReasons must be omitted.
+	rl = &RevocationList{
+		Number: CRLNumber{big.NewInt(1)},
+		IssuingDistributionPoint: &IssuingDistributionPoint{
+			FullName: []string{"http://ca.example.com/delta.crl"},
+		},
+	}
+	crl, err = rl.GetRevocationList()
+	require.NoError(t, err)
+	assert.Equal(t, []pkix.Extension{
+		{Id: []int{2, 5, 29, 28}, Critical: true, Value: []byte{
+			0x30, 0x25,
+			0xa0, 0x23, 0xa0, 0x21, 0x86, 0x1f,
+			'h', 't', 't', 'p', ':', '/', '/', 'c', 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', '/', 'd', 'e', 'l', 't', 'a', '.', 'c', 'r', 'l',
+		}},
+	}, crl.ExtraExtensions)
+
+	rl = &RevocationList{
+		Number:      CRLNumber{big.NewInt(1)},
+		FreshestCRL: []string{"http://ca.example.com/delta.crl"},
+	}
+	crl, err = rl.GetRevocationList()
+	require.NoError(t, err)
+	assert.Equal(t, []pkix.Extension{
+		{Id: []int{2, 5, 29, 46}, Value: []byte{
+			0x30, 0x27, 0x30, 0x25,
+			0xa0, 0x23, 0xa0, 0x21, 0x86, 0x1f,
+			'h', 't', 't', 'p', ':', '/', '/', 'c', 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', '/', 'd', 'e', 'l', 't', 'a', '.', 'c', 'r', 'l',
+		}},
+	}, crl.ExtraExtensions)
+}
+
+func TestCreateRevocationList(t *testing.T) {
+	iss, issPriv := createIssuerCertificate(t, "issuer")
+	badSigner := createBadSigner(t)
+
+	type args struct {
+		template *x509.RevocationList
+		issuer   *x509.Certificate
+		signer   crypto.Signer
+	}
+	tests := []struct {
+		name      string
+		args      args
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{&x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}, iss, issPriv}, assert.NoError},
+		{"ok defaults", args{&x509.RevocationList{Number: big.NewInt(1)}, iss, issPriv}, assert.NoError},
+		{"fail number", args{&x509.RevocationList{}, iss, issPriv}, assert.Error},
+		{"fail sign", args{&x509.RevocationList{Number: big.NewInt(1)}, iss, badSigner}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateRevocationList(tt.args.template, tt.args.issuer, tt.args.signer)
+			tt.assertion(t, err)
+			if err == nil {
+				assert.NoError(t, got.CheckSignatureFrom(iss))
+				assert.False(t, got.ThisUpdate.IsZero())
+				assert.True(t, got.NextUpdate.After(got.ThisUpdate))
+			}
+		})
+	}
+}
+
+func TestNewRevocationListFromX509(t *testing.T) {
+	want, der, iss := createRevocationList(t)
+
+	crl, err := x509.ParseRevocationList(der)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(iss))
+	assert.Equal(t, want, NewRevocationListFromX509(crl))
+
+	// Unsupported extensions are kept raw.
+	relativeName, err := asn1.Marshal(asn1IssuingDistributionPoint{
+		DistributionPoint: distributionPointName{
+			RelativeName: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: []byte{}},
+		},
+	})
+	require.NoError(t, err)
+	badIDP := pkix.Extension{Id: []int{2, 5, 29, 28}, Critical: true, Value: relativeName}
+	badDelta := pkix.Extension{Id: []int{2, 5, 29, 27}, Critical: true, Value: []byte{0x05, 0x00}}
+	badFreshest := pkix.Extension{Id: []int{2, 5, 29, 46}, Value: []byte{0x30, 0x04, 0x30, 0x02, 0xa2, 0x00}}
+	badInvalidityDate := pkix.Extension{Id: []int{2, 5, 29, 24}, Value: []byte{0x05, 0x00}}
+	assert.Equal(t, &RevocationList{
+		Issuer:     Issuer{CommonName: "issuer"},
+		Extensions: []Extension{newExtension(badIDP), newExtension(badDelta), newExtension(badFreshest)},
+		RevokedCertificates: []RevocationListEntry{{
+			SerialNumber: SerialNumber{big.NewInt(1)},
+			Extensions:   []Extension{newExtension(badInvalidityDate)},
+		}},
+	}, NewRevocationListFromX509(&x509.RevocationList{
+		Issuer:     pkix.Name{CommonName: "issuer"},
+		Extensions: []pkix.Extension{badIDP, badDelta, badFreshest},
+		RevokedCertificateEntries: []x509.RevocationListEntry{{
+			SerialNumber: big.NewInt(1),
+			Extensions:   []pkix.Extension{badInvalidityDate},
+		}},
+	}))
+}
+
+func TestParseRevocationList(t *testing.T) {
+	want, der, _ := createRevocationList(t)
+
+	type args struct {
+		der []byte
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *RevocationList
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{der}, want, assert.NoError},
+		{"fail", args{[]byte("foo")}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRevocationList(tt.args.der)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRevocationListPEM(t *testing.T) {
+	want, der, _ := createRevocationList(t)
+
+	type args struct {
+		data []byte
+	}
+	tests := []struct {
+		name      string
+		args      args
+		want      *RevocationList
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", args{pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})}, want, assert.NoError},
+		{"fail pem", args{der}, nil, assert.Error},
+		{"fail type", args{pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}, nil, assert.Error},
+		{"fail parse", args{pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: []byte("foo")})}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRevocationListPEM(tt.args.data)
+			tt.assertion(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRevocationList_json(t *testing.T) {
+	rl, der, _ := createRevocationList(t)
+	rl.FreshestCRL = []string{"http://ca.example.com/delta.crl"}
+
+	b, err := json.Marshal(rl)
+	require.NoError(t, err)
+
+	var got RevocationList
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, rl, &got)
+
+	// Signing the unmarshaled revocation list produces the same extensions.
+	parsed, err := ParseRevocationList(der)
+	require.NoError(t, err)
+	parsed.FreshestCRL = rl.FreshestCRL
+	assert.Equal(t, &got, parsed)
+}
+
+func TestReasonCode_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		r    ReasonCode
+		want string
+	}{
+		{"unspecified", 0, `"unspecified"`},
+		{"keyCompromise", 1, `"keyCompromise"`},
+		{"removeFromCRL", 8, `"removeFromCRL"`},
+		{"aACompromise", 10, `"aACompromise"`},
+		{"unknown", 7, `7`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.r.MarshalJSON()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestReasonCode_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      ReasonCode
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", `"keyCompromise"`, 1, assert.NoError},
+		{"ok case", `"CA_COMPROMISE"`, 2, assert.NoError},
+		{"ok number", `9`, 9, assert.NoError},
+		{"fail name", `"foo"`, 0, assert.Error},
+		{"fail type", `{}`, 0, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r ReasonCode
+			tt.assertion(t, r.UnmarshalJSON([]byte(tt.data)))
+			assert.Equal(t, tt.want, r)
+		})
+	}
+}
+
+func TestCRLNumber_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      CRLNumber
+		assertion assert.ErrorAssertionFunc
+	}{
+		{"ok", `1234`, CRLNumber{big.NewInt(1234)}, assert.NoError},
+		{"ok string", `"0x10"`, CRLNumber{big.NewInt(16)}, assert.NoError},
+		{"ok null", `null`, CRLNumber{}, assert.NoError},
+		{"fail", `"foo"`, CRLNumber{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n CRLNumber
+			tt.assertion(t, n.UnmarshalJSON([]byte(tt.data)))
+			assert.Equal(t, tt.want, n)
+		})
+	}
+}
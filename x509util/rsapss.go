@@ -0,0 +1,245 @@
+package x509util
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+
+	"go.step.sm/crypto/rsapss"
+)
+
+// isRSAPSSRestricted returns true if the subject public key, the signer or
+// the parent certificate use a key restricted to RSASSA-PSS.
+func isRSAPSSRestricted(parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) bool {
+	if _, ok := pub.(*rsapss.PublicKey); ok {
+		return true
+	}
+	if _, ok := signer.Public().(*rsapss.PublicKey); ok {
+		return true
+	}
+	if parent != nil {
+		if _, ok := parent.PublicKey.(*rsapss.PublicKey); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// createRSAPSSCertificate creates a certificate where the subject key, the
+// issuer key, or both are restricted to RSASSA-PSS.
+//
+// The certificate is first created by crypto/x509 using the unrestricted RSA
+// keys. Then, if the subject key is restricted, or the signature parameters
+// are not the ones supported by crypto/x509, the TBSCertificate is modified
+// with the right SubjectPublicKeyInfo and signature AlgorithmIdentifier and
+// signed again.
+func createRSAPSSCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	key, params, isPSS := rsapss.Unwrap(signer)
+	if pk, ok := parent.PublicKey.(*rsapss.PublicKey); ok && !isPSS {
+		isPSS, params = true, pk.Parameters
+	}
+	if err := params.Validate(); err != nil {
+		return nil, errors.Wrap(err, "error creating certificate")
+	}
+
+	// Use the unrestricted keys with crypto/x509.
+	tmpl, issuer := *template, *parent
+	if pk, ok := issuer.PublicKey.(*rsapss.PublicKey); ok {
+		issuer.PublicKey = pk.PublicKey
+	}
+	rsaPub, isPSSPub := pub.(*rsapss.PublicKey)
+	if isPSSPub {
+		pub = rsaPub.PublicKey
+	}
+
+	var sigAlg []byte
+	var signerOpts crypto.SignerOpts
+	if isPSS {
+		switch {
+		case params == nil:
+			switch tmpl.SignatureAlgorithm {
+			case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+			default:
+				tmpl.SignatureAlgorithm = x509.SHA256WithRSAPSS
+			}
+		case params.SignatureAlgorithm() == x509.UnknownSignatureAlgorithm || params.SaltLength != params.Hash.Size():
+			// crypto/x509 only supports SHA-256, SHA-384 and SHA-512 with a
+			// salt of the size of the hash. The algorithm will be replaced.
+			tmpl.SignatureAlgorithm = x509.SHA256WithRSAPSS
+			ai, err := params.AlgorithmIdentifier()
+			if err != nil {
+				return nil, errors.Wrap(err, "error creating certificate")
+			}
+			if sigAlg, err = asn1.Marshal(ai); err != nil {
+				return nil, errors.Wrap(err, "error creating certificate")
+			}
+			signerOpts = &rsa.PSSOptions{
+				SaltLength: params.SaltLength,
+				Hash:       params.Hash,
+			}
+		default:
+			tmpl.SignatureAlgorithm = params.SignatureAlgorithm()
+		}
+	}
+
+	asn1Data, err := x509.CreateCertificate(rand.Reader, &tmpl, &issuer, pub, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate")
+	}
+
+	// Return the certificate if crypto/x509 was able to create it.
+	if !isPSSPub && sigAlg == nil {
+		cert, err := rsapss.ParseCertificate(asn1Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing certificate")
+		}
+		return cert, nil
+	}
+
+	cert, err := x509.ParseCertificate(asn1Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	}
+
+	var spki []byte
+	if isPSSPub {
+		if spki, err = rsapss.MarshalPKIXPublicKey(rsaPub); err != nil {
+			return nil, errors.Wrap(err, "error creating certificate")
+		}
+	}
+	if sigAlg == nil {
+		if sigAlg, err = readSignatureAlgorithm(asn1Data); err != nil {
+			return nil, err
+		}
+	}
+	if signerOpts == nil {
+		if signerOpts, err = signerOptsFromAlgorithm(cert.SignatureAlgorithm); err != nil {
+			return nil, err
+		}
+	}
+
+	tbs, err := replaceTBSCertificate(cert.RawTBSCertificate, sigAlg, spki)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the new TBSCertificate.
+	signed := tbs
+	if h := signerOpts.HashFunc(); h != 0 {
+		hash := h.New()
+		hash.Write(tbs)
+		signed = hash.Sum(nil)
+	}
+	signature, err := key.Sign(rand.Reader, signed, signerOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate")
+	}
+
+	// Verify the signature to protect against signers that return invalid
+	// signatures, as crypto/x509 does.
+	if pssOpts, ok := signerOpts.(*rsa.PSSOptions); ok {
+		err = rsa.VerifyPSS(key.Public().(*rsa.PublicKey), pssOpts.Hash, signed, signature, pssOpts)
+	} else {
+		err = (&x509.Certificate{PublicKey: key.Public()}).CheckSignature(cert.SignatureAlgorithm, tbs, signature)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate: signature returned by signer is invalid")
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(child *cryptobyte.Builder) {
+		child.AddBytes(tbs)
+		child.AddBytes(sigAlg)
+		child.AddASN1BitString(signature)
+	})
+	asn1Data, err = b.Bytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate")
+	}
+
+	cert, err = rsapss.ParseCertificate(asn1Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	}
+	return cert, nil
+}
+
+// signerOptsFromAlgorithm returns the options used to sign with the given
+// signature algorithm.
+func signerOptsFromAlgorithm(alg x509.SignatureAlgorithm) (crypto.SignerOpts, error) {
+	for _, m := range signatureAlgorithmMapping {
+		if m.value == alg && m.value != x509.UnknownSignatureAlgorithm {
+			switch alg {
+			case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+				return &rsa.PSSOptions{
+					SaltLength: rsa.PSSSaltLengthEqualsHash,
+					Hash:       m.hash,
+				}, nil
+			default:
+				return m.hash, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("error creating certificate: unsupported signature algorithm %s", alg)
+}
+
+// readSignatureAlgorithm returns the ASN.1 DER encoded signatureAlgorithm of
+// a certificate.
+func readSignatureAlgorithm(der []byte) ([]byte, error) {
+	var cert, tbs, sigAlg cryptobyte.String
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&cert, cryptobyte_asn1.SEQUENCE) ||
+		!cert.ReadASN1Element(&tbs, cryptobyte_asn1.SEQUENCE) ||
+		!cert.ReadASN1Element(&sigAlg, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("error parsing certificate: malformed certificate")
+	}
+	return sigAlg, nil
+}
+
+// replaceTBSCertificate replaces the signature AlgorithmIdentifier and the
+// SubjectPublicKeyInfo of the given ASN.1 DER encoded TBSCertificate. Nil
+// values are not replaced.
+func replaceTBSCertificate(tbs, sigAlg, spki []byte) ([]byte, error) {
+	var seq cryptobyte.String
+	input := cryptobyte.String(tbs)
+	if !input.ReadASN1(&seq, cryptobyte_asn1.SEQUENCE) || !input.Empty() {
+		return nil, errors.New("error parsing certificate: malformed tbs certificate")
+	}
+
+	copyElement := func(child *cryptobyte.Builder, replacement []byte) {
+		var elem cryptobyte.String
+		var tag cryptobyte_asn1.Tag
+		if !seq.ReadAnyASN1Element(&elem, &tag) {
+			child.SetError(errors.New("error parsing certificate: malformed tbs certificate"))
+			return
+		}
+		if replacement != nil {
+			child.AddBytes(replacement)
+		} else {
+			child.AddBytes(elem)
+		}
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(child *cryptobyte.Builder) {
+		// version
+		if seq.PeekASN1Tag(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+			copyElement(child, nil)
+		}
+		copyElement(child, nil)    // serialNumber
+		copyElement(child, sigAlg) // signature
+		copyElement(child, nil)    // issuer
+		copyElement(child, nil)    // validity
+		copyElement(child, nil)    // subject
+		copyElement(child, spki)   // subjectPublicKeyInfo
+		// issuerUniqueID, subjectUniqueID and extensions
+		child.AddBytes(seq)
+	})
+	return b.Bytes()
+}
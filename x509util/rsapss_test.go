@@ -0,0 +1,244 @@
+package x509util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.step.sm/crypto/pemutil"
+	"go.step.sm/crypto/rsapss"
+)
+
+func mustRSAPSSKey(t *testing.T, params *rsapss.Parameters) *rsapss.PrivateKey {
+	t.Helper()
+	key, err := rsapss.GenerateKey(rand.Reader, 2048, params)
+	require.NoError(t, err)
+	return key
+}
+
+// checkRSAPSSSignature verifies the RSASSA-PSS signature of cert using the
+// given issuer key.
+func checkRSAPSSSignature(t *testing.T, cert *x509.Certificate, issuer *rsapss.PublicKey) {
+	t.Helper()
+	h := crypto.SHA256
+	if issuer.Parameters != nil {
+		h = issuer.Parameters.Hash
+	}
+	hash := h.New()
+	hash.Write(cert.RawTBSCertificate)
+	assert.NoError(t, issuer.Verify(hash.Sum(nil), cert.Signature, h))
+}
+
+func TestCreateCertificate_rsapss(t *testing.T) {
+	now := time.Now()
+	rootKey := mustRSAPSSKey(t, rsapss.NewParameters(crypto.SHA256))
+	intKey := mustRSAPSSKey(t, &rsapss.Parameters{Hash: crypto.SHA384, MGFHash: crypto.SHA384, SaltLength: 20})
+	unrestrictedKey := mustRSAPSSKey(t, nil)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	newTemplate := func(cn string, isCA bool) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: cn},
+			NotBefore:             now,
+			NotAfter:              now.Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: isCA,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		}
+	}
+
+	root, err := CreateCertificate(newTemplate("Root", true), newTemplate("Root", true), rootKey.Public(), rootKey)
+	require.NoError(t, err)
+	assert.Equal(t, rootKey.Public(), root.PublicKey)
+	assert.Equal(t, x509.RSA, root.PublicKeyAlgorithm)
+	assert.Equal(t, x509.SHA256WithRSAPSS, root.SignatureAlgorithm)
+	checkRSAPSSSignature(t, root, rootKey.Public().(*rsapss.PublicKey))
+
+	intermediate, err := CreateCertificate(newTemplate("Intermediate", true), root, intKey.Public(), rootKey)
+	require.NoError(t, err)
+	assert.Equal(t, intKey.Public(), intermediate.PublicKey)
+	assert.Equal(t, root.SubjectKeyId, intermediate.AuthorityKeyId)
+	checkRSAPSSSignature(t, intermediate, rootKey.Public().(*rsapss.PublicKey))
+
+	type args struct {
+		template *x509.Certificate
+		parent   *x509.Certificate
+		pub      crypto.PublicKey
+		signer   crypto.Signer
+	}
+	tests := []struct {
+		name    string
+		args    args
+		issuer  *rsapss.PublicKey
+		wantErr bool
+	}{
+		{"ok rsa with custom salt", args{newTemplate("leaf", false), intermediate, rsaKey.Public(), intKey}, intKey.Public().(*rsapss.PublicKey), false},
+		{"ok ecdsa with custom salt", args{newTemplate("leaf", false), intermediate, ecKey.Public(), intKey}, intKey.Public().(*rsapss.PublicKey), false},
+		{"ok rsapss with custom salt", args{newTemplate("leaf", false), intermediate, unrestrictedKey.Public(), intKey}, intKey.Public().(*rsapss.PublicKey), false},
+		{"ok rsa signer with restricted parent", args{newTemplate("leaf", false), intermediate, ecKey.Public(), intKey.PrivateKey}, intKey.Public().(*rsapss.PublicKey), false},
+		{"ok rsa signer with rsapss subject", args{newTemplate("leaf", false), newTemplate("issuer", true), unrestrictedKey.Public(), rsaKey}, nil, false},
+		{"fail signer does not match parent", args{newTemplate("leaf", false), intermediate, ecKey.Public(), rootKey}, nil, true},
+		{"fail signer hash", args{newTemplate("leaf", false), intermediate, ecKey.Public(), &rsapss.Signer{Signer: intKey.PrivateKey, Parameters: &rsapss.Parameters{Hash: crypto.MD5}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateCertificate(tt.args.template, tt.args.parent, tt.args.pub, tt.args.signer)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.args.pub, got.PublicKey)
+			if tt.issuer != nil {
+				checkRSAPSSSignature(t, got, tt.issuer)
+			} else {
+				assert.NoError(t, (&x509.Certificate{PublicKey: rsaKey.Public()}).CheckSignature(got.SignatureAlgorithm, got.RawTBSCertificate, got.Signature))
+			}
+
+			// Make sure the certificate can be parsed again.
+			block, err := pemutil.Serialize(got)
+			require.NoError(t, err)
+			parsed, err := pemutil.ParseCertificate(pem.EncodeToMemory(block))
+			require.NoError(t, err)
+			assert.Equal(t, got.PublicKey, parsed.PublicKey)
+		})
+	}
+}
+
+func TestNewCertificate_rsapss(t *testing.T) {
+	cr, err := pemutil.ReadCertificateRequest("../pemutil/testdata/openssl.rsapss.csr")
+	require.NoError(t, err)
+	pub, ok := cr.PublicKey.(*rsapss.PublicKey)
+	require.True(t, ok)
+
+	cert, err := NewCertificate(cr)
+	require.NoError(t, err)
+	assert.Equal(t, pub, cert.PublicKey)
+	assert.Equal(t, "rsapss.smallstep.com", cert.Subject.CommonName)
+
+	// Modify the signature.
+	bad := *cr
+	bad.Raw = append([]byte{}, cr.Raw...)
+	bad.Raw[len(bad.Raw)-1] ^= 0xff
+	_, err = NewCertificate(&bad)
+	assert.Error(t, err)
+}
+
+func TestCreateCertificate_rsapssSerialNumber(t *testing.T) {
+	key := mustRSAPSSKey(t, nil)
+	template := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "test"},
+		SerialNumber: big.NewInt(1234),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := CreateCertificate(template, template, key.Public(), key)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1234), cert.SerialNumber)
+	assert.NotEmpty(t, cert.SubjectKeyId)
+	checkRSAPSSSignature(t, cert, key.Public().(*rsapss.PublicKey))
+}
+
+// pssSaltLength recovers the length of the salt used in an RSASSA-PSS
+// signature, following the EMSA-PSS-VERIFY steps in RFC 8017, section 9.1.2.
+func pssSaltLength(t *testing.T, pub *rsa.PublicKey, h crypto.Hash, sig []byte) int {
+	t.Helper()
+	emBits := pub.N.BitLen() - 1
+	emLen := (emBits + 7) / 8
+	m := new(big.Int).Exp(new(big.Int).SetBytes(sig), big.NewInt(int64(pub.E)), pub.N)
+	em := m.FillBytes(make([]byte, emLen))
+	require.Equal(t, byte(0xbc), em[emLen-1])
+
+	hLen := h.Size()
+	db := em[:emLen-hLen-1]
+	mHash := em[emLen-hLen-1 : emLen-1]
+	var mask []byte
+	for counter := uint32(0); len(mask) < len(db); counter++ {
+		hash := h.New()
+		hash.Write(mHash)
+		hash.Write([]byte{byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)})
+		mask = hash.Sum(mask)
+	}
+	for i := range db {
+		db[i] ^= mask[i]
+	}
+	db[0] &= 0xff >> (8*emLen - emBits)
+
+	for i, b := range db {
+		if b != 0 {
+			require.Equal(t, byte(0x01), b)
+			return len(db) - i - 1
+		}
+	}
+	t.Fatal("invalid RSASSA-PSS padding")
+	return 0
+}
+
+func TestCreateCertificate_rsapssSaltLength(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "test"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+
+	tests := []struct {
+		name    string
+		params  *rsapss.Parameters
+		wantErr bool
+	}{
+		{"ok default salt", rsapss.NewParameters(crypto.SHA256), false},
+		{"ok short salt", &rsapss.Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA256, SaltLength: 1}, false},
+		{"ok custom salt", &rsapss.Parameters{Hash: crypto.SHA384, MGFHash: crypto.SHA384, SaltLength: 20}, false},
+		{"ok long salt", &rsapss.Parameters{Hash: crypto.SHA512, MGFHash: crypto.SHA512, SaltLength: 128}, false},
+		{"fail zero salt", &rsapss.Parameters{Hash: crypto.SHA256, MGFHash: crypto.SHA256, SaltLength: 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &rsapss.Signer{Signer: rsaKey, Parameters: tt.params}
+			got, err := CreateCertificate(template, template, rsaKey.Public(), signer)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+
+			cert, err := rsapss.ParseCertificate(got.Raw)
+			require.NoError(t, err)
+			sigAlg, err := readSignatureAlgorithm(cert.Raw)
+			require.NoError(t, err)
+			var ai pkix.AlgorithmIdentifier
+			_, err = asn1.Unmarshal(sigAlg, &ai)
+			require.NoError(t, err)
+			params, err := rsapss.ParseParameters(ai.Parameters.FullBytes)
+			require.NoError(t, err)
+			assert.Equal(t, tt.params, params)
+
+			hash := params.Hash.New()
+			hash.Write(cert.RawTBSCertificate)
+			require.NoError(t, rsa.VerifyPSS(&rsaKey.PublicKey, params.Hash, hash.Sum(nil), cert.Signature, &rsa.PSSOptions{
+				SaltLength: params.SaltLength,
+				Hash:       params.Hash,
+			}))
+			assert.Equal(t, tt.params.SaltLength, pssSaltLength(t, &rsaKey.PublicKey, params.Hash, cert.Signature))
+		})
+	}
+}
@@ -2,21 +2,24 @@ package x509util
 
 import (
 	"crypto/x509"
+	"math/big"
 
 	"go.step.sm/crypto/internal/templates"
 )
 
 // Variables used to hold template data.
 const (
-	SubjectKey            = "Subject"
-	SANsKey               = "SANs"
-	TokenKey              = "Token"
-	InsecureKey           = "Insecure"
-	UserKey               = "User"
-	CertificateRequestKey = "CR"
-	AuthorizationCrtKey   = "AuthorizationCrt"
-	AuthorizationChainKey = "AuthorizationChain"
-	WebhooksKey           = "Webhooks"
+	SubjectKey             = "Subject"
+	SANsKey                = "SANs"
+	TokenKey               = "Token"
+	InsecureKey            = "Insecure"
+	UserKey                = "User"
+	CertificateRequestKey  = "CR"
+	AuthorizationCrtKey    = "AuthorizationCrt"
+	AuthorizationChainKey  = "AuthorizationChain"
+	WebhooksKey            = "Webhooks"
+	CRLNumberKey           = "CRLNumber"
+	RevokedCertificatesKey = "RevokedCertificates"
 )
 
 // TemplateError represents an error in a template produced by the fail
@@ -126,6 +129,17 @@ func (t TemplateData) SetCertificateRequest(cr *x509.CertificateRequest) {
 	t.SetInsecure(CertificateRequestKey, NewCertificateRequestFromX509(cr))
 }
 
+// SetCRLNumber sets the given CRL number in the template data.
+func (t TemplateData) SetCRLNumber(n *big.Int) {
+	t.Set(CRLNumberKey, n)
+}
+
+// SetRevokedCertificates sets the given revoked certificates in the template
+// data.
+func (t TemplateData) SetRevokedCertificates(entries ...RevocationListEntry) {
+	t.Set(RevokedCertificatesKey, entries)
+}
+
 // SetWebhook sets the given webhook response in the webhooks template data.
 func (t TemplateData) SetWebhook(webhookName string, data interface{}) {
 	if webhooksMap, ok := t[WebhooksKey].(map[string]interface{}); ok {
@@ -238,3 +252,10 @@ const DefaultAttestedLeafTemplate = `{
 {{- end }}
 	"extKeyUsage": ["clientAuth"]
 }`
+
+// DefaultRevocationListTemplate is the template used by default when creating
+// a new revocation list.
+const DefaultRevocationListTemplate = `{
+	"number": {{ toJson .CRLNumber }},
+	"revokedCertificates": {{ toJson .RevokedCertificates }}
+}`
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/net/idna"
 
 	"go.step.sm/crypto/fipsutil"
+	"go.step.sm/crypto/rsapss"
 )
 
 var emptyASN1Subject = []byte{0x30, 0}
@@ -117,6 +118,10 @@ type subjectPublicKeyInfo struct {
 // If FIPS 140-3 mode is enabled, instead of SHA-1, it will use the leftmost
 // 160-bits of the SHA-256 hash according to RFC 7093 section 2.
 func generateSubjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	// RSASSA-PSS keys share the subjectPublicKey encoding with RSA keys.
+	if k, ok := pub.(*rsapss.PublicKey); ok {
+		pub = k.PublicKey
+	}
 	b, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
 		return nil, errors.Wrap(err, "error marshaling public key")